@@ -0,0 +1,376 @@
+// Package acl implements Redis 6 style access control lists: users with
+// their passwords, the commands they may run and the keys they may access.
+//
+// The ACL is part of the replicated state, so it is changed only by the
+// state machine while applying a log entry. Passwords are kept as SHA-256
+// digests, and HashPasswords turns the clear text passwords of ACL SETUSER
+// into digests before the rules are written to the log.
+package acl
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+
+	"raft-redis-cluster/glob"
+)
+
+// DefaultUser is the user new connections are authenticated as.
+const DefaultUser = "default"
+
+// Categories are the command categories rules can refer to with @name.
+var Categories = []string{
+	"keyspace", "read", "write", "string", "hash", "set", "sortedset",
+	"bitmap", "hyperloglog", "stream", "pubsub", "admin", "dangerous",
+	"connection", "transaction", "scripting",
+}
+
+var ErrDefaultUser = errors.New("ERR The 'default' user cannot be removed")
+
+// User is an ACL user. A User held by an ACL is never modified, so it can be
+// used without holding the lock of the ACL.
+type User struct {
+	Name    string
+	Enabled bool
+	NoPass  bool
+	// Passwords are the hex SHA-256 digests of the passwords.
+	Passwords []string
+	// Commands are the command rules such as +@read or -flushall, in the
+	// order they were given. The last rule that matches a command decides.
+	Commands []string
+	// Keys are the glob-style patterns of the keys the user may access.
+	Keys []string
+}
+
+func defaultUser() *User {
+	return &User{
+		Name:     DefaultUser,
+		Enabled:  true,
+		NoPass:   true,
+		Commands: []string{"+@all"},
+		Keys:     []string{"*"},
+	}
+}
+
+func (u *User) clone() *User {
+	c := *u
+	c.Passwords = slices.Clone(u.Passwords)
+	c.Commands = slices.Clone(u.Commands)
+	c.Keys = slices.Clone(u.Keys)
+	return &c
+}
+
+// CheckPassword reports whether pass authenticates the user.
+func (u *User) CheckPassword(pass string) bool {
+	if !u.Enabled {
+		return false
+	}
+	if u.NoPass {
+		return true
+	}
+	d := []byte(digest(pass))
+	ok := false
+	for _, p := range u.Passwords {
+		if subtle.ConstantTimeCompare(d, []byte(p)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// CanRun reports whether the user may run the command cmd, or its
+// subcommand sub when sub is not empty. categories are the categories of
+// the command.
+func (u *User) CanRun(cmd, sub string, categories []string) bool {
+	cmd = strings.ToLower(cmd)
+	full := cmd + "|" + strings.ToLower(sub)
+
+	allowed := false
+	for _, r := range u.Commands {
+		var match bool
+		switch target := r[1:]; {
+		case target == "@all":
+			match = true
+		case strings.HasPrefix(target, "@"):
+			match = slices.Contains(categories, target[1:])
+		default:
+			match = target == cmd || (sub != "" && target == full)
+		}
+		if match {
+			allowed = r[0] == '+'
+		}
+	}
+	return allowed
+}
+
+// CanAccess reports whether the user may access key.
+func (u *User) CanAccess(key []byte) bool {
+	for _, p := range u.Keys {
+		if glob.Match([]byte(p), key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rules describes the user in the form of ACL LIST.
+func (u *User) Rules() string {
+	rules := []string{"user", u.Name}
+	if u.Enabled {
+		rules = append(rules, "on")
+	} else {
+		rules = append(rules, "off")
+	}
+	if u.NoPass {
+		rules = append(rules, "nopass")
+	}
+	for _, p := range u.Passwords {
+		rules = append(rules, "#"+p)
+	}
+	for _, k := range u.Keys {
+		rules = append(rules, "~"+k)
+	}
+	if len(u.Commands) == 0 {
+		rules = append(rules, "-@all")
+	}
+	rules = append(rules, u.Commands...)
+	return strings.Join(rules, " ")
+}
+
+func (u *User) setRules(rules []string) error {
+	for _, r := range rules {
+		if err := u.apply(r); err != nil {
+			return fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': %w", r, err)
+		}
+	}
+	return nil
+}
+
+var (
+	errSyntax   = errors.New("Syntax error")
+	errDigest   = errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+	errCategory = errors.New("Unknown command category")
+)
+
+func (u *User) apply(rule string) error {
+	if rule == "" {
+		return errSyntax
+	}
+
+	switch lower := strings.ToLower(rule); {
+	case lower == "on":
+		u.Enabled = true
+	case lower == "off":
+		u.Enabled = false
+	case lower == "nopass":
+		u.NoPass, u.Passwords = true, nil
+	case lower == "resetpass":
+		u.NoPass, u.Passwords = false, nil
+	case lower == "allkeys":
+		u.Keys = []string{"*"}
+	case lower == "resetkeys":
+		u.Keys = nil
+	case lower == "allcommands":
+		u.Commands = []string{"+@all"}
+	case lower == "nocommands":
+		u.Commands = nil
+	case lower == "reset":
+		*u = User{Name: u.Name}
+
+	case rule[0] == '>':
+		u.addPassword(digest(rule[1:]))
+	case rule[0] == '<':
+		u.removePassword(digest(rule[1:]))
+	case rule[0] == '#', rule[0] == '!':
+		d := rule[1:]
+		if _, err := hex.DecodeString(d); err != nil || len(d) != 64 || d != strings.ToLower(d) {
+			return errDigest
+		}
+		if rule[0] == '#' {
+			u.addPassword(d)
+		} else {
+			u.removePassword(d)
+		}
+
+	case rule[0] == '~':
+		if !slices.Contains(u.Keys, rule[1:]) {
+			u.Keys = append(u.Keys, rule[1:])
+		}
+
+	case rule[0] == '+', rule[0] == '-':
+		return u.addCommandRule(lower)
+
+	default:
+		return errSyntax
+	}
+	return nil
+}
+
+func (u *User) addPassword(d string) {
+	u.NoPass = false
+	if !slices.Contains(u.Passwords, d) {
+		u.Passwords = append(u.Passwords, d)
+	}
+}
+
+func (u *User) removePassword(d string) {
+	u.Passwords = slices.DeleteFunc(u.Passwords, func(p string) bool { return p == d })
+}
+
+// addCommandRule adds a +/- rule, dropping the earlier rules it overrides.
+func (u *User) addCommandRule(rule string) error {
+	target := rule[1:]
+	if target == "" {
+		return errSyntax
+	}
+	if cat, ok := strings.CutPrefix(target, "@"); ok && cat != "all" && !slices.Contains(Categories, cat) {
+		return errCategory
+	}
+
+	if target == "@all" {
+		u.Commands = nil
+		if rule[0] == '+' {
+			u.Commands = []string{rule}
+		}
+		return nil
+	}
+	u.Commands = slices.DeleteFunc(u.Commands, func(r string) bool { return r[1:] == target })
+	u.Commands = append(u.Commands, rule)
+	return nil
+}
+
+func digest(pass string) string {
+	sum := sha256.Sum256([]byte(pass))
+	return hex.EncodeToString(sum[:])
+}
+
+// HashPasswords returns rules with the clear text passwords of >password
+// and <password replaced by their digests.
+func HashPasswords(rules []string) []string {
+	hashed := make([]string, len(rules))
+	for i, r := range rules {
+		switch {
+		case strings.HasPrefix(r, ">"):
+			r = "#" + digest(r[1:])
+		case strings.HasPrefix(r, "<"):
+			r = "!" + digest(r[1:])
+		}
+		hashed[i] = r
+	}
+	return hashed
+}
+
+// Validate reports the first invalid rule of rules, without applying them.
+func Validate(rules []string) error {
+	return (&User{}).setRules(rules)
+}
+
+// ACL holds the users by name.
+type ACL struct {
+	mu    sync.RWMutex
+	users map[string]*User
+}
+
+// New returns an ACL with only the default user, which needs no password
+// and may run every command on every key.
+func New() *ACL {
+	a := &ACL{}
+	a.Reset()
+	return a
+}
+
+// Reset brings the ACL back to the state of New.
+func (a *ACL) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.users = map[string]*User{DefaultUser: defaultUser()}
+}
+
+// User returns the named user.
+func (a *ACL) User(name string) (*User, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	u, ok := a.users[name]
+	return u, ok
+}
+
+// Users returns all users ordered by name.
+func (a *ACL) Users() []*User {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	users := make([]*User, 0, len(a.users))
+	for _, u := range a.users {
+		users = append(users, u)
+	}
+	slices.SortFunc(users, func(x, y *User) int { return strings.Compare(x.Name, y.Name) })
+	return users
+}
+
+// SetUser applies rules to the named user, creating it if needed. A new
+// user starts disabled, without passwords, commands or keys. Nothing is
+// changed if a rule is invalid.
+func (a *ACL) SetUser(name string, rules []string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	u, ok := a.users[name]
+	if ok {
+		u = u.clone()
+	} else {
+		u = &User{Name: name}
+	}
+	if err := u.setRules(rules); err != nil {
+		return err
+	}
+	a.users[name] = u
+	return nil
+}
+
+// DelUser deletes the named users and returns how many existed.
+func (a *ACL) DelUser(names []string) (int, error) {
+	if slices.Contains(names, DefaultUser) {
+		return 0, ErrDefaultUser
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	n := 0
+	for _, name := range names {
+		if _, ok := a.users[name]; ok {
+			delete(a.users, name)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Encode writes the users to w.
+func (a *ACL) Encode(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(a.Users())
+}
+
+// Decode replaces the users with the ones read from r.
+func (a *ACL) Decode(r io.Reader) error {
+	var users []*User
+	if err := gob.NewDecoder(r).Decode(&users); err != nil {
+		return err
+	}
+
+	m := make(map[string]*User, len(users))
+	for _, u := range users {
+		m[u.Name] = u
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.users = m
+	return nil
+}
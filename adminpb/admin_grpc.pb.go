@@ -0,0 +1,335 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.28.2
+// source: admin.proto
+
+package adminpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ClusterAdmin_AddServer_FullMethodName    = "/admin.ClusterAdmin/AddServer"
+	ClusterAdmin_RemoveServer_FullMethodName = "/admin.ClusterAdmin/RemoveServer"
+	ClusterAdmin_Snapshot_FullMethodName     = "/admin.ClusterAdmin/Snapshot"
+	ClusterAdmin_Status_FullMethodName       = "/admin.ClusterAdmin/Status"
+	ClusterAdmin_GetConfig_FullMethodName    = "/admin.ClusterAdmin/GetConfig"
+	ClusterAdmin_SetConfig_FullMethodName    = "/admin.ClusterAdmin/SetConfig"
+)
+
+// ClusterAdminClient is the client API for ClusterAdmin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// ClusterAdmin manages the cluster through the node it is sent to. The
+// changes of the shards the node doesn't lead are sent to their leader.
+type ClusterAdminClient interface {
+	// AddServer adds a server to the shards, as RAFT.ADD does.
+	AddServer(ctx context.Context, in *AddServerRequest, opts ...grpc.CallOption) (*Ack, error)
+	// RemoveServer removes a server from the shards, as RAFT.REMOVE does.
+	RemoveServer(ctx context.Context, in *RemoveServerRequest, opts ...grpc.CallOption) (*Ack, error)
+	// Snapshot makes the node take a snapshot of the shards and compact
+	// their log, as RAFT.SNAPSHOT does.
+	Snapshot(ctx context.Context, in *SnapshotRequest, opts ...grpc.CallOption) (*Ack, error)
+	// Status returns the state of every shard on the node.
+	Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResponse, error)
+	// GetConfig returns the configuration parameters of the node, as CONFIG
+	// GET does.
+	GetConfig(ctx context.Context, in *GetConfigRequest, opts ...grpc.CallOption) (*GetConfigResponse, error)
+	// SetConfig changes a configuration parameter of the node, as CONFIG SET
+	// does.
+	SetConfig(ctx context.Context, in *SetConfigRequest, opts ...grpc.CallOption) (*Ack, error)
+}
+
+type clusterAdminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewClusterAdminClient(cc grpc.ClientConnInterface) ClusterAdminClient {
+	return &clusterAdminClient{cc}
+}
+
+func (c *clusterAdminClient) AddServer(ctx context.Context, in *AddServerRequest, opts ...grpc.CallOption) (*Ack, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Ack)
+	err := c.cc.Invoke(ctx, ClusterAdmin_AddServer_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clusterAdminClient) RemoveServer(ctx context.Context, in *RemoveServerRequest, opts ...grpc.CallOption) (*Ack, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Ack)
+	err := c.cc.Invoke(ctx, ClusterAdmin_RemoveServer_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clusterAdminClient) Snapshot(ctx context.Context, in *SnapshotRequest, opts ...grpc.CallOption) (*Ack, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Ack)
+	err := c.cc.Invoke(ctx, ClusterAdmin_Snapshot_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clusterAdminClient) Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StatusResponse)
+	err := c.cc.Invoke(ctx, ClusterAdmin_Status_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clusterAdminClient) GetConfig(ctx context.Context, in *GetConfigRequest, opts ...grpc.CallOption) (*GetConfigResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetConfigResponse)
+	err := c.cc.Invoke(ctx, ClusterAdmin_GetConfig_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clusterAdminClient) SetConfig(ctx context.Context, in *SetConfigRequest, opts ...grpc.CallOption) (*Ack, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Ack)
+	err := c.cc.Invoke(ctx, ClusterAdmin_SetConfig_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ClusterAdminServer is the server API for ClusterAdmin service.
+// All implementations must embed UnimplementedClusterAdminServer
+// for forward compatibility.
+//
+// ClusterAdmin manages the cluster through the node it is sent to. The
+// changes of the shards the node doesn't lead are sent to their leader.
+type ClusterAdminServer interface {
+	// AddServer adds a server to the shards, as RAFT.ADD does.
+	AddServer(context.Context, *AddServerRequest) (*Ack, error)
+	// RemoveServer removes a server from the shards, as RAFT.REMOVE does.
+	RemoveServer(context.Context, *RemoveServerRequest) (*Ack, error)
+	// Snapshot makes the node take a snapshot of the shards and compact
+	// their log, as RAFT.SNAPSHOT does.
+	Snapshot(context.Context, *SnapshotRequest) (*Ack, error)
+	// Status returns the state of every shard on the node.
+	Status(context.Context, *StatusRequest) (*StatusResponse, error)
+	// GetConfig returns the configuration parameters of the node, as CONFIG
+	// GET does.
+	GetConfig(context.Context, *GetConfigRequest) (*GetConfigResponse, error)
+	// SetConfig changes a configuration parameter of the node, as CONFIG SET
+	// does.
+	SetConfig(context.Context, *SetConfigRequest) (*Ack, error)
+	mustEmbedUnimplementedClusterAdminServer()
+}
+
+// UnimplementedClusterAdminServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedClusterAdminServer struct{}
+
+func (UnimplementedClusterAdminServer) AddServer(context.Context, *AddServerRequest) (*Ack, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddServer not implemented")
+}
+func (UnimplementedClusterAdminServer) RemoveServer(context.Context, *RemoveServerRequest) (*Ack, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveServer not implemented")
+}
+func (UnimplementedClusterAdminServer) Snapshot(context.Context, *SnapshotRequest) (*Ack, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Snapshot not implemented")
+}
+func (UnimplementedClusterAdminServer) Status(context.Context, *StatusRequest) (*StatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Status not implemented")
+}
+func (UnimplementedClusterAdminServer) GetConfig(context.Context, *GetConfigRequest) (*GetConfigResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetConfig not implemented")
+}
+func (UnimplementedClusterAdminServer) SetConfig(context.Context, *SetConfigRequest) (*Ack, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetConfig not implemented")
+}
+func (UnimplementedClusterAdminServer) mustEmbedUnimplementedClusterAdminServer() {}
+func (UnimplementedClusterAdminServer) testEmbeddedByValue()                      {}
+
+// UnsafeClusterAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ClusterAdminServer will
+// result in compilation errors.
+type UnsafeClusterAdminServer interface {
+	mustEmbedUnimplementedClusterAdminServer()
+}
+
+func RegisterClusterAdminServer(s grpc.ServiceRegistrar, srv ClusterAdminServer) {
+	// If the following call pancis, it indicates UnimplementedClusterAdminServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ClusterAdmin_ServiceDesc, srv)
+}
+
+func _ClusterAdmin_AddServer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddServerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterAdminServer).AddServer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ClusterAdmin_AddServer_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterAdminServer).AddServer(ctx, req.(*AddServerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClusterAdmin_RemoveServer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveServerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterAdminServer).RemoveServer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ClusterAdmin_RemoveServer_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterAdminServer).RemoveServer(ctx, req.(*RemoveServerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClusterAdmin_Snapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SnapshotRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterAdminServer).Snapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ClusterAdmin_Snapshot_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterAdminServer).Snapshot(ctx, req.(*SnapshotRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClusterAdmin_Status_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterAdminServer).Status(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ClusterAdmin_Status_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterAdminServer).Status(ctx, req.(*StatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClusterAdmin_GetConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterAdminServer).GetConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ClusterAdmin_GetConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterAdminServer).GetConfig(ctx, req.(*GetConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClusterAdmin_SetConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterAdminServer).SetConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ClusterAdmin_SetConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterAdminServer).SetConfig(ctx, req.(*SetConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ClusterAdmin_ServiceDesc is the grpc.ServiceDesc for ClusterAdmin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ClusterAdmin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "admin.ClusterAdmin",
+	HandlerType: (*ClusterAdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "AddServer",
+			Handler:    _ClusterAdmin_AddServer_Handler,
+		},
+		{
+			MethodName: "RemoveServer",
+			Handler:    _ClusterAdmin_RemoveServer_Handler,
+		},
+		{
+			MethodName: "Snapshot",
+			Handler:    _ClusterAdmin_Snapshot_Handler,
+		},
+		{
+			MethodName: "Status",
+			Handler:    _ClusterAdmin_Status_Handler,
+		},
+		{
+			MethodName: "GetConfig",
+			Handler:    _ClusterAdmin_GetConfig_Handler,
+		},
+		{
+			MethodName: "SetConfig",
+			Handler:    _ClusterAdmin_SetConfig_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "admin.proto",
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"raft-redis-cluster/cluster"
+	"raft-redis-cluster/kvs"
+
+	hraft "github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+)
+
+// backupManifest は、バックアップのアーカイブの先頭に置く情報
+type backupManifest struct {
+	Version  int       `json:"version"`
+	Created  time.Time `json:"created"`
+	ServerID string    `json:"server_id"`
+	Shards   int       `json:"shards"`
+}
+
+const (
+	backupVersion = 1
+	manifestName  = "manifest.json"
+)
+
+// runSubcommand は、最初の引数が backup、restore、kvscli、bench、linearize か raft-debug の場合にそのサブコマンドを実行して終了する
+func runSubcommand() {
+	if len(os.Args) < 2 {
+		return
+	}
+	var err error
+	switch os.Args[1] {
+	case "backup":
+		err = backupCmd(os.Args[2:])
+	case "restore":
+		err = restoreCmd(os.Args[2:])
+	case "kvscli":
+		err = kvscliCmd(os.Args[2:])
+	case "bench":
+		err = benchCmd(os.Args[2:])
+	case "linearize":
+		err = linearizeCmd(os.Args[2:])
+	case "raft-debug":
+		err = raftDebugCmd(os.Args[2:])
+	default:
+		return
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	os.Exit(0)
+}
+
+// backupCmd は、各シャードの最新のスナップショットとそのメタデータ (インデックス、ターム、構成) を
+// tar.gz のアーカイブに書き出す
+// スナップショットのファイルを読むだけなので、ノードの実行中でも取れる
+// 最新の書き込みを含めるには、先に BGSAVE か RAFT.SNAPSHOT でスナップショットを取っておく
+// --encryption_key_file で暗号化しているノードのスナップショットは、暗号化されたまま書き出す
+func backupCmd(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	dataDir := fs.String("data_dir", "", "Raft data dir of the node to back up")
+	serverID := fs.String("server_id", "", "Node id of the node, recorded in the archive")
+	shards := fs.Int("shards", 1, "Number of shards of the node")
+	out := fs.String("out", "", "Archive file to write")
+	fs.Parse(args)
+	if *dataDir == "" || *out == "" {
+		return errors.New("flags --data_dir and --out are required")
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+
+	m := backupManifest{Version: backupVersion, Created: time.Now().UTC(), ServerID: *serverID, Shards: *shards}
+	if err := writeTarJSON(tw, manifestName, m); err != nil {
+		return err
+	}
+	for i := 0; i < *shards; i++ {
+		if err := backupShard(tw, i, kvs.ShardDir(*dataDir, i)); err != nil {
+			return fmt.Errorf("shard %d: %w", i, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func backupShard(tw *tar.Writer, i int, dir string) error {
+	fss, err := hraft.NewFileSnapshotStore(dir, kvs.SnapshotRetainCount, io.Discard)
+	if err != nil {
+		return err
+	}
+	metas, err := fss.List()
+	if err != nil {
+		return err
+	}
+	if len(metas) == 0 {
+		return errors.New("no snapshot, take one with BGSAVE or RAFT.SNAPSHOT first")
+	}
+	meta, rc, err := fss.Open(metas[0].ID)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	name := fmt.Sprintf("shard%d/", i)
+	if err := writeTarJSON(tw, name+"meta.json", meta); err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: name + "state.bin", Mode: 0o600, Size: meta.Size, ModTime: time.Now()}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, rc)
+	return err
+}
+
+func writeTarJSON(tw *tar.Writer, name string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(b)), ModTime: time.Now()}); err != nil {
+		return err
+	}
+	_, err = tw.Write(b)
+	return err
+}
+
+// restoreCmd は、backup のアーカイブから新しいクラスタの最初のノードのデータを作る
+// 各シャードのスナップショットを、このノードだけを投票者とする構成で書き込む
+// ノードを起動するとスナップショットから復元して単独でリーダーになるので、
+// 他のノードは --join で起動して RAFT.ADD か CLUSTER MEET で加える
+// 暗号化されたスナップショットを復元したノードは、その鍵を持つ --encryption_key_file で起動する
+func restoreCmd(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	in := fs.String("in", "", "Archive file written by backup")
+	dataDir := fs.String("data_dir", "", "Raft data dir of the new node; must not hold Raft data yet")
+	serverID := fs.String("server_id", "", "Node id of the new node")
+	raftAddr := fs.String("address", "localhost:50051", "TCP host+port of the new node, as given to --address")
+	fs.Parse(args)
+	if *in == "" || *dataDir == "" || *serverID == "" {
+		return errors.New("flags --in, --data_dir and --server_id are required")
+	}
+
+	f, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gr)
+
+	var m backupManifest
+	if err := readTarJSON(tr, manifestName, &m); err != nil {
+		return err
+	}
+	if m.Version != backupVersion {
+		return fmt.Errorf("unsupported backup version %d", m.Version)
+	}
+	for i := 0; i < m.Shards; i++ {
+		addr, err := cluster.ShardAddr(*raftAddr, i)
+		if err != nil {
+			return err
+		}
+		if err := restoreShard(tr, i, kvs.ShardDir(*dataDir, i), hraft.ServerID(*serverID), hraft.ServerAddress(addr)); err != nil {
+			return fmt.Errorf("shard %d: %w", i, err)
+		}
+	}
+	fmt.Printf("restored %d shards, start the node with --data_dir %s --server_id %s --address %s --shards %d\n",
+		m.Shards, *dataDir, *serverID, *raftAddr, m.Shards)
+	return nil
+}
+
+func restoreShard(tr *tar.Reader, i int, dir string, id hraft.ServerID, addr hraft.ServerAddress) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for _, name := range []string{"logs.dat", "stable.dat"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return fmt.Errorf("%s already holds Raft data", dir)
+		}
+	}
+
+	name := fmt.Sprintf("shard%d/", i)
+	var meta hraft.SnapshotMeta
+	if err := readTarJSON(tr, name+"meta.json", &meta); err != nil {
+		return err
+	}
+	hdr, err := tr.Next()
+	if err != nil {
+		return err
+	}
+	if hdr.Name != name+"state.bin" {
+		return fmt.Errorf("unexpected %s in the archive, want %sstate.bin", hdr.Name, name)
+	}
+
+	fss, err := hraft.NewFileSnapshotStore(dir, kvs.SnapshotRetainCount, io.Discard)
+	if err != nil {
+		return err
+	}
+	cfg := hraft.Configuration{Servers: []hraft.Server{{Suffrage: hraft.Voter, ID: id, Address: addr}}}
+	_, trans := hraft.NewInmemTransport(addr)
+	sink, err := fss.Create(meta.Version, meta.Index, meta.Term, cfg, meta.Index, trans)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(sink, tr); err != nil {
+		sink.Cancel()
+		return err
+	}
+	if err := sink.Close(); err != nil {
+		return err
+	}
+
+	// スナップショットより古いタームで選挙をしないよう、タームを引き継ぐ
+	sdb, err := raftboltdb.NewBoltStore(filepath.Join(dir, "stable.dat"))
+	if err != nil {
+		return err
+	}
+	defer sdb.Close()
+	return sdb.SetUint64([]byte("CurrentTerm"), meta.Term)
+}
+
+func readTarJSON(tr *tar.Reader, name string, v any) error {
+	hdr, err := tr.Next()
+	if err != nil {
+		return err
+	}
+	if hdr.Name != name {
+		return fmt.Errorf("unexpected %s in the archive, want %s", hdr.Name, name)
+	}
+	return json.NewDecoder(tr).Decode(v)
+}
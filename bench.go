@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"raft-redis-cluster/client"
+)
+
+// benchOps は、bench が送れるコマンド
+var benchOps = []string{"GET", "SET", "DEL"}
+
+// benchResult は、1 つのコマンドの種類の結果
+type benchResult struct {
+	latencies []time.Duration
+	errors    int
+}
+
+// benchCmd は、redis-benchmark のように GET、SET、DEL を混ぜて並行に送り、
+// スループットとレイテンシのパーセンタイルを表示する
+// パイプラインでまとめて送ったコマンドのレイテンシは、その往復にかかった時間とする
+func benchCmd(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	clientOptions := clientFlags(fs)
+	clients := fs.Int("clients", 50, "Number of concurrent clients")
+	requests := fs.Int("requests", 100000, "Total number of commands")
+	pipeline := fs.Int("pipeline", 1, "Commands each client sends in one round trip")
+	mix := fs.String("mix", "get=80,set=20", "Weights of the commands sent, as comma-separated command=weight among get, set and del")
+	keyspace := fs.Int("keyspace", 10000, "Number of distinct keys, picked at random")
+	valueSize := fs.Int("value_size", 3, "Bytes of the values of SET")
+	fs.Parse(args)
+	if *clients < 1 || *requests < 1 || *pipeline < 1 || *keyspace < 1 {
+		return errors.New("flags --clients, --requests, --pipeline and --keyspace must be positive")
+	}
+	if *valueSize < 0 {
+		return errors.New("flag --value_size must not be negative")
+	}
+	weights, err := parseMix(*mix)
+	if err != nil {
+		return err
+	}
+
+	opts, err := clientOptions()
+	if err != nil {
+		return err
+	}
+	opts.PoolSize = *clients
+	c, err := client.New(opts)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	value := strings.Repeat("x", *valueSize)
+	results := make([][]benchResult, *clients)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := range *clients {
+		// 端数は先頭のクライアントに割り振る
+		n := *requests / *clients
+		if w < *requests%*clients {
+			n++
+		}
+		results[w] = make([]benchResult, len(benchOps))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			benchClient(c, n, *pipeline, weights, *keyspace, value, opts.Timeout, results[w])
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	merged := make([]benchResult, len(benchOps))
+	for _, rs := range results {
+		for i, r := range rs {
+			merged[i].latencies = append(merged[i].latencies, r.latencies...)
+			merged[i].errors += r.errors
+		}
+	}
+	printBench(os.Stdout, merged, *requests, elapsed)
+	return nil
+}
+
+// parseMix は、command=weight をカンマで区切った --mix を benchOps の順の重みにする
+func parseMix(mix string) ([]int, error) {
+	weights := make([]int, len(benchOps))
+	total := 0
+	for _, kv := range strings.Split(mix, ",") {
+		op, w, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		i := slices.Index(benchOps, strings.ToUpper(op))
+		n, err := strconv.Atoi(w)
+		if !ok || i < 0 || err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid --mix entry %q", kv)
+		}
+		weights[i] = n
+		total += n
+	}
+	if total == 0 {
+		return nil, errors.New("--mix has no positive weight")
+	}
+	return weights, nil
+}
+
+// benchClient は、1 つのクライアントとして n 個のコマンドを pipeline 個ずつ送り、
+// コマンドの種類ごとのレイテンシとエラーの数を results に記録する
+func benchClient(c *client.Client, n, pipeline int, weights []int, keyspace int, value string, timeout time.Duration, results []benchResult) {
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	cmds := make([][]string, 0, pipeline)
+	ops := make([]int, 0, pipeline)
+	for n > 0 {
+		cmds, ops = cmds[:0], ops[:0]
+		for range min(n, pipeline) {
+			op := 0
+			for x := rand.IntN(total); x >= weights[op]; op++ {
+				x -= weights[op]
+			}
+			key := "key:" + strconv.Itoa(rand.IntN(keyspace))
+			cmd := []string{benchOps[op], key}
+			if benchOps[op] == "SET" {
+				cmd = append(cmd, value)
+			}
+			cmds = append(cmds, cmd)
+			ops = append(ops, op)
+		}
+		n -= len(cmds)
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		start := time.Now()
+		replies, err := c.Pipeline(ctx, cmds...)
+		d := time.Since(start)
+		cancel()
+		for i, op := range ops {
+			if err != nil {
+				results[op].errors++
+				continue
+			}
+			if _, ok := replies[i].(client.Error); ok {
+				results[op].errors++
+				continue
+			}
+			results[op].latencies = append(results[op].latencies, d)
+		}
+	}
+}
+
+// printBench は、全体のスループットと、コマンドの種類ごとのレイテンシのパーセンタイルを表示する
+func printBench(out io.Writer, results []benchResult, requests int, elapsed time.Duration) {
+	errs := 0
+	for _, r := range results {
+		errs += r.errors
+	}
+	fmt.Fprintf(out, "%d requests completed in %.2f seconds, %d failed\n", requests, elapsed.Seconds(), errs)
+	fmt.Fprintf(out, "throughput: %.2f requests per second\n\n", float64(requests-errs)/elapsed.Seconds())
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "COMMAND\tCOUNT\tERRORS\tP50\tP90\tP99\tP99.9\tMAX\t")
+	for i, r := range results {
+		if len(r.latencies) == 0 && r.errors == 0 {
+			continue
+		}
+		slices.Sort(r.latencies)
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t\n", benchOps[i], len(r.latencies)+r.errors, r.errors,
+			percentile(r.latencies, 50), percentile(r.latencies, 90), percentile(r.latencies, 99),
+			percentile(r.latencies, 99.9), percentile(r.latencies, 100))
+	}
+	w.Flush()
+}
+
+// percentile は、昇順に並んだ latencies の p パーセンタイルをミリ秒で表した文字列を返す
+func percentile(latencies []time.Duration, p float64) string {
+	if len(latencies) == 0 {
+		return "-"
+	}
+	i := int(float64(len(latencies))*p/100+0.5) - 1
+	i = max(0, min(i, len(latencies)-1))
+	return fmt.Sprintf("%.3fms", float64(latencies[i].Microseconds())/1000)
+}
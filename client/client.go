@@ -0,0 +1,511 @@
+// Package client is a Go client of the cluster. It sends each command to
+// the leader of the shard of its key, follows MOVED and ASK redirects,
+// retries the commands that failed while a shard was electing a leader, and
+// keeps a pool of connections to each node.
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	mrand "math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	hraft "github.com/hashicorp/raft"
+
+	"raft-redis-cluster/cluster"
+)
+
+var (
+	// ErrClosed is returned by the commands sent after Close.
+	ErrClosed = errors.New("client: closed")
+	// ErrNotFound is returned by Get for a key that doesn't exist.
+	ErrNotFound = errors.New("client: key not found")
+
+	errReply = errors.New("client: unexpected reply")
+)
+
+// maxRetryBackoff is the longest a retry waits.
+const maxRetryBackoff = 2 * time.Second
+
+// Options configure a Client.
+type Options struct {
+	// Addrs are the Redis addresses of some nodes of the cluster. The other
+	// nodes are found with CLUSTER SLOTS.
+	Addrs []string
+	// Username and Password authenticate the connections with AUTH, unless
+	// Password is empty.
+	Username string
+	Password string
+	// TLS, when set, makes the connections use TLS.
+	TLS *tls.Config
+	// PoolSize is how many idle connections are kept to each node, 10 when
+	// zero.
+	PoolSize int
+	// DialTimeout bounds connecting to a node, 5 seconds when zero.
+	DialTimeout time.Duration
+	// Timeout bounds a round trip when the context has no deadline, 5
+	// seconds when zero.
+	Timeout time.Duration
+	// MaxRedirects is how many MOVED and ASK redirects a command follows, 16
+	// when zero.
+	MaxRedirects int
+	// MaxRetries is how many times a command that failed while a shard had
+	// no leader is retried, 3 when zero and none when negative.
+	MaxRetries int
+	// RetryBackoff is how long the first retry waits, doubled on each next
+	// one, 100 milliseconds when zero.
+	RetryBackoff time.Duration
+}
+
+// Client is a client of the cluster, safe for concurrent use.
+type Client struct {
+	opts Options
+
+	mu     sync.RWMutex
+	pools  map[string]*pool
+	slots  []string // the address of the leader of each slot, when known
+	closed bool
+
+	refreshing atomic.Bool
+}
+
+// New returns a client of the cluster of the nodes at opts.Addrs. It
+// connects to them on the first command.
+func New(opts Options) (*Client, error) {
+	if len(opts.Addrs) == 0 {
+		return nil, errors.New("client: no address")
+	}
+	if opts.PoolSize == 0 {
+		opts.PoolSize = 10
+	}
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.MaxRedirects == 0 {
+		opts.MaxRedirects = 16
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = 3
+	}
+	if opts.RetryBackoff == 0 {
+		opts.RetryBackoff = 100 * time.Millisecond
+	}
+	return &Client{opts: opts, pools: map[string]*pool{}, slots: make([]string, cluster.Slots)}, nil
+}
+
+// Close closes the connections of the client.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for _, p := range c.pools {
+		p.close()
+	}
+	return nil
+}
+
+// Get returns the value of the string key.
+func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
+	v, err := c.do(ctx, "", false, []byte("GET"), []byte(key))
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, ErrNotFound
+	}
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, errReply
+	}
+	return b, nil
+}
+
+// Set sets the string key to value. It is sent with a request ID, so that
+// it is retried safely when the leader changed before it was committed.
+func (c *Client) Set(ctx context.Context, key string, value []byte) error {
+	_, err := c.do(ctx, "", true, []byte("SET"), []byte(key), value)
+	return err
+}
+
+// Del deletes key and reports whether it existed. Like Set, it is sent with
+// a request ID.
+func (c *Client) Del(ctx context.Context, key string) (bool, error) {
+	v, err := c.do(ctx, "", true, []byte("DEL"), []byte(key))
+	if err != nil {
+		return false, err
+	}
+	n, ok := v.(int64)
+	if !ok {
+		return false, errReply
+	}
+	return n > 0, nil
+}
+
+// Do sends any command to the leader of the shard of its first argument,
+// taken as its key, or to any node when it has none, and returns the reply:
+// a string for a status, an int64, a []byte for a bulk string, nil for a
+// null, or a []any of them. An error reply is returned as an Error, but is
+// kept in place in an array. The command is retried only when it surely
+// was not applied; a write that may have been is not, unless wrapped in
+// REQID.
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	b := make([][]byte, len(args))
+	for i, a := range args {
+		b[i] = []byte(a)
+	}
+	return c.do(ctx, "", false, b...)
+}
+
+// DoNode is Do, sending the command to the node at addr instead, which
+// replies itself unless it redirects the command to the leader of its key.
+// Commands without a key, such as INFO or RAFT.SNAPSHOT, are served by
+// that node.
+func (c *Client) DoNode(ctx context.Context, addr string, args ...string) (any, error) {
+	b := make([][]byte, len(args))
+	for i, a := range args {
+		b[i] = []byte(a)
+	}
+	return c.do(ctx, addr, false, b...)
+}
+
+// Pipeline sends the commands the way Do does, but those routed to the same
+// node together, in one round trip on one connection, and returns their
+// replies in order. Error replies are kept in place as Errors; a command
+// that was redirected or may be retried is sent again on its own, with Do.
+// The commands of the same key keep their order. The error is that of a
+// round trip that failed, after which any of the commands may have been
+// applied.
+func (c *Client) Pipeline(ctx context.Context, cmds ...[]string) ([]any, error) {
+	args := make([][][]byte, len(cmds))
+	byAddr := map[string][]int{}
+	var addrs []string
+	for i, cmd := range cmds {
+		args[i] = make([][]byte, len(cmd))
+		for j, a := range cmd {
+			args[i][j] = []byte(a)
+		}
+		var key []byte
+		if len(cmd) > 1 {
+			key = args[i][1]
+		}
+		addr := c.route(key)
+		if _, ok := byAddr[addr]; !ok {
+			addrs = append(addrs, addr)
+		}
+		byAddr[addr] = append(byAddr[addr], i)
+	}
+
+	replies := make([]any, len(cmds))
+	for _, addr := range addrs {
+		idx := byAddr[addr]
+		lines := make([][][]byte, len(idx))
+		for j, i := range idx {
+			lines[j] = args[i]
+		}
+		vals, err := c.roundTrip(ctx, addr, lines)
+		if err != nil {
+			return nil, err
+		}
+		for j, i := range idx {
+			e, ok := vals[j].(Error)
+			if !ok {
+				replies[i] = vals[j]
+				continue
+			}
+			if _, _, _, redirect := redirectOf(e); !redirect && !retryable(e, false) {
+				replies[i] = e
+				continue
+			}
+			v, err := c.do(ctx, "", false, args[i]...)
+			if err != nil {
+				if e, ok := err.(Error); ok {
+					v = e
+				} else {
+					return nil, err
+				}
+			}
+			replies[i] = v
+		}
+	}
+	return replies, nil
+}
+
+// do sends args to node, or to the leader of their key when node is empty,
+// tagged with a new request ID when tag is set, following redirects and
+// retrying the failures retryable allows.
+func (c *Client) do(ctx context.Context, node string, tag bool, args ...[]byte) (any, error) {
+	cmd := args
+	switch {
+	case len(args) > 2 && strings.EqualFold(string(args[0]), "REQID"):
+		cmd, tag = args[2:], true
+	case tag:
+		args = append([][]byte{[]byte("REQID"), []byte(newRequestID())}, args...)
+	}
+	var key []byte
+	if len(cmd) > 1 {
+		key = cmd[1]
+	}
+
+	addr := node
+	if addr == "" {
+		addr = c.route(key)
+	}
+	asking := false
+	redirects, retries := 0, 0
+	for {
+		v, err := c.send(ctx, addr, asking, args)
+		asking = false
+		if err == nil {
+			e, ok := v.(Error)
+			if !ok {
+				return v, nil
+			}
+			err = e
+			if target, slot, moved, ok := redirectOf(e); ok && redirects < c.opts.MaxRedirects {
+				redirects++
+				if moved {
+					c.setSlot(slot, target)
+					c.refreshAsync()
+				} else {
+					asking = true
+				}
+				addr = target
+				continue
+			}
+		}
+		if !retryable(err, tag) || retries >= c.opts.MaxRetries || ctx.Err() != nil {
+			return nil, err
+		}
+
+		t := time.NewTimer(min(c.opts.RetryBackoff<<retries, maxRetryBackoff))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+		retries++
+		c.refresh(ctx)
+		if addr = node; addr == "" {
+			addr = c.route(key)
+		}
+	}
+}
+
+// send sends args to the node at addr, after ASKING when asking is set.
+func (c *Client) send(ctx context.Context, addr string, asking bool, args [][]byte) (any, error) {
+	lines := [][][]byte{args}
+	if asking {
+		lines = [][][]byte{{[]byte("ASKING")}, args}
+	}
+	replies, err := c.roundTrip(ctx, addr, lines)
+	if err != nil {
+		return nil, err
+	}
+	return replies[len(replies)-1], nil
+}
+
+// roundTrip sends the command lines to the node at addr on one connection
+// and returns their replies.
+func (c *Client) roundTrip(ctx context.Context, addr string, lines [][][]byte) ([]any, error) {
+	p, err := c.pool(addr)
+	if err != nil {
+		return nil, err
+	}
+	cn, err := p.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	replies, err := cn.do(ctx, c.opts.Timeout, lines...)
+	p.put(cn)
+	return replies, err
+}
+
+// redirectOf returns the address and the slot of a MOVED or an ASK
+// redirect, and whether it is a MOVED one.
+func redirectOf(e Error) (addr string, slot int, moved, ok bool) {
+	f := strings.Fields(string(e))
+	if len(f) != 3 || (f[0] != "MOVED" && f[0] != "ASK") {
+		return "", 0, false, false
+	}
+	slot, err := strconv.Atoi(f[1])
+	if err != nil || slot < 0 || slot >= cluster.Slots {
+		return "", 0, false, false
+	}
+	return f[2], slot, f[0] == "MOVED", true
+}
+
+// retryable reports whether a command may be sent again after err. It was
+// not applied when the node couldn't be reached, when the shard had no
+// leader, or when the node refused it for being busy. When leadership was
+// lost while it was being committed, or the connection failed after it was
+// sent, it may have been, so only a command with a request ID is retried.
+func retryable(err error, tagged bool) bool {
+	var de *dialError
+	if errors.As(err, &de) {
+		return true
+	}
+	var e Error
+	if !errors.As(err, &e) {
+		return tagged && !errors.Is(err, ErrClosed)
+	}
+	msg := string(e)
+	code, _, _ := strings.Cut(msg, " ")
+	switch code {
+	case "CLUSTERDOWN", "TRYAGAIN", "BUSY", "THROTTLED", "LOADING":
+		return true
+	}
+	switch {
+	case strings.Contains(msg, hraft.ErrNotLeader.Error()),
+		strings.Contains(msg, hraft.ErrEnqueueTimeout.Error()):
+		return true
+	case strings.Contains(msg, hraft.ErrLeadershipLost.Error()):
+		return tagged
+	}
+	return false
+}
+
+// newRequestID returns a random request ID for REQID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// route returns the address of the leader of the slot of key, or of any
+// known node when it is unknown or key is nil.
+func (c *Client) route(key []byte) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if key != nil {
+		if addr := c.slots[cluster.KeySlot(key)]; addr != "" {
+			return addr
+		}
+	}
+	return c.opts.Addrs[mrand.IntN(len(c.opts.Addrs))]
+}
+
+func (c *Client) setSlot(slot int, addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.slots[slot] = addr
+}
+
+// pool returns the pool of connections to addr.
+func (c *Client) pool(addr string) (*pool, error) {
+	c.mu.RLock()
+	p, ok := c.pools[addr]
+	closed := c.closed
+	c.mu.RUnlock()
+	if closed {
+		return nil, ErrClosed
+	}
+	if ok {
+		return p, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, ok := c.pools[addr]; ok {
+		return p, nil
+	}
+	p = &pool{addr: addr, opts: &c.opts}
+	c.pools[addr] = p
+	return p, nil
+}
+
+// refreshAsync refreshes the slots in the background, unless a refresh is
+// running already.
+func (c *Client) refreshAsync() {
+	if !c.refreshing.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer c.refreshing.Store(false)
+		ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
+		defer cancel()
+		c.refresh(ctx)
+	}()
+}
+
+// refresh learns the leader of each slot with CLUSTER SLOTS, from the first
+// node that answers: one of Addrs or a leader found earlier.
+func (c *Client) refresh(ctx context.Context) error {
+	c.mu.RLock()
+	addrs := append([]string(nil), c.opts.Addrs...)
+	seen := map[string]bool{}
+	for _, a := range addrs {
+		seen[a] = true
+	}
+	for _, a := range c.slots {
+		if a != "" && !seen[a] {
+			seen[a] = true
+			addrs = append(addrs, a)
+		}
+	}
+	c.mu.RUnlock()
+	mrand.Shuffle(len(addrs), func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+
+	err := errors.New("client: no node answered CLUSTER SLOTS")
+	for _, addr := range addrs {
+		var v any
+		if v, err = c.send(ctx, addr, false, [][]byte{[]byte("CLUSTER"), []byte("SLOTS")}); err != nil {
+			continue
+		}
+		var slots []string
+		if slots, err = parseSlots(v); err != nil {
+			continue
+		}
+		c.mu.Lock()
+		c.slots = slots
+		c.mu.Unlock()
+		return nil
+	}
+	return err
+}
+
+// parseSlots returns the address of the master of each slot in a reply of
+// CLUSTER SLOTS.
+func parseSlots(v any) ([]string, error) {
+	if e, ok := v.(Error); ok {
+		return nil, e
+	}
+	ranges, ok := v.([]any)
+	if !ok {
+		return nil, errReply
+	}
+	slots := make([]string, cluster.Slots)
+	for _, r := range ranges {
+		f, ok := r.([]any)
+		if !ok || len(f) < 3 {
+			return nil, errReply
+		}
+		start, ok1 := f[0].(int64)
+		end, ok2 := f[1].(int64)
+		master, ok3 := f[2].([]any)
+		if !ok1 || !ok2 || !ok3 || len(master) < 2 || start < 0 || end >= cluster.Slots || start > end {
+			return nil, errReply
+		}
+		host, ok1 := master[0].([]byte)
+		port, ok2 := master[1].(int64)
+		if !ok1 || !ok2 {
+			return nil, errReply
+		}
+		addr := net.JoinHostPort(string(host), strconv.FormatInt(port, 10))
+		for s := start; s <= end; s++ {
+			slots[s] = addr
+		}
+	}
+	return slots, nil
+}
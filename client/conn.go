@@ -0,0 +1,211 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tidwall/redcon"
+)
+
+// Error is an error reply of the server.
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+// conn is a connection to a node, speaking RESP2.
+type conn struct {
+	nc net.Conn
+	br *bufio.Reader
+	bw *bufio.Writer
+	// broken is set once an I/O error left the connection in an unknown
+	// state, so that it is not reused.
+	broken bool
+}
+
+// do sends the command lines and returns the reply of each, until the
+// first I/O error. The deadline is the one of ctx, or timeout from now.
+func (c *conn) do(ctx context.Context, timeout time.Duration, lines ...[][]byte) ([]any, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok && timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	c.nc.SetDeadline(deadline)
+
+	var b []byte
+	for _, args := range lines {
+		b = redcon.AppendArray(b, len(args))
+		for _, a := range args {
+			b = redcon.AppendBulk(b, a)
+		}
+	}
+	if _, err := c.bw.Write(b); err != nil {
+		c.broken = true
+		return nil, err
+	}
+	if err := c.bw.Flush(); err != nil {
+		c.broken = true
+		return nil, err
+	}
+
+	replies := make([]any, len(lines))
+	for i := range lines {
+		v, err := readValue(c.br)
+		if err != nil {
+			c.broken = true
+			return nil, err
+		}
+		replies[i] = v
+	}
+	return replies, nil
+}
+
+// readValue reads a RESP2 reply: a string for a status, an Error, an int64,
+// a []byte for a bulk string, nil for a null, or a []any of them.
+func readValue(br *bufio.Reader) (any, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("client: protocol error: invalid reply line")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return Error(body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(br, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		vals := make([]any, n)
+		for i := range vals {
+			if vals[i], err = readValue(br); err != nil {
+				return nil, err
+			}
+		}
+		return vals, nil
+	}
+	return nil, fmt.Errorf("client: protocol error: unexpected reply type %q", kind)
+}
+
+// pool keeps the idle connections to a node.
+type pool struct {
+	addr string
+	opts *Options
+
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+// get returns an idle connection to the node, or a new one.
+func (p *pool) get(ctx context.Context) (*conn, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if n := len(p.idle); n > 0 {
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return c, nil
+	}
+	p.mu.Unlock()
+	return p.dial(ctx)
+}
+
+// put returns c to the pool, or closes it when it is broken or the pool is
+// full.
+func (p *pool) put(c *conn) {
+	p.mu.Lock()
+	if !c.broken && !p.closed && len(p.idle) < p.opts.PoolSize {
+		p.idle = append(p.idle, c)
+		c = nil
+	}
+	p.mu.Unlock()
+	if c != nil {
+		c.nc.Close()
+	}
+}
+
+func (p *pool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, c := range p.idle {
+		c.nc.Close()
+	}
+	p.idle = nil
+}
+
+// dialError is a failure to connect to a node, after which any command may
+// be sent again.
+type dialError struct{ err error }
+
+func (e *dialError) Error() string { return e.err.Error() }
+func (e *dialError) Unwrap() error { return e.err }
+
+// dial connects to the node and authenticates.
+func (p *pool) dial(ctx context.Context) (*conn, error) {
+	d := &net.Dialer{Timeout: p.opts.DialTimeout}
+	var nc net.Conn
+	var err error
+	if p.opts.TLS != nil {
+		td := &tls.Dialer{NetDialer: d, Config: p.opts.TLS}
+		nc, err = td.DialContext(ctx, "tcp", p.addr)
+	} else {
+		nc, err = d.DialContext(ctx, "tcp", p.addr)
+	}
+	if err != nil {
+		return nil, &dialError{err}
+	}
+	c := &conn{nc: nc, br: bufio.NewReader(nc), bw: bufio.NewWriter(nc)}
+
+	if p.opts.Password != "" {
+		auth := [][]byte{[]byte("AUTH"), []byte(p.opts.Password)}
+		if p.opts.Username != "" {
+			auth = [][]byte{[]byte("AUTH"), []byte(p.opts.Username), []byte(p.opts.Password)}
+		}
+		replies, err := c.do(ctx, p.opts.Timeout, auth)
+		if err != nil {
+			nc.Close()
+			return nil, &dialError{err}
+		}
+		if e, ok := replies[0].(Error); ok {
+			nc.Close()
+			return nil, e
+		}
+	}
+	return c, nil
+}
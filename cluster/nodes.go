@@ -0,0 +1,116 @@
+package cluster
+
+import (
+	"encoding/gob"
+	"io"
+	"maps"
+	"sync"
+)
+
+// Nodes records the Redis address of the servers added to the cluster at
+// runtime, so that every node can redirect clients to them. Like Table, it
+// is part of the replicated state. A server may also have an advertised
+// address, which clients are given instead of the one the nodes use between
+// themselves, for servers behind NAT or in containers.
+type Nodes struct {
+	mu         sync.RWMutex
+	addrs      map[string]string
+	advertised map[string]string
+}
+
+// NewNodes returns an empty registry.
+func NewNodes() *Nodes {
+	return &Nodes{addrs: map[string]string{}, advertised: map[string]string{}}
+}
+
+// Reset empties the registry.
+func (n *Nodes) Reset() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.addrs = map[string]string{}
+	n.advertised = map[string]string{}
+}
+
+// Set records the Redis address of the server id, and its advertised
+// address unless it is empty.
+func (n *Nodes) Set(id, addr, advertised string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.addrs[id] = addr
+	if advertised == "" {
+		delete(n.advertised, id)
+	} else {
+		n.advertised[id] = advertised
+	}
+}
+
+// Delete forgets the server id.
+func (n *Nodes) Delete(id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.addrs, id)
+	delete(n.advertised, id)
+}
+
+// All returns the Redis address of every server, by server ID.
+func (n *Nodes) All() map[string]string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return maps.Clone(n.addrs)
+}
+
+// Advertised returns the advertised address of the servers that have one,
+// by server ID.
+func (n *Nodes) Advertised() map[string]string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return maps.Clone(n.advertised)
+}
+
+// Encode writes the registry to w.
+func (n *Nodes) Encode(w io.Writer) error {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return gob.NewEncoder(w).Encode(n.addrs)
+}
+
+// Decode replaces the registry with the one read from r.
+func (n *Nodes) Decode(r io.Reader) error {
+	var addrs map[string]string
+	if err := gob.NewDecoder(r).Decode(&addrs); err != nil {
+		return err
+	}
+	if addrs == nil {
+		addrs = map[string]string{}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.addrs = addrs
+	return nil
+}
+
+// EncodeAdvertised writes the advertised addresses to w. They are kept apart
+// from the addresses in Encode, which snapshots have carried before them.
+func (n *Nodes) EncodeAdvertised(w io.Writer) error {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return gob.NewEncoder(w).Encode(n.advertised)
+}
+
+// DecodeAdvertised replaces the advertised addresses with the ones read from
+// r.
+func (n *Nodes) DecodeAdvertised(r io.Reader) error {
+	var advertised map[string]string
+	if err := gob.NewDecoder(r).Decode(&advertised); err != nil {
+		return err
+	}
+	if advertised == nil {
+		advertised = map[string]string{}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.advertised = advertised
+	return nil
+}
@@ -0,0 +1,88 @@
+// Package cluster maps keys to the hash slots of Redis Cluster, and the
+// slots to the shards that own them.
+package cluster
+
+import (
+	"net"
+	"strconv"
+)
+
+// Slots is the number of hash slots the keyspace is divided into.
+const Slots = 16384
+
+// KeySlot returns the hash slot of key, CRC16(key) mod 16384. Only the hash
+// tag of the key is hashed when it has one.
+func KeySlot(key []byte) int {
+	return int(crc16(HashTag(key)) % Slots)
+}
+
+// HashTag returns the part of key that decides its slot: the bytes between
+// the first '{' and the next '}', when there is at least one, and the whole
+// key otherwise. Keys sharing a hash tag, like {user1}.name and
+// {user1}.mail, belong to the same slot.
+func HashTag(key []byte) []byte {
+	for i, c := range key {
+		if c != '{' {
+			continue
+		}
+		for j := i + 1; j < len(key); j++ {
+			if key[j] == '}' {
+				if j == i+1 {
+					return key
+				}
+				return key[i+1 : j]
+			}
+		}
+		return key
+	}
+	return key
+}
+
+// crc16Table is the table of CRC-16/XMODEM (polynomial 0x1021), the
+// checksum used by Redis Cluster.
+var crc16Table = func() [256]uint16 {
+	var t [256]uint16
+	for i := range t {
+		crc := uint16(i) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		t[i] = crc
+	}
+	return t
+}()
+
+func crc16(b []byte) uint16 {
+	var crc uint16
+	for _, c := range b {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^c]
+	}
+	return crc
+}
+
+// MaxShards is the largest number of shards the slots can be split into.
+const MaxShards = 256
+
+// ShardOf returns the shard that owns slot when the slots are split evenly
+// into n shards.
+func ShardOf(slot, n int) int {
+	return slot * n / Slots
+}
+
+// ShardAddr returns the address shard i of a node listens on when the first
+// shard listens on addr: the port is shifted by i.
+func ShardAddr(addr string, i int) (string, error) {
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port+i)), nil
+}
@@ -0,0 +1,112 @@
+package cluster
+
+import (
+	"encoding/gob"
+	"io"
+	"sync"
+)
+
+// Table records the slots that were moved away from the shard ShardOf
+// assigns them to, and the slots being migrated to another shard. It is
+// part of the replicated state and is only changed while a log entry is
+// applied.
+type Table struct {
+	mu        sync.RWMutex
+	owners    map[int]int
+	migrating map[int]int
+}
+
+// NewTable returns a table where every slot belongs to its initial shard.
+func NewTable() *Table {
+	t := &Table{}
+	t.Reset()
+	return t
+}
+
+// Reset brings the table back to the state of NewTable.
+func (t *Table) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.owners, t.migrating = map[int]int{}, map[int]int{}
+}
+
+// Owner returns the shard slot was assigned to, if it was moved.
+func (t *Table) Owner(slot int) (int, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	shard, ok := t.owners[slot]
+	return shard, ok
+}
+
+// Migrating returns the shard slot is being migrated to.
+func (t *Table) Migrating(slot int) (int, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	shard, ok := t.migrating[slot]
+	return shard, ok
+}
+
+// MigratingSlots returns the slots being migrated with their target shard.
+func (t *Table) MigratingSlots() map[int]int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	m := make(map[int]int, len(t.migrating))
+	for slot, shard := range t.migrating {
+		m[slot] = shard
+	}
+	return m
+}
+
+// SetMigrating starts the migration of slot to shard.
+func (t *Table) SetMigrating(slot, shard int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.migrating[slot] = shard
+}
+
+// SetOwner assigns slot to shard and ends its migration.
+func (t *Table) SetOwner(slot, shard int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.owners[slot] = shard
+	delete(t.migrating, slot)
+}
+
+// Stable cancels the migration of slot.
+func (t *Table) Stable(slot int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.migrating, slot)
+}
+
+// tableSnapshot is the encoded form of a Table.
+type tableSnapshot struct {
+	Owners    map[int]int
+	Migrating map[int]int
+}
+
+// Encode writes the table to w.
+func (t *Table) Encode(w io.Writer) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return gob.NewEncoder(w).Encode(tableSnapshot{Owners: t.owners, Migrating: t.migrating})
+}
+
+// Decode replaces the table with the one read from r.
+func (t *Table) Decode(r io.Reader) error {
+	var ts tableSnapshot
+	if err := gob.NewDecoder(r).Decode(&ts); err != nil {
+		return err
+	}
+	if ts.Owners == nil {
+		ts.Owners = map[int]int{}
+	}
+	if ts.Migrating == nil {
+		ts.Migrating = map[int]int{}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.owners, t.migrating = ts.Owners, ts.Migrating
+	return nil
+}
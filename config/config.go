@@ -0,0 +1,144 @@
+// Package config is the registry of runtime configuration parameters served
+// by CONFIG GET and CONFIG SET, and the loader of configuration files.
+package config
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknown  = errors.New("unknown parameter")
+	ErrReadOnly = errors.New("can't set immutable config")
+)
+
+// Param is a configuration parameter.
+type Param struct {
+	Name string
+	// Get returns the current value.
+	Get func() string
+	// Set validates and applies a new value. It is nil for parameters that
+	// can't be changed at runtime.
+	Set func(value string) error
+}
+
+// Registry holds the parameters by name.
+type Registry struct {
+	mu     sync.RWMutex
+	params map[string]Param
+}
+
+// New returns an empty Registry.
+func New() *Registry {
+	return &Registry{params: map[string]Param{}}
+}
+
+// Register adds p, replacing a parameter with the same name.
+func (r *Registry) Register(p Param) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.params[strings.ToLower(p.Name)] = p
+}
+
+// Names returns the names of all parameters in lexical order.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.params))
+	for n := range r.params {
+		names = append(names, n)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Get returns the value of the named parameter.
+func (r *Registry) Get(name string) (string, error) {
+	p, ok := r.lookup(name)
+	if !ok {
+		return "", ErrUnknown
+	}
+	return p.Get(), nil
+}
+
+// Set changes the value of the named parameter.
+func (r *Registry) Set(name, value string) error {
+	p, ok := r.lookup(name)
+	if !ok {
+		return ErrUnknown
+	}
+	if p.Set == nil {
+		return ErrReadOnly
+	}
+	return p.Set(value)
+}
+
+func (r *Registry) lookup(name string) (Param, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.params[strings.ToLower(name)]
+	return p, ok
+}
+
+var memoryUnits = []struct {
+	suffix string
+	mul    int64
+}{
+	{"gb", 1 << 30},
+	{"mb", 1 << 20},
+	{"kb", 1 << 10},
+	{"g", 1000 * 1000 * 1000},
+	{"m", 1000 * 1000},
+	{"k", 1000},
+	{"b", 1},
+}
+
+// ParseMemory parses a memory amount the way redis.conf does, such as
+// "100mb" or "1g".
+func ParseMemory(s string) (int64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	mul := int64(1)
+	for _, u := range memoryUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mul = strings.TrimSuffix(s, u.suffix), u.mul
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("argument must be a memory value")
+	}
+	return n * mul, nil
+}
+
+// ParseInt parses a non-negative integer parameter.
+func ParseInt(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("argument couldn't be parsed into an integer")
+	}
+	return n, nil
+}
+
+// ParseBool parses a yes or no parameter.
+func ParseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+	return false, errors.New("argument must be 'yes' or 'no'")
+}
+
+// FormatBool formats b as a yes or no parameter.
+func FormatBool(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
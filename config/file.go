@@ -0,0 +1,348 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// paramsSection is the section of a configuration file holding runtime
+// parameters.
+const paramsSection = "params"
+
+// File is a configuration file in YAML or TOML, told apart by its
+// extension. Its settings are the command-line flags of the node, written
+// without the leading dashes. A section prefixes the names of the settings
+// in it, so that
+//
+//	raft:
+//	  apply_timeout: 500
+//
+// sets --raft_apply_timeout. Lists are joined with commas. The params
+// section holds runtime parameters by their CONFIG SET names instead, such
+// as maxclients or raft-heartbeat-timeout, which are set once the node is
+// up.
+type File struct {
+	path     string
+	settings []setting
+	// given are the flags given on the command line, which the file leaves
+	// alone.
+	given map[string]bool
+
+	mu     sync.Mutex
+	params []setting
+}
+
+// setting is a value of a file, named key in it, such as raft.apply_timeout,
+// and name once the sections are joined.
+type setting struct {
+	key   string
+	name  string
+	value string
+}
+
+// LoadFile reads and parses the configuration file at path.
+func LoadFile(path string) (*File, error) {
+	m, err := decode(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &File{path: path}
+	if p, ok := m[paramsSection]; ok {
+		delete(m, paramsSection)
+		params, ok := p.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: %s must be a section", path, paramsSection)
+		}
+		if err := flatten(&f.params, params, "", "", "-", formatParam); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if err := flatten(&f.settings, m, "", "", "_", formatFlag); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+// Path returns the path of the file.
+func (f *File) Path() string {
+	return f.path
+}
+
+// Reload reads the file again. ApplyFlags of the new file leaves alone the
+// flags given on the command line when ApplyFlags of f ran, which f itself
+// can no longer tell apart from the ones it set.
+func (f *File) Reload() (*File, error) {
+	nf, err := LoadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	nf.given = f.given
+	return nf, nil
+}
+
+// decode reads the file at path into a map, in the format of its extension.
+func decode(path string) (map[string]any, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.NewDecoder(bytes.NewReader(b)).Decode(&m)
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+	case ".toml":
+		err = toml.Unmarshal(b, &m)
+	default:
+		return nil, fmt.Errorf("%s: unknown format, the file must end with .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// encode writes m to the file at path, in the format of its extension. The
+// file is replaced at once, so that it is never left half written.
+func encode(path string, m map[string]any) error {
+	var b bytes.Buffer
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		e := yaml.NewEncoder(&b)
+		e.SetIndent(2)
+		if err := e.Encode(m); err != nil {
+			return err
+		}
+		if err := e.Close(); err != nil {
+			return err
+		}
+	case ".toml":
+		if err := toml.NewEncoder(&b).Encode(m); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%s: unknown format, the file must end with .yaml, .yml or .toml", path)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// flatten appends the values of m to out, in the order of their keys. The
+// names of the values of a section are prefixed with the name of the
+// section and sep.
+func flatten(out *[]setting, m map[string]any, key, name, sep string, format func(any) (string, bool)) error {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		fk, fn := k, k
+		if key != "" {
+			fk, fn = key+"."+k, name+sep+k
+		}
+		if sub, ok := m[k].(map[string]any); ok {
+			if err := flatten(out, sub, fk, fn, sep, format); err != nil {
+				return err
+			}
+			continue
+		}
+		v, ok := format(m[k])
+		if !ok {
+			return fmt.Errorf("%s: unsupported value %v", fk, m[k])
+		}
+		*out = append(*out, setting{key: fk, name: fn, value: v})
+	}
+	return nil
+}
+
+// formatFlag formats v as the argument of a flag.
+func formatFlag(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case int:
+		return strconv.Itoa(v), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case []any:
+		s := make([]string, len(v))
+		for i, e := range v {
+			var ok bool
+			if s[i], ok = formatFlag(e); !ok {
+				return "", false
+			}
+		}
+		return strings.Join(s, ","), true
+	}
+	return "", false
+}
+
+// formatParam formats v as the value of a runtime parameter, where booleans
+// are yes or no.
+func formatParam(v any) (string, bool) {
+	if b, ok := v.(bool); ok {
+		return FormatBool(b), true
+	}
+	return formatFlag(v)
+}
+
+// ApplyFlags sets the flags of fs from the file. The flags given on the
+// command line are left as they are, so they override the file. A setting
+// that is no flag of fs or doesn't parse is an error.
+func (f *File) ApplyFlags(fs *flag.FlagSet) error {
+	if f.given == nil {
+		f.given = map[string]bool{}
+		fs.Visit(func(fl *flag.Flag) { f.given[fl.Name] = true })
+	}
+
+	var names []string
+	fs.VisitAll(func(fl *flag.Flag) { names = append(names, fl.Name) })
+
+	for _, s := range f.settings {
+		if fs.Lookup(s.name) == nil {
+			return fmt.Errorf("%s: unknown setting %s%s", f.path, s.key, suggest(s.name, names))
+		}
+		if f.given[s.name] {
+			continue
+		}
+		if err := fs.Set(s.name, s.value); err != nil {
+			return fmt.Errorf("%s: invalid value %q for %s: %w", f.path, s.value, s.key, err)
+		}
+	}
+	return nil
+}
+
+// ApplyParams sets the runtime parameters of the params section in r.
+func (f *File) ApplyParams(r *Registry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, s := range f.params {
+		err := r.Set(s.name, s.value)
+		switch {
+		case errors.Is(err, ErrUnknown):
+			return fmt.Errorf("%s: unknown parameter %s.%s%s", f.path, paramsSection, s.key, suggest(s.name, r.Names()))
+		case err != nil:
+			return fmt.Errorf("%s: invalid value %q for %s.%s: %w", f.path, s.value, paramsSection, s.key, err)
+		}
+	}
+	return nil
+}
+
+// Rewrite writes the current values in r of the parameters of the params
+// section, and of names, to the params section of the file, so that the
+// changes made at runtime outlive a restart. The other sections are kept,
+// but not the comments and the layout of the file.
+func (f *File) Rewrite(r *Registry, names []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	m, err := decode(f.path)
+	if err != nil {
+		return err
+	}
+	all := slices.Clone(names)
+	for _, s := range f.params {
+		all = append(all, s.name)
+	}
+	params := map[string]any{}
+	for _, name := range all {
+		v, err := r.Get(name)
+		if err != nil {
+			continue
+		}
+		params[strings.ToLower(name)] = v
+	}
+	m[paramsSection] = params
+	if err := encode(f.path, m); err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+
+	var rewritten []setting
+	if err := flatten(&rewritten, params, "", "", "-", formatParam); err != nil {
+		return err
+	}
+	f.params = rewritten
+	return nil
+}
+
+// suggest returns a hint naming the closest of names to name, when one is
+// close enough to be a typo.
+func suggest(name string, names []string) string {
+	best, dist := "", len(name)/3+1
+	for _, n := range names {
+		if d := editDistance(name, n); d < dist {
+			best, dist = n, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return " (did you mean " + best + "?)"
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,250 @@
+// Package encryption encrypts the data a node keeps on disk with AES-GCM,
+// under keys read from a key file that can be rotated.
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownKey = errors.New("encryption: data encrypted with a key missing from the key file")
+	ErrCorrupt    = errors.New("encryption: data corrupted, truncated or encrypted with another key of the same ID")
+)
+
+// blobMagic starts the data encrypted by Seal, and streamMagic the streams
+// written by NewWriter. Neither can start a log entry or a snapshot.
+var (
+	blobMagic   = []byte("RKVENCB1")
+	streamMagic = []byte("RKVENCS1")
+)
+
+// Keyring holds the keys of a key file. The first key of the file is the
+// primary key, which encrypts; the others only decrypt the data encrypted
+// before it became the primary key.
+//
+// A key is rotated by adding a new key at the top of the file and reloading
+// it. The data encrypted with the former primary key is encrypted again as
+// it is rewritten: the snapshots as new ones are taken, and the log entries
+// as the log is compacted after them. A key can be removed once no data
+// encrypted with it is left, which raft-debug verify checks.
+//
+// Data that is not encrypted is rejected, as it may have been written in
+// place of encrypted data, unless AllowPlaintext was called while the data
+// written before the encryption was enabled is migrated.
+type Keyring struct {
+	path string
+
+	mu             sync.RWMutex
+	keys           []*key
+	allowPlaintext bool
+}
+
+// key is an AES key of the key file, with its ID.
+type key struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// Load reads the keys of the key file at path. Each line of the file holds
+// the ID of a key and the key encoded in base64, separated by spaces. The
+// keys are 16, 24 or 32 bytes long, for AES-128, AES-192 or AES-256. Empty
+// lines and lines starting with # are ignored.
+func Load(path string) (*Keyring, error) {
+	k := &Keyring{path: path}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Reload reads the key file again, such as after a new primary key was
+// added. The keys are left alone when the file is not valid.
+func (k *Keyring) Reload() error {
+	keys, err := readKeys(k.path)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = keys
+	return nil
+}
+
+// AllowPlaintext has Open accept the data that is not encrypted, as it is,
+// and so should the readers of files that are not a stream, which check
+// PlaintextAllowed. It is meant for migrating a node that ran without
+// encryption, until the snapshots and the log were rewritten encrypted.
+func (k *Keyring) AllowPlaintext(allow bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.allowPlaintext = allow
+}
+
+// PlaintextAllowed reports whether the data that is not encrypted is
+// accepted.
+func (k *Keyring) PlaintextAllowed() bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.allowPlaintext
+}
+
+// Primary returns the ID of the key the data is encrypted with.
+func (k *Keyring) Primary() string {
+	return k.primary().id
+}
+
+func (k *Keyring) primary() *key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keys[0]
+}
+
+func (k *Keyring) lookup(id string) (*key, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if key.id == id {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+}
+
+func readKeys(path string) ([]*key, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys []*key
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want a key ID and a base64 key", path, line)
+		}
+		id := fields[0]
+		if len(id) > 255 {
+			return nil, fmt.Errorf("%s:%d: key ID longer than 255 bytes", path, line)
+		}
+		for _, k := range keys {
+			if k.id == id {
+				return nil, fmt.Errorf("%s:%d: duplicate key ID %q", path, line, id)
+			}
+		}
+		raw, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: key must be 16, 24 or 32 bytes", path, line)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key{id: id, aead: aead})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no key", path)
+	}
+	return keys, nil
+}
+
+// header returns magic followed by the ID of k.
+func (k *key) header(magic []byte) []byte {
+	h := make([]byte, 0, len(magic)+1+len(k.id))
+	h = append(h, magic...)
+	h = append(h, byte(len(k.id)))
+	return append(h, k.id...)
+}
+
+// readHeader parses the header of data starting with magic, and returns the
+// key of its ID with the length of the header.
+func (k *Keyring) readHeader(data, magic []byte) (*key, int, error) {
+	n := len(magic)
+	if len(data) <= n || !bytes.HasPrefix(data, magic) || len(data) < n+1+int(data[n]) {
+		return nil, 0, ErrCorrupt
+	}
+	end := n + 1 + int(data[n])
+	key, err := k.lookup(string(data[n+1 : end]))
+	return key, end, err
+}
+
+// Encrypted reports whether data was encrypted by Seal.
+func Encrypted(data []byte) bool {
+	return bytes.HasPrefix(data, blobMagic)
+}
+
+// Seal encrypts data with the primary key. The same ad must be given to
+// Open, which binds the data to it, such as to its place in a file.
+func (k *Keyring) Seal(data, ad []byte) []byte {
+	key := k.primary()
+	h := key.header(blobMagic)
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	out := append(h, nonce...)
+	return key.aead.Seal(out, nonce, data, append(h, ad...))
+}
+
+// Open decrypts data encrypted by Seal with any key of k. Data that is not
+// encrypted is rejected with ErrCorrupt, unless plaintext is allowed, when
+// it is returned as it is. Empty data, which is never sealed, is returned
+// as it is.
+func (k *Keyring) Open(data, ad []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	if !Encrypted(data) {
+		if !k.PlaintextAllowed() {
+			return nil, ErrCorrupt
+		}
+		return data, nil
+	}
+	key, n, err := k.readHeader(data, blobMagic)
+	if err != nil {
+		return nil, err
+	}
+	ns := key.aead.NonceSize()
+	if len(data) < n+ns {
+		return nil, ErrCorrupt
+	}
+	plain, err := key.aead.Open(nil, data[n:n+ns], data[n+ns:], append(bytes.Clone(data[:n]), ad...))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	return plain, nil
+}
+
+// KeyOf returns the ID of the key data was encrypted with by Seal or
+// NewWriter, and false when it is not encrypted.
+func KeyOf(data []byte) (string, bool) {
+	for _, magic := range [][]byte{blobMagic, streamMagic} {
+		n := len(magic)
+		if bytes.HasPrefix(data, magic) && len(data) > n && len(data) >= n+1+int(data[n]) {
+			return string(data[n+1 : n+1+int(data[n])]), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,165 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newKey returns a line of a key file for a new AES-256 key with id.
+func newKey(t *testing.T, id string) string {
+	t.Helper()
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+	return id + " " + base64.StdEncoding.EncodeToString(raw)
+}
+
+// writeKeys writes a key file of lines at path.
+func writeKeys(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// loadKeys returns a Keyring of a new key file of lines, with its path.
+func loadKeys(t *testing.T, lines ...string) (*Keyring, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys")
+	writeKeys(t, path, lines...)
+	k, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k, path
+}
+
+func TestSealOpen(t *testing.T) {
+	k, _ := loadKeys(t, "# comment", "", newKey(t, "k1"))
+	data, ad := []byte("value"), []byte("ad")
+
+	sealed := k.Seal(data, ad)
+	if !Encrypted(sealed) || bytes.Contains(sealed, data) {
+		t.Fatalf("Seal = %q, want it encrypted", sealed)
+	}
+	if id, ok := KeyOf(sealed); !ok || id != "k1" {
+		t.Fatalf("KeyOf = %q, %v, want k1", id, ok)
+	}
+	if got, err := k.Open(sealed, ad); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Open = %q, %v, want %q", got, err, data)
+	}
+}
+
+func TestOpenRejectsPlaintext(t *testing.T) {
+	k, _ := loadKeys(t, newKey(t, "k1"))
+	ad := []byte("ad")
+	sealed := k.Seal([]byte("value"), ad)
+
+	// Data written in place of sealed data, or sealed data whose magic was
+	// changed, can't be passed off as data never encrypted.
+	unmarked := bytes.Clone(sealed)
+	unmarked[0] ^= 1
+	for name, data := range map[string][]byte{"plaintext": []byte("other value"), "unmarked": unmarked} {
+		if _, err := k.Open(data, ad); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: Open = %v, want ErrCorrupt", name, err)
+		}
+	}
+	if got, err := k.Open(nil, ad); err != nil || len(got) != 0 {
+		t.Errorf("Open of empty data = %q, %v, want it empty", got, err)
+	}
+
+	k.AllowPlaintext(true)
+	if got, err := k.Open([]byte("written before"), ad); err != nil || string(got) != "written before" {
+		t.Errorf("Open of plaintext allowed = %q, %v, want it as it is", got, err)
+	}
+	if _, err := k.Open(append(bytes.Clone(sealed[:len(sealed)-1]), sealed[len(sealed)-1]^1), ad); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Open of tampered data with plaintext allowed = %v, want ErrCorrupt", err)
+	}
+}
+
+func TestOpenRejectsTamperedData(t *testing.T) {
+	k, _ := loadKeys(t, newKey(t, "k1"))
+	ad := []byte("ad")
+	sealed := k.Seal([]byte("value"), ad)
+
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+	cases := map[string]struct {
+		data, ad []byte
+	}{
+		"tampered":    {tampered, ad},
+		"truncated":   {sealed[:len(sealed)-1], ad},
+		"header only": {sealed[:len(blobMagic)+3], ad},
+		"magic only":  {sealed[:len(blobMagic)], ad},
+		"other ad":    {sealed, []byte("other")},
+		"no ad":       {sealed, nil},
+		"renamed key": {append(append(bytes.Clone(blobMagic), 2, 'k', '2'), sealed[len(blobMagic)+3:]...), ad},
+	}
+	for name, c := range cases {
+		if _, err := k.Open(c.data, c.ad); err == nil {
+			t.Errorf("%s: Open succeeded", name)
+		}
+	}
+	if _, err := k.Open(cases["renamed key"].data, ad); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Open with a key missing from the file = %v, want ErrUnknownKey", err)
+	}
+	if _, err := k.Open(tampered, ad); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Open of tampered data = %v, want ErrCorrupt", err)
+	}
+}
+
+func TestRotatedKeyStillDecrypts(t *testing.T) {
+	k1 := newKey(t, "k1")
+	k, path := loadKeys(t, k1)
+	ad := []byte("ad")
+	old := k.Seal([]byte("old"), ad)
+
+	writeKeys(t, path, newKey(t, "k2"), k1)
+	if err := k.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if p := k.Primary(); p != "k2" {
+		t.Fatalf("Primary = %q after rotation, want k2", p)
+	}
+	if id, _ := KeyOf(k.Seal([]byte("new"), ad)); id != "k2" {
+		t.Fatalf("sealed with %q after rotation, want k2", id)
+	}
+	if got, err := k.Open(old, ad); err != nil || string(got) != "old" {
+		t.Fatalf("Open with the former key = %q, %v, want \"old\"", got, err)
+	}
+
+	// Once the former key is removed, its data can't be read.
+	writeKeys(t, path, newKey(t, "k2"))
+	if err := k.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.Open(old, ad); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Open with a removed key = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestReloadKeepsKeysOfInvalidFile(t *testing.T) {
+	k, path := loadKeys(t, newKey(t, "k1"))
+	for _, lines := range [][]string{
+		{"k2"},
+		{"k2 not-base64!"},
+		{"k2 " + base64.StdEncoding.EncodeToString([]byte("short"))},
+		{newKey(t, "k2"), newKey(t, "k2")},
+		{"# no key"},
+	} {
+		writeKeys(t, path, lines...)
+		if err := k.Reload(); err == nil {
+			t.Errorf("Reload of %q succeeded", lines)
+		}
+		if p := k.Primary(); p != "k1" {
+			t.Fatalf("Primary = %q after a failed reload, want k1", p)
+		}
+	}
+}
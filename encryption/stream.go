@@ -0,0 +1,174 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// segmentSize is how many bytes of a stream are encrypted together. Every
+// segment but the last one is full, and the last one is never full, so a
+// stream cut at the end of a segment is told from a complete one.
+const segmentSize = 64 << 10
+
+// prefixSize is the length of the random part of the nonces of a stream,
+// which are followed by the number of the segment and by 1 for the last
+// segment, 0 for the others.
+const prefixSize = 7
+
+// IsStream reports whether the reader of a file, buffered by br, is at a
+// stream written by NewWriter.
+func IsStream(br *bufio.Reader) bool {
+	magic, _ := br.Peek(len(streamMagic))
+	return bytes.Equal(magic, streamMagic)
+}
+
+// Writer encrypts what is written to it with the primary key of a Keyring,
+// in segments of segmentSize bytes.
+type Writer struct {
+	w      io.Writer
+	key    *key
+	header []byte
+	prefix []byte
+	seq    uint32
+	buf    []byte
+	err    error
+}
+
+// NewWriter returns a Writer encrypting to w. Close must be called to write
+// the last segment.
+func (k *Keyring) NewWriter(w io.Writer) (*Writer, error) {
+	key := k.primary()
+	prefix := make([]byte, prefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	header := append(key.header(streamMagic), prefix...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, key: key, header: header, prefix: prefix, buf: make([]byte, 0, segmentSize)}, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 && w.err == nil {
+		take := min(segmentSize-len(w.buf), len(p))
+		w.buf = append(w.buf, p[:take]...)
+		p = p[take:]
+		n += take
+		if len(w.buf) == segmentSize {
+			w.seal(false)
+		}
+	}
+	return n, w.err
+}
+
+// Close writes the last segment. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.err == nil {
+		w.seal(true)
+	}
+	return w.err
+}
+
+func (w *Writer) seal(last bool) {
+	if w.seq == ^uint32(0) {
+		w.err = errors.New("encryption: stream too long")
+		return
+	}
+	ct := w.key.aead.Seal(nil, segmentNonce(w.prefix, w.seq, last), w.buf, w.header)
+	_, w.err = w.w.Write(ct)
+	w.seq++
+	w.buf = w.buf[:0]
+}
+
+func segmentNonce(prefix []byte, seq uint32, last bool) []byte {
+	nonce := binary.BigEndian.AppendUint32(bytes.Clone(prefix), seq)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// Reader decrypts a stream written by NewWriter.
+type Reader struct {
+	r      io.Reader
+	key    *key
+	header []byte
+	prefix []byte
+	seq    uint32
+	seg    []byte
+	buf    []byte
+	done   bool
+}
+
+// NewReader returns a Reader of the stream read from r, encrypted with any
+// key of k.
+func (k *Keyring) NewReader(r io.Reader) (*Reader, error) {
+	head := make([]byte, len(streamMagic)+1)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, ErrCorrupt
+	}
+	rest := make([]byte, int(head[len(streamMagic)])+prefixSize)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, ErrCorrupt
+	}
+	header := append(head, rest...)
+	key, n, err := k.readHeader(header, streamMagic)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{
+		r:      r,
+		key:    key,
+		header: header,
+		prefix: header[n:],
+		seg:    make([]byte, segmentSize+key.aead.Overhead()),
+	}, nil
+}
+
+// Size returns how many bytes the stream of size bytes, header included,
+// decrypts to.
+func (r *Reader) Size(size int64) int64 {
+	full := int64(len(r.seg))
+	body := size - int64(len(r.header))
+	n := body / full
+	return n*segmentSize + body - n*full - int64(r.key.aead.Overhead())
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// next decrypts the next segment.
+func (r *Reader) next() error {
+	n, err := io.ReadFull(r.r, r.seg)
+	switch {
+	case err == nil:
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		r.done = true
+	default:
+		return err
+	}
+	plain, err := r.key.aead.Open(r.seg[:0], segmentNonce(r.prefix, r.seq, r.done), r.seg[:n], r.header)
+	if err != nil {
+		return ErrCorrupt
+	}
+	r.seq++
+	r.buf = plain
+	return nil
+}
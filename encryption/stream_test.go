@@ -0,0 +1,132 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+// encryptStream returns data written to a stream by k.
+func encryptStream(t *testing.T, k *Keyring, data []byte) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	w, err := k.NewWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Written in uneven pieces, which straddle the segments.
+	for p := data; len(p) > 0; {
+		n := min(len(p), 10_000)
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// decryptStream reads the stream enc with k.
+func decryptStream(k *Keyring, enc []byte) ([]byte, error) {
+	br := bufio.NewReader(bytes.NewReader(enc))
+	if !IsStream(br) {
+		return nil, errors.New("not a stream")
+	}
+	r, err := k.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	k, _ := loadKeys(t, newKey(t, "k1"))
+	for _, n := range []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 2*segmentSize + 100} {
+		data := randomBytes(t, n)
+		enc := encryptStream(t, k, data)
+		if id, ok := KeyOf(enc); !ok || id != "k1" {
+			t.Fatalf("%d bytes: KeyOf = %q, %v, want k1", n, id, ok)
+		}
+
+		got, err := decryptStream(k, enc)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%d bytes: decrypted %d bytes, %v, want them back", n, len(got), err)
+		}
+		r, err := k.NewReader(bytes.NewReader(enc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := r.Size(int64(len(enc))); size != int64(n) {
+			t.Errorf("%d bytes: Size = %d", n, size)
+		}
+	}
+}
+
+func TestStreamRejectsTamperedOrTruncated(t *testing.T) {
+	k, _ := loadKeys(t, newKey(t, "k1"))
+	enc := encryptStream(t, k, randomBytes(t, 2*segmentSize+100))
+	header := len(streamMagic) + 1 + len("k1") + prefixSize
+	full := segmentSize + 16
+
+	cases := map[string][]byte{
+		// Cut at the end of a segment, the stream would look complete
+		// but for the last segment, which is never full.
+		"cut at a segment boundary": enc[:header+full],
+		"cut before the last one":   enc[:header+2*full],
+		"cut in the last segment":   enc[:len(enc)-1],
+		"cut in the header":         enc[:header-1],
+		"header only":               enc[:header],
+	}
+	for name, i := range map[string]int{
+		"tampered header":       len(streamMagic) + 3,
+		"tampered first":        header + 10,
+		"tampered last segment": len(enc) - 1,
+	} {
+		b := bytes.Clone(enc)
+		b[i] ^= 1
+		cases[name] = b
+	}
+	// Segments swapped keep their own authentication tag.
+	swapped := bytes.Clone(enc)
+	copy(swapped[header:], enc[header+full:header+2*full])
+	copy(swapped[header+full:], enc[header:header+full])
+	cases["swapped segments"] = swapped
+
+	for name, b := range cases {
+		if _, err := decryptStream(k, b); err == nil {
+			t.Errorf("%s: decrypted", name)
+		}
+	}
+}
+
+func TestStreamRotatedKeyStillDecrypts(t *testing.T) {
+	k1 := newKey(t, "k1")
+	k, path := loadKeys(t, k1)
+	data := randomBytes(t, segmentSize+1)
+	enc := encryptStream(t, k, data)
+
+	writeKeys(t, path, newKey(t, "k2"), k1)
+	if err := k.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := decryptStream(k, enc); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("decrypted %d bytes, %v, with the former key, want them back", len(got), err)
+	}
+	if id, _ := KeyOf(encryptStream(t, k, data)); id != "k2" {
+		t.Fatalf("new stream encrypted with %q, want k2", id)
+	}
+}
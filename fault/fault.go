@@ -0,0 +1,164 @@
+// Package fault injects failures into a node for chaos tests: Raft messages
+// dropped at random or cut off between nodes, delayed FSM applies and
+// failing store writes. An Injector does nothing until a failure point is
+// set, from a test or with DEBUG FAULT, and only the transports, FSMs and
+// stores wrapped by it are affected.
+package fault
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	hraft "github.com/hashicorp/raft"
+)
+
+var (
+	// ErrDropped is the error of a Raft message dropped by an Injector.
+	ErrDropped = errors.New("fault: raft message dropped")
+	// ErrStoreWrite is the error of a store write failed by an Injector.
+	ErrStoreWrite = errors.New("fault: store write failed")
+)
+
+// Injector holds the failure points of a node, shared by its shards. It is
+// safe for concurrent use.
+type Injector struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+	// dropRate is the probability an outgoing Raft message is dropped.
+	dropRate float64
+	// partitioned are the servers no Raft message is exchanged with.
+	partitioned map[hraft.ServerID]bool
+	// applyDelay is how long the FSM waits before applying each entry.
+	applyDelay time.Duration
+	// storeFailRate is the probability a store write fails.
+	storeFailRate float64
+}
+
+// New returns an Injector with no failure point set.
+func New() *Injector {
+	return &Injector{
+		rand:        rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+		partitioned: map[hraft.ServerID]bool{},
+	}
+}
+
+// Seed seeds the random choices of the Injector, so that a test drops the
+// same messages and fails the same writes when it runs them in the same
+// order.
+func (f *Injector) Seed(seed uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rand = rand.New(rand.NewPCG(seed, seed))
+}
+
+// SetDropRate makes each outgoing Raft message dropped with probability p,
+// between 0 and 1.
+func (f *Injector) SetDropRate(p float64) error {
+	if p < 0 || p > 1 {
+		return errors.New("fault: probability must be between 0 and 1")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dropRate = p
+	return nil
+}
+
+// Partition cuts the node off from the servers ids, in both directions: the
+// Raft messages to and from them are dropped until Heal.
+func (f *Injector) Partition(ids ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, id := range ids {
+		f.partitioned[hraft.ServerID(id)] = true
+	}
+}
+
+// Heal ends the partitions.
+func (f *Injector) Heal() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	clear(f.partitioned)
+}
+
+// SetApplyDelay makes the FSM wait d before applying each log entry.
+func (f *Injector) SetApplyDelay(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.applyDelay = max(d, 0)
+}
+
+// SetStoreFailRate makes each store write fail with probability p, between
+// 0 and 1, before it changes anything.
+func (f *Injector) SetStoreFailRate(p float64) error {
+	if p < 0 || p > 1 {
+		return errors.New("fault: probability must be between 0 and 1")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.storeFailRate = p
+	return nil
+}
+
+// Reset clears every failure point.
+func (f *Injector) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dropRate = 0
+	clear(f.partitioned)
+	f.applyDelay = 0
+	f.storeFailRate = 0
+}
+
+// Status returns the failure points as field-value pairs, for DEBUG FAULT
+// STATUS.
+func (f *Injector) Status() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := make([]string, 0, len(f.partitioned))
+	for id := range f.partitioned {
+		ids = append(ids, string(id))
+	}
+	slices.Sort(ids)
+	return []string{
+		"drop", fmt.Sprint(f.dropRate),
+		"partition", strings.Join(ids, ","),
+		"apply-delay", fmt.Sprint(f.applyDelay.Milliseconds()),
+		"store-fail", fmt.Sprint(f.storeFailRate),
+	}
+}
+
+// dropTo reports whether a Raft message to the server id is dropped.
+func (f *Injector) dropTo(id hraft.ServerID) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.partitioned[id] || f.chance(f.dropRate)
+}
+
+// dropFrom reports whether a Raft message from the server id is dropped.
+func (f *Injector) dropFrom(id hraft.ServerID) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.partitioned[id]
+}
+
+func (f *Injector) delay() time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.applyDelay
+}
+
+func (f *Injector) failWrite() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.chance(f.storeFailRate)
+}
+
+// chance reports true with probability p. f.mu must be held.
+func (f *Injector) chance(p float64) bool {
+	return p > 0 && f.rand.Float64() < p
+}
@@ -0,0 +1,26 @@
+package fault
+
+import (
+	"time"
+
+	hraft "github.com/hashicorp/raft"
+)
+
+// fsm is a Raft FSM whose applies the Injector delays.
+type fsm struct {
+	hraft.FSM
+	f *Injector
+}
+
+// FSM wraps m so that it waits the apply delay before applying each log
+// entry, as a slow disk or a busy node would.
+func (f *Injector) FSM(m hraft.FSM) hraft.FSM {
+	return &fsm{FSM: m, f: f}
+}
+
+func (m *fsm) Apply(log *hraft.Log) any {
+	if d := m.f.delay(); d > 0 {
+		time.Sleep(d)
+	}
+	return m.FSM.Apply(log)
+}
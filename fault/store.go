@@ -0,0 +1,130 @@
+package fault
+
+import (
+	"context"
+	"time"
+
+	"raft-redis-cluster/store"
+)
+
+// storeWrapper is a store whose writes the Injector fails.
+type storeWrapper struct {
+	store.Store
+	f *Injector
+}
+
+// checkpointWrapper is the storeWrapper of a store that keeps its keys
+// across restarts, which it goes on doing.
+type checkpointWrapper struct {
+	*storeWrapper
+	store.Checkpointer
+}
+
+// Store wraps s so that its writes fail with ErrStoreWrite at the store
+// failure rate, leaving it unchanged. Restoring a snapshot never fails, so
+// that a node can always start again.
+func (f *Injector) Store(s store.Store) store.Store {
+	w := &storeWrapper{Store: s, f: f}
+	if ck, ok := s.(store.Checkpointer); ok {
+		return checkpointWrapper{storeWrapper: w, Checkpointer: ck}
+	}
+	return w
+}
+
+func (s *storeWrapper) Put(ctx context.Context, key []byte, value []byte) error {
+	if s.f.failWrite() {
+		return ErrStoreWrite
+	}
+	return s.Store.Put(ctx, key, value)
+}
+
+func (s *storeWrapper) Delete(ctx context.Context, key []byte) error {
+	if s.f.failWrite() {
+		return ErrStoreWrite
+	}
+	return s.Store.Delete(ctx, key)
+}
+
+func (s *storeWrapper) Expire(ctx context.Context, key []byte, at time.Time) error {
+	if s.f.failWrite() {
+		return ErrStoreWrite
+	}
+	return s.Store.Expire(ctx, key, at)
+}
+
+func (s *storeWrapper) Persist(ctx context.Context, key []byte) error {
+	if s.f.failWrite() {
+		return ErrStoreWrite
+	}
+	return s.Store.Persist(ctx, key)
+}
+
+func (s *storeWrapper) Flush(ctx context.Context) error {
+	if s.f.failWrite() {
+		return ErrStoreWrite
+	}
+	return s.Store.Flush(ctx)
+}
+
+func (s *storeWrapper) RestoreKey(ctx context.Context, key []byte, data []byte) error {
+	if s.f.failWrite() {
+		return ErrStoreWrite
+	}
+	return s.Store.RestoreKey(ctx, key, data)
+}
+
+func (s *storeWrapper) Txn(ctx context.Context, fn func(ctx context.Context, txn store.Txn) error) error {
+	if s.f.failWrite() {
+		return ErrStoreWrite
+	}
+	return s.Store.Txn(ctx, fn)
+}
+
+func (s *storeWrapper) HSet(ctx context.Context, key []byte, fields map[string][]byte) (int, error) {
+	if s.f.failWrite() {
+		return 0, ErrStoreWrite
+	}
+	return s.Store.HSet(ctx, key, fields)
+}
+
+func (s *storeWrapper) HDel(ctx context.Context, key []byte, fields [][]byte) (int, error) {
+	if s.f.failWrite() {
+		return 0, ErrStoreWrite
+	}
+	return s.Store.HDel(ctx, key, fields)
+}
+
+func (s *storeWrapper) SAdd(ctx context.Context, key []byte, members [][]byte) (int, error) {
+	if s.f.failWrite() {
+		return 0, ErrStoreWrite
+	}
+	return s.Store.SAdd(ctx, key, members)
+}
+
+func (s *storeWrapper) SRem(ctx context.Context, key []byte, members [][]byte) (int, error) {
+	if s.f.failWrite() {
+		return 0, ErrStoreWrite
+	}
+	return s.Store.SRem(ctx, key, members)
+}
+
+func (s *storeWrapper) ZAdd(ctx context.Context, key []byte, members []store.ZMember) (int, error) {
+	if s.f.failWrite() {
+		return 0, ErrStoreWrite
+	}
+	return s.Store.ZAdd(ctx, key, members)
+}
+
+func (s *storeWrapper) ZRem(ctx context.Context, key []byte, members [][]byte) (int, error) {
+	if s.f.failWrite() {
+		return 0, ErrStoreWrite
+	}
+	return s.Store.ZRem(ctx, key, members)
+}
+
+func (s *storeWrapper) XAdd(ctx context.Context, key []byte, id store.StreamID, fields [][]byte) error {
+	if s.f.failWrite() {
+		return ErrStoreWrite
+	}
+	return s.Store.XAdd(ctx, key, id, fields)
+}
@@ -0,0 +1,128 @@
+package fault
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	hraft "github.com/hashicorp/raft"
+)
+
+// transport is a Raft transport whose messages the Injector drops.
+type transport struct {
+	hraft.Transport
+	f *Injector
+
+	consumer chan hraft.RPC
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Transport wraps tr so that the Raft messages to the partitioned servers
+// and, at the drop rate, to any server fail with ErrDropped, and those from
+// the partitioned servers are refused. AppendEntries are never pipelined
+// over it, so that each one goes through the Injector. tr must support
+// pre-votes, as the transports of the nodes do.
+func (f *Injector) Transport(tr hraft.Transport) hraft.Transport {
+	t := &transport{Transport: tr, f: f, consumer: make(chan hraft.RPC), stop: make(chan struct{})}
+	go t.relay()
+	return t
+}
+
+// relay passes the RPCs received by the transport on to Raft, unless their
+// sender is partitioned.
+func (t *transport) relay() {
+	in := t.Transport.Consumer()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case rpc := <-in:
+			if t.refused(rpc) {
+				rpc.Respond(nil, ErrDropped)
+				continue
+			}
+			select {
+			case t.consumer <- rpc:
+			case <-t.stop:
+				return
+			}
+		}
+	}
+}
+
+// refused reports whether rpc comes from a partitioned server.
+func (t *transport) refused(rpc hraft.RPC) bool {
+	h, ok := rpc.Command.(hraft.WithRPCHeader)
+	return ok && t.f.dropFrom(hraft.ServerID(h.GetRPCHeader().ID))
+}
+
+func (t *transport) Consumer() <-chan hraft.RPC {
+	return t.consumer
+}
+
+func (t *transport) SetHeartbeatHandler(cb func(rpc hraft.RPC)) {
+	if cb == nil {
+		t.Transport.SetHeartbeatHandler(nil)
+		return
+	}
+	t.Transport.SetHeartbeatHandler(func(rpc hraft.RPC) {
+		if t.refused(rpc) {
+			rpc.Respond(nil, ErrDropped)
+			return
+		}
+		cb(rpc)
+	})
+}
+
+func (t *transport) AppendEntriesPipeline(id hraft.ServerID, target hraft.ServerAddress) (hraft.AppendPipeline, error) {
+	return nil, hraft.ErrPipelineReplicationNotSupported
+}
+
+func (t *transport) AppendEntries(id hraft.ServerID, target hraft.ServerAddress, args *hraft.AppendEntriesRequest, resp *hraft.AppendEntriesResponse) error {
+	if t.f.dropTo(id) {
+		return ErrDropped
+	}
+	return t.Transport.AppendEntries(id, target, args, resp)
+}
+
+func (t *transport) RequestVote(id hraft.ServerID, target hraft.ServerAddress, args *hraft.RequestVoteRequest, resp *hraft.RequestVoteResponse) error {
+	if t.f.dropTo(id) {
+		return ErrDropped
+	}
+	return t.Transport.RequestVote(id, target, args, resp)
+}
+
+func (t *transport) RequestPreVote(id hraft.ServerID, target hraft.ServerAddress, args *hraft.RequestPreVoteRequest, resp *hraft.RequestPreVoteResponse) error {
+	pv, ok := t.Transport.(hraft.WithPreVote)
+	if !ok {
+		return errors.New("fault: transport doesn't support pre-votes")
+	}
+	if t.f.dropTo(id) {
+		return ErrDropped
+	}
+	return pv.RequestPreVote(id, target, args, resp)
+}
+
+func (t *transport) InstallSnapshot(id hraft.ServerID, target hraft.ServerAddress, args *hraft.InstallSnapshotRequest, resp *hraft.InstallSnapshotResponse, data io.Reader) error {
+	if t.f.dropTo(id) {
+		return ErrDropped
+	}
+	return t.Transport.InstallSnapshot(id, target, args, resp, data)
+}
+
+func (t *transport) TimeoutNow(id hraft.ServerID, target hraft.ServerAddress, args *hraft.TimeoutNowRequest, resp *hraft.TimeoutNowResponse) error {
+	if t.f.dropTo(id) {
+		return ErrDropped
+	}
+	return t.Transport.TimeoutNow(id, target, args, resp)
+}
+
+// Close stops relaying and closes the wrapped transport.
+func (t *transport) Close() error {
+	t.stopOnce.Do(func() { close(t.stop) })
+	if c, ok := t.Transport.(hraft.WithClose); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+// Package glob implements the glob-style patterns of Redis, as used by KEYS,
+// SCAN MATCH, CONFIG GET and ACL key patterns.
+package glob
+
+// Match reports whether str matches the Redis glob-style pattern.
+// It supports '*', '?', character classes such as [abc], [^a] and [a-z],
+// and '\' to escape the next character.
+func Match(pattern, str []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if Match(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			str = str[1:]
+
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			var ok bool
+			pattern, ok = matchClass(pattern[1:], str[0])
+			if !ok {
+				return false
+			}
+			str = str[1:]
+			continue
+
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+			str = str[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(str) == 0
+}
+
+// matchClass matches c against the character class at the head of pattern
+// (just after '[') and returns the pattern following the closing ']'.
+func matchClass(pattern []byte, c byte) ([]byte, bool) {
+	not := len(pattern) > 0 && pattern[0] == '^'
+	if not {
+		pattern = pattern[1:]
+	}
+
+	match := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) >= 2:
+			if pattern[1] == c {
+				match = true
+			}
+			pattern = pattern[2:]
+		case len(pattern) >= 3 && pattern[1] == '-' && pattern[2] != ']':
+			lo, hi := pattern[0], pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				match = true
+			}
+			pattern = pattern[3:]
+		default:
+			if pattern[0] == c {
+				match = true
+			}
+			pattern = pattern[1:]
+		}
+	}
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+
+	return pattern, match != not
+}
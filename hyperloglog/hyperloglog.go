@@ -0,0 +1,111 @@
+// Package hyperloglog implements the HyperLogLog sketch used by the PF*
+// commands. The encoding is deterministic so that replicas applying the same
+// PFADD produce byte-identical values.
+package hyperloglog
+
+import (
+	"bytes"
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+const (
+	// precision is the number of hash bits used to select a register.
+	precision = 14
+	registers = 1 << precision
+	version   = 1
+)
+
+var magic = []byte("HYLL")
+
+var ErrInvalid = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+
+// Sketch is a dense HyperLogLog with one byte per register.
+type Sketch struct {
+	reg [registers]uint8
+}
+
+func New() *Sketch {
+	return &Sketch{}
+}
+
+// Decode parses a sketch previously produced by Bytes.
+func Decode(b []byte) (*Sketch, error) {
+	if len(b) != len(magic)+1+registers || !bytes.HasPrefix(b, magic) || b[len(magic)] != version {
+		return nil, ErrInvalid
+	}
+	s := &Sketch{}
+	copy(s.reg[:], b[len(magic)+1:])
+	return s, nil
+}
+
+// Bytes encodes the sketch so it can be stored as a string value.
+func (s *Sketch) Bytes() []byte {
+	b := make([]byte, 0, len(magic)+1+registers)
+	b = append(b, magic...)
+	b = append(b, version)
+	return append(b, s.reg[:]...)
+}
+
+// Add adds an element and reports whether any register changed.
+func (s *Sketch) Add(elem []byte) bool {
+	h := hash(elem)
+	i := h & (registers - 1)
+	// The remaining bits, with a sentinel so the rank is bounded.
+	w := h>>precision | 1<<(64-precision)
+	rank := uint8(bits.TrailingZeros64(w) + 1)
+
+	if rank > s.reg[i] {
+		s.reg[i] = rank
+		return true
+	}
+	return false
+}
+
+// Merge sets every register to the maximum of s and o.
+func (s *Sketch) Merge(o *Sketch) {
+	for i, r := range o.reg {
+		if r > s.reg[i] {
+			s.reg[i] = r
+		}
+	}
+}
+
+// Count returns the estimated cardinality.
+func (s *Sketch) Count() uint64 {
+	sum := 0.0
+	zeros := 0
+	for _, r := range s.reg {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	m := float64(registers)
+	alpha := 0.7213 / (1 + 1.079/m)
+	est := alpha * m * m / sum
+
+	// Small range correction with linear counting.
+	if est <= 2.5*m && zeros > 0 {
+		est = m * math.Log(m/float64(zeros))
+	}
+	return uint64(est + 0.5)
+}
+
+// hash is FNV-1a followed by the MurmurHash3 finalizer, which gives the
+// well-mixed low bits the register selection depends on.
+func hash(b []byte) uint64 {
+	f := fnv.New64a()
+	f.Write(b)
+	h := f.Sum64()
+
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
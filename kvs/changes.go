@@ -0,0 +1,73 @@
+package kvs
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"raft-redis-cluster/raft"
+)
+
+// ReadChanges returns about count of the changes of the keys of the shard
+// applied by this node after the log index after, all of them when count
+// is negative, and a channel closed once a newer change is applied. It is
+// raft.StateMachine.ReadChanges of the shard.
+func (n *Node) ReadChanges(shard int, after uint64, count int) ([]raft.Change, <-chan struct{}, error) {
+	if shard < 0 || shard >= len(n.shards) {
+		return nil, nil, fmt.Errorf("kvs: no shard %d", shard)
+	}
+	return n.shards[shard].FSM.ReadChanges(after, count)
+}
+
+// Checkpoint returns the log index last committed with SetCheckpoint by the
+// consumer of the changes of the shard named name, 0 when none was.
+func (n *Node) Checkpoint(ctx context.Context, shard int, name string) (uint64, error) {
+	v, err := n.do(ctx, []byte("CDC.COMMITTED"), []byte(name), []byte("SHARD"), []byte(strconv.Itoa(shard)))
+	if err != nil {
+		return 0, err
+	}
+	index, ok := v.(int64)
+	if !ok {
+		return 0, errReply
+	}
+	return uint64(index), nil
+}
+
+// SetCheckpoint commits index as the last change of the shard processed by
+// the consumer named name, through the Raft log of the shard, which this
+// node must lead. The checkpoints are kept apart from the keys.
+func (n *Node) SetCheckpoint(ctx context.Context, shard int, name string, index uint64) error {
+	_, err := n.do(ctx, []byte("CDC.COMMIT"), []byte(name), []byte(strconv.FormatUint(index, 10)), []byte("SHARD"), []byte(strconv.Itoa(shard)))
+	return err
+}
+
+// WatchChanges calls fn with the changes of the keys of the shard applied
+// by this node after the log index after, in log order, until ctx ends or
+// fn returns an error. An after of zero starts from the oldest change kept.
+// Every replica sees the same changes, so a consumer may resume on another
+// node from the Index of the last change it handled.
+//
+// It returns raft.ErrChangesTrimmed when the changes after after are no
+// longer kept, and raft.ErrChangesDisabled unless ChangeBacklog is set.
+func (n *Node) WatchChanges(ctx context.Context, shard int, after uint64, fn func(raft.Change) error) error {
+	for {
+		changes, added, err := n.ReadChanges(shard, after, -1)
+		if err != nil {
+			return err
+		}
+		for _, c := range changes {
+			if err := fn(c); err != nil {
+				return err
+			}
+			after = c.Index
+		}
+		if len(changes) > 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-added:
+		}
+	}
+}
@@ -0,0 +1,381 @@
+// Package kvs runs a node of the cluster inside an application, which reads
+// and writes the keys with the methods of Node instead of talking RESP over
+// loopback. The node still serves Redis clients and the other nodes on its
+// Redis addresses, as the nodes forward commands to each other.
+package kvs
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+
+	hraft "github.com/hashicorp/raft"
+
+	"raft-redis-cluster/cluster"
+	"raft-redis-cluster/encryption"
+	"raft-redis-cluster/fault"
+	"raft-redis-cluster/store"
+	"raft-redis-cluster/tlsconfig"
+	"raft-redis-cluster/transport"
+)
+
+// Peer is another node of the cluster bootstrapped on the first start.
+type Peer struct {
+	NodeID    string
+	RaftAddr  string
+	RedisAddr string
+}
+
+// Config is the configuration of a node.
+type Config struct {
+	// ID is the Raft server ID of the node.
+	ID string
+	// RaftAddr is the TCP host:port of the Raft transport of the first
+	// shard. Shard i listens on its port plus i.
+	RaftAddr string
+	// RedisAddrs are the TCP host:port the node serves Redis clients on.
+	// The other nodes reach it at the first one.
+	RedisAddrs []string
+	// TLS, when set, makes Redis clients connect over TLS.
+	TLS *tls.Config
+	// DataDir holds the Raft logs and snapshots of the node.
+	DataDir string
+	// Peers are the other nodes of the cluster bootstrapped on the first
+	// start, unless Join is set: then the node waits to be added to a
+	// running cluster with RAFT.ADD or CLUSTER MEET.
+	Peers []Peer
+	Join  bool
+	// Shards is the number of Raft groups the hash slots are split between,
+	// 1 when zero. It must be the same on every node.
+	Shards int
+	// Store is the backend of the key space, one of store.Backends: memory
+	// when empty. A store on disk keeps its keys across Stop, but not Kill,
+	// and is otherwise rebuilt from the Raft snapshots and log.
+	Store string
+	// StoreFsync makes a Store on disk sync its file on every write rather
+	// than only on Stop, so that a power loss can't corrupt it.
+	StoreFsync bool
+	// NotifyKeyspaceEvents are the keyspace events published, as in the
+	// notify-keyspace-events parameter.
+	NotifyKeyspaceEvents string
+	// SnapshotCompression is the codec of the Raft snapshots: none, the
+	// default, zstd or lz4.
+	SnapshotCompression string
+	// ChangeBacklog is how many of the last changed keys each shard keeps
+	// for WatchChanges and CDC.READ, none when zero.
+	ChangeBacklog int
+	// SnapshotStore, when set, wraps the snapshot store of each shard, such
+	// as to copy the snapshots to object storage.
+	SnapshotStore func(shard int, fss hraft.SnapshotStore) (hraft.SnapshotStore, error)
+	// Encryption, when set, encrypts the Raft logs, snapshots and stable
+	// store of the node, and the values of a store backend on disk, with its
+	// primary key. The
+	// data written before it was set is read only while the keyring allows
+	// plaintext, and is encrypted as it is rewritten.
+	Encryption *encryption.Keyring
+	// RaftTLS enables mutual TLS between the Raft nodes.
+	RaftTLS tlsconfig.Options
+	// RaftTransport, when set, returns the transport of each shard at its
+	// Raft address instead of a TCP one, such as an in-memory one in tests.
+	// RaftTLS is then ignored.
+	RaftTransport func(shard int, addr string) (hraft.Transport, error)
+	// Raft, when set, adjusts the Raft configuration of each shard, such as
+	// its timeouts or its logger.
+	Raft func(*hraft.Config)
+	// Faults, when set, wraps the Raft transport, the FSM and the store of
+	// each shard so that failures can be injected into the node, from tests
+	// or with DEBUG FAULT.
+	Faults *fault.Injector
+	// Params are runtime parameters set on start, by their CONFIG SET names.
+	Params map[string]string
+	// Options are passed to transport.NewRedis.
+	Options []transport.Option
+	// Logger, when set, replaces the logger of the node.
+	Logger *slog.Logger
+
+	// OnLeaderChange, when set, is called as the node becomes or stops
+	// being the leader of a shard.
+	OnLeaderChange func(shard int, leader bool)
+	// OnRaftEvent, when set, is called with the Raft events of the node,
+	// also published on the __raft__:* channels, while it serves.
+	OnRaftEvent func(transport.RaftEvent)
+	// OnMessage, when set, is called with every message published in the
+	// cluster once this node applied it, including the keyspace
+	// notifications of NotifyKeyspaceEvents.
+	OnMessage func(channel, message string)
+}
+
+// Node is a node of the cluster.
+type Node struct {
+	cfg    Config
+	shards []*transport.Shard
+	redis  *transport.Redis
+	// closers are the stores of the shards, closed once their Raft groups
+	// stopped.
+	closers []io.Closer
+
+	mu      sync.Mutex
+	started bool
+	stopped chan struct{}
+	// done is closed once the node stopped serving, with err.
+	done chan struct{}
+	err  error
+}
+
+// NewNode starts the Raft groups of a node, which joins its cluster at
+// once. Start serves its clients.
+func NewNode(cfg Config) (*Node, error) {
+	switch {
+	case cfg.ID == "":
+		return nil, errors.New("kvs: ID is required")
+	case cfg.RaftAddr == "":
+		return nil, errors.New("kvs: RaftAddr is required")
+	case len(cfg.RedisAddrs) == 0:
+		return nil, errors.New("kvs: RedisAddrs is required")
+	case cfg.DataDir == "":
+		return nil, errors.New("kvs: DataDir is required")
+	}
+	if cfg.Shards == 0 {
+		cfg.Shards = 1
+	}
+	if cfg.Shards < 1 || cfg.Shards > cluster.MaxShards {
+		return nil, fmt.Errorf("kvs: Shards must be between 1 and %d", cluster.MaxShards)
+	}
+	if cfg.Store == "" {
+		cfg.Store = "memory"
+	}
+	if cfg.SnapshotCompression == "" {
+		cfg.SnapshotCompression = "none"
+	}
+
+	n := &Node{cfg: cfg, stopped: make(chan struct{}), done: make(chan struct{})}
+	if err := n.open(); err != nil {
+		n.shutdownRaft(false)
+		return nil, err
+	}
+	return n, nil
+}
+
+func (n *Node) open() error {
+	var sdb hraft.StableStore
+	for i := range n.cfg.Shards {
+		sh, s, err := n.newShard(i)
+		if err != nil {
+			return err
+		}
+		n.shards = append(n.shards, sh)
+		// The Redis addresses of the nodes are kept in the stable store of
+		// the first shard.
+		if i == 0 {
+			sdb = s
+		}
+	}
+	// The node's own address is returned by CLUSTER SLOTS and the like.
+	if err := store.SetRedisAddrByNodeID(sdb, hraft.ServerID(n.cfg.ID), n.cfg.RedisAddrs[0]); err != nil {
+		return err
+	}
+	// The addresses of the nodes added at runtime are replicated in the log
+	// of the first shard and written to sdb.
+	if err := n.shards[0].FSM.SetNodeRegistry(sdb); err != nil {
+		return err
+	}
+
+	opts := n.cfg.Options
+	if n.cfg.Faults != nil {
+		opts = append([]transport.Option{transport.WithFaults(n.cfg.Faults)}, opts...)
+	}
+	if n.cfg.OnRaftEvent != nil {
+		opts = append([]transport.Option{transport.WithRaftEvents(n.cfg.OnRaftEvent)}, opts...)
+	}
+	n.redis = transport.NewRedis(hraft.ServerID(n.cfg.ID), n.shards, sdb, opts...)
+	for i, sh := range n.shards {
+		sh.FSM.AddPublisher(n.redis)
+		sh.FSM.AddInvalidator(n.redis)
+		sh.FSM.SetTxReader(n.redis.TxReader(i))
+		sh.FSM.SetCommandRunner(n.redis.CommandRunner(i))
+		if n.cfg.OnMessage != nil {
+			sh.FSM.AddPublisher(publisherFunc(n.cfg.OnMessage))
+		}
+		if n.cfg.OnLeaderChange != nil {
+			n.observeLeader(i, sh)
+		}
+	}
+	if n.cfg.Logger != nil {
+		n.redis.SetLogger(n.cfg.Logger)
+	}
+	for _, name := range slices.Sorted(maps.Keys(n.cfg.Params)) {
+		if err := n.redis.Config().Set(name, n.cfg.Params[name]); err != nil {
+			return fmt.Errorf("kvs: parameter %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// publisherFunc is the Publisher of OnMessage.
+type publisherFunc func(channel, message string)
+
+func (f publisherFunc) Publish(channel, message string) int {
+	f(channel, message)
+	return 0
+}
+
+// observeLeader calls OnLeaderChange when the leader of the i-th shard
+// changes from or to this node, until Stop.
+func (n *Node) observeLeader(i int, sh *transport.Shard) {
+	ch := make(chan hraft.Observation, 16)
+	o := hraft.NewObserver(ch, false, func(o *hraft.Observation) bool {
+		_, ok := o.Data.(hraft.LeaderObservation)
+		return ok
+	})
+	sh.Raft.RegisterObserver(o)
+
+	go func() {
+		defer sh.Raft.DeregisterObserver(o)
+		leader := false
+		for {
+			select {
+			case <-n.stopped:
+				return
+			case obs := <-ch:
+				l := obs.Data.(hraft.LeaderObservation).LeaderID == hraft.ServerID(n.cfg.ID)
+				if l != leader {
+					leader = l
+					n.cfg.OnLeaderChange(i, l)
+				}
+			}
+		}
+	}()
+}
+
+// Leader returns the ID of the leader of the shard as this node knows it,
+// or "" while the shard has none.
+func (n *Node) Leader(shard int) string {
+	_, id := n.shards[shard].Raft.LeaderWithID()
+	return string(id)
+}
+
+// Redis returns the server of the node, to change its runtime parameters
+// with Config or to serve the admin APIs.
+func (n *Node) Redis() *transport.Redis {
+	return n.redis
+}
+
+// Start listens on RedisAddrs and serves the clients in the background
+// until Stop.
+func (n *Node) Start() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.started {
+		return errors.New("kvs: node already started")
+	}
+	select {
+	case <-n.stopped:
+		return transport.ErrServerClosed
+	default:
+	}
+
+	lns, err := transport.Listen(n.cfg.TLS, n.cfg.RedisAddrs...)
+	if err != nil {
+		return err
+	}
+	n.started = true
+	go func() {
+		err := n.redis.ServeListeners(lns...)
+		if !errors.Is(err, transport.ErrServerClosed) {
+			n.err = err
+		}
+		close(n.done)
+	}()
+	return nil
+}
+
+// Wait waits for the node started with Start to stop serving, and returns
+// the error that stopped it, or nil after Stop.
+func (n *Node) Wait() error {
+	<-n.done
+	return n.err
+}
+
+// Stop shuts the node down gracefully with Shutdown of its server, which
+// also hands the leadership of its shards over, and stops its Raft groups.
+// If ctx ends first, the remaining clients are disconnected at once.
+func (n *Node) Stop(ctx context.Context) error {
+	return n.stop(func() error { return n.redis.Shutdown(ctx) }, true)
+}
+
+// Kill stops the node at once, as a crash would: its clients are
+// disconnected and its Raft groups stop without handing their leadership
+// over. What it wrote to DataDir is kept, so that a new Node on it
+// restarts it.
+func (n *Node) Kill() error {
+	return n.stop(func() error { return n.redis.Kill() }, false)
+}
+
+func (n *Node) stop(shutdown func() error, checkpoint bool) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	select {
+	case <-n.stopped:
+		return transport.ErrServerClosed
+	default:
+	}
+	close(n.stopped)
+
+	var errs []error
+	if err := shutdown(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := n.shutdownRaft(checkpoint); err != nil {
+		errs = append(errs, err)
+	}
+	if n.started {
+		<-n.done
+	} else {
+		close(n.done)
+	}
+	return errors.Join(errs...)
+}
+
+// shutdownRaft stops the Raft groups of the shards and closes their stores.
+// With checkpoint, each shard first takes a snapshot, so that a store
+// keeping its keys on disk is found up to date with it on the next start
+// and the snapshot is not written to it again.
+func (n *Node) shutdownRaft(checkpoint bool) error {
+	var errs []error
+	for _, sh := range n.shards {
+		if checkpoint {
+			err := sh.Raft.Snapshot().Error()
+			if err != nil && !errors.Is(err, hraft.ErrNothingNewToSnapshot) {
+				errs = append(errs, err)
+			}
+		}
+		if err := sh.Raft.Shutdown().Error(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if checkpoint {
+			if err := sh.FSM.CheckpointStore(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	for _, c := range slices.Backward(n.closers) {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RegisterCommand adds c to the commands the nodes of the application serve,
+// to their clients and to Do. It is transport.RegisterCommand, and must be
+// called before any node is created.
+func RegisterCommand(c transport.Command) error {
+	return transport.RegisterCommand(c)
+}
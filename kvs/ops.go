@@ -0,0 +1,100 @@
+package kvs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"github.com/tidwall/redcon"
+)
+
+// ErrNotFound is returned by Get for a key that doesn't exist.
+var ErrNotFound = errors.New("kvs: key not found")
+
+var errReply = errors.New("kvs: invalid reply")
+
+// Get returns the value of the string key.
+func (n *Node) Get(ctx context.Context, key string) ([]byte, error) {
+	v, err := n.do(ctx, []byte("GET"), []byte(key))
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, ErrNotFound
+	}
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, errReply
+	}
+	return b, nil
+}
+
+// Set sets the string key to value, through the Raft log of its shard.
+func (n *Node) Set(ctx context.Context, key string, value []byte) error {
+	_, err := n.do(ctx, []byte("SET"), []byte(key), value)
+	return err
+}
+
+// Del deletes key, through the Raft log of its shard, and reports whether
+// it existed.
+func (n *Node) Del(ctx context.Context, key string) (bool, error) {
+	v, err := n.do(ctx, []byte("DEL"), []byte(key))
+	if err != nil {
+		return false, err
+	}
+	deleted, ok := v.(int64)
+	if !ok {
+		return false, errReply
+	}
+	return deleted > 0, nil
+}
+
+// Do runs any command the way a client of the node would, with Do of its
+// server, and returns the reply: a string for a status, an int64, a []byte
+// for a bulk string, nil for a null, or a []any of them. An error reply is
+// returned as an error, but is kept in place in an array. The commands the
+// node can't serve are forwarded to the leader.
+func (n *Node) Do(ctx context.Context, args ...string) (any, error) {
+	b := make([][]byte, len(args))
+	for i, a := range args {
+		b[i] = []byte(a)
+	}
+	return n.do(ctx, b...)
+}
+
+func (n *Node) do(ctx context.Context, args ...[]byte) (any, error) {
+	_, resp := redcon.ReadNextRESP(n.redis.Do(ctx, args...))
+	return replyValue(resp)
+}
+
+// replyValue returns the value of a RESP2 reply.
+func replyValue(resp redcon.RESP) (any, error) {
+	switch resp.Type {
+	case redcon.String:
+		return string(resp.Data), nil
+	case redcon.Error:
+		return nil, errors.New(string(resp.Data))
+	case redcon.Integer:
+		return resp.Int(), nil
+	case redcon.Bulk:
+		if resp.Data == nil {
+			return nil, nil
+		}
+		return bytes.Clone(resp.Data), nil
+	case redcon.Array:
+		if resp.Count < 0 {
+			return nil, nil
+		}
+		vals := make([]any, 0, resp.Count)
+		resp.ForEach(func(e redcon.RESP) bool {
+			v, err := replyValue(e)
+			if err != nil {
+				v = err
+			}
+			vals = append(vals, v)
+			return true
+		})
+		return vals, nil
+	}
+	return nil, errReply
+}
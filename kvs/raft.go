@@ -0,0 +1,230 @@
+package kvs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	hraft "github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+
+	"raft-redis-cluster/cluster"
+	"raft-redis-cluster/raft"
+	"raft-redis-cluster/store"
+	"raft-redis-cluster/tlsconfig"
+	"raft-redis-cluster/transport"
+)
+
+// SnapshotRetainCount is how many snapshots each shard keeps on disk.
+const SnapshotRetainCount = 2
+
+// ShardDir returns the directory of the Raft data of the i-th shard under
+// dataDir: dataDir itself for the first one and dataDir/shard<i> for the
+// others.
+func ShardDir(dataDir string, i int) string {
+	if i == 0 {
+		return dataDir
+	}
+	return filepath.Join(dataDir, fmt.Sprintf("shard%d", i))
+}
+
+// newShard starts the Raft group of the i-th shard. The first shard uses
+// DataDir and RaftAddr as they are, the others ShardDir and the port of
+// RaftAddr plus i, and so do the peers. The stores it opens are added to
+// the closers of n.
+func (n *Node) newShard(i int) (*transport.Shard, hraft.StableStore, error) {
+	c := &n.cfg
+	dir, addr := c.DataDir, c.RaftAddr
+	peers := c.Peers
+	if i > 0 {
+		dir = ShardDir(c.DataDir, i)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, nil, err
+		}
+
+		var err error
+		addr, err = cluster.ShardAddr(c.RaftAddr, i)
+		if err != nil {
+			return nil, nil, err
+		}
+		peers = make([]Peer, len(c.Peers))
+		for j, p := range c.Peers {
+			p.RaftAddr, err = cluster.ShardAddr(p.RaftAddr, i)
+			if err != nil {
+				return nil, nil, err
+			}
+			peers[j] = p
+		}
+	}
+
+	opts := store.Options{Fsync: c.StoreFsync}
+	if c.Encryption != nil {
+		opts.Cipher = c.Encryption
+	}
+	datastore, err := store.Open(c.Store, filepath.Join(dir, "store"), opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cl, ok := datastore.(io.Closer); ok {
+		n.closers = append(n.closers, cl)
+	}
+	ck, _ := datastore.(store.Checkpointer)
+	if c.Faults != nil {
+		datastore = c.Faults.Store(datastore)
+	}
+	st := raft.NewStateMachine(datastore)
+	if err := st.SetNotifyKeyspaceEvents(c.NotifyKeyspaceEvents); err != nil {
+		return nil, nil, err
+	}
+	if err := st.SetSnapshotCompression(c.SnapshotCompression); err != nil {
+		return nil, nil, err
+	}
+	if err := st.SetChangeBacklog(c.ChangeBacklog); err != nil {
+		return nil, nil, err
+	}
+	r, sdb, err := n.newRaft(dir, i, addr, st, ck, peers)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &transport.Shard{Raft: r, FSM: st, Store: datastore}, sdb, nil
+}
+
+// newRaft starts the Raft node of the shard-th shard, with its logs and
+// snapshots in baseDir. Unless Join is set, it bootstraps a cluster of
+// itself and peers, which fails harmlessly on a restart. ck is the store of
+// the shard when it keeps its keys across restarts.
+func (n *Node) newRaft(baseDir string, shard int, address string, fsm hraft.FSM, ck store.Checkpointer, peers []Peer) (*hraft.Raft, hraft.StableStore, error) {
+	c := &n.cfg
+	rc := hraft.DefaultConfig()
+	rc.LocalID = hraft.ServerID(c.ID)
+	if c.Raft != nil {
+		c.Raft(rc)
+	}
+
+	ldb, err := raftboltdb.NewBoltStore(filepath.Join(baseDir, "logs.dat"))
+	if err != nil {
+		return nil, nil, err
+	}
+	n.closers = append(n.closers, ldb)
+	var logs hraft.LogStore = ldb
+	if c.Encryption != nil {
+		logs = raft.NewEncryptedLogStore(ldb, c.Encryption)
+	}
+
+	bdb, err := raftboltdb.NewBoltStore(filepath.Join(baseDir, "stable.dat"))
+	if err != nil {
+		return nil, nil, err
+	}
+	n.closers = append(n.closers, bdb)
+	var sdb hraft.StableStore = bdb
+	if c.Encryption != nil {
+		sdb = raft.NewEncryptedStableStore(bdb, c.Encryption)
+	}
+
+	var fss hraft.SnapshotStore
+	fss, err = hraft.NewFileSnapshotStore(baseDir, SnapshotRetainCount, os.Stderr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.SnapshotStore != nil {
+		if fss, err = c.SnapshotStore(shard, fss); err != nil {
+			return nil, nil, err
+		}
+	}
+	if c.Encryption != nil {
+		fss = raft.NewEncryptedSnapshotStore(fss, c.Encryption)
+	}
+	// With no snapshot to restore, the whole log is replayed on an empty
+	// store.
+	if ck != nil {
+		snaps, err := fss.List()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(snaps) == 0 {
+			if _, err := ck.Resume(0); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	var tm hraft.Transport
+	if c.RaftTransport != nil {
+		tm, err = c.RaftTransport(shard, address)
+	} else {
+		tm, err = newRaftTransport(address, c.RaftTLS)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if c.Faults != nil {
+		fsm = c.Faults.FSM(fsm)
+		tm = c.Faults.Transport(tm)
+	}
+
+	r, err := hraft.NewRaft(rc, fsm, logs, sdb, fss, tm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg := hraft.Configuration{
+		Servers: []hraft.Server{
+			{
+				Suffrage: hraft.Voter,
+				ID:       hraft.ServerID(c.ID),
+				Address:  hraft.ServerAddress(address),
+			},
+		},
+	}
+
+	for _, peer := range peers {
+		sid := hraft.ServerID(peer.NodeID)
+		cfg.Servers = append(cfg.Servers, hraft.Server{
+			Suffrage: hraft.Voter,
+			ID:       sid,
+			Address:  hraft.ServerAddress(peer.RaftAddr),
+		})
+
+		err := store.SetRedisAddrByNodeID(sdb, sid, peer.RedisAddr)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if c.Join {
+		return r, sdb, nil
+	}
+	f := r.BootstrapCluster(cfg)
+	if err := f.Error(); err != nil && !errors.Is(err, hraft.ErrCantBootstrap) {
+		return nil, nil, err
+	}
+
+	return r, sdb, nil
+}
+
+// newRaftTransport returns the TCP transport between the Raft nodes, over
+// mutual TLS when tlsOpts has a certificate.
+func newRaftTransport(address string, tlsOpts tlsconfig.Options) (hraft.Transport, error) {
+	advertise, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if !tlsOpts.Enabled() {
+		return hraft.NewTCPTransport(address, advertise, 10, time.Second*10, os.Stderr)
+	}
+
+	server, err := tlsOpts.Server()
+	if err != nil {
+		return nil, err
+	}
+	client, err := tlsOpts.Client()
+	if err != nil {
+		return nil, err
+	}
+	return raft.NewTLSTransport(address, advertise, server, client, 10, time.Second*10, os.Stderr)
+}
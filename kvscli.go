@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"raft-redis-cluster/client"
+	"raft-redis-cluster/tlsconfig"
+)
+
+// kvscliHelp は、kvscli の help で表示する説明
+const kvscliHelp = `Commands are sent to the connected node and follow MOVED and ASK redirects
+to the leader of their key. Besides the Redis commands:
+  status                        Raft state of the node and of its shards (INFO raft)
+  nodes                         nodes of the cluster (CLUSTER NODES)
+  join <id> <raft-addr> <redis-addr> [ADVERTISE addr] [NONVOTER] [SHARD i]
+                                add a server to the cluster (RAFT.ADD)
+  remove <id> [SHARD i]         remove a server from the cluster (RAFT.REMOVE)
+  snapshot [shard]              take a snapshot on the node (RAFT.SNAPSHOT)
+  check [shard]                 compare the keyspace digests of the nodes at the same
+                                log index, to find diverged replicas (RAFT.DIGEST)
+  connect <host:port>           send the next commands to another node
+  help                          show this help
+  quit                          leave`
+
+var errKvscliReply = errors.New("unexpected reply")
+
+// kvscli は、対話的なクライアントの状態
+type kvscli struct {
+	c       *client.Client
+	addr    string // コマンドを送るノード
+	timeout time.Duration
+	out     io.Writer
+}
+
+// kvscliCmd は、クラスタに対応した対話的なクライアントを実行する
+// 引数にコマンドがあれば、それだけを実行して終了する
+func kvscliCmd(args []string) error {
+	fs := flag.NewFlagSet("kvscli", flag.ExitOnError)
+	clientOptions := clientFlags(fs)
+	fs.Parse(args)
+	opts, err := clientOptions()
+	if err != nil {
+		return err
+	}
+	c, err := client.New(opts)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	k := &kvscli{c: c, addr: opts.Addrs[0], timeout: opts.Timeout, out: os.Stdout}
+	if fs.NArg() > 0 {
+		return k.run(fs.Args())
+	}
+	return k.repl(os.Stdin)
+}
+
+// clientFlags は、クラスタに接続するサブコマンドに共通のフラグを fs に定義する
+// 返す関数は、fs.Parse の後にフラグからクライアントの設定を作る
+func clientFlags(fs *flag.FlagSet) func() (client.Options, error) {
+	addrs := fs.String("redis_address", "localhost:6379", "TCP host+port of the node to connect to, or a comma-separated list of nodes to find the cluster from; kvscli sends its commands to the first")
+	username := fs.String("username", "", "User to AUTH as")
+	password := fs.String("password", "", "Password to AUTH with; read from REDISCLI_AUTH when empty")
+	useTLS := fs.Bool("tls", false, "Connect over TLS")
+	var tlsOpts tlsconfig.Options
+	fs.StringVar(&tlsOpts.CAFile, "tls_ca_cert_file", "", "CA certificate file that signs the certificates of the nodes; the CAs of the system when empty")
+	fs.StringVar(&tlsOpts.CertFile, "tls_cert_file", "", "Certificate file presented to nodes that require one")
+	fs.StringVar(&tlsOpts.KeyFile, "tls_key_file", "", "Private key file of --tls_cert_file")
+	timeout := fs.Duration("timeout", 5*time.Second, "Time a command waits for its reply, redirects and retries included")
+
+	return func() (client.Options, error) {
+		opts := client.Options{Addrs: strings.Split(*addrs, ","), Username: *username, Password: *password, Timeout: *timeout}
+		if opts.Password == "" {
+			opts.Password = os.Getenv("REDISCLI_AUTH")
+		}
+		if *useTLS || tlsOpts.CAFile != "" || tlsOpts.CertFile != "" {
+			var err error
+			if opts.TLS, err = tlsOpts.Client(); err != nil {
+				return opts, err
+			}
+		}
+		return opts, nil
+	}
+}
+
+// repl は、1 行に 1 つずつコマンドを読んで実行する
+// 端末から読む場合だけ、接続先のノードをプロンプトに表示する
+func (k *kvscli) repl(in *os.File) error {
+	interactive := false
+	if fi, err := in.Stat(); err == nil {
+		interactive = fi.Mode()&os.ModeCharDevice != 0
+	}
+	sc := bufio.NewScanner(in)
+	sc.Buffer(nil, 64<<20)
+	for {
+		if interactive {
+			fmt.Fprintf(k.out, "%s> ", k.addr)
+		}
+		if !sc.Scan() {
+			return sc.Err()
+		}
+		args, err := splitArgs(sc.Text())
+		if err != nil {
+			fmt.Fprintf(k.out, "(error) %v\n", err)
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if verb := strings.ToLower(args[0]); verb == "quit" || verb == "exit" {
+			return nil
+		}
+		if err := k.run(args); err != nil {
+			fmt.Fprintf(k.out, "(error) %v\n", err)
+		}
+	}
+}
+
+// run は、管理用のコマンドか Redis のコマンドを 1 つ実行して応答を表示する
+// エラーの応答は表示せずに返す
+func (k *kvscli) run(args []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), k.timeout)
+	defer cancel()
+
+	switch strings.ToLower(args[0]) {
+	case "help":
+		fmt.Fprintln(k.out, kvscliHelp)
+		return nil
+	case "connect":
+		if len(args) != 2 {
+			return errors.New("usage: connect <host:port>")
+		}
+		if _, _, err := net.SplitHostPort(args[1]); err != nil {
+			return err
+		}
+		k.addr = args[1]
+		return nil
+	case "status":
+		info, err := k.bulk(ctx, "INFO", "raft")
+		if err != nil {
+			return err
+		}
+		k.printStatus(info)
+		return nil
+	case "nodes":
+		nodes, err := k.bulk(ctx, "CLUSTER", "NODES")
+		if err != nil {
+			return err
+		}
+		k.printNodes(nodes)
+		return nil
+	case "check":
+		return k.check(ctx, args[1:])
+	case "join":
+		args = append([]string{"RAFT.ADD"}, args[1:]...)
+	case "remove":
+		args = append([]string{"RAFT.REMOVE"}, args[1:]...)
+	case "snapshot":
+		args = append([]string{"RAFT.SNAPSHOT"}, args[1:]...)
+	}
+
+	v, err := k.c.DoNode(ctx, k.addr, args...)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(k.out, formatReply(v, 0))
+	return nil
+}
+
+// check は、シャードのリーダーに RAFT.DIGEST でキー空間のダイジェストを取らせ、
+// 各ノードが同じインデックスで計算したダイジェストと比べる
+func (k *kvscli) check(ctx context.Context, args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: check [shard]")
+	}
+	v, err := k.c.DoNode(ctx, k.addr, append([]string{"RAFT.DIGEST"}, args...)...)
+	if err != nil {
+		return err
+	}
+	index, want, _, err := parseDigest(v)
+	if err != nil {
+		return err
+	}
+	nodes, err := k.bulk(ctx, "CLUSTER", "NODES")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(k.out, "digest at index %d\n", index)
+	w := tabwriter.NewWriter(k.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ADDRESS\tKEYS\tDIGEST\tSTATUS")
+	diverged := 0
+	for _, line := range strings.Split(nodes, "\n") {
+		f := strings.Fields(line)
+		if len(f) < 2 {
+			continue
+		}
+		addr, _, _ := strings.Cut(f[1], "@")
+		v, err := k.c.DoNode(ctx, addr, append([]string{"RAFT.DIGEST", "AT", strconv.FormatUint(index, 10)}, args...)...)
+		if err != nil {
+			fmt.Fprintf(w, "%s\t\t\t%v\n", addr, err)
+			continue
+		}
+		_, digest, keys, err := parseDigest(v)
+		if err != nil {
+			fmt.Fprintf(w, "%s\t\t\t%v\n", addr, err)
+			continue
+		}
+		status := "ok"
+		if digest != want {
+			status = "DIVERGED"
+			diverged++
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", addr, keys, digest, status)
+	}
+	w.Flush()
+	if diverged > 0 {
+		return fmt.Errorf("%d nodes diverged from the leader", diverged)
+	}
+	return nil
+}
+
+// parseDigest は、RAFT.DIGEST の応答をインデックス、ダイジェスト、キーの数に分ける
+func parseDigest(v any) (index uint64, digest string, keys int64, err error) {
+	a, ok := v.([]any)
+	if !ok || len(a) != 3 {
+		return 0, "", 0, errKvscliReply
+	}
+	i, ok1 := a[0].(int64)
+	d, ok2 := a[1].([]byte)
+	n, ok3 := a[2].(int64)
+	if !ok1 || !ok2 || !ok3 {
+		return 0, "", 0, errKvscliReply
+	}
+	return uint64(i), string(d), n, nil
+}
+
+// bulk は、バルク文字列を返すコマンドを接続先のノードで実行する
+func (k *kvscli) bulk(ctx context.Context, args ...string) (string, error) {
+	v, err := k.c.DoNode(ctx, k.addr, args...)
+	if err != nil {
+		return "", err
+	}
+	b, ok := v.([]byte)
+	if !ok {
+		return "", errKvscliReply
+	}
+	return string(b), nil
+}
+
+// printStatus は、INFO raft の応答をノード、ピア、シャードごとにまとめて表示する
+func (k *kvscli) printStatus(info string) {
+	f := infoFields(info)
+	fmt.Fprintf(k.out, "node %s: %s, term %s\n", f["raft_node_id"], f["raft_state"], f["raft_term"])
+	fmt.Fprintf(k.out, "leader %s at %s\n", f["raft_leader_id"], f["raft_leader_address"])
+	fmt.Fprintf(k.out, "log: last %s (term %s), commit %s, applied %s, snapshot %s, fsm pending %s\n",
+		f["raft_last_log_index"], f["raft_last_log_term"], f["raft_commit_index"],
+		f["raft_applied_index"], f["raft_last_snapshot_index"], f["raft_fsm_pending"])
+
+	// ピアは最初のシャードの構成
+	fmt.Fprintln(k.out)
+	w := tabwriter.NewWriter(k.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tADDRESS\tSUFFRAGE\tROLE")
+	n, _ := strconv.Atoi(f["raft_num_peers"])
+	for i := range n {
+		p := infoAttrs(f[fmt.Sprintf("raft_peer%d", i)])
+		role := "follower"
+		if p["id"] == f["raft_leader_id"] {
+			role = "leader"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p["id"], p["address"], p["suffrage"], role)
+	}
+	w.Flush()
+
+	fmt.Fprintln(k.out)
+	w = tabwriter.NewWriter(k.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SHARD\tSTATE\tLEADER\tAPPLIED\tPENDING\tBACKLOG\tSLOTS")
+	n, _ = strconv.Atoi(f["raft_num_shards"])
+	for i := range n {
+		s := infoAttrs(f[fmt.Sprintf("raft_shard%d", i)])
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			i, s["state"], s["leader_id"], s["applied_index"], s["pending_writes"], s["apply_backlog"], s["slots"])
+	}
+	w.Flush()
+	if m := f["raft_migrating_slots"]; m != "" && m != "0" {
+		fmt.Fprintf(k.out, "\n%s slots migrating\n", m)
+	}
+}
+
+// printNodes は、CLUSTER NODES の応答を表にして表示する
+func (k *kvscli) printNodes(nodes string) {
+	w := tabwriter.NewWriter(k.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tADDRESS\tFLAGS\tMASTER\tSLOTS")
+	for _, line := range strings.Split(nodes, "\n") {
+		f := strings.Fields(line)
+		if len(f) < 8 {
+			continue
+		}
+		addr, _, _ := strings.Cut(f[1], "@")
+		master := f[3]
+		if master == "-" {
+			master = ""
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", f[0], addr, f[2], master, strings.Join(f[8:], " "))
+	}
+	w.Flush()
+}
+
+// infoFields は、INFO の応答を項目名から値への map にする
+func infoFields(info string) map[string]string {
+	m := map[string]string{}
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if k, v, ok := strings.Cut(line, ":"); ok {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// infoAttrs は、raft_peer0 などの key=value をカンマで区切った値を map にする
+func infoAttrs(s string) map[string]string {
+	m := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// formatReply は、応答を redis-cli と同じ形式で文字列にする
+// 配列の要素は番号を付けて、入れ子の配列は indent 桁だけ字下げして並べる
+// 改行を含むバルク文字列 (INFO や CLUSTER NODES の応答) は、引用符を付けずにそのまま表示する
+func formatReply(v any, indent int) string {
+	switch v := v.(type) {
+	case nil:
+		return "(nil)"
+	case string:
+		return v
+	case client.Error:
+		return "(error) " + string(v)
+	case int64:
+		return "(integer) " + strconv.FormatInt(v, 10)
+	case []byte:
+		if bytes.ContainsRune(v, '\n') {
+			return strings.TrimRight(strings.ReplaceAll(string(v), "\r\n", "\n"), "\n")
+		}
+		return strconv.Quote(string(v))
+	case []any:
+		if len(v) == 0 {
+			return "(empty array)"
+		}
+		width := len(strconv.Itoa(len(v)))
+		var b strings.Builder
+		for i, e := range v {
+			if i > 0 {
+				b.WriteString("\n" + strings.Repeat(" ", indent))
+			}
+			fmt.Fprintf(&b, "%*d) %s", width, i+1, formatReply(e, indent+width+2))
+		}
+		return b.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// splitArgs は、コマンドの行を redis-cli と同じように引数に分ける
+// 二重引用符の中では \n、\r、\t、\", \\ と \xHH のエスケープを、一重引用符の中では \' だけを解釈する
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	for i := 0; ; {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var b strings.Builder
+		var quote byte
+		for ; i < len(line); i++ {
+			c := line[i]
+			switch {
+			case quote == 0 && (c == ' ' || c == '\t'):
+			case quote == 0 && (c == '"' || c == '\''):
+				quote = c
+				continue
+			case quote == 0:
+				b.WriteByte(c)
+				continue
+			case c == quote:
+				quote = 0
+				// 閉じる引用符の後には空白が要る
+				if i+1 < len(line) && line[i+1] != ' ' && line[i+1] != '\t' {
+					return nil, errors.New("closing quote must be followed by a space")
+				}
+				continue
+			case c == '\\' && i+1 < len(line) && quote == '\'':
+				if line[i+1] == '\'' {
+					i++
+				}
+				b.WriteByte(line[i])
+				continue
+			case c == '\\' && i+1 < len(line):
+				i++
+				switch e := line[i]; e {
+				case 'n':
+					b.WriteByte('\n')
+				case 'r':
+					b.WriteByte('\r')
+				case 't':
+					b.WriteByte('\t')
+				case 'x':
+					if i+2 >= len(line) {
+						return nil, errors.New("invalid \\x escape")
+					}
+					h, err := strconv.ParseUint(line[i+1:i+3], 16, 8)
+					if err != nil {
+						return nil, errors.New("invalid \\x escape")
+					}
+					b.WriteByte(byte(h))
+					i += 2
+				default:
+					b.WriteByte(e)
+				}
+				continue
+			default:
+				b.WriteByte(c)
+				continue
+			}
+			break
+		}
+		if quote != 0 {
+			return nil, errors.New("unbalanced quotes")
+		}
+		args = append(args, b.String())
+	}
+}
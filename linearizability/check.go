@@ -0,0 +1,225 @@
+package linearizability
+
+import (
+	"cmp"
+	"encoding/binary"
+	"math"
+	"slices"
+	"time"
+)
+
+// Result is the outcome of Check.
+type Result int
+
+const (
+	// Ok means every key was linearizable.
+	Ok Result = iota
+	// Illegal means a key was not.
+	Illegal
+	// Unknown means the check timed out before it could tell.
+	Unknown
+)
+
+func (r Result) String() string {
+	switch r {
+	case Ok:
+		return "linearizable"
+	case Illegal:
+		return "not linearizable"
+	}
+	return "unknown"
+}
+
+// Report is the outcome of Check.
+type Report struct {
+	Result Result
+	// Keys is the number of keys checked.
+	Keys int
+	// Key and History are the first key found not linearizable, or left
+	// unchecked by a timeout, and its operations.
+	Key     string
+	History []Operation
+}
+
+// Check checks that the history of each key of ops is linearizable,
+// starting from a missing key, within timeout or without a limit when zero.
+func Check(ops []Operation, timeout time.Duration) Report {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	byKey := map[string][]Operation{}
+	for _, op := range ops {
+		byKey[op.Key] = append(byKey[op.Key], op)
+	}
+	keys := make([]string, 0, len(byKey))
+	for k := range byKey {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		if r := checkKey(byKey[k], deadline); r != Ok {
+			return Report{Result: r, Keys: len(keys), Key: k, History: byKey[k]}
+		}
+	}
+	return Report{Result: Ok, Keys: len(keys)}
+}
+
+// state is the model of a Redis string key.
+type state struct {
+	value  string
+	exists bool
+}
+
+// step applies op to s and reports whether its reply is consistent with s.
+func step(s state, op Operation) (bool, state) {
+	switch op.Kind {
+	case Get:
+		return op.Found == s.exists && (!s.exists || op.Value == s.value), s
+	case Set:
+		return true, state{value: op.Value, exists: true}
+	default:
+		return op.Unknown || op.Found == s.exists, state{}
+	}
+}
+
+// entry is the call or the return of an operation, in a doubly linked list
+// of them in time order.
+type entry struct {
+	op         int
+	call       bool
+	time       int64
+	match      *entry // the return of a call
+	prev, next *entry
+}
+
+// checkKey searches for a linearization of the operations of a key, as
+// Lowe's version of the algorithm of Wing and Gong: it takes the calls in
+// order, linearizing each one it can right away and backtracking when it
+// meets the return of an operation it didn't linearize. A cache of the
+// sets of linearized operations and the states they led to prunes the
+// search.
+func checkKey(ops []Operation, deadline time.Time) Result {
+	head := makeEntries(ops)
+	linearized := make(bitset, (len(ops)+63)/64)
+	cache := map[cacheKey]bool{}
+	type call struct {
+		e *entry
+		s state
+	}
+	var calls []call
+	s := state{}
+	e := head.next
+	for n := 0; head.next != nil; n++ {
+		if n%1024 == 0 && !deadline.IsZero() && time.Now().After(deadline) {
+			return Unknown
+		}
+		if e.call {
+			if ok, next := step(s, ops[e.op]); ok {
+				linearized.set(e.op)
+				k := cacheKey{linearized.key(), next}
+				if !cache[k] {
+					cache[k] = true
+					calls = append(calls, call{e, s})
+					s = next
+					e.lift()
+					e = head.next
+					continue
+				}
+				linearized.clear(e.op)
+			}
+			e = e.next
+			continue
+		}
+		// The operation of this return was not linearized before it: undo
+		// the last one linearized and try the calls after it.
+		if len(calls) == 0 {
+			return Illegal
+		}
+		c := calls[len(calls)-1]
+		calls = calls[:len(calls)-1]
+		s = c.s
+		linearized.clear(c.e.op)
+		c.e.unlift()
+		e = c.e.next
+	}
+	return Ok
+}
+
+// makeEntries returns the head of the list of the calls and the returns of
+// ops, in time order, the calls first at the same time. The operations
+// whose outcome is unknown never return.
+func makeEntries(ops []Operation) *entry {
+	var entries []*entry
+	for i, op := range ops {
+		ret := op.Return
+		if op.Unknown {
+			ret = math.MaxInt64
+		}
+		c := &entry{op: i, call: true, time: op.Call}
+		r := &entry{op: i, time: ret}
+		c.match = r
+		entries = append(entries, c, r)
+	}
+	slices.SortStableFunc(entries, func(a, b *entry) int {
+		switch {
+		case a.time != b.time:
+			return cmp.Compare(a.time, b.time)
+		case a.call == b.call:
+			return 0
+		case a.call:
+			return -1
+		}
+		return 1
+	})
+	head := &entry{}
+	prev := head
+	for _, e := range entries {
+		prev.next, e.prev = e, prev
+		prev = e
+	}
+	return head
+}
+
+// lift removes the call e and its return from the list.
+func (e *entry) lift() {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	m := e.match
+	m.prev.next = m.next
+	if m.next != nil {
+		m.next.prev = m.prev
+	}
+}
+
+// unlift puts back the call e and its return, lifted last.
+func (e *entry) unlift() {
+	m := e.match
+	m.prev.next = m
+	if m.next != nil {
+		m.next.prev = m
+	}
+	e.prev.next = e
+	e.next.prev = e
+}
+
+type cacheKey struct {
+	linearized string
+	s          state
+}
+
+// bitset is the set of the operations linearized.
+type bitset []uint64
+
+func (b bitset) set(i int)   { b[i/64] |= 1 << (i % 64) }
+func (b bitset) clear(i int) { b[i/64] &^= 1 << (i % 64) }
+
+// key returns the set as a string, to be a map key.
+func (b bitset) key() string {
+	buf := make([]byte, 0, 8*len(b))
+	for _, w := range b {
+		buf = binary.LittleEndian.AppendUint64(buf, w)
+	}
+	return string(buf)
+}
@@ -0,0 +1,262 @@
+package linearizability
+
+import (
+	"testing"
+	"time"
+)
+
+func set(client int, key, value string, call, ret int64) Operation {
+	return Operation{Client: client, Kind: Set, Key: key, Value: value, Call: call, Return: ret}
+}
+
+func get(client int, key, value string, call, ret int64) Operation {
+	return Operation{Client: client, Kind: Get, Key: key, Value: value, Found: true, Call: call, Return: ret}
+}
+
+func getMissing(client int, key string, call, ret int64) Operation {
+	return Operation{Client: client, Kind: Get, Key: key, Call: call, Return: ret}
+}
+
+func del(client int, key string, found bool, call, ret int64) Operation {
+	return Operation{Client: client, Kind: Del, Key: key, Found: found, Call: call, Return: ret}
+}
+
+func unknown(op Operation) Operation {
+	op.Unknown, op.Return = true, 0
+	return op
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  []Operation
+		want Result
+	}{
+		{
+			name: "empty",
+			want: Ok,
+		},
+		{
+			name: "sequential",
+			ops: []Operation{
+				getMissing(0, "k", 0, 1),
+				set(0, "k", "a", 2, 3),
+				get(0, "k", "a", 4, 5),
+				del(0, "k", true, 6, 7),
+				getMissing(0, "k", 8, 9),
+				del(0, "k", false, 10, 11),
+			},
+			want: Ok,
+		},
+		{
+			name: "stale read",
+			ops: []Operation{
+				set(0, "k", "a", 0, 1),
+				set(0, "k", "b", 2, 3),
+				get(1, "k", "a", 4, 5),
+			},
+			want: Illegal,
+		},
+		{
+			name: "read of a value never set",
+			ops: []Operation{
+				set(0, "k", "a", 0, 1),
+				get(1, "k", "b", 2, 3),
+			},
+			want: Illegal,
+		},
+		{
+			name: "read missing key after set",
+			ops: []Operation{
+				set(0, "k", "a", 0, 1),
+				getMissing(1, "k", 2, 3),
+			},
+			want: Illegal,
+		},
+		{
+			name: "concurrent get sees old value",
+			ops: []Operation{
+				set(0, "k", "a", 0, 10),
+				getMissing(1, "k", 1, 2),
+			},
+			want: Ok,
+		},
+		{
+			name: "concurrent get sees new value",
+			ops: []Operation{
+				set(0, "k", "a", 0, 10),
+				get(1, "k", "a", 1, 2),
+			},
+			want: Ok,
+		},
+		{
+			name: "new value then old value",
+			ops: []Operation{
+				set(0, "k", "a", 0, 1),
+				set(0, "k", "b", 2, 20),
+				get(1, "k", "b", 3, 4),
+				get(1, "k", "a", 5, 6),
+			},
+			want: Illegal,
+		},
+		{
+			name: "concurrent sets ordered by reads",
+			ops: []Operation{
+				set(0, "k", "a", 0, 10),
+				set(1, "k", "b", 0, 10),
+				get(2, "k", "a", 1, 2),
+				get(2, "k", "b", 3, 4),
+				get(2, "k", "b", 11, 12),
+			},
+			want: Ok,
+		},
+		{
+			name: "reads disagree after concurrent sets",
+			ops: []Operation{
+				set(0, "k", "a", 0, 10),
+				set(1, "k", "b", 0, 10),
+				get(2, "k", "a", 11, 12),
+				get(2, "k", "b", 13, 14),
+			},
+			want: Illegal,
+		},
+		{
+			name: "concurrent del and get",
+			ops: []Operation{
+				set(0, "k", "a", 0, 1),
+				del(0, "k", true, 2, 10),
+				get(1, "k", "a", 3, 4),
+				getMissing(1, "k", 5, 6),
+			},
+			want: Ok,
+		},
+		{
+			name: "two deletes both found",
+			ops: []Operation{
+				set(0, "k", "a", 0, 1),
+				del(0, "k", true, 2, 10),
+				del(1, "k", true, 2, 10),
+			},
+			want: Illegal,
+		},
+		{
+			name: "del reports a missing key",
+			ops: []Operation{
+				set(0, "k", "a", 0, 1),
+				del(1, "k", false, 2, 3),
+			},
+			want: Illegal,
+		},
+		{
+			name: "unknown set applied",
+			ops: []Operation{
+				unknown(set(0, "k", "a", 0, 0)),
+				get(1, "k", "a", 100, 101),
+			},
+			want: Ok,
+		},
+		{
+			name: "unknown set not applied",
+			ops: []Operation{
+				unknown(set(0, "k", "a", 0, 0)),
+				getMissing(1, "k", 100, 101),
+			},
+			want: Ok,
+		},
+		{
+			name: "unknown set applied late",
+			ops: []Operation{
+				unknown(set(0, "k", "a", 0, 0)),
+				getMissing(1, "k", 100, 101),
+				get(1, "k", "a", 102, 103),
+			},
+			want: Ok,
+		},
+		{
+			name: "unknown set undone",
+			ops: []Operation{
+				unknown(set(0, "k", "a", 0, 0)),
+				get(1, "k", "a", 100, 101),
+				getMissing(1, "k", 102, 103),
+			},
+			want: Illegal,
+		},
+		{
+			name: "unknown set after a later call",
+			ops: []Operation{
+				set(1, "k", "b", 0, 1),
+				unknown(set(0, "k", "a", 2, 0)),
+				get(1, "k", "b", 3, 4),
+				get(1, "k", "a", 5, 6),
+			},
+			want: Ok,
+		},
+		{
+			name: "unknown set can't precede its call",
+			ops: []Operation{
+				unknown(set(0, "k", "a", 10, 0)),
+				get(1, "k", "a", 0, 1),
+			},
+			want: Illegal,
+		},
+		{
+			name: "unknown del",
+			ops: []Operation{
+				set(0, "k", "a", 0, 1),
+				unknown(del(0, "k", false, 2, 0)),
+				get(1, "k", "a", 3, 4),
+				getMissing(1, "k", 5, 6),
+			},
+			want: Ok,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Check(tt.ops, 0)
+			if r.Result != tt.want {
+				t.Errorf("Check = %v, want %v", r.Result, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckReportsKey(t *testing.T) {
+	ops := []Operation{
+		set(0, "a", "1", 0, 1),
+		get(1, "a", "1", 2, 3),
+		set(0, "b", "1", 0, 1),
+		getMissing(1, "b", 2, 3),
+		set(0, "c", "1", 0, 1),
+	}
+	r := Check(ops, 0)
+	if r.Result != Illegal || r.Key != "b" || r.Keys != 3 || len(r.History) != 2 {
+		t.Errorf("Check = %+v, want key b not linearizable among 3", r)
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	// Many concurrent sets and reads of distinct values leave a search too
+	// wide to finish at once.
+	var ops []Operation
+	for i := range 200 {
+		v := string(rune('a' + i%26))
+		ops = append(ops, set(i, "k", v, 0, 1000), get(i+200, "k", v, 0, 1000))
+	}
+	ops = append(ops, getMissing(400, "k", 2000, 2001))
+	if r := Check(ops, time.Nanosecond); r.Result != Unknown {
+		t.Errorf("Check = %v, want %v", r.Result, Unknown)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder()
+	r.Add(get(1, "k", "a", 5, 6))
+	r.Add(set(0, "k", "a", 1, 2))
+	h := r.History()
+	if len(h) != 2 || h[0].Kind != Set || h[1].Kind != Get {
+		t.Errorf("History = %+v, want the SET first", h)
+	}
+	if a, b := r.Now(), r.Now(); b < a {
+		t.Errorf("Now went back from %d to %d", a, b)
+	}
+}
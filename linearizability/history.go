@@ -0,0 +1,90 @@
+// Package linearizability checks that histories of concurrent GET, SET and
+// DEL commands on the cluster are linearizable, that is that every command
+// appears to take effect at once, at some point between when it was sent
+// and when its reply came back. It implements the algorithm of Porcupine:
+// the history of each key is checked on its own, by the search of Wing and
+// Gong improved by Lowe, against a model of a Redis string key.
+package linearizability
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Kind is the command of an Operation.
+type Kind int
+
+const (
+	Get Kind = iota
+	Set
+	Del
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Get:
+		return "GET"
+	case Set:
+		return "SET"
+	case Del:
+		return "DEL"
+	}
+	return "?"
+}
+
+// Operation is a command sent by a client and its reply.
+type Operation struct {
+	// Client identifies the client that sent the command. A client sends
+	// its commands one after the other.
+	Client int
+	Kind   Kind
+	Key    string
+	// Value is the value set by a SET, or read by a GET that found the key.
+	Value string
+	// Found reports whether the key existed, for a GET or a DEL.
+	Found bool
+	// Call and Return are when the command was sent and its reply came
+	// back, in nanoseconds since any fixed time.
+	Call, Return int64
+	// Unknown is set for a SET or a DEL whose reply was lost, so that it
+	// may or may not have been applied. Its Return is ignored.
+	Unknown bool
+}
+
+// Recorder collects the operations of concurrent clients. It is safe for
+// concurrent use.
+type Recorder struct {
+	start time.Time
+
+	mu  sync.Mutex
+	ops []Operation
+}
+
+// NewRecorder returns an empty Recorder, whose times start now.
+func NewRecorder() *Recorder {
+	return &Recorder{start: time.Now()}
+}
+
+// Now returns the time to record as the Call or the Return of an operation.
+func (r *Recorder) Now() int64 {
+	return int64(time.Since(r.start))
+}
+
+// Add records an operation once its reply came back or was lost. A GET
+// without a reply is not recorded, as it changed nothing.
+func (r *Recorder) Add(op Operation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops = append(r.ops, op)
+}
+
+// History returns the recorded operations, sorted by Call.
+func (r *Recorder) History() []Operation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ops := slices.Clone(r.ops)
+	slices.SortStableFunc(ops, func(a, b Operation) int { return cmp.Compare(a.Call, b.Call) })
+	return ops
+}
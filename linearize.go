@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"raft-redis-cluster/client"
+	"raft-redis-cluster/linearizability"
+)
+
+// nemesisFaults は、--nemesis に指定できる障害
+var nemesisFaults = []string{"partition", "drop", "delay", "none"}
+
+// linearizeCmd は、並行なクライアントに GET、SET、DEL を送らせながらノードに障害を注入し、
+// 記録した履歴が線形化可能かを検査する
+// 障害は DEBUG FAULT で注入するので、--redis_address には全てのノードを並べ、
+// どのノードも --fault_injection で起動しておく
+func linearizeCmd(args []string) error {
+	fs := flag.NewFlagSet("linearize", flag.ExitOnError)
+	clientOptions := clientFlags(fs)
+	clients := fs.Int("clients", 10, "Number of concurrent clients")
+	duration := fs.Duration("duration", 30*time.Second, "How long the clients send commands")
+	keys := fs.Int("keys", 5, "Number of distinct keys; fewer keys make more concurrent commands on each")
+	nemesis := fs.String("nemesis", "partition,drop,delay", "Comma-separated faults injected in turn into a random node: partition, drop, delay or none")
+	interval := fs.Duration("nemesis_interval", 5*time.Second, "How long each fault lasts before the next one")
+	checkTimeout := fs.Duration("check_timeout", time.Minute, "How long the check may take before its result is unknown; 0 for no limit")
+	fs.Parse(args)
+	if *clients < 1 || *keys < 1 || *duration <= 0 || *interval <= 0 {
+		return errors.New("flags --clients, --keys, --duration and --nemesis_interval must be positive")
+	}
+	faults := strings.Split(*nemesis, ",")
+	for _, f := range faults {
+		if !slices.Contains(nemesisFaults, f) {
+			return fmt.Errorf("invalid --nemesis fault %q", f)
+		}
+	}
+
+	opts, err := clientOptions()
+	if err != nil {
+		return err
+	}
+	opts.PoolSize = *clients
+	c, err := client.New(opts)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	n, err := newNemesis(c, opts.Addrs, opts.Timeout)
+	if err != nil {
+		return err
+	}
+	defer n.reset()
+
+	rec := linearizability.NewRecorder()
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+	var wg sync.WaitGroup
+	for id := range *clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			linearizeClient(ctx, c, rec, id, *keys, opts.Timeout)
+		}()
+	}
+	n.run(ctx, faults, *interval)
+	wg.Wait()
+	n.reset()
+
+	history := rec.History()
+	fmt.Printf("%d operations recorded by %d clients in %v\n", len(history), *clients, *duration)
+	report := linearizability.Check(history, *checkTimeout)
+	fmt.Printf("%d keys checked: %s\n", report.Keys, report.Result)
+	switch report.Result {
+	case linearizability.Illegal:
+		fmt.Printf("\nhistory of key %s:\n", report.Key)
+		printHistory(os.Stdout, report.History)
+		return errors.New("history is not linearizable")
+	case linearizability.Unknown:
+		return fmt.Errorf("check timed out on key %s", report.Key)
+	}
+	return nil
+}
+
+// linearizeClient は、ctx が終わるまで 1 つのクライアントとしてコマンドを 1 つずつ送り、rec に記録する
+// SET する値はクライアントと通し番号から作り、どの SET の値かを区別できるようにする
+// 応答のなかった書き込みは、適用されたかどうか分からない操作として記録する
+func linearizeClient(ctx context.Context, c *client.Client, rec *linearizability.Recorder, id, keys int, timeout time.Duration) {
+	for seq := 0; ctx.Err() == nil; seq++ {
+		op := linearizability.Operation{Client: id, Key: "lin:" + strconv.Itoa(rand.IntN(keys))}
+		// 期限は全体の ctx とは別にし、終了間際のコマンドも応答を待つ
+		opCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		op.Call = rec.Now()
+		var err error
+		switch x := rand.IntN(10); {
+		case x < 5:
+			op.Kind = linearizability.Get
+			var v []byte
+			v, err = c.Get(opCtx, op.Key)
+			op.Value, op.Found = string(v), err == nil
+			if errors.Is(err, client.ErrNotFound) {
+				err = nil
+			}
+		case x < 8:
+			op.Kind = linearizability.Set
+			op.Value = fmt.Sprintf("%d-%d", id, seq)
+			err = c.Set(opCtx, op.Key, []byte(op.Value))
+		default:
+			op.Kind = linearizability.Del
+			op.Found, err = c.Del(opCtx, op.Key)
+		}
+		op.Return = rec.Now()
+		cancel()
+		if err != nil {
+			// 失敗した GET は何も変えていない
+			if op.Kind == linearizability.Get {
+				continue
+			}
+			op.Unknown = true
+		}
+		rec.Add(op)
+	}
+}
+
+// nemesis は、DEBUG FAULT でノードに障害を注入する
+type nemesis struct {
+	c       *client.Client
+	addrs   []string
+	ids     []string // Raft のサーバー ID
+	timeout time.Duration
+}
+
+// newNemesis は、addrs の各ノードの ID を INFO raft で調べる
+func newNemesis(c *client.Client, addrs []string, timeout time.Duration) (*nemesis, error) {
+	n := &nemesis{c: c, addrs: addrs, timeout: timeout}
+	for _, addr := range addrs {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		v, err := c.DoNode(ctx, addr, "INFO", "raft")
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", addr, err)
+		}
+		b, ok := v.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("%s: %w", addr, errKvscliReply)
+		}
+		n.ids = append(n.ids, infoFields(string(b))["raft_node_id"])
+	}
+	return n, nil
+}
+
+// run は、ctx が終わるまで faults の障害を順に interval ずつ注入する
+func (n *nemesis) run(ctx context.Context, faults []string, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for i := 0; ; i++ {
+		n.reset()
+		if err := n.inject(faults[i%len(faults)]); err != nil {
+			log.Printf("nemesis: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// inject は、無作為に選んだノードに障害を注入する
+// partition はそのノードを他の全てのノードから切り離す
+func (n *nemesis) inject(fault string) error {
+	i := rand.IntN(len(n.addrs))
+	var args []string
+	switch fault {
+	case "partition":
+		args = []string{"PARTITION"}
+		for j, id := range n.ids {
+			if j != i {
+				args = append(args, id)
+			}
+		}
+	case "drop":
+		args = []string{"DROP", "0.3"}
+	case "delay":
+		args = []string{"APPLYDELAY", "100"}
+	default:
+		log.Printf("nemesis: no fault")
+		return nil
+	}
+	log.Printf("nemesis: %s on %s", fault, n.ids[i])
+	return n.debugFault(n.addrs[i], args...)
+}
+
+// reset は、全てのノードの障害を取り除く
+func (n *nemesis) reset() {
+	for _, addr := range n.addrs {
+		if err := n.debugFault(addr, "RESET"); err != nil {
+			log.Printf("nemesis: %v", err)
+		}
+	}
+}
+
+func (n *nemesis) debugFault(addr string, args ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+	v, err := n.c.DoNode(ctx, addr, append([]string{"DEBUG", "FAULT"}, args...)...)
+	if err == nil {
+		if e, ok := v.(client.Error); ok {
+			err = e
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", addr, err)
+	}
+	return nil
+}
+
+// printHistory は、操作を呼び出した順に表にして表示する
+// 時刻はミリ秒で、応答のなかった操作の RETURN は ? とする
+func printHistory(out io.Writer, ops []linearizability.Operation) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CLIENT\tCALL\tRETURN\tCOMMAND\tRESULT")
+	for _, op := range ops {
+		ret := "?"
+		if !op.Unknown {
+			ret = fmt.Sprintf("%.3f", float64(op.Return)/1e6)
+		}
+		cmd, result := op.Kind.String()+" "+op.Key, ""
+		switch {
+		case op.Kind == linearizability.Set:
+			cmd += " " + op.Value
+		case op.Unknown:
+		case op.Kind == linearizability.Get && op.Found:
+			result = op.Value
+		case op.Kind == linearizability.Get:
+			result = "(nil)"
+		default:
+			result = strconv.FormatBool(op.Found)
+		}
+		fmt.Fprintf(w, "%d\t%.3f\t%s\t%s\t%s\n", op.Client, float64(op.Call)/1e6, ret, cmd, result)
+	}
+	w.Flush()
+}
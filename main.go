@@ -1,31 +1,46 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"path"
+	"raft-redis-cluster/adminpb"
+	"raft-redis-cluster/cluster"
+	"raft-redis-cluster/config"
+	"raft-redis-cluster/encryption"
+	"raft-redis-cluster/fault"
+	"raft-redis-cluster/kvs"
 	"raft-redis-cluster/raft"
+	"raft-redis-cluster/sink"
 	"raft-redis-cluster/store"
+	"raft-redis-cluster/tlsconfig"
 	"raft-redis-cluster/transport"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	hraft "github.com/hashicorp/raft"
-	raftboltdb "github.com/hashicorp/raft-boltdb"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 // initialPeersList nodeID->address mapping
-type initialPeersList []Peer
-
-type Peer struct {
-	NodeID    string
-	RaftAddr  string
-	RedisAddr string
-}
+type initialPeersList []kvs.Peer
 
 // Set initialPeersList
 // nodeID=raftAddress|RedisAddress,nodeID=raftAddress|RedisAddress...
@@ -43,7 +58,7 @@ func (i *initialPeersList) Set(value string) error {
 			return errors.New("invalid peer format. expected nodeID=raftAddress|RedisAddress")
 		}
 
-		*i = append(*i, Peer{
+		*i = append(*i, kvs.Peer{
 			NodeID:    nodes[0],
 			RaftAddr:  address[0],
 			RedisAddr: address[1],
@@ -57,19 +72,102 @@ func (i *initialPeersList) String() string {
 }
 
 var (
+	configPath   = flag.String("config", "", "YAML or TOML file of the flags, without their dashes, and of the runtime parameters in its params section. Flags on the command line override it. Read again on SIGHUP, with the TLS certificates and the encryption keys; CONFIG REWRITE saves the parameters changed at runtime to it")
 	raftAddr     = flag.String("address", "localhost:50051", "TCP host+port for this raft node")
-	redisAddr    = flag.String("redis_address", "localhost:6379", "TCP host+port for redis")
+	redisAddr    = flag.String("redis_address", "localhost:6379", "TCP host+port for redis, or a comma-separated list of them to listen on several addresses, such as an IPv4 and an IPv6 one")
+	redisAdvAddr = flag.String("redis_advertise_address", "", "TCP host+port clients reach this node at, returned in MOVED and ASK redirects and CLUSTER replies, when it differs from the first of --redis_address, which the nodes use between themselves. Also the advertise-addr parameter")
 	serverID     = flag.String("server_id", "", "Node id used by Raft")
 	dataDir      = flag.String("data_dir", "", "Raft data dir")
+	notifyEvents = flag.String("notify_keyspace_events", "", "Keyspace events to publish, as in Redis notify-keyspace-events (e.g. KEA)")
+	requirePass  = flag.String("requirepass", "", "Password clients must send with AUTH")
+	shardCount   = flag.Int("shards", 1, "Number of Raft groups the hash slots are split between; shard i listens on the port of --address plus i. Must be the same on every node")
+	forwardTo    = flag.Bool("forward_to_leader", false, "Forward the commands this node can't serve to the leader instead of replying MOVED or ASK, for clients without Redis Cluster support")
+	readConsist  = flag.String("read_consistency", "leader-local", "Default consistency of reads: leader-local, linearizable to confirm leadership with a quorum before each read, or stale to let followers serve them")
+	httpAddr     = flag.String("http_address", "", "TCP host+port of the HTTP admin API (/join, /remove, /status, /snapshot, /leader and /health); disabled when empty")
+	grpcAddr     = flag.String("grpc_address", "", "TCP host+port of the gRPC management API defined in adminpb/admin.proto; disabled when empty")
+	otelTracing  = flag.Bool("otel_tracing", false, "Export OpenTelemetry traces of the commands with OTLP over gRPC, configured with the OTEL_EXPORTER_OTLP_* environment variables")
+	logLevel     = flag.String("log_level", "notice", "Level of the logs: debug, verbose, notice, warning or nothing, as the loglevel parameter")
+	logFormat    = flag.String("log_format", "text", "Format of the logs: text or json")
+	storeBackend = flag.String("store", "memory", "Backend of the key space: "+strings.Join(store.Backends(), ", ")+". The backends on disk (badger, bolt and pebble) keep the key space in <data_dir>/store across a clean stop; otherwise it is rebuilt from the Raft snapshots and logs on start")
+	storeFsync   = flag.Bool("store_fsync", false, "Sync the files of a --store on disk on every write instead of only on a clean stop. Slower, but a power loss never leaves them corrupted")
+	s3Endpoint   = flag.String("snapshot_s3_endpoint", "s3.amazonaws.com", "Endpoint of the S3 compatible service of --snapshot_s3_bucket, such as storage.googleapis.com for GCS or the host:port of MinIO")
+	s3Bucket     = flag.String("snapshot_s3_bucket", "", "Bucket the Raft snapshots are copied to, under <prefix>/<server_id>/shard<i>, so a node that lost its disk restores from it; credentials come from the AWS_* or MINIO_* environment variables. Disabled when empty")
+	s3Prefix     = flag.String("snapshot_s3_prefix", "raft-redis-cluster", "Prefix of the snapshots in --snapshot_s3_bucket")
+	s3Insecure   = flag.Bool("snapshot_s3_insecure", false, "Connect to --snapshot_s3_endpoint over plain HTTP")
+	encKeyFile   = flag.String("encryption_key_file", "", "File of the AES keys the Raft logs, snapshots and stable store, and the values of a --store on disk, are encrypted with at rest, one '<id> <base64 key>' per line, the first one encrypting and the others only decrypting; read again on SIGHUP to rotate the keys. Disabled when empty")
+	encPlaintext = flag.Bool("encryption_allow_plaintext", false, "Read the Raft log entries and snapshots written before --encryption_key_file was set, which are otherwise rejected as corrupted, while they are rewritten encrypted")
+	snapCompress = flag.String("snapshot_compression", "none", "Codec of the Raft snapshots written to disk and sent to followers: none, zstd or lz4. Snapshots of any codec are restored")
+	applyTimeout = flag.Int64("raft_apply_timeout", 1000, "Milliseconds a write waits to be committed before it fails. Also the raft-apply-timeout parameter")
+	cmdTimeout   = flag.Int64("command_timeout", 0, "Milliseconds a command may wait for its writes to commit, its reads to catch up or the leader to answer before it fails; 0 for no limit. WAIT, XREAD and CDC.READ keep their own timeout. Also the command-timeout parameter")
+	cmdReadTO    = flag.Int64("command_read_timeout", 0, "Milliseconds a read command may run before it fails, instead of --command_timeout; 0 falls back to it. Also the command-read-timeout parameter")
+	cmdWriteTO   = flag.Int64("command_write_timeout", 0, "Milliseconds a write command may run before it fails, instead of --command_timeout; 0 falls back to it. Also the command-write-timeout parameter")
+	maxBulkLen   = flag.Int64("proto_max_bulk_len", 512<<20, "Bytes an argument of a command may have, as the largest value that can be written. Also the proto-max-bulk-len parameter")
+	chunkSize    = flag.Int64("raft_chunk_size", 1<<20, "Bytes of the largest value a write carries in one Raft entry; a larger value of SET, APPEND, SETRANGE or RESTORE is replicated in chunks of that size, outside of transactions and scripts. 0 never splits a value. Also the raft-chunk-size parameter")
+	applyRetries = flag.Int64("raft_apply_retries", 0, "How many times a write is retried when it wasn't appended to the Raft log or, for writes safe to apply twice, when leadership was lost before it was committed. Also the raft-apply-retries parameter")
+	batchWindow  = flag.Int64("write_batch_window", 0, "Microseconds a SET or DEL waits for concurrent ones to the same shard, to commit them in one Raft entry; 0 commits each on its own. Also the write-batch-window parameter")
+	maxPending   = flag.Int64("max_pending_writes", 0, "Client writes a shard may have waiting to commit before new ones wait for --busy_wait and then fail with BUSY; 0 for no limit. Also the max-pending-writes parameter")
+	maxBacklog   = flag.Int64("max_apply_backlog", 0, "Log entries a shard may have yet to apply before new writes wait for --busy_wait and then fail with BUSY; 0 for no limit. Also the max-apply-backlog parameter")
+	busyWait     = flag.Int64("busy_wait", 0, "Milliseconds a write waits for a backed up shard to drain before it fails with BUSY. Also the busy-wait parameter")
+	clientCmds   = flag.Int64("client_max_cmds_per_sec", 0, "Commands per second a connection may send before the next ones fail with THROTTLED; 0 for no limit. Also the client-max-cmds-per-sec parameter")
+	clientBytes  = flag.Int64("client_max_bytes_per_sec", 0, "Bytes of commands per second a connection may send before the next ones fail with THROTTLED; 0 for no limit. Also the client-max-bytes-per-sec parameter")
+	ipCmds       = flag.Int64("ip_max_cmds_per_sec", 0, "Commands per second the connections from an IP address may send together; 0 for no limit. Also the ip-max-cmds-per-sec parameter")
+	ipBytes      = flag.Int64("ip_max_bytes_per_sec", 0, "Bytes of commands per second the connections from an IP address may send together; 0 for no limit. Also the ip-max-bytes-per-sec parameter")
+	maxClients   = flag.Int64("maxclients", 10000, "Clients connected at once beyond which new connections are refused. Also the maxclients parameter")
+	idleTimeout  = flag.Duration("timeout", 0, "Time after which a client that sent no command is disconnected, e.g. 5m; 0 keeps idle clients. Also the timeout parameter, in seconds")
+	writeTimeout = flag.Duration("write_timeout", 0, "Time after which a client that doesn't read its replies is disconnected; 0 waits forever. Also the write-timeout parameter, in seconds")
+	drainDelay   = flag.Duration("shutdown_delay", 0, "Time to keep serving after SIGTERM with /health failing, for load balancers to stop sending clients, before the node closes its connections")
+	drainTimeout = flag.Duration("shutdown_timeout", 30*time.Second, "Time given to the commands in flight to finish on SIGTERM before the remaining connections are closed")
+	join         = flag.Bool("join", false, "Start without bootstrapping a cluster, to be added to a running one with RAFT.ADD or CLUSTER MEET")
+	databases    = flag.Int("databases", 16, "Number of databases clients can SELECT, whose keys share the keyspace of the shards. Also the read-only databases parameter")
+	cdcBacklog   = flag.Int("cdc_backlog", 0, "Changed keys each shard keeps for CDC.READ, so downstream systems can follow the committed changes in log order; 0 disables change data capture")
+	kafkaBrokers = flag.String("kafka_brokers", "", "Comma-separated host:port of Kafka brokers to publish the committed SET and DEL of the keys to, from the shards this node leads, at least once; needs --cdc_backlog. Disabled when empty")
+	kafkaRoutes  = flag.String("kafka_routes", "=raft-redis-cluster", "Comma-separated prefix=topic routes of the keys published to Kafka; a key takes the route of its longest prefix, keys without a route are not published")
+	kafkaTLS     = flag.Bool("kafka_tls", false, "Connect to --kafka_brokers over TLS")
+	faultInject  = flag.Bool("fault_injection", false, "Enable DEBUG FAULT to drop Raft messages, partition this node, delay applies and fail store writes, for chaos tests. Never in production")
+	redisTLS     = tlsconfig.Options{}
+	raftTLS      = tlsconfig.Options{}
 	initialPeers = initialPeersList{}
 )
 
 func init() {
+	runSubcommand()
+
 	flag.Var(&initialPeers, "initial_peers", "Initial peers for the Raft cluster")
+	flag.StringVar(&redisTLS.CertFile, "tls_cert_file", "", "Certificate file that enables TLS for redis clients")
+	flag.StringVar(&redisTLS.KeyFile, "tls_key_file", "", "Private key file of --tls_cert_file")
+	flag.StringVar(&redisTLS.CAFile, "tls_ca_cert_file", "", "CA certificate file; when set, redis clients must present a certificate signed by it")
+	flag.StringVar(&redisTLS.Ciphers, "tls_ciphers", "", "Comma-separated TLS 1.2 cipher suites allowed for redis clients and raft peers")
+	flag.StringVar(&redisTLS.MinVersion, "tls_min_version", "1.2", "Lowest TLS version accepted from redis clients and raft peers (1.0 to 1.3)")
+	flag.StringVar(&raftTLS.CertFile, "raft_tls_cert_file", "", "Certificate file that enables mutual TLS between raft peers")
+	flag.StringVar(&raftTLS.KeyFile, "raft_tls_key_file", "", "Private key file of --raft_tls_cert_file")
+	flag.StringVar(&raftTLS.CAFile, "raft_tls_ca_cert_file", "", "CA certificate file that signs the certificates of all raft peers")
 	flag.Parse()
+	loadConfigFile()
+	raftTLS.Ciphers, raftTLS.MinVersion = redisTLS.Ciphers, redisTLS.MinVersion
 	validateFlags()
 }
 
+// configFile は、--config で読み込んだ設定ファイル
+var configFile *config.File
+
+// keyring は、--encryption_key_file で読み込んだ暗号化の鍵
+var keyring *encryption.Keyring
+
+// loadConfigFile は、--config の設定ファイルをフラグに反映する
+// コマンドラインで指定されたフラグは、ファイルより優先する
+func loadConfigFile() {
+	if *configPath == "" {
+		return
+	}
+	var err error
+	if configFile, err = config.LoadFile(*configPath); err != nil {
+		log.Fatalln(err)
+	}
+	if err := configFile.ApplyFlags(flag.CommandLine); err != nil {
+		log.Fatalln(err)
+	}
+}
+
 func validateFlags() {
 	if *serverID == "" {
 		log.Fatalf("flag --server_id is required")
@@ -79,96 +177,333 @@ func validateFlags() {
 		log.Fatalf("flag --address is required")
 	}
 
-	if *redisAddr == "" {
+	if len(redisAddrs()) == 0 {
 		log.Fatalf("flag --redis_address is required")
 	}
 
 	if *dataDir == "" {
 		log.Fatalf("flag --data_dir is required")
 	}
-}
 
-func main() {
-	datastore := store.NewMemoryStore()
-	st := raft.NewStateMachine(datastore)
-	r, sdb, err := NewRaft(*dataDir, *serverID, *raftAddr, st, initialPeers)
-	if err != nil {
-		log.Fatalln(err)
+	if *shardCount < 1 || *shardCount > cluster.MaxShards {
+		log.Fatalf("flag --shards must be between 1 and %d", cluster.MaxShards)
+	}
+	if *databases < 1 {
+		log.Fatalf("flag --databases must be at least 1")
 	}
 
-	redis := transport.NewRedis(hraft.ServerID(*serverID), r, datastore, sdb)
-	err = redis.Serve(*redisAddr)
-	if err != nil {
-		log.Fatalln(err)
+	if *logFormat != "text" && *logFormat != "json" {
+		log.Fatalf("flag --log_format must be text or json")
+	}
+
+	if redisTLS.Enabled() && redisTLS.KeyFile == "" {
+		log.Fatalf("flag --tls_key_file is required with --tls_cert_file")
+	}
+
+	if raftTLS.Enabled() && (raftTLS.KeyFile == "" || raftTLS.CAFile == "") {
+		log.Fatalf("flags --raft_tls_key_file and --raft_tls_ca_cert_file are required with --raft_tls_cert_file")
 	}
 }
 
-// snapshotRetainCount スナップショットの保持数
-const snapshotRetainCount = 2
+func main() {
+	var tlsConfig *tls.Config
+	if redisTLS.Enabled() {
+		var err error
+		tlsConfig, err = redisTLS.Server()
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
 
-func NewRaft(baseDir string, id string, address string, fsm hraft.FSM, nodes initialPeersList) (*hraft.Raft, hraft.StableStore, error) {
-	c := hraft.DefaultConfig()
-	c.LocalID = hraft.ServerID(id)
+	// トレースを有効にした場合は、OTLP でエクスポートする
+	if *otelTracing {
+		exp, err := otlptracegrpc.New(context.Background())
+		if err != nil {
+			log.Fatalln(err)
+		}
+		tp := sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exp),
+			sdktrace.WithResource(resource.NewSchemaless(
+				attribute.String("service.name", "raft-redis-cluster"),
+				attribute.String("service.instance.id", *serverID),
+			)),
+		)
+		defer tp.Shutdown(context.Background())
+		otel.SetTracerProvider(tp)
+	}
 
-	ldb, err := raftboltdb.NewBoltStore(filepath.Join(baseDir, "logs.dat"))
-	if err != nil {
-		return nil, nil, err
+	// --fault_injection のときだけ、DEBUG FAULT で障害を注入できる
+	var faults *fault.Injector
+	if *faultInject {
+		faults = fault.New()
 	}
 
-	sdb, err := raftboltdb.NewBoltStore(filepath.Join(baseDir, "stable.dat"))
-	if err != nil {
-		return nil, nil, err
+	// --encryption_key_file のときは、Raft のログ、スナップショット、stable store とディスクのストアの値を暗号化して書き込む
+	if *encKeyFile != "" {
+		var err error
+		if keyring, err = encryption.Load(*encKeyFile); err != nil {
+			log.Fatalln(err)
+		}
+		keyring.AllowPlaintext(*encPlaintext)
 	}
 
-	fss, err := hraft.NewFileSnapshotStore(baseDir, snapshotRetainCount, os.Stderr)
+	// --kafka_brokers のときは、リーダーのシャードの変更を Kafka に送る
+	changeSink, err := kafkaSink()
 	if err != nil {
-		return nil, nil, err
+		log.Fatalln(err)
+	}
+	var onLeaderChange func(int, bool)
+	if changeSink != nil {
+		onLeaderChange = changeSink.LeaderChanged
 	}
 
-	tcpAddr, err := net.ResolveTCPAddr("tcp", address)
+	node, err := kvs.NewNode(kvs.Config{
+		ID:                   *serverID,
+		RaftAddr:             *raftAddr,
+		RedisAddrs:           redisAddrs(),
+		TLS:                  tlsConfig,
+		DataDir:              *dataDir,
+		Peers:                initialPeers,
+		Join:                 *join,
+		Shards:               *shardCount,
+		Store:                *storeBackend,
+		StoreFsync:           *storeFsync,
+		NotifyKeyspaceEvents: *notifyEvents,
+		SnapshotCompression:  *snapCompress,
+		ChangeBacklog:        *cdcBacklog,
+		SnapshotStore:        s3SnapshotStore,
+		Encryption:           keyring,
+		RaftTLS:              raftTLS,
+		Faults:               faults,
+		OnLeaderChange:       onLeaderChange,
+		Options:              []transport.Option{transport.WithConfigFile(configFile), transport.WithDatabases(*databases)},
+	})
 	if err != nil {
-		return nil, nil, err
+		log.Fatalln(err)
+	}
+	redis := node.Redis()
+	// フラグの値を実行時パラメータに設定する
+	for _, p := range paramFlags {
+		if err := redis.Config().Set(p.param, p.value()); err != nil {
+			log.Fatalf("flag --%s: %v", p.flag, err)
+		}
+	}
+	// ログは --log_format の形式で、loglevel の設定に従って出力する
+	logOpts := &slog.HandlerOptions{Level: redis.LogLevel()}
+	var logHandler slog.Handler = slog.NewTextHandler(os.Stderr, logOpts)
+	if *logFormat == "json" {
+		logHandler = slog.NewJSONHandler(os.Stderr, logOpts)
+	}
+	logger := slog.New(logHandler)
+	slog.SetDefault(logger)
+	redis.SetLogger(logger)
+	// 設定ファイルの params は、フラグの後に実行時パラメータとして設定する
+	if configFile != nil {
+		if err := configFile.ApplyParams(redis.Config()); err != nil {
+			log.Fatalln(err)
+		}
 	}
+	// TLS で待ち受けている場合は、リーダーへの転送にも TLS を使う
+	// クライアント証明書を要求している場合は、自分の証明書を提示する
+	if redisTLS.Enabled() {
+		fwdTLS := &tls.Config{MinVersion: tlsConfig.MinVersion, CipherSuites: tlsConfig.CipherSuites}
+		if redisTLS.CAFile != "" {
+			var err error
+			if fwdTLS, err = redisTLS.Client(); err != nil {
+				log.Fatalln(err)
+			}
+		}
+		redis.SetForwardTLS(fwdTLS)
+	}
+	// HTTP の管理 API も、Redis と同じ TLS の設定で待ち受ける
+	if *httpAddr != "" {
+		go func() {
+			srv := &http.Server{Addr: *httpAddr, Handler: redis.AdminHandler(), TLSConfig: tlsConfig}
+			var err error
+			if tlsConfig != nil {
+				err = srv.ListenAndServeTLS("", "")
+			} else {
+				err = srv.ListenAndServe()
+			}
+			log.Fatalln(err)
+		}()
+	}
+	// gRPC の管理 API も、Redis と同じ TLS の設定で待ち受ける
+	if *grpcAddr != "" {
+		var opts []grpc.ServerOption
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		srv := grpc.NewServer(opts...)
+		adminpb.RegisterClusterAdminServer(srv, redis.AdminServer())
+		lis, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		go func() {
+			log.Fatalln(srv.Serve(lis))
+		}()
+	}
+	// SIGHUP では、設定ファイル、TLS の証明書と暗号化の鍵を読み直す
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGHUP)
+		for range sig {
+			reload(redis)
+		}
+	}()
+	// SIGTERM では、ロードバランサーから外れてクライアントを切断し、
+	// リーダーを譲ってから終了する
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+		<-sig
+		shutdown(node)
+	}()
 
-	tm, err := hraft.NewTCPTransport(address, tcpAddr, 10, time.Second*10, os.Stderr)
-	if err != nil {
-		return nil, nil, err
+	if err := node.Start(); err != nil {
+		log.Fatalln(err)
+	}
+	if changeSink != nil {
+		go changeSink.Run(context.Background(), node)
 	}
+	if err := node.Wait(); err != nil {
+		log.Fatalln(err)
+	}
+	<-stopped
+}
 
-	r, err := hraft.NewRaft(c, fsm, ldb, sdb, fss, tm)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	cfg := hraft.Configuration{
-		Servers: []hraft.Server{
-			{
-				Suffrage: hraft.Voter,
-				ID:       hraft.ServerID(id),
-				Address:  hraft.ServerAddress(address),
-			},
-		},
-	}
-
-	// 自分以外の initialPeers を追加
-	for _, peer := range nodes {
-		sid := hraft.ServerID(peer.NodeID)
-		cfg.Servers = append(cfg.Servers, hraft.Server{
-			Suffrage: hraft.Voter,
-			ID:       sid,
-			Address:  hraft.ServerAddress(peer.RaftAddr),
-		})
+// paramFlags は、実行時パラメータの初期値になるフラグと、そのパラメータ
+var paramFlags = []struct {
+	flag, param string
+	value       func() string
+}{
+	{"log_level", "loglevel", func() string { return *logLevel }},
+	{"requirepass", "requirepass", func() string { return *requirePass }},
+	{"redis_advertise_address", "advertise-addr", func() string { return *redisAdvAddr }},
+	{"read_consistency", "read-consistency", func() string { return *readConsist }},
+	{"forward_to_leader", "forward-to-leader", func() string { return config.FormatBool(*forwardTo) }},
+	{"raft_apply_timeout", "raft-apply-timeout", func() string { return strconv.FormatInt(*applyTimeout, 10) }},
+	{"command_timeout", "command-timeout", func() string { return strconv.FormatInt(*cmdTimeout, 10) }},
+	{"command_read_timeout", "command-read-timeout", func() string { return strconv.FormatInt(*cmdReadTO, 10) }},
+	{"command_write_timeout", "command-write-timeout", func() string { return strconv.FormatInt(*cmdWriteTO, 10) }},
+	{"proto_max_bulk_len", "proto-max-bulk-len", func() string { return strconv.FormatInt(*maxBulkLen, 10) }},
+	{"raft_chunk_size", "raft-chunk-size", func() string { return strconv.FormatInt(*chunkSize, 10) }},
+	{"raft_apply_retries", "raft-apply-retries", func() string { return strconv.FormatInt(*applyRetries, 10) }},
+	{"write_batch_window", "write-batch-window", func() string { return strconv.FormatInt(*batchWindow, 10) }},
+	{"max_pending_writes", "max-pending-writes", func() string { return strconv.FormatInt(*maxPending, 10) }},
+	{"max_apply_backlog", "max-apply-backlog", func() string { return strconv.FormatInt(*maxBacklog, 10) }},
+	{"busy_wait", "busy-wait", func() string { return strconv.FormatInt(*busyWait, 10) }},
+	{"client_max_cmds_per_sec", "client-max-cmds-per-sec", func() string { return strconv.FormatInt(*clientCmds, 10) }},
+	{"client_max_bytes_per_sec", "client-max-bytes-per-sec", func() string { return strconv.FormatInt(*clientBytes, 10) }},
+	{"ip_max_cmds_per_sec", "ip-max-cmds-per-sec", func() string { return strconv.FormatInt(*ipCmds, 10) }},
+	{"ip_max_bytes_per_sec", "ip-max-bytes-per-sec", func() string { return strconv.FormatInt(*ipBytes, 10) }},
+	{"maxclients", "maxclients", func() string { return strconv.FormatInt(*maxClients, 10) }},
+	{"timeout", "timeout", func() string { return strconv.FormatInt(int64(*idleTimeout/time.Second), 10) }},
+	{"write_timeout", "write-timeout", func() string { return strconv.FormatInt(int64(*writeTimeout/time.Second), 10) }},
+}
 
-		err := store.SetRedisAddrByNodeID(sdb, sid, peer.RedisAddr)
+// reload は、SIGHUP で設定ファイル、TLS の証明書と暗号化の鍵を読み直す
+// 値の変わったフラグのうち実行時パラメータになるものと、params を設定し直す
+// それ以外のフラグの変更は、再起動するまで反映されない
+func reload(redis *transport.Redis) {
+	if configFile != nil {
+		before := map[string]string{}
+		for _, p := range paramFlags {
+			before[p.flag] = p.value()
+		}
+		f, err := configFile.Reload()
+		if err == nil {
+			err = f.ApplyFlags(flag.CommandLine)
+		}
 		if err != nil {
-			return nil, nil, err
+			slog.Error("config reload failed", "error", err)
+			return
+		}
+		for _, p := range paramFlags {
+			if p.value() == before[p.flag] {
+				continue
+			}
+			if err := redis.Config().Set(p.param, p.value()); err != nil {
+				slog.Error("config reload failed", "flag", p.flag, "error", err)
+			}
+		}
+		if err := f.ApplyParams(redis.Config()); err != nil {
+			slog.Error("config reload failed", "error", err)
 		}
+		configFile = f
+		redis.SetConfigFile(f)
+		slog.Info("config reloaded", "file", f.Path())
 	}
+	if err := tlsconfig.Reload(); err != nil {
+		slog.Error("TLS certificate reload failed", "error", err)
+	}
+	if keyring != nil {
+		if err := keyring.Reload(); err != nil {
+			slog.Error("encryption key reload failed", "error", err)
+		} else {
+			slog.Info("encryption keys reloaded", "primary", keyring.Primary())
+		}
+	}
+}
+
+// redisAddrs は、--redis_address に並べられた待ち受けアドレスを返す
+func redisAddrs() []string {
+	var addrs []string
+	for _, a := range strings.Split(*redisAddr, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
+
+// shutdown は、/health を失敗させて --shutdown_delay だけ待ち、
+// 実行中のコマンドが終わるのを待ってクライアントを切断する
+// リーダーのシャードは他のノードに譲ってから、Raft を停止する
+func shutdown(node *kvs.Node) {
+	node.Redis().Drain()
+	time.Sleep(*drainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+	defer cancel()
+	if err := node.Stop(ctx); err != nil {
+		log.Println(err)
+	}
+}
 
-	f := r.BootstrapCluster(cfg)
-	if err := f.Error(); err != nil {
-		return nil, nil, err
+// kafkaSink は、--kafka_brokers が指定された場合に、キーの変更を Kafka に送る Sink を返す
+func kafkaSink() (*sink.Sink, error) {
+	if *kafkaBrokers == "" {
+		return nil, nil
+	}
+	if *cdcBacklog == 0 {
+		return nil, errors.New("flag --kafka_brokers needs --cdc_backlog")
+	}
+	routes, err := sink.ParseRoutes(*kafkaRoutes)
+	if err != nil {
+		return nil, err
 	}
+	var tlsConfig *tls.Config
+	if *kafkaTLS {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	pub := sink.NewKafka(strings.Split(*kafkaBrokers, ","), tlsConfig)
+	return sink.New(sink.Config{Name: "kafka", Routes: routes, Shards: max(*shardCount, 1)}, pub)
+}
 
-	return r, sdb, nil
+// s3SnapshotStore は、バケットが指定された場合に、シャードのスナップショットを
+// オブジェクトストレージにも複製する
+func s3SnapshotStore(shard int, fss hraft.SnapshotStore) (hraft.SnapshotStore, error) {
+	if *s3Bucket == "" {
+		return fss, nil
+	}
+	objects, err := raft.NewS3(*s3Endpoint, *s3Bucket, !*s3Insecure)
+	if err != nil {
+		return nil, err
+	}
+	prefix := path.Join(*s3Prefix, *serverID, fmt.Sprintf("shard%d", shard))
+	return raft.NewObjectSnapshotStore(fss, objects, prefix, kvs.SnapshotRetainCount), nil
 }
\ No newline at end of file
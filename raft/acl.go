@@ -0,0 +1,32 @@
+package raft
+
+import (
+	"raft-redis-cluster/acl"
+)
+
+// ACL returns the access control list shared by every node.
+func (s *StateMachine) ACL() *acl.ACL {
+	return s.acl
+}
+
+// aclSetUser applies the rules in Args to the user named by Key.
+func (s *StateMachine) aclSetUser(cmd KVCmd) any {
+	rules := make([]string, len(cmd.Args))
+	for i, r := range cmd.Args {
+		rules[i] = string(r)
+	}
+	return s.acl.SetUser(string(cmd.Key), rules)
+}
+
+// aclDelUser deletes the users named in Args and returns how many existed.
+func (s *StateMachine) aclDelUser(cmd KVCmd) any {
+	names := make([]string, len(cmd.Args))
+	for i, n := range cmd.Args {
+		names[i] = string(n)
+	}
+	n, err := s.acl.DelUser(names)
+	if err != nil {
+		return err
+	}
+	return n
+}
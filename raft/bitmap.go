@@ -0,0 +1,109 @@
+package raft
+
+import (
+	"context"
+	"errors"
+)
+
+// BitOp names accepted in the Val of a BitOp command.
+const (
+	BitAnd = "AND"
+	BitOr  = "OR"
+	BitXor = "XOR"
+	BitNot = "NOT"
+)
+
+var ErrBitOffset = errors.New("ERR bit offset is not an integer or out of range")
+
+// MaxBitOffset is the largest offset accepted by SETBIT, matching the 512MB
+// limit of Redis strings.
+const MaxBitOffset = 1<<32 - 1
+
+// setBit sets or clears the bit at Offset and returns the previous bit.
+func (s *StateMachine) setBit(ctx context.Context, cmd KVCmd) any {
+	if cmd.Offset < 0 || cmd.Offset > MaxBitOffset {
+		return ErrBitOffset
+	}
+
+	cur, err := s.getOr(ctx, cmd.Key, nil)
+	if err != nil {
+		return err
+	}
+
+	i := cmd.Offset >> 3
+	b := make([]byte, max(int64(len(cur)), i+1))
+	copy(b, cur)
+
+	mask := byte(1 << (7 - uint(cmd.Offset&7)))
+	prev := 0
+	if b[i]&mask != 0 {
+		prev = 1
+	}
+	if len(cmd.Val) > 0 && cmd.Val[0] == '1' {
+		b[i] |= mask
+	} else {
+		b[i] &^= mask
+	}
+
+	if err := s.update(ctx, cmd.Key, b); err != nil {
+		return err
+	}
+	return prev
+}
+
+// bitOp stores the result of a bitwise operation over the source keys in
+// Args into Key and returns the length of the result.
+func (s *StateMachine) bitOp(ctx context.Context, cmd KVCmd) any {
+	srcs := make([][]byte, 0, len(cmd.Args))
+	n := 0
+	for _, k := range cmd.Args {
+		v, err := s.getOr(ctx, k, nil)
+		if err != nil {
+			return err
+		}
+		srcs = append(srcs, v)
+		n = max(n, len(v))
+	}
+
+	res := make([]byte, n)
+	op := string(cmd.Val)
+	for i := range res {
+		switch op {
+		case BitNot:
+			res[i] = ^byteAt(srcs[0], i)
+		case BitAnd:
+			res[i] = 0xff
+			for _, src := range srcs {
+				res[i] &= byteAt(src, i)
+			}
+		case BitOr:
+			for _, src := range srcs {
+				res[i] |= byteAt(src, i)
+			}
+		case BitXor:
+			for _, src := range srcs {
+				res[i] ^= byteAt(src, i)
+			}
+		default:
+			return ErrUnknownOp
+		}
+	}
+
+	if n == 0 {
+		if err := s.store.Delete(ctx, cmd.Key); err != nil {
+			return err
+		}
+		return 0
+	}
+	if err := s.store.Put(ctx, cmd.Key, res); err != nil {
+		return err
+	}
+	return n
+}
+
+func byteAt(b []byte, i int) byte {
+	if i < len(b) {
+		return b[i]
+	}
+	return 0
+}
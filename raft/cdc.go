@@ -0,0 +1,196 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"raft-redis-cluster/store"
+)
+
+var (
+	// ErrChangesDisabled is returned by ReadChanges while no backlog of
+	// changes is kept.
+	ErrChangesDisabled = errors.New("ERR change data capture is disabled, start the node with --cdc_backlog")
+	// ErrChangesTrimmed is returned by ReadChanges for changes that are no
+	// longer kept. The consumer must read the keys again before it resumes
+	// from the current index.
+	ErrChangesTrimmed = errors.New("ERR changes after this index are no longer kept")
+)
+
+// Change is a change of a key committed to the log of a shard. Every
+// replica records the same changes, in log order, as it applies the log.
+type Change struct {
+	// Index and Term are those of the log entry of the change. The changes
+	// of the commands of a transaction share its index.
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	// Time is when the leader appended the entry.
+	Time time.Time `json:"time"`
+	// Op is the name of the command, such as Put, Del or HSet.
+	Op string `json:"op"`
+	// DB is the database of the key.
+	DB int `json:"db,omitempty"`
+	// Key is the key changed, nil for a Flush, which deletes every key of
+	// every database.
+	Key []byte `json:"key,omitempty"`
+	// Value is the value of a string key after the change. It is nil when
+	// the key holds another type, whose value is read from the store.
+	Value []byte `json:"value,omitempty"`
+	// Deleted reports whether the key no longer exists after the change.
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// changeFeed keeps the last changes applied, for the consumers to read in
+// order.
+type changeFeed struct {
+	mu sync.Mutex
+	// backlog is how many changes are kept, none when zero.
+	backlog int
+	changes []Change
+	// trimmed is the index of the last change no longer kept, and
+	// math.MaxUint64 after a restore until the next change is recorded.
+	trimmed uint64
+	// added is closed and replaced when a change is recorded.
+	added chan struct{}
+}
+
+type termKey struct{}
+
+// withTerm returns a context carrying the term of the log entry being
+// applied.
+func withTerm(ctx context.Context, term uint64) context.Context {
+	return context.WithValue(ctx, termKey{}, term)
+}
+
+func termOf(ctx context.Context) uint64 {
+	term, _ := ctx.Value(termKey{}).(uint64)
+	return term
+}
+
+// SetChangeBacklog sets how many of the last changes are kept for
+// ReadChanges. Zero stops recording them.
+func (s *StateMachine) SetChangeBacklog(n int) error {
+	if n < 0 {
+		return errors.New("change backlog must not be negative")
+	}
+	f := &s.changes
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.backlog = n
+	if len(f.changes) > n {
+		f.trim(len(f.changes) - n)
+	}
+	return nil
+}
+
+// ChangeBacklog returns how many of the last changes are kept.
+func (s *StateMachine) ChangeBacklog() int {
+	s.changes.mu.Lock()
+	defer s.changes.mu.Unlock()
+	return s.changes.backlog
+}
+
+// ReadChanges returns about count of the changes after the index after, all
+// of them when count is negative, and a channel closed when a new change is
+// recorded, to wait on when there are none yet. An after of zero reads the
+// changes kept from the oldest one, even if older ones were dropped.
+func (s *StateMachine) ReadChanges(after uint64, count int) ([]Change, <-chan struct{}, error) {
+	f := &s.changes
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.backlog == 0 {
+		return nil, nil, ErrChangesDisabled
+	}
+	if f.added == nil {
+		f.added = make(chan struct{})
+	}
+	if after > 0 && after < f.trimmed {
+		return nil, nil, ErrChangesTrimmed
+	}
+
+	// The changes are in index order.
+	i := len(f.changes)
+	for i > 0 && f.changes[i-1].Index > after {
+		i--
+	}
+	changes := f.changes[i:]
+	if count >= 0 && len(changes) > count {
+		// The changes of an entry are never split, so that the consumer
+		// resumes after the index of the last one.
+		n := max(count, 1)
+		for n < len(changes) && changes[n].Index == changes[n-1].Index {
+			n++
+		}
+		changes = changes[:n]
+	}
+	return append([]Change(nil), changes...), f.added, nil
+}
+
+// recordChanges records the changes of the keys written by cmd, once its
+// result res was applied.
+func (s *StateMachine) recordChanges(ctx context.Context, cmd KVCmd, res any) {
+	f := &s.changes
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.backlog == 0 {
+		return
+	}
+	keys, all := writtenKeys(cmd, res)
+	if len(keys) == 0 && !all {
+		return
+	}
+
+	c := Change{Index: indexOf(ctx), Term: termOf(ctx), Time: store.Now(ctx), Op: cmd.Op.String()}
+	if f.trimmed == math.MaxUint64 {
+		f.trimmed = c.Index - 1
+	}
+	if all {
+		c.Deleted = true
+		f.add(c)
+	}
+	for _, k := range keys {
+		c.DB, c.Key = store.SplitDBKey(k)
+		c.Value, c.Deleted = nil, false
+		kind, err := s.store.Type(ctx, k)
+		switch {
+		case err != nil:
+			continue
+		case kind == store.KindNone:
+			c.Deleted = true
+		case kind == store.KindString:
+			c.Value, _ = s.store.Get(ctx, k)
+		}
+		f.add(c)
+	}
+	if f.added != nil {
+		close(f.added)
+		f.added = nil
+	}
+}
+
+// add appends c, dropping the oldest change beyond the backlog. f.mu must
+// be held.
+func (f *changeFeed) add(c Change) {
+	if len(f.changes) == f.backlog {
+		f.trim(1)
+	}
+	f.changes = append(f.changes, c)
+}
+
+// trim drops the n oldest changes. f.mu must be held.
+func (f *changeFeed) trim(n int) {
+	f.trimmed = f.changes[n-1].Index
+	f.changes = append(f.changes[:0], f.changes[n:]...)
+}
+
+// restored forgets the changes when the store is replaced by a snapshot,
+// which they don't cover.
+func (f *changeFeed) restored() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.changes = nil
+	f.trimmed = math.MaxUint64
+}
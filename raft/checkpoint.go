@@ -0,0 +1,65 @@
+package raft
+
+import (
+	"encoding/gob"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// checkpoints holds, for each consumer of the change feed of the shard, the
+// log index of the last change it processed. They are replicated like the
+// keys but kept outside of the keyspace, so KEYS doesn't list them and
+// FLUSHALL doesn't reset them.
+type checkpoints struct {
+	mu sync.RWMutex
+	m  map[string]uint64
+}
+
+func newCheckpoints() checkpoints {
+	return checkpoints{m: map[string]uint64{}}
+}
+
+// Checkpoint returns the index committed by the consumer of the change
+// feed named name, 0 when it committed none.
+func (s *StateMachine) Checkpoint(name string) uint64 {
+	s.checkpoints.mu.RLock()
+	defer s.checkpoints.mu.RUnlock()
+	return s.checkpoints.m[name]
+}
+
+// checkpoint records the index in Val as the checkpoint of the consumer
+// named by Key.
+func (s *StateMachine) checkpoint(cmd KVCmd) any {
+	index, err := strconv.ParseUint(string(cmd.Val), 10, 64)
+	if err != nil {
+		return ErrNotInteger
+	}
+	s.checkpoints.mu.Lock()
+	defer s.checkpoints.mu.Unlock()
+	s.checkpoints.m[string(cmd.Key)] = index
+	return nil
+}
+
+func (c *checkpoints) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.m = map[string]uint64{}
+}
+
+func (c *checkpoints) encode(w io.Writer) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return gob.NewEncoder(w).Encode(c.m)
+}
+
+func (c *checkpoints) decode(r io.Reader) error {
+	m := map[string]uint64{}
+	if err := gob.NewDecoder(r).Decode(&m); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.m = m
+	return nil
+}
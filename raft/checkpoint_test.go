@@ -0,0 +1,75 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/hashicorp/raft"
+
+	"raft-redis-cluster/store"
+)
+
+func TestRestoreKeepsCheckpointedStore(t *testing.T) {
+	dir := t.TempDir()
+	open := func() store.Store {
+		t.Helper()
+		st, err := store.Open("bolt", dir, store.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { st.Close() })
+		return st
+	}
+	st := open()
+	s := NewStateMachine(st)
+	applyAt(t, s, 1, KVCmd{Op: Put, Key: []byte("k"), Val: []byte("v")})
+	applyAt(t, s, 2, KVCmd{Op: Put, Key: []byte("other"), Val: []byte("v")})
+	version := keyVersion(t, s, "k")
+
+	snap, err := s.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink, err := raft.NewInmemSnapshotStore().Create(raft.SnapshotVersionMax, 2, 1, raft.Configuration{}, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := snap.Persist(sink); err != nil {
+		t.Fatal(err)
+	}
+	snap.Release()
+	if err := s.CheckpointStore(); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The keys are not in the snapshot restored, only its header: they are
+	// those the store kept.
+	st = open()
+	restored := NewStateMachine(st)
+	if err := restored.Restore(io.NopCloser(bytes.NewReader(snap.(*KVSnapshot).header))); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := st.Get(context.Background(), []byte("k")); err != nil || string(v) != "v" {
+		t.Errorf("Get(k) = %q, %v, want v", v, err)
+	}
+	if v := keyVersion(t, restored, "k"); v != version {
+		t.Errorf("version of k = %x, want %x", v, version)
+	}
+
+	// An entry applied after the snapshot leaves no checkpoint.
+	applyAt(t, restored, 3, KVCmd{Op: Del, Key: []byte("k")})
+	if err := restored.CheckpointStore(); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if kept, err := open().(store.Checkpointer).Resume(2); err != nil || kept {
+		t.Errorf("Resume(2) after applying entry 3 = %v, %v, want false", kept, err)
+	}
+}
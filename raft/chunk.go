@@ -0,0 +1,109 @@
+package raft
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"raft-redis-cluster/store"
+)
+
+var (
+	ErrChunkOrder = errors.New("ERR chunk of a value out of order")
+	ErrUploadLost = errors.New("ERR the chunks of the value were lost, write it again")
+)
+
+// uploadTTL is how long, in the time of the log, the value staged by Chunk
+// entries is kept without a new chunk. A leader that fails in the middle of
+// a value leaves its chunks behind, which the next Chunk entries drop.
+const uploadTTL = 5 * time.Minute
+
+// uploads holds the values written in chunks, by the ID of their upload,
+// until the command they are the value of is applied. They are replicated
+// with the keys, so a server restored from a snapshot taken in the middle of
+// a value still has its first chunks.
+type uploads struct {
+	mu sync.Mutex
+	m  map[string]*upload
+}
+
+// upload is a value staged by Chunk entries, with the time of the log of
+// the last one in Unix milliseconds.
+type upload struct {
+	Data    []byte
+	Updated int64
+}
+
+func newUploads() uploads {
+	return uploads{m: map[string]*upload{}}
+}
+
+// chunk appends Val to the value staged for Upload, which it starts when
+// Offset is 0. The chunks come in order from the leader; one that doesn't
+// start where the value ends drops the value, which then fails to apply.
+func (s *StateMachine) chunk(ctx context.Context, cmd KVCmd) any {
+	now := store.Now(ctx).UnixMilli()
+	u := &s.uploads
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for id, up := range u.m {
+		if now-up.Updated > uploadTTL.Milliseconds() {
+			delete(u.m, id)
+		}
+	}
+
+	up := u.m[cmd.Upload]
+	if cmd.Offset == 0 {
+		up = &upload{}
+		u.m[cmd.Upload] = up
+	}
+	if up == nil || int64(len(up.Data)) != cmd.Offset {
+		delete(u.m, cmd.Upload)
+		return ErrChunkOrder
+	}
+	if cmd.Offset+int64(len(cmd.Val)) > MaxStringLen {
+		delete(u.m, cmd.Upload)
+		return ErrStringTooLong
+	}
+	up.Data = append(up.Data, cmd.Val...)
+	up.Updated = now
+	return nil
+}
+
+// take removes the value staged for id and returns it.
+func (u *uploads) take(id string) ([]byte, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	up, ok := u.m[id]
+	if !ok {
+		return nil, ErrUploadLost
+	}
+	delete(u.m, id)
+	return up.Data, nil
+}
+
+func (u *uploads) reset() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.m = map[string]*upload{}
+}
+
+func (u *uploads) encode(w io.Writer) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return gob.NewEncoder(w).Encode(u.m)
+}
+
+func (u *uploads) decode(r io.Reader) error {
+	m := map[string]*upload{}
+	if err := gob.NewDecoder(r).Decode(&m); err != nil {
+		return err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.m = m
+	return nil
+}
@@ -0,0 +1,134 @@
+package raft
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"raft-redis-cluster/store"
+)
+
+func chunkCmd(upload string, offset int64, val string) KVCmd {
+	return KVCmd{Op: Chunk, Upload: upload, Offset: offset, Val: []byte(val)}
+}
+
+// putRest is the Put that ends the upload with the rest of the value.
+func putRest(upload, key, val string) KVCmd {
+	return KVCmd{Op: Put, Key: []byte(key), Val: []byte(val), Upload: upload}
+}
+
+func getValue(t *testing.T, s *StateMachine, key string) string {
+	t.Helper()
+	v, err := s.store.Get(context.Background(), []byte(key))
+	if err != nil {
+		t.Fatalf("Get %s: %v", key, err)
+	}
+	return string(v)
+}
+
+func TestChunksAssembleInOrder(t *testing.T) {
+	s := NewStateMachine(store.NewMemoryStore())
+	for i, c := range []KVCmd{chunkCmd("u", 0, "ab"), chunkCmd("u", 2, "cd")} {
+		if res := applyAt(t, s, uint64(i+1), c); res != nil {
+			t.Fatalf("chunk %d = %v", i, res)
+		}
+	}
+	if err, ok := applyAt(t, s, 3, putRest("u", "k", "ef")).(error); ok {
+		t.Fatalf("Put = %v", err)
+	}
+	if v := getValue(t, s, "k"); v != "abcdef" {
+		t.Fatalf("value = %q, want abcdef", v)
+	}
+	// The upload is gone once taken.
+	if res := applyAt(t, s, 4, putRest("u", "k", "ef")); res != ErrUploadLost {
+		t.Fatalf("second Put of the upload = %v, want ErrUploadLost", res)
+	}
+}
+
+func TestChunkOutOfOrderDropsUpload(t *testing.T) {
+	s := NewStateMachine(store.NewMemoryStore())
+	applyAt(t, s, 1, chunkCmd("u", 0, "ab"))
+	if res := applyAt(t, s, 2, chunkCmd("u", 3, "cd")); res != ErrChunkOrder {
+		t.Fatalf("chunk past the end = %v, want ErrChunkOrder", res)
+	}
+	if res := applyAt(t, s, 3, chunkCmd("u", 2, "cd")); res != ErrChunkOrder {
+		t.Fatalf("chunk of a dropped upload = %v, want ErrChunkOrder", res)
+	}
+	if res := applyAt(t, s, 4, putRest("u", "k", "ef")); res != ErrUploadLost {
+		t.Fatalf("Put of a dropped upload = %v, want ErrUploadLost", res)
+	}
+	if _, err := s.store.Get(context.Background(), []byte("k")); err != store.ErrKeyNotFound {
+		t.Fatalf("Get k = %v, want ErrKeyNotFound", err)
+	}
+}
+
+func TestChunkUploadExpires(t *testing.T) {
+	s := NewStateMachine(store.NewMemoryStore())
+	start := time.UnixMilli(1_000_000)
+	applyAtTime(t, s, 1, start, chunkCmd("old", 0, "ab"))
+	applyAtTime(t, s, 2, start, chunkCmd("kept", 0, "ab"))
+
+	// Another chunk, past the TTL of the first upload, drops it.
+	later := start.Add(uploadTTL + time.Millisecond)
+	applyAtTime(t, s, 3, later.Add(-time.Second), chunkCmd("kept", 2, "cd"))
+	applyAtTime(t, s, 4, later, chunkCmd("new", 0, "x"))
+
+	if res := applyAtTime(t, s, 5, later, putRest("old", "k", "ef")); res != ErrUploadLost {
+		t.Fatalf("Put of an expired upload = %v, want ErrUploadLost", res)
+	}
+	if res := applyAtTime(t, s, 6, later, putRest("kept", "k", "ef")); res == ErrUploadLost {
+		t.Fatal("Put of an upload updated within its TTL = ErrUploadLost")
+	}
+	if v := getValue(t, s, "k"); v != "abcdef" {
+		t.Fatalf("value = %q, want abcdef", v)
+	}
+}
+
+// restoreCopy returns a new state machine restored from a snapshot of s.
+func restoreCopy(t *testing.T, s *StateMachine) *StateMachine {
+	t.Helper()
+	snap, err := s.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	snaps := raft.NewInmemSnapshotStore()
+	sink, err := snaps.Create(raft.SnapshotVersionMax, 1, 1, raft.Configuration{}, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := snap.Persist(sink); err != nil {
+		t.Fatal(err)
+	}
+	snap.Release()
+	list, err := snaps.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, rc, err := snaps.Open(list[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	restored := NewStateMachine(store.NewMemoryStore())
+	if err := restored.Restore(rc); err != nil {
+		t.Fatal(err)
+	}
+	return restored
+}
+
+func TestSnapshotKeepsUploadInProgress(t *testing.T) {
+	s := NewStateMachine(store.NewMemoryStore())
+	applyAt(t, s, 1, chunkCmd("u", 0, "ab"))
+
+	restored := restoreCopy(t, s)
+	if res := applyAt(t, restored, 2, chunkCmd("u", 2, "cd")); res != nil {
+		t.Fatalf("chunk after the restore = %v", res)
+	}
+	applyAt(t, restored, 3, putRest("u", "k", "ef"))
+	if v := getValue(t, restored, "k"); v != "abcdef" {
+		t.Fatalf("value = %q, want abcdef", v)
+	}
+}
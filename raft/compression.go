@@ -0,0 +1,95 @@
+package raft
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Compression is the codec snapshots are written with. The snapshot store
+// keeps them compressed and they are sent to followers as they are.
+type Compression uint32
+
+const (
+	CompressionNone Compression = iota
+	CompressionZstd
+	CompressionLZ4
+)
+
+var compressionNames = []string{"none", "zstd", "lz4"}
+
+var ErrCompression = errors.New("ERR snapshot compression must be none, zstd or lz4")
+
+// Frame magic numbers, by which Restore recognizes compressed snapshots.
+// Neither can start an uncompressed snapshot.
+var (
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	lz4Magic  = []byte{0x04, 0x22, 0x4d, 0x18}
+)
+
+func ParseCompression(name string) (Compression, error) {
+	for i, n := range compressionNames {
+		if n == name {
+			return Compression(i), nil
+		}
+	}
+	return 0, ErrCompression
+}
+
+func (c Compression) String() string {
+	return compressionNames[c]
+}
+
+// SetSnapshotCompression changes the codec of the next snapshots. Snapshots
+// of any codec can be restored.
+func (s *StateMachine) SetSnapshotCompression(name string) error {
+	c, err := ParseCompression(name)
+	if err != nil {
+		return err
+	}
+	s.compression.Store(uint32(c))
+	return nil
+}
+
+// SnapshotCompression returns the current snapshot-compression setting.
+func (s *StateMachine) SnapshotCompression() string {
+	return Compression(s.compression.Load()).String()
+}
+
+// compress returns a writer compressing to w with c.
+func compress(c Compression, w io.Writer) (io.WriteCloser, error) {
+	switch c {
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	case CompressionLZ4:
+		return lz4.NewWriter(w), nil
+	}
+	return nopWriteCloser{w}, nil
+}
+
+// decompress returns a reader of the snapshot in br, decompressing it if it
+// starts with a frame of a known codec.
+func decompress(br *bufio.Reader) (io.Reader, func(), error) {
+	magic, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.Equal(magic, zstdMagic):
+		d, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return d, d.Close, nil
+	case bytes.Equal(magic, lz4Magic):
+		return lz4.NewReader(br), func() {}, nil
+	}
+	return br, func() {}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,36 @@
+package raft
+
+import (
+	"context"
+	"strconv"
+)
+
+// flushDB deletes the keys of the database in Val and returns them.
+func (s *StateMachine) flushDB(ctx context.Context, cmd KVCmd) any {
+	db, err := strconv.Atoi(string(cmd.Val))
+	if err != nil {
+		return ErrNotInteger
+	}
+	keys, err := s.store.FlushDB(ctx, db)
+	if err != nil {
+		return err
+	}
+	return keys
+}
+
+// swapDB swaps the databases in Args and returns the keys changed.
+func (s *StateMachine) swapDB(ctx context.Context, cmd KVCmd) any {
+	if len(cmd.Args) != 2 {
+		return ErrUnknownOp
+	}
+	a, err1 := strconv.Atoi(string(cmd.Args[0]))
+	b, err2 := strconv.Atoi(string(cmd.Args[1]))
+	if err1 != nil || err2 != nil {
+		return ErrNotInteger
+	}
+	keys, err := s.store.SwapDB(ctx, a, b)
+	if err != nil {
+		return err
+	}
+	return keys
+}
@@ -0,0 +1,63 @@
+package raft
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// digestsKept is how many of the last digests each replica keeps for
+// DigestAt.
+const digestsKept = 16
+
+// DigestResult is the FSM response to a Digest command, which every
+// replica also keeps.
+type DigestResult struct {
+	// Index is the index of the Digest entry. The digest covers the writes
+	// of the entries before it.
+	Index uint64
+	// Digest is the hash of the keyspace computed by store.Digest.
+	Digest []byte
+	// Keys is the number of keys.
+	Keys int
+}
+
+// digests are the last digests computed by a replica.
+type digests struct {
+	mu     sync.Mutex
+	recent []DigestResult
+}
+
+// digest computes the digest of the keyspace at the index of the entry
+// being applied and keeps it, so that the replicas can be compared at the
+// same point of the log.
+func (s *StateMachine) digest(ctx context.Context) any {
+	d, n, err := s.store.Digest(ctx)
+	if err != nil {
+		return err
+	}
+	res := DigestResult{Index: indexOf(ctx), Digest: d, Keys: n}
+
+	s.digests.mu.Lock()
+	defer s.digests.mu.Unlock()
+	if len(s.digests.recent) == digestsKept {
+		s.digests.recent = slices.Delete(s.digests.recent, 0, 1)
+	}
+	s.digests.recent = append(s.digests.recent, res)
+	return res
+}
+
+// DigestAt returns the digest this replica computed when it applied the
+// Digest entry at index. It is not found when the entry is not a Digest,
+// was not applied yet, is older than the last few ones, or was restored
+// from a snapshot rather than applied.
+func (s *StateMachine) DigestAt(index uint64) (DigestResult, bool) {
+	s.digests.mu.Lock()
+	defer s.digests.mu.Unlock()
+	for _, d := range s.digests.recent {
+		if d.Index == index {
+			return d, true
+		}
+	}
+	return DigestResult{}, false
+}
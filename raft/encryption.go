@@ -0,0 +1,176 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/hashicorp/raft"
+
+	"raft-redis-cluster/encryption"
+)
+
+var (
+	_ raft.LogStore      = (*EncryptedLogStore)(nil)
+	_ raft.StableStore   = (*EncryptedStableStore)(nil)
+	_ raft.SnapshotStore = (*EncryptedSnapshotStore)(nil)
+)
+
+// EncryptedLogStore is a raft.LogStore that encrypts the data of the
+// entries it stores in another one, bound to their index so that an entry
+// can't be passed off as another. The entries stored before the encryption
+// was enabled are read as they are only while the keyring allows
+// plaintext.
+type EncryptedLogStore struct {
+	raft.LogStore
+	keys *encryption.Keyring
+}
+
+// NewEncryptedLogStore returns a log store encrypting the entries of logs
+// with the primary key of keys.
+func NewEncryptedLogStore(logs raft.LogStore, keys *encryption.Keyring) *EncryptedLogStore {
+	return &EncryptedLogStore{LogStore: logs, keys: keys}
+}
+
+func (s *EncryptedLogStore) GetLog(index uint64, log *raft.Log) error {
+	if err := s.LogStore.GetLog(index, log); err != nil {
+		return err
+	}
+	data, err := s.keys.Open(log.Data, indexAD(index))
+	if err != nil {
+		return err
+	}
+	log.Data = data
+	return nil
+}
+
+func (s *EncryptedLogStore) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs stores encrypted copies of logs, which Raft keeps using.
+func (s *EncryptedLogStore) StoreLogs(logs []*raft.Log) error {
+	enc := make([]*raft.Log, len(logs))
+	for i, l := range logs {
+		e := *l
+		if len(l.Data) > 0 {
+			e.Data = s.keys.Seal(l.Data, indexAD(l.Index))
+		}
+		enc[i] = &e
+	}
+	return s.LogStore.StoreLogs(enc)
+}
+
+func indexAD(index uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, index)
+}
+
+// EncryptedStableStore is a raft.StableStore that encrypts the values set
+// with Set, such as the vote of the node and the addresses of the node
+// registry, bound to their key. The terms set with SetUint64 are stored as
+// they are.
+type EncryptedStableStore struct {
+	raft.StableStore
+	keys *encryption.Keyring
+}
+
+// NewEncryptedStableStore returns a stable store encrypting the values of
+// stable with the primary key of keys.
+func NewEncryptedStableStore(stable raft.StableStore, keys *encryption.Keyring) *EncryptedStableStore {
+	return &EncryptedStableStore{StableStore: stable, keys: keys}
+}
+
+func (s *EncryptedStableStore) Set(key, val []byte) error {
+	return s.StableStore.Set(key, s.keys.Seal(val, key))
+}
+
+func (s *EncryptedStableStore) Get(key []byte) ([]byte, error) {
+	val, err := s.StableStore.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.keys.Open(val, key)
+}
+
+// EncryptedSnapshotStore is a raft.SnapshotStore that encrypts the
+// snapshots it writes to another one, which then only ever holds them
+// encrypted, such as when it copies them to object storage. They are
+// decrypted as they are opened, to be restored or sent to a follower, which
+// encrypts them with its own keys. The snapshots taken before the
+// encryption was enabled are opened as they are only while the keyring
+// allows plaintext.
+type EncryptedSnapshotStore struct {
+	raft.SnapshotStore
+	keys *encryption.Keyring
+}
+
+// NewEncryptedSnapshotStore returns a snapshot store encrypting the
+// snapshots of snaps with the primary key of keys.
+func NewEncryptedSnapshotStore(snaps raft.SnapshotStore, keys *encryption.Keyring) *EncryptedSnapshotStore {
+	return &EncryptedSnapshotStore{SnapshotStore: snaps, keys: keys}
+}
+
+func (s *EncryptedSnapshotStore) Create(version raft.SnapshotVersion, index, term uint64, configuration raft.Configuration,
+	configurationIndex uint64, trans raft.Transport) (raft.SnapshotSink, error) {
+	sink, err := s.SnapshotStore.Create(version, index, term, configuration, configurationIndex, trans)
+	if err != nil {
+		return nil, err
+	}
+	w, err := s.keys.NewWriter(sink)
+	if err != nil {
+		sink.Cancel()
+		return nil, err
+	}
+	return &encryptedSink{SnapshotSink: sink, w: w}, nil
+}
+
+// Open opens the snapshot id, with the size it decrypts to in its metadata.
+// The sizes listed by List are those of the encrypted snapshots.
+func (s *EncryptedSnapshotStore) Open(id string) (*raft.SnapshotMeta, io.ReadCloser, error) {
+	meta, rc, err := s.SnapshotStore.Open(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	br := bufio.NewReader(rc)
+	if !encryption.IsStream(br) {
+		if !s.keys.PlaintextAllowed() {
+			rc.Close()
+			return nil, nil, fmt.Errorf("snapshot %s: %w", id, encryption.ErrCorrupt)
+		}
+		return meta, readCloser{br, rc}, nil
+	}
+	r, err := s.keys.NewReader(br)
+	if err != nil {
+		rc.Close()
+		return nil, nil, err
+	}
+	m := *meta
+	m.Size = r.Size(meta.Size)
+	return &m, readCloser{r, rc}, nil
+}
+
+// encryptedSink encrypts a snapshot as it is written to the sink of the
+// underlying store.
+type encryptedSink struct {
+	raft.SnapshotSink
+	w *encryption.Writer
+}
+
+func (s *encryptedSink) Write(p []byte) (int, error) {
+	return s.w.Write(p)
+}
+
+func (s *encryptedSink) Close() error {
+	if err := s.w.Close(); err != nil {
+		s.SnapshotSink.Cancel()
+		return err
+	}
+	return s.SnapshotSink.Close()
+}
+
+// readCloser reads from a reader wrapping the snapshot file it closes.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,260 @@
+package raft
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/raft"
+
+	"raft-redis-cluster/encryption"
+)
+
+// newKeyLine returns a line of a key file for a new AES-256 key with id.
+func newKeyLine(t *testing.T, id string) string {
+	t.Helper()
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+	return id + " " + base64.StdEncoding.EncodeToString(raw) + "\n"
+}
+
+// writeKeyFile writes a key file of lines at path.
+func writeKeyFile(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func loadKeyring(t *testing.T, lines ...string) (*encryption.Keyring, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys")
+	writeKeyFile(t, path, lines...)
+	k, err := encryption.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k, path
+}
+
+func TestEncryptedLogStore(t *testing.T) {
+	keys, _ := loadKeyring(t, newKeyLine(t, "k1"))
+	keys.AllowPlaintext(true)
+	inner := raft.NewInmemStore()
+	logs := NewEncryptedLogStore(inner, keys)
+
+	plain := &raft.Log{Index: 1, Term: 1, Data: []byte("written before the encryption")}
+	if err := inner.StoreLog(plain); err != nil {
+		t.Fatal(err)
+	}
+	entries := []*raft.Log{
+		{Index: 2, Term: 1, Data: []byte("entry 2")},
+		{Index: 3, Term: 1, Type: raft.LogNoop},
+	}
+	if err := logs.StoreLogs(entries); err != nil {
+		t.Fatal(err)
+	}
+	if string(entries[0].Data) != "entry 2" {
+		t.Fatalf("StoreLogs changed the entry Raft keeps to %q", entries[0].Data)
+	}
+
+	stored := &raft.Log{}
+	if err := inner.GetLog(2, stored); err != nil {
+		t.Fatal(err)
+	}
+	if !encryption.Encrypted(stored.Data) {
+		t.Fatalf("stored entry %q, want it encrypted", stored.Data)
+	}
+	for i, want := range []string{"written before the encryption", "entry 2", ""} {
+		got := &raft.Log{}
+		if err := logs.GetLog(uint64(i+1), got); err != nil || string(got.Data) != want {
+			t.Errorf("GetLog(%d) = %q, %v, want %q", i+1, got.Data, err, want)
+		}
+	}
+
+	// An entry is bound to its index.
+	moved := *stored
+	moved.Index = 4
+	if err := inner.StoreLog(&moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := logs.GetLog(4, &raft.Log{}); err == nil {
+		t.Error("GetLog of an entry moved to another index succeeded")
+	}
+
+	// Once the entries are all encrypted, one written in plaintext is
+	// rejected.
+	keys.AllowPlaintext(false)
+	if err := logs.GetLog(1, &raft.Log{}); !errors.Is(err, encryption.ErrCorrupt) {
+		t.Errorf("GetLog of a plaintext entry = %v, want ErrCorrupt", err)
+	}
+}
+
+func TestEncryptedLogStoreAfterRotation(t *testing.T) {
+	k1 := newKeyLine(t, "k1")
+	keys, path := loadKeyring(t, k1)
+	inner := raft.NewInmemStore()
+	logs := NewEncryptedLogStore(inner, keys)
+	if err := logs.StoreLog(&raft.Log{Index: 1, Data: []byte("old")}); err != nil {
+		t.Fatal(err)
+	}
+
+	writeKeyFile(t, path, newKeyLine(t, "k2"), k1)
+	if err := keys.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := logs.StoreLog(&raft.Log{Index: 2, Data: []byte("new")}); err != nil {
+		t.Fatal(err)
+	}
+	stored := &raft.Log{}
+	if err := inner.GetLog(2, stored); err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := encryption.KeyOf(stored.Data); id != "k2" {
+		t.Fatalf("new entry encrypted with %q, want k2", id)
+	}
+	for i, want := range []string{"old", "new"} {
+		got := &raft.Log{}
+		if err := logs.GetLog(uint64(i+1), got); err != nil || string(got.Data) != want {
+			t.Errorf("GetLog(%d) = %q, %v, want %q", i+1, got.Data, err, want)
+		}
+	}
+}
+
+func TestEncryptedStableStore(t *testing.T) {
+	keys, _ := loadKeyring(t, newKeyLine(t, "k1"))
+	inner := raft.NewInmemStore()
+	stable := NewEncryptedStableStore(inner, keys)
+
+	if err := stable.Set([]byte("addr"), []byte("10.0.0.1:6379")); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := inner.Get([]byte("addr")); !encryption.Encrypted(stored) {
+		t.Fatalf("stored value %q, want it encrypted", stored)
+	}
+	if got, err := stable.Get([]byte("addr")); err != nil || string(got) != "10.0.0.1:6379" {
+		t.Fatalf("Get = %q, %v, want 10.0.0.1:6379", got, err)
+	}
+
+	// A value is bound to its key.
+	stored, _ := inner.Get([]byte("addr"))
+	if err := inner.Set([]byte("other"), stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stable.Get([]byte("other")); !errors.Is(err, encryption.ErrCorrupt) {
+		t.Errorf("Get of a value moved from another key = %v, want ErrCorrupt", err)
+	}
+	if err := stable.SetUint64([]byte("term"), 3); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := stable.GetUint64([]byte("term")); err != nil || got != 3 {
+		t.Errorf("GetUint64 = %d, %v, want 3", got, err)
+	}
+}
+
+// writeSnapshot writes data to a new snapshot of snaps at index.
+func writeSnapshot(t *testing.T, snaps raft.SnapshotStore, index uint64, data []byte) {
+	t.Helper()
+	sink, err := snaps.Create(raft.SnapshotVersionMax, index, 1, raft.Configuration{}, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sink.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readSnapshot returns the size in the metadata and the data of the latest
+// snapshot of snaps.
+func readSnapshot(snaps raft.SnapshotStore) (int64, []byte, error) {
+	list, err := snaps.List()
+	if err != nil {
+		return 0, nil, err
+	}
+	meta, rc, err := snaps.Open(list[0].ID)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	return meta.Size, data, err
+}
+
+func TestEncryptedSnapshotStore(t *testing.T) {
+	k1 := newKeyLine(t, "k1")
+	keys, path := loadKeyring(t, k1)
+	inner := raft.NewInmemSnapshotStore()
+	snaps := NewEncryptedSnapshotStore(inner, keys)
+
+	// Over a segment of the stream, with a short one at the end.
+	data := make([]byte, 150<<10)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	writeSnapshot(t, snaps, 10, data)
+
+	_, stored, err := readSnapshot(inner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := encryption.KeyOf(stored); !ok || id != "k1" || bytes.Contains(stored, data[:64]) {
+		t.Fatalf("stored snapshot encrypted with %q, %v, want k1", id, ok)
+	}
+
+	// The snapshot taken before the rotation is still read with the former
+	// key.
+	writeKeyFile(t, path, newKeyLine(t, "k2"), k1)
+	if err := keys.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	size, got, err := readSnapshot(snaps)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read %d bytes, %v, want the %d written", len(got), err, len(data))
+	}
+	if size != int64(len(data)) {
+		t.Errorf("snapshot size = %d, want %d", size, len(data))
+	}
+}
+
+func TestEncryptedSnapshotStoreRejectsTruncated(t *testing.T) {
+	keys, _ := loadKeyring(t, newKeyLine(t, "k1"))
+	inner := raft.NewInmemSnapshotStore()
+	writeSnapshot(t, NewEncryptedSnapshotStore(inner, keys), 10, bytes.Repeat([]byte("x"), 100<<10))
+	_, stored, err := readSnapshot(inner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Written again without its last bytes.
+	truncated := raft.NewInmemSnapshotStore()
+	writeSnapshot(t, truncated, 10, stored[:len(stored)-10])
+	if _, _, err := readSnapshot(NewEncryptedSnapshotStore(truncated, keys)); err == nil {
+		t.Error("read a truncated snapshot")
+	}
+}
+
+func TestEncryptedSnapshotStoreReadsPlainSnapshotsWhenAllowed(t *testing.T) {
+	keys, _ := loadKeyring(t, newKeyLine(t, "k1"))
+	inner := raft.NewInmemSnapshotStore()
+	writeSnapshot(t, inner, 10, []byte("written before the encryption"))
+
+	if _, _, err := readSnapshot(NewEncryptedSnapshotStore(inner, keys)); !errors.Is(err, encryption.ErrCorrupt) {
+		t.Fatalf("read a plaintext snapshot: %v, want ErrCorrupt", err)
+	}
+	keys.AllowPlaintext(true)
+	size, got, err := readSnapshot(NewEncryptedSnapshotStore(inner, keys))
+	if err != nil || string(got) != "written before the encryption" || size != int64(len(got)) {
+		t.Fatalf("read %q of size %d, %v, want the snapshot as it is", got, size, err)
+	}
+}
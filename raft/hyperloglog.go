@@ -0,0 +1,67 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"raft-redis-cluster/hyperloglog"
+	"raft-redis-cluster/store"
+)
+
+// loadSketch reads the sketch stored at key, or an empty one if the key does
+// not exist.
+func (s *StateMachine) loadSketch(ctx context.Context, key []byte) (*hyperloglog.Sketch, bool, error) {
+	v, err := s.store.Get(ctx, key)
+	if errors.Is(err, store.ErrKeyNotFound) {
+		return hyperloglog.New(), false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	sk, err := hyperloglog.Decode(v)
+	if err != nil {
+		return nil, false, err
+	}
+	return sk, true, nil
+}
+
+// pfadd adds the elements in Args and returns 1 if the sketch changed.
+func (s *StateMachine) pfadd(ctx context.Context, cmd KVCmd) any {
+	sk, found, err := s.loadSketch(ctx, cmd.Key)
+	if err != nil {
+		return err
+	}
+
+	changed := !found
+	for _, e := range cmd.Args {
+		if sk.Add(e) {
+			changed = true
+		}
+	}
+	if !changed {
+		return 0
+	}
+
+	if err := s.update(ctx, cmd.Key, sk.Bytes()); err != nil {
+		return err
+	}
+	return 1
+}
+
+// pfmerge merges the sketches in Args into Key.
+func (s *StateMachine) pfmerge(ctx context.Context, cmd KVCmd) any {
+	dst, _, err := s.loadSketch(ctx, cmd.Key)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range cmd.Args {
+		sk, _, err := s.loadSketch(ctx, k)
+		if err != nil {
+			return err
+		}
+		dst.Merge(sk)
+	}
+
+	return s.update(ctx, cmd.Key, dst.Bytes())
+}
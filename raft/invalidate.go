@@ -0,0 +1,58 @@
+package raft
+
+import (
+	"context"
+	"sync"
+)
+
+// Invalidator is told of the keys changed by the entries applied on this
+// node, so that the clients caching them drop them.
+type Invalidator interface {
+	// Invalidate is called with the keys written by a command, or with nil
+	// when every key may have changed. origin is the Origin of the command,
+	// or of the transaction or the script it ran in.
+	Invalidate(keys [][]byte, origin string)
+}
+
+type invalidators struct {
+	mu   sync.RWMutex
+	list []Invalidator
+}
+
+// AddInvalidator registers i to be told of the keys changed by every entry
+// applied on this node, and of every key when a snapshot is restored.
+func (s *StateMachine) AddInvalidator(i Invalidator) {
+	s.invalidators.mu.Lock()
+	defer s.invalidators.mu.Unlock()
+	s.invalidators.list = append(s.invalidators.list, i)
+}
+
+type originKey struct{}
+
+// withOrigin returns a context carrying the origin of the command being
+// applied, which the commands nested in it share.
+func withOrigin(ctx context.Context, origin string) context.Context {
+	return context.WithValue(ctx, originKey{}, origin)
+}
+
+func originOf(ctx context.Context) string {
+	origin, _ := ctx.Value(originKey{}).(string)
+	return origin
+}
+
+// invalidate tells the invalidators of the keys written by cmd.
+func (s *StateMachine) invalidate(ctx context.Context, cmd KVCmd, res any) {
+	keys, all := writtenKeys(cmd, res)
+	if len(keys) == 0 && !all {
+		return
+	}
+	s.invalidateKeys(keys, originOf(ctx))
+}
+
+func (s *StateMachine) invalidateKeys(keys [][]byte, origin string) {
+	s.invalidators.mu.RLock()
+	defer s.invalidators.mu.RUnlock()
+	for _, i := range s.invalidators.list {
+		i.Invalidate(keys, origin)
+	}
+}
@@ -0,0 +1,49 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"time"
+)
+
+// lock sets Key to the owner in Val, expiring at ExpireAt, unless the key
+// holds another owner. It returns the fencing token of the lock, the index
+// of the entry, or 0 when another owner holds it. The index grows with every
+// entry, so a resource guarded by the lock can refuse the requests of an
+// owner whose lock expired and was taken by another, which carry an older
+// token. The owner holding the lock takes it again with a new token and
+// expiration. The indexes are those of the shard's own log, so the tokens
+// of a key only grow while its slot stays on the shard; LOCK is refused
+// while the slot migrates.
+func (s *StateMachine) lock(ctx context.Context, cmd KVCmd) any {
+	cur, err := s.getOr(ctx, cmd.Key, nil)
+	if err != nil {
+		return err
+	}
+	if cur != nil && !bytes.Equal(cur, cmd.Val) {
+		return int64(0)
+	}
+	if err := s.store.Put(ctx, cmd.Key, cmd.Val); err != nil {
+		return err
+	}
+	if err := s.store.Expire(ctx, cmd.Key, time.UnixMilli(cmd.ExpireAt)); err != nil {
+		return err
+	}
+	return int64(indexOf(ctx))
+}
+
+// unlock deletes Key if it holds the owner in Val, and returns 1 if it did,
+// 0 otherwise.
+func (s *StateMachine) unlock(ctx context.Context, cmd KVCmd) any {
+	cur, err := s.getOr(ctx, cmd.Key, nil)
+	if err != nil {
+		return err
+	}
+	if cur == nil || !bytes.Equal(cur, cmd.Val) {
+		return 0
+	}
+	if err := s.store.Delete(ctx, cmd.Key); err != nil {
+		return err
+	}
+	return 1
+}
@@ -0,0 +1,56 @@
+package raft
+
+import "context"
+
+// TxReader answers the read-only commands of a transaction. It is called
+// while the transaction is applied, so the reads observe the writes queued
+// before them and nothing else. db is the database selected by the client.
+type TxReader interface {
+	TxRead(ctx context.Context, db int, args [][]byte, resp3 bool) any
+}
+
+// SetTxReader registers the TxReader used for Read commands of a Multi.
+func (s *StateMachine) SetTxReader(r TxReader) {
+	s.txReader.Store(&r)
+}
+
+// batch applies the commands of a Batch one after another. Unlike a Multi,
+// they are unrelated writes of different clients, so an error is the result
+// of its own command only.
+func (s *StateMachine) batch(ctx context.Context, cmd KVCmd) any {
+	res := make([]any, len(cmd.Cmds))
+	for i, c := range cmd.Cmds {
+		switch c.Op {
+		case Put, Del:
+			res[i] = s.apply(ctx, c)
+		default:
+			res[i] = ErrUnknownOp
+		}
+	}
+	return res
+}
+
+// multi applies the commands of a transaction one after another. Since the
+// whole transaction is a single log entry, it is applied on every replica or
+// on none, even if the leader fails in between. If a watched key changed
+// since it was watched, nothing is applied and nil is returned.
+func (s *StateMachine) multi(ctx context.Context, cmd KVCmd) any {
+	if s.watchChanged(ctx, cmd.Watch) {
+		return nil
+	}
+
+	res := make([]any, len(cmd.Cmds))
+	for i, c := range cmd.Cmds {
+		switch c.Op {
+		case Multi:
+			res[i] = ErrUnknownOp
+		case Read:
+			if r := s.txReader.Load(); r != nil {
+				res[i] = (*r).TxRead(ctx, c.DB, c.Args, c.RESP3)
+			}
+		default:
+			res[i] = s.apply(ctx, c)
+		}
+	}
+	return res
+}
@@ -0,0 +1,59 @@
+package raft
+
+import (
+	"github.com/hashicorp/raft"
+
+	"raft-redis-cluster/store"
+)
+
+// SetNodeRegistry makes the state machine write the Redis addresses of the
+// servers added at runtime, and their advertised addresses, to registry,
+// where the transport looks them up. The addresses recorded so far are
+// written right away.
+func (s *StateMachine) SetNodeRegistry(registry raft.StableStore) error {
+	s.registry.Store(&registry)
+	return s.mirrorNodes()
+}
+
+// Nodes returns the Redis addresses of the servers added at runtime, by
+// server ID.
+func (s *StateMachine) Nodes() map[string]string {
+	return s.nodes.All()
+}
+
+// setNode records or forgets the Redis addresses of a server.
+func (s *StateMachine) setNode(cmd KVCmd) any {
+	id, addr, advertised := string(cmd.Key), string(cmd.Val), string(cmd.Field)
+	if cmd.Op == DelNode {
+		s.nodes.Delete(id)
+		addr, advertised = "", ""
+	} else {
+		s.nodes.Set(id, addr, advertised)
+	}
+	if r := s.registry.Load(); r != nil {
+		if err := store.SetRedisAddrByNodeID(*r, raft.ServerID(id), addr); err != nil {
+			return err
+		}
+		return store.SetAdvertiseAddrByNodeID(*r, raft.ServerID(id), advertised)
+	}
+	return nil
+}
+
+// mirrorNodes writes every recorded address to the registry.
+func (s *StateMachine) mirrorNodes() error {
+	r := s.registry.Load()
+	if r == nil {
+		return nil
+	}
+	for id, addr := range s.nodes.All() {
+		if err := store.SetRedisAddrByNodeID(*r, raft.ServerID(id), addr); err != nil {
+			return err
+		}
+	}
+	for id, addr := range s.nodes.Advertised() {
+		if err := store.SetAdvertiseAddrByNodeID(*r, raft.ServerID(id), addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
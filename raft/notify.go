@@ -0,0 +1,246 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"raft-redis-cluster/store"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NotifyFlags is a parsed notify-keyspace-events setting.
+type NotifyFlags uint32
+
+const (
+	NotifyKeyspace NotifyFlags = 1 << iota // K
+	NotifyKeyevent                         // E
+	NotifyGeneric                          // g
+	NotifyString                           // $
+	NotifyList                             // l
+	NotifySet                              // s
+	NotifyHash                             // h
+	NotifyZSet                             // z
+	NotifyExpired                          // x
+	NotifyEvicted                          // e
+	NotifyStream                           // t
+
+	// NotifyAll is the class set selected by "A".
+	NotifyAll = NotifyGeneric | NotifyString | NotifyList | NotifySet | NotifyHash |
+		NotifyZSet | NotifyExpired | NotifyEvicted | NotifyStream
+)
+
+var notifyFlagChars = []struct {
+	c    byte
+	flag NotifyFlags
+}{
+	{'K', NotifyKeyspace},
+	{'E', NotifyKeyevent},
+	{'g', NotifyGeneric},
+	{'$', NotifyString},
+	{'l', NotifyList},
+	{'s', NotifySet},
+	{'h', NotifyHash},
+	{'z', NotifyZSet},
+	{'x', NotifyExpired},
+	{'e', NotifyEvicted},
+	{'t', NotifyStream},
+}
+
+var ErrNotifyFlags = errors.New("ERR Invalid event class character. Use 'Ag$lshzxet'.")
+
+// ParseNotifyFlags parses a notify-keyspace-events string such as "KEA" or
+// "Kx".
+func ParseNotifyFlags(s string) (NotifyFlags, error) {
+	var f NotifyFlags
+next:
+	for i := 0; i < len(s); i++ {
+		if s[i] == 'A' {
+			f |= NotifyAll
+			continue
+		}
+		for _, fc := range notifyFlagChars {
+			if fc.c == s[i] {
+				f |= fc.flag
+				continue next
+			}
+		}
+		return 0, ErrNotifyFlags
+	}
+	return f, nil
+}
+
+func (f NotifyFlags) String() string {
+	var b strings.Builder
+	for _, fc := range notifyFlagChars {
+		if f&fc.flag != 0 {
+			b.WriteByte(fc.c)
+		}
+	}
+	return b.String()
+}
+
+// SetNotifyKeyspaceEvents changes which keyspace events are published.
+func (s *StateMachine) SetNotifyKeyspaceEvents(flags string) error {
+	f, err := ParseNotifyFlags(flags)
+	if err != nil {
+		return err
+	}
+	s.notifyFlags.Store(uint32(f))
+	return nil
+}
+
+// NotifyKeyspaceEvents returns the current notify-keyspace-events setting.
+func (s *StateMachine) NotifyKeyspaceEvents() string {
+	return NotifyFlags(s.notifyFlags.Load()).String()
+}
+
+// notifyEvent publishes a keyspace event on __keyspace@0__:<key> and
+// __keyevent@0__:<event>, depending on the configured flags.
+func (s *StateMachine) notifyEvent(ctx context.Context, class NotifyFlags, event string, key []byte) {
+	f := NotifyFlags(s.notifyFlags.Load())
+	if f&class == 0 || f&(NotifyKeyspace|NotifyKeyevent) == 0 {
+		return
+	}
+	if time.Since(store.Now(ctx)) > publishMaxAge {
+		return
+	}
+
+	s.publishers.mu.RLock()
+	defer s.publishers.mu.RUnlock()
+
+	db, key := store.SplitDBKey(key)
+	n := strconv.Itoa(db)
+	for _, p := range s.publishers.list {
+		if f&NotifyKeyspace != 0 {
+			p.Publish("__keyspace@"+n+"__:"+string(key), event)
+		}
+		if f&NotifyKeyevent != 0 {
+			p.Publish("__keyevent@"+n+"__:"+event, string(key))
+		}
+	}
+}
+
+// notify emits the keyspace events caused by an applied command.
+func (s *StateMachine) notify(ctx context.Context, cmd KVCmd, res any) {
+	if s.notifyFlags.Load() == 0 {
+		return
+	}
+	if _, ok := res.(error); ok {
+		return
+	}
+
+	switch cmd.Op {
+	case Put:
+		if pr, _ := res.(PutResult); pr.Applied {
+			s.notifyEvent(ctx, NotifyString, "set", cmd.Key)
+			if cmd.ExpireAt != 0 {
+				s.notifyEvent(ctx, NotifyGeneric, "expire", cmd.Key)
+			}
+		}
+	case Del:
+		switch {
+		case res != 1:
+		case cmd.Evict:
+			s.notifyEvent(ctx, NotifyEvicted, "evicted", cmd.Key)
+		case cmd.Expired:
+			s.notifyEvent(ctx, NotifyExpired, "expired", cmd.Key)
+		default:
+			s.notifyEvent(ctx, NotifyGeneric, "del", cmd.Key)
+		}
+	case Expire:
+		if res == true {
+			if time.UnixMilli(cmd.ExpireAt).After(store.Now(ctx)) {
+				s.notifyEvent(ctx, NotifyGeneric, "expire", cmd.Key)
+			} else {
+				s.notifyEvent(ctx, NotifyGeneric, "del", cmd.Key)
+			}
+		}
+	case Persist:
+		if res == true {
+			s.notifyEvent(ctx, NotifyGeneric, "persist", cmd.Key)
+		}
+	case IncrBy:
+		s.notifyEvent(ctx, NotifyString, "incrby", cmd.Key)
+	case IncrByFloat:
+		s.notifyEvent(ctx, NotifyString, "incrbyfloat", cmd.Key)
+	case MSet:
+		for _, p := range cmd.Pairs {
+			s.notifyEvent(ctx, NotifyString, "set", p.Key)
+		}
+	case HSet:
+		s.notifyEvent(ctx, NotifyHash, "hset", cmd.Key)
+	case HDel:
+		s.notifyRemoved(ctx, NotifyHash, "hdel", cmd.Key, res)
+	case HIncrBy:
+		s.notifyEvent(ctx, NotifyHash, "hincrby", cmd.Key)
+	case SAdd:
+		if res != 0 {
+			s.notifyEvent(ctx, NotifySet, "sadd", cmd.Key)
+		}
+	case SRem:
+		s.notifyRemoved(ctx, NotifySet, "srem", cmd.Key, res)
+	case ZAdd:
+		if zr, _ := res.(ZAddResult); zr.Changed > 0 {
+			s.notifyEvent(ctx, NotifyZSet, "zadd", cmd.Key)
+		}
+	case ZRem:
+		s.notifyRemoved(ctx, NotifyZSet, "zrem", cmd.Key, res)
+	case SetBit:
+		s.notifyEvent(ctx, NotifyString, "setbit", cmd.Key)
+	case SetRange:
+		if len(cmd.Val) > 0 {
+			s.notifyEvent(ctx, NotifyString, "setrange", cmd.Key)
+		}
+	case Append:
+		s.notifyEvent(ctx, NotifyString, "append", cmd.Key)
+	case Lock:
+		if res != int64(0) {
+			s.notifyEvent(ctx, NotifyString, "set", cmd.Key)
+			s.notifyEvent(ctx, NotifyGeneric, "expire", cmd.Key)
+		}
+	case Unlock:
+		if res == 1 {
+			s.notifyEvent(ctx, NotifyGeneric, "del", cmd.Key)
+		}
+	case Rename:
+		if res == 1 && !bytes.Equal(cmd.Key, cmd.Val) {
+			s.notifyEvent(ctx, NotifyGeneric, "rename_from", cmd.Key)
+			s.notifyEvent(ctx, NotifyGeneric, "rename_to", cmd.Val)
+		}
+	case Copy:
+		if res == 1 {
+			s.notifyEvent(ctx, NotifyGeneric, "copy_to", cmd.Val)
+		}
+	case BitOp:
+		if res == 0 {
+			s.notifyEvent(ctx, NotifyGeneric, "del", cmd.Key)
+		} else {
+			s.notifyEvent(ctx, NotifyString, "set", cmd.Key)
+		}
+	case PFAdd:
+		if res == 1 {
+			s.notifyEvent(ctx, NotifyString, "pfadd", cmd.Key)
+		}
+	case PFMerge:
+		s.notifyEvent(ctx, NotifyString, "pfadd", cmd.Key)
+	case XAdd:
+		s.notifyEvent(ctx, NotifyStream, "xadd", cmd.Key)
+	case RestoreKey:
+		s.notifyEvent(ctx, NotifyGeneric, "restore", cmd.Key)
+	}
+}
+
+// notifyRemoved emits the event of a command removing elements from a
+// collection, followed by "del" if the collection became empty.
+func (s *StateMachine) notifyRemoved(ctx context.Context, class NotifyFlags, event string, key []byte, res any) {
+	if res == 0 {
+		return
+	}
+	s.notifyEvent(ctx, class, event, key)
+
+	if ok, err := s.store.Exists(ctx, key); err == nil && !ok {
+		s.notifyEvent(ctx, NotifyGeneric, "del", key)
+	}
+}
@@ -0,0 +1,234 @@
+package raft
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// ObjectStore is an object storage bucket, such as S3, GCS or MinIO.
+type ObjectStore interface {
+	Put(ctx context.Context, name string, r io.Reader, size int64) error
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+	// List returns the names of the objects starting with prefix.
+	List(ctx context.Context, prefix string) ([]string, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// objectTimeout bounds each request to the object storage.
+const objectTimeout = 5 * time.Minute
+
+const (
+	objectMeta  = "meta.json"
+	objectState = "state.bin"
+)
+
+var _ raft.SnapshotStore = (*ObjectSnapshotStore)(nil)
+
+// ObjectSnapshotStore is a raft.SnapshotStore that keeps the snapshots in a
+// local store and copies each one to object storage, under
+// prefix/<snapshot id>/. A node that lost its disk lists and opens the
+// snapshots from object storage, so it restores the latest one and only
+// needs the log after it from the leader instead of a full snapshot
+// transfer.
+type ObjectSnapshotStore struct {
+	local   raft.SnapshotStore
+	objects ObjectStore
+	prefix  string
+	retain  int
+}
+
+// NewObjectSnapshotStore returns a snapshot store that copies the snapshots
+// of local to objects under prefix and keeps the retain newest ones there.
+func NewObjectSnapshotStore(local raft.SnapshotStore, objects ObjectStore, prefix string, retain int) *ObjectSnapshotStore {
+	return &ObjectSnapshotStore{local: local, objects: objects, prefix: prefix, retain: retain}
+}
+
+func (s *ObjectSnapshotStore) Create(version raft.SnapshotVersion, index, term uint64, configuration raft.Configuration,
+	configurationIndex uint64, trans raft.Transport) (raft.SnapshotSink, error) {
+	sink, err := s.local.Create(version, index, term, configuration, configurationIndex, trans)
+	if err != nil {
+		return nil, err
+	}
+	return &objectSink{SnapshotSink: sink, store: s}, nil
+}
+
+// List returns the local snapshots and the ones only found in object
+// storage, newest first. When the object storage can't be listed, only the
+// local snapshots are returned.
+func (s *ObjectSnapshotStore) List() ([]*raft.SnapshotMeta, error) {
+	metas, err := s.local.List()
+	if err != nil {
+		return nil, err
+	}
+	remote, err := s.remoteMetas()
+	if err != nil {
+		slog.Default().Warn("listing snapshots in object storage failed", "prefix", s.prefix, "error", err)
+		return metas, nil
+	}
+	for _, m := range remote {
+		if !slices.ContainsFunc(metas, func(l *raft.SnapshotMeta) bool { return l.ID == m.ID }) {
+			metas = append(metas, m)
+		}
+	}
+	sortMetas(metas)
+	return metas, nil
+}
+
+// Open opens a local snapshot, or downloads it from object storage.
+func (s *ObjectSnapshotStore) Open(id string) (*raft.SnapshotMeta, io.ReadCloser, error) {
+	meta, rc, err := s.local.Open(id)
+	if err == nil {
+		return meta, rc, nil
+	}
+	meta, rerr := s.remoteMeta(id)
+	if rerr != nil {
+		return nil, nil, err
+	}
+	// No timeout, so that restoring a large snapshot isn't cut short.
+	rc, rerr = s.objects.Get(context.Background(), s.object(id, objectState))
+	if rerr != nil {
+		return nil, nil, rerr
+	}
+	return meta, rc, nil
+}
+
+func (s *ObjectSnapshotStore) object(id, name string) string {
+	return path.Join(s.prefix, id, name)
+}
+
+// upload copies the local snapshot id to object storage. The metadata is
+// written last, so only complete snapshots are listed.
+func (s *ObjectSnapshotStore) upload(id string) error {
+	meta, rc, err := s.local.Open(id)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), objectTimeout)
+	defer cancel()
+
+	if err := s.objects.Put(ctx, s.object(id, objectState), rc, meta.Size); err != nil {
+		return err
+	}
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return s.objects.Put(ctx, s.object(id, objectMeta), bytes.NewReader(b), int64(len(b)))
+}
+
+// prune deletes the snapshots in object storage beyond the retain newest.
+func (s *ObjectSnapshotStore) prune() error {
+	metas, err := s.remoteMetas()
+	if err != nil || len(metas) <= s.retain {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), objectTimeout)
+	defer cancel()
+
+	for _, m := range metas[s.retain:] {
+		for _, name := range []string{objectMeta, objectState} {
+			if err := s.objects.Delete(ctx, s.object(m.ID, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// remoteMetas returns the metadata of the snapshots in object storage,
+// newest first.
+func (s *ObjectSnapshotStore) remoteMetas() ([]*raft.SnapshotMeta, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), objectTimeout)
+	defer cancel()
+
+	names, err := s.objects.List(ctx, s.prefix+"/")
+	if err != nil {
+		return nil, err
+	}
+	var metas []*raft.SnapshotMeta
+	for _, name := range names {
+		if path.Base(name) != objectMeta {
+			continue
+		}
+		m, err := s.remoteMeta(path.Base(strings.TrimSuffix(name, "/"+objectMeta)))
+		if err != nil {
+			return nil, err
+		}
+		metas = append(metas, m)
+	}
+	sortMetas(metas)
+	return metas, nil
+}
+
+func (s *ObjectSnapshotStore) remoteMeta(id string) (*raft.SnapshotMeta, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), objectTimeout)
+	defer cancel()
+
+	rc, err := s.objects.Get(ctx, s.object(id, objectMeta))
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var m raft.SnapshotMeta
+	if err := json.NewDecoder(rc).Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// sortMetas sorts snapshots newest first, as raft.FileSnapshotStore lists
+// them.
+func sortMetas(metas []*raft.SnapshotMeta) {
+	slices.SortFunc(metas, func(a, b *raft.SnapshotMeta) int {
+		if c := cmp.Compare(b.Term, a.Term); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(b.Index, a.Index); c != 0 {
+			return c
+		}
+		return strings.Compare(b.ID, a.ID)
+	})
+}
+
+// objectSink copies the snapshot to object storage once it is complete
+// locally. Failing to copy it is logged without failing the snapshot, so
+// that the log is still compacted while the object storage is unavailable.
+type objectSink struct {
+	raft.SnapshotSink
+	store    *ObjectSnapshotStore
+	canceled bool
+}
+
+func (k *objectSink) Cancel() error {
+	k.canceled = true
+	return k.SnapshotSink.Cancel()
+}
+
+func (k *objectSink) Close() error {
+	if err := k.SnapshotSink.Close(); err != nil || k.canceled {
+		return err
+	}
+	id := k.ID()
+	if err := k.store.upload(id); err != nil {
+		slog.Default().Warn("copying snapshot to object storage failed", "id", id, "error", err)
+		return nil
+	}
+	if err := k.store.prune(); err != nil {
+		slog.Default().Warn("pruning snapshots in object storage failed", "prefix", k.store.prefix, "error", err)
+	}
+	return nil
+}
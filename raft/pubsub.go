@@ -0,0 +1,48 @@
+package raft
+
+import (
+	"context"
+	"raft-redis-cluster/store"
+	"sync"
+	"time"
+)
+
+// Publisher delivers a message to the subscribers connected to this node.
+type Publisher interface {
+	Publish(channel, message string) int
+}
+
+// publishMaxAge bounds how old a Publish entry may be and still be delivered.
+// Replaying the log after a restart would otherwise re-deliver old messages,
+// while pub/sub is an at-most-once, fire-and-forget mechanism.
+const publishMaxAge = 10 * time.Second
+
+type publishers struct {
+	mu   sync.RWMutex
+	list []Publisher
+}
+
+// AddPublisher registers p to receive every replicated Publish applied on
+// this node.
+func (s *StateMachine) AddPublisher(p Publisher) {
+	s.publishers.mu.Lock()
+	defer s.publishers.mu.Unlock()
+	s.publishers.list = append(s.publishers.list, p)
+}
+
+// publish delivers a replicated message to local subscribers and returns how
+// many received it.
+func (s *StateMachine) publish(ctx context.Context, cmd KVCmd) any {
+	if time.Since(store.Now(ctx)) > publishMaxAge {
+		return 0
+	}
+
+	s.publishers.mu.RLock()
+	defer s.publishers.mu.RUnlock()
+
+	n := 0
+	for _, p := range s.publishers.list {
+		n += p.Publish(string(cmd.Key), string(cmd.Val))
+	}
+	return n
+}
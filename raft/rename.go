@@ -0,0 +1,80 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"raft-redis-cluster/store"
+)
+
+var (
+	ErrNoSuchKey = errors.New("ERR no such key")
+	ErrSameKey   = errors.New("ERR source and destination objects are the same")
+)
+
+// rename moves the value and expiration of Key to the key in Val, replacing
+// its value unless Cond is CondNX. It returns 1, or 0 when the destination
+// exists under CondNX.
+func (s *StateMachine) rename(ctx context.Context, cmd KVCmd) any {
+	data, err := s.store.Dump(ctx, cmd.Key)
+	if errors.Is(err, store.ErrKeyNotFound) {
+		return ErrNoSuchKey
+	}
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(cmd.Key, cmd.Val) {
+		if cmd.Cond == CondNX {
+			return 0
+		}
+		return 1
+	}
+	if cmd.Cond == CondNX {
+		ok, err := s.store.Exists(ctx, cmd.Val)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return 0
+		}
+	}
+
+	if err := s.store.RestoreKey(ctx, cmd.Val, data); err != nil {
+		return err
+	}
+	if err := s.store.Delete(ctx, cmd.Key); err != nil {
+		return err
+	}
+	return 1
+}
+
+// copyKey copies the value and expiration of Key to the key in Val,
+// replacing its value unless Cond is CondNX. It returns 1, or 0 when Key
+// does not exist or the destination exists under CondNX.
+func (s *StateMachine) copyKey(ctx context.Context, cmd KVCmd) any {
+	if bytes.Equal(cmd.Key, cmd.Val) {
+		return ErrSameKey
+	}
+	data, err := s.store.Dump(ctx, cmd.Key)
+	if errors.Is(err, store.ErrKeyNotFound) {
+		return 0
+	}
+	if err != nil {
+		return err
+	}
+	if cmd.Cond == CondNX {
+		ok, err := s.store.Exists(ctx, cmd.Val)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return 0
+		}
+	}
+
+	if err := s.store.RestoreKey(ctx, cmd.Val, data); err != nil {
+		return err
+	}
+	return 1
+}
@@ -0,0 +1,145 @@
+package raft
+
+import (
+	"container/list"
+	"context"
+	"encoding/gob"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"raft-redis-cluster/store"
+)
+
+// requestTTL is how long, in log time, the result of a command with a
+// request ID is kept to answer its retries.
+const requestTTL = 10 * time.Minute
+
+func init() {
+	gob.Register(PutResult{})
+	gob.Register(ZAddResult{})
+	gob.Register(store.StreamID{})
+	gob.Register([]any(nil))
+}
+
+// requests holds the results of the commands applied with a request ID, so
+// that a client retrying a command after an ambiguous failure gets the
+// result of the first attempt instead of applying it twice. Entries expire
+// after requestTTL from the time the leader appended them, which is the
+// same on every replica.
+type requests struct {
+	mu    sync.Mutex
+	m     map[string]*list.Element
+	order *list.List // of *requestEntry, oldest first
+}
+
+type requestEntry struct {
+	ID string
+	// At is when the command was appended, in Unix milliseconds.
+	At  int64
+	Res any
+	// Err is the message of an error result, which gob can't encode.
+	Err string
+	// Unknown is set for a result of a type that can't be saved in a
+	// snapshot. A retry then fails with ErrRequestApplied.
+	Unknown bool
+}
+
+var ErrRequestApplied = errors.New("ERR a command with this request ID was already applied")
+
+func newRequests() requests {
+	return requests{m: map[string]*list.Element{}, order: list.New()}
+}
+
+// lookup returns the result of the command applied with id.
+func (q *requests) lookup(id string) (any, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	el, ok := q.m[id]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*requestEntry)
+	switch {
+	case e.Unknown:
+		return ErrRequestApplied, true
+	case e.Err != "":
+		return errors.New(e.Err), true
+	}
+	return e.Res, true
+}
+
+// record saves the result of the command applied with id and forgets the
+// ones older than requestTTL.
+func (q *requests) record(ctx context.Context, id string, res any) {
+	now := store.Now(ctx).UnixMilli()
+	e := &requestEntry{ID: id, At: now}
+	if err, ok := res.(error); ok {
+		e.Err = err.Error()
+	} else if encodable(res) {
+		e.Res = res
+	} else {
+		e.Unknown = true
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for el := q.order.Front(); el != nil; el = q.order.Front() {
+		old := el.Value.(*requestEntry)
+		if now-old.At < requestTTL.Milliseconds() {
+			break
+		}
+		q.order.Remove(el)
+		delete(q.m, old.ID)
+	}
+	q.m[id] = q.order.PushBack(e)
+}
+
+// encodable reports whether a result can be saved in a snapshot.
+func encodable(res any) bool {
+	switch v := res.(type) {
+	case nil, int, int64, float64, bool, string, []byte, PutResult, ZAddResult, store.StreamID:
+		return true
+	case []any:
+		for _, e := range v {
+			if !encodable(e) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (q *requests) reset() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.m = map[string]*list.Element{}
+	q.order.Init()
+}
+
+func (q *requests) encode(w io.Writer) error {
+	q.mu.Lock()
+	entries := make([]requestEntry, 0, q.order.Len())
+	for el := q.order.Front(); el != nil; el = el.Next() {
+		entries = append(entries, *el.Value.(*requestEntry))
+	}
+	q.mu.Unlock()
+	return gob.NewEncoder(w).Encode(entries)
+}
+
+func (q *requests) decode(r io.Reader) error {
+	var entries []requestEntry
+	if err := gob.NewDecoder(r).Decode(&entries); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i := range entries {
+		q.m[entries[i].ID] = q.order.PushBack(&entries[i])
+	}
+	return nil
+}
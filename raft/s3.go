@@ -0,0 +1,66 @@
+package raft
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3 is an ObjectStore on a bucket of an S3 compatible service: AWS S3,
+// MinIO, or GCS through its XML API with HMAC keys.
+type S3 struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewS3 returns the bucket of the service at endpoint, such as
+// s3.amazonaws.com or storage.googleapis.com. The credentials are read from
+// the AWS_* or MINIO_* environment variables, or from the instance role.
+func NewS3(endpoint, bucket string, secure bool) (*S3, error) {
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+	})
+	client, err := minio.New(endpoint, &minio.Options{Creds: creds, Secure: secure})
+	if err != nil {
+		return nil, err
+	}
+	return &S3{client: client, bucket: bucket}, nil
+}
+
+func (s *S3) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	_, err := s.client.PutObject(ctx, s.bucket, name, r, size, minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	return err
+}
+
+func (s *S3) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject doesn't fail for a missing object until it is read.
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *S3) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		names = append(names, obj.Key)
+	}
+	return names, nil
+}
+
+func (s *S3) Delete(ctx context.Context, name string) error {
+	return s.client.RemoveObject(ctx, s.bucket, name, minio.RemoveObjectOptions{})
+}
@@ -0,0 +1,55 @@
+package raft
+
+import (
+	"context"
+	"errors"
+)
+
+// CommandRunner executes the commands a script issues with redis.call. The
+// writes of a command must be applied with apply, which runs them in the
+// state machine right away, and the reply is returned in RESP. db is the
+// database selected by the client of the script.
+type CommandRunner interface {
+	RunCommand(ctx context.Context, db int, args [][]byte, apply func(KVCmd) any) []byte
+}
+
+var ErrNumKeys = errors.New("ERR Number of keys can't be greater than number of args")
+
+// SetCommandRunner registers the CommandRunner used by scripts. Eval entries
+// wait until it is set, so that a script replayed at startup runs the same
+// commands as it did on the leader.
+func (s *StateMachine) SetCommandRunner(r CommandRunner) {
+	s.runner = r
+	close(s.runnerReady)
+}
+
+// Script returns the cached script with the given SHA1 digest.
+func (s *StateMachine) Script(sha string) (string, bool) {
+	return s.scripts.Lookup(sha)
+}
+
+// eval runs a Lua script. The commands it issues are applied as part of the
+// same log entry.
+func (s *StateMachine) eval(ctx context.Context, cmd KVCmd) any {
+	if cmd.NumKeys < 0 || cmd.NumKeys > len(cmd.Args) {
+		return ErrNumKeys
+	}
+	<-s.runnerReady
+
+	apply := func(c KVCmd) any {
+		return s.apply(ctx, c)
+	}
+	call := func(args [][]byte) []byte {
+		return s.runner.RunCommand(ctx, cmd.DB, args, apply)
+	}
+	return s.scripts.Eval(string(cmd.Val), cmd.Args[:cmd.NumKeys], cmd.Args[cmd.NumKeys:], call)
+}
+
+// scriptLoad caches a script and returns its SHA1 digest.
+func (s *StateMachine) scriptLoad(cmd KVCmd) any {
+	sha, err := s.scripts.Load(string(cmd.Val))
+	if err != nil {
+		return errors.New("ERR Error compiling script: " + err.Error())
+	}
+	return sha
+}
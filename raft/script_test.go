@@ -0,0 +1,40 @@
+package raft
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/script"
+	"raft-redis-cluster/store"
+)
+
+// noCommands is the CommandRunner of scripts that call no command.
+type noCommands struct{}
+
+func (noCommands) RunCommand(context.Context, int, [][]byte, func(KVCmd) any) []byte {
+	return redcon.AppendError(nil, "ERR no commands")
+}
+
+func TestSnapshotKeepsScripts(t *testing.T) {
+	s := NewStateMachine(store.NewMemoryStore())
+	s.SetCommandRunner(noCommands{})
+	loaded, _ := applyAt(t, s, 1, KVCmd{Op: ScriptLoad, Val: []byte("return 1")}).(string)
+	applyAt(t, s, 2, KVCmd{Op: Eval, Val: []byte("return ARGV[1]"), Args: [][]byte{[]byte("a")}})
+	evaluated := script.SHA1Hex("return ARGV[1]")
+
+	restored := restoreCopy(t, s)
+	restored.SetCommandRunner(noCommands{})
+	for i, sha := range []string{loaded, evaluated} {
+		body, ok := restored.Script(sha)
+		if !ok {
+			t.Fatalf("script %s lost by the restore", sha)
+		}
+		// EVALSHA runs the body it resolved the digest to.
+		res, _ := applyAt(t, restored, uint64(3+i), KVCmd{Op: Eval, Val: []byte(body), Args: [][]byte{[]byte("a")}}).([]byte)
+		if len(res) == 0 || res[0] == '-' {
+			t.Fatalf("EVALSHA %s after the restore = %q", sha, res)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package raft
+
+import (
+	"raft-redis-cluster/cluster"
+)
+
+// Slots returns the slot table shared by every node. It is kept by the
+// state machine of the first shard.
+func (s *StateMachine) Slots() *cluster.Table {
+	return s.slots
+}
+
+// setSlot changes the state of a slot in the table.
+func (s *StateMachine) setSlot(cmd KVCmd) any {
+	switch string(cmd.Val) {
+	case "migrating":
+		s.slots.SetMigrating(cmd.Slot, cmd.Shard)
+	case "node":
+		s.slots.SetOwner(cmd.Slot, cmd.Shard)
+	case "stable":
+		s.slots.Stable(cmd.Slot)
+	default:
+		return ErrUnknownOp
+	}
+	return nil
+}
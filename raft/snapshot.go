@@ -1,22 +1,78 @@
 package raft
 
 import (
-	"io"
+	"sync"
 
 	"github.com/hashicorp/raft"
+
+	"raft-redis-cluster/store"
 )
 
 var _ raft.FSMSnapshot = (*KVSnapshot)(nil)
 
 type KVSnapshot struct {
-	io.ReadWriter
+	// store is encoded into the sink key by key, while the FSM keeps
+	// applying entries.
+	store store.Snapshot
+	// header is written ahead of the store data.
+	header []byte
+	// compression is the codec the header and the store data are written with.
+	compression Compression
+	// persisted is called with the ID of the snapshot once it is saved.
+	persisted func(id string)
 }
 
 func (f *KVSnapshot) Persist(sink raft.SnapshotSink) error {
-	defer sink.Close()
-	_, err := io.Copy(sink, f)
-	return err
+	if err := f.write(sink); err != nil {
+		sink.Cancel()
+		return err
+	}
+	if err := sink.Close(); err != nil {
+		return err
+	}
+	if f.persisted != nil {
+		f.persisted(sink.ID())
+	}
+	return nil
+}
+
+func (f *KVSnapshot) write(sink raft.SnapshotSink) error {
+	w, err := compress(f.compression, sink)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(f.header); err != nil {
+		return err
+	}
+	if err := f.store.Persist(w); err != nil {
+		return err
+	}
+	return w.Close()
 }
 
 func (f *KVSnapshot) Release() {
-}
\ No newline at end of file
+	f.store.Release()
+}
+
+// SnapshotObserver is told of the snapshots of the state machine of this
+// node.
+type SnapshotObserver interface {
+	// SnapshotSaved is called with the ID of a snapshot once it is saved.
+	SnapshotSaved(id string)
+	// SnapshotRestored is called once the state machine was restored from
+	// a snapshot, such as one sent by the leader.
+	SnapshotRestored()
+}
+
+type snapshotObservers struct {
+	mu   sync.RWMutex
+	list []SnapshotObserver
+}
+
+// AddSnapshotObserver registers o to be told of the snapshots saved and
+// restored on this node.
+func (s *StateMachine) AddSnapshotObserver(o SnapshotObserver) {
+	s.snapObs.mu.Lock()
+	defer s.snapObs.mu.Unlock()
+	s.snapObs.list = append(s.snapObs.list, o)
+}
@@ -1,13 +1,27 @@
 package raft
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"math"
+	"raft-redis-cluster/acl"
+	"raft-redis-cluster/cluster"
+	"raft-redis-cluster/script"
 	"raft-redis-cluster/store"
+	"runtime/debug"
+	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/hashicorp/raft"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var _ raft.FSM = (*StateMachine)(nil)
@@ -17,25 +31,298 @@ type Op int
 const (
 	Put Op = iota
 	Del
+	Expire
+	Persist
+	// IncrBy adds the integer in Val to the integer value of Key.
+	IncrBy
+	// IncrByFloat adds the float in Val to the float value of Key.
+	IncrByFloat
+	// MSet writes all Pairs in a single transaction.
+	MSet
+	// HSet sets the hash fields in Pairs.
+	HSet
+	// HDel deletes the hash fields in Args.
+	HDel
+	// HIncrBy adds the integer in Val to the hash Field.
+	HIncrBy
+	// SAdd adds the members in Args to a set.
+	SAdd
+	// SRem removes the members in Args from a set.
+	SRem
+	// ZAdd adds the members in Pairs (member, score) to a sorted set.
+	ZAdd
+	// ZRem removes the members in Args from a sorted set.
+	ZRem
+	// SetBit sets the bit at Offset to the bit in Val.
+	SetBit
+	// BitOp stores the bitwise operation named in Val over the keys in Args.
+	BitOp
+	// PFAdd adds the elements in Args to a HyperLogLog.
+	PFAdd
+	// PFMerge merges the HyperLogLogs in Args into Key.
+	PFMerge
+	// XAdd appends the field-value pairs in Args to a stream with the ID
+	// given in Val.
+	XAdd
+	// Publish delivers the message in Val to the subscribers of the channel
+	// in Key on every node.
+	Publish
+	// Multi applies the commands in Cmds in order as one transaction and
+	// returns their responses as a []any, or nil if a key in Watch changed.
+	Multi
+	// Read is a read-only command of a Multi, with the command line in Args.
+	// It is answered by the TxReader of the node at its position in the
+	// transaction.
+	Read
+	// Eval runs the Lua script in Val with the keys and arguments in Args,
+	// the first NumKeys of which are keys, and returns its RESP reply.
+	Eval
+	// ScriptLoad adds the Lua script in Val to the script cache.
+	ScriptLoad
+	// ScriptFlush empties the script cache.
+	ScriptFlush
+	// Flush deletes every key.
+	Flush
+	// ACLSetUser applies the ACL rules in Args to the user named by Key.
+	// Passwords in the rules are digests.
+	ACLSetUser
+	// ACLDelUser deletes the ACL users named in Args.
+	ACLDelUser
+	// SetSlot changes the state of Slot in the slot table, as named in Val:
+	// "migrating" to Shard, "node" to assign it to Shard, or "stable".
+	SetSlot
+	// RestoreKey writes the value in Val, encoded by store.Dump, to Key.
+	// With CondNX it fails with ErrBusyKey when Key exists.
+	RestoreKey
+	// SetNode records the Redis address in Val of the server ID in Key, and
+	// the address advertised to clients in Field, if any.
+	SetNode
+	// DelNode forgets the Redis address of the server ID in Key.
+	DelNode
+	// Batch applies the independent writes in Cmds, coalesced by the leader
+	// into one log entry, and returns the result of each.
+	Batch
+	// Digest computes the digest of the keyspace at the index of the entry,
+	// which every replica keeps so that they can be compared.
+	Digest
+	// SetRange overwrites the string of Key from Offset with Val.
+	SetRange
+	// Append appends Val to the string of Key.
+	Append
+	// Rename moves Key to the key in Val. With CondNX it does nothing when
+	// that key exists.
+	Rename
+	// Copy copies Key to the key in Val. With CondNX it does nothing when
+	// that key exists.
+	Copy
+	// FlushDB deletes every key of the database numbered in Val, and
+	// returns the keys deleted.
+	FlushDB
+	// SwapDB swaps the keys of the two databases numbered in Args, and
+	// returns the keys changed in both.
+	SwapDB
+	// Checkpoint records the log index in Val as the last change processed
+	// by the consumer of the change feed named by Key.
+	Checkpoint
+	// SetTenant creates or updates the tenant named by Key, with the key
+	// prefix in Val and the maximum keys and bytes in Args.
+	SetTenant
+	// DelTenant deletes the tenants named in Args.
+	DelTenant
+	// Lock sets Key to the owner in Val, expiring at ExpireAt, unless
+	// another owner holds it.
+	Lock
+	// Unlock deletes Key if it holds the owner in Val.
+	Unlock
+	// Chunk appends Val to the value staged for Upload from Offset, for the
+	// command with that Upload to be applied with.
+	Chunk
 )
 
+var opNames = [...]string{
+	"Put", "Del", "Expire", "Persist", "IncrBy", "IncrByFloat", "MSet", "HSet", "HDel", "HIncrBy",
+	"SAdd", "SRem", "ZAdd", "ZRem", "SetBit", "BitOp", "PFAdd", "PFMerge", "XAdd", "Publish",
+	"Multi", "Read", "Eval", "ScriptLoad", "ScriptFlush", "Flush", "ACLSetUser", "ACLDelUser", "SetSlot", "RestoreKey",
+	"SetNode", "DelNode", "Batch", "Digest", "SetRange", "Append",
+	"Rename", "Copy", "FlushDB", "SwapDB", "Checkpoint", "SetTenant", "DelTenant",
+	"Lock", "Unlock", "Chunk",
+}
+
+func (o Op) String() string {
+	if o < 0 || int(o) >= len(opNames) {
+		return fmt.Sprintf("Op(%d)", int(o))
+	}
+	return opNames[o]
+}
+
 type KVCmd struct {
 	Op  Op     `json:"op"`
 	Key []byte `json:"key"`
 	Val []byte `json:"val"`
+	// ExpireAt is the absolute expiration time in Unix milliseconds, computed
+	// by the leader so that every replica expires the key at the same moment.
+	ExpireAt int64 `json:"expire_at,omitempty"`
+	// Cond restricts a Put to keys that already exist (XX), do not (NX) or
+	// are at Version, and a Rename or Copy to destinations that do not exist
+	// (NX).
+	Cond Cond `json:"cond,omitempty"`
+	// Version is the version of the key a Put with CondVersion requires.
+	Version uint64 `json:"version,omitempty"`
+	// KeepTTL keeps the current expiration of the key on Put.
+	KeepTTL bool `json:"keep_ttl,omitempty"`
+	// Get makes a Put return the previous value of the key.
+	Get bool `json:"get,omitempty"`
+	// Evict marks a Del that evicts the key under maxmemory, which emits an
+	// evicted event instead of del.
+	Evict bool `json:"evict,omitempty"`
+	// Expired marks a Del of a key found expired by the leader, which
+	// deletes the key only if it is still expired at the time of the entry
+	// and emits an expired event.
+	Expired bool `json:"expired,omitempty"`
+	// ScoreCond restricts a ZAdd update to scores greater (GT) or less (LT)
+	// than the current one.
+	ScoreCond ScoreCond `json:"score_cond,omitempty"`
+	// Offset is the bit offset of a SetBit, or the byte offset of a
+	// SetRange.
+	Offset int64 `json:"offset,omitempty"`
+	// Pairs holds the key-value pairs of a multi-key write, or the
+	// field-value pairs of a hash write.
+	Pairs []KVPair `json:"pairs,omitempty"`
+	// Field is the hash field targeted by the command.
+	Field []byte `json:"field,omitempty"`
+	// Args holds the fields or members of a collection command.
+	Args [][]byte `json:"args,omitempty"`
+	// Cmds holds the commands of a Multi or a Batch.
+	Cmds []KVCmd `json:"cmds,omitempty"`
+	// Watch holds the keys a Multi is conditional on.
+	Watch []WatchedKey `json:"watch,omitempty"`
+	// NumKeys is the number of keys at the head of Args for an Eval.
+	NumKeys int `json:"num_keys,omitempty"`
+	// RESP3 asks for the reply of a Read in RESP3.
+	RESP3 bool `json:"resp3,omitempty"`
+	// DB is the database the client of a Read or an Eval selected.
+	DB int `json:"db,omitempty"`
+	// Slot and Shard are the hash slot and the shard of a SetSlot.
+	Slot  int `json:"slot,omitempty"`
+	Shard int `json:"shard,omitempty"`
+	// RequestID identifies the command for a client that may retry it. The
+	// command is applied once and its retries get the same result.
+	RequestID string `json:"request_id,omitempty"`
+	// Origin identifies the client connection that sent the command, so
+	// that it is not told of the keys it wrote itself, as with CLIENT
+	// TRACKING NOLOOP.
+	Origin string `json:"origin,omitempty"`
+	// Trace is the W3C traceparent of the span replicating the command,
+	// which the FSM continues when it applies it.
+	Trace string `json:"trace,omitempty"`
+	// Upload names the value staged by the Chunk entries ahead of the
+	// command, too large for one entry, which Val completes.
+	Upload string `json:"upload,omitempty"`
+}
+
+type KVPair struct {
+	Key []byte `json:"key"`
+	Val []byte `json:"val"`
+}
+
+// Cond is a precondition on the existence or the version of the key for a
+// Put.
+type Cond int
+
+const (
+	CondNone Cond = iota
+	CondNX
+	CondXX
+	CondVersion
+)
+
+// ScoreCond is a precondition on the current score for a ZAdd.
+type ScoreCond int
+
+const (
+	ScoreAny ScoreCond = iota
+	ScoreGT
+	ScoreLT
+)
+
+// ZAddResult is the FSM response to a ZAdd command.
+type ZAddResult struct {
+	// Added is the number of new members.
+	Added int
+	// Changed is the number of members added or whose score was updated.
+	Changed int
+}
+
+// PutResult is the FSM response to a Put command.
+type PutResult struct {
+	// Applied reports whether the value was written.
+	Applied bool
+	// PrevFound reports whether the key existed before the command.
+	PrevFound bool
+	// Prev is the value held by the key before the command, set only when
+	// the command asked for it with Get.
+	Prev []byte
 }
 
 func NewStateMachine(store store.Store) *StateMachine {
-	return &StateMachine{store}
+	return &StateMachine{
+		store:       store,
+		versions:    versions{m: map[string]uint64{}, tombs: map[string]uint64{}},
+		requests:    newRequests(),
+		checkpoints: newCheckpoints(),
+		tenants:     newTenants(),
+		uploads:     newUploads(),
+		scripts:     script.New(),
+		acl:         acl.New(),
+		slots:       cluster.NewTable(),
+		nodes:       cluster.NewNodes(),
+		runnerReady: make(chan struct{}),
+	}
 }
 
 type StateMachine struct {
-	store store.Store
+	store       store.Store
+	publishers  publishers
+	notifyFlags atomic.Uint32
+	txReader    atomic.Pointer[TxReader]
+	versions    versions
+	requests    requests
+	scripts     *script.Engine
+	acl         *acl.ACL
+	slots       *cluster.Table
+	nodes       *cluster.Nodes
+	registry    atomic.Pointer[raft.StableStore]
+	runner      CommandRunner
+	runnerReady chan struct{}
+	// lastSnapshot is when the last snapshot was saved, in Unix milliseconds.
+	lastSnapshot atomic.Int64
+	// restoring is set while a snapshot is restored.
+	restoring atomic.Bool
+	// compression is the Compression of the next snapshots.
+	compression  atomic.Uint32
+	digests      digests
+	changes      changeFeed
+	invalidators invalidators
+	checkpoints  checkpoints
+	tenants      tenants
+	snapObs      snapshotObservers
+	uploads      uploads
+	// applied is the index of the last entry applied, and snapshotApplied
+	// that of the last entry in the latest snapshot saved or restored.
+	applied         atomic.Uint64
+	snapshotApplied atomic.Uint64
 }
 
 // Apply applies a Raft log entry to the key-value store.
+// Expiration is evaluated against the time the leader appended the entry,
+// so every replica reaches the same result. For the same reason, the entry
+// is applied with no deadline, whether or not the client that sent it still
+// waits for it.
 func (s *StateMachine) Apply(log *raft.Log) any {
-	ctx := context.Background()
+	s.applied.Store(log.Index)
+	ctx := store.WithTime(context.Background(), log.AppendedAt)
+	ctx = withIndex(ctx, log.Index)
+	ctx = withTerm(ctx, log.Term)
 	c := KVCmd{}
 
 	err := json.Unmarshal(log.Data, &c)
@@ -43,33 +330,732 @@ func (s *StateMachine) Apply(log *raft.Log) any {
 		return err
 	}
 
-	return s.handleRequest(ctx, c)
+	if c.Trace != "" {
+		var span trace.Span
+		ctx, span = startApply(ctx, c, log.Index)
+		defer span.End()
+	}
+	return s.apply(ctx, c)
+}
+
+// apply runs a command and the bookkeeping that follows every write. A
+// command with a RequestID that was already applied is not run again and
+// returns the first result.
+func (s *StateMachine) apply(ctx context.Context, cmd KVCmd) any {
+	if cmd.RequestID != "" {
+		if res, ok := s.requests.lookup(cmd.RequestID); ok {
+			return res
+		}
+	}
+	if cmd.Origin != "" {
+		ctx = withOrigin(ctx, cmd.Origin)
+	}
+	if cmd.Upload != "" && cmd.Op != Chunk {
+		val, err := s.uploads.take(cmd.Upload)
+		if err != nil {
+			return err
+		}
+		cmd.Val = append(val, cmd.Val...)
+	}
+	var res any
+	if err := s.checkQuota(ctx, cmd); err != nil {
+		res = err
+	} else {
+		res = s.handle(ctx, cmd)
+	}
+	s.touch(ctx, cmd, res)
+	s.recordChanges(ctx, cmd, res)
+	s.invalidate(ctx, cmd, res)
+	s.notify(ctx, cmd, res)
+	if cmd.RequestID != "" {
+		s.requests.record(ctx, cmd.RequestID, res)
+	}
+	return res
+}
+
+// handle runs handleRequest, turning a panic into an error result rather
+// than crash the node. Every replica applying the entry panics the same way,
+// so they keep the same state instead of all crashing on it.
+func (s *StateMachine) handle(ctx context.Context, cmd KVCmd) (res any) {
+	defer func() {
+		if v := recover(); v != nil {
+			slog.Default().Error("applying a command panicked", "op", cmd.Op, "index", indexOf(ctx), "panic", v, "stack", string(debug.Stack()))
+			res = fmt.Errorf("ERR internal error applying %s", cmd.Op)
+		}
+	}()
+	return s.handleRequest(ctx, cmd)
+}
+
+// snapshotMagics prefix snapshots that carry the replicated state other
+// than the store data ahead of it. The version of a snapshot is the index of
+// its magic plus one: version 1 carries the key versions, version 2 adds the
+// ACL, version 3 the slot table, version 4 the node registry, version 5 the
+// results of the commands with a request ID, version 6 the advertised
+// addresses of the nodes, version 7 the checkpoints of the consumers of
+// the change feed, version 8 the tenants, version 9 the values being
+// written in chunks, version 10 the scripts cached for EVALSHA and version 11
+// the index of the last entry applied. Snapshots without a magic hold only the store data.
+var snapshotMagics = [][]byte{
+	[]byte("RKVSNAP1"),
+	[]byte("RKVSNAP2"),
+	[]byte("RKVSNAP3"),
+	[]byte("RKVSNAP4"),
+	[]byte("RKVSNAP5"),
+	[]byte("RKVSNAP6"),
+	[]byte("RKVSNAP7"),
+	[]byte("RKVSNAP8"),
+	[]byte("RKVSNAP9"),
+	// Magics keep a length of 8 bytes, so that none is the prefix of
+	// another, and go on in hexadecimal.
+	[]byte("RKVSNAPA"),
+	[]byte("RKVSNAPB"),
 }
 
 // Restore stores the key-value store to a previous state.
 func (s *StateMachine) Restore(rc io.ReadCloser) error {
-	return s.store.Restore(rc)
+	s.restoring.Store(true)
+	defer s.restoring.Store(false)
+	r, done, err := decompress(bufio.NewReader(rc))
+	if err != nil {
+		return err
+	}
+	defer done()
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(snapshotMagics[0]))
+	version := 0
+	for i, m := range snapshotMagics {
+		if bytes.Equal(magic, m) {
+			version = i + 1
+			br.Discard(len(m))
+		}
+	}
+
+	s.versions.reset()
+	s.requests.reset()
+	s.acl.Reset()
+	s.slots.Reset()
+	s.nodes.Reset()
+	s.checkpoints.reset()
+	s.tenants.reset()
+	s.uploads.reset()
+	s.scripts.Flush()
+	s.changes.restored()
+	if version >= 1 {
+		if err := s.versions.decode(br); err != nil {
+			return err
+		}
+	}
+	if version >= 2 {
+		if err := s.acl.Decode(br); err != nil {
+			return err
+		}
+	}
+	if version >= 3 {
+		if err := s.slots.Decode(br); err != nil {
+			return err
+		}
+	}
+	if version >= 4 {
+		if err := s.nodes.Decode(br); err != nil {
+			return err
+		}
+		if err := s.mirrorNodes(); err != nil {
+			return err
+		}
+	}
+	if version >= 5 {
+		if err := s.requests.decode(br); err != nil {
+			return err
+		}
+	}
+	if version >= 6 {
+		if err := s.nodes.DecodeAdvertised(br); err != nil {
+			return err
+		}
+	}
+	if version >= 7 {
+		if err := s.checkpoints.decode(br); err != nil {
+			return err
+		}
+	}
+	if version >= 8 {
+		if err := s.tenants.decode(br); err != nil {
+			return err
+		}
+	}
+	if version >= 9 {
+		if err := s.uploads.decode(br); err != nil {
+			return err
+		}
+	}
+	if version >= 10 {
+		if err := s.scripts.Decode(br); err != nil {
+			return err
+		}
+	}
+	var applied uint64
+	if version >= 11 {
+		if err := binary.Read(br, binary.BigEndian, &applied); err != nil {
+			return err
+		}
+	}
+	s.applied.Store(applied)
+	s.snapshotApplied.Store(applied)
+	// A store that kept on disk the keys of this snapshot, as the node
+	// stopped after saving it, is left as it is.
+	kept := false
+	if ck, ok := s.store.(store.Checkpointer); ok {
+		if kept, err = ck.Resume(applied); err != nil {
+			return err
+		}
+	}
+	if !kept {
+		if err := s.store.Restore(br); err != nil {
+			return err
+		}
+	}
+	s.tenants.mu.RLock()
+	s.store.SetUsagePrefixes(s.tenants.prefixes())
+	s.tenants.mu.RUnlock()
+	s.invalidateKeys(nil, "")
+	s.snapObs.mu.RLock()
+	defer s.snapObs.mu.RUnlock()
+	for _, o := range s.snapObs.list {
+		o.SnapshotRestored()
+	}
+	return nil
 }
 
-// Snapshot returns a KVSnapshot of the key-value store.
+// Snapshot returns a KVSnapshot of the key-value store. The store only
+// keeps references to its entries here and encodes them in Persist, so the
+// FSM goes on applying entries while the snapshot is written.
 func (s *StateMachine) Snapshot() (raft.FSMSnapshot, error) {
-	rc, err := s.store.Snapshot()
+	header := bytes.NewBuffer(append([]byte(nil), snapshotMagics[len(snapshotMagics)-1]...))
+	if err := s.versions.encode(header); err != nil {
+		return nil, err
+	}
+	if err := s.acl.Encode(header); err != nil {
+		return nil, err
+	}
+	if err := s.slots.Encode(header); err != nil {
+		return nil, err
+	}
+	if err := s.nodes.Encode(header); err != nil {
+		return nil, err
+	}
+	if err := s.requests.encode(header); err != nil {
+		return nil, err
+	}
+	if err := s.nodes.EncodeAdvertised(header); err != nil {
+		return nil, err
+	}
+	if err := s.checkpoints.encode(header); err != nil {
+		return nil, err
+	}
+	if err := s.tenants.encode(header); err != nil {
+		return nil, err
+	}
+	if err := s.uploads.encode(header); err != nil {
+		return nil, err
+	}
+	if err := s.scripts.Encode(header); err != nil {
+		return nil, err
+	}
+	applied := s.applied.Load()
+	if err := binary.Write(header, binary.BigEndian, applied); err != nil {
+		return nil, err
+	}
+
+	snap, err := s.store.Snapshot()
 	if err != nil {
 		return nil, err
 	}
+	return &KVSnapshot{
+		store:       snap,
+		header:      header.Bytes(),
+		compression: Compression(s.compression.Load()),
+		persisted: func(id string) {
+			s.snapshotApplied.Store(applied)
+			s.snapshotPersisted(id)
+		},
+	}, nil
+}
+
+// CheckpointStore records, in a store that keeps its keys on disk across
+// restarts, that they are those of the latest snapshot, so that restoring
+// the snapshot on the next start keeps them instead of writing them again.
+// It must be called once Raft is shut down, and does nothing when entries
+// were applied after the latest snapshot.
+func (s *StateMachine) CheckpointStore() error {
+	ck, ok := s.store.(store.Checkpointer)
+	applied := s.applied.Load()
+	if !ok || applied == 0 || applied != s.snapshotApplied.Load() {
+		return nil
+	}
+	return ck.Checkpoint(applied)
+}
+
+func (s *StateMachine) snapshotPersisted(id string) {
+	s.lastSnapshot.Store(time.Now().UnixMilli())
+	s.snapObs.mu.RLock()
+	defer s.snapObs.mu.RUnlock()
+	for _, o := range s.snapObs.list {
+		o.SnapshotSaved(id)
+	}
+}
 
-	return &KVSnapshot{rc}, nil
+// Restoring reports whether the state machine is being restored from a
+// snapshot, when its keys are incomplete.
+func (s *StateMachine) Restoring() bool {
+	return s.restoring.Load()
+}
+
+// LastSnapshot returns when the last snapshot of the node was saved, or the
+// zero time if none was since the node started.
+func (s *StateMachine) LastSnapshot() time.Time {
+	ms := s.lastSnapshot.Load()
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
 }
 
 var ErrUnknownOp = errors.New("unknown op")
 
-func (s *StateMachine) handleRequest(ctx context.Context, cmd KVCmd) error {
+var (
+	ErrNotInteger = errors.New("ERR value is not an integer or out of range")
+	ErrNotFloat   = errors.New("ERR value is not a valid float")
+	ErrOverflow   = errors.New("ERR increment or decrement would overflow")
+	ErrNaN        = errors.New("ERR increment would produce NaN or Infinity")
+	ErrBusyKey    = errors.New("BUSYKEY Target key name already exists.")
+)
+
+func (s *StateMachine) handleRequest(ctx context.Context, cmd KVCmd) any {
 	switch cmd.Op {
 	case Put:
-		return s.store.Put(ctx, cmd.Key, cmd.Val)
+		return s.put(ctx, cmd)
 	case Del:
-		return s.store.Delete(ctx, cmd.Key)
+		return s.del(ctx, cmd)
+	case Expire:
+		return s.expire(ctx, cmd)
+	case Persist:
+		return s.persist(ctx, cmd)
+	case IncrBy:
+		return s.incrBy(ctx, cmd)
+	case IncrByFloat:
+		return s.incrByFloat(ctx, cmd)
+	case MSet:
+		return s.mset(ctx, cmd)
+	case HSet:
+		return s.hset(ctx, cmd)
+	case HDel:
+		return countOrErr(s.store.HDel(ctx, cmd.Key, cmd.Args))
+	case HIncrBy:
+		return s.hincrBy(ctx, cmd)
+	case SAdd:
+		return countOrErr(s.store.SAdd(ctx, cmd.Key, cmd.Args))
+	case SRem:
+		return countOrErr(s.store.SRem(ctx, cmd.Key, cmd.Args))
+	case ZAdd:
+		return s.zadd(ctx, cmd)
+	case ZRem:
+		return countOrErr(s.store.ZRem(ctx, cmd.Key, cmd.Args))
+	case SetBit:
+		return s.setBit(ctx, cmd)
+	case BitOp:
+		return s.bitOp(ctx, cmd)
+	case SetRange:
+		return s.setRange(ctx, cmd)
+	case Append:
+		return s.appendVal(ctx, cmd)
+	case Rename:
+		return s.rename(ctx, cmd)
+	case Copy:
+		return s.copyKey(ctx, cmd)
+	case FlushDB:
+		return s.flushDB(ctx, cmd)
+	case SwapDB:
+		return s.swapDB(ctx, cmd)
+	case Checkpoint:
+		return s.checkpoint(cmd)
+	case SetTenant:
+		return s.setTenant(cmd)
+	case DelTenant:
+		return s.delTenant(cmd)
+	case Lock:
+		return s.lock(ctx, cmd)
+	case Unlock:
+		return s.unlock(ctx, cmd)
+	case Chunk:
+		return s.chunk(ctx, cmd)
+	case PFAdd:
+		return s.pfadd(ctx, cmd)
+	case PFMerge:
+		return s.pfmerge(ctx, cmd)
+	case XAdd:
+		return s.xadd(ctx, cmd)
+	case Publish:
+		return s.publish(ctx, cmd)
+	case Multi:
+		return s.multi(ctx, cmd)
+	case Eval:
+		return s.eval(ctx, cmd)
+	case ScriptLoad:
+		return s.scriptLoad(cmd)
+	case ScriptFlush:
+		s.scripts.Flush()
+		return nil
+	case Flush:
+		return s.store.Flush(ctx)
+	case ACLSetUser:
+		return s.aclSetUser(cmd)
+	case ACLDelUser:
+		return s.aclDelUser(cmd)
+	case SetSlot:
+		return s.setSlot(cmd)
+	case RestoreKey:
+		return s.restoreKey(ctx, cmd)
+	case SetNode, DelNode:
+		return s.setNode(cmd)
+	case Batch:
+		return s.batch(ctx, cmd)
+	case Digest:
+		return s.digest(ctx)
 	default:
 		return ErrUnknownOp
 	}
-}
\ No newline at end of file
+}
+
+func (s *StateMachine) restoreKey(ctx context.Context, cmd KVCmd) any {
+	if cmd.Cond == CondNX {
+		ok, err := s.store.Exists(ctx, cmd.Key)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return ErrBusyKey
+		}
+	}
+	return s.store.RestoreKey(ctx, cmd.Key, cmd.Val)
+}
+
+// put writes a value honoring the NX/XX condition, KEEPTTL and an optional
+// expiration. With Get, the previous value is returned so SET ... GET can be
+// answered; it fails if the key holds a non-string value.
+func (s *StateMachine) put(ctx context.Context, cmd KVCmd) any {
+	res := PutResult{}
+	kind, err := s.store.Type(ctx, cmd.Key)
+	if err != nil {
+		return err
+	}
+	res.PrevFound = kind != store.KindNone
+
+	if cmd.Get && res.PrevFound {
+		res.Prev, err = s.store.Get(ctx, cmd.Key)
+		if err != nil {
+			return err
+		}
+	}
+
+	if (cmd.Cond == CondNX && res.PrevFound) || (cmd.Cond == CondXX && !res.PrevFound) {
+		return res
+	}
+	if cmd.Cond == CondVersion {
+		v, err := s.Version(ctx, cmd.Key)
+		if err != nil {
+			return err
+		}
+		if v != cmd.Version {
+			return res
+		}
+	}
+
+	var keep time.Time
+	if cmd.KeepTTL && res.PrevFound {
+		keep, err = s.store.TTL(ctx, cmd.Key)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := s.store.Put(ctx, cmd.Key, cmd.Val); err != nil {
+		return err
+	}
+
+	at := keep
+	if cmd.ExpireAt != 0 {
+		at = time.UnixMilli(cmd.ExpireAt)
+	}
+	if !at.IsZero() {
+		if err := s.store.Expire(ctx, cmd.Key, at); err != nil {
+			return err
+		}
+	}
+
+	res.Applied = true
+	return res
+}
+
+// del deletes a key and returns 1 if it existed, 0 otherwise.
+func (s *StateMachine) del(ctx context.Context, cmd KVCmd) any {
+	if cmd.Expired {
+		ok, err := s.store.DeleteExpired(ctx, cmd.Key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return 0
+		}
+		return 1
+	}
+	ok, err := s.store.Exists(ctx, cmd.Key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return 0
+	}
+	if err := s.store.Delete(ctx, cmd.Key); err != nil {
+		return err
+	}
+	return 1
+}
+
+// expire sets the expiration of a key and reports whether the key existed.
+// A deadline that has already passed deletes the key immediately.
+func (s *StateMachine) expire(ctx context.Context, cmd KVCmd) any {
+	ok, err := s.store.Exists(ctx, cmd.Key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return false
+	}
+
+	at := time.UnixMilli(cmd.ExpireAt)
+	if !at.After(store.Now(ctx)) {
+		if err := s.store.Delete(ctx, cmd.Key); err != nil {
+			return err
+		}
+		return true
+	}
+
+	if err := s.store.Expire(ctx, cmd.Key, at); err != nil {
+		return err
+	}
+	return true
+}
+
+// persist removes the expiration of a key and reports whether one was removed.
+func (s *StateMachine) persist(ctx context.Context, cmd KVCmd) any {
+	at, err := s.store.TTL(ctx, cmd.Key)
+	if err != nil {
+		if errors.Is(err, store.ErrKeyNotFound) {
+			return false
+		}
+		return err
+	}
+	if at.IsZero() {
+		return false
+	}
+
+	if err := s.store.Persist(ctx, cmd.Key); err != nil {
+		return err
+	}
+	return true
+}
+
+// update overwrites the value of a key while keeping its expiration,
+// as Redis does for commands that modify a value in place.
+func (s *StateMachine) update(ctx context.Context, key []byte, val []byte) error {
+	at, err := s.store.TTL(ctx, key)
+	if err != nil && !errors.Is(err, store.ErrKeyNotFound) {
+		return err
+	}
+
+	if err := s.store.Put(ctx, key, val); err != nil {
+		return err
+	}
+	if at.IsZero() {
+		return nil
+	}
+	return s.store.Expire(ctx, key, at)
+}
+
+// getOr returns the value of a key, or def if it does not exist.
+func (s *StateMachine) getOr(ctx context.Context, key []byte, def []byte) ([]byte, error) {
+	v, err := s.store.Get(ctx, key)
+	if errors.Is(err, store.ErrKeyNotFound) {
+		return def, nil
+	}
+	return v, err
+}
+
+// incrBy adds an integer delta to a key and returns the new value as int64.
+func (s *StateMachine) incrBy(ctx context.Context, cmd KVCmd) any {
+	delta, err := strconv.ParseInt(string(cmd.Val), 10, 64)
+	if err != nil {
+		return ErrNotInteger
+	}
+
+	cur, err := s.getOr(ctx, cmd.Key, []byte("0"))
+	if err != nil {
+		return err
+	}
+	n, err := strconv.ParseInt(string(cur), 10, 64)
+	if err != nil {
+		return ErrNotInteger
+	}
+
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return ErrOverflow
+	}
+	n += delta
+
+	if err := s.update(ctx, cmd.Key, []byte(strconv.FormatInt(n, 10))); err != nil {
+		return err
+	}
+	return n
+}
+
+// incrByFloat adds a float delta to a key and returns the new value in its
+// string representation.
+func (s *StateMachine) incrByFloat(ctx context.Context, cmd KVCmd) any {
+	delta, err := strconv.ParseFloat(string(cmd.Val), 64)
+	if err != nil {
+		return ErrNotFloat
+	}
+
+	cur, err := s.getOr(ctx, cmd.Key, []byte("0"))
+	if err != nil {
+		return err
+	}
+	f, err := strconv.ParseFloat(string(cur), 64)
+	if err != nil {
+		return ErrNotFloat
+	}
+
+	f += delta
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return ErrNaN
+	}
+
+	b := []byte(strconv.FormatFloat(f, 'f', -1, 64))
+	if err := s.update(ctx, cmd.Key, b); err != nil {
+		return err
+	}
+	return b
+}
+
+// mset writes all pairs atomically so readers never observe a partial batch.
+func (s *StateMachine) mset(ctx context.Context, cmd KVCmd) any {
+	return s.store.Txn(ctx, func(ctx context.Context, txn store.Txn) error {
+		for _, p := range cmd.Pairs {
+			if err := txn.Put(ctx, p.Key, p.Val); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// hset sets hash fields and returns the number of fields added.
+func (s *StateMachine) hset(ctx context.Context, cmd KVCmd) any {
+	fields := make(map[string][]byte, len(cmd.Pairs))
+	for _, p := range cmd.Pairs {
+		fields[string(p.Key)] = p.Val
+	}
+	n, err := s.store.HSet(ctx, cmd.Key, fields)
+	if err != nil {
+		return err
+	}
+	return n
+}
+
+// countOrErr turns the (count, error) result of a store call into an FSM
+// response.
+func countOrErr(n int, err error) any {
+	if err != nil {
+		return err
+	}
+	return n
+}
+
+// hincrBy adds an integer delta to a hash field and returns the new value.
+func (s *StateMachine) hincrBy(ctx context.Context, cmd KVCmd) any {
+	delta, err := strconv.ParseInt(string(cmd.Val), 10, 64)
+	if err != nil {
+		return ErrNotInteger
+	}
+
+	cur, err := s.store.HGet(ctx, cmd.Key, cmd.Field)
+	if errors.Is(err, store.ErrKeyNotFound) {
+		cur = []byte("0")
+	} else if err != nil {
+		return err
+	}
+	n, err := strconv.ParseInt(string(cur), 10, 64)
+	if err != nil {
+		return errors.New("ERR hash value is not an integer")
+	}
+
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return ErrOverflow
+	}
+	n += delta
+
+	_, err = s.store.HSet(ctx, cmd.Key, map[string][]byte{string(cmd.Field): []byte(strconv.FormatInt(n, 10))})
+	if err != nil {
+		return err
+	}
+	return n
+}
+
+// zadd adds or updates sorted set members honoring the NX/XX and GT/LT
+// conditions.
+func (s *StateMachine) zadd(ctx context.Context, cmd KVCmd) any {
+	res := ZAddResult{}
+	pending := map[string]float64{}
+	var members []store.ZMember
+
+	for _, p := range cmd.Pairs {
+		score, err := strconv.ParseFloat(string(p.Val), 64)
+		if err != nil || math.IsNaN(score) {
+			return ErrNotFloat
+		}
+
+		cur, exists := pending[string(p.Key)]
+		if !exists {
+			cur, err = s.store.ZScore(ctx, cmd.Key, p.Key)
+			switch {
+			case err == nil:
+				exists = true
+			case !errors.Is(err, store.ErrKeyNotFound):
+				return err
+			}
+		}
+
+		if (cmd.Cond == CondNX && exists) || (cmd.Cond == CondXX && !exists) {
+			continue
+		}
+		if exists {
+			if score == cur || (cmd.ScoreCond == ScoreGT && score < cur) || (cmd.ScoreCond == ScoreLT && score > cur) {
+				continue
+			}
+		} else {
+			res.Added++
+		}
+		res.Changed++
+
+		pending[string(p.Key)] = score
+		members = append(members, store.ZMember{Member: p.Key, Score: score})
+	}
+
+	if len(members) == 0 {
+		return res
+	}
+	if _, err := s.store.ZAdd(ctx, cmd.Key, members); err != nil {
+		return err
+	}
+	return res
+}
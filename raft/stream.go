@@ -0,0 +1,71 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"math"
+	"raft-redis-cluster/store"
+	"strings"
+)
+
+var ErrStreamIDZero = errors.New("ERR The ID specified in XADD must be greater than 0-0")
+
+// xadd appends an entry to a stream and returns its ID. Auto-generated IDs
+// are derived from the time the leader appended the log entry, so every
+// replica assigns the same ID.
+func (s *StateMachine) xadd(ctx context.Context, cmd KVCmd) any {
+	last, err := s.store.XLastID(ctx, cmd.Key)
+	if err != nil {
+		return err
+	}
+
+	id, err := nextStreamID(string(cmd.Val), last, uint64(store.Now(ctx).UnixMilli()))
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.XAdd(ctx, cmd.Key, id, cmd.Args); err != nil {
+		return err
+	}
+	return id
+}
+
+// nextStreamID resolves an XADD ID argument ("*", "ms-*" or an explicit ID)
+// against the last ID of the stream.
+func nextStreamID(spec string, last store.StreamID, nowMs uint64) (store.StreamID, error) {
+	if spec == "*" {
+		if nowMs > last.Ms {
+			return store.StreamID{Ms: nowMs}, nil
+		}
+		if last.Seq == math.MaxUint64 {
+			return store.StreamID{}, store.ErrStreamID
+		}
+		return store.StreamID{Ms: last.Ms, Seq: last.Seq + 1}, nil
+	}
+
+	if ms, ok := strings.CutSuffix(spec, "-*"); ok {
+		id, err := store.ParseStreamID(ms, 0)
+		if err != nil {
+			return store.StreamID{}, err
+		}
+		if id.Ms == last.Ms {
+			if last.Seq == math.MaxUint64 {
+				return store.StreamID{}, store.ErrStreamID
+			}
+			id.Seq = last.Seq + 1
+		}
+		if id.Compare(last) <= 0 {
+			return store.StreamID{}, store.ErrStreamID
+		}
+		return id, nil
+	}
+
+	id, err := store.ParseStreamID(spec, 0)
+	if err != nil {
+		return store.StreamID{}, err
+	}
+	if id.Compare(store.StreamID{}) == 0 {
+		return store.StreamID{}, ErrStreamIDZero
+	}
+	return id, nil
+}
@@ -0,0 +1,57 @@
+package raft
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrStringTooLong = errors.New("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+
+// MaxStringLen is the largest string SETRANGE and APPEND may build, the
+// 512MB limit of Redis strings.
+const MaxStringLen = 512 << 20
+
+// setRange overwrites the value of Key from Offset with Val, padding it with
+// zero bytes as needed, and returns the new length. An empty Val leaves the
+// key unchanged, and is not created when missing.
+func (s *StateMachine) setRange(ctx context.Context, cmd KVCmd) any {
+	if cmd.Offset < 0 || cmd.Offset+int64(len(cmd.Val)) > MaxStringLen {
+		return ErrStringTooLong
+	}
+
+	cur, err := s.getOr(ctx, cmd.Key, nil)
+	if err != nil {
+		return err
+	}
+	if len(cmd.Val) == 0 {
+		return len(cur)
+	}
+
+	b := make([]byte, max(int64(len(cur)), cmd.Offset+int64(len(cmd.Val))))
+	copy(b, cur)
+	copy(b[cmd.Offset:], cmd.Val)
+
+	if err := s.update(ctx, cmd.Key, b); err != nil {
+		return err
+	}
+	return len(b)
+}
+
+// appendVal appends Val to the value of Key, created empty when missing, and
+// returns the new length.
+func (s *StateMachine) appendVal(ctx context.Context, cmd KVCmd) any {
+	cur, err := s.getOr(ctx, cmd.Key, nil)
+	if err != nil {
+		return err
+	}
+	if len(cur)+len(cmd.Val) > MaxStringLen {
+		return ErrStringTooLong
+	}
+
+	b := make([]byte, 0, len(cur)+len(cmd.Val))
+	b = append(append(b, cur...), cmd.Val...)
+	if err := s.update(ctx, cmd.Key, b); err != nil {
+		return err
+	}
+	return len(b)
+}
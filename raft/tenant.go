@@ -0,0 +1,223 @@
+package raft
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"raft-redis-cluster/store"
+)
+
+// Tenant is a part of the keyspace, the keys starting with Prefix in every
+// database, whose quotas are enforced as the log is applied, so that every
+// replica refuses the same writes. A key belongs to the tenant with the
+// longest prefix it starts with.
+type Tenant struct {
+	Name   string
+	Prefix string
+	// MaxKeys and MaxMemory limit the number of keys of the tenant and the
+	// bytes they use. Zero means no limit.
+	MaxKeys   int64
+	MaxMemory int64
+}
+
+// tenants holds the tenants of the keyspace by name. The usage of each is
+// counted by the store.
+type tenants struct {
+	mu sync.RWMutex
+	m  map[string]Tenant
+}
+
+func newTenants() tenants {
+	return tenants{m: map[string]Tenant{}}
+}
+
+var errTenantPrefix = errors.New("ERR the prefix of a tenant can't be empty")
+
+// Tenants returns the tenants sorted by name.
+func (s *StateMachine) Tenants() []Tenant {
+	s.tenants.mu.RLock()
+	defer s.tenants.mu.RUnlock()
+
+	ts := make([]Tenant, 0, len(s.tenants.m))
+	for _, t := range s.tenants.m {
+		ts = append(ts, t)
+	}
+	slices.SortFunc(ts, func(a, b Tenant) int { return strings.Compare(a.Name, b.Name) })
+	return ts
+}
+
+// Tenant returns the tenant named name.
+func (s *StateMachine) Tenant(name string) (Tenant, bool) {
+	s.tenants.mu.RLock()
+	defer s.tenants.mu.RUnlock()
+	t, ok := s.tenants.m[name]
+	return t, ok
+}
+
+// setTenant creates or updates the tenant named by Key, with the prefix in
+// Val and the quotas in Args: the maximum number of keys, then of bytes.
+func (s *StateMachine) setTenant(cmd KVCmd) any {
+	if len(cmd.Args) != 2 {
+		return ErrUnknownOp
+	}
+	t := Tenant{Name: string(cmd.Key), Prefix: string(cmd.Val)}
+	if t.Prefix == "" {
+		return errTenantPrefix
+	}
+	var err error
+	if t.MaxKeys, err = strconv.ParseInt(string(cmd.Args[0]), 10, 64); err != nil || t.MaxKeys < 0 {
+		return ErrNotInteger
+	}
+	if t.MaxMemory, err = strconv.ParseInt(string(cmd.Args[1]), 10, 64); err != nil || t.MaxMemory < 0 {
+		return ErrNotInteger
+	}
+
+	s.tenants.mu.Lock()
+	defer s.tenants.mu.Unlock()
+	for _, o := range s.tenants.m {
+		if o.Name != t.Name && o.Prefix == t.Prefix {
+			return fmt.Errorf("ERR prefix '%s' already belongs to tenant '%s'", t.Prefix, o.Name)
+		}
+	}
+	s.tenants.m[t.Name] = t
+	s.store.SetUsagePrefixes(s.tenants.prefixes())
+	return nil
+}
+
+// delTenant deletes the tenants named in Args and returns how many existed.
+// Their keys are kept.
+func (s *StateMachine) delTenant(cmd KVCmd) any {
+	s.tenants.mu.Lock()
+	defer s.tenants.mu.Unlock()
+
+	n := 0
+	for _, name := range cmd.Args {
+		if _, ok := s.tenants.m[string(name)]; ok {
+			delete(s.tenants.m, string(name))
+			n++
+		}
+	}
+	if n > 0 {
+		s.store.SetUsagePrefixes(s.tenants.prefixes())
+	}
+	return n
+}
+
+// checkQuota returns an OOM error when cmd would add a key to a tenant at
+// its maximum number of keys, or grow a tenant using more than its maximum
+// memory. Like maxmemory, the quota on memory is checked before the write,
+// so the write that crosses it still succeeds.
+func (s *StateMachine) checkQuota(ctx context.Context, cmd KVCmd) error {
+	keys := quotaKeys(cmd)
+	if len(keys) == 0 {
+		return nil
+	}
+	s.tenants.mu.RLock()
+	defer s.tenants.mu.RUnlock()
+	if len(s.tenants.m) == 0 {
+		return nil
+	}
+
+	added := map[string]int64{}
+	seen := map[string]bool{}
+	for _, k := range keys {
+		t, ok := s.tenants.of(k)
+		if !ok || seen[string(k)] {
+			continue
+		}
+		seen[string(k)] = true
+		u := s.store.PrefixUsage(t.Prefix)
+		if t.MaxMemory > 0 && u.Bytes > t.MaxMemory {
+			return fmt.Errorf("OOM command not allowed when used memory of tenant '%s' > 'maxmemory'.", t.Name)
+		}
+		if t.MaxKeys == 0 {
+			continue
+		}
+		if ok, err := s.store.Exists(ctx, k); err != nil {
+			return err
+		} else if ok {
+			continue
+		}
+		// A key renamed within the tenant doesn't add to its keys.
+		if from, ok := s.tenants.of(cmd.Key); cmd.Op == Rename && ok && from.Name == t.Name {
+			continue
+		}
+		added[t.Name]++
+		if u.Keys+added[t.Name] > t.MaxKeys {
+			return fmt.Errorf("OOM command not allowed when keys of tenant '%s' > 'maxkeys'.", t.Name)
+		}
+	}
+	return nil
+}
+
+// quotaKeys returns the keys cmd may add or grow. The commands that only
+// remove data, and those nested in a Multi, a Batch or an Eval, which are
+// checked as they are applied, return none.
+func quotaKeys(cmd KVCmd) [][]byte {
+	switch cmd.Op {
+	case Put, IncrBy, IncrByFloat, HSet, HIncrBy, SAdd, ZAdd, SetBit, BitOp, PFAdd, PFMerge, XAdd, SetRange, Append, RestoreKey, Lock:
+		return [][]byte{cmd.Key}
+	case Rename, Copy:
+		return [][]byte{cmd.Val}
+	case MSet:
+		keys := make([][]byte, len(cmd.Pairs))
+		for i, p := range cmd.Pairs {
+			keys[i] = p.Key
+		}
+		return keys
+	}
+	return nil
+}
+
+// of returns the tenant of key, the one with the longest prefix the key
+// starts with in its database. The caller holds mu.
+func (t *tenants) of(key []byte) (Tenant, bool) {
+	_, k := store.SplitDBKey(key)
+	var best Tenant
+	found := false
+	for _, tn := range t.m {
+		if strings.HasPrefix(string(k), tn.Prefix) && (!found || len(tn.Prefix) > len(best.Prefix)) {
+			best, found = tn, true
+		}
+	}
+	return best, found
+}
+
+// prefixes returns the prefixes of the tenants. The caller holds mu.
+func (t *tenants) prefixes() []string {
+	ps := make([]string, 0, len(t.m))
+	for _, tn := range t.m {
+		ps = append(ps, tn.Prefix)
+	}
+	return ps
+}
+
+func (t *tenants) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.m = map[string]Tenant{}
+}
+
+func (t *tenants) encode(w io.Writer) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return gob.NewEncoder(w).Encode(t.m)
+}
+
+func (t *tenants) decode(r io.Reader) error {
+	m := map[string]Tenant{}
+	if err := gob.NewDecoder(r).Decode(&m); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.m = m
+	return nil
+}
@@ -0,0 +1,50 @@
+package raft
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// tlsStreamLayer is a raft.StreamLayer that carries the Raft RPCs, log
+// replication and snapshot transfer over TLS.
+type tlsStreamLayer struct {
+	net.Listener
+	advertise net.Addr
+	client    *tls.Config
+}
+
+func (t *tlsStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	return tls.DialWithDialer(dialer, "tcp", string(address), t.client)
+}
+
+func (t *tlsStreamLayer) Addr() net.Addr {
+	if t.advertise != nil {
+		return t.advertise
+	}
+	return t.Listener.Addr()
+}
+
+// NewTLSTransport returns a Raft transport that listens on bindAddr and
+// connects to its peers over TLS. server should require client
+// certificates and client should present one, so that peers authenticate
+// each other. The certificates of the peers must be valid for the host of
+// their Raft address.
+func NewTLSTransport(bindAddr string, advertise net.Addr, server, client *tls.Config, maxPool int, timeout time.Duration, logOutput io.Writer) (*raft.NetworkTransport, error) {
+	if server.ClientAuth != tls.RequireAndVerifyClientCert {
+		return nil, errors.New("raft TLS transport requires verified client certificates")
+	}
+
+	ln, err := tls.Listen("tcp", bindAddr, server)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := &tlsStreamLayer{Listener: ln, advertise: advertise, client: client}
+	return raft.NewNetworkTransport(stream, maxPool, timeout, logOutput), nil
+}
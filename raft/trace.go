@@ -0,0 +1,23 @@
+package raft
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer traces the application of the entries written by traced commands.
+var tracer = otel.Tracer("raft-redis-cluster/raft")
+
+// startApply starts the span of applying cmd, the entry at index, as a child
+// of the span of the command that wrote it.
+func startApply(ctx context.Context, cmd KVCmd, index uint64) (context.Context, trace.Span) {
+	ctx = propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{"traceparent": cmd.Trace})
+	return tracer.Start(ctx, "fsm.apply", trace.WithAttributes(
+		attribute.Int64("raft.index", int64(index)),
+		attribute.Int("raft.op", int(cmd.Op)),
+	))
+}
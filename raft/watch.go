@@ -0,0 +1,247 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"io"
+	"slices"
+	"sync"
+)
+
+// WatchedKey is a key watched by a transaction with the version it had when
+// the client watched it.
+type WatchedKey struct {
+	Key     []byte `json:"key"`
+	Version uint64 `json:"version"`
+}
+
+// missingVersion marks the version of a key that does not exist.
+const missingVersion = 1 << 63
+
+// maxTombstones is how many removed keys keep the index of their removal.
+// Beyond it, the older half is forgotten.
+const maxTombstones = 1 << 16
+
+// versions tracks the index of the last log entry that wrote each key. Since
+// it is maintained while applying the log and saved in snapshots, every
+// replica reports the same versions at the same index.
+type versions struct {
+	mu sync.RWMutex
+	m  map[string]uint64
+	// tombs is the index of the entry that removed each key, which serves
+	// as its version while it is missing, so a key that is created and
+	// removed again still counts as changed, and one that stays missing
+	// doesn't, whatever else is removed.
+	tombs map[string]uint64
+	// deleted is the version of the missing keys with no tombstone: the
+	// index of the last FLUSHALL, or of the newest tombstone forgotten.
+	deleted uint64
+}
+
+type indexKey struct{}
+
+// withIndex returns a context carrying the index of the log entry being
+// applied.
+func withIndex(ctx context.Context, index uint64) context.Context {
+	return context.WithValue(ctx, indexKey{}, index)
+}
+
+func indexOf(ctx context.Context) uint64 {
+	index, _ := ctx.Value(indexKey{}).(uint64)
+	return index
+}
+
+// KeyVersion returns the current version of key. The version changes every
+// time the key is written, deleted or expires. It fails when the store gives
+// up reading the key as ctx is done, which never happens while an entry is
+// applied, as the entries are applied with no deadline.
+func (s *StateMachine) KeyVersion(ctx context.Context, key []byte) (uint64, error) {
+	ok, err := s.store.Exists(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	s.versions.mu.RLock()
+	defer s.versions.mu.RUnlock()
+
+	if !ok {
+		if v, ok := s.versions.tombs[string(key)]; ok {
+			return v | missingVersion, nil
+		}
+		return s.versions.deleted | missingVersion, nil
+	}
+	return s.versions.m[string(key)], nil
+}
+
+// Version returns the version of key for a conditional write: the index of
+// the last log entry that wrote it, or 0 when the key does not exist.
+func (s *StateMachine) Version(ctx context.Context, key []byte) (uint64, error) {
+	v, err := s.KeyVersion(ctx, key)
+	if err != nil || v&missingVersion != 0 {
+		return 0, err
+	}
+	return v, nil
+}
+
+// watchChanged reports whether any watched key changed since it was watched.
+// A key whose version can't be read counts as changed.
+func (s *StateMachine) watchChanged(ctx context.Context, watched []WatchedKey) bool {
+	for _, w := range watched {
+		if v, err := s.KeyVersion(ctx, w.Key); err != nil || v != w.Version {
+			return true
+		}
+	}
+	return false
+}
+
+// touch records the index of the entry being applied as the version of the
+// keys written by cmd.
+func (s *StateMachine) touch(ctx context.Context, cmd KVCmd, res any) {
+	keys, all := writtenKeys(cmd, res)
+	index := indexOf(ctx)
+	if all {
+		s.versions.mu.Lock()
+		s.versions.m, s.versions.tombs, s.versions.deleted = map[string]uint64{}, map[string]uint64{}, index
+		s.versions.mu.Unlock()
+		return
+	}
+	for _, k := range keys {
+		ok, err := s.store.Exists(ctx, k)
+
+		s.versions.mu.Lock()
+		if err == nil && ok {
+			s.versions.m[string(k)] = index
+			delete(s.versions.tombs, string(k))
+		} else {
+			delete(s.versions.m, string(k))
+			s.versions.tombs[string(k)] = index
+			s.versions.prune()
+		}
+		s.versions.mu.Unlock()
+	}
+}
+
+// prune forgets the older half of the tombstones once there are more than
+// maxTombstones, raising deleted to the newest of them, so that the keys
+// they were for still count as changed. It is done while applying the log
+// rather than at snapshot time, which differs between replicas, so that
+// every replica decides a transaction on the same versions. The caller holds
+// the lock.
+func (v *versions) prune() {
+	if len(v.tombs) <= maxTombstones {
+		return
+	}
+	indexes := make([]uint64, 0, len(v.tombs))
+	for _, index := range v.tombs {
+		indexes = append(indexes, index)
+	}
+	slices.Sort(indexes)
+	cut := indexes[len(indexes)/2]
+	for k, index := range v.tombs {
+		if index <= cut {
+			delete(v.tombs, k)
+		}
+	}
+	v.deleted = max(v.deleted, cut)
+}
+
+// writtenKeys returns the keys written by cmd, given its result res, or all
+// when it removed every key. The commands nested in a Multi, a Batch or an
+// Eval report their own keys as they are applied.
+func writtenKeys(cmd KVCmd, res any) (keys [][]byte, all bool) {
+	switch r := res.(type) {
+	case error:
+		return nil, false
+	case PutResult:
+		if !r.Applied {
+			return nil, false
+		}
+	case bool:
+		if !r {
+			return nil, false
+		}
+	}
+
+	switch cmd.Op {
+	case Publish, Multi, Batch, Read, Eval, ScriptLoad, ScriptFlush, ACLSetUser, ACLDelUser, SetSlot, SetNode, DelNode, Digest, Checkpoint, SetTenant, DelTenant, Chunk:
+		return nil, false
+	case Flush:
+		return nil, true
+	case FlushDB, SwapDB:
+		keys, _ := res.([][]byte)
+		return keys, false
+	case Del, HDel, SRem, ZRem, SAdd:
+		// Nothing was removed, or added to the set.
+		if res == 0 {
+			return nil, false
+		}
+		return [][]byte{cmd.Key}, false
+	case SetRange:
+		// An empty value changes nothing.
+		if len(cmd.Val) == 0 {
+			return nil, false
+		}
+	case Rename:
+		if res != 1 || bytes.Equal(cmd.Key, cmd.Val) {
+			return nil, false
+		}
+		return [][]byte{cmd.Key, cmd.Val}, false
+	case Copy:
+		if res != 1 {
+			return nil, false
+		}
+		return [][]byte{cmd.Val}, false
+	case Lock:
+		if res == int64(0) {
+			return nil, false
+		}
+	case Unlock:
+		if res != 1 {
+			return nil, false
+		}
+	case MSet:
+		for _, p := range cmd.Pairs {
+			keys = append(keys, p.Key)
+		}
+		return keys, false
+	}
+	return [][]byte{cmd.Key}, false
+}
+
+// versionsSnapshot is the encoded form of versions in a snapshot.
+type versionsSnapshot struct {
+	Versions   map[string]uint64
+	Tombstones map[string]uint64
+	Deleted    uint64
+}
+
+func (v *versions) encode(w io.Writer) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return gob.NewEncoder(w).Encode(versionsSnapshot{Versions: v.m, Tombstones: v.tombs, Deleted: v.deleted})
+}
+
+func (v *versions) reset() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.m, v.tombs, v.deleted = map[string]uint64{}, map[string]uint64{}, 0
+}
+
+func (v *versions) decode(r io.Reader) error {
+	vs := versionsSnapshot{}
+	if err := gob.NewDecoder(r).Decode(&vs); err != nil {
+		return err
+	}
+	if vs.Versions == nil {
+		vs.Versions = map[string]uint64{}
+	}
+	if vs.Tombstones == nil {
+		vs.Tombstones = map[string]uint64{}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.m, v.tombs, v.deleted = vs.Versions, vs.Tombstones, vs.Deleted
+	return nil
+}
@@ -0,0 +1,114 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"raft-redis-cluster/store"
+)
+
+// applyAt applies cmd to s as the log entry at index.
+func applyAt(t *testing.T, s *StateMachine, index uint64, cmd KVCmd) any {
+	t.Helper()
+	return applyAtTime(t, s, index, time.UnixMilli(1_000_000), cmd)
+}
+
+// applyAtTime applies cmd to s as the log entry at index, appended at.
+func applyAtTime(t *testing.T, s *StateMachine, index uint64, at time.Time, cmd KVCmd) any {
+	t.Helper()
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.Apply(&raft.Log{Index: index, Data: data, AppendedAt: at})
+}
+
+func keyVersion(t *testing.T, s *StateMachine, key string) uint64 {
+	t.Helper()
+	v, err := s.KeyVersion(context.Background(), []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestWatchAbsentKeyIgnoresOtherDeletes(t *testing.T) {
+	s := NewStateMachine(store.NewMemoryStore())
+	applyAt(t, s, 1, KVCmd{Op: Put, Key: []byte("other"), Val: []byte("v")})
+	watched := WatchedKey{Key: []byte("absent"), Version: keyVersion(t, s, "absent")}
+
+	applyAt(t, s, 2, KVCmd{Op: Del, Key: []byte("other")})
+	if v := keyVersion(t, s, "absent"); v != watched.Version {
+		t.Fatalf("version of the absent key = %x after deleting another key, want %x", v, watched.Version)
+	}
+
+	res := applyAt(t, s, 3, KVCmd{Op: Multi, Watch: []WatchedKey{watched}, Cmds: []KVCmd{{Op: Put, Key: []byte("absent"), Val: []byte("v")}}})
+	if res == nil {
+		t.Fatal("transaction aborted by the delete of an unwatched key")
+	}
+}
+
+func TestWatchAbsentKeyCreatedAndDeleted(t *testing.T) {
+	s := NewStateMachine(store.NewMemoryStore())
+	watched := WatchedKey{Key: []byte("k"), Version: keyVersion(t, s, "k")}
+
+	applyAt(t, s, 1, KVCmd{Op: Put, Key: []byte("k"), Val: []byte("v")})
+	applyAt(t, s, 2, KVCmd{Op: Del, Key: []byte("k")})
+
+	res := applyAt(t, s, 3, KVCmd{Op: Multi, Watch: []WatchedKey{watched}, Cmds: []KVCmd{{Op: Put, Key: []byte("k"), Val: []byte("v")}}})
+	if res != nil {
+		t.Fatalf("transaction = %v, want it aborted by the key created and deleted again", res)
+	}
+}
+
+func TestPruneTombstonesKeepsChanges(t *testing.T) {
+	v := versions{m: map[string]uint64{}, tombs: map[string]uint64{}}
+	for i := range uint64(maxTombstones + 1) {
+		v.tombs[strconv.FormatUint(i, 10)] = i + 1
+	}
+	v.prune()
+	if len(v.tombs) > maxTombstones/2+1 {
+		t.Fatalf("%d tombstones left, want at most %d", len(v.tombs), maxTombstones/2+1)
+	}
+	// A forgotten key now has the version of the newest forgotten tombstone,
+	// which differs from the one it was watched at.
+	if _, ok := v.tombs["0"]; ok || v.deleted <= 1 {
+		t.Fatalf("oldest tombstone kept or deleted = %d, want it forgotten with deleted above 1", v.deleted)
+	}
+}
+
+func TestWatchIgnoresWritesChangingNothing(t *testing.T) {
+	s := NewStateMachine(store.NewMemoryStore())
+	applyAt(t, s, 1, KVCmd{Op: HSet, Key: []byte("h"), Pairs: []KVPair{{Key: []byte("f"), Val: []byte("v")}}})
+	applyAt(t, s, 2, KVCmd{Op: SAdd, Key: []byte("s"), Args: [][]byte{[]byte("m")}})
+	applyAt(t, s, 3, KVCmd{Op: ZAdd, Key: []byte("z"), Pairs: []KVPair{{Key: []byte("m"), Val: []byte("1")}}})
+	var watched []WatchedKey
+	for _, k := range []string{"h", "s", "z"} {
+		v := keyVersion(t, s, k)
+		if v&missingVersion != 0 {
+			t.Fatalf("%s missing", k)
+		}
+		watched = append(watched, WatchedKey{Key: []byte(k), Version: v})
+	}
+
+	for i, cmd := range []KVCmd{
+		{Op: HDel, Key: []byte("h"), Args: [][]byte{[]byte("missing")}},
+		{Op: SRem, Key: []byte("s"), Args: [][]byte{[]byte("missing")}},
+		{Op: ZRem, Key: []byte("z"), Args: [][]byte{[]byte("missing")}},
+		{Op: SAdd, Key: []byte("s"), Args: [][]byte{[]byte("m")}},
+	} {
+		if res := applyAt(t, s, uint64(4+i), cmd); res != 0 {
+			t.Fatalf("%v = %v, want 0", cmd.Op, res)
+		}
+	}
+	for _, w := range watched {
+		if v := keyVersion(t, s, string(w.Key)); v != w.Version {
+			t.Errorf("version of %s = %d after writes changing nothing, want %d", w.Key, v, w.Version)
+		}
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/boltdb/bolt"
+	hraft "github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+
+	"raft-redis-cluster/encryption"
+	"raft-redis-cluster/kvs"
+	"raft-redis-cluster/raft"
+)
+
+// raftDebugHelp は、raft-debug の使い方
+const raftDebugHelp = `usage: raft-debug <command> --data_dir dir [--shard i] [flags]
+
+Inspects and repairs the Raft data of a shard of a stopped node:
+  info                          log bounds, term, vote, snapshots and latest configuration
+  dump [--from n] [--to n]      log entries, with the commands decoded
+  verify                        check that the entries decode, follow each other and
+                                meet the latest snapshot, and the snapshot checksums
+  truncate --from n [--yes]     delete the entries from n to the end of the log, such as
+                                a corrupted tail found by verify. Entries the cluster
+                                committed are then caught up from the leader, unless
+                                this node was the only one to hold them
+
+The log entries, snapshots and vote of a node encrypting them are decrypted
+with the keys of --encryption_key_file, which verify checks are all there.
+Those left unencrypted are reported as corrupted, unless
+--encryption_allow_plaintext is given.`
+
+// raftDebugCmd は、シャードの Raft のログとスナップショットを調べ、壊れたログの末尾を切り詰める
+// ログのデータベースはノードが開いている間はロックされているので、ノードを止めてから使う
+func raftDebugCmd(args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return errors.New(raftDebugHelp)
+	}
+	verb := args[0]
+	fs := flag.NewFlagSet("raft-debug "+verb, flag.ExitOnError)
+	dataDir := fs.String("data_dir", "", "Raft data dir of the node")
+	shard := fs.Int("shard", 0, "Shard to inspect")
+	keyFile := fs.String("encryption_key_file", "", "Key file the node encrypts its Raft data with, to decrypt its log entries and snapshots")
+	allowPlain := fs.Bool("encryption_allow_plaintext", false, "Read the log entries and snapshots left unencrypted, which are otherwise reported as corrupted")
+	var from, to *uint64
+	var maxValue *int
+	var yes *bool
+	switch verb {
+	case "info", "verify":
+	case "dump":
+		from = fs.Uint64("from", 0, "First index to dump; the first of the log when 0")
+		to = fs.Uint64("to", 0, "Last index to dump; the last of the log when 0")
+		maxValue = fs.Int("max_value", 64, "Bytes of each key and value shown before they are cut; 0 shows them whole")
+	case "truncate":
+		from = fs.Uint64("from", 0, "First index to delete")
+		yes = fs.Bool("yes", false, "Delete the entries; without it, only show what would be deleted")
+	default:
+		return errors.New(raftDebugHelp)
+	}
+	fs.Parse(args[1:])
+	if *dataDir == "" {
+		return errors.New("flag --data_dir is required")
+	}
+	dir := kvs.ShardDir(*dataDir, *shard)
+
+	logs, err := openBoltStore(dir, "logs.dat", verb != "truncate")
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+	// 暗号化されたエントリは、鍵があれば復号して読む
+	var entries hraft.LogStore = logs
+	var keys *encryption.Keyring
+	if *keyFile != "" {
+		if keys, err = encryption.Load(*keyFile); err != nil {
+			return err
+		}
+		keys.AllowPlaintext(*allowPlain)
+		entries = raft.NewEncryptedLogStore(logs, keys)
+	}
+
+	switch verb {
+	case "info":
+		return raftDebugInfo(os.Stdout, dir, entries, keys)
+	case "dump":
+		return raftDebugDump(os.Stdout, entries, *from, *to, *maxValue)
+	case "verify":
+		return raftDebugVerify(os.Stdout, dir, entries, keys)
+	default:
+		if *from == 0 {
+			return errors.New("flag --from is required")
+		}
+		return raftDebugTruncate(os.Stdout, logs, *from, *yes)
+	}
+}
+
+// openBoltStore は、dir の Raft のデータベースを開く
+// ノードが開いている間は待たずにエラーにする
+func openBoltStore(dir, name string, readOnly bool) (*raftboltdb.BoltStore, error) {
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	s, err := raftboltdb.New(raftboltdb.Options{
+		Path:        path,
+		BoltOptions: &bolt.Options{Timeout: time.Second, ReadOnly: readOnly},
+	})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("%s is in use, stop the node first", path)
+	}
+	return s, err
+}
+
+// raftDebugInfo は、ログの範囲、投票の状態、スナップショットと最新の構成を表示する
+// keys があれば、暗号化された投票先を復号する
+func raftDebugInfo(out io.Writer, dir string, logs hraft.LogStore, keys *encryption.Keyring) error {
+	first, last, err := logBounds(logs)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "log: first %d, last %d\n", first, last)
+
+	sdb, err := openBoltStore(dir, "stable.dat", true)
+	if err != nil {
+		return err
+	}
+	defer sdb.Close()
+	var stable hraft.StableStore = sdb
+	if keys != nil {
+		stable = raft.NewEncryptedStableStore(sdb, keys)
+	}
+	// 未設定の項目はゼロ値のまま表示する
+	term, _ := stable.GetUint64([]byte("CurrentTerm"))
+	voteTerm, _ := stable.GetUint64([]byte("LastVoteTerm"))
+	voteFor, _ := stable.Get([]byte("LastVoteCand"))
+	fmt.Fprintf(out, "current term %d, last vote in term %d for %q\n", term, voteTerm, voteFor)
+
+	metas, err := listSnapshots(dir)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out)
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SNAPSHOT\tINDEX\tTERM\tSIZE")
+	for _, m := range metas {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", m.ID, m.Index, m.Term, m.Size)
+	}
+	w.Flush()
+
+	// 最新の構成は、ログの最後の構成の変更か、なければ最新のスナップショットのもの
+	var cfg *hraft.Configuration
+	var cfgIndex uint64
+	for i := last; i >= first && i > 0 && cfg == nil; i-- {
+		var l hraft.Log
+		if err := logs.GetLog(i, &l); err != nil || l.Type != hraft.LogConfiguration {
+			continue
+		}
+		if c, err := decodeConfiguration(l.Data); err == nil {
+			cfg, cfgIndex = &c, i
+		}
+	}
+	if cfg == nil && len(metas) > 0 {
+		cfg, cfgIndex = &metas[0].Configuration, metas[0].ConfigurationIndex
+	}
+	if cfg == nil {
+		return nil
+	}
+	fmt.Fprintf(out, "\nconfiguration at index %d:\n", cfgIndex)
+	w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tADDRESS\tSUFFRAGE")
+	for _, s := range cfg.Servers {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.ID, s.Address, s.Suffrage)
+	}
+	return w.Flush()
+}
+
+// raftDebugDump は、from から to までのログのエントリを、コマンドを復号して表示する
+func raftDebugDump(out io.Writer, logs hraft.LogStore, from, to uint64, maxValue int) error {
+	first, last, err := logBounds(logs)
+	if err != nil {
+		return err
+	}
+	if from == 0 {
+		from = first
+	}
+	if to == 0 || to > last {
+		to = last
+	}
+	for i := max(from, first); i <= to && i > 0; i++ {
+		var l hraft.Log
+		if err := logs.GetLog(i, &l); err != nil {
+			fmt.Fprintf(out, "%d\terror: %v\n", i, err)
+			continue
+		}
+		fmt.Fprintf(out, "%d\tterm %d\t%s\t%s\t%s\n", l.Index, l.Term, l.Type,
+			l.AppendedAt.UTC().Format(time.RFC3339Nano), describeLog(&l, maxValue))
+	}
+	return nil
+}
+
+// describeLog は、エントリの中身を 1 行で表す
+func describeLog(l *hraft.Log, maxValue int) string {
+	if id, ok := encryption.KeyOf(l.Data); ok {
+		return fmt.Sprintf("encrypted with key %q", id)
+	}
+	switch l.Type {
+	case hraft.LogCommand:
+		var c raft.KVCmd
+		if err := json.Unmarshal(l.Data, &c); err != nil {
+			return "undecodable command: " + err.Error()
+		}
+		return formatKVCmd(c, maxValue)
+	case hraft.LogConfiguration:
+		c, err := decodeConfiguration(l.Data)
+		if err != nil {
+			return "undecodable configuration: " + err.Error()
+		}
+		servers := make([]string, len(c.Servers))
+		for i, s := range c.Servers {
+			servers[i] = fmt.Sprintf("%s@%s(%s)", s.ID, s.Address, s.Suffrage)
+		}
+		return strings.Join(servers, " ")
+	}
+	return ""
+}
+
+// formatKVCmd は、コマンドを操作と引数の並びで表す
+// キーと値は maxValue バイトで切る
+func formatKVCmd(c raft.KVCmd, maxValue int) string {
+	b := &strings.Builder{}
+	b.WriteString(c.Op.String())
+	quote := func(v []byte) string {
+		if maxValue > 0 && len(v) > maxValue {
+			return fmt.Sprintf("%q...(%d bytes)", v[:maxValue], len(v))
+		}
+		return strconv.Quote(string(v))
+	}
+	if c.Key != nil {
+		b.WriteString(" key=" + quote(c.Key))
+	}
+	if c.Field != nil {
+		b.WriteString(" field=" + quote(c.Field))
+	}
+	if c.Val != nil {
+		b.WriteString(" val=" + quote(c.Val))
+	}
+	for _, p := range c.Pairs {
+		b.WriteString(" " + quote(p.Key) + "=" + quote(p.Val))
+	}
+	for _, a := range c.Args {
+		b.WriteString(" " + quote(a))
+	}
+	if c.ExpireAt != 0 {
+		b.WriteString(" expire_at=" + time.UnixMilli(c.ExpireAt).UTC().Format(time.RFC3339Nano))
+	}
+	switch c.Cond {
+	case raft.CondNX:
+		b.WriteString(" NX")
+	case raft.CondXX:
+		b.WriteString(" XX")
+	}
+	if c.RequestID != "" {
+		b.WriteString(" request_id=" + c.RequestID)
+	}
+	if len(c.Cmds) > 0 {
+		cmds := make([]string, len(c.Cmds))
+		for i, sub := range c.Cmds {
+			cmds[i] = formatKVCmd(sub, maxValue)
+		}
+		b.WriteString(" [" + strings.Join(cmds, "; ") + "]")
+	}
+	return b.String()
+}
+
+// raftDebugVerify は、ログのエントリが読めて復号でき、インデックスが連続してタームが減らず、
+// 最新のスナップショットと隙間なくつながっていること、スナップショットのチェックサムが合うことを確かめる
+// keys があれば、暗号化されたエントリとスナップショットの鍵がそろっていることも確かめる
+// 問題があれば表示して、最初に壊れたエントリから切り詰める方法を示す
+func raftDebugVerify(out io.Writer, dir string, logs hraft.LogStore, keys *encryption.Keyring) error {
+	problems := 0
+	report := func(format string, args ...any) {
+		problems++
+		fmt.Fprintf(out, format+"\n", args...)
+	}
+
+	first, last, err := logBounds(logs)
+	if err != nil {
+		return err
+	}
+	var bad, prevTerm uint64
+	for i := first; i <= last && i > 0; i++ {
+		var l hraft.Log
+		err := logs.GetLog(i, &l)
+		switch {
+		case err != nil:
+			report("index %d: %v", i, err)
+		case l.Index != i:
+			report("index %d: entry holds index %d", i, l.Index)
+		case l.Term < prevTerm:
+			report("index %d: term %d after term %d", i, l.Term, prevTerm)
+		case encryption.Encrypted(l.Data):
+			report("index %d: encrypted, give the key file with --encryption_key_file", i)
+		case l.Type == hraft.LogCommand && json.Unmarshal(l.Data, &raft.KVCmd{}) != nil:
+			report("index %d: undecodable command", i)
+		case l.Type == hraft.LogConfiguration:
+			if _, err := decodeConfiguration(l.Data); err != nil {
+				report("index %d: undecodable configuration: %v", i, err)
+			}
+		}
+		if problems > 0 && bad == 0 {
+			bad = i
+		}
+		if err == nil {
+			prevTerm = max(prevTerm, l.Term)
+		}
+	}
+
+	metas, err := listSnapshots(dir)
+	if err != nil {
+		return err
+	}
+	var fss hraft.SnapshotStore
+	if fss, err = hraft.NewFileSnapshotStore(dir, kvs.SnapshotRetainCount, io.Discard); err != nil {
+		return err
+	}
+	if keys != nil {
+		fss = raft.NewEncryptedSnapshotStore(fss, keys)
+	}
+	for _, m := range metas {
+		// Open は、スナップショットの CRC を計算して確かめる
+		// 暗号化されたスナップショットは、その鍵があることも確かめる
+		_, rc, err := fss.Open(m.ID)
+		if err != nil {
+			report("snapshot %s: %v", m.ID, err)
+			continue
+		}
+		rc.Close()
+	}
+	// 最新のスナップショットより後のエントリが欠けていると、ノードは追いつけない
+	snapIndex := uint64(0)
+	if len(metas) > 0 {
+		snapIndex = metas[0].Index
+	}
+	if first > snapIndex+1 {
+		report("log starts at index %d, after the latest snapshot at index %d", first, snapIndex)
+	}
+
+	fmt.Fprintf(out, "checked entries %d to %d and %d snapshots\n", first, last, len(metas))
+	if problems == 0 {
+		fmt.Fprintln(out, "no problem found")
+		return nil
+	}
+	if bad > 0 {
+		fmt.Fprintf(out, "the log is broken from index %d; delete its tail with raft-debug truncate --from %d\n", bad, bad)
+	}
+	return fmt.Errorf("%d problems found", problems)
+}
+
+// raftDebugTruncate は、from からログの最後までのエントリを削除する
+// yes でなければ、削除する範囲を表示するだけにする
+func raftDebugTruncate(out io.Writer, logs hraft.LogStore, from uint64, yes bool) error {
+	_, last, err := logBounds(logs)
+	if err != nil {
+		return err
+	}
+	if from > last {
+		fmt.Fprintf(out, "the log ends at index %d, nothing to delete\n", last)
+		return nil
+	}
+	if !yes {
+		fmt.Fprintf(out, "would delete entries %d to %d (%d entries); run again with --yes to delete them\n", from, last, last-from+1)
+		return nil
+	}
+	if err := logs.DeleteRange(from, last); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "deleted entries %d to %d\n", from, last)
+	return nil
+}
+
+func logBounds(logs hraft.LogStore) (first, last uint64, err error) {
+	if first, err = logs.FirstIndex(); err != nil {
+		return 0, 0, err
+	}
+	last, err = logs.LastIndex()
+	return first, last, err
+}
+
+// listSnapshots は、dir のスナップショットを新しい順に返す
+func listSnapshots(dir string) ([]*hraft.SnapshotMeta, error) {
+	fss, err := hraft.NewFileSnapshotStore(dir, kvs.SnapshotRetainCount, io.Discard)
+	if err != nil {
+		return nil, err
+	}
+	return fss.List()
+}
+
+// decodeConfiguration は、構成のエントリを復号する
+// hraft.DecodeConfiguration は壊れたデータで panic するので、エラーにする
+func decodeConfiguration(data []byte) (c hraft.Configuration, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return hraft.DecodeConfiguration(data), nil
+}
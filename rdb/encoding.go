@@ -0,0 +1,257 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"hash/crc64"
+	"math"
+	"strconv"
+)
+
+// jones is the table of CRC-64/Jones, the checksum of RDB.
+var jones = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+// checksum returns the CRC64 of p as Redis computes it, without the
+// inversions of hash/crc64.
+func checksum(p []byte) uint64 {
+	return ^crc64.Update(^uint64(0), jones, p)
+}
+
+// lzfDecompress decompresses LZF compressed strings of n bytes.
+func lzfDecompress(in []byte, n uint64) ([]byte, error) {
+	if n > math.MaxInt32 {
+		return nil, ErrBadData
+	}
+	out := make([]byte, 0, n)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			// A run of ctrl+1 literal bytes.
+			if i+ctrl+1 > len(in) {
+				return nil, ErrBadData
+			}
+			out = append(out, in[i:i+ctrl+1]...)
+			i += ctrl + 1
+			continue
+		}
+
+		// A back reference of length+2 bytes.
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, ErrBadData
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, ErrBadData
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, ErrBadData
+		}
+		for j := range length + 2 {
+			out = append(out, out[ref+j])
+		}
+	}
+	if uint64(len(out)) != n {
+		return nil, ErrBadData
+	}
+	return out, nil
+}
+
+// intset returns the members of an intset as decimal strings.
+func intset(p []byte) ([][]byte, error) {
+	if len(p) < 8 {
+		return nil, ErrBadData
+	}
+	size := int(binary.LittleEndian.Uint32(p))
+	n := int(binary.LittleEndian.Uint32(p[4:]))
+	p = p[8:]
+	if size != 2 && size != 4 && size != 8 || n*size != len(p) {
+		return nil, ErrBadData
+	}
+	members := make([][]byte, n)
+	for i := range members {
+		var v int64
+		switch size {
+		case 2:
+			v = int64(int16(binary.LittleEndian.Uint16(p[i*2:])))
+		case 4:
+			v = int64(int32(binary.LittleEndian.Uint32(p[i*4:])))
+		default:
+			v = int64(binary.LittleEndian.Uint64(p[i*8:]))
+		}
+		members[i] = strconv.AppendInt(nil, v, 10)
+	}
+	return members, nil
+}
+
+// ziplist returns the entries of a ziplist, with integers as decimal
+// strings.
+func ziplist(p []byte) ([][]byte, error) {
+	if len(p) < 11 || int(binary.LittleEndian.Uint32(p)) != len(p) {
+		return nil, ErrBadData
+	}
+	r := &reader{b: p[10:]}
+	var elems [][]byte
+	for {
+		prev, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if prev == 0xff {
+			break
+		}
+		if prev == 0xfe {
+			if _, err := r.next(4); err != nil {
+				return nil, err
+			}
+		}
+
+		enc, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		var e []byte
+		switch {
+		case enc>>6 == 0:
+			e, err = r.next(int(enc & 0x3f))
+		case enc>>6 == 1:
+			var c byte
+			if c, err = r.byte(); err == nil {
+				e, err = r.next(int(enc&0x3f)<<8 | int(c))
+			}
+		case enc == 0x80:
+			var l []byte
+			if l, err = r.next(4); err == nil {
+				e, err = r.next(int(binary.BigEndian.Uint32(l)))
+			}
+		case enc == 0xc0:
+			e, err = r.int(2)
+		case enc == 0xd0:
+			e, err = r.int(4)
+		case enc == 0xe0:
+			e, err = r.int(8)
+		case enc == 0xf0:
+			e, err = r.int(3)
+		case enc == 0xfe:
+			e, err = r.int(1)
+		case enc >= 0xf1 && enc <= 0xfd:
+			e = strconv.AppendInt(nil, int64(enc&0x0f)-1, 10)
+		default:
+			err = ErrBadData
+		}
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, append([]byte(nil), e...))
+	}
+	if len(r.b) != 0 {
+		return nil, ErrBadData
+	}
+	return elems, nil
+}
+
+// listpack returns the entries of a listpack, with integers as decimal
+// strings.
+func listpack(p []byte) ([][]byte, error) {
+	if len(p) < 7 || int(binary.LittleEndian.Uint32(p)) != len(p) {
+		return nil, ErrBadData
+	}
+	r := &reader{b: p[6:]}
+	var elems [][]byte
+	for {
+		start := len(r.b)
+		enc, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if enc == 0xff {
+			break
+		}
+
+		var e []byte
+		switch {
+		case enc>>7 == 0:
+			e = strconv.AppendInt(nil, int64(enc), 10)
+		case enc>>6 == 2:
+			e, err = r.next(int(enc & 0x3f))
+		case enc>>5 == 6:
+			var c byte
+			if c, err = r.byte(); err == nil {
+				v := int64(enc&0x1f)<<8 | int64(c)
+				if v >= 1<<12 {
+					v -= 1 << 13
+				}
+				e = strconv.AppendInt(nil, v, 10)
+			}
+		case enc>>4 == 0xe:
+			var c byte
+			if c, err = r.byte(); err == nil {
+				e, err = r.next(int(enc&0x0f)<<8 | int(c))
+			}
+		case enc == 0xf0:
+			var l []byte
+			if l, err = r.next(4); err == nil {
+				e, err = r.next(int(binary.LittleEndian.Uint32(l)))
+			}
+		case enc == 0xf1:
+			e, err = r.int(2)
+		case enc == 0xf2:
+			e, err = r.int(3)
+		case enc == 0xf3:
+			e, err = r.int(4)
+		case enc == 0xf4:
+			e, err = r.int(8)
+		default:
+			err = ErrBadData
+		}
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, append([]byte(nil), e...))
+
+		// Skip the backlen, the length of the entry so far.
+		if _, err := r.next(backlenSize(start - len(r.b))); err != nil {
+			return nil, err
+		}
+	}
+	if len(r.b) != 0 {
+		return nil, ErrBadData
+	}
+	return elems, nil
+}
+
+// backlenSize returns the size of the backlen of a listpack entry of n
+// bytes.
+func backlenSize(n int) int {
+	switch {
+	case n <= 127:
+		return 1
+	case n < 16383:
+		return 2
+	case n < 2097151:
+		return 3
+	case n < 268435455:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// int reads a little endian signed integer of n bytes as a decimal string.
+func (r *reader) int(n int) ([]byte, error) {
+	p, err := r.next(n)
+	if err != nil {
+		return nil, err
+	}
+	var u uint64
+	for i := n - 1; i >= 0; i-- {
+		u = u<<8 | uint64(p[i])
+	}
+	shift := 64 - 8*n
+	return strconv.AppendInt(nil, int64(u<<shift)>>shift, 10), nil
+}
@@ -0,0 +1,393 @@
+// Package rdb encodes and decodes the serialized values of DUMP and RESTORE
+// in the format of Redis: an RDB object followed by the RDB version and a
+// CRC64 checksum. Values dumped here restore into Redis 5 and later, and
+// the strings, hashes, sets and sorted sets dumped by Redis, in any of their
+// encodings up to RDB version 12, restore here.
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+
+	"raft-redis-cluster/store"
+)
+
+// version is the RDB version written in dumped values. Version 9 only has
+// the plain encodings used by Encode and is understood by Redis 5 and later.
+const version = 9
+
+// maxVersion is the newest RDB version accepted by Decode.
+const maxVersion = 12
+
+// RDB object types.
+const (
+	typeString       = 0
+	typeSet          = 2
+	typeZSet         = 3
+	typeHash         = 4
+	typeZSet2        = 5
+	typeSetIntset    = 11
+	typeZSetZiplist  = 12
+	typeHashZiplist  = 13
+	typeHashListpack = 16
+	typeZSetListpack = 17
+	typeSetListpack  = 20
+)
+
+var (
+	ErrPayload     = errors.New("ERR DUMP payload version or checksum are wrong")
+	ErrBadData     = errors.New("ERR Bad data format")
+	ErrUnsupported = errors.New("ERR the value type is not supported by DUMP and RESTORE")
+)
+
+// Encode serializes v as DUMP does, with the fields of hashes sorted so that
+// the same value always has the same serialization. The expiration of v is
+// not included.
+func Encode(v store.Value) ([]byte, error) {
+	var b []byte
+	switch v.Kind {
+	case store.KindString:
+		b = append(b, typeString)
+		b = appendString(b, v.String)
+	case store.KindSet:
+		b = append(b, typeSet)
+		b = appendLen(b, uint64(len(v.Set)))
+		for _, m := range v.Set {
+			b = appendString(b, m)
+		}
+	case store.KindZSet:
+		b = append(b, typeZSet2)
+		b = appendLen(b, uint64(len(v.ZSet)))
+		for _, m := range v.ZSet {
+			b = appendString(b, m.Member)
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(m.Score))
+		}
+	case store.KindHash:
+		b = append(b, typeHash)
+		b = appendLen(b, uint64(len(v.Hash)))
+		for _, f := range slices.Sorted(maps.Keys(v.Hash)) {
+			b = appendString(b, []byte(f))
+			b = appendString(b, v.Hash[f])
+		}
+	default:
+		return nil, ErrUnsupported
+	}
+	b = binary.LittleEndian.AppendUint16(b, version)
+	return binary.LittleEndian.AppendUint64(b, checksum(b)), nil
+}
+
+// Decode parses a value serialized by DUMP, after checking its version and
+// checksum.
+func Decode(payload []byte) (store.Value, error) {
+	if len(payload) < 10 {
+		return store.Value{}, ErrPayload
+	}
+	body, footer := payload[:len(payload)-10], payload[len(payload)-10:]
+	if binary.LittleEndian.Uint16(footer) > maxVersion ||
+		binary.LittleEndian.Uint64(footer[2:]) != checksum(payload[:len(payload)-8]) {
+		return store.Value{}, ErrPayload
+	}
+
+	r := &reader{b: body}
+	v, err := r.object()
+	if err != nil {
+		return store.Value{}, err
+	}
+	if len(r.b) != 0 {
+		return store.Value{}, ErrBadData
+	}
+	return v, nil
+}
+
+func appendLen(b []byte, n uint64) []byte {
+	switch {
+	case n < 1<<6:
+		return append(b, byte(n))
+	case n < 1<<14:
+		return append(b, byte(n>>8)|0x40, byte(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0x80), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, 0x81), n)
+	}
+}
+
+func appendString(b, s []byte) []byte {
+	return append(appendLen(b, uint64(len(s))), s...)
+}
+
+// reader reads an RDB object from b.
+type reader struct {
+	b []byte
+}
+
+func (r *reader) next(n int) ([]byte, error) {
+	if n < 0 || n > len(r.b) {
+		return nil, ErrBadData
+	}
+	p := r.b[:n]
+	r.b = r.b[n:]
+	return p, nil
+}
+
+func (r *reader) byte() (byte, error) {
+	p, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return p[0], nil
+}
+
+// length reads a length, or the encoding of a special string when encoded
+// is true.
+func (r *reader) length() (n uint64, encoded bool, err error) {
+	c, err := r.byte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch c >> 6 {
+	case 0:
+		return uint64(c & 0x3f), false, nil
+	case 1:
+		c2, err := r.byte()
+		return uint64(c&0x3f)<<8 | uint64(c2), false, err
+	case 2:
+		switch c {
+		case 0x80:
+			p, err := r.next(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(p)), false, nil
+		case 0x81:
+			p, err := r.next(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(p), false, nil
+		}
+		return 0, false, ErrBadData
+	default:
+		return uint64(c & 0x3f), true, nil
+	}
+}
+
+func (r *reader) count() (int, error) {
+	n, encoded, err := r.length()
+	if err != nil {
+		return 0, err
+	}
+	if encoded || n > uint64(len(r.b)) {
+		return 0, ErrBadData
+	}
+	return int(n), nil
+}
+
+// RDB special string encodings.
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+func (r *reader) string() ([]byte, error) {
+	n, encoded, err := r.length()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		if n > uint64(len(r.b)) {
+			return nil, ErrBadData
+		}
+		p, err := r.next(int(n))
+		return append([]byte(nil), p...), err
+	}
+
+	switch n {
+	case encInt8:
+		p, err := r.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, int64(int8(p[0])), 10), nil
+	case encInt16:
+		p, err := r.next(2)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, int64(int16(binary.LittleEndian.Uint16(p))), 10), nil
+	case encInt32:
+		p, err := r.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, int64(int32(binary.LittleEndian.Uint32(p))), 10), nil
+	case encLZF:
+		clen, err := r.count()
+		if err != nil {
+			return nil, err
+		}
+		ulen, _, err := r.length()
+		if err != nil {
+			return nil, err
+		}
+		p, err := r.next(clen)
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(p, ulen)
+	}
+	return nil, ErrBadData
+}
+
+// float reads the score of a sorted set of RDB_TYPE_ZSET, written as a
+// string.
+func (r *reader) float() (float64, error) {
+	n, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	p, err := r.next(int(n))
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(string(p), 64)
+	if err != nil {
+		return 0, ErrBadData
+	}
+	return f, nil
+}
+
+func (r *reader) object() (store.Value, error) {
+	t, err := r.byte()
+	if err != nil {
+		return store.Value{}, err
+	}
+
+	switch t {
+	case typeString:
+		s, err := r.string()
+		return store.Value{Kind: store.KindString, String: s}, err
+
+	case typeSet:
+		n, err := r.count()
+		if err != nil {
+			return store.Value{}, err
+		}
+		v := store.Value{Kind: store.KindSet, Set: make([][]byte, n)}
+		for i := range v.Set {
+			if v.Set[i], err = r.string(); err != nil {
+				return store.Value{}, err
+			}
+		}
+		return v, nil
+
+	case typeZSet, typeZSet2:
+		n, err := r.count()
+		if err != nil {
+			return store.Value{}, err
+		}
+		v := store.Value{Kind: store.KindZSet, ZSet: make([]store.ZMember, n)}
+		for i := range v.ZSet {
+			m := &v.ZSet[i]
+			if m.Member, err = r.string(); err != nil {
+				return store.Value{}, err
+			}
+			if t == typeZSet {
+				m.Score, err = r.float()
+			} else {
+				var p []byte
+				p, err = r.next(8)
+				if err == nil {
+					m.Score = math.Float64frombits(binary.LittleEndian.Uint64(p))
+				}
+			}
+			if err != nil {
+				return store.Value{}, err
+			}
+		}
+		return v, nil
+
+	case typeHash:
+		n, err := r.count()
+		if err != nil {
+			return store.Value{}, err
+		}
+		v := store.Value{Kind: store.KindHash, Hash: make(map[string][]byte, n)}
+		for range n {
+			f, err := r.string()
+			if err != nil {
+				return store.Value{}, err
+			}
+			if v.Hash[string(f)], err = r.string(); err != nil {
+				return store.Value{}, err
+			}
+		}
+		return v, nil
+
+	case typeSetIntset:
+		p, err := r.string()
+		if err != nil {
+			return store.Value{}, err
+		}
+		members, err := intset(p)
+		return store.Value{Kind: store.KindSet, Set: members}, err
+
+	case typeSetListpack, typeHashListpack, typeZSetListpack, typeHashZiplist, typeZSetZiplist:
+		p, err := r.string()
+		if err != nil {
+			return store.Value{}, err
+		}
+		var elems [][]byte
+		if t == typeHashZiplist || t == typeZSetZiplist {
+			elems, err = ziplist(p)
+		} else {
+			elems, err = listpack(p)
+		}
+		if err != nil {
+			return store.Value{}, err
+		}
+		return fromElements(t, elems)
+	}
+	return store.Value{}, ErrUnsupported
+}
+
+// fromElements builds a value from the flat elements of a listpack or a
+// ziplist: the members of a set, or the pairs of a hash or a sorted set.
+func fromElements(t byte, elems [][]byte) (store.Value, error) {
+	if t == typeSetListpack {
+		return store.Value{Kind: store.KindSet, Set: elems}, nil
+	}
+	if len(elems)%2 != 0 {
+		return store.Value{}, ErrBadData
+	}
+	if t == typeHashListpack || t == typeHashZiplist {
+		v := store.Value{Kind: store.KindHash, Hash: make(map[string][]byte, len(elems)/2)}
+		for i := 0; i < len(elems); i += 2 {
+			v.Hash[string(elems[i])] = elems[i+1]
+		}
+		return v, nil
+	}
+	v := store.Value{Kind: store.KindZSet, ZSet: make([]store.ZMember, 0, len(elems)/2)}
+	for i := 0; i < len(elems); i += 2 {
+		score, err := strconv.ParseFloat(string(elems[i+1]), 64)
+		if err != nil {
+			return store.Value{}, ErrBadData
+		}
+		v.ZSet = append(v.ZSet, store.ZMember{Member: elems[i], Score: score})
+	}
+	return v, nil
+}
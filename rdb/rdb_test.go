@@ -0,0 +1,156 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"raft-redis-cluster/store"
+)
+
+// payload returns the bytes of hex, written with spaces between the parts
+// of the payload.
+func payload(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// Payloads of DUMP in Redis. The string is the example of the DUMP page of
+// the Redis documentation, dumped by Redis 7.0 (RDB 10), 5 (RDB 9) and 3
+// (RDB 6), which also checks the checksum. The others are laid out as
+// Redis 7.2 (RDB 11) dumps small values, in listpacks and intsets; the
+// stream as with rdbcompression no, which leaves its listpack uncompressed.
+var (
+	// SET k 10
+	dumpInt = map[string]string{
+		"RDB 10": "00 c00a 0a00 6e9f57450eae63bb",
+		"RDB 9":  "00 c00a 0900 be6d06895a28000a",
+		"RDB 6":  "00 c00a 0600 f8723fc5fbfb5f28",
+	}
+	// HSET k f v
+	dumpHash = "10 0d 0d000000 0200 816602 817602 ff 0b00 492e8037decbe114"
+	// SADD k a b
+	dumpSet = "14 0d 0d000000 0200 816102 816202 ff 0b00 0aec0ab449a3d654"
+	// SADD k 1 2 3
+	dumpIntset = "0b 0e 02000000 03000000 0100 0200 0300 0b00 ccdde19190f1a492"
+	// ZADD k 1 a 2.5 b
+	dumpZSet = "11 14 14000000 0400 816102 0101 816202 83322e3504 ff 0b00 bd93d3d70bdb305e"
+	// RPUSH k a b
+	dumpList = "12 01 02 0d 0d000000 0200 816102 816202 ff 0b00 0134b7faeede5238"
+	// XADD k 1-1 f v
+	dumpStream = "15 01 10 0000000000000001 0000000000000001 1d 1d000000 0a00 0101 0001 0101 816602 0001 0201 0001 0001 817602 0401 ff" +
+		" 01 01 01 01 01 00 00 01 00 0b00 e55aacd2a8048c73"
+)
+
+func TestDecodeRedisPayloads(t *testing.T) {
+	for name, p := range dumpInt {
+		v, err := Decode(payload(t, p))
+		if err != nil || v.Kind != store.KindString || string(v.String) != "10" {
+			t.Errorf("%s: Decode = %v %q, %v, want the string 10", name, v.Kind, v.String, err)
+		}
+	}
+
+	v, err := Decode(payload(t, dumpHash))
+	if err != nil || v.Kind != store.KindHash || len(v.Hash) != 1 || string(v.Hash["f"]) != "v" {
+		t.Errorf("hash: Decode = %v %q, %v, want f v", v.Kind, v.Hash, err)
+	}
+
+	for name, c := range map[string]struct {
+		p    string
+		want []string
+	}{
+		"set":    {dumpSet, []string{"a", "b"}},
+		"intset": {dumpIntset, []string{"1", "2", "3"}},
+	} {
+		v, err := Decode(payload(t, c.p))
+		var got []string
+		for _, m := range v.Set {
+			got = append(got, string(m))
+		}
+		slices.Sort(got)
+		if err != nil || v.Kind != store.KindSet || !slices.Equal(got, c.want) {
+			t.Errorf("%s: Decode = %v %q, %v, want %q", name, v.Kind, got, err, c.want)
+		}
+	}
+
+	v, err = Decode(payload(t, dumpZSet))
+	want := []store.ZMember{{Member: []byte("a"), Score: 1}, {Member: []byte("b"), Score: 2.5}}
+	if err != nil || v.Kind != store.KindZSet || !slices.EqualFunc(v.ZSet, want, func(a, b store.ZMember) bool {
+		return bytes.Equal(a.Member, b.Member) && a.Score == b.Score
+	}) {
+		t.Errorf("zset: Decode = %v %v, %v, want %v", v.Kind, v.ZSet, err, want)
+	}
+
+	// The store has no lists, and streams are not dumped.
+	for name, p := range map[string]string{"list": dumpList, "stream": dumpStream} {
+		if _, err := Decode(payload(t, p)); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("%s: Decode = %v, want ErrUnsupported", name, err)
+		}
+	}
+}
+
+func TestEncodeGolden(t *testing.T) {
+	for _, c := range []struct {
+		v    store.Value
+		want string
+	}{
+		// As dumped by Redis 5.
+		{store.Value{Kind: store.KindString, String: []byte("hello")}, "00 05 68656c6c6f 0900 b3808eba31b243bb"},
+		{store.Value{Kind: store.KindSet, Set: [][]byte{[]byte("a")}}, "02 01 0161 0900 b264b8b2105479dc"},
+		{
+			store.Value{Kind: store.KindZSet, ZSet: []store.ZMember{{Member: []byte("a"), Score: 1}, {Member: []byte("b"), Score: 2.5}}},
+			"05 02 0161 000000000000f03f 0162 0000000000000440 0900 32c944df3f6b2a5c",
+		},
+		// The fields are sorted.
+		{
+			store.Value{Kind: store.KindHash, Hash: map[string][]byte{"b": []byte("y"), "a": []byte("x")}},
+			"04 02 0161 0178 0162 0179 0900 af5364d07e8026ec",
+		},
+	} {
+		got, err := Encode(c.v)
+		if want := payload(t, c.want); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("Encode(%v) = %x, %v, want %x", c.v.Kind, got, err, want)
+			continue
+		}
+		v, err := Decode(got)
+		if err != nil || v.Kind != c.v.Kind {
+			t.Errorf("Decode(Encode(%v)) = %v, %v", c.v.Kind, v.Kind, err)
+		}
+	}
+
+	if _, err := Encode(store.Value{Kind: store.KindStream}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Encode of a stream = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestDecodeRejectsBadPayloads(t *testing.T) {
+	good := payload(t, dumpInt["RDB 10"])
+
+	badCRC := bytes.Clone(good)
+	badCRC[len(badCRC)-1] ^= 1
+	badBody := bytes.Clone(good)
+	badBody[1] ^= 1
+	// RDB 13 is newer than the versions understood, whatever the checksum.
+	newer := payload(t, "00 c00a 0d00")
+	newer = binary.LittleEndian.AppendUint64(newer, checksum(newer))
+
+	for name, p := range map[string][]byte{
+		"bad checksum":  badCRC,
+		"changed value": badBody,
+		"newer version": newer,
+		"too short":     good[:9],
+		"empty":         nil,
+	} {
+		if _, err := Decode(p); !errors.Is(err, ErrPayload) {
+			t.Errorf("%s: Decode = %v, want ErrPayload", name, err)
+		}
+	}
+}
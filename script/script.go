@@ -0,0 +1,371 @@
+// Package script runs the Lua scripts of EVAL and EVALSHA.
+//
+// Scripts are executed by the state machine on every replica, so everything
+// a script can observe must be the same on all of them. Only the base,
+// table, string and math libraries are loaded, file access from the base
+// library is removed, and math.random starts from a fixed seed on every run.
+// Commands issued with redis.call are handed to a Call function, which
+// executes them against the replica's own store. A script may run at most
+// MaxInstructions Lua instructions, so that a script that never ends fails
+// at the same instruction on every replica instead of hanging them.
+package script
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tidwall/redcon"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// MaxInstructions is how many Lua instructions a script may run.
+const MaxInstructions = 100_000_000
+
+// Call executes a command issued by a script and returns its reply encoded
+// in RESP.
+type Call func(args [][]byte) []byte
+
+// Engine runs scripts and caches them by their SHA1 digest for EVALSHA.
+type Engine struct {
+	mu      sync.RWMutex
+	scripts map[string]string
+}
+
+// New returns an Engine with an empty script cache.
+func New() *Engine {
+	return &Engine{scripts: map[string]string{}}
+}
+
+// SHA1Hex returns the digest that identifies body in the script cache.
+func SHA1Hex(body string) string {
+	sum := sha1.Sum([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// Load compiles body and adds it to the cache. It returns the digest of the
+// script.
+func (e *Engine) Load(body string) (string, error) {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	defer L.Close()
+	if _, err := L.LoadString(body); err != nil {
+		return "", err
+	}
+	return e.add(body), nil
+}
+
+func (e *Engine) add(body string) string {
+	sha := SHA1Hex(body)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.scripts[sha] = body
+	return sha
+}
+
+// Lookup returns the cached script with the given digest.
+func (e *Engine) Lookup(sha string) (string, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	body, ok := e.scripts[strings.ToLower(sha)]
+	return body, ok
+}
+
+// Flush empties the cache.
+func (e *Engine) Flush() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.scripts = map[string]string{}
+}
+
+// Encode writes the cached scripts to w.
+func (e *Engine) Encode(w io.Writer) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return gob.NewEncoder(w).Encode(e.scripts)
+}
+
+// Decode replaces the cached scripts with the ones read from r.
+func (e *Engine) Decode(r io.Reader) error {
+	m := map[string]string{}
+	if err := gob.NewDecoder(r).Decode(&m); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.scripts = m
+	return nil
+}
+
+// Eval runs body with the KEYS and ARGV globals set and returns its reply
+// encoded in RESP. Errors are returned as RESP error replies, so that every
+// replica answers a failing script in the same way. The script is cached
+// when it compiles.
+//
+// A script is not limited in time, because aborting on a timer would make
+// replicas diverge, but fails once it ran MaxInstructions instructions.
+func (e *Engine) Eval(body string, keys, argv [][]byte, call Call) []byte {
+	L := newState(call)
+	defer L.Close()
+	L.SetContext(&budget{Context: context.Background(), left: MaxInstructions})
+
+	fn, err := L.LoadString(body)
+	if err != nil {
+		return redcon.AppendError(nil, "ERR Error compiling script: "+oneLine(err.Error()))
+	}
+	e.add(body)
+
+	L.SetGlobal("KEYS", stringTable(L, keys))
+	L.SetGlobal("ARGV", stringTable(L, argv))
+
+	if err := L.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}); err != nil {
+		return errorReply(err)
+	}
+	return appendValue(nil, L.Get(-1))
+}
+
+// newState returns a Lua state with the deterministic subset of the standard
+// libraries and the redis table.
+func newState(call Call) *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "print"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	rng := rand.New(rand.NewPCG(0, 0))
+	math := L.GetGlobal("math")
+	L.SetField(math, "random", L.NewFunction(func(L *lua.LState) int {
+		switch L.GetTop() {
+		case 0:
+			L.Push(lua.LNumber(rng.Float64()))
+		case 1:
+			L.Push(lua.LNumber(1 + rng.Int64N(max(L.CheckInt64(1), 1))))
+		default:
+			lo, hi := L.CheckInt64(1), L.CheckInt64(2)
+			L.Push(lua.LNumber(lo + rng.Int64N(max(hi-lo+1, 1))))
+		}
+		return 1
+	}))
+	L.SetField(math, "randomseed", L.NewFunction(func(L *lua.LState) int {
+		rng = rand.New(rand.NewPCG(uint64(L.CheckInt64(1)), 0))
+		return 0
+	}))
+
+	redis := L.NewTable()
+	L.SetField(redis, "call", L.NewFunction(func(L *lua.LState) int {
+		return redisCall(L, call, true)
+	}))
+	L.SetField(redis, "pcall", L.NewFunction(func(L *lua.LState) int {
+		return redisCall(L, call, false)
+	}))
+	L.SetField(redis, "error_reply", L.NewFunction(func(L *lua.LState) int {
+		L.Push(replyTable(L, "err", L.CheckString(1)))
+		return 1
+	}))
+	L.SetField(redis, "status_reply", L.NewFunction(func(L *lua.LState) int {
+		L.Push(replyTable(L, "ok", L.CheckString(1)))
+		return 1
+	}))
+	L.SetField(redis, "sha1hex", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LString(SHA1Hex(L.CheckString(1))))
+		return 1
+	}))
+	L.SetGlobal("redis", redis)
+
+	return L
+}
+
+// errBudget fails a script that ran out of instructions.
+var errBudget = errors.New("Script exceeded the limit of " + strconv.Itoa(MaxInstructions) + " instructions")
+
+// budget counts the instructions of a script. The Lua VM checks the Done
+// channel of the context of its state before each instruction, which
+// budget closes once there are none left.
+type budget struct {
+	context.Context
+	left int
+	done chan struct{}
+}
+
+func (b *budget) Done() <-chan struct{} {
+	if b.left--; b.left >= 0 {
+		return nil
+	}
+	if b.done == nil {
+		b.done = make(chan struct{})
+		close(b.done)
+	}
+	return b.done
+}
+
+func (b *budget) Err() error {
+	if b.left < 0 {
+		return errBudget
+	}
+	return nil
+}
+
+// redisCall implements redis.call and redis.pcall. An error reply is raised
+// as a Lua error by redis.call and returned as an error table by
+// redis.pcall.
+func redisCall(L *lua.LState, call Call, raise bool) int {
+	n := L.GetTop()
+	if n == 0 {
+		L.RaiseError("Please specify at least one argument for this redis lib call")
+		return 0
+	}
+
+	args := make([][]byte, n)
+	for i := 1; i <= n; i++ {
+		switch v := L.Get(i).(type) {
+		case lua.LString:
+			args[i-1] = []byte(v)
+		case lua.LNumber:
+			args[i-1] = []byte(strconv.FormatFloat(float64(v), 'f', -1, 64))
+		default:
+			L.RaiseError("Lua redis lib command arguments must be strings or integers")
+			return 0
+		}
+	}
+
+	reply, _ := parseReply(L, call(args))
+	if t, ok := reply.(*lua.LTable); ok && raise && t.RawGetString("err") != lua.LNil {
+		L.Error(t, 1)
+		return 0
+	}
+	L.Push(reply)
+	return 1
+}
+
+func stringTable(L *lua.LState, vals [][]byte) *lua.LTable {
+	t := L.NewTable()
+	for i, v := range vals {
+		t.RawSetInt(i+1, lua.LString(v))
+	}
+	return t
+}
+
+func replyTable(L *lua.LState, field, msg string) *lua.LTable {
+	t := L.NewTable()
+	t.RawSetString(field, lua.LString(msg))
+	return t
+}
+
+// errorReply converts the error of a failed script into an error reply. An
+// error raised with an error table, such as by redis.call, keeps its
+// message.
+func errorReply(err error) []byte {
+	if ae, ok := err.(*lua.ApiError); ok {
+		if t, ok := ae.Object.(*lua.LTable); ok {
+			if msg, ok := t.RawGetString("err").(lua.LString); ok {
+				return redcon.AppendError(nil, oneLine(string(msg)))
+			}
+		}
+		if ae.Object != nil {
+			return redcon.AppendError(nil, "ERR Error running script: "+oneLine(ae.Object.String()))
+		}
+	}
+	return redcon.AppendError(nil, "ERR Error running script: "+oneLine(err.Error()))
+}
+
+// appendValue appends the reply for a Lua value returned by a script,
+// following the conversion rules of Redis.
+func appendValue(b []byte, v lua.LValue) []byte {
+	switch v := v.(type) {
+	case lua.LNumber:
+		return redcon.AppendInt(b, int64(v))
+	case lua.LString:
+		return redcon.AppendBulkString(b, string(v))
+	case lua.LBool:
+		if v {
+			return redcon.AppendInt(b, 1)
+		}
+		return redcon.AppendNull(b)
+	case *lua.LTable:
+		if msg, ok := v.RawGetString("err").(lua.LString); ok {
+			return redcon.AppendError(b, oneLine(string(msg)))
+		}
+		if msg, ok := v.RawGetString("ok").(lua.LString); ok {
+			return redcon.AppendString(b, oneLine(string(msg)))
+		}
+		// An array ends at its first nil.
+		n := 0
+		for v.RawGetInt(n+1) != lua.LNil {
+			n++
+		}
+		b = redcon.AppendArray(b, n)
+		for i := 1; i <= n; i++ {
+			b = appendValue(b, v.RawGetInt(i))
+		}
+		return b
+	default:
+		return redcon.AppendNull(b)
+	}
+}
+
+// parseReply converts a RESP reply into the Lua value redis.call returns,
+// and returns the unparsed rest of b.
+func parseReply(L *lua.LState, b []byte) (lua.LValue, []byte) {
+	if len(b) == 0 {
+		return replyTable(L, "err", "ERR empty reply"), nil
+	}
+	end := strings.Index(string(b), "\r\n")
+	if end < 0 {
+		return replyTable(L, "err", "ERR malformed reply"), nil
+	}
+	line, rest := string(b[1:end]), b[end+2:]
+
+	switch b[0] {
+	case '+':
+		return replyTable(L, "ok", line), rest
+	case '-':
+		return replyTable(L, "err", line), rest
+	case ':':
+		n, _ := strconv.ParseInt(line, 10, 64)
+		return lua.LNumber(n), rest
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 || len(rest) < n+2 {
+			return lua.LFalse, rest
+		}
+		return lua.LString(rest[:n]), rest[n+2:]
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return lua.LFalse, rest
+		}
+		t := L.NewTable()
+		for i := 1; i <= n; i++ {
+			var v lua.LValue
+			v, rest = parseReply(L, rest)
+			t.RawSetInt(i, v)
+		}
+		return t, rest
+	default:
+		return replyTable(L, "err", "ERR malformed reply"), nil
+	}
+}
+
+// oneLine makes msg safe for a simple string or error reply.
+func oneLine(msg string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+}
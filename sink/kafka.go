@@ -0,0 +1,51 @@
+package sink
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Kafka is a Publisher to the topics of a Kafka cluster. The events are
+// JSON, keyed by the key changed so that the events of a key land on the
+// same partition, in order.
+type Kafka struct {
+	w *kafka.Writer
+}
+
+// NewKafka returns a Publisher to the Kafka cluster of brokers, connecting
+// over TLS when tlsConfig is set. A message is published once every in-sync
+// replica of its partition has it.
+func NewKafka(brokers []string, tlsConfig *tls.Config) *Kafka {
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		// Publish writes its messages at once and waits for them, so there
+		// is no use waiting for more to fill a batch.
+		BatchTimeout: time.Millisecond,
+	}
+	if tlsConfig != nil {
+		w.Transport = &kafka.Transport{TLS: tlsConfig}
+	}
+	return &Kafka{w: w}
+}
+
+func (k *Kafka) Publish(ctx context.Context, msgs []Message) error {
+	kmsgs := make([]kafka.Message, len(msgs))
+	for i, m := range msgs {
+		b, err := json.Marshal(m.Event)
+		if err != nil {
+			return err
+		}
+		kmsgs[i] = kafka.Message{Topic: m.Topic, Key: m.Key, Value: b}
+	}
+	return k.w.WriteMessages(ctx, kmsgs...)
+}
+
+func (k *Kafka) Close() error {
+	return k.w.Close()
+}
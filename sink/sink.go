@@ -0,0 +1,349 @@
+// Package sink publishes the changes of the keys committed to the cluster
+// to an external system, such as Kafka, for downstream consumers to build
+// materialized views or invalidate caches. A Sink runs on every node and
+// publishes the changes of the shards the node leads, read from their
+// change feed. Delivery is at least once: the log index of the last change
+// published is checkpointed in the replicated state of the shard, apart from
+// the keys, and the next leader resumes from it.
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"raft-redis-cluster/raft"
+)
+
+// Route publishes the changes of the keys starting with Prefix to Topic.
+type Route struct {
+	Prefix string
+	Topic  string
+}
+
+// ParseRoutes parses routes written as prefix=topic, separated by commas,
+// such as "user:=users,order:=orders". A key takes the route of its
+// longest prefix, and an empty prefix routes every other key.
+func ParseRoutes(s string) ([]Route, error) {
+	var routes []Route
+	for _, r := range strings.Split(s, ",") {
+		prefix, topic, ok := strings.Cut(strings.TrimSpace(r), "=")
+		if !ok || topic == "" {
+			return nil, fmt.Errorf("sink: invalid route %q, want prefix=topic", r)
+		}
+		routes = append(routes, Route{Prefix: prefix, Topic: topic})
+	}
+	return routes, nil
+}
+
+// Event is the message published for a change.
+type Event struct {
+	// Index, Term and Time are those of the log entry of the change.
+	// Consumers may drop an event whose Index is not above the last one
+	// they handled for the key, as a delivery may be repeated.
+	Index uint64    `json:"index"`
+	Term  uint64    `json:"term"`
+	Time  time.Time `json:"time"`
+	// Shard is the shard of the key.
+	Shard int `json:"shard"`
+	// Op is set when the key was written, with its new Value, del when it
+	// was deleted or expired, and flushall when every key was deleted,
+	// without a Key.
+	Op string `json:"op"`
+	// DB is the database of the key.
+	DB    int    `json:"db,omitempty"`
+	Key   string `json:"key,omitempty"`
+	Value []byte `json:"value,omitempty"`
+}
+
+// Message is an event to publish to a topic.
+type Message struct {
+	Topic string
+	// Key is the key changed, by which the events of a key keep their
+	// order, and nil for a flushall.
+	Key   []byte
+	Event Event
+}
+
+// Publisher publishes messages to the external system.
+type Publisher interface {
+	// Publish returns once every message was durably published, or fails.
+	// It may have published some of them when it fails.
+	Publish(ctx context.Context, msgs []Message) error
+	Close() error
+}
+
+// Source is the node whose changes are published: a kvs.Node.
+type Source interface {
+	ReadChanges(shard int, after uint64, count int) ([]raft.Change, <-chan struct{}, error)
+	Checkpoint(ctx context.Context, shard int, name string) (uint64, error)
+	SetCheckpoint(ctx context.Context, shard int, name string, index uint64) error
+}
+
+// Config configures a Sink.
+type Config struct {
+	// Name identifies the sink, as the consumer of the changes its
+	// checkpoints are committed for.
+	Name   string
+	Routes []Route
+	// Shards is the number of shards of the node.
+	Shards int
+	// BatchSize is about how many changes are published at once, 100 when
+	// zero.
+	BatchSize int
+	// CheckpointInterval is how often the last change published is
+	// checkpointed, 1s when zero. A new leader publishes again the changes
+	// since the last checkpoint.
+	CheckpointInterval time.Duration
+	// RetryBackoff is how long to wait before publishing again after a
+	// failure, 1s when zero.
+	RetryBackoff time.Duration
+	Logger       *slog.Logger
+}
+
+// Sink publishes the changes of the shards this node leads.
+type Sink struct {
+	cfg Config
+	pub Publisher
+
+	mu sync.Mutex
+	// leader reports whether this node leads each shard, and changed is
+	// closed and replaced when it does.
+	leader  []bool
+	changed chan struct{}
+}
+
+// New returns a Sink publishing to pub. LeaderChanged must be called as the
+// node becomes or stops being the leader of a shard, from
+// kvs.Config.OnLeaderChange.
+func New(cfg Config, pub Publisher) (*Sink, error) {
+	if cfg.Name == "" || len(cfg.Routes) == 0 || cfg.Shards < 1 {
+		return nil, errors.New("sink: Name, Routes and Shards are required")
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.CheckpointInterval == 0 {
+		cfg.CheckpointInterval = time.Second
+	}
+	if cfg.RetryBackoff == 0 {
+		cfg.RetryBackoff = time.Second
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	return &Sink{cfg: cfg, pub: pub, leader: make([]bool, cfg.Shards), changed: make(chan struct{})}, nil
+}
+
+// LeaderChanged records whether this node leads the shard.
+func (s *Sink) LeaderChanged(shard int, leader bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if shard < len(s.leader) {
+		s.leader[shard] = leader
+		close(s.changed)
+		s.changed = make(chan struct{})
+	}
+}
+
+// isLeader reports whether this node leads the shard, and returns a
+// channel closed when that changes.
+func (s *Sink) isLeader(shard int) (bool, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.leader[shard], s.changed
+}
+
+// Run publishes the changes of src until ctx ends, then closes the
+// publisher.
+func (s *Sink) Run(ctx context.Context, src Source) error {
+	var wg sync.WaitGroup
+	for i := range s.cfg.Shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runShard(ctx, src, i)
+		}()
+	}
+	wg.Wait()
+	return s.pub.Close()
+}
+
+// runShard publishes the changes of the shard while this node leads it.
+func (s *Sink) runShard(ctx context.Context, src Source, shard int) {
+	log := s.cfg.Logger.With("sink", s.cfg.Name, "shard", shard)
+	for ctx.Err() == nil {
+		leader, changed := s.isLeader(shard)
+		if !leader {
+			select {
+			case <-ctx.Done():
+			case <-changed:
+			}
+			continue
+		}
+
+		// Publishing stops as soon as the node stops leading the shard.
+		lctx, cancel := context.WithCancel(ctx)
+		go func() {
+			for {
+				leader, changed := s.isLeader(shard)
+				if !leader {
+					cancel()
+					return
+				}
+				select {
+				case <-lctx.Done():
+					return
+				case <-changed:
+				}
+			}
+		}()
+		err := s.publishShard(lctx, src, shard, log)
+		cancel()
+		switch {
+		case ctx.Err() != nil:
+		case errors.Is(err, raft.ErrChangesTrimmed):
+			// Going on would skip the changes lost, so publishing stops until
+			// another node, which may still have them, leads the shard. A
+			// sink under a new name would publish from the oldest change kept.
+			log.Error("changes since the checkpoint are no longer kept, publishing stopped", "error", err)
+			select {
+			case <-ctx.Done():
+			case <-changed:
+			}
+		case err != nil:
+			log.Warn("publishing changes failed", "error", err)
+			sleep(ctx, s.cfg.RetryBackoff)
+		}
+	}
+}
+
+// publishShard publishes the changes of the shard from its checkpoint until
+// ctx ends.
+func (s *Sink) publishShard(ctx context.Context, src Source, shard int, log *slog.Logger) error {
+	after, err := src.Checkpoint(ctx, shard, s.cfg.Name)
+	if err != nil {
+		return err
+	}
+	log.Info("publishing changes", "after", after)
+	// dirty is set once changes were published after the last checkpoint.
+	// The changes that publish nothing don't call for a new one.
+	dirty, lastSave := false, time.Now()
+	save := func() error {
+		if err := src.SetCheckpoint(ctx, shard, s.cfg.Name, after); err != nil {
+			return err
+		}
+		dirty, lastSave = false, time.Now()
+		return nil
+	}
+	for {
+		// The changes since the checkpoint may be lost when the backlog is
+		// too short, or the node restarted from a snapshot: the error is
+		// returned rather than skipping them.
+		changes, added, err := src.ReadChanges(shard, after, s.cfg.BatchSize)
+		if err != nil {
+			return err
+		}
+
+		if len(changes) == 0 {
+			if dirty {
+				if err := save(); err != nil {
+					return err
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-added:
+			}
+			continue
+		}
+
+		if msgs := s.messages(shard, changes); len(msgs) > 0 {
+			for {
+				err := s.pub.Publish(ctx, msgs)
+				if err == nil {
+					break
+				}
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.Warn("publishing changes failed, retrying", "error", err)
+				sleep(ctx, s.cfg.RetryBackoff)
+			}
+			dirty = true
+		}
+		after = changes[len(changes)-1].Index
+		if dirty && time.Since(lastSave) >= s.cfg.CheckpointInterval {
+			if err := save(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// messages returns the messages of the changes of the shard, skipping the
+// keys without a route and the keys of other types than strings.
+func (s *Sink) messages(shard int, changes []raft.Change) []Message {
+	var msgs []Message
+	for _, c := range changes {
+		ev := Event{Index: c.Index, Term: c.Term, Time: c.Time, Shard: shard}
+		switch {
+		case c.Key == nil:
+			ev.Op = "flushall"
+			for _, topic := range s.topics() {
+				msgs = append(msgs, Message{Topic: topic, Event: ev})
+			}
+			continue
+		case c.Deleted:
+			ev.Op = "del"
+		case c.Value != nil:
+			ev.Op, ev.Value = "set", c.Value
+		default:
+			continue
+		}
+		ev.DB, ev.Key = c.DB, string(c.Key)
+		if topic, ok := s.route(ev.Key); ok {
+			msgs = append(msgs, Message{Topic: topic, Key: c.Key, Event: ev})
+		}
+	}
+	return msgs
+}
+
+// route returns the topic of the longest prefix of key.
+func (s *Sink) route(key string) (string, bool) {
+	best := -1
+	topic := ""
+	for _, r := range s.cfg.Routes {
+		if len(r.Prefix) > best && strings.HasPrefix(key, r.Prefix) {
+			best, topic = len(r.Prefix), r.Topic
+		}
+	}
+	return topic, best >= 0
+}
+
+// topics returns the distinct topics of the routes.
+func (s *Sink) topics() []string {
+	var topics []string
+	seen := map[string]bool{}
+	for _, r := range s.cfg.Routes {
+		if !seen[r.Topic] {
+			seen[r.Topic] = true
+			topics = append(topics, r.Topic)
+		}
+	}
+	return topics
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
@@ -0,0 +1,66 @@
+package store
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// backends は、Open で選べるストアの実装
+// ディスクを使う実装は、開くディレクトリを受け取る
+// 読み込みもそのディスクから行える実装だけを加える。書き込みを写すだけの実装は、キーの内容を二重に持つだけになる
+var backends = map[string]func(dir string, opts Options) (Store, error){
+	"memory": func(string, Options) (Store, error) { return NewMemoryStore(), nil },
+	"badger": openBadgerStore,
+	"bolt":   openBoltStore,
+	"pebble": openPebbleStore,
+}
+
+// Options は、ディスクを使う実装の設定
+type Options struct {
+	// Cipher があれば、ディスクに書き込む値をこれで暗号化する
+	Cipher Cipher
+	// Fsync は、書き込みのたびにファイルを fsync する
+	// しなければ、電源断で壊れていることのある、正しく閉じられなかったファイルは消して作り直す
+	// どちらでも、キー空間を使い続けるのは Checkpoint を記録して閉じたファイルだけで、それ以外は Raft から作り直す
+	Fsync bool
+}
+
+// Checkpointer は、閉じたときのキー空間を、次に開いたときにも使える実装
+// Raft は起動時に最新のスナップショットからキー空間を作り直すが、それを書き込んだときの内容がファイルに残っていれば、
+// スナップショットのキーを書き直さずに済む
+type Checkpointer interface {
+	// Checkpoint は、今のキー空間が Raft のログのインデックス index までを適用したものであることを記録し、ディスクに書き出す
+	// 書き込みが止まってから、閉じる直前に呼ぶ
+	Checkpoint(index uint64) error
+	// Resume は、開いたときのキー空間が index までを適用したものであれば、それを使い続けて true を返す
+	// そうでなければキー空間を空にして false を返す。使えるのは開いてから最初の呼び出しだけで、以降は何もせずに false を返す
+	Resume(index uint64) (bool, error)
+}
+
+// Cipher は、ディスクに書き込む値を暗号化する。encryption.Keyring が満たす
+// Seal に渡した ad は Open にも渡し、値をその置き場所に結びつける
+type Cipher interface {
+	Seal(data, ad []byte) []byte
+	Open(data, ad []byte) ([]byte, error)
+}
+
+// Backends は、Open で選べる実装の名前を返す
+func Backends() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Open は、name の実装のストアを開く
+// dir と opts は、ディスクを使う実装がデータを置くディレクトリとその設定
+func Open(name, dir string, opts Options) (Store, error) {
+	open, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown store backend %q, must be one of %s", name, strings.Join(Backends(), ", "))
+	}
+	return open(dir, opts)
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// BadgerDB のキーは、キーの値とキー空間についての記録を前に付けた1バイトで分ける
+// badgerCheckpointKey には、Checkpoint で記録したインデックスを置く
+var (
+	badgerKeyPrefix     = []byte("k")
+	badgerCheckpointKey = []byte("mcheckpoint")
+)
+
+// badgerKV は、BadgerDB のディレクトリにキーの値を置く diskKV
+type badgerKV struct {
+	db *badger.DB
+}
+
+var _ diskKV = (*badgerKV)(nil)
+
+// openBadgerStore は、dir の BadgerDB にキーの値を置くストアを開く
+func openBadgerStore(dir string, opts Options) (Store, error) {
+	return openDiskStore(dir, "badger", opts, openBadgerKV)
+}
+
+// openBadgerKV は、path のディレクトリの BadgerDB を開く。ログは出さない
+func openBadgerKV(path string, fsync bool) (diskKV, error) {
+	db, err := badger.Open(badger.DefaultOptions(path).WithSyncWrites(fsync).WithLogger(nil))
+	if err != nil {
+		return nil, err
+	}
+	return &badgerKV{db: db}, nil
+}
+
+// badgerKey は、ストアのキーの BadgerDB でのキーを返す
+func badgerKey(key []byte) []byte {
+	return append(append([]byte(nil), badgerKeyPrefix...), key...)
+}
+
+func (b *badgerKV) Get(key []byte) ([]byte, error) {
+	var v []byte
+	err := b.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(badgerKey(key))
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		v, err = item.ValueCopy(nil)
+		return err
+	})
+	return v, err
+}
+
+// Write は、WriteBatch で書き込む。トランザクションの大きさの上限を超える batch は分けて書き込む
+func (b *badgerKV) Write(batch map[string][]byte) error {
+	wb := b.db.NewWriteBatch()
+	defer wb.Cancel()
+	for k, v := range batch {
+		if v == nil {
+			if err := wb.Delete(badgerKey([]byte(k))); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := wb.Set(badgerKey([]byte(k)), v); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+func (b *badgerKV) Clear() error {
+	return b.db.DropPrefix(badgerKeyPrefix)
+}
+
+// View は、読み込みトランザクションを開始する
+func (b *badgerKV) View() (diskView, error) {
+	return badgerView{txn: b.db.NewTransaction(false)}, nil
+}
+
+func (b *badgerKV) Checkpoint() (uint64, error) {
+	var index uint64
+	err := b.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(badgerCheckpointKey)
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			if len(v) == 8 {
+				index = binary.BigEndian.Uint64(v)
+			}
+			return nil
+		})
+	})
+	return index, err
+}
+
+func (b *badgerKV) SetCheckpoint(index uint64) error {
+	if err := b.db.Update(func(txn *badger.Txn) error {
+		if index == 0 {
+			return txn.Delete(badgerCheckpointKey)
+		}
+		return txn.Set(badgerCheckpointKey, binary.BigEndian.AppendUint64(nil, index))
+	}); err != nil {
+		return err
+	}
+	return b.db.Sync()
+}
+
+func (b *badgerKV) Close() error {
+	return b.db.Close()
+}
+
+// badgerView は、読み込みトランザクションの diskView
+type badgerView struct {
+	txn *badger.Txn
+}
+
+func (v badgerView) ForEach(f func(k, v []byte) error) error {
+	it := v.txn.NewIterator(badger.IteratorOptions{Prefix: badgerKeyPrefix})
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		err := item.Value(func(val []byte) error {
+			return f(item.Key()[len(badgerKeyPrefix):], val)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v badgerView) Release() {
+	v.txn.Discard()
+}
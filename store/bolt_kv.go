@@ -0,0 +1,154 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// boltBucket BoltDB でキーの値を保存するバケット
+var boltBucket = []byte("keys")
+
+// boltMetaBucket は、キー空間についての記録を保存するバケット
+// boltCheckpointKey には、Checkpoint で記録したインデックスを置く
+var (
+	boltMetaBucket    = []byte("meta")
+	boltCheckpointKey = []byte("checkpoint")
+)
+
+// boltMmapSize は、BoltDB のファイルを最初に割り当てる大きさ
+// 書き出し中のスナップショットの読み込みトランザクションは、ファイルの割り当て直しを待たせるため、余裕を持って割り当てる
+const boltMmapSize = 1 << 30
+
+// boltKV は、BoltDB の1つのファイルにキーの値を置く diskKV
+type boltKV struct {
+	db *bolt.DB
+}
+
+var _ diskKV = (*boltKV)(nil)
+
+// openBoltStore は、dir の BoltDB のファイルにキーの値を置くストアを開く
+func openBoltStore(dir string, opts Options) (Store, error) {
+	return openDiskStore(dir, "store.db", opts, openBoltKV)
+}
+
+// openBoltKV は、path の BoltDB のファイルを開く。開けないファイルは消して作り直す
+func openBoltKV(path string, fsync bool) (diskKV, error) {
+	opts := &bolt.Options{Timeout: time.Second, InitialMmapSize: boltMmapSize}
+	db, err := bolt.Open(path, 0o600, opts)
+	if errors.Is(err, bolt.ErrInvalid) || errors.Is(err, bolt.ErrChecksum) || errors.Is(err, bolt.ErrVersionMismatch) {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		db, err = bolt.Open(path, 0o600, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	db.NoSync = !fsync
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltMetaBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltKV{db: db}, nil
+}
+
+func (b *boltKV) Get(key []byte) ([]byte, error) {
+	var v []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		// トランザクションの外では、ファイルを割り当てたメモリを指す値を使えない
+		if data := tx.Bucket(boltBucket).Get(key); data != nil {
+			v = append([]byte(nil), data...)
+		}
+		return nil
+	})
+	return v, err
+}
+
+func (b *boltKV) Write(batch map[string][]byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bk := tx.Bucket(boltBucket)
+		for k, v := range batch {
+			if v == nil {
+				if err := bk.Delete([]byte(k)); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := bk.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *boltKV) Clear() error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(boltBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(boltBucket)
+		return err
+	})
+}
+
+// View は、読み込みトランザクションを開始する
+func (b *boltKV) View() (diskView, error) {
+	tx, err := b.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	return boltView{tx: tx}, nil
+}
+
+func (b *boltKV) Checkpoint() (uint64, error) {
+	var index uint64
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(boltMetaBucket).Get(boltCheckpointKey); len(v) == 8 {
+			index = binary.BigEndian.Uint64(v)
+		}
+		return nil
+	})
+	return index, err
+}
+
+func (b *boltKV) SetCheckpoint(index uint64) error {
+	if err := b.db.Update(func(tx *bolt.Tx) error {
+		mb := tx.Bucket(boltMetaBucket)
+		if index == 0 {
+			return mb.Delete(boltCheckpointKey)
+		}
+		return mb.Put(boltCheckpointKey, binary.BigEndian.AppendUint64(nil, index))
+	}); err != nil {
+		return err
+	}
+	return b.db.Sync()
+}
+
+func (b *boltKV) Close() error {
+	return b.db.Close()
+}
+
+// boltView は、読み込みトランザクションの diskView
+type boltView struct {
+	tx *bolt.Tx
+}
+
+func (v boltView) ForEach(f func(k, v []byte) error) error {
+	return v.tx.Bucket(boltBucket).ForEach(f)
+}
+
+func (v boltView) Release() {
+	v.tx.Rollback()
+}
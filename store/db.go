@@ -0,0 +1,140 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+)
+
+// データベース 0 以外のキーは、先頭に dbPrefix とデータベースの番号、dbSep を付けて保存する
+// データベース 0 のキーはそのまま保存するため、データベースを使わないクライアントから見た内容は変わらない
+const (
+	dbPrefix = "\x00db"
+	dbSep    = '\x00'
+)
+
+// DBKey は、データベース db のキー key を保存するキーを返す
+func DBKey(db int, key []byte) []byte {
+	if db == 0 {
+		return key
+	}
+	b := make([]byte, 0, len(dbPrefix)+4+len(key))
+	b = append(b, dbPrefix...)
+	b = strconv.AppendInt(b, int64(db), 10)
+	b = append(b, dbSep)
+	return append(b, key...)
+}
+
+// SplitDBKey は、保存したキーをデータベースの番号とそのデータベースでのキーに分ける
+func SplitDBKey(key []byte) (int, []byte) {
+	if !bytes.HasPrefix(key, []byte(dbPrefix)) {
+		return 0, key
+	}
+	rest := key[len(dbPrefix):]
+	i := bytes.IndexByte(rest, dbSep)
+	if i < 1 {
+		return 0, key
+	}
+	db, err := strconv.Atoi(string(rest[:i]))
+	if err != nil || db < 1 {
+		return 0, key
+	}
+	return db, rest[i+1:]
+}
+
+// IsDBKey は、key がデータベース 0 以外のキーを保存する形をしているかを返す
+// クライアントがこの形のキーを使うと他のデータベースのキーに触れてしまうため、使わせてはならない
+func IsDBKey(key []byte) bool {
+	return bytes.HasPrefix(key, []byte(dbPrefix))
+}
+
+// dbOf は、保存したキーのデータベースの番号を返す
+func dbOf(key string) int {
+	db, _ := SplitDBKey([]byte(key))
+	return db
+}
+
+// DBSize は、データベース db の期限切れでないキーの数を返す
+func (s *memoryStore) DBSize(ctx context.Context, db int) (int, error) {
+	if err := s.rlock(ctx); err != nil {
+		return 0, err
+	}
+	defer s.mtx.RUnlock()
+
+	return countKeys(ctx, s.m, func(k string) bool { return dbOf(k) == db })
+}
+
+// FlushDB は、データベース db の全てのキーを削除し、削除したキーを返す
+func (s *memoryStore) FlushDB(_ context.Context, db int) ([][]byte, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var keys [][]byte
+	for k := range s.m {
+		if dbOf(k) == db {
+			keys = append(keys, []byte(k))
+		}
+	}
+	for _, k := range keys {
+		s.remove(string(k))
+	}
+	return keys, nil
+}
+
+// SwapDB は、データベース a と b の内容を入れ替え、入れ替えたキーを両方のデータベースについて返す
+func (s *memoryStore) SwapDB(_ context.Context, a, b int) ([][]byte, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if a == b {
+		return nil, nil
+	}
+	moved := map[string]*entry{}
+	var keys [][]byte
+	for k, e := range s.m {
+		db, key := SplitDBKey([]byte(k))
+		switch db {
+		case a:
+			moved[string(DBKey(b, key))] = e
+		case b:
+			moved[string(DBKey(a, key))] = e
+		default:
+			continue
+		}
+		keys = append(keys, []byte(k))
+	}
+	for _, k := range keys {
+		s.drop(string(k))
+	}
+	for k, e := range moved {
+		// 書き出し中のスナップショットが参照しているエントリは、その場で書き換えられないよう複製する
+		if s.snapshots > 0 && e.gen != s.gen {
+			e = e.clone()
+		}
+		s.set(k, e)
+		keys = append(keys, []byte(k))
+	}
+	return keys, nil
+}
+
+func (s *shardedStore) DBSize(ctx context.Context, db int) (int, error) {
+	total := 0
+	for _, st := range s.shards {
+		n, err := st.DBSize(ctx, db)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// FlushDB データベースの削除はシャードごとに複製するため、ErrShardedStore を返す
+func (s *shardedStore) FlushDB(context.Context, int) ([][]byte, error) {
+	return nil, ErrShardedStore
+}
+
+// SwapDB データベースの入れ替えはシャードごとに複製するため、ErrShardedStore を返す
+func (s *shardedStore) SwapDB(context.Context, int, int) ([][]byte, error) {
+	return nil, ErrShardedStore
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"math"
+	"slices"
+)
+
+// Digest は、ctx の時刻で生きているキーの、値と有効期限を含めた内容のハッシュとキーの数を返す
+// キーごとのハッシュを XOR で合わせるので、キーの順序によらず、同じ内容のレプリカでは同じ値になる
+// ハッシュのフィールドやセットのメンバーも並べ替えてからハッシュする
+func (s *memoryStore) Digest(ctx context.Context) ([]byte, int, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, 0, err
+	}
+	defer s.mtx.RUnlock()
+
+	now := Now(ctx).UnixMilli()
+	sum := make([]byte, sha256.Size)
+	n, i := 0, 0
+	h := sha256.New()
+	for k, e := range s.m {
+		if i++; i%ctxCheckKeys == 0 && ctx.Err() != nil {
+			return nil, 0, ContextErr(ctx)
+		}
+		if e.expired(now) {
+			continue
+		}
+		h.Reset()
+		e.digest(h, k)
+		for i, b := range h.Sum(nil) {
+			sum[i] ^= b
+		}
+		n++
+	}
+	return sum, n, nil
+}
+
+// digest は、キーとエントリを長さを前に付けた並びで h に書き込む
+func (e *entry) digest(h hash.Hash, key string) {
+	var buf [8]byte
+	writeUint := func(v uint64) {
+		binary.BigEndian.PutUint64(buf[:], v)
+		h.Write(buf[:])
+	}
+	writeBytes := func(b []byte) {
+		writeUint(uint64(len(b)))
+		h.Write(b)
+	}
+
+	writeBytes([]byte(key))
+	writeUint(uint64(e.kind))
+	writeUint(uint64(e.expireAt))
+	writeBytes(e.value)
+
+	fields := make([]string, 0, len(e.hash))
+	for f := range e.hash {
+		fields = append(fields, f)
+	}
+	slices.Sort(fields)
+	writeUint(uint64(len(fields)))
+	for _, f := range fields {
+		writeBytes([]byte(f))
+		writeBytes(e.hash[f])
+	}
+
+	members := make([]string, 0, len(e.set))
+	for m := range e.set {
+		members = append(members, m)
+	}
+	slices.Sort(members)
+	writeUint(uint64(len(members)))
+	for _, m := range members {
+		writeBytes([]byte(m))
+	}
+
+	if e.zset != nil {
+		// メンバーはスコアとメンバーの順に並んでいる
+		zm := e.zset.members()
+		writeUint(uint64(len(zm)))
+		for _, m := range zm {
+			writeBytes(m.Member)
+			writeUint(math.Float64bits(m.Score))
+		}
+	}
+
+	if e.stream != nil {
+		writeUint(uint64(len(e.stream.entries)))
+		for _, se := range e.stream.entries {
+			writeUint(se.ID.Ms)
+			writeUint(se.ID.Seq)
+			writeUint(uint64(len(se.Fields)))
+			for _, f := range se.Fields {
+				writeBytes(f)
+			}
+		}
+		writeUint(e.stream.lastID.Ms)
+		writeUint(e.stream.lastID.Seq)
+	}
+}
@@ -0,0 +1,852 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// diskRestoreBatch は、Restore が1回の書き込みでまとめるキーの数
+const diskRestoreBatch = 4096
+
+// diskKV は、diskStore がキーの値を置くディスクのキーバリューストアで、BoltDB、BadgerDB と Pebble の実装がある
+// キーの値とは別に、チェックポイントのインデックスを1つ記録できる
+type diskKV interface {
+	// Get は、key の値のコピーを返す。なければ nil を返す
+	Get(key []byte) ([]byte, error)
+	// Write は、batch の値をまとめて書き込む。値が nil のキーは削除する
+	Write(batch map[string][]byte) error
+	// Clear は、全てのキーの値を削除する
+	Clear() error
+	// View は、この時点の全てのキーの値を、その後の書き込みを止めずに読み出せるビューを返す
+	View() (diskView, error)
+	// Checkpoint は、記録したチェックポイントのインデックスを返す。なければ 0 を返す
+	Checkpoint() (uint64, error)
+	// SetCheckpoint は、チェックポイントのインデックスを記録し、それまでの書き込みと合わせて fsync する
+	// index が 0 ならチェックポイントを消す
+	SetCheckpoint(index uint64) error
+	Close() error
+}
+
+// diskView は、diskKV のある時点の内容を読み出す
+type diskView interface {
+	// ForEach は、キーの値ごとに f を呼ぶ。k と v は f の中でだけ使える
+	ForEach(f func(k, v []byte) error) error
+	Release()
+}
+
+// diskOpener は、path に diskKV を開く。fsync でなければ、書き込みを fsync しない
+type diskOpener func(path string, fsync bool) (diskKV, error)
+
+// diskStore は、キーの値をディスクのキーバリューストアに置くストア
+// 値は Dump と同じ形式でエンコードして1キーずつ保存し、読み込みのたびにディスクから読み出す
+// メモリには、型、有効期限、大きさと LRU と LFU の記録といったキーのメタデータだけを、値を持たない entry として置く
+// Scan や期限切れのキーの検出、追い出すキーの選択はメタデータだけで行う
+// ハッシュなどの要素を書き換える操作は、値全体を読み出して書き戻す
+// cipher があれば、値はキーに結びつけて暗号化して保存する
+type diskStore struct {
+	kv     diskKV
+	cipher Cipher
+
+	mtx   sync.RWMutex
+	meta  map[string]*entry
+	index *skiplist[indexKey]
+	// used 全てのキーの size の合計
+	used  int64
+	lazy  lazyExpired
+	usage prefixUsage
+	// checkpoint は、開いたときに記録されていたチェックポイントのインデックスで、なければ 0
+	// resumed は、Resume が呼ばれたか Restore で書き直した後に立つ
+	checkpoint uint64
+	resumed    bool
+}
+
+var (
+	_ Store        = (*diskStore)(nil)
+	_ Checkpointer = (*diskStore)(nil)
+)
+
+// openDiskStore は、dir の name に open で開いたキーバリューストアに、キーの値を置くストアを開く
+// 前回 Checkpoint を記録して閉じたなら、そのキー空間を読み込んで Resume を待つ。そうでなければキーを消す
+// opts.Fsync でなければ、Checkpoint なしで閉じたものは電源断で壊れていることがあるため、消して作り直す
+func openDiskStore(dir, name string, opts Options, open diskOpener) (Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, name)
+	kv, err := open(path, opts.Fsync)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.Fsync {
+		index, err := kv.Checkpoint()
+		if err != nil || index == 0 {
+			kv.Close()
+			if err := os.RemoveAll(path); err != nil {
+				return nil, err
+			}
+			if kv, err = open(path, false); err != nil {
+				return nil, err
+			}
+		}
+	}
+	s := &diskStore{
+		kv:     kv,
+		cipher: opts.Cipher,
+		meta:   map[string]*entry{},
+		index:  newSkiplist(compareIndexKey),
+	}
+	if err := s.reopen(); err != nil {
+		kv.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// reopen は、前回記録したチェックポイントを読み出して消し、そのキー空間のメタデータを読み込む
+// チェックポイントがないか、値を読み出せないキーがあれば、キーを消す
+// 消したチェックポイントは、この後の書き込みの途中で落ちても使われないよう、fsync してから書き込みを受ける
+func (s *diskStore) reopen() error {
+	index, err := s.kv.Checkpoint()
+	if err != nil {
+		return err
+	}
+	if err := s.kv.SetCheckpoint(0); err != nil {
+		return err
+	}
+	s.checkpoint = index
+	if s.checkpoint > 0 {
+		view, err := s.kv.View()
+		if err == nil {
+			err = view.ForEach(func(k, v []byte) error {
+				v, err := s.unseal(k, v)
+				if err != nil {
+					return err
+				}
+				e, err := decodeEntry(v)
+				if err != nil {
+					return err
+				}
+				s.setMeta(string(k), e)
+				return nil
+			})
+			view.Release()
+		}
+		if err != nil {
+			s.checkpoint = 0
+		}
+	}
+	if s.checkpoint == 0 {
+		return s.clear()
+	}
+	return nil
+}
+
+// Checkpoint は、今のキー空間がインデックス index までを適用したものであることを記録し、ディスクに書き出す
+func (s *diskStore) Checkpoint(index uint64) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.kv.SetCheckpoint(index)
+}
+
+// Resume は、開いたときのチェックポイントが index であれば、読み込んだキー空間を使い続ける
+func (s *diskStore) Resume(index uint64) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.resumed {
+		return false, nil
+	}
+	s.resumed = true
+	if s.checkpoint != 0 && s.checkpoint == index {
+		return true, nil
+	}
+	return false, s.clear()
+}
+
+// diskAD は、キーの値を暗号化するときにキーの前に付けて、値をその置き場所に結びつけるデータ
+var diskAD = []byte("keys\x00")
+
+// seal は、キーのエンコードした値をディスクに書き込む形にする
+func (s *diskStore) seal(key, data []byte) []byte {
+	if s.cipher == nil {
+		return data
+	}
+	return s.cipher.Seal(data, append(append([]byte(nil), diskAD...), key...))
+}
+
+// unseal は、ディスクから読み出したキーの値を、エンコードした値に戻す
+// 暗号化しない場合は v をそのまま返すため、ForEach の外で使うならコピーすること
+func (s *diskStore) unseal(key, v []byte) ([]byte, error) {
+	if s.cipher == nil {
+		return v, nil
+	}
+	return s.cipher.Open(v, append(append([]byte(nil), diskAD...), key...))
+}
+
+// rlock は、読み込みロックを取得する
+// 書き込みがロックを持っている間に ctx が終わった場合は、取得を諦めて ContextErr のエラーを返す
+func (s *diskStore) rlock(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return ContextErr(ctx)
+	}
+	if s.mtx.TryRLock() {
+		return nil
+	}
+	if ctx.Done() == nil {
+		s.mtx.RLock()
+		return nil
+	}
+	locked := make(chan struct{})
+	go func() {
+		s.mtx.RLock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		go func() {
+			<-locked
+			s.mtx.RUnlock()
+		}()
+		return ContextErr(ctx)
+	}
+}
+
+// lookup は、期限切れを考慮してキーのメタデータを返し、キーが使われたことを記録する
+// 呼び出し側でロックを取得していること
+func (s *diskStore) lookup(ctx context.Context, key []byte) (*entry, bool) {
+	m, ok := s.meta[string(key)]
+	if !ok {
+		return nil, false
+	}
+	if m.expired(Now(ctx).UnixMilli()) {
+		s.lazy.note(string(key))
+		return nil, false
+	}
+	m.access()
+	return m, true
+}
+
+// load は、期限切れと型を考慮して、キーの値をディスクから読み出す
+// kind が KindNone の場合は型を問わない
+// 呼び出し側でロックを取得していること
+func (s *diskStore) load(ctx context.Context, key []byte, kind Kind) (*entry, error) {
+	m, ok := s.lookup(ctx, key)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	if kind != KindNone && m.kind != kind {
+		return nil, ErrWrongType
+	}
+	data, err := s.raw(key)
+	if err != nil {
+		return nil, err
+	}
+	return decodeEntry(data)
+}
+
+// raw は、キーのエンコードした値をディスクから読み出す
+// 呼び出し側でロックを取得していること
+func (s *diskStore) raw(key []byte) ([]byte, error) {
+	v, err := s.kv.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, ErrKeyNotFound
+	}
+	return s.unseal(key, v)
+}
+
+// put は、キーの値をディスクに書き込み、メタデータを更新する
+// 呼び出し側で書き込みロックを取得していること
+func (s *diskStore) put(key string, e *entry) error {
+	data, err := e.encode()
+	if err != nil {
+		return err
+	}
+	if err := s.kv.Write(map[string][]byte{key: s.seal([]byte(key), data)}); err != nil {
+		return err
+	}
+	s.setMeta(key, e)
+	return nil
+}
+
+// remove は、キーの値をディスクから削除し、メタデータを取り除く
+// 呼び出し側で書き込みロックを取得していること
+func (s *diskStore) remove(key string) error {
+	if _, ok := s.meta[key]; !ok {
+		return nil
+	}
+	if err := s.kv.Write(map[string][]byte{key: nil}); err != nil {
+		return err
+	}
+	s.dropMeta(key)
+	return nil
+}
+
+// setMeta は、書き込んだキーの値 e のメタデータを記録する
+// 既にあるキーは、LRU と LFU の記録を引き継ぐ
+// 呼び出し側で書き込みロックを取得していること
+func (s *diskStore) setMeta(key string, e *entry) {
+	m, ok := s.meta[key]
+	if ok {
+		s.used -= m.size
+		m.account(-1)
+	} else {
+		m = &entry{}
+		m.freq.Store(lfuInitVal)
+		m.accessed.Store(time.Now().UnixMilli())
+		s.meta[key] = m
+		s.index.Insert(newIndexKey(key))
+	}
+	m.kind, m.expireAt, m.size = e.kind, e.expireAt, entrySize(key, e)
+	m.usage = s.usage.of(key)
+	m.account(1)
+	s.used += m.size
+}
+
+// dropMeta は、キーのメタデータを取り除いて返す
+// 呼び出し側で書き込みロックを取得していること
+func (s *diskStore) dropMeta(key string) *entry {
+	m, ok := s.meta[key]
+	if !ok {
+		return nil
+	}
+	s.used -= m.size
+	m.account(-1)
+	delete(s.meta, key)
+	s.index.Delete(newIndexKey(key))
+	return m
+}
+
+func (s *diskStore) Get(ctx context.Context, key []byte) ([]byte, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.load(ctx, key, KindString)
+	if err != nil {
+		return nil, err
+	}
+	return e.value, nil
+}
+
+func (s *diskStore) Put(_ context.Context, key []byte, value []byte) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.put(string(key), &entry{kind: KindString, value: value})
+}
+
+func (s *diskStore) Delete(_ context.Context, key []byte) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.remove(string(key))
+}
+
+func (s *diskStore) Exists(ctx context.Context, key []byte) (bool, error) {
+	if err := s.rlock(ctx); err != nil {
+		return false, err
+	}
+	defer s.mtx.RUnlock()
+
+	_, ok := s.lookup(ctx, key)
+	return ok, nil
+}
+
+func (s *diskStore) Type(ctx context.Context, key []byte) (Kind, error) {
+	if err := s.rlock(ctx); err != nil {
+		return KindNone, err
+	}
+	defer s.mtx.RUnlock()
+
+	m, ok := s.lookup(ctx, key)
+	if !ok {
+		return KindNone, nil
+	}
+	return m.kind, nil
+}
+
+func (s *diskStore) Expire(ctx context.Context, key []byte, at time.Time) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	e, err := s.load(ctx, key, KindNone)
+	if err != nil {
+		return err
+	}
+	e.expireAt = at.UnixMilli()
+	return s.put(string(key), e)
+}
+
+func (s *diskStore) Persist(ctx context.Context, key []byte) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	e, err := s.load(ctx, key, KindNone)
+	if err != nil {
+		return err
+	}
+	e.expireAt = 0
+	return s.put(string(key), e)
+}
+
+func (s *diskStore) TTL(ctx context.Context, key []byte) (time.Time, error) {
+	if err := s.rlock(ctx); err != nil {
+		return time.Time{}, err
+	}
+	defer s.mtx.RUnlock()
+
+	m, ok := s.lookup(ctx, key)
+	if !ok {
+		return time.Time{}, ErrKeyNotFound
+	}
+	if m.expireAt == 0 {
+		return time.Time{}, nil
+	}
+	return time.UnixMilli(m.expireAt), nil
+}
+
+func (s *diskStore) Scan(ctx context.Context, cursor uint64, count int) ([][]byte, uint64, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, 0, err
+	}
+	defer s.mtx.RUnlock()
+
+	keys, next := scanIndex(s.index, s.meta, Now(ctx).UnixMilli(), cursor, count)
+	return keys, next, nil
+}
+
+func (s *diskStore) Len(ctx context.Context) (int, error) {
+	if err := s.rlock(ctx); err != nil {
+		return 0, err
+	}
+	defer s.mtx.RUnlock()
+
+	return countKeys(ctx, s.meta, func(string) bool { return true })
+}
+
+func (s *diskStore) Flush(_ context.Context) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.clear()
+}
+
+// clear は、全てのキーをディスクとメタデータから消す
+// 呼び出し側で書き込みロックを取得していること
+func (s *diskStore) clear() error {
+	if err := s.kv.Clear(); err != nil {
+		return err
+	}
+	s.meta = map[string]*entry{}
+	s.index = newSkiplist(compareIndexKey)
+	s.used = 0
+	s.usage.reset()
+	return nil
+}
+
+func (s *diskStore) DBSize(ctx context.Context, db int) (int, error) {
+	if err := s.rlock(ctx); err != nil {
+		return 0, err
+	}
+	defer s.mtx.RUnlock()
+
+	return countKeys(ctx, s.meta, func(k string) bool { return dbOf(k) == db })
+}
+
+func (s *diskStore) FlushDB(_ context.Context, db int) ([][]byte, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var keys [][]byte
+	batch := map[string][]byte{}
+	for k := range s.meta {
+		if dbOf(k) == db {
+			keys = append(keys, []byte(k))
+			batch[k] = nil
+		}
+	}
+	if err := s.kv.Write(batch); err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		s.dropMeta(string(k))
+	}
+	return keys, nil
+}
+
+func (s *diskStore) SwapDB(_ context.Context, a, b int) ([][]byte, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if a == b {
+		return nil, nil
+	}
+	moved := map[string]string{}
+	var keys [][]byte
+	for k := range s.meta {
+		db, key := SplitDBKey([]byte(k))
+		switch db {
+		case a:
+			moved[k] = string(DBKey(b, key))
+		case b:
+			moved[k] = string(DBKey(a, key))
+		default:
+			continue
+		}
+		keys = append(keys, []byte(k))
+	}
+	// 暗号化した値は元のキーに結びついているため、復号して移し先のキーで暗号化し直す
+	batch := make(map[string][]byte, len(moved))
+	for from, to := range moved {
+		v, err := s.raw([]byte(from))
+		if err != nil {
+			return nil, err
+		}
+		batch[to] = s.seal([]byte(to), v)
+	}
+	for from := range moved {
+		if _, ok := batch[from]; !ok {
+			batch[from] = nil
+		}
+	}
+	if err := s.kv.Write(batch); err != nil {
+		return nil, err
+	}
+
+	metas := make(map[string]*entry, len(moved))
+	for from, to := range moved {
+		m := s.dropMeta(from)
+		m.size += int64(len(to) - len(from))
+		metas[to] = m
+	}
+	for to, m := range metas {
+		m.usage = s.usage.of(to)
+		m.account(1)
+		s.used += m.size
+		s.meta[to] = m
+		s.index.Insert(newIndexKey(to))
+		keys = append(keys, []byte(to))
+	}
+	return keys, nil
+}
+
+// Dump は、ディスクに保存したエンコード済みの値をそのまま返す
+func (s *diskStore) Dump(ctx context.Context, key []byte) ([]byte, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.mtx.RUnlock()
+
+	if _, ok := s.lookup(ctx, key); !ok {
+		return nil, ErrKeyNotFound
+	}
+	return s.raw(key)
+}
+
+func (s *diskStore) RestoreKey(_ context.Context, key []byte, data []byte) error {
+	e, err := decodeEntry(data)
+	if err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.put(string(key), e)
+}
+
+// diskSnapshot は、作成時点のディスクの内容を diskView で保持する
+type diskSnapshot struct {
+	s    *diskStore
+	view diskView
+	n    int
+	once sync.Once
+}
+
+// Snapshot は、その時点の内容の diskView を作り、それを書き出すスナップショットを返す
+// 値のエンコードは済んでいるため、Persist はディスクから読み出して書き出すだけで、その間も書き込みを止めない
+func (s *diskStore) Snapshot() (Snapshot, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	view, err := s.kv.View()
+	if err != nil {
+		return nil, err
+	}
+	return &diskSnapshot{s: s, view: view, n: len(s.meta)}, nil
+}
+
+// Persist は、メモリストアのスナップショットと同じ形式で、キーの数に続けてキーを1つずつ書き出す
+func (p *diskSnapshot) Persist(w io.Writer) error {
+	if _, err := w.Write(snapshotMagic); err != nil {
+		return err
+	}
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(p.n); err != nil {
+		return err
+	}
+	return p.view.ForEach(func(k, v []byte) error {
+		v, err := p.s.unseal(k, v)
+		if err != nil {
+			return err
+		}
+		e, err := decodeEntry(v)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(snapshotItem{Key: string(k), Entry: e.snapshot()})
+	})
+}
+
+func (p *diskSnapshot) Release() {
+	p.once.Do(func() {
+		p.view.Release()
+	})
+}
+
+// Restore は、スナップショットを1キーずつデコードしてディスクを書き直す
+// 書き込みは diskRestoreBatch 個のキーごとにまとめる
+func (s *diskStore) Restore(buf io.Reader) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.resumed = true
+	if err := s.clear(); err != nil {
+		return err
+	}
+
+	batch := map[string]*entry{}
+	flush := func() error {
+		values := make(map[string][]byte, len(batch))
+		for k, e := range batch {
+			data, err := e.encode()
+			if err != nil {
+				return err
+			}
+			values[k] = s.seal([]byte(k), data)
+		}
+		if err := s.kv.Write(values); err != nil {
+			return err
+		}
+		for k, e := range batch {
+			s.setMeta(k, e)
+		}
+		clear(batch)
+		return nil
+	}
+	if err := readSnapshot(buf, func(k string, se snapshotEntry) error {
+		batch[k] = se.entry()
+		if len(batch) < diskRestoreBatch {
+			return nil
+		}
+		return flush()
+	}); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// Digest は、ディスクの値を1キーずつ読み出してハッシュする
+func (s *diskStore) Digest(ctx context.Context) ([]byte, int, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, 0, err
+	}
+	defer s.mtx.RUnlock()
+
+	now := Now(ctx).UnixMilli()
+	sum := make([]byte, sha256.Size)
+	n, i := 0, 0
+	h := sha256.New()
+	view, err := s.kv.View()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer view.Release()
+	err = view.ForEach(func(k, v []byte) error {
+		if i++; i%ctxCheckKeys == 0 && ctx.Err() != nil {
+			return ContextErr(ctx)
+		}
+		if s.meta[string(k)].expired(now) {
+			return nil
+		}
+		v, err := s.unseal(k, v)
+		if err != nil {
+			return err
+		}
+		e, err := decodeEntry(v)
+		if err != nil {
+			return err
+		}
+		h.Reset()
+		e.digest(h, string(k))
+		for i, b := range h.Sum(nil) {
+			sum[i] ^= b
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return sum, n, nil
+}
+
+func (s *diskStore) Txn(ctx context.Context, f func(ctx context.Context, txn Txn) error) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	txn := &diskStoreTxn{s: s, m: map[string]*entry{}}
+	if err := f(ctx, txn); err != nil {
+		return err
+	}
+
+	batch := make(map[string][]byte, len(txn.m))
+	for k, e := range txn.m {
+		if e == nil {
+			batch[k] = nil
+			continue
+		}
+		data, err := e.encode()
+		if err != nil {
+			return err
+		}
+		batch[k] = s.seal([]byte(k), data)
+	}
+	if err := s.kv.Write(batch); err != nil {
+		return err
+	}
+	for k, e := range txn.m {
+		if e == nil {
+			s.dropMeta(k)
+			continue
+		}
+		s.setMeta(k, e)
+	}
+	return nil
+}
+
+func (s *diskStore) Close() error {
+	return s.kv.Close()
+}
+
+func (s *diskStore) UsedMemory() int64 {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.used
+}
+
+func (s *diskStore) MemoryUsage(ctx context.Context, key []byte) (int64, error) {
+	if err := s.rlock(ctx); err != nil {
+		return 0, err
+	}
+	defer s.mtx.RUnlock()
+
+	m, ok := s.meta[string(key)]
+	if !ok || m.expired(Now(ctx).UnixMilli()) {
+		return 0, ErrKeyNotFound
+	}
+	return m.size, nil
+}
+
+func (s *diskStore) ExpiredKeys(ctx context.Context, samples int) ([][]byte, int, error) {
+	lazy := s.lazy.take()
+
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	keys, sampled := expiredKeys(s.meta, lazy, Now(ctx).UnixMilli(), samples)
+	return keys, sampled, nil
+}
+
+func (s *diskStore) DeleteExpired(ctx context.Context, key []byte) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	m, ok := s.meta[string(key)]
+	if !ok || !m.expired(Now(ctx).UnixMilli()) {
+		return false, nil
+	}
+	if err := s.remove(string(key)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *diskStore) SetUsagePrefixes(prefixes []string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.usage.set(prefixes, s.meta)
+}
+
+func (s *diskStore) PrefixUsage(prefix string) Usage {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.usage.get(prefix)
+}
+
+func (s *diskStore) EvictionCandidate(policy EvictionPolicy, samples int) ([]byte, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return evictionCandidate(s.meta, policy, samples)
+}
+
+// diskStoreTxn は、トランザクション中の変更を保持し、Txn がまとめてディスクに書き込む
+// m の値が nil のキーは、トランザクション中に削除されたことを表す
+type diskStoreTxn struct {
+	s *diskStore
+	m map[string]*entry
+}
+
+func (t *diskStoreTxn) load(ctx context.Context, key []byte) (*entry, bool) {
+	e, ok := t.m[string(key)]
+	if !ok {
+		e, err := t.s.load(ctx, key, KindNone)
+		return e, err == nil
+	}
+	if e == nil || e.expired(Now(ctx).UnixMilli()) {
+		return nil, false
+	}
+	return e, true
+}
+
+func (t *diskStoreTxn) Get(ctx context.Context, key []byte) ([]byte, error) {
+	e, ok := t.load(ctx, key)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	if e.kind != KindString {
+		return nil, ErrWrongType
+	}
+	return e.value, nil
+}
+
+func (t *diskStoreTxn) Put(_ context.Context, key []byte, value []byte) error {
+	t.m[string(key)] = &entry{kind: KindString, value: value}
+	return nil
+}
+
+func (t *diskStoreTxn) Delete(_ context.Context, key []byte) error {
+	t.m[string(key)] = nil
+	return nil
+}
+
+func (t *diskStoreTxn) Exists(ctx context.Context, key []byte) (bool, error) {
+	if _, ok := t.m[string(key)]; !ok {
+		_, ok := t.s.lookup(ctx, key)
+		return ok, nil
+	}
+	_, ok := t.load(ctx, key)
+	return ok, nil
+}
@@ -0,0 +1,311 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"raft-redis-cluster/encryption"
+)
+
+// diskBackends returns the backends keeping the keys on disk.
+func diskBackends() []string {
+	return slices.DeleteFunc(Backends(), func(name string) bool { return name == "memory" })
+}
+
+func TestDiskStores(t *testing.T) {
+	for _, name := range diskBackends() {
+		t.Run(name, func(t *testing.T) {
+			t.Run("MatchesMemoryStore", func(t *testing.T) { testMatchesMemoryStore(t, name) })
+			t.Run("SnapshotIgnoresLaterWrites", func(t *testing.T) { testSnapshotIgnoresLaterWrites(t, name) })
+			t.Run("EncryptsValues", func(t *testing.T) { testEncryptsValues(t, name) })
+			t.Run("KeepsCheckpoint", func(t *testing.T) { testKeepsCheckpoint(t, name) })
+		})
+	}
+}
+
+func openTestDiskStore(t *testing.T, name string) Store {
+	t.Helper()
+	s, err := Open(name, t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// write applies the same writes of every type to s.
+func write(t *testing.T, s Store) {
+	t.Helper()
+	ctx := WithTime(context.Background(), time.UnixMilli(1_000_000))
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(s.Put(ctx, []byte("str"), []byte("v")))
+	must(s.Put(ctx, []byte("gone"), []byte("v")))
+	must(s.Delete(ctx, []byte("gone")))
+	must(s.Put(ctx, []byte("ttl"), []byte("v")))
+	must(s.Expire(ctx, []byte("ttl"), time.UnixMilli(2_000_000)))
+	must(s.Put(ctx, []byte("expired"), []byte("v")))
+	must(s.Expire(ctx, []byte("expired"), time.UnixMilli(1_000_500)))
+	_, err := s.HSet(ctx, []byte("hash"), map[string][]byte{"a": []byte("1"), "b": []byte("2")})
+	must(err)
+	_, err = s.HDel(ctx, []byte("hash"), [][]byte{[]byte("a")})
+	must(err)
+	_, err = s.SAdd(ctx, []byte("set"), [][]byte{[]byte("x"), []byte("y")})
+	must(err)
+	_, err = s.SRem(ctx, []byte("set"), [][]byte{[]byte("x")})
+	must(err)
+	_, err = s.ZAdd(ctx, []byte("zset"), []ZMember{{Member: []byte("m"), Score: 2}, {Member: []byte("n"), Score: 1}})
+	must(err)
+	must(s.XAdd(ctx, []byte("stream"), StreamID{Ms: 1}, [][]byte{[]byte("f"), []byte("v")}))
+	must(s.Put(ctx, DBKey(1, []byte("str")), []byte("db1")))
+	_, err = s.SwapDB(ctx, 1, 2)
+	must(err)
+	must(s.Txn(ctx, func(ctx context.Context, txn Txn) error {
+		return txn.Put(ctx, []byte("txn"), []byte("v"))
+	}))
+}
+
+func digest(t *testing.T, s Store, at int64) ([]byte, int) {
+	t.Helper()
+	sum, n, err := s.Digest(WithTime(context.Background(), time.UnixMilli(at)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sum, n
+}
+
+func testMatchesMemoryStore(t *testing.T, name string) {
+	mem, disk := NewMemoryStore(), openTestDiskStore(t, name)
+	write(t, mem)
+	write(t, disk)
+
+	for _, at := range []int64{1_000_000, 1_001_000, 3_000_000} {
+		want, wantN := digest(t, mem, at)
+		got, gotN := digest(t, disk, at)
+		if !bytes.Equal(got, want) || gotN != wantN {
+			t.Errorf("digest at %d = %x (%d keys), want %x (%d keys)", at, got, gotN, want, wantN)
+		}
+	}
+
+	ctx := WithTime(context.Background(), time.UnixMilli(1_000_000))
+	if v, err := disk.Get(ctx, DBKey(2, []byte("str"))); err != nil || string(v) != "db1" {
+		t.Errorf("Get after SwapDB = %q, %v, want \"db1\"", v, err)
+	}
+	if v, err := disk.HGet(ctx, []byte("hash"), []byte("b")); err != nil || string(v) != "2" {
+		t.Errorf("HGet = %q, %v, want \"2\"", v, err)
+	}
+	if _, err := disk.HGet(ctx, []byte("zset"), []byte("m")); err != ErrWrongType {
+		t.Errorf("HGet on a sorted set = %v, want ErrWrongType", err)
+	}
+	if zm, err := disk.ZRange(ctx, []byte("zset"), 0, -1); err != nil || len(zm) != 2 || string(zm[0].Member) != "n" {
+		t.Errorf("ZRange = %v, %v, want n then m", zm, err)
+	}
+	if n, err := disk.Len(ctx); err != nil || n != 9 {
+		t.Errorf("Len = %d, %v, want 9", n, err)
+	}
+	if mem.UsedMemory() != disk.UsedMemory() {
+		t.Errorf("UsedMemory = %d, want %d as the memory store", disk.UsedMemory(), mem.UsedMemory())
+	}
+
+	late := WithTime(context.Background(), time.UnixMilli(1_001_000))
+	keys, _, err := disk.ExpiredKeys(late, 100)
+	if err != nil || len(keys) != 1 || string(keys[0]) != "expired" {
+		t.Fatalf("ExpiredKeys = %q, %v, want [expired]", keys, err)
+	}
+	if ok, err := disk.DeleteExpired(late, keys[0]); !ok || err != nil {
+		t.Errorf("DeleteExpired = %v, %v, want true", ok, err)
+	}
+}
+
+func testSnapshotIgnoresLaterWrites(t *testing.T, name string) {
+	disk := openTestDiskStore(t, name)
+	write(t, disk)
+	want, wantN := digest(t, disk, 1_000_000)
+
+	snap, err := disk.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Release()
+	ctx := context.Background()
+	if err := disk.Put(ctx, []byte("later"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := disk.Delete(ctx, []byte("str")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := snap.Persist(buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []Store{NewMemoryStore(), openTestDiskStore(t, name)} {
+		if err := s.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+		got, gotN := digest(t, s, 1_000_000)
+		if !bytes.Equal(got, want) || gotN != wantN {
+			t.Errorf("%T restored %x (%d keys), want %x (%d keys)", s, got, gotN, want, wantN)
+		}
+	}
+}
+
+// testKeyring returns a Keyring of a new AES-256 key.
+func testKeyring(t *testing.T) *encryption.Keyring {
+	t.Helper()
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte("k1 "+base64.StdEncoding.EncodeToString(raw)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	k, err := encryption.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func testEncryptsValues(t *testing.T, name string) {
+	dir := t.TempDir()
+	s, err := Open(name, dir, Options{Cipher: testKeyring(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	disk := s.(*diskStore)
+	mem := NewMemoryStore()
+	ctx := WithTime(context.Background(), time.UnixMilli(1_000_000))
+	for _, st := range []Store{mem, disk} {
+		write(t, st)
+		if err := st.Put(ctx, []byte("secret"), []byte("plaintext-secret")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The values are read back, dumped and snapshotted decrypted.
+	want, wantN := digest(t, mem, 1_000_000)
+	if got, gotN := digest(t, disk, 1_000_000); !bytes.Equal(got, want) || gotN != wantN {
+		t.Errorf("digest = %x (%d keys), want %x (%d keys)", got, gotN, want, wantN)
+	}
+	wantDump, _ := mem.Dump(ctx, []byte("secret"))
+	if got, err := disk.Dump(ctx, []byte("secret")); err != nil || !bytes.Equal(got, wantDump) {
+		t.Errorf("Dump = %x, %v, want %x", got, err, wantDump)
+	}
+	snap, err := disk.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := snap.Persist(buf); err != nil {
+		t.Fatal(err)
+	}
+	snap.Release()
+	restored := NewMemoryStore()
+	if err := restored.Restore(buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, gotN := digest(t, restored, 1_000_000); !bytes.Equal(got, want) || gotN != wantN {
+		t.Errorf("restored %x (%d keys), want %x (%d keys)", got, gotN, want, wantN)
+	}
+
+	// A value is bound to its key, and can't be moved to another.
+	sealed, err := disk.kv.Get([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := disk.kv.Write(map[string][]byte{"str": sealed}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := disk.Get(ctx, []byte("str")); !errors.Is(err, encryption.ErrCorrupt) {
+		t.Errorf("Get of a value moved from another key = %v, want ErrCorrupt", err)
+	}
+
+	if err := disk.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err == nil && bytes.Contains(data, []byte("plaintext-secret")) {
+			t.Errorf("%s holds the value in plaintext", path)
+		}
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testKeepsCheckpoint(t *testing.T, name string) {
+	dir := t.TempDir()
+	reopen := func(s Store) *diskStore {
+		t.Helper()
+		if s != nil {
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s, err := Open(name, dir, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s.(*diskStore)
+	}
+	mem := NewMemoryStore()
+	write(t, mem)
+	want, wantN := digest(t, mem, 1_000_000)
+
+	s := reopen(nil)
+	write(t, s)
+	if err := s.Checkpoint(7); err != nil {
+		t.Fatal(err)
+	}
+	s = reopen(s)
+	if kept, err := s.Resume(7); err != nil || !kept {
+		t.Fatalf("Resume(7) after Checkpoint(7) = %v, %v, want true", kept, err)
+	}
+	if got, gotN := digest(t, s, 1_000_000); !bytes.Equal(got, want) || gotN != wantN {
+		t.Errorf("kept %x (%d keys), want %x (%d keys)", got, gotN, want, wantN)
+	}
+
+	// The checkpoint is used once: closed without another one, the keys are
+	// not trusted.
+	s = reopen(s)
+	if kept, err := s.Resume(7); err != nil || kept {
+		t.Errorf("Resume(7) without a new Checkpoint = %v, %v, want false", kept, err)
+	}
+	if _, n := digest(t, s, 1_000_000); n != 0 {
+		t.Errorf("%d keys left after a reopen without Checkpoint, want 0", n)
+	}
+
+	// Keys of another index are dropped.
+	write(t, s)
+	if err := s.Checkpoint(7); err != nil {
+		t.Fatal(err)
+	}
+	s = reopen(s)
+	if kept, err := s.Resume(8); err != nil || kept {
+		t.Errorf("Resume(8) after Checkpoint(7) = %v, %v, want false", kept, err)
+	}
+	if _, n := digest(t, s, 1_000_000); n != 0 {
+		t.Errorf("%d keys left after Resume of another index, want 0", n)
+	}
+}
@@ -0,0 +1,388 @@
+package store
+
+import (
+	"context"
+	"errors"
+)
+
+// 型ごとの操作は、値全体をディスクから読み出して行い、書き換えた値を書き戻す
+// 要素がなくなった値は、メモリストアと同じくキーごと削除する
+
+func (s *diskStore) HGet(ctx context.Context, key []byte, field []byte) ([]byte, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.load(ctx, key, KindHash)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := e.hash[string(field)]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return v, nil
+}
+
+func (s *diskStore) HSet(ctx context.Context, key []byte, fields map[string][]byte) (int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	e, err := s.load(ctx, key, KindHash)
+	if errors.Is(err, ErrKeyNotFound) {
+		e = &entry{kind: KindHash}
+	} else if err != nil {
+		return 0, err
+	}
+	if e.hash == nil {
+		e.hash = make(map[string][]byte, len(fields))
+	}
+
+	added := 0
+	for f, v := range fields {
+		if _, ok := e.hash[f]; !ok {
+			added++
+		}
+		e.hash[f] = v
+	}
+	return added, s.put(string(key), e)
+}
+
+func (s *diskStore) HDel(ctx context.Context, key []byte, fields [][]byte) (int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	e, err := s.load(ctx, key, KindHash)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	deleted := 0
+	for _, f := range fields {
+		if _, ok := e.hash[string(f)]; ok {
+			delete(e.hash, string(f))
+			deleted++
+		}
+	}
+	return deleted, s.rewrite(key, e, deleted, len(e.hash))
+}
+
+func (s *diskStore) HGetAll(ctx context.Context, key []byte) (map[string][]byte, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.load(ctx, key, KindHash)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return map[string][]byte{}, nil
+		}
+		return nil, err
+	}
+	return e.hash, nil
+}
+
+func (s *diskStore) HLen(ctx context.Context, key []byte) (int, error) {
+	if err := s.rlock(ctx); err != nil {
+		return 0, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.load(ctx, key, KindHash)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return len(e.hash), nil
+}
+
+// rewrite は、要素を removed 個取り除いた値を書き戻す。残りの要素が left 個もなければキーを削除する
+// 何も取り除いていなければ書き込まない
+// 呼び出し側で書き込みロックを取得していること
+func (s *diskStore) rewrite(key []byte, e *entry, removed, left int) error {
+	switch {
+	case removed == 0:
+		return nil
+	case left == 0:
+		return s.remove(string(key))
+	}
+	return s.put(string(key), e)
+}
+
+func (s *diskStore) SAdd(ctx context.Context, key []byte, members [][]byte) (int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	e, err := s.load(ctx, key, KindSet)
+	if errors.Is(err, ErrKeyNotFound) {
+		e = &entry{kind: KindSet, set: make(map[string]struct{}, len(members))}
+	} else if err != nil {
+		return 0, err
+	}
+
+	added := 0
+	for _, m := range members {
+		if _, ok := e.set[string(m)]; !ok {
+			e.set[string(m)] = struct{}{}
+			added++
+		}
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	return added, s.put(string(key), e)
+}
+
+func (s *diskStore) SRem(ctx context.Context, key []byte, members [][]byte) (int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	e, err := s.load(ctx, key, KindSet)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	removed := 0
+	for _, m := range members {
+		if _, ok := e.set[string(m)]; ok {
+			delete(e.set, string(m))
+			removed++
+		}
+	}
+	return removed, s.rewrite(key, e, removed, len(e.set))
+}
+
+func (s *diskStore) SMembers(ctx context.Context, key []byte) ([][]byte, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.load(ctx, key, KindSet)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return [][]byte{}, nil
+		}
+		return nil, err
+	}
+	members := make([][]byte, 0, len(e.set))
+	for m := range e.set {
+		members = append(members, []byte(m))
+	}
+	return members, nil
+}
+
+func (s *diskStore) SIsMember(ctx context.Context, key []byte, member []byte) (bool, error) {
+	if err := s.rlock(ctx); err != nil {
+		return false, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.load(ctx, key, KindSet)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	_, ok := e.set[string(member)]
+	return ok, nil
+}
+
+func (s *diskStore) SCard(ctx context.Context, key []byte) (int, error) {
+	if err := s.rlock(ctx); err != nil {
+		return 0, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.load(ctx, key, KindSet)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return len(e.set), nil
+}
+
+func (s *diskStore) ZAdd(ctx context.Context, key []byte, members []ZMember) (int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	e, err := s.load(ctx, key, KindZSet)
+	if errors.Is(err, ErrKeyNotFound) {
+		e = &entry{kind: KindZSet, zset: newZSet()}
+	} else if err != nil {
+		return 0, err
+	}
+
+	added := 0
+	for _, m := range members {
+		if e.zset.add(string(m.Member), m.Score) {
+			added++
+		}
+	}
+	return added, s.put(string(key), e)
+}
+
+func (s *diskStore) ZRem(ctx context.Context, key []byte, members [][]byte) (int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	e, err := s.load(ctx, key, KindZSet)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	removed := 0
+	for _, m := range members {
+		if e.zset.remove(string(m)) {
+			removed++
+		}
+	}
+	return removed, s.rewrite(key, e, removed, len(e.zset.scores))
+}
+
+func (s *diskStore) ZScore(ctx context.Context, key []byte, member []byte) (float64, error) {
+	if err := s.rlock(ctx); err != nil {
+		return 0, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.load(ctx, key, KindZSet)
+	if err != nil {
+		return 0, err
+	}
+	score, ok := e.zset.scores[string(member)]
+	if !ok {
+		return 0, ErrKeyNotFound
+	}
+	return score, nil
+}
+
+func (s *diskStore) ZCard(ctx context.Context, key []byte) (int, error) {
+	if err := s.rlock(ctx); err != nil {
+		return 0, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.load(ctx, key, KindZSet)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return len(e.zset.scores), nil
+}
+
+func (s *diskStore) ZRange(ctx context.Context, key []byte, start, stop int) ([]ZMember, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.load(ctx, key, KindZSet)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return []ZMember{}, nil
+		}
+		return nil, err
+	}
+	return e.zset.rangeByRank(start, stop), nil
+}
+
+func (s *diskStore) ZRangeByScore(ctx context.Context, key []byte, lo, hi ScoreBound, offset, count int) ([]ZMember, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.load(ctx, key, KindZSet)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return []ZMember{}, nil
+		}
+		return nil, err
+	}
+	return e.zset.rangeByScore(lo, hi, offset, count), nil
+}
+
+func (s *diskStore) XAdd(ctx context.Context, key []byte, id StreamID, fields [][]byte) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	e, err := s.load(ctx, key, KindStream)
+	if errors.Is(err, ErrKeyNotFound) {
+		e = &entry{kind: KindStream, stream: &stream{}}
+	} else if err != nil {
+		return err
+	}
+	if id.Compare(e.stream.lastID) <= 0 {
+		return ErrStreamID
+	}
+	e.stream.entries = append(e.stream.entries, StreamEntry{ID: id, Fields: fields})
+	e.stream.lastID = id
+	return s.put(string(key), e)
+}
+
+func (s *diskStore) XLastID(ctx context.Context, key []byte) (StreamID, error) {
+	if err := s.rlock(ctx); err != nil {
+		return StreamID{}, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.load(ctx, key, KindStream)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return StreamID{}, nil
+		}
+		return StreamID{}, err
+	}
+	return e.stream.lastID, nil
+}
+
+func (s *diskStore) XLen(ctx context.Context, key []byte) (int, error) {
+	if err := s.rlock(ctx); err != nil {
+		return 0, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.load(ctx, key, KindStream)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return len(e.stream.entries), nil
+}
+
+func (s *diskStore) XRange(ctx context.Context, key []byte, start, end StreamID, count int) ([]StreamEntry, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.load(ctx, key, KindStream)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return []StreamEntry{}, nil
+		}
+		return nil, err
+	}
+	return e.stream.rangeByID(start, end, count), nil
+}
@@ -0,0 +1,197 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// EvictionPolicy は、maxmemory を超えたときに追い出すキーの選び方
+type EvictionPolicy int32
+
+const (
+	// NoEviction キーを追い出さず、メモリを増やす書き込みを拒否する
+	NoEviction EvictionPolicy = iota
+	// AllKeysLRU 最後に使われてから最も時間が経ったキーを追い出す
+	AllKeysLRU
+	// AllKeysLFU 使われる頻度が最も低いキーを追い出す
+	AllKeysLFU
+	// VolatileTTL 有効期限が最も近いキーを追い出す
+	VolatileTTL
+)
+
+var evictionPolicies = []string{"noeviction", "allkeys-lru", "allkeys-lfu", "volatile-ttl"}
+
+var ErrEvictionPolicy = errors.New("argument(s) must be one of the following: " + strings.Join(evictionPolicies, ", "))
+
+// ParseEvictionPolicy は、maxmemory-policy の値を解釈する
+func ParseEvictionPolicy(s string) (EvictionPolicy, error) {
+	for i, name := range evictionPolicies {
+		if strings.EqualFold(s, name) {
+			return EvictionPolicy(i), nil
+		}
+	}
+	return 0, ErrEvictionPolicy
+}
+
+func (p EvictionPolicy) String() string {
+	return evictionPolicies[p]
+}
+
+// メモリ使用量の見積もりに使う、値の長さ以外に掛かるバイト数
+const (
+	// entryOverhead キー1つあたり (map のエントリ、entry と索引のノード)
+	entryOverhead = 96
+	// elementOverhead ハッシュ、セット、ソート済みセットの要素1つあたり
+	elementOverhead = 48
+	// streamEntryOverhead ストリームのエントリ1つあたり
+	streamEntryOverhead = 40
+)
+
+func fieldSize(field string, value []byte) int64 {
+	return elementOverhead + int64(len(field)+len(value))
+}
+
+func memberSize(member string) int64 {
+	return elementOverhead + int64(len(member))
+}
+
+func streamEntrySize(se StreamEntry) int64 {
+	n := int64(streamEntryOverhead)
+	for _, f := range se.Fields {
+		n += int64(len(f)) + 24
+	}
+	return n
+}
+
+// entrySize は、キーとエントリが占めるおおよそのバイト数を返す
+func entrySize(key string, e *entry) int64 {
+	n := entryOverhead + int64(len(key)+len(e.value))
+	for f, v := range e.hash {
+		n += fieldSize(f, v)
+	}
+	for m := range e.set {
+		n += memberSize(m)
+	}
+	if e.zset != nil {
+		for m := range e.zset.scores {
+			n += memberSize(m)
+		}
+	}
+	if e.stream != nil {
+		for _, se := range e.stream.entries {
+			n += streamEntrySize(se)
+		}
+	}
+	return n
+}
+
+// grow は、エントリの値の変更で増えたバイト数を使用量に加える
+// 呼び出し側で書き込みロックを取得していること
+func (s *memoryStore) grow(e *entry, n int64) {
+	e.size += n
+	s.used += n
+	if e.usage != nil {
+		e.usage.Bytes += n
+	}
+}
+
+// UsedMemory は、キーと値が占めるおおよそのバイト数を返す
+func (s *memoryStore) UsedMemory() int64 {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.used
+}
+
+// MemoryUsage は、キーとその値が占めるおおよそのバイト数を返す
+// LRU と LFU のための記録は更新しない
+func (s *memoryStore) MemoryUsage(ctx context.Context, key []byte) (int64, error) {
+	if err := s.rlock(ctx); err != nil {
+		return 0, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, ok := s.m[string(key)]
+	if !ok || e.expired(Now(ctx).UnixMilli()) {
+		return 0, ErrKeyNotFound
+	}
+	return e.size, nil
+}
+
+// LFU のカウンタは Redis と同じく、8 ビットで対数的に増え、1 分ごとに 1 ずつ減る
+const (
+	lfuInitVal   = 5
+	lfuLogFactor = 10
+	lfuDecayTime = time.Minute
+)
+
+// access は、キーが使われたことを LRU と LFU のために記録する
+// 記録はノードごとのもので、複製されない
+func (e *entry) access() {
+	now := time.Now().UnixMilli()
+	c := e.frequency(now)
+	if c < 255 {
+		base := float64(c) - lfuInitVal
+		if base < 0 {
+			base = 0
+		}
+		if rand.Float64() < 1/(base*lfuLogFactor+1) {
+			c++
+		}
+	}
+	e.freq.Store(uint32(c))
+	e.accessed.Store(now)
+}
+
+// frequency は、最後に使われてからの時間で減らした LFU のカウンタを返す
+func (e *entry) frequency(now int64) uint8 {
+	c := int64(e.freq.Load())
+	c -= (now - e.accessed.Load()) / lfuDecayTime.Milliseconds()
+	return uint8(max(c, 0))
+}
+
+// EvictionCandidate は、policy に従って追い出すキーを、samples 個のキーの標本から選ぶ
+// 候補がない場合は ErrKeyNotFound を返す
+func (s *memoryStore) EvictionCandidate(policy EvictionPolicy, samples int) ([]byte, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return evictionCandidate(s.m, policy, samples)
+}
+
+// evictionCandidate は、policy に従って追い出すキーを、m のキー samples 個の標本から選ぶ
+// 呼び出し側でロックを取得していること
+func evictionCandidate(m map[string]*entry, policy EvictionPolicy, samples int) ([]byte, error) {
+	now := time.Now().UnixMilli()
+	var best string
+	var bestScore int64
+	found := false
+	for k, e := range m {
+		if samples <= 0 {
+			break
+		}
+		var score int64
+		switch policy {
+		case AllKeysLRU:
+			score = e.accessed.Load()
+		case AllKeysLFU:
+			score = int64(e.frequency(now))<<48 | e.accessed.Load()&(1<<48-1)
+		case VolatileTTL:
+			if e.expireAt == 0 {
+				continue
+			}
+			score = e.expireAt
+		default:
+			return nil, ErrKeyNotFound
+		}
+		samples--
+		if !found || score < bestScore {
+			best, bestScore, found = k, score, true
+		}
+	}
+	if !found {
+		return nil, ErrKeyNotFound
+	}
+	return []byte(best), nil
+}
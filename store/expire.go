@@ -0,0 +1,106 @@
+package store
+
+import (
+	"context"
+	"sync"
+)
+
+// expireScanFactor は、ExpiredKeys が有効期限付きのキーを探すために、標本の数の何倍までキーを調べるか
+// 有効期限付きのキーが少ないときに、全てのキーを調べ続けないようにする
+const expireScanFactor = 20
+
+// maxLazyExpired は、読み込みで期限切れと分かり、削除を待つキーを覚えておく最大の数
+const maxLazyExpired = 1024
+
+// lazyExpired は、読み込みで期限切れと分かり、ExpiredKeys が返すのを待つキー
+// 読み込みロックで記録するため、自身のロックで保護する
+type lazyExpired struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+// note は、読み込みで期限切れと分かったキーを、ExpiredKeys が返すまで覚えておく
+func (l *lazyExpired) note(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.keys == nil {
+		l.keys = map[string]struct{}{}
+	}
+	if len(l.keys) < maxLazyExpired {
+		l.keys[key] = struct{}{}
+	}
+}
+
+// take は、覚えておいたキーを返して忘れる
+func (l *lazyExpired) take() map[string]struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	keys := l.keys
+	l.keys = nil
+	return keys
+}
+
+// ExpiredKeys は、読み込みで期限切れと分かったキーと、有効期限付きのキーを最大 samples 個調べて見つけた期限切れのキーを返す
+// 調べたキーの数も返す。見つけたキーは DeleteExpired で削除されるまで残る
+func (s *memoryStore) ExpiredKeys(ctx context.Context, samples int) ([][]byte, int, error) {
+	lazy := s.lazy.take()
+
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	keys, sampled := expiredKeys(s.m, lazy, Now(ctx).UnixMilli(), samples)
+	return keys, sampled, nil
+}
+
+// expiredKeys は、lazy のうち期限切れのキーと、m の有効期限付きのキーを最大 samples 個調べて見つけた期限切れのキーを返す
+// 呼び出し側でロックを取得していること
+func expiredKeys(m map[string]*entry, lazy map[string]struct{}, now int64, samples int) ([][]byte, int) {
+	var keys [][]byte
+	for k := range lazy {
+		if e, ok := m[k]; ok && e.expired(now) {
+			keys = append(keys, []byte(k))
+		}
+	}
+
+	// map の走査は毎回異なる位置から始まるため、先頭から調べれば無作為な標本になる
+	sampled := len(lazy)
+	budget := samples * expireScanFactor
+	for k, e := range m {
+		if samples <= 0 || budget <= 0 {
+			break
+		}
+		budget--
+		if e.expireAt == 0 {
+			continue
+		}
+		samples--
+		sampled++
+		if _, ok := lazy[k]; !ok && e.expired(now) {
+			keys = append(keys, []byte(k))
+		}
+	}
+	return keys, sampled
+}
+
+// DeleteExpired は、キーの有効期限が過ぎている場合に削除し、削除したかを返す
+// 期限の判定には ctx の時刻を使うため、FSM では全てのレプリカで同じ結果になる
+func (s *memoryStore) DeleteExpired(ctx context.Context, key []byte) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	e, ok := s.m[string(key)]
+	if !ok || !e.expired(Now(ctx).UnixMilli()) {
+		return false, nil
+	}
+	s.remove(string(key))
+	return true, nil
+}
+
+// ExpiredKeys 期限切れのキーの削除はシャードごとに複製するため、ErrShardedStore を返す
+func (s *shardedStore) ExpiredKeys(context.Context, int) ([][]byte, int, error) {
+	return nil, 0, ErrShardedStore
+}
+
+func (s *shardedStore) DeleteExpired(ctx context.Context, key []byte) (bool, error) {
+	return s.of(ctx, key).DeleteExpired(ctx, key)
+}
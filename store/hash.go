@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"errors"
+)
+
+// lookupKind は、期限切れと型を考慮してキーのエントリを返す
+// 呼び出し側でロックを取得していること
+func (s *memoryStore) lookupKind(ctx context.Context, key []byte, kind Kind) (*entry, error) {
+	e, ok := s.lookup(ctx, key)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	if e.kind != kind {
+		return nil, ErrWrongType
+	}
+	return e, nil
+}
+
+func (s *memoryStore) HGet(ctx context.Context, key []byte, field []byte) ([]byte, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.lookupKind(ctx, key, KindHash)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := e.hash[string(field)]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return v, nil
+}
+
+func (s *memoryStore) HSet(ctx context.Context, key []byte, fields map[string][]byte) (int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	e, err := s.lookupKind(ctx, key, KindHash)
+	if errors.Is(err, ErrKeyNotFound) {
+		e = &entry{kind: KindHash, hash: make(map[string][]byte, len(fields))}
+		s.set(string(key), e)
+	} else if err != nil {
+		return 0, err
+	}
+	e = s.mutable(key, e)
+
+	added := 0
+	for f, v := range fields {
+		if old, ok := e.hash[f]; ok {
+			s.grow(e, int64(len(v)-len(old)))
+		} else {
+			s.grow(e, fieldSize(f, v))
+			added++
+		}
+		e.hash[f] = v
+	}
+	return added, nil
+}
+
+func (s *memoryStore) HDel(ctx context.Context, key []byte, fields [][]byte) (int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	e, err := s.lookupKind(ctx, key, KindHash)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	e = s.mutable(key, e)
+
+	deleted := 0
+	for _, f := range fields {
+		if v, ok := e.hash[string(f)]; ok {
+			s.grow(e, -fieldSize(string(f), v))
+			delete(e.hash, string(f))
+			deleted++
+		}
+	}
+	if len(e.hash) == 0 {
+		s.remove(string(key))
+	}
+	return deleted, nil
+}
+
+func (s *memoryStore) HGetAll(ctx context.Context, key []byte) (map[string][]byte, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.lookupKind(ctx, key, KindHash)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return map[string][]byte{}, nil
+		}
+		return nil, err
+	}
+
+	m := make(map[string][]byte, len(e.hash))
+	for f, v := range e.hash {
+		m[f] = v
+	}
+	return m, nil
+}
+
+func (s *memoryStore) HLen(ctx context.Context, key []byte) (int, error) {
+	if err := s.rlock(ctx); err != nil {
+		return 0, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.lookupKind(ctx, key, KindHash)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return len(e.hash), nil
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// lazyfreeThreshold は、削除したエントリの要素をバックグラウンドで解放する要素数の下限
+// Redis の LAZYFREE_THRESHOLD と同じ値
+const lazyfreeThreshold = 64
+
+// lazyfreeQueue は、解放を待つエントリの数の上限。溢れたエントリはその場で手放し、GC に任せる
+const lazyfreeQueue = 1024
+
+var (
+	lazyfreeOnce    sync.Once
+	lazyfreeCh      chan *entry
+	lazyfreePending atomic.Int64
+	lazyfreed       atomic.Int64
+)
+
+// elements は、エントリの要素の数を返す。文字列は 1 とする
+func (e *entry) elements() int {
+	switch e.kind {
+	case KindHash:
+		return len(e.hash)
+	case KindSet:
+		return len(e.set)
+	case KindZSet:
+		return len(e.zset.scores)
+	case KindStream:
+		return len(e.stream.entries)
+	}
+	return 1
+}
+
+// release は、エントリの要素を手放す
+func (e *entry) release() {
+	clear(e.hash)
+	clear(e.set)
+	if e.zset != nil {
+		clear(e.zset.scores)
+		e.zset.order = nil
+	}
+	e.hash, e.set, e.zset, e.stream = nil, nil, nil, nil
+}
+
+// lazyFree は、削除したエントリが大きければ、その要素の解放をバックグラウンドのワーカーに任せる
+// 削除はキーを索引から外すだけで済むため、要素の数によらず FSM の適用にかかる時間は変わらない
+// 書き出し中のスナップショットが参照しているエントリは、スナップショットのために残す
+// 呼び出し側で書き込みロックを取得していること
+func (s *memoryStore) lazyFree(e *entry) {
+	if s.snapshots > 0 && e.gen != s.gen || e.elements() <= lazyfreeThreshold {
+		return
+	}
+	lazyfreeOnce.Do(func() {
+		lazyfreeCh = make(chan *entry, lazyfreeQueue)
+		go func() {
+			for e := range lazyfreeCh {
+				e.release()
+				lazyfreePending.Add(-1)
+				lazyfreed.Add(1)
+			}
+		}()
+	})
+	lazyfreePending.Add(1)
+	select {
+	case lazyfreeCh <- e:
+	default:
+		lazyfreePending.Add(-1)
+	}
+}
+
+// LazyfreeStats は、バックグラウンドでの解放を待つエントリの数と、解放したエントリの数を返す
+func LazyfreeStats() (pending, freed int64) {
+	return lazyfreePending.Load(), lazyfreed.Load()
+}
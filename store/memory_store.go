@@ -0,0 +1,675 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/gob"
+	"hash/fnv"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// entry は、メモリストアに保持される1キー分のデータ
+type entry struct {
+	kind   Kind
+	value  []byte
+	hash   map[string][]byte
+	set    map[string]struct{}
+	zset   *zset
+	stream *stream
+	// expireAt 有効期限 (Unix ミリ秒)。0 の場合は期限なし
+	expireAt int64
+	// size キーと値が占めるおおよそのバイト数
+	size int64
+	// accessed, freq 最後に使われた時刻 (Unix ミリ秒) と LFU のカウンタ
+	accessed atomic.Int64
+	freq     atomic.Uint32
+	// gen エントリを保存したときのストアの世代
+	gen uint64
+	// usage キーを数える接頭辞の使用量。どの接頭辞にも一致しない場合は nil
+	usage *Usage
+}
+
+// clone は、値を共有しないエントリの複製を返す
+// 文字列やハッシュの値、ストリームのエントリは書き換えずに置き換えるため、共有したままにする
+func (e *entry) clone() *entry {
+	c := &entry{kind: e.kind, value: e.value, expireAt: e.expireAt, size: e.size, usage: e.usage}
+	c.accessed.Store(e.accessed.Load())
+	c.freq.Store(e.freq.Load())
+	switch e.kind {
+	case KindHash:
+		c.hash = maps.Clone(e.hash)
+	case KindSet:
+		c.set = maps.Clone(e.set)
+	case KindZSet:
+		c.zset = newZSet()
+		for m, score := range e.zset.scores {
+			c.zset.add(m, score)
+		}
+	case KindStream:
+		c.stream = &stream{entries: slices.Clip(e.stream.entries), lastID: e.stream.lastID}
+	}
+	return c
+}
+
+func (e *entry) expired(now int64) bool {
+	return e.expireAt != 0 && e.expireAt <= now
+}
+
+// indexKey は、Scan の走査順を決めるキー
+// カーソルをノード間で共通の値にするため、キーのハッシュ値の順に並べる
+type indexKey struct {
+	hash uint64
+	key  string
+}
+
+func newIndexKey(key string) indexKey {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return indexKey{hash: h.Sum64(), key: key}
+}
+
+func compareIndexKey(a, b indexKey) int {
+	if c := cmp.Compare(a.hash, b.hash); c != 0 {
+		return c
+	}
+	return strings.Compare(a.key, b.key)
+}
+
+type memoryStore struct {
+	mtx   sync.RWMutex
+	m     map[string]*entry
+	index *skiplist[indexKey]
+	// used 全てのエントリの size の合計
+	used int64
+	// gen スナップショットを作るたびに進める世代
+	// snapshots 書き出し中のスナップショットの数
+	gen       uint64
+	snapshots int
+	// lazy 読み込みで期限切れと分かり、ExpiredKeys が返すのを待つキー
+	lazy lazyExpired
+	// usage キーの接頭辞ごとの使用量
+	usage prefixUsage
+}
+
+var _ Store = (*memoryStore)(nil)
+
+// NewMemoryStore は、有効期限付きのキーを扱えるインメモリのストアを返す
+func NewMemoryStore() Store {
+	return &memoryStore{
+		m:     map[string]*entry{},
+		index: newSkiplist(compareIndexKey),
+	}
+}
+
+// ctxCheckKeys は、全てのキーを走査する読み込みが ctx の終わりを確かめる間隔のキーの数
+const ctxCheckKeys = 1024
+
+// rlock は、読み込みロックを取得する
+// 書き込みがロックを持っている間に ctx が終わった場合は、取得を諦めて ContextErr のエラーを返す
+// 諦めたロックは、取得でき次第解放する
+func (s *memoryStore) rlock(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return ContextErr(ctx)
+	}
+	if s.mtx.TryRLock() {
+		return nil
+	}
+	if ctx.Done() == nil {
+		s.mtx.RLock()
+		return nil
+	}
+	locked := make(chan struct{})
+	go func() {
+		s.mtx.RLock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		go func() {
+			<-locked
+			s.mtx.RUnlock()
+		}()
+		return ContextErr(ctx)
+	}
+}
+
+// set は、キーのエントリを保存し索引に追加する
+// 呼び出し側で書き込みロックを取得していること
+func (s *memoryStore) set(key string, e *entry) {
+	if old, ok := s.m[key]; ok {
+		s.used -= old.size
+		old.account(-1)
+	} else {
+		s.index.Insert(newIndexKey(key))
+	}
+	if e.accessed.Load() == 0 {
+		e.freq.Store(lfuInitVal)
+		e.accessed.Store(time.Now().UnixMilli())
+	}
+	e.size = entrySize(key, e)
+	e.gen = s.gen
+	e.usage = s.usage.of(key)
+	e.account(1)
+	s.used += e.size
+	s.m[key] = e
+}
+
+// mutable は、エントリをその場で書き換える前に呼び出し、書き換えてよいエントリを返す
+// 書き出し中のスナップショットが参照しているエントリは、複製して置き換える
+// 呼び出し側で書き込みロックを取得していること
+func (s *memoryStore) mutable(key []byte, e *entry) *entry {
+	if s.snapshots == 0 || e.gen == s.gen {
+		return e
+	}
+	c := e.clone()
+	c.gen = s.gen
+	s.m[string(key)] = c
+	return c
+}
+
+// remove は、キーのエントリを削除し索引から取り除く
+// 呼び出し側で書き込みロックを取得していること
+func (s *memoryStore) remove(key string) {
+	if e := s.drop(key); e != nil {
+		s.lazyFree(e)
+	}
+}
+
+// drop は、キーのエントリを索引から取り除いて返す。エントリは解放しない
+// 呼び出し側で書き込みロックを取得していること
+func (s *memoryStore) drop(key string) *entry {
+	e, ok := s.m[key]
+	if !ok {
+		return nil
+	}
+	s.used -= e.size
+	e.account(-1)
+	delete(s.m, key)
+	s.index.Delete(newIndexKey(key))
+	return e
+}
+
+// lookup は、期限切れを考慮してキーのエントリを返し、キーが使われたことを記録する
+// 呼び出し側でロックを取得していること
+func (s *memoryStore) lookup(ctx context.Context, key []byte) (*entry, bool) {
+	e, ok := s.m[string(key)]
+	if !ok {
+		return nil, false
+	}
+	if e.expired(Now(ctx).UnixMilli()) {
+		s.lazy.note(string(key))
+		return nil, false
+	}
+	e.access()
+	return e, true
+}
+
+func (s *memoryStore) Get(ctx context.Context, key []byte) ([]byte, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, ok := s.lookup(ctx, key)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	if e.kind != KindString {
+		return nil, ErrWrongType
+	}
+	return e.value, nil
+}
+
+func (s *memoryStore) Put(_ context.Context, key []byte, value []byte) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.set(string(key), &entry{kind: KindString, value: value})
+	return nil
+}
+
+func (s *memoryStore) Delete(_ context.Context, key []byte) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.remove(string(key))
+	return nil
+}
+
+func (s *memoryStore) Exists(ctx context.Context, key []byte) (bool, error) {
+	if err := s.rlock(ctx); err != nil {
+		return false, err
+	}
+	defer s.mtx.RUnlock()
+
+	_, ok := s.lookup(ctx, key)
+	return ok, nil
+}
+
+func (s *memoryStore) Type(ctx context.Context, key []byte) (Kind, error) {
+	if err := s.rlock(ctx); err != nil {
+		return KindNone, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, ok := s.lookup(ctx, key)
+	if !ok {
+		return KindNone, nil
+	}
+	return e.kind, nil
+}
+
+func (s *memoryStore) Expire(ctx context.Context, key []byte, at time.Time) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	e, ok := s.lookup(ctx, key)
+	if !ok {
+		return ErrKeyNotFound
+	}
+	e = s.mutable(key, e)
+	e.expireAt = at.UnixMilli()
+	return nil
+}
+
+func (s *memoryStore) Persist(ctx context.Context, key []byte) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	e, ok := s.lookup(ctx, key)
+	if !ok {
+		return ErrKeyNotFound
+	}
+	e = s.mutable(key, e)
+	e.expireAt = 0
+	return nil
+}
+
+func (s *memoryStore) TTL(ctx context.Context, key []byte) (time.Time, error) {
+	if err := s.rlock(ctx); err != nil {
+		return time.Time{}, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, ok := s.lookup(ctx, key)
+	if !ok {
+		return time.Time{}, ErrKeyNotFound
+	}
+	if e.expireAt == 0 {
+		return time.Time{}, nil
+	}
+	return time.UnixMilli(e.expireAt), nil
+}
+
+func (s *memoryStore) Scan(ctx context.Context, cursor uint64, count int) ([][]byte, uint64, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, 0, err
+	}
+	defer s.mtx.RUnlock()
+
+	keys, next := scanIndex(s.index, s.m, Now(ctx).UnixMilli(), cursor, count)
+	return keys, next, nil
+}
+
+// scanIndex は、索引のカーソルの位置から count 件程度のキーを調べ、期限切れでないキーと次のカーソルを返す
+// 呼び出し側でロックを取得していること
+func scanIndex(index *skiplist[indexKey], m map[string]*entry, now int64, cursor uint64, count int) ([][]byte, uint64) {
+	keys := [][]byte{}
+	examined := 0
+	var last uint64
+
+	n := index.Seek(indexKey{hash: cursor})
+	for ; n != nil; n = n.Next() {
+		// 同じハッシュ値のキーは次のカーソルで区別できないため、まとめて返す
+		if examined >= count && n.item.hash != last {
+			break
+		}
+		examined++
+		last = n.item.hash
+
+		if m[n.item.key].expired(now) {
+			continue
+		}
+		keys = append(keys, []byte(n.item.key))
+	}
+
+	if n == nil {
+		return keys, 0
+	}
+	return keys, n.item.hash
+}
+
+func (s *memoryStore) Len(ctx context.Context) (int, error) {
+	if err := s.rlock(ctx); err != nil {
+		return 0, err
+	}
+	defer s.mtx.RUnlock()
+
+	return countKeys(ctx, s.m, func(string) bool { return true })
+}
+
+// countKeys は、m の期限切れでないキーのうち match に一致するものを数える
+// 呼び出し側でロックを取得していること
+func countKeys(ctx context.Context, m map[string]*entry, match func(key string) bool) (int, error) {
+	now := Now(ctx).UnixMilli()
+	n, i := 0, 0
+	for k, e := range m {
+		if i++; i%ctxCheckKeys == 0 && ctx.Err() != nil {
+			return 0, ContextErr(ctx)
+		}
+		if !e.expired(now) && match(k) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *memoryStore) Flush(_ context.Context) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.m = map[string]*entry{}
+	s.index = newSkiplist(compareIndexKey)
+	s.used = 0
+	s.usage.reset()
+	return nil
+}
+
+func (s *memoryStore) Txn(ctx context.Context, f func(ctx context.Context, txn Txn) error) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	txn := &memoryStoreTxn{s: s, m: map[string]*entry{}}
+	err := f(ctx, txn)
+	if err != nil {
+		return err
+	}
+
+	for k, e := range txn.m {
+		if e == nil {
+			s.remove(k)
+			continue
+		}
+		s.set(k, e)
+	}
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+// snapshotEntry は、スナップショットに書き出す1キー分のデータ
+type snapshotEntry struct {
+	Kind     Kind
+	Value    []byte
+	Hash     map[string][]byte
+	Set      []string
+	ZSet     []ZMember
+	Stream   []StreamEntry
+	LastID   StreamID
+	ExpireAt int64
+}
+
+// snapshot は、エントリをスナップショットの形式に変換する
+// ハッシュの値はエントリと共有するため、スナップショットの書き出し中はエントリを mutable で書き換えること
+func (e *entry) snapshot() snapshotEntry {
+	se := snapshotEntry{Kind: e.kind, Value: e.value, Hash: e.hash, ExpireAt: e.expireAt}
+	for m := range e.set {
+		se.Set = append(se.Set, m)
+	}
+	if e.zset != nil {
+		se.ZSet = e.zset.members()
+	}
+	if e.stream != nil {
+		se.Stream, se.LastID = e.stream.entries, e.stream.lastID
+	}
+	return se
+}
+
+// entry は、スナップショットの形式からエントリを組み立てる
+func (e snapshotEntry) entry() *entry {
+	en := &entry{kind: e.Kind, value: e.Value, hash: e.Hash, expireAt: e.ExpireAt}
+	switch e.Kind {
+	case KindSet:
+		en.set = make(map[string]struct{}, len(e.Set))
+		for _, m := range e.Set {
+			en.set[m] = struct{}{}
+		}
+	case KindZSet:
+		en.zset = newZSet()
+		for _, m := range e.ZSet {
+			en.zset.add(string(m.Member), m.Score)
+		}
+	case KindStream:
+		en.stream = &stream{entries: e.Stream, lastID: e.LastID}
+	}
+	return en
+}
+
+// Dump は、キーの値と有効期限をスナップショットと同じ形式でエンコードして返す
+func (s *memoryStore) Dump(ctx context.Context, key []byte) ([]byte, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, ok := s.lookup(ctx, key)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return e.encode()
+}
+
+// RestoreKey は、Dump でエンコードした値をキーに書き込む
+func (s *memoryStore) RestoreKey(_ context.Context, key []byte, data []byte) error {
+	e, err := decodeEntry(data)
+	if err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.set(string(key), e)
+	return nil
+}
+
+// encode は、エントリを Dump の形式でエンコードする
+func (e *entry) encode() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(e.snapshot()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeEntry は、Dump の形式でエンコードしたエントリをデコードする
+func decodeEntry(data []byte) (*entry, error) {
+	var se snapshotEntry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&se); err != nil {
+		return nil, err
+	}
+	return se.entry(), nil
+}
+
+// snapshotMagic は、キーごとにエンコードしたスナップショットの先頭に置く
+// これがないスナップショットは、全てのキーを1つの map としてエンコードしている
+var snapshotMagic = []byte("KVSTREAM")
+
+// snapshotItem は、スナップショットに書き出す1キー
+type snapshotItem struct {
+	Key   string
+	Entry snapshotEntry
+}
+
+// memorySnapshot は、作成時点のキーとエントリの組を保持する
+// エントリは複製せず、書き出し中に書き換えられるエントリは memoryStore.mutable が複製する
+type memorySnapshot struct {
+	s     *memoryStore
+	keys  []string
+	items []*entry
+	once  sync.Once
+}
+
+// Snapshot は、現時点のキーとエントリへの参照を保持したスナップショットを返す
+// 値のエンコードは Persist で行うため、その間も書き込みを止めない
+func (s *memoryStore) Snapshot() (Snapshot, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	snap := &memorySnapshot{s: s, keys: make([]string, 0, len(s.m)), items: make([]*entry, 0, len(s.m))}
+	for k, e := range s.m {
+		snap.keys = append(snap.keys, k)
+		snap.items = append(snap.items, e)
+	}
+	s.gen++
+	s.snapshots++
+	return snap, nil
+}
+
+// Persist は、キーの数に続けてキーを1つずつエンコードする
+func (p *memorySnapshot) Persist(w io.Writer) error {
+	if _, err := w.Write(snapshotMagic); err != nil {
+		return err
+	}
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(len(p.keys)); err != nil {
+		return err
+	}
+	for i, k := range p.keys {
+		if err := enc.Encode(snapshotItem{Key: k, Entry: p.items[i].snapshot()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *memorySnapshot) Release() {
+	p.once.Do(func() {
+		p.s.mtx.Lock()
+		defer p.s.mtx.Unlock()
+		p.s.snapshots--
+	})
+}
+
+// Restore は、スナップショットを1キーずつデコードしてストアを置き換える
+func (s *memoryStore) Restore(buf io.Reader) error {
+	m := map[string]*entry{}
+	index := newSkiplist(compareIndexKey)
+	var used int64
+	now := time.Now().UnixMilli()
+	add := func(k string, se snapshotEntry) error {
+		e := se.entry()
+		e.size = entrySize(k, e)
+		e.freq.Store(lfuInitVal)
+		e.accessed.Store(now)
+		used += e.size
+		m[k] = e
+		index.Insert(newIndexKey(k))
+		return nil
+	}
+
+	if err := readSnapshot(buf, add); err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.m = m
+	s.index = index
+	s.used = used
+	s.usage.reset()
+	for k, e := range m {
+		e.usage = s.usage.of(k)
+		e.account(1)
+	}
+	return nil
+}
+
+// readSnapshot は、Snapshot でエンコードした内容を1キーずつデコードして add に渡す
+func readSnapshot(buf io.Reader, add func(key string, se snapshotEntry) error) error {
+	br := bufio.NewReader(buf)
+	if magic, _ := br.Peek(len(snapshotMagic)); !bytes.Equal(magic, snapshotMagic) {
+		cl := map[string]snapshotEntry{}
+		if err := gob.NewDecoder(br).Decode(&cl); err != nil {
+			return err
+		}
+		for k, se := range cl {
+			if err := add(k, se); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	br.Discard(len(snapshotMagic))
+	dec := gob.NewDecoder(br)
+	var n int
+	if err := dec.Decode(&n); err != nil {
+		return err
+	}
+	for range n {
+		var item snapshotItem
+		if err := dec.Decode(&item); err != nil {
+			return err
+		}
+		if err := add(item.Key, item.Entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// memoryStoreTxn は、トランザクション中の変更を保持する
+// m の値が nil のキーは、トランザクション中に削除されたことを表す
+type memoryStoreTxn struct {
+	s *memoryStore
+	m map[string]*entry
+}
+
+func (t *memoryStoreTxn) lookup(ctx context.Context, key []byte) (*entry, bool) {
+	e, ok := t.m[string(key)]
+	if !ok {
+		return t.s.lookup(ctx, key)
+	}
+	if e == nil || e.expired(Now(ctx).UnixMilli()) {
+		return nil, false
+	}
+	return e, true
+}
+
+func (t *memoryStoreTxn) Get(ctx context.Context, key []byte) ([]byte, error) {
+	e, ok := t.lookup(ctx, key)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	if e.kind != KindString {
+		return nil, ErrWrongType
+	}
+	return e.value, nil
+}
+
+func (t *memoryStoreTxn) Put(_ context.Context, key []byte, value []byte) error {
+	t.m[string(key)] = &entry{kind: KindString, value: value}
+	return nil
+}
+
+func (t *memoryStoreTxn) Delete(_ context.Context, key []byte) error {
+	t.m[string(key)] = nil
+	return nil
+}
+
+func (t *memoryStoreTxn) Exists(ctx context.Context, key []byte) (bool, error) {
+	_, ok := t.lookup(ctx, key)
+	return ok, nil
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// Pebble のキーは、キーの値とキー空間についての記録を前に付けた1バイトで分ける
+// キーの値は pebbleKeyPrefix から pebbleKeyEnd の前までに並ぶ
+// pebbleCheckpointKey には、Checkpoint で記録したインデックスを置く
+var (
+	pebbleKeyPrefix     = []byte("k")
+	pebbleKeyEnd        = []byte("l")
+	pebbleCheckpointKey = []byte("mcheckpoint")
+)
+
+// pebbleKV は、Pebble のディレクトリにキーの値を置く diskKV
+type pebbleKV struct {
+	db *pebble.DB
+	// wo は、書き込みを fsync するかどうか
+	wo *pebble.WriteOptions
+	// closed Pebble は2度閉じると panic するため、BoltDB と同じく2度目は何もしない
+	closed sync.Once
+}
+
+var _ diskKV = (*pebbleKV)(nil)
+
+// openPebbleStore は、dir の Pebble にキーの値を置くストアを開く
+func openPebbleStore(dir string, opts Options) (Store, error) {
+	return openDiskStore(dir, "pebble", opts, openPebbleKV)
+}
+
+// openPebbleKV は、path のディレクトリの Pebble を開く
+func openPebbleKV(path string, fsync bool) (diskKV, error) {
+	db, err := pebble.Open(path, &pebble.Options{})
+	if err != nil {
+		return nil, err
+	}
+	wo := pebble.NoSync
+	if fsync {
+		wo = pebble.Sync
+	}
+	return &pebbleKV{db: db, wo: wo}, nil
+}
+
+// pebbleKey は、ストアのキーの Pebble でのキーを返す
+func pebbleKey(key []byte) []byte {
+	return append(append([]byte(nil), pebbleKeyPrefix...), key...)
+}
+
+// get は、Pebble のキーの値のコピーを返す。なければ nil を返す
+func (p *pebbleKV) get(key []byte) ([]byte, error) {
+	v, closer, err := p.db.Get(key)
+	if errors.Is(err, pebble.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	return append([]byte(nil), v...), nil
+}
+
+func (p *pebbleKV) Get(key []byte) ([]byte, error) {
+	return p.get(pebbleKey(key))
+}
+
+func (p *pebbleKV) Write(batch map[string][]byte) error {
+	b := p.db.NewBatch()
+	defer b.Close()
+	for k, v := range batch {
+		if v == nil {
+			if err := b.Delete(pebbleKey([]byte(k)), nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := b.Set(pebbleKey([]byte(k)), v, nil); err != nil {
+			return err
+		}
+	}
+	return b.Commit(p.wo)
+}
+
+func (p *pebbleKV) Clear() error {
+	return p.db.DeleteRange(pebbleKeyPrefix, pebbleKeyEnd, p.wo)
+}
+
+// View は、スナップショットを作る
+func (p *pebbleKV) View() (diskView, error) {
+	return pebbleView{snap: p.db.NewSnapshot()}, nil
+}
+
+func (p *pebbleKV) Checkpoint() (uint64, error) {
+	v, err := p.get(pebbleCheckpointKey)
+	if err != nil || len(v) != 8 {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(v), nil
+}
+
+func (p *pebbleKV) SetCheckpoint(index uint64) error {
+	if index == 0 {
+		return p.db.Delete(pebbleCheckpointKey, pebble.Sync)
+	}
+	return p.db.Set(pebbleCheckpointKey, binary.BigEndian.AppendUint64(nil, index), pebble.Sync)
+}
+
+func (p *pebbleKV) Close() error {
+	var err error
+	p.closed.Do(func() { err = p.db.Close() })
+	return err
+}
+
+// pebbleView は、スナップショットの diskView
+type pebbleView struct {
+	snap *pebble.Snapshot
+}
+
+func (v pebbleView) ForEach(f func(k, v []byte) error) error {
+	it, err := v.snap.NewIter(&pebble.IterOptions{LowerBound: pebbleKeyPrefix, UpperBound: pebbleKeyEnd})
+	if err != nil {
+		return err
+	}
+	for it.First(); it.Valid(); it.Next() {
+		val, err := it.ValueAndErr()
+		if err != nil {
+			it.Close()
+			return err
+		}
+		if err := f(it.Key()[len(pebbleKeyPrefix):], val); err != nil {
+			it.Close()
+			return err
+		}
+	}
+	return it.Close()
+}
+
+func (v pebbleView) Release() {
+	v.snap.Close()
+}
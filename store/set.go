@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"errors"
+)
+
+func (s *memoryStore) SAdd(ctx context.Context, key []byte, members [][]byte) (int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	e, err := s.lookupKind(ctx, key, KindSet)
+	if errors.Is(err, ErrKeyNotFound) {
+		e = &entry{kind: KindSet, set: make(map[string]struct{}, len(members))}
+		s.set(string(key), e)
+	} else if err != nil {
+		return 0, err
+	}
+	e = s.mutable(key, e)
+
+	added := 0
+	for _, m := range members {
+		if _, ok := e.set[string(m)]; !ok {
+			e.set[string(m)] = struct{}{}
+			s.grow(e, memberSize(string(m)))
+			added++
+		}
+	}
+	return added, nil
+}
+
+func (s *memoryStore) SRem(ctx context.Context, key []byte, members [][]byte) (int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	e, err := s.lookupKind(ctx, key, KindSet)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	e = s.mutable(key, e)
+
+	removed := 0
+	for _, m := range members {
+		if _, ok := e.set[string(m)]; ok {
+			delete(e.set, string(m))
+			s.grow(e, -memberSize(string(m)))
+			removed++
+		}
+	}
+	if len(e.set) == 0 {
+		s.remove(string(key))
+	}
+	return removed, nil
+}
+
+func (s *memoryStore) SMembers(ctx context.Context, key []byte) ([][]byte, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.lookupKind(ctx, key, KindSet)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return [][]byte{}, nil
+		}
+		return nil, err
+	}
+
+	members := make([][]byte, 0, len(e.set))
+	for m := range e.set {
+		members = append(members, []byte(m))
+	}
+	return members, nil
+}
+
+func (s *memoryStore) SIsMember(ctx context.Context, key []byte, member []byte) (bool, error) {
+	if err := s.rlock(ctx); err != nil {
+		return false, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.lookupKind(ctx, key, KindSet)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	_, ok := e.set[string(member)]
+	return ok, nil
+}
+
+func (s *memoryStore) SCard(ctx context.Context, key []byte) (int, error) {
+	if err := s.rlock(ctx); err != nil {
+		return 0, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.lookupKind(ctx, key, KindSet)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return len(e.set), nil
+}
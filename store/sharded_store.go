@@ -0,0 +1,253 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrShardedStore は、シャードをまとめたストアでは扱えない操作で返される
+var ErrShardedStore = errors.New("operation is not supported on a sharded store")
+
+// shardCursorBits Scan のカーソルの上位で、シャードの番号を表すビット数
+// シャードの数は最大 1<<shardCursorBits となる
+const shardCursorBits = 8
+
+const cursorShift = 64 - shardCursorBits
+
+// shardedStore は、キーごとに複数のストアへ操作を振り分ける
+type shardedStore struct {
+	shards []Store
+	route  func(ctx context.Context, key []byte) int
+}
+
+// NewShardedStore は、キーの操作を route が返す番号のストアへ振り分ける Store を返す
+// キーを持たない Scan, Len, Flush は全てのストアを順に扱う
+// スナップショットとトランザクションはシャードごとに行うため、ErrShardedStore を返す
+func NewShardedStore(shards []Store, route func(ctx context.Context, key []byte) int) Store {
+	return &shardedStore{shards: shards, route: route}
+}
+
+func (s *shardedStore) of(ctx context.Context, key []byte) Store {
+	return s.shards[s.route(ctx, key)]
+}
+
+func (s *shardedStore) Get(ctx context.Context, key []byte) ([]byte, error) {
+	return s.of(ctx, key).Get(ctx, key)
+}
+
+func (s *shardedStore) Put(ctx context.Context, key []byte, value []byte) error {
+	return s.of(ctx, key).Put(ctx, key, value)
+}
+
+func (s *shardedStore) Delete(ctx context.Context, key []byte) error {
+	return s.of(ctx, key).Delete(ctx, key)
+}
+
+func (s *shardedStore) Exists(ctx context.Context, key []byte) (bool, error) {
+	return s.of(ctx, key).Exists(ctx, key)
+}
+
+func (s *shardedStore) Type(ctx context.Context, key []byte) (Kind, error) {
+	return s.of(ctx, key).Type(ctx, key)
+}
+
+func (s *shardedStore) Expire(ctx context.Context, key []byte, at time.Time) error {
+	return s.of(ctx, key).Expire(ctx, key, at)
+}
+
+func (s *shardedStore) Persist(ctx context.Context, key []byte) error {
+	return s.of(ctx, key).Persist(ctx, key)
+}
+
+func (s *shardedStore) TTL(ctx context.Context, key []byte) (time.Time, error) {
+	return s.of(ctx, key).TTL(ctx, key)
+}
+
+// Scan シャードを番号順に走査する
+// カーソルの上位 shardCursorBits ビットがシャードの番号、残りがシャード内のカーソルの上位ビットとなる
+// 下位ビットを切り捨てた位置から再開するため、同じキーが再び返されることがある
+func (s *shardedStore) Scan(ctx context.Context, cursor uint64, count int) ([][]byte, uint64, error) {
+	if len(s.shards) == 1 {
+		return s.shards[0].Scan(ctx, cursor, count)
+	}
+
+	i := int(cursor >> cursorShift)
+	if i >= len(s.shards) {
+		return [][]byte{}, 0, nil
+	}
+	keys, next, err := s.shards[i].Scan(ctx, cursor<<shardCursorBits, count)
+	if err != nil {
+		return nil, 0, err
+	}
+	// 切り捨てるとカーソルが 0 になる位置では、走査の完了と区別できないため先へ進める
+	for i == 0 && next != 0 && next>>shardCursorBits == 0 {
+		var more [][]byte
+		more, next, err = s.shards[i].Scan(ctx, next, count)
+		if err != nil {
+			return nil, 0, err
+		}
+		keys = append(keys, more...)
+	}
+
+	switch {
+	case next != 0:
+		return keys, uint64(i)<<cursorShift | next>>shardCursorBits, nil
+	case i+1 < len(s.shards):
+		return keys, uint64(i+1) << cursorShift, nil
+	default:
+		return keys, 0, nil
+	}
+}
+
+func (s *shardedStore) Len(ctx context.Context) (int, error) {
+	total := 0
+	for _, st := range s.shards {
+		n, err := st.Len(ctx)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+func (s *shardedStore) Flush(ctx context.Context) error {
+	for _, st := range s.shards {
+		if err := st.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *shardedStore) Dump(ctx context.Context, key []byte) ([]byte, error) {
+	return s.of(ctx, key).Dump(ctx, key)
+}
+
+func (s *shardedStore) RestoreKey(ctx context.Context, key []byte, data []byte) error {
+	return s.of(ctx, key).RestoreKey(ctx, key, data)
+}
+
+func (s *shardedStore) Snapshot() (Snapshot, error) {
+	return nil, ErrShardedStore
+}
+
+func (s *shardedStore) Restore(buf io.Reader) error {
+	return ErrShardedStore
+}
+
+func (s *shardedStore) Digest(ctx context.Context) ([]byte, int, error) {
+	return nil, 0, ErrShardedStore
+}
+
+func (s *shardedStore) Txn(ctx context.Context, f func(ctx context.Context, txn Txn) error) error {
+	return ErrShardedStore
+}
+
+// UsedMemory は、全てのシャードの使用量の合計を返す
+func (s *shardedStore) UsedMemory() int64 {
+	var n int64
+	for _, st := range s.shards {
+		n += st.UsedMemory()
+	}
+	return n
+}
+
+func (s *shardedStore) MemoryUsage(ctx context.Context, key []byte) (int64, error) {
+	return s.of(ctx, key).MemoryUsage(ctx, key)
+}
+
+// EvictionCandidate キーの削除はシャードごとに複製するため、ErrShardedStore を返す
+func (s *shardedStore) EvictionCandidate(EvictionPolicy, int) ([]byte, error) {
+	return nil, ErrShardedStore
+}
+
+func (s *shardedStore) Close() error {
+	var errs []error
+	for _, st := range s.shards {
+		errs = append(errs, st.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (s *shardedStore) HGet(ctx context.Context, key []byte, field []byte) ([]byte, error) {
+	return s.of(ctx, key).HGet(ctx, key, field)
+}
+
+func (s *shardedStore) HSet(ctx context.Context, key []byte, fields map[string][]byte) (int, error) {
+	return s.of(ctx, key).HSet(ctx, key, fields)
+}
+
+func (s *shardedStore) HDel(ctx context.Context, key []byte, fields [][]byte) (int, error) {
+	return s.of(ctx, key).HDel(ctx, key, fields)
+}
+
+func (s *shardedStore) HGetAll(ctx context.Context, key []byte) (map[string][]byte, error) {
+	return s.of(ctx, key).HGetAll(ctx, key)
+}
+
+func (s *shardedStore) HLen(ctx context.Context, key []byte) (int, error) {
+	return s.of(ctx, key).HLen(ctx, key)
+}
+
+func (s *shardedStore) SAdd(ctx context.Context, key []byte, members [][]byte) (int, error) {
+	return s.of(ctx, key).SAdd(ctx, key, members)
+}
+
+func (s *shardedStore) SRem(ctx context.Context, key []byte, members [][]byte) (int, error) {
+	return s.of(ctx, key).SRem(ctx, key, members)
+}
+
+func (s *shardedStore) SMembers(ctx context.Context, key []byte) ([][]byte, error) {
+	return s.of(ctx, key).SMembers(ctx, key)
+}
+
+func (s *shardedStore) SIsMember(ctx context.Context, key []byte, member []byte) (bool, error) {
+	return s.of(ctx, key).SIsMember(ctx, key, member)
+}
+
+func (s *shardedStore) SCard(ctx context.Context, key []byte) (int, error) {
+	return s.of(ctx, key).SCard(ctx, key)
+}
+
+func (s *shardedStore) ZAdd(ctx context.Context, key []byte, members []ZMember) (int, error) {
+	return s.of(ctx, key).ZAdd(ctx, key, members)
+}
+
+func (s *shardedStore) ZRem(ctx context.Context, key []byte, members [][]byte) (int, error) {
+	return s.of(ctx, key).ZRem(ctx, key, members)
+}
+
+func (s *shardedStore) ZScore(ctx context.Context, key []byte, member []byte) (float64, error) {
+	return s.of(ctx, key).ZScore(ctx, key, member)
+}
+
+func (s *shardedStore) ZCard(ctx context.Context, key []byte) (int, error) {
+	return s.of(ctx, key).ZCard(ctx, key)
+}
+
+func (s *shardedStore) ZRange(ctx context.Context, key []byte, start, stop int) ([]ZMember, error) {
+	return s.of(ctx, key).ZRange(ctx, key, start, stop)
+}
+
+func (s *shardedStore) ZRangeByScore(ctx context.Context, key []byte, lo, hi ScoreBound, offset, count int) ([]ZMember, error) {
+	return s.of(ctx, key).ZRangeByScore(ctx, key, lo, hi, offset, count)
+}
+
+func (s *shardedStore) XAdd(ctx context.Context, key []byte, id StreamID, fields [][]byte) error {
+	return s.of(ctx, key).XAdd(ctx, key, id, fields)
+}
+
+func (s *shardedStore) XLastID(ctx context.Context, key []byte) (StreamID, error) {
+	return s.of(ctx, key).XLastID(ctx, key)
+}
+
+func (s *shardedStore) XLen(ctx context.Context, key []byte) (int, error) {
+	return s.of(ctx, key).XLen(ctx, key)
+}
+
+func (s *shardedStore) XRange(ctx context.Context, key []byte, start, end StreamID, count int) ([]StreamEntry, error) {
+	return s.of(ctx, key).XRange(ctx, key, start, end, count)
+}
@@ -0,0 +1,183 @@
+package store
+
+import "math/rand/v2"
+
+const (
+	skiplistMaxLevel = 32
+	skiplistP        = 0.25
+)
+
+// skiplist は、順序付きの集合を保持するスキップリスト
+// 各レベルのリンクに span (飛び越える要素数) を持たせることで、順位による参照を O(log n) で行える
+type skiplist[T any] struct {
+	cmp    func(a, b T) int
+	head   *skiplistNode[T]
+	level  int
+	length int
+}
+
+type skiplistNode[T any] struct {
+	item T
+	next []skiplistLink[T]
+}
+
+type skiplistLink[T any] struct {
+	node *skiplistNode[T]
+	span int
+}
+
+func newSkiplist[T any](cmp func(a, b T) int) *skiplist[T] {
+	return &skiplist[T]{
+		cmp:   cmp,
+		head:  &skiplistNode[T]{next: make([]skiplistLink[T], skiplistMaxLevel)},
+		level: 1,
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.Float64() < skiplistP {
+		level++
+	}
+	return level
+}
+
+// Len は、要素数を返す
+func (l *skiplist[T]) Len() int {
+	return l.length
+}
+
+// Insert は、item を挿入する。同じ順序の要素が既に存在する場合は何もせず false を返す
+func (l *skiplist[T]) Insert(item T) bool {
+	var update [skiplistMaxLevel]*skiplistNode[T]
+	var rank [skiplistMaxLevel]int
+
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		if i < l.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.next[i].node != nil && l.cmp(x.next[i].node.item, item) < 0 {
+			rank[i] += x.next[i].span
+			x = x.next[i].node
+		}
+		update[i] = x
+	}
+	if n := x.next[0].node; n != nil && l.cmp(n.item, item) == 0 {
+		return false
+	}
+
+	level := randomLevel()
+	if level > l.level {
+		for i := l.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = l.head
+			update[i].next[i].span = l.length
+		}
+		l.level = level
+	}
+
+	n := &skiplistNode[T]{item: item, next: make([]skiplistLink[T], level)}
+	for i := 0; i < level; i++ {
+		n.next[i].node = update[i].next[i].node
+		update[i].next[i].node = n
+		n.next[i].span = update[i].next[i].span - (rank[0] - rank[i])
+		update[i].next[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < l.level; i++ {
+		update[i].next[i].span++
+	}
+
+	l.length++
+	return true
+}
+
+// Delete は、item と同じ順序の要素を削除する。削除した場合は true を返す
+func (l *skiplist[T]) Delete(item T) bool {
+	var update [skiplistMaxLevel]*skiplistNode[T]
+
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.next[i].node != nil && l.cmp(x.next[i].node.item, item) < 0 {
+			x = x.next[i].node
+		}
+		update[i] = x
+	}
+
+	n := x.next[0].node
+	if n == nil || l.cmp(n.item, item) != 0 {
+		return false
+	}
+
+	for i := 0; i < l.level; i++ {
+		if update[i].next[i].node == n {
+			update[i].next[i].span += n.next[i].span - 1
+			update[i].next[i].node = n.next[i].node
+		} else {
+			update[i].next[i].span--
+		}
+	}
+	for l.level > 1 && l.head.next[l.level-1].node == nil {
+		l.level--
+	}
+
+	l.length--
+	return true
+}
+
+// Seek は、item 以上の最初の要素を返す
+func (l *skiplist[T]) Seek(item T) *skiplistNode[T] {
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.next[i].node != nil && l.cmp(x.next[i].node.item, item) < 0 {
+			x = x.next[i].node
+		}
+	}
+	return x.next[0].node
+}
+
+// Rank は、item と同じ順序の要素の 0 始まりの順位を返す。存在しない場合は -1 を返す
+func (l *skiplist[T]) Rank(item T) int {
+	rank := 0
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.next[i].node != nil && l.cmp(x.next[i].node.item, item) <= 0 {
+			rank += x.next[i].span
+			x = x.next[i].node
+		}
+		if x != l.head && l.cmp(x.item, item) == 0 {
+			return rank - 1
+		}
+	}
+	return -1
+}
+
+// At は、0 始まりの順位 rank の要素を返す。範囲外の場合は nil を返す
+func (l *skiplist[T]) At(rank int) *skiplistNode[T] {
+	if rank < 0 || rank >= l.length {
+		return nil
+	}
+
+	traversed := 0
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.next[i].node != nil && traversed+x.next[i].span <= rank+1 {
+			traversed += x.next[i].span
+			x = x.next[i].node
+		}
+		if traversed == rank+1 {
+			return x
+		}
+	}
+	return nil
+}
+
+// First は、先頭の要素を返す
+func (l *skiplist[T]) First() *skiplistNode[T] {
+	return l.head.next[0].node
+}
+
+// Next は、次の要素を返す
+func (n *skiplistNode[T]) Next() *skiplistNode[T] {
+	return n.next[0].node
+}
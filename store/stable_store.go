@@ -4,6 +4,9 @@ import hraft "github.com/hashicorp/raft"
 
 var prefixRedisAddr = []byte("___redisAddr")
 
+// prefixAdvertiseAddr は、クライアントに案内するアドレスのキーの接頭辞
+var prefixAdvertiseAddr = []byte("___redisAdvertiseAddr")
+
 func GetRedisAddrByNodeID(store hraft.StableStore, lid hraft.ServerID) (string, error) {
 	v, err := store.Get(append(prefixRedisAddr, []byte(lid)...))
 	if err != nil {
@@ -15,4 +18,20 @@ func GetRedisAddrByNodeID(store hraft.StableStore, lid hraft.ServerID) (string,
 
 func SetRedisAddrByNodeID(store hraft.StableStore, lid hraft.ServerID, addr string) error {
 	return store.Set(append(prefixRedisAddr, []byte(lid)...), []byte(addr))
+}
+
+// GetAdvertiseAddrByNodeID は、クライアントに案内するノードのアドレスを返す
+// 登録されていなければ、ノード間で使う Redis のアドレスを返す
+func GetAdvertiseAddrByNodeID(store hraft.StableStore, lid hraft.ServerID) (string, error) {
+	v, err := store.Get(append(prefixAdvertiseAddr, []byte(lid)...))
+	if err == nil && len(v) > 0 {
+		return string(v), nil
+	}
+	return GetRedisAddrByNodeID(store, lid)
+}
+
+// SetAdvertiseAddrByNodeID は、クライアントに案内するノードのアドレスを登録する
+// 空のアドレスは、登録を取り消す
+func SetAdvertiseAddrByNodeID(store hraft.StableStore, lid hraft.ServerID, addr string) error {
+	return store.Set(append(prefixAdvertiseAddr, []byte(lid)...), []byte(addr))
 }
\ No newline at end of file
@@ -2,7 +2,9 @@ package store
 
 import (
 	"context"
+	"errors"
 	"io"
+	"time"
 
 	"github.com/bootjp/go-kvlib/store"
 )
@@ -22,26 +24,222 @@ type Txn interface{
 */
 
 // Store は、キーバリューストアのインターフェースを定義する
-// Get, Put, Delete, Exists, Snapshot, Restore, Txn, Close の関数を提供する
+// Get, Put, Delete, Exists, Type, Expire, Persist, TTL, Scan, Len, Flush, Snapshot, Restore, Txn, Close の関数を提供する
 // このインターフェースを実装することで、任意のキーバリューストアを利用できる
+// 文字列以外の型の操作は、型ごとのインターフェースで定義する
+// 読み込みは、書き込みを待つ間や全てのキーを走査する間に ctx が終わった場合、諦めて ContextErr のエラーを返す
+// 書き込みは全てのレプリカで同じ結果になるよう FSM が期限のない ctx で行うため、ctx によらず最後まで行う
 type Store interface {
+	HashStore
+	SetStore
+	ZSetStore
+	StreamStore
+
 	Get(ctx context.Context, key []byte) ([]byte, error)
 	Put(ctx context.Context, key []byte, value []byte) error
 	Delete(ctx context.Context, key []byte) error
 	Exists(ctx context.Context, key []byte) (bool, error)
-	Snapshot() (io.ReadWriter, error)
+	// Type キーが保持する値の型を返す。値そのものは読み出さない
+	// キーが存在しない場合は KindNone を返す
+	Type(ctx context.Context, key []byte) (Kind, error)
+	// Expire キーの有効期限を絶対時刻で設定する
+	// 全てのレプリカで同じ結果になるよう、有効期限は相対時間ではなく絶対時刻で受け取る
+	// キーが存在しない場合は ErrKeyNotFound を返す
+	Expire(ctx context.Context, key []byte, at time.Time) error
+	// Persist キーの有効期限を解除する
+	// キーが存在しない場合は ErrKeyNotFound を返す
+	Persist(ctx context.Context, key []byte) error
+	// TTL キーの有効期限を返す。有効期限が設定されていない場合はゼロ値を返す
+	// キーが存在しない場合は ErrKeyNotFound を返す
+	TTL(ctx context.Context, key []byte) (time.Time, error)
+	// Scan カーソルの位置から最大 count 件程度のキーを返す
+	// 戻り値の次のカーソルを渡すことで続きを取得でき、走査が完了した場合は 0 を返す
+	// 走査の間に存在し続けたキーは、必ず一度は返される
+	Scan(ctx context.Context, cursor uint64, count int) ([][]byte, uint64, error)
+	// Len 期限切れでないキーの数を返す
+	Len(ctx context.Context) (int, error)
+	// Flush 全てのデータベースの全てのキーを削除する
+	Flush(ctx context.Context) error
+	// DBSize データベース db の期限切れでないキーの数を返す
+	DBSize(ctx context.Context, db int) (int, error)
+	// FlushDB データベース db の全てのキーを削除し、削除したキーを返す
+	FlushDB(ctx context.Context, db int) ([][]byte, error)
+	// SwapDB データベース a と b の内容を入れ替え、入れ替えたキーを両方のデータベースについて返す
+	SwapDB(ctx context.Context, a, b int) ([][]byte, error)
+	// Dump キーの値と有効期限をエンコードして返す。RestoreKey で別のストアに書き戻せる
+	// キーが存在しない場合は ErrKeyNotFound を返す
+	Dump(ctx context.Context, key []byte) ([]byte, error)
+	// RestoreKey Dump でエンコードした値をキーに書き込む。既存の値は置き換える
+	RestoreKey(ctx context.Context, key []byte, data []byte) error
+	// Snapshot 現時点の内容を、書き込みを止めずにエンコードできるスナップショットとして返す
+	Snapshot() (Snapshot, error)
+	// Restore Snapshot でエンコードした内容でストアを置き換える
 	Restore(buf io.Reader) error
+	// Digest 生きているキーの内容のハッシュとキーの数を返す。同じ内容のストアでは同じ値になる
+	Digest(ctx context.Context) ([]byte, int, error)
 	// Txn トランザクション用の関数を提供する
 	// トランザクション内で複数の操作をまとめて実行するために使用する
 	// トランザクション内でエラーが発生した場合、トランザクションはロールバックされる
 	// トランザクション内でエラーが発生しなかった場合、トランザクションはコミットされる
 	// トランザクション内で発生したエラーは呼び出し元に返される
 	Txn(ctx context.Context, f func(ctx context.Context, txn Txn) error) error
+	// UsedMemory は、キーと値が占めるおおよそのバイト数を返す
+	UsedMemory() int64
+	// MemoryUsage は、キーとその値が占めるおおよそのバイト数を返す
+	MemoryUsage(ctx context.Context, key []byte) (int64, error)
+	// ExpiredKeys は、読み込みで期限切れと分かったキーと、有効期限付きのキーを最大 samples 個調べて見つけた期限切れのキーを返す
+	// 調べたキーの数も返す。その多くが期限切れであれば、呼び出し側は続けて呼び出す
+	ExpiredKeys(ctx context.Context, samples int) ([][]byte, int, error)
+	// DeleteExpired は、ctx の時刻でキーの有効期限が過ぎている場合に削除し、削除したかを返す
+	DeleteExpired(ctx context.Context, key []byte) (bool, error)
+	// SetUsagePrefixes は、prefixes の接頭辞ごとにキーの数と使用量を数えるよう設定し、今のキーから数え直す
+	// キーは、データベースを除いたキーに最も長く一致する接頭辞に数える
+	SetUsagePrefixes(prefixes []string)
+	// PrefixUsage は、SetUsagePrefixes で設定した接頭辞のキーの数と使用量を返す
+	PrefixUsage(prefix string) Usage
+	// EvictionCandidate は、policy に従って追い出すキーを samples 個のキーの標本から選ぶ
+	// 候補がない場合は ErrKeyNotFound を返す
+	EvictionCandidate(policy EvictionPolicy, samples int) ([]byte, error)
 	Close() error
 }
 
+// Snapshot は、ある時点のストアの内容
+// 作成後のストアへの書き込みは、Persist で書き出す内容に影響しない
+type Snapshot interface {
+	// Persist 内容をキーごとにエンコードしながら w に書き込む
+	Persist(w io.Writer) error
+	// Release スナップショットが不要になったことを知らせる
+	Release()
+}
+
+// HashStore は、ハッシュ型の操作を定義する
+// キーがハッシュ型以外の値を保持している場合は ErrWrongType を返す
+type HashStore interface {
+	// HGet フィールドの値を返す。キーかフィールドが存在しない場合は ErrKeyNotFound を返す
+	HGet(ctx context.Context, key []byte, field []byte) ([]byte, error)
+	// HSet フィールドの値を設定し、新たに追加されたフィールドの数を返す
+	HSet(ctx context.Context, key []byte, fields map[string][]byte) (int, error)
+	// HDel フィールドを削除し、削除されたフィールドの数を返す
+	// 全てのフィールドが削除された場合はキーも削除する
+	HDel(ctx context.Context, key []byte, fields [][]byte) (int, error)
+	// HGetAll 全てのフィールドと値を返す。キーが存在しない場合は空の map を返す
+	HGetAll(ctx context.Context, key []byte) (map[string][]byte, error)
+	// HLen フィールドの数を返す
+	HLen(ctx context.Context, key []byte) (int, error)
+}
+
+// SetStore は、セット型の操作を定義する
+// キーがセット型以外の値を保持している場合は ErrWrongType を返す
+type SetStore interface {
+	// SAdd メンバーを追加し、新たに追加されたメンバーの数を返す
+	SAdd(ctx context.Context, key []byte, members [][]byte) (int, error)
+	// SRem メンバーを削除し、削除されたメンバーの数を返す
+	// 全てのメンバーが削除された場合はキーも削除する
+	SRem(ctx context.Context, key []byte, members [][]byte) (int, error)
+	// SMembers 全てのメンバーを返す。キーが存在しない場合は空のスライスを返す
+	SMembers(ctx context.Context, key []byte) ([][]byte, error)
+	// SIsMember メンバーが含まれているかを返す
+	SIsMember(ctx context.Context, key []byte, member []byte) (bool, error)
+	// SCard メンバーの数を返す
+	SCard(ctx context.Context, key []byte) (int, error)
+}
+
+// ZSetStore は、ソート済みセット型の操作を定義する
+// キーがソート済みセット型以外の値を保持している場合は ErrWrongType を返す
+type ZSetStore interface {
+	// ZAdd メンバーを追加または既存メンバーのスコアを更新し、新たに追加されたメンバーの数を返す
+	ZAdd(ctx context.Context, key []byte, members []ZMember) (int, error)
+	// ZRem メンバーを削除し、削除されたメンバーの数を返す
+	// 全てのメンバーが削除された場合はキーも削除する
+	ZRem(ctx context.Context, key []byte, members [][]byte) (int, error)
+	// ZScore メンバーのスコアを返す。キーかメンバーが存在しない場合は ErrKeyNotFound を返す
+	ZScore(ctx context.Context, key []byte, member []byte) (float64, error)
+	// ZCard メンバーの数を返す
+	ZCard(ctx context.Context, key []byte) (int, error)
+	// ZRange スコア順で start から stop 番目 (両端を含む) のメンバーを返す
+	// 負の値は末尾からの位置を表す
+	ZRange(ctx context.Context, key []byte, start, stop int) ([]ZMember, error)
+	// ZRangeByScore スコアが lo から hi の範囲にあるメンバーを、offset 件読み飛ばして最大 count 件返す
+	// count が負の場合は全件を返す
+	ZRangeByScore(ctx context.Context, key []byte, lo, hi ScoreBound, offset, count int) ([]ZMember, error)
+}
+
+// StreamStore は、ストリーム型の操作を定義する
+// キーがストリーム型以外の値を保持している場合は ErrWrongType を返す
+type StreamStore interface {
+	// XAdd エントリを末尾に追加する
+	// id が最後のエントリの ID 以下の場合は ErrStreamID を返す
+	XAdd(ctx context.Context, key []byte, id StreamID, fields [][]byte) error
+	// XLastID 最後に追加されたエントリの ID を返す。キーが存在しない場合はゼロ値を返す
+	XLastID(ctx context.Context, key []byte) (StreamID, error)
+	// XLen エントリの数を返す
+	XLen(ctx context.Context, key []byte) (int, error)
+	// XRange ID が start から end (両端を含む) のエントリを最大 count 件返す
+	// count が負の場合は全件を返す
+	XRange(ctx context.Context, key []byte, start, end StreamID, count int) ([]StreamEntry, error)
+}
+
 var ErrKeyNotFound = store.ErrKeyNotFound
 
-func NewMemoryStore() Store {
-	return store.NewMemoryStore()
+// ErrWrongType は、キーが操作と異なる型の値を保持している場合に返す
+var ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// ErrTimeout と ErrAborted は、ctx の期限が過ぎたか取り消されたため諦めた読み込みのエラー
+var (
+	ErrTimeout = errors.New("ERR command timed out")
+	ErrAborted = errors.New("ERR command aborted")
+)
+
+// ContextErr は、終わった ctx について読み込みが返すエラーを返す
+func ContextErr(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+	return ErrAborted
+}
+
+// Kind は、キーが保持する値の型を表す
+type Kind uint8
+
+const (
+	KindNone Kind = iota
+	KindString
+	KindHash
+	KindSet
+	KindZSet
+	KindStream
+)
+
+// String は、TYPE コマンドで返す型名を返す
+func (k Kind) String() string {
+	switch k {
+	case KindString:
+		return "string"
+	case KindHash:
+		return "hash"
+	case KindSet:
+		return "set"
+	case KindZSet:
+		return "zset"
+	case KindStream:
+		return "stream"
+	default:
+		return "none"
+	}
+}
+
+type nowKey struct{}
+
+// WithTime は、有効期限の判定に使う現在時刻を ctx に設定する
+// FSM ではログの追記時刻を設定することで、全てのレプリカで同じ判定結果になる
+func WithTime(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, nowKey{}, t)
+}
+
+// Now は、ctx に設定された現在時刻を返す。設定されていない場合は time.Now() を返す
+func Now(ctx context.Context) time.Time {
+	if t, ok := ctx.Value(nowKey{}).(time.Time); ok && !t.IsZero() {
+		return t
+	}
+	return time.Now()
 }
\ No newline at end of file
@@ -0,0 +1,157 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// StreamID は、ストリームのエントリ ID (ミリ秒-シーケンス番号)
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+// MaxStreamID は、取りうる最大の ID
+var MaxStreamID = StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Compare は、id と o を比較する
+func (id StreamID) Compare(o StreamID) int {
+	if c := cmp.Compare(id.Ms, o.Ms); c != 0 {
+		return c
+	}
+	return cmp.Compare(id.Seq, o.Seq)
+}
+
+// StreamEntry は、ストリームの1エントリ
+// Fields はフィールドと値を交互に並べたもの
+type StreamEntry struct {
+	ID     StreamID
+	Fields [][]byte
+}
+
+// stream は、ID の昇順に並んだエントリを保持する
+// エントリは常に末尾に追加されるため、スライスと二分探索で範囲検索できる
+type stream struct {
+	entries []StreamEntry
+	lastID  StreamID
+}
+
+var ErrStreamID = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+
+func (s *memoryStore) XAdd(ctx context.Context, key []byte, id StreamID, fields [][]byte) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	e, err := s.lookupKind(ctx, key, KindStream)
+	if errors.Is(err, ErrKeyNotFound) {
+		e = &entry{kind: KindStream, stream: &stream{}}
+		s.set(string(key), e)
+	} else if err != nil {
+		return err
+	}
+	e = s.mutable(key, e)
+
+	if id.Compare(e.stream.lastID) <= 0 {
+		return ErrStreamID
+	}
+	se := StreamEntry{ID: id, Fields: fields}
+	e.stream.entries = append(e.stream.entries, se)
+	e.stream.lastID = id
+	s.grow(e, streamEntrySize(se))
+	return nil
+}
+
+func (s *memoryStore) XLastID(ctx context.Context, key []byte) (StreamID, error) {
+	if err := s.rlock(ctx); err != nil {
+		return StreamID{}, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.lookupKind(ctx, key, KindStream)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return StreamID{}, nil
+		}
+		return StreamID{}, err
+	}
+	return e.stream.lastID, nil
+}
+
+func (s *memoryStore) XLen(ctx context.Context, key []byte) (int, error) {
+	if err := s.rlock(ctx); err != nil {
+		return 0, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.lookupKind(ctx, key, KindStream)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return len(e.stream.entries), nil
+}
+
+func (s *memoryStore) XRange(ctx context.Context, key []byte, start, end StreamID, count int) ([]StreamEntry, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.lookupKind(ctx, key, KindStream)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return []StreamEntry{}, nil
+		}
+		return nil, err
+	}
+
+	return e.stream.rangeByID(start, end, count), nil
+}
+
+// rangeByID は、ID が start から end (両端を含む) のエントリを最大 count 件返す
+func (st *stream) rangeByID(start, end StreamID, count int) []StreamEntry {
+	entries := st.entries
+	i := sort.Search(len(entries), func(i int) bool {
+		return entries[i].ID.Compare(start) >= 0
+	})
+
+	res := []StreamEntry{}
+	for ; i < len(entries) && entries[i].ID.Compare(end) <= 0; i++ {
+		if count >= 0 && len(res) >= count {
+			break
+		}
+		res = append(res, entries[i])
+	}
+	return res
+}
+
+var ErrInvalidStreamID = errors.New("ERR Invalid stream ID specified as stream command argument")
+
+// ParseStreamID は、"ms-seq" または "ms" 形式の ID を解析する
+// シーケンス番号が省略された場合は defaultSeq を使う
+func ParseStreamID(s string, defaultSeq uint64) (StreamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, ErrInvalidStreamID
+	}
+	if !hasSeq {
+		return StreamID{Ms: ms, Seq: defaultSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return StreamID{}, ErrInvalidStreamID
+	}
+	return StreamID{Ms: ms, Seq: seq}, nil
+}
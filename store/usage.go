@@ -0,0 +1,103 @@
+package store
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// Usage は、キーの接頭辞ごとに数えたキーの数と、それらが占めるおおよそのバイト数
+type Usage struct {
+	Keys  int64
+	Bytes int64
+}
+
+// account は、エントリを接頭辞の使用量に加える (n = 1) か、差し引く (n = -1)
+func (e *entry) account(n int64) {
+	if e.usage == nil {
+		return
+	}
+	e.usage.Keys += n
+	e.usage.Bytes += n * e.size
+}
+
+// prefixUsage は、キーの接頭辞ごとの使用量
+// prefixes は使用量を数える接頭辞で、長いものから順に並べる
+type prefixUsage struct {
+	prefixes []string
+	usage    map[string]*Usage
+}
+
+// of は、キーを数える接頭辞の使用量を返す。どの接頭辞にも一致しない場合は nil を返す
+// データベースを除いたキーに最も長く一致する接頭辞に数える
+func (u *prefixUsage) of(key string) *Usage {
+	_, k := SplitDBKey([]byte(key))
+	for _, p := range u.prefixes {
+		if strings.HasPrefix(string(k), p) {
+			return u.usage[p]
+		}
+	}
+	return nil
+}
+
+// set は、prefixes の接頭辞ごとに使用量を数えるよう設定し、m のキーから数え直す
+func (u *prefixUsage) set(prefixes []string, m map[string]*entry) {
+	u.prefixes = slices.Clone(prefixes)
+	// 長い接頭辞から順に一致を調べる
+	slices.SortFunc(u.prefixes, func(a, b string) int { return cmp.Compare(len(b), len(a)) })
+	u.usage = make(map[string]*Usage, len(prefixes))
+	for _, p := range prefixes {
+		u.usage[p] = &Usage{}
+	}
+	for k, e := range m {
+		e.usage = u.of(k)
+		e.account(1)
+	}
+}
+
+// get は、接頭辞 prefix の使用量を返す
+func (u *prefixUsage) get(prefix string) Usage {
+	if v, ok := u.usage[prefix]; ok {
+		return *v
+	}
+	return Usage{}
+}
+
+// reset は、接頭辞の使用量を 0 に戻す
+func (u *prefixUsage) reset() {
+	for _, v := range u.usage {
+		*v = Usage{}
+	}
+}
+
+// SetUsagePrefixes は、prefixes の接頭辞ごとにキーの数と使用量を数えるよう設定し、今のキーから数え直す
+func (s *memoryStore) SetUsagePrefixes(prefixes []string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.usage.set(prefixes, s.m)
+}
+
+// PrefixUsage は、SetUsagePrefixes で設定した接頭辞 prefix のキーの数と使用量を返す
+// 期限切れのキーも、削除されるまでは数える
+func (s *memoryStore) PrefixUsage(prefix string) Usage {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.usage.get(prefix)
+}
+
+func (s *shardedStore) SetUsagePrefixes(prefixes []string) {
+	for _, st := range s.shards {
+		st.SetUsagePrefixes(prefixes)
+	}
+}
+
+// PrefixUsage は、全てのシャードの使用量の合計を返す
+func (s *shardedStore) PrefixUsage(prefix string) Usage {
+	var u Usage
+	for _, st := range s.shards {
+		su := st.PrefixUsage(prefix)
+		u.Keys += su.Keys
+		u.Bytes += su.Bytes
+	}
+	return u
+}
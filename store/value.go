@@ -0,0 +1,46 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// Value は、キーの値と有効期限を型によらず表したもの
+// Dump でエンコードした値と相互に変換できる
+type Value struct {
+	Kind   Kind
+	String []byte
+	Hash   map[string][]byte
+	Set    [][]byte
+	ZSet   []ZMember
+	Stream []StreamEntry
+	LastID StreamID
+	// ExpireAt 有効期限 (Unix ミリ秒)。0 の場合は期限なし
+	ExpireAt int64
+}
+
+// EncodeValue は、値を Dump と同じ形式でエンコードする
+func EncodeValue(v Value) ([]byte, error) {
+	se := snapshotEntry{Kind: v.Kind, Value: v.String, Hash: v.Hash, ZSet: v.ZSet, Stream: v.Stream, LastID: v.LastID, ExpireAt: v.ExpireAt}
+	for _, m := range v.Set {
+		se.Set = append(se.Set, string(m))
+	}
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(se); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeValue は、Dump でエンコードした値を復元する
+func DecodeValue(data []byte) (Value, error) {
+	var se snapshotEntry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&se); err != nil {
+		return Value{}, err
+	}
+	v := Value{Kind: se.Kind, String: se.Value, Hash: se.Hash, ZSet: se.ZSet, Stream: se.Stream, LastID: se.LastID, ExpireAt: se.ExpireAt}
+	for _, m := range se.Set {
+		v.Set = append(v.Set, []byte(m))
+	}
+	return v, nil
+}
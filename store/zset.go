@@ -0,0 +1,251 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"strings"
+)
+
+// ZMember は、ソート済みセットのメンバーとスコア
+type ZMember struct {
+	Member []byte
+	Score  float64
+}
+
+// ScoreBound は、スコアによる範囲指定の端点
+type ScoreBound struct {
+	Value     float64
+	Exclusive bool
+}
+
+func (b ScoreBound) lessOrEqual(score float64) bool {
+	if b.Exclusive {
+		return b.Value < score
+	}
+	return b.Value <= score
+}
+
+func (b ScoreBound) greaterOrEqual(score float64) bool {
+	if b.Exclusive {
+		return b.Value > score
+	}
+	return b.Value >= score
+}
+
+type zsetItem struct {
+	score  float64
+	member string
+}
+
+func compareZSetItem(a, b zsetItem) int {
+	if c := cmp.Compare(a.score, b.score); c != 0 {
+		return c
+	}
+	return strings.Compare(a.member, b.member)
+}
+
+// zset は、メンバーからスコアを引く map と、スコア順に並べたスキップリストを併せ持つ
+type zset struct {
+	scores map[string]float64
+	order  *skiplist[zsetItem]
+}
+
+func newZSet() *zset {
+	return &zset{
+		scores: map[string]float64{},
+		order:  newSkiplist(compareZSetItem),
+	}
+}
+
+// add は、メンバーを追加する。新たに追加された場合は true を返す
+func (z *zset) add(member string, score float64) bool {
+	cur, ok := z.scores[member]
+	if ok {
+		if cur == score {
+			return false
+		}
+		z.order.Delete(zsetItem{score: cur, member: member})
+	}
+	z.scores[member] = score
+	z.order.Insert(zsetItem{score: score, member: member})
+	return !ok
+}
+
+func (z *zset) remove(member string) bool {
+	score, ok := z.scores[member]
+	if !ok {
+		return false
+	}
+	delete(z.scores, member)
+	z.order.Delete(zsetItem{score: score, member: member})
+	return true
+}
+
+func (s *memoryStore) ZAdd(ctx context.Context, key []byte, members []ZMember) (int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	e, err := s.lookupKind(ctx, key, KindZSet)
+	if errors.Is(err, ErrKeyNotFound) {
+		e = &entry{kind: KindZSet, zset: newZSet()}
+		s.set(string(key), e)
+	} else if err != nil {
+		return 0, err
+	}
+	e = s.mutable(key, e)
+
+	added := 0
+	for _, m := range members {
+		if e.zset.add(string(m.Member), m.Score) {
+			s.grow(e, memberSize(string(m.Member)))
+			added++
+		}
+	}
+	return added, nil
+}
+
+func (s *memoryStore) ZRem(ctx context.Context, key []byte, members [][]byte) (int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	e, err := s.lookupKind(ctx, key, KindZSet)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	e = s.mutable(key, e)
+
+	removed := 0
+	for _, m := range members {
+		if e.zset.remove(string(m)) {
+			s.grow(e, -memberSize(string(m)))
+			removed++
+		}
+	}
+	if len(e.zset.scores) == 0 {
+		s.remove(string(key))
+	}
+	return removed, nil
+}
+
+func (s *memoryStore) ZScore(ctx context.Context, key []byte, member []byte) (float64, error) {
+	if err := s.rlock(ctx); err != nil {
+		return 0, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.lookupKind(ctx, key, KindZSet)
+	if err != nil {
+		return 0, err
+	}
+	score, ok := e.zset.scores[string(member)]
+	if !ok {
+		return 0, ErrKeyNotFound
+	}
+	return score, nil
+}
+
+func (s *memoryStore) ZCard(ctx context.Context, key []byte) (int, error) {
+	if err := s.rlock(ctx); err != nil {
+		return 0, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.lookupKind(ctx, key, KindZSet)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return len(e.zset.scores), nil
+}
+
+func (s *memoryStore) ZRange(ctx context.Context, key []byte, start, stop int) ([]ZMember, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.lookupKind(ctx, key, KindZSet)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return []ZMember{}, nil
+		}
+		return nil, err
+	}
+
+	return e.zset.rangeByRank(start, stop), nil
+}
+
+// rangeByRank は、スコア順で start から stop 番目 (両端を含む) のメンバーを返す
+// 負の値は末尾からの位置を表す
+func (z *zset) rangeByRank(start, stop int) []ZMember {
+	n := z.order.Len()
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if stop < 0 {
+		stop = n + stop
+	}
+	stop = min(stop, n-1)
+	if start > stop {
+		return []ZMember{}
+	}
+	res := make([]ZMember, 0, stop-start+1)
+	node := z.order.At(start)
+	for i := start; i <= stop && node != nil; i++ {
+		res = append(res, ZMember{Member: []byte(node.item.member), Score: node.item.score})
+		node = node.Next()
+	}
+	return res
+}
+
+func (s *memoryStore) ZRangeByScore(ctx context.Context, key []byte, lo, hi ScoreBound, offset, count int) ([]ZMember, error) {
+	if err := s.rlock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.mtx.RUnlock()
+
+	e, err := s.lookupKind(ctx, key, KindZSet)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return []ZMember{}, nil
+		}
+		return nil, err
+	}
+
+	return e.zset.rangeByScore(lo, hi, offset, count), nil
+}
+
+// rangeByScore は、スコアが lo から hi の範囲にあるメンバーを、offset 件読み飛ばして最大 count 件返す
+func (z *zset) rangeByScore(lo, hi ScoreBound, offset, count int) []ZMember {
+	res := []ZMember{}
+	node := z.order.Seek(zsetItem{score: lo.Value})
+	for ; node != nil && hi.greaterOrEqual(node.item.score); node = node.Next() {
+		if !lo.lessOrEqual(node.item.score) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if count >= 0 && len(res) >= count {
+			break
+		}
+		res = append(res, ZMember{Member: []byte(node.item.member), Score: node.item.score})
+	}
+	return res
+}
+
+// members は、スナップショット用にメンバーをスコア順に並べて返す
+func (z *zset) members() []ZMember {
+	res := make([]ZMember, 0, z.order.Len())
+	for node := z.order.First(); node != nil; node = node.Next() {
+		res = append(res, ZMember{Member: []byte(node.item.member), Score: node.item.score})
+	}
+	return res
+}
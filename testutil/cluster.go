@@ -0,0 +1,340 @@
+// Package testutil runs a cluster of several nodes in one process for
+// integration tests. The nodes talk Raft over in-memory transports and keep
+// their data in temporary directories, but serve Redis clients, and forward
+// commands to each other, on loopback ports.
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	hraft "github.com/hashicorp/raft"
+
+	"raft-redis-cluster/client"
+	"raft-redis-cluster/fault"
+	"raft-redis-cluster/kvs"
+)
+
+const (
+	// waitTimeout bounds how long WaitLeader waits.
+	waitTimeout = 10 * time.Second
+	// waitPoll is how often WaitLeader checks the nodes.
+	waitPoll = 10 * time.Millisecond
+	// raftPort is the port of the Raft address of every node, which only
+	// names the in-memory transports.
+	raftPort = 7000
+)
+
+// Options configure a Cluster.
+type Options struct {
+	// Nodes is the number of nodes, 3 when zero.
+	Nodes int
+	// Shards is the number of shards, 1 when zero.
+	Shards int
+	// Configure, when set, adjusts the configuration of the i-th node each
+	// time it starts.
+	Configure func(i int, cfg *kvs.Config)
+}
+
+// Cluster is a cluster of nodes running in the test process. It is shut
+// down when the test ends.
+type Cluster struct {
+	t     testing.TB
+	opts  Options
+	nodes []*node
+
+	mu sync.Mutex
+	// transports are the in-memory Raft transports of the running nodes,
+	// by node and shard.
+	transports [][]*hraft.InmemTransport
+}
+
+// node is a node of a Cluster.
+type node struct {
+	id        string
+	raftAddr  string
+	redisAddr string
+	dataDir   string
+	faults    *fault.Injector
+	kv        *kvs.Node // nil while killed
+}
+
+// NewCluster starts a cluster and waits for every shard to elect a leader.
+// It fails the test if it can't.
+func NewCluster(t testing.TB, opts Options) *Cluster {
+	t.Helper()
+	if opts.Nodes == 0 {
+		opts.Nodes = 3
+	}
+	if opts.Shards == 0 {
+		opts.Shards = 1
+	}
+	c := &Cluster{t: t, opts: opts, transports: make([][]*hraft.InmemTransport, opts.Nodes)}
+	for i := range opts.Nodes {
+		c.nodes = append(c.nodes, &node{
+			id:        fmt.Sprintf("node%d", i+1),
+			raftAddr:  fmt.Sprintf("node%d:%d", i+1, raftPort),
+			redisAddr: freeAddr(t),
+			dataDir:   t.TempDir(),
+			faults:    fault.New(),
+		})
+	}
+	t.Cleanup(c.close)
+
+	for i := range c.nodes {
+		c.start(i)
+	}
+	for shard := range opts.Shards {
+		c.WaitLeader(shard)
+	}
+	return c
+}
+
+// freeAddr returns a loopback address with a port free for now.
+func freeAddr(t testing.TB) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("testutil: %v", err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// start starts the i-th node on its data directory.
+func (c *Cluster) start(i int) {
+	c.t.Helper()
+	n := c.nodes[i]
+	cfg := kvs.Config{
+		ID:            n.id,
+		RaftAddr:      n.raftAddr,
+		RedisAddrs:    []string{n.redisAddr},
+		DataDir:       n.dataDir,
+		Shards:        c.opts.Shards,
+		RaftTransport: func(shard int, addr string) (hraft.Transport, error) { return c.connect(i, shard, addr), nil },
+		Raft: func(rc *hraft.Config) {
+			rc.HeartbeatTimeout = 100 * time.Millisecond
+			rc.ElectionTimeout = 100 * time.Millisecond
+			rc.LeaderLeaseTimeout = 50 * time.Millisecond
+			rc.CommitTimeout = 5 * time.Millisecond
+			rc.LogOutput = io.Discard
+		},
+		Faults: n.faults,
+		Logger: slog.New(slog.DiscardHandler),
+	}
+	for j, p := range c.nodes {
+		if j != i {
+			cfg.Peers = append(cfg.Peers, kvs.Peer{NodeID: p.id, RaftAddr: p.raftAddr, RedisAddr: p.redisAddr})
+		}
+	}
+	if c.opts.Configure != nil {
+		c.opts.Configure(i, &cfg)
+	}
+
+	kv, err := kvs.NewNode(cfg)
+	if err != nil {
+		c.t.Fatalf("testutil: starting %s: %v", n.id, err)
+	}
+	if err := kv.Start(); err != nil {
+		kv.Kill()
+		c.t.Fatalf("testutil: starting %s: %v", n.id, err)
+	}
+	n.kv = kv
+}
+
+// connect returns a new in-memory transport of the shard of the i-th node,
+// connected both ways to those of the running nodes.
+func (c *Cluster) connect(i, shard int, addr string) hraft.Transport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, tr := hraft.NewInmemTransport(hraft.ServerAddress(addr))
+	for j, trs := range c.transports {
+		if j == i || trs == nil {
+			continue
+		}
+		peer := trs[shard]
+		tr.Connect(peer.LocalAddr(), peer)
+		peer.Connect(tr.LocalAddr(), tr)
+	}
+	if c.transports[i] == nil {
+		c.transports[i] = make([]*hraft.InmemTransport, c.opts.Shards)
+	}
+	c.transports[i][shard] = tr
+	return tr
+}
+
+// Len returns the number of nodes.
+func (c *Cluster) Len() int {
+	return len(c.nodes)
+}
+
+// Node returns the i-th node, or nil while it is killed.
+func (c *Cluster) Node(i int) *kvs.Node {
+	return c.nodes[i].kv
+}
+
+// ID returns the Raft server ID of the i-th node.
+func (c *Cluster) ID(i int) string {
+	return c.nodes[i].id
+}
+
+// RedisAddr returns the address the i-th node serves Redis clients on.
+func (c *Cluster) RedisAddr(i int) string {
+	return c.nodes[i].redisAddr
+}
+
+// Addrs returns the Redis addresses of every node.
+func (c *Cluster) Addrs() []string {
+	addrs := make([]string, len(c.nodes))
+	for i, n := range c.nodes {
+		addrs[i] = n.redisAddr
+	}
+	return addrs
+}
+
+// Client returns a client of the cluster, closed when the test ends.
+func (c *Cluster) Client() *client.Client {
+	c.t.Helper()
+	cl, err := client.New(client.Options{Addrs: c.Addrs()})
+	if err != nil {
+		c.t.Fatalf("testutil: %v", err)
+	}
+	c.t.Cleanup(func() { cl.Close() })
+	return cl
+}
+
+// Faults returns the failure points of the i-th node. They are kept when it
+// is restarted.
+func (c *Cluster) Faults(i int) *fault.Injector {
+	return c.nodes[i].faults
+}
+
+// Partition splits the nodes into groups, given by their indexes, that
+// exchange no Raft message with each other until Heal. The nodes in no
+// group are cut off from every other node.
+func (c *Cluster) Partition(groups ...[]int) {
+	group := make([]int, len(c.nodes))
+	for i := range group {
+		group[i] = -1 - i
+	}
+	for g, is := range groups {
+		for _, i := range is {
+			group[i] = g
+		}
+	}
+	for i, n := range c.nodes {
+		for j, p := range c.nodes {
+			if group[j] != group[i] {
+				n.faults.Partition(p.id)
+			}
+		}
+	}
+}
+
+// Heal ends the partitions of every node.
+func (c *Cluster) Heal() {
+	for _, n := range c.nodes {
+		n.faults.Heal()
+	}
+}
+
+// Kill stops the i-th node at once, as a crash would, and cuts it off
+// from the others. Restart starts it again.
+func (c *Cluster) Kill(i int) {
+	c.t.Helper()
+	c.down(i, func(kv *kvs.Node) { kv.Kill() })
+}
+
+// Stop stops the i-th node gracefully, as on SIGTERM, and cuts it off from
+// the others. Restart starts it again.
+func (c *Cluster) Stop(i int) {
+	c.t.Helper()
+	c.down(i, func(kv *kvs.Node) {
+		if err := kv.Stop(context.Background()); err != nil {
+			c.t.Errorf("testutil: stopping %s: %v", c.nodes[i].id, err)
+		}
+	})
+}
+
+// down stops the running i-th node with stop and disconnects it.
+func (c *Cluster) down(i int, stop func(*kvs.Node)) {
+	c.t.Helper()
+	n := c.nodes[i]
+	if n.kv == nil {
+		c.t.Fatalf("testutil: %s is not running", n.id)
+	}
+	stop(n.kv)
+	n.kv = nil
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	killed := c.transports[i]
+	c.transports[i] = nil
+	for _, trs := range c.transports {
+		for shard, tr := range trs {
+			tr.Disconnect(killed[shard].LocalAddr())
+		}
+	}
+}
+
+// Restart starts the killed or stopped i-th node again on what it wrote to disk.
+func (c *Cluster) Restart(i int) {
+	c.t.Helper()
+	if n := c.nodes[i]; n.kv != nil {
+		c.t.Fatalf("testutil: %s is running", n.id)
+	}
+	c.start(i)
+}
+
+// WaitLeader waits for the running nodes to agree on a running leader of
+// the shard and returns its index. It fails the test if they don't within
+// 10 seconds.
+func (c *Cluster) WaitLeader(shard int) int {
+	c.t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for time.Now().Before(deadline) {
+		if i := c.leader(shard); i >= 0 {
+			return i
+		}
+		time.Sleep(waitPoll)
+	}
+	c.t.Fatalf("testutil: shard %d elected no leader within %v", shard, waitTimeout)
+	return -1
+}
+
+// leader returns the index of the leader of the shard every running node
+// follows, or -1 when they don't agree on a running one.
+func (c *Cluster) leader(shard int) int {
+	leader := ""
+	for _, n := range c.nodes {
+		if n.kv == nil {
+			continue
+		}
+		id := n.kv.Leader(shard)
+		if id == "" || (leader != "" && id != leader) {
+			return -1
+		}
+		leader = id
+	}
+	for i, n := range c.nodes {
+		if n.id == leader && n.kv != nil {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *Cluster) close() {
+	for _, n := range c.nodes {
+		if n.kv != nil {
+			n.kv.Kill()
+			n.kv = nil
+		}
+	}
+}
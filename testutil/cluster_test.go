@@ -0,0 +1,233 @@
+package testutil_test
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"raft-redis-cluster/kvs"
+	"raft-redis-cluster/testutil"
+)
+
+// waitValue waits for the i-th node to read value for key from its own
+// store.
+func waitValue(t *testing.T, c *testutil.Cluster, i int, key, value string) {
+	t.Helper()
+	ctx := context.Background()
+	n := c.Node(i)
+	if _, err := n.Do(ctx, "CONFIG", "SET", "read-consistency", "stale"); err != nil {
+		t.Fatalf("node %d: %v", i, err)
+	}
+	var got []byte
+	var err error
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		got, err = n.Get(ctx, key)
+		if err == nil && string(got) == value {
+			return
+		}
+	}
+	t.Fatalf("node %d: GET %s = %q, %v, want %q", i, key, got, err, value)
+}
+
+// dbSize returns the number of keys of the shards the i-th node leads,
+// once it serves them.
+func dbSize(t *testing.T, c *testutil.Cluster, i int) int64 {
+	t.Helper()
+	var v any
+	var err error
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if v, err = c.Node(i).Do(context.Background(), "DBSIZE"); err == nil {
+			return v.(int64)
+		}
+	}
+	t.Fatalf("node %d: DBSIZE: %v", i, err)
+	return 0
+}
+
+// waitLoaded waits for the i-th node to apply the log it held when it
+// started in every shard, until when it replies LOADING.
+func waitLoaded(t *testing.T, c *testutil.Cluster, i int) {
+	t.Helper()
+	var v any
+	var err error
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		v, err = c.Node(i).Do(context.Background(), "INFO", "persistence")
+		if info, ok := v.([]byte); ok && strings.Contains(string(info), "loading:0\r\n") {
+			return
+		}
+	}
+	t.Fatalf("node %d: still loading: %v, %v", i, v, err)
+}
+
+func TestClusterReplicatesAndFailsOver(t *testing.T) {
+	ctx := context.Background()
+	c := testutil.NewCluster(t, testutil.Options{Nodes: 3})
+	cl := c.Client()
+
+	if err := cl.Set(ctx, "k", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	for i := range c.Len() {
+		waitValue(t, c, i, "k", "v1")
+	}
+
+	leader := c.WaitLeader(0)
+	c.Kill(leader)
+	if c.Node(leader) != nil {
+		t.Fatalf("node %d still running after Kill", leader)
+	}
+	next := c.WaitLeader(0)
+	if next == leader {
+		t.Fatalf("killed node %d is still the leader", leader)
+	}
+
+	n := c.Node(next)
+	if v, err := n.Get(ctx, "k"); err != nil || string(v) != "v1" {
+		t.Fatalf("GET k after failover = %q, %v, want v1", v, err)
+	}
+	if err := n.Set(ctx, "k", []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The restarted node catches up with the write it missed.
+	c.Restart(leader)
+	c.WaitLeader(0)
+	waitValue(t, c, leader, "k", "v2")
+}
+
+// splitLeaders has shards 0 and 1 led by different nodes, and returns
+// their leaders.
+func splitLeaders(t *testing.T, c *testutil.Cluster) (int, int) {
+	t.Helper()
+	l0, l1 := c.WaitLeader(0), c.WaitLeader(1)
+	if l0 == l1 {
+		to := (l1 + 1) % c.Len()
+		if _, err := c.Node(l1).Do(context.Background(), "RAFT.TRANSFER", "1", c.ID(to)); err != nil {
+			t.Fatal(err)
+		}
+		l1 = c.WaitLeader(1)
+	}
+	return l0, l1
+}
+
+func TestFlushAllReachesShardsLedElsewhere(t *testing.T) {
+	ctx := context.Background()
+	c := testutil.NewCluster(t, testutil.Options{Nodes: 3, Shards: 2})
+	l0, l1 := splitLeaders(t, c)
+	for i := range c.Len() {
+		waitLoaded(t, c, i)
+	}
+
+	n := c.Node(l0)
+	for i := range 20 {
+		if err := n.Set(ctx, "k"+strconv.Itoa(i), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v := dbSize(t, c, l1); v == 0 {
+		t.Fatalf("node %d: DBSIZE = 0, want the keys of shard 1", l1)
+	}
+
+	if v, err := n.Do(ctx, "FLUSHALL"); err != nil || v != "OK" {
+		t.Fatalf("FLUSHALL = %v, %v, want OK", v, err)
+	}
+	for _, i := range []int{l0, l1} {
+		if v := dbSize(t, c, i); v != 0 {
+			t.Errorf("node %d: DBSIZE after FLUSHALL = %d, want 0", i, v)
+		}
+	}
+}
+
+func TestClusterReplicatesChunkedValue(t *testing.T) {
+	ctx := context.Background()
+	c := testutil.NewCluster(t, testutil.Options{Nodes: 3})
+	n := c.Node(c.WaitLeader(0))
+	if _, err := n.Do(ctx, "CONFIG", "SET", "raft-chunk-size", "1000"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Three full chunks and the rest in the SET.
+	value := strings.Repeat("0123456789", 350)
+	if err := n.Set(ctx, "big", []byte(value)); err != nil {
+		t.Fatal(err)
+	}
+	for i := range c.Len() {
+		waitValue(t, c, i, "big", value)
+	}
+}
+
+func TestScriptsReachEveryShard(t *testing.T) {
+	ctx := context.Background()
+	c := testutil.NewCluster(t, testutil.Options{Nodes: 3, Shards: 2})
+	l0, _ := splitLeaders(t, c)
+	n := c.Node(l0)
+
+	// The keys fall in both shards. EVALSHA finds the script EVAL cached
+	// in the shard of its key, and the one SCRIPT LOAD cached in all.
+	v, err := n.Do(ctx, "SCRIPT", "LOAD", "return 2")
+	if err != nil {
+		t.Fatalf("SCRIPT LOAD = %v", err)
+	}
+	loaded := string(v.([]byte))
+	for i := range 20 {
+		key := "k" + strconv.Itoa(i)
+		if _, err := n.Do(ctx, "EVAL", "return 1", "1", key); err != nil {
+			t.Fatalf("EVAL on %s = %v", key, err)
+		}
+		if v, err := n.Do(ctx, "EVALSHA", "e0e1f9fabfc9d4800c877a703b823ac0578ff8db", "1", key); err != nil || v != int64(1) {
+			t.Fatalf("EVALSHA of the EVAL on %s = %v, %v, want 1", key, v, err)
+		}
+		if v, err := n.Do(ctx, "EVALSHA", loaded, "1", key); err != nil || v != int64(2) {
+			t.Fatalf("EVALSHA of the loaded script on %s = %v, %v, want 2", key, v, err)
+		}
+	}
+
+	if _, err := n.Do(ctx, "SCRIPT", "FLUSH"); err != nil {
+		t.Fatalf("SCRIPT FLUSH = %v", err)
+	}
+	// Every replica of every shard flushes its cache, once it applied the
+	// flush.
+	for i := range c.Len() {
+		var v any
+		var err error
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if v, err = c.Node(i).Do(ctx, "SCRIPT", "EXISTS", loaded); err == nil && v.([]any)[0] == int64(0) {
+				break
+			}
+		}
+		if err != nil || v.([]any)[0] != int64(0) {
+			t.Errorf("node %d: SCRIPT EXISTS after SCRIPT FLUSH = %v, %v, want [0]", i, v, err)
+		}
+	}
+}
+
+func TestDiskStoresKeptAcrossStop(t *testing.T) {
+	for _, name := range []string{"badger", "bolt", "pebble"} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			c := testutil.NewCluster(t, testutil.Options{Nodes: 1, Configure: func(_ int, cfg *kvs.Config) { cfg.Store = name }})
+			c.WaitLeader(0)
+			waitLoaded(t, c, 0)
+			n := c.Node(0)
+			for range 3 {
+				if _, err := n.Do(ctx, "INCR", "counter"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Each entry is applied once, whether the store was kept by the
+			// stop or rebuilt by the kill.
+			for i, down := range []func(int){c.Stop, c.Kill, c.Stop} {
+				down(0)
+				c.Restart(0)
+				c.WaitLeader(0)
+				waitLoaded(t, c, 0)
+				if v, err := c.Node(0).Do(ctx, "INCR", "counter"); err != nil || v != int64(4+i) {
+					t.Fatalf("INCR after restart %d = %v, %v, want %d", i, v, err, 4+i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+// Package tlsconfig builds the TLS configurations of the Redis listener and
+// of the Raft transport from certificate files and cipher policy settings.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Options are the TLS settings of a listener and of the connections it
+// dials to its peers.
+type Options struct {
+	// CertFile and KeyFile hold the PEM encoded certificate and key of the
+	// node.
+	CertFile string
+	KeyFile  string
+	// CAFile holds the PEM encoded certificates of the CAs that sign client
+	// certificates. When set, clients must present a certificate signed by
+	// one of them.
+	CAFile string
+	// Ciphers is a comma-separated list of TLS 1.0-1.2 cipher suite names,
+	// such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Empty means the Go
+	// defaults. TLS 1.3 suites are not configurable.
+	Ciphers string
+	// MinVersion is the lowest protocol version accepted: 1.0, 1.1, 1.2 or
+	// 1.3. Empty means 1.2.
+	MinVersion string
+}
+
+// Enabled reports whether a certificate is configured.
+func (o Options) Enabled() bool {
+	return o.CertFile != ""
+}
+
+// Server returns the configuration of a TLS listener. Its certificate is
+// read again by Reload.
+func (o Options) Server() (*tls.Config, error) {
+	cert, err := loadCertificate(o.CertFile, o.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	version, err := parseVersion(o.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	ciphers, err := parseCiphers(o.Ciphers)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return cert.get(), nil },
+		MinVersion:     version,
+		CipherSuites:   ciphers,
+	}
+	if o.CAFile != "" {
+		pool, err := loadPool(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// Client returns the configuration used to dial a TLS server that
+// presents a certificate signed by a CA in CAFile, or by a CA the system
+// trusts when CAFile is empty. The certificate in CertFile, when set, is
+// presented to the server, so that both ends are authenticated. Like that
+// of Server, it is read again by Reload.
+func (o Options) Client() (*tls.Config, error) {
+	version, err := parseVersion(o.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	ciphers, err := parseCiphers(o.Ciphers)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{MinVersion: version, CipherSuites: ciphers}
+	if o.CAFile != "" {
+		if cfg.RootCAs, err = loadPool(o.CAFile); err != nil {
+			return nil, err
+		}
+	}
+	if o.CertFile != "" {
+		cert, err := loadCertificate(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) { return cert.get(), nil }
+	}
+	return cfg, nil
+}
+
+// certificate is a certificate and key pair loaded from files, which Reload
+// replaces when the files change.
+type certificate struct {
+	certFile, keyFile string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func (c *certificate) get() *tls.Certificate {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert
+}
+
+func (c *certificate) load() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert = &cert
+	return nil
+}
+
+// certificates are the certificates loaded by Server and Client, by their
+// files, so that the configurations sharing files share the certificate.
+var certificates = struct {
+	sync.Mutex
+	m map[[2]string]*certificate
+}{m: map[[2]string]*certificate{}}
+
+func loadCertificate(certFile, keyFile string) (*certificate, error) {
+	certificates.Lock()
+	defer certificates.Unlock()
+	if c, ok := certificates.m[[2]string{certFile, keyFile}]; ok {
+		return c, nil
+	}
+	c := &certificate{certFile: certFile, keyFile: keyFile}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	certificates.m[[2]string{certFile, keyFile}] = c
+	return c, nil
+}
+
+// Reload reads again the certificates of the configurations returned by
+// Server and Client, which present the new ones to the connections made
+// from then on, so that renewed certificates are rolled out without a
+// restart. A certificate that fails to load is kept as it was.
+func Reload() error {
+	certificates.Lock()
+	defer certificates.Unlock()
+	var errs []error
+	for _, c := range certificates.m {
+		if err := c.load(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.certFile, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func loadPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func parseVersion(s string) (uint16, error) {
+	if s == "" {
+		return tls.VersionTLS12, nil
+	}
+	v, ok := versions[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q", s)
+	}
+	return v, nil
+}
+
+func parseCiphers(s string) ([]uint16, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	byName := map[string]uint16{}
+	for _, c := range tls.CipherSuites() {
+		byName[c.Name] = c.ID
+	}
+	for _, c := range tls.InsecureCipherSuites() {
+		byName[c.Name] = c.ID
+	}
+
+	var ids []uint16
+	for _, name := range strings.Split(s, ",") {
+		id, ok := byName[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
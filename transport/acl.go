@@ -0,0 +1,439 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/redcon"
+	"raft-redis-cluster/acl"
+	"raft-redis-cluster/raft"
+)
+
+var errNoPermKey = errors.New("NOPERM No permissions to access a key")
+
+// cmdCategories are the ACL categories of each command. Entries named
+// COMMAND|SUBCOMMAND override the categories of the command.
+var cmdCategories = map[string][]string{
+	"GET":         {"read", "string"},
+	"SET":         {"write", "string"},
+	"INCR":        {"write", "string"},
+	"DECR":        {"write", "string"},
+	"INCRBY":      {"write", "string"},
+	"DECRBY":      {"write", "string"},
+	"INCRBYFLOAT": {"write", "string"},
+	"MGET":        {"read", "string"},
+	"MSET":        {"write", "string"},
+	"APPEND":      {"write", "string"},
+	"SETRANGE":    {"write", "string"},
+	"STRLEN":      {"read", "string"},
+	"GETRANGE":    {"read", "string"},
+	"SUBSTR":      {"read", "string"},
+
+	"DEL":      {"write", "keyspace"},
+	"UNLINK":   {"write", "keyspace"},
+	"EXPIRE":   {"write", "keyspace"},
+	"PEXPIRE":  {"write", "keyspace"},
+	"PERSIST":  {"write", "keyspace"},
+	"TTL":      {"read", "keyspace"},
+	"PTTL":     {"read", "keyspace"},
+	"EXISTS":   {"read", "keyspace"},
+	"TOUCH":    {"read", "keyspace"},
+	"TYPE":     {"read", "keyspace"},
+	"VERSION":  {"read", "keyspace"},
+	"DUMP":     {"read", "keyspace"},
+	"RESTORE":  {"write", "keyspace", "dangerous"},
+	"RENAME":   {"write", "keyspace"},
+	"RENAMENX": {"write", "keyspace"},
+	"COPY":     {"write", "keyspace"},
+	"LOCK":     {"write", "keyspace"},
+	"UNLOCK":   {"write", "keyspace"},
+	"KEYS":     {"read", "keyspace", "dangerous"},
+	"SCAN":     {"read", "keyspace"},
+	"DBSIZE":   {"read", "keyspace"},
+	"FLUSHDB":  {"write", "keyspace", "dangerous"},
+	"FLUSHALL": {"write", "keyspace", "dangerous"},
+	"SWAPDB":   {"write", "keyspace", "dangerous"},
+
+	"HSET":    {"write", "hash"},
+	"HMSET":   {"write", "hash"},
+	"HDEL":    {"write", "hash"},
+	"HINCRBY": {"write", "hash"},
+	"HGET":    {"read", "hash"},
+	"HMGET":   {"read", "hash"},
+	"HGETALL": {"read", "hash"},
+	"HKEYS":   {"read", "hash"},
+	"HVALS":   {"read", "hash"},
+	"HLEN":    {"read", "hash"},
+	"HEXISTS": {"read", "hash"},
+
+	"SADD":      {"write", "set"},
+	"SREM":      {"write", "set"},
+	"SMEMBERS":  {"read", "set"},
+	"SISMEMBER": {"read", "set"},
+	"SCARD":     {"read", "set"},
+
+	"ZADD":          {"write", "sortedset"},
+	"ZREM":          {"write", "sortedset"},
+	"ZSCORE":        {"read", "sortedset"},
+	"ZCARD":         {"read", "sortedset"},
+	"ZRANGE":        {"read", "sortedset"},
+	"ZRANGEBYSCORE": {"read", "sortedset"},
+
+	"SETBIT":   {"write", "bitmap"},
+	"BITOP":    {"write", "bitmap"},
+	"GETBIT":   {"read", "bitmap"},
+	"BITCOUNT": {"read", "bitmap"},
+
+	"PFADD":   {"write", "hyperloglog"},
+	"PFMERGE": {"write", "hyperloglog"},
+	"PFCOUNT": {"read", "hyperloglog"},
+
+	"XADD":   {"write", "stream"},
+	"XLEN":   {"read", "stream"},
+	"XRANGE": {"read", "stream"},
+	"XREAD":  {"read", "stream"},
+
+	"SUBSCRIBE":    {"pubsub"},
+	"PSUBSCRIBE":   {"pubsub"},
+	"UNSUBSCRIBE":  {"pubsub"},
+	"PUNSUBSCRIBE": {"pubsub"},
+	"PUBLISH":      {"pubsub"},
+
+	"MULTI":   {"transaction"},
+	"EXEC":    {"transaction"},
+	"DISCARD": {"transaction"},
+	"WATCH":   {"transaction"},
+	"UNWATCH": {"transaction"},
+
+	"EVAL":    {"scripting"},
+	"EVALSHA": {"scripting"},
+	"SCRIPT":  {"scripting"},
+
+	"PING":   {"connection"},
+	"ECHO":   {"connection"},
+	"QUIT":   {"connection"},
+	"SELECT": {"connection"},
+	"HELLO":  {"connection"},
+	"AUTH":   {"connection"},
+	"ASKING": {"connection"},
+	"REQID":  {"connection"},
+
+	"READONLY":  {"connection"},
+	"READWRITE": {"connection"},
+
+	"RAFT.INFO":      {"dangerous"},
+	"RAFT.INDEX":     {"connection"},
+	"RAFT.READAFTER": {"connection"},
+	"RAFT.APPLIED":   {"connection"},
+	"RAFT.TRANSFER":  {"admin", "dangerous"},
+	"RAFT.ADD":       {"admin", "dangerous"},
+	"RAFT.REMOVE":    {"admin", "dangerous"},
+	"RAFT.PROMOTE":   {"admin", "dangerous"},
+	"RAFT.DEMOTE":    {"admin", "dangerous"},
+	"RAFT.SNAPSHOT":  {"admin", "dangerous"},
+	"RAFT.DIGEST":    {"admin", "dangerous"},
+	"CDC.READ":       {"read", "keyspace", "dangerous"},
+	"CDC.COMMIT":     {"write", "keyspace", "dangerous"},
+	"CDC.COMMITTED":  {"read", "keyspace", "dangerous"},
+	"WAIT":           {"connection"},
+	"BGSAVE":         {"admin", "dangerous"},
+	"LASTSAVE":       {"admin", "dangerous"},
+
+	"INFO":        {"dangerous"},
+	"COMMAND":     {"connection"},
+	"CONFIG":      {"admin", "dangerous"},
+	"CLIENT":      {"connection"},
+	"CLIENT|LIST": {"admin", "connection", "dangerous"},
+	"CLIENT|KILL": {"admin", "connection", "dangerous"},
+	"MEMORY":      {"read"},
+	"DEBUG":       {"admin", "dangerous"},
+	"ACL":         {"admin", "dangerous"},
+	"TENANT":      {"admin", "dangerous"},
+	"ROLE":        {"admin", "dangerous"},
+	"REPLICAOF":   {"admin", "dangerous"},
+	"SLAVEOF":     {"admin", "dangerous"},
+	"ACL|WHOAMI":  {},
+	"ACL|CAT":     {},
+	"CLUSTER":     {},
+
+	"CLUSTER|FAILOVER": {"admin", "dangerous"},
+	"CLUSTER|MEET":     {"admin", "dangerous"},
+}
+
+// subcommandCmds are the commands ACL rules can name with a subcommand, as
+// in +client|id.
+var subcommandCmds = map[string]bool{
+	"CLIENT":  true,
+	"CONFIG":  true,
+	"MEMORY":  true,
+	"SCRIPT":  true,
+	"ACL":     true,
+	"CLUSTER": true,
+	"TENANT":  true,
+	"COMMAND": true,
+}
+
+func categoriesOf(name, sub string) []string {
+	if c, ok := cmdCategories[name+"|"+sub]; ok {
+		return c
+	}
+	return cmdCategories[name]
+}
+
+// cmdKeys returns the keys a command line accesses.
+func cmdKeys(name string, args [][]byte) [][]byte {
+	first, end, step := cmdKeyRange(name, args)
+	if step == 0 {
+		return nil
+	}
+	end = min(end, len(args))
+	keys := make([][]byte, 0, (end-first+step-1)/step)
+	for i := first; i < end; i += step {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+// cmdKeyRange returns the positions of the keys of a command line, as in
+// the key specs of Redis: every step-th argument from first up to end,
+// excluded. step is 0 when the command has no keys.
+func cmdKeyRange(name string, args [][]byte) (first, end, step int) {
+	switch name {
+	case "KEYS", "SCAN", "DBSIZE", "FLUSHDB", "FLUSHALL", "SWAPDB", "CDC.READ", "CDC.COMMIT", "CDC.COMMITTED":
+		return 0, 0, 0
+
+	case "DEL", "UNLINK", "EXISTS", "TOUCH", "MGET", "WATCH", "PFCOUNT", "PFMERGE":
+		return 1, len(args), 1
+
+	case "MSET":
+		return 1, len(args), 2
+
+	case "BITOP":
+		return 2, len(args), 1
+
+	case "RENAME", "RENAMENX", "COPY":
+		return 1, 3, 1
+
+	case "EVAL", "EVALSHA":
+		n, err := strconv.Atoi(string(args[2]))
+		if err != nil || n < 0 || n > len(args)-3 {
+			return 0, 0, 0
+		}
+		return 3, 3 + n, 1
+
+	case "MEMORY":
+		if len(args) > 2 && strings.EqualFold(string(args[1]), "USAGE") {
+			return 2, 3, 1
+		}
+		return 0, 0, 0
+
+	case "XREAD":
+		for i, a := range args {
+			if strings.EqualFold(string(a), "STREAMS") {
+				return i + 1, i + 1 + (len(args)-i-1)/2, 1
+			}
+		}
+		return 0, 0, 0
+	}
+
+	if c, ok := commands[name]; ok && c.keys != nil {
+		if c.keys.step == 0 {
+			return 0, 0, 0
+		}
+		last := c.keys.last
+		if last < 0 {
+			last += len(args)
+		}
+		return c.keys.first, last + 1, c.keys.step
+	}
+	c := cmdCategories[name]
+	if slices.Contains(c, "read") || slices.Contains(c, "write") {
+		return 1, 2, 1
+	}
+	return 0, 0, 0
+}
+
+// checkACL reports whether the user of the connection may run cmd and
+// access its keys.
+func (r *Redis) checkACL(st *connState, cmd redcon.Command) error {
+	name := commandOf(cmd)
+	if noAuthCmds[name] {
+		return nil
+	}
+	sub := ""
+	if subcommandCmds[name] && len(cmd.Args) > 1 {
+		sub = strings.ToUpper(string(cmd.Args[1]))
+	}
+
+	user := st.getUser()
+	u, ok := r.fsm.ACL().User(user)
+	if !ok || !u.CanRun(name, sub, categoriesOf(name, sub)) {
+		c := strings.ToLower(name)
+		if sub != "" {
+			c += "|" + strings.ToLower(sub)
+		}
+		return fmt.Errorf("NOPERM User %s has no permissions to run the '%s' command", user, c)
+	}
+	for _, k := range cmdKeys(name, cmd.Args) {
+		if !u.CanAccess(k) {
+			return errNoPermKey
+		}
+	}
+	return nil
+}
+
+// validateRules checks the rules of ACL SETUSER, including the commands
+// they name.
+func validateRules(rules []string) error {
+	if err := acl.Validate(rules); err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if rule[0] != '+' && rule[0] != '-' || rule[1] == '@' {
+			continue
+		}
+		name, sub, _ := strings.Cut(strings.ToUpper(rule[1:]), "|")
+		if _, ok := commands[name]; !ok || (sub != "" && !subcommandCmds[name]) {
+			return fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': Unknown command", rule)
+		}
+	}
+	return nil
+}
+
+// aclCmd handles ACL SETUSER, GETUSER, DELUSER, LIST, USERS, WHOAMI and
+// CAT. The users are part of the replicated state, so SETUSER and DELUSER
+// are redirected to the leader.
+func (r *Redis) aclCmd(conn redcon.Conn, cmd redcon.Command) {
+	a := r.fsm.ACL()
+	sub := strings.ToUpper(string(cmd.Args[1]))
+	switch {
+	case sub == "SETUSER" && len(cmd.Args) >= 3:
+		rules := make([]string, len(cmd.Args)-3)
+		for i, rule := range cmd.Args[3:] {
+			rules[i] = string(rule)
+		}
+		if err := validateRules(rules); err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		if r.moved(conn, r.shards[0], 0) {
+			return
+		}
+
+		kvCmd := &raft.KVCmd{Op: raft.ACLSetUser, Key: cmd.Args[2]}
+		for _, rule := range acl.HashPasswords(rules) {
+			kvCmd.Args = append(kvCmd.Args, []byte(rule))
+		}
+		if _, err := r.apply(conn, kvCmd); err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		conn.WriteString("OK")
+
+	case sub == "DELUSER" && len(cmd.Args) >= 3:
+		if r.moved(conn, r.shards[0], 0) {
+			return
+		}
+		res, err := r.apply(conn, &raft.KVCmd{Op: raft.ACLDelUser, Args: cmd.Args[2:]})
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		n, _ := res.(int)
+		conn.WriteInt(n)
+
+	case sub == "GETUSER" && len(cmd.Args) == 3:
+		u, ok := a.User(string(cmd.Args[2]))
+		if !ok {
+			conn.WriteNull()
+			return
+		}
+		writeUser(conn, u)
+
+	case sub == "LIST" && len(cmd.Args) == 2:
+		users := a.Users()
+		conn.WriteArray(len(users))
+		for _, u := range users {
+			conn.WriteBulkString(u.Rules())
+		}
+
+	case sub == "USERS" && len(cmd.Args) == 2:
+		users := a.Users()
+		conn.WriteArray(len(users))
+		for _, u := range users {
+			conn.WriteBulkString(u.Name)
+		}
+
+	case sub == "WHOAMI" && len(cmd.Args) == 2:
+		conn.WriteBulkString(stateOf(conn).getUser())
+
+	case sub == "CAT" && len(cmd.Args) == 2:
+		conn.WriteArray(len(acl.Categories))
+		for _, c := range acl.Categories {
+			conn.WriteBulkString(c)
+		}
+
+	case sub == "CAT" && len(cmd.Args) == 3:
+		cat := strings.ToLower(string(cmd.Args[2]))
+		if !slices.Contains(acl.Categories, cat) {
+			conn.WriteError("ERR Unknown category '" + string(cmd.Args[2]) + "'")
+			return
+		}
+		names := []string{}
+		for name := range commands {
+			if slices.Contains(cmdCategories[name], cat) {
+				names = append(names, strings.ToLower(name))
+			}
+		}
+		slices.Sort(names)
+		conn.WriteArray(len(names))
+		for _, n := range names {
+			conn.WriteBulkString(n)
+		}
+
+	case slices.Contains([]string{"SETUSER", "DELUSER", "GETUSER", "LIST", "USERS", "WHOAMI", "CAT"}, sub):
+		conn.WriteError("ERR wrong number of arguments for 'acl|" + strings.ToLower(sub) + "' command")
+
+	default:
+		conn.WriteError("ERR unknown subcommand '" + string(cmd.Args[1]) + "'. Try ACL HELP.")
+	}
+}
+
+// writeUser writes the reply of ACL GETUSER.
+func writeUser(conn redcon.Conn, u *acl.User) {
+	flags := []string{"off"}
+	if u.Enabled {
+		flags[0] = "on"
+	}
+	if u.NoPass {
+		flags = append(flags, "nopass")
+	}
+
+	keys := make([]string, len(u.Keys))
+	for i, k := range u.Keys {
+		keys[i] = "~" + k
+	}
+	commands := strings.Join(u.Commands, " ")
+	if commands == "" {
+		commands = "-@all"
+	}
+
+	writeMap(conn, 4)
+	conn.WriteBulkString("flags")
+	conn.WriteArray(len(flags))
+	for _, f := range flags {
+		conn.WriteBulkString(f)
+	}
+	conn.WriteBulkString("passwords")
+	conn.WriteArray(len(u.Passwords))
+	for _, p := range u.Passwords {
+		conn.WriteBulkString(p)
+	}
+	conn.WriteBulkString("commands")
+	conn.WriteBulkString(commands)
+	conn.WriteBulkString("keys")
+	conn.WriteBulkString(strings.Join(keys, " "))
+}
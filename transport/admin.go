@@ -0,0 +1,314 @@
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	hraft "github.com/hashicorp/raft"
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/acl"
+	"raft-redis-cluster/store"
+)
+
+// errBadRequest is returned for requests to the admin API that don't parse.
+var errBadRequest = errors.New("ERR invalid request")
+
+// AdminHandler returns the HTTP admin API, which runs the cluster operations
+// of the RAFT.* commands for tooling that doesn't speak RESP:
+//
+//	POST /join      {"id", "raft_address", "redis_address", "advertise_address", "nonvoter", "shard"}
+//	POST /remove    {"id", "shard"}
+//	POST /snapshot  {"shard"}
+//	GET  /status
+//	GET  /leader
+//	GET  /health
+//
+// Without "shard", every shard is changed. Requests authenticate with HTTP
+// basic authentication as an ACL user, which must be allowed to run the
+// matching command, when clients must authenticate. /health is open to
+// anyone, for the probes of load balancers.
+func (r *Redis) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /join", r.adminHandler("RAFT.ADD", r.adminJoin))
+	mux.HandleFunc("POST /remove", r.adminHandler("RAFT.REMOVE", r.adminRemove))
+	mux.HandleFunc("POST /snapshot", r.adminHandler("RAFT.SNAPSHOT", r.adminSnapshot))
+	mux.HandleFunc("GET /status", r.adminHandler("INFO", r.adminStatus))
+	mux.HandleFunc("GET /leader", r.adminHandler("CLUSTER", r.adminLeader))
+	mux.HandleFunc("GET /health", r.adminHealth)
+	return mux
+}
+
+// adminHealth replies 200 while the node takes clients, and 503 while it
+// loads its keys or catches up with the leaders, as PING does, and once it
+// is draining for a shutdown.
+func (r *Redis) adminHealth(w http.ResponseWriter, req *http.Request) {
+	if r.draining.Load() {
+		writeAdminJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "draining"})
+		return
+	}
+	if !r.allReady() {
+		writeAdminJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "loading"})
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// adminFunc serves a request of the admin API as the client of st, and
+// returns the value to reply with as JSON.
+type adminFunc func(st *connState, req *http.Request) (any, error)
+
+// adminHandler authenticates the requests of an endpoint, checks that the
+// user may run name and writes the reply of fn.
+func (r *Redis) adminHandler(name string, fn adminFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		user, pass, ok := req.BasicAuth()
+		st, err := r.adminLogin(user, pass, ok, name)
+		if err != nil {
+			code := http.StatusForbidden
+			if errors.Is(err, errWrongPass) || errors.Is(err, errNoAuth) {
+				w.Header().Set("WWW-Authenticate", `Basic realm="raft-redis-cluster"`)
+				code = http.StatusUnauthorized
+			}
+			writeAdminError(w, code, err)
+			return
+		}
+
+		res, err := fn(st, req)
+		switch {
+		case errors.Is(err, errBadRequest):
+			writeAdminError(w, http.StatusBadRequest, err)
+		case err != nil:
+			writeAdminError(w, http.StatusInternalServerError, err)
+		default:
+			writeAdminJSON(w, http.StatusOK, res)
+		}
+	}
+}
+
+// adminLogin returns the state of a client of the admin APIs that sent the
+// credentials user and pass, if ok, after checking that it may run the
+// command args.
+func (r *Redis) adminLogin(user, pass string, ok bool, args ...string) (*connState, error) {
+	st := &connState{user: acl.DefaultUser}
+	switch {
+	case ok:
+		if !r.authenticate(user, pass) {
+			return nil, errWrongPass
+		}
+		st.login(user, pass)
+	case r.passwordRequired():
+		return nil, errNoAuth
+	}
+	cmd := redcon.Command{Args: make([][]byte, len(args))}
+	for i, arg := range args {
+		cmd.Args[i] = []byte(arg)
+	}
+	if err := r.checkACL(st, cmd); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func writeAdminJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAdminError(w http.ResponseWriter, code int, err error) {
+	writeAdminJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// decodeAdmin decodes the JSON body of req to v. An empty body leaves v as
+// is.
+func decodeAdmin(req *http.Request, v any) error {
+	err := json.NewDecoder(req.Body).Decode(v)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: %v", errBadRequest, err)
+	}
+	return nil
+}
+
+// adminShards returns the shard shard, or every shard when it is nil.
+func (r *Redis) adminShards(shard *int) ([]int, error) {
+	if shard == nil {
+		return r.allShards(), nil
+	}
+	i, err := r.parseShard([]byte(strconv.Itoa(*shard)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadRequest, err)
+	}
+	return []int{i}, nil
+}
+
+// adminOK is the reply of the endpoints that change the cluster.
+var adminOK = map[string]string{"result": "OK"}
+
+func (r *Redis) adminJoin(st *connState, req *http.Request) (any, error) {
+	var body struct {
+		ID           string `json:"id"`
+		RaftAddress  string `json:"raft_address"`
+		RedisAddress string `json:"redis_address"`
+		Advertise    string `json:"advertise_address"`
+		Nonvoter     bool   `json:"nonvoter"`
+		Shard        *int   `json:"shard"`
+	}
+	if err := decodeAdmin(req, &body); err != nil {
+		return nil, err
+	}
+	if body.ID == "" || body.RaftAddress == "" || body.RedisAddress == "" {
+		return nil, fmt.Errorf("%w: id, raft_address and redis_address are required", errBadRequest)
+	}
+	shards, err := r.adminShards(body.Shard)
+	if err != nil {
+		return nil, err
+	}
+	m := newServer{id: hraft.ServerID(body.ID), raftAddr: body.RaftAddress, redisAddr: body.RedisAddress, advertiseAddr: body.Advertise, nonvoter: body.Nonvoter}
+	if err := r.addMember(st, m, shards); err != nil {
+		return nil, err
+	}
+	return adminOK, nil
+}
+
+func (r *Redis) adminRemove(st *connState, req *http.Request) (any, error) {
+	var body struct {
+		ID    string `json:"id"`
+		Shard *int   `json:"shard"`
+	}
+	if err := decodeAdmin(req, &body); err != nil {
+		return nil, err
+	}
+	if body.ID == "" {
+		return nil, fmt.Errorf("%w: id is required", errBadRequest)
+	}
+	shards, err := r.adminShards(body.Shard)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.removeMember(st, hraft.ServerID(body.ID), shards); err != nil {
+		return nil, err
+	}
+	return adminOK, nil
+}
+
+func (r *Redis) adminSnapshot(_ *connState, req *http.Request) (any, error) {
+	var body struct {
+		Shard *int `json:"shard"`
+	}
+	if err := decodeAdmin(req, &body); err != nil {
+		return nil, err
+	}
+	shards, err := r.adminShards(body.Shard)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.snapshot(shards); err != nil {
+		return nil, err
+	}
+	return adminOK, nil
+}
+
+// adminServer is a server of a shard, as reported by /status.
+type adminServer struct {
+	ID           string `json:"id"`
+	RaftAddress  string `json:"raft_address"`
+	RedisAddress string `json:"redis_address,omitempty"`
+	Suffrage     string `json:"suffrage"`
+}
+
+// adminShard is the state of a shard on this node, as reported by /status.
+type adminShard struct {
+	Index         int           `json:"index"`
+	Slots         []string      `json:"slots"`
+	State         string        `json:"state"`
+	Term          uint64        `json:"term"`
+	LeaderID      string        `json:"leader_id"`
+	LastLogIndex  uint64        `json:"last_log_index"`
+	CommitIndex   uint64        `json:"commit_index"`
+	AppliedIndex  uint64        `json:"applied_index"`
+	SnapshotIndex uint64        `json:"last_snapshot_index"`
+	Servers       []adminServer `json:"servers"`
+}
+
+func (r *Redis) adminStatus(*connState, *http.Request) (any, error) {
+	shards, err := r.shardStatus()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"id": r.id, "shards": shards}, nil
+}
+
+// shardStatus returns the state of every shard on this node.
+func (r *Redis) shardStatus() ([]adminShard, error) {
+	ranges := r.slotRanges()
+	shards := make([]adminShard, len(r.shards))
+	for i, sh := range r.shards {
+		stats := sh.Raft.Stats()
+		_, lid := sh.Raft.LeaderWithID()
+		s := adminShard{
+			Index:         i,
+			Slots:         make([]string, len(ranges[i])),
+			State:         sh.Raft.State().String(),
+			Term:          statOf(stats, "term"),
+			LeaderID:      string(lid),
+			LastLogIndex:  sh.Raft.LastIndex(),
+			CommitIndex:   sh.Raft.CommitIndex(),
+			AppliedIndex:  sh.Raft.AppliedIndex(),
+			SnapshotIndex: statOf(stats, "last_snapshot_index"),
+			Servers:       []adminServer{},
+		}
+		for j, rg := range ranges[i] {
+			s.Slots[j] = formatRange(rg)
+		}
+		f := sh.Raft.GetConfiguration()
+		if err := f.Error(); err != nil {
+			return nil, err
+		}
+		for _, srv := range f.Configuration().Servers {
+			addr, _ := store.GetAdvertiseAddrByNodeID(r.stableStore, srv.ID)
+			s.Servers = append(s.Servers, adminServer{
+				ID:           string(srv.ID),
+				RaftAddress:  string(srv.Address),
+				RedisAddress: addr,
+				Suffrage:     srv.Suffrage.String(),
+			})
+		}
+		shards[i] = s
+	}
+	return shards, nil
+}
+
+// statOf returns the number stat of the Raft stats.
+func statOf(stats map[string]string, stat string) uint64 {
+	n, _ := strconv.ParseUint(stats[stat], 10, 64)
+	return n
+}
+
+// adminLeader is the leader of a shard, as reported by /leader.
+type adminLeader struct {
+	Shard        int    `json:"shard"`
+	ID           string `json:"id"`
+	RaftAddress  string `json:"raft_address"`
+	RedisAddress string `json:"redis_address"`
+}
+
+func (r *Redis) adminLeader(*connState, *http.Request) (any, error) {
+	leaders := make([]adminLeader, len(r.shards))
+	for i, sh := range r.shards {
+		addr, lid := sh.Raft.LeaderWithID()
+		if lid == "" {
+			return nil, errClusterDown
+		}
+		redisAddr, err := store.GetAdvertiseAddrByNodeID(r.stableStore, lid)
+		if err != nil {
+			return nil, err
+		}
+		leaders[i] = adminLeader{Shard: i, ID: string(lid), RaftAddress: string(addr), RedisAddress: redisAddr}
+	}
+	return map[string]any{"leaders": leaders}, nil
+}
@@ -0,0 +1,64 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"raft-redis-cluster/store"
+)
+
+var errBusy = errors.New("BUSY the write queue is full, try again later")
+
+// admitPoll is how often a write waiting for admission checks the queue.
+const admitPoll = time.Millisecond
+
+// admit reserves a place for a client write in the queue of sh. While sh
+// holds max-pending-writes writes waiting to commit, or its log is more than
+// max-apply-backlog entries ahead of what its state machine applied, the write
+// waits up to busy-wait milliseconds for the queue to drain and then fails
+// with errBusy, so a backed up shard refuses the excess writes instead of
+// letting all of them time out. The caller must call done when the write
+// returns. A write stops waiting once ctx is done.
+func (r *Redis) admit(ctx context.Context, sh *Shard) error {
+	deadline := time.Now().Add(time.Duration(r.busyWait.Load()) * time.Millisecond)
+	for {
+		if r.admissible(sh) {
+			sh.pendingWrites.Add(1)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return store.ContextErr(ctx)
+		}
+		if !time.Now().Before(deadline) {
+			r.rejectedWrites.Add(1)
+			return errBusy
+		}
+		time.Sleep(admitPoll)
+	}
+}
+
+func (r *Redis) admissible(sh *Shard) bool {
+	if n := r.maxPendingWrites.Load(); n > 0 && sh.pendingWrites.Load() >= n {
+		return false
+	}
+	if n := r.maxApplyBacklog.Load(); n > 0 && applyBacklog(sh) > uint64(n) {
+		return false
+	}
+	return true
+}
+
+// done releases the place of a write taken by admit.
+func (sh *Shard) done() {
+	sh.pendingWrites.Add(-1)
+}
+
+// applyBacklog returns how many entries of the log of sh its state machine
+// has yet to apply.
+func applyBacklog(sh *Shard) uint64 {
+	last, applied := sh.Raft.LastIndex(), sh.Raft.AppliedIndex()
+	if last < applied {
+		return 0
+	}
+	return last - applied
+}
@@ -0,0 +1,70 @@
+package transport
+
+import (
+	"crypto/subtle"
+	"errors"
+
+	"github.com/tidwall/redcon"
+	"raft-redis-cluster/acl"
+)
+
+var (
+	errNoAuth    = errors.New("NOAUTH Authentication required.")
+	errWrongPass = errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+)
+
+// noAuthCmds may be sent before the connection is authenticated.
+var noAuthCmds = map[string]bool{
+	"AUTH":  true,
+	"HELLO": true,
+	"QUIT":  true,
+}
+
+// passwordRequired reports whether new connections must authenticate,
+// either because requirepass is set or because the default user of the ACL
+// is disabled or has a password.
+func (r *Redis) passwordRequired() bool {
+	if r.requirepass.Load().(string) != "" {
+		return true
+	}
+	u, ok := r.fsm.ACL().User(acl.DefaultUser)
+	return !ok || !u.Enabled || !u.NoPass
+}
+
+// authenticate checks the credentials of AUTH and HELLO. requirepass, which
+// is local to the node, takes the place of the ACL passwords of the default
+// user.
+func (r *Redis) authenticate(user, pass string) bool {
+	u, ok := r.fsm.ACL().User(user)
+	if !ok {
+		return false
+	}
+	if want := r.requirepass.Load().(string); user == acl.DefaultUser && want != "" {
+		return u.Enabled && subtle.ConstantTimeCompare([]byte(pass), []byte(want)) == 1
+	}
+	return u.CheckPassword(pass)
+}
+
+// auth handles AUTH [username] password.
+func (r *Redis) auth(conn redcon.Conn, cmd redcon.Command) {
+	user, pass := acl.DefaultUser, string(cmd.Args[1])
+	switch len(cmd.Args) {
+	case 2:
+		if !r.passwordRequired() {
+			conn.WriteError("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+			return
+		}
+	case 3:
+		user, pass = string(cmd.Args[1]), string(cmd.Args[2])
+	default:
+		conn.WriteError(errSyntax.Error())
+		return
+	}
+
+	if !r.authenticate(user, pass) {
+		conn.WriteError(errWrongPass.Error())
+		return
+	}
+	stateOf(conn).login(user, pass)
+	conn.WriteString("OK")
+}
@@ -0,0 +1,127 @@
+package transport
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"raft-redis-cluster/raft"
+	"raft-redis-cluster/store"
+)
+
+// defaultWriteBatchMax is how many writes a Batch holds at most by default.
+const defaultWriteBatchMax = 128
+
+// batcher collects the writes to a shard waiting for the next Batch entry.
+type batcher struct {
+	mu      sync.Mutex
+	pending []*batchedCmd
+}
+
+// batchedCmd is a write waiting in a batcher, and its result once the
+// Batch is applied.
+type batchedCmd struct {
+	cmd   *raft.KVCmd
+	res   any
+	index uint64
+	err   error
+	done  chan struct{}
+}
+
+// batchable reports whether cmd may share a log entry with the writes of
+// other clients. Only SET and DEL are, as their results don't depend on
+// anything but their own key.
+func batchable(cmd *raft.KVCmd) bool {
+	return cmd.Op == raft.Put || cmd.Op == raft.Del
+}
+
+// applyBatched replicates cmd like applyAt. While write-batch-window is set,
+// a SET or DEL waits up to the window for other ones to sh and is committed
+// with them in a single Batch entry, trading latency for fewer consensus
+// rounds under many concurrent writes. A write abandoned once ctx is done
+// is taken out of the batch if it is still waiting for it. A value written
+// in chunks is applied on its own.
+func (r *Redis) applyBatched(ctx context.Context, sh *Shard, cmd *raft.KVCmd) (any, uint64, error) {
+	window := time.Duration(r.writeBatchWindow.Load()) * time.Microsecond
+	if window == 0 || !batchable(cmd) || r.chunked(cmd) {
+		return r.applyAt(ctx, sh, cmd)
+	}
+
+	c := &batchedCmd{cmd: cmd, done: make(chan struct{})}
+	b := &sh.batch
+	b.mu.Lock()
+	b.pending = append(b.pending, c)
+	var full []*batchedCmd
+	switch n := len(b.pending); {
+	case int64(n) >= r.writeBatchMax.Load():
+		full, b.pending = b.pending, nil
+	case n == 1:
+		time.AfterFunc(window, func() { r.flushBatch(sh) })
+	}
+	b.mu.Unlock()
+
+	if full != nil {
+		r.commitBatch(sh, full)
+	}
+	select {
+	case <-c.done:
+		return c.res, c.index, c.err
+	case <-ctx.Done():
+		b.mu.Lock()
+		b.pending = slices.DeleteFunc(b.pending, func(p *batchedCmd) bool { return p == c })
+		b.mu.Unlock()
+		return nil, 0, store.ContextErr(ctx)
+	}
+}
+
+// flushBatch commits the writes waiting for sh when the window ends.
+func (r *Redis) flushBatch(sh *Shard) {
+	b := &sh.batch
+	b.mu.Lock()
+	cmds := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+
+	if len(cmds) > 0 {
+		r.commitBatch(sh, cmds)
+	}
+}
+
+// commitBatch replicates cmds as one Batch entry and hands each its result.
+// The entry is applied on behalf of all of them, whichever gave up waiting.
+func (r *Redis) commitBatch(sh *Shard, cmds []*batchedCmd) {
+	defer func() {
+		for _, c := range cmds {
+			close(c.done)
+		}
+	}()
+
+	if len(cmds) == 1 {
+		c := cmds[0]
+		c.res, c.index, c.err = r.applyAt(context.Background(), sh, c.cmd)
+		return
+	}
+
+	batch := &raft.KVCmd{Op: raft.Batch, Cmds: make([]raft.KVCmd, len(cmds))}
+	for i, c := range cmds {
+		batch.Cmds[i] = *c.cmd
+	}
+	res, index, err := r.applyAt(context.Background(), sh, batch)
+	results, _ := res.([]any)
+	for i, c := range cmds {
+		c.index = index
+		switch {
+		case err != nil:
+			c.err = err
+		case i >= len(results):
+			c.err = raft.ErrUnknownOp
+		default:
+			c.res = results[i]
+			c.err, _ = c.res.(error)
+			if c.err != nil {
+				c.res = nil
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/raft"
+	"raft-redis-cluster/store"
+)
+
+// setbit handles SETBIT key offset value.
+func (r *Redis) setbit(conn redcon.Conn, cmd redcon.Command) {
+	offset, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+	if err != nil || offset < 0 || offset > raft.MaxBitOffset {
+		conn.WriteError(raft.ErrBitOffset.Error())
+		return
+	}
+	bit := string(cmd.Args[3])
+	if bit != "0" && bit != "1" {
+		conn.WriteError("ERR bit is not an integer or out of range")
+		return
+	}
+
+	kvCmd := &raft.KVCmd{
+		Op:     raft.SetBit,
+		Key:    cmd.Args[keyName],
+		Offset: offset,
+		Val:    cmd.Args[3],
+	}
+	res, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	n, _ := res.(int)
+	conn.WriteInt(n)
+}
+
+// getbit handles GETBIT key offset.
+func (r *Redis) getbit(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	offset, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+	if err != nil || offset < 0 || offset > raft.MaxBitOffset {
+		conn.WriteError(raft.ErrBitOffset.Error())
+		return
+	}
+
+	val, err := r.store.Get(ctx, cmd.Args[keyName])
+	if err != nil && !errors.Is(err, store.ErrKeyNotFound) {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	i := offset >> 3
+	if i >= int64(len(val)) {
+		conn.WriteInt(0)
+		return
+	}
+	conn.WriteInt(int(val[i]>>(7-uint(offset&7))) & 1)
+}
+
+// bitcount handles BITCOUNT key [start end [BYTE | BIT]].
+func (r *Redis) bitcount(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) == 3 || len(cmd.Args) > 5 {
+		conn.WriteError(errSyntax.Error())
+		return
+	}
+
+	val, err := r.store.Get(ctx, cmd.Args[keyName])
+	if err != nil && !errors.Is(err, store.ErrKeyNotFound) {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	if len(cmd.Args) == 2 {
+		conn.WriteInt(countBits(val, 0, len(val)*8-1))
+		return
+	}
+
+	start, err1 := strconv.Atoi(string(cmd.Args[2]))
+	end, err2 := strconv.Atoi(string(cmd.Args[3]))
+	if err1 != nil || err2 != nil {
+		conn.WriteError(errNotInteger.Error())
+		return
+	}
+
+	unit := 8
+	if len(cmd.Args) == 5 {
+		switch strings.ToUpper(string(cmd.Args[4])) {
+		case "BYTE":
+		case "BIT":
+			unit = 1
+		default:
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+	}
+
+	n := len(val) * 8 / unit
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if end < 0 {
+		end = max(n+end, 0)
+	}
+	end = min(end, n-1)
+	if start > end {
+		conn.WriteInt(0)
+		return
+	}
+	conn.WriteInt(countBits(val, start*unit, end*unit+unit-1))
+}
+
+// countBits returns the number of set bits between the bit offsets from and
+// to, inclusive.
+func countBits(b []byte, from, to int) int {
+	n := 0
+	for i := from; i <= to; {
+		if i&7 == 0 && i+7 <= to {
+			n += bits.OnesCount8(b[i>>3])
+			i += 8
+			continue
+		}
+		n += int(b[i>>3]>>(7-uint(i&7))) & 1
+		i++
+	}
+	return n
+}
+
+// bitop handles BITOP AND | OR | XOR | NOT destkey key [key ...].
+func (r *Redis) bitop(conn redcon.Conn, cmd redcon.Command) {
+	op := strings.ToUpper(string(cmd.Args[1]))
+	srcs := cmd.Args[3:]
+	switch op {
+	case raft.BitAnd, raft.BitOr, raft.BitXor:
+	case raft.BitNot:
+		if len(srcs) != 1 {
+			conn.WriteError("ERR BITOP NOT must be called with a single source key.")
+			return
+		}
+	default:
+		conn.WriteError(errSyntax.Error())
+		return
+	}
+
+	kvCmd := &raft.KVCmd{
+		Op:   raft.BitOp,
+		Key:  cmd.Args[2],
+		Val:  []byte(op),
+		Args: srcs,
+	}
+	res, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	n, _ := res.(int)
+	conn.WriteInt(n)
+}
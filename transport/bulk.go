@@ -0,0 +1,125 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/raft"
+)
+
+// defaultProtoMaxBulkLen is the longest argument a command may have by
+// default, and defaultRaftChunkSize the largest value written in a single
+// log entry.
+const (
+	defaultProtoMaxBulkLen = raft.MaxStringLen
+	defaultRaftChunkSize   = 1 << 20
+)
+
+// streamBulkMin is the size from which a bulk reply is written straight to
+// the connection, streamChunk bytes at a time, rather than copied whole
+// into the reply buffer of redcon.
+const (
+	streamBulkMin = 1 << 20
+	streamChunk   = 64 << 10
+)
+
+var errBulkTooLong = errors.New("ERR argument exceeds maximum allowed size (proto-max-bulk-len)")
+
+// checkBulkLen checks that no argument of cmd is longer than
+// proto-max-bulk-len.
+func (r *Redis) checkBulkLen(cmd redcon.Command) error {
+	limit := r.protoMaxBulkLen.Load()
+	for _, arg := range cmd.Args {
+		if int64(len(arg)) > limit {
+			return errBulkTooLong
+		}
+	}
+	return nil
+}
+
+// chunkable reports whether the value of cmd may be written in chunks:
+// those of the commands that write Val whole, which the FSM rebuilds ahead
+// of applying them.
+func chunkable(cmd *raft.KVCmd) bool {
+	switch cmd.Op {
+	case raft.Put, raft.Append, raft.SetRange, raft.RestoreKey:
+		return true
+	}
+	return false
+}
+
+// overChunk reports whether a value of n bytes is too large for a single
+// log entry.
+func (r *Redis) overChunk(n int) bool {
+	size := r.raftChunkSize.Load()
+	return size > 0 && int64(n) > size
+}
+
+// chunked reports whether cmd is written in chunks by applyChunked.
+func (r *Redis) chunked(cmd *raft.KVCmd) bool {
+	return chunkable(cmd) && r.overChunk(len(cmd.Val))
+}
+
+// applyChunked replicates cmd, whose value is larger than raft-chunk-size,
+// as Chunk entries of raft-chunk-size bytes staging the head of the value
+// in the FSM, followed by cmd with the rest of it. The entries are applied
+// one at a time; when one fails, the staged chunks are left for the FSM to
+// drop. The command is then failed, as the chunks are not retried.
+func (r *Redis) applyChunked(ctx context.Context, sh *Shard, cmd *raft.KVCmd) (any, uint64, error) {
+	size := int(r.raftChunkSize.Load())
+	id := fmt.Sprintf("%s-%d", r.id, r.uploadSeq.Add(1))
+	off := 0
+	for ; len(cmd.Val)-off > size; off += size {
+		chunk := &raft.KVCmd{
+			Op:     raft.Chunk,
+			Upload: id,
+			Offset: int64(off),
+			Val:    cmd.Val[off : off+size],
+			Trace:  cmd.Trace,
+		}
+		if _, _, err := r.applyAt(ctx, sh, chunk); err != nil {
+			return nil, 0, err
+		}
+	}
+	last := *cmd
+	last.Upload, last.Val = id, cmd.Val[off:]
+	return r.applyAt(ctx, sh, &last)
+}
+
+// writeBulk writes val as the bulk reply of conn. A value of streamBulkMin
+// bytes or more is streamed to the client when nothing is left in the reply
+// buffer ahead of it, which redcon only flushes after the last command the
+// client pipelined. The replies collected by transactions, scripts and
+// detached connections are always buffered.
+func (r *Redis) writeBulk(conn redcon.Conn, val []byte) {
+	st := stateOf(conn)
+	if len(val) < streamBulkMin || conn != st.conn || !st.flushed {
+		conn.WriteBulk(val)
+		return
+	}
+
+	nc := conn.NetConn()
+	write := func(b []byte) bool {
+		nc.SetWriteDeadline(r.writeDeadline())
+		_, err := nc.Write(b)
+		return err == nil
+	}
+	head := strconv.AppendInt([]byte{'$'}, int64(len(val)), 10)
+	if !write(append(head, '\r', '\n')) {
+		conn.Close()
+		return
+	}
+	for off := 0; off < len(val); off += streamChunk {
+		if !write(val[off:min(off+streamChunk, len(val))]) {
+			conn.Close()
+			return
+		}
+	}
+	if !write([]byte("\r\n")) {
+		conn.Close()
+	}
+}
@@ -0,0 +1,191 @@
+package transport
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	hraft "github.com/hashicorp/raft"
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/raft"
+	"raft-redis-cluster/store"
+)
+
+// cdcRead handles CDC.READ index [COUNT count] [BLOCK milliseconds]
+// [SHARD shard], which replies with the changes of the keys of the shard
+// applied by this node after the log index, oldest first. Each change is a
+// map of its index, term, time in Unix milliseconds, op, database, key,
+// value and deleted flag; the key of a FLUSHALL is null, as is the value of a key
+// that is not a string. An index of 0 reads from the oldest change kept.
+// As in XREAD, BLOCK waits for a change when there is none yet and the
+// reply is null when none came, or until the connection is closed.
+func (r *Redis) cdcRead(conn redcon.Conn, cmd redcon.Command) {
+	after, err := strconv.ParseUint(string(cmd.Args[1]), 10, 64)
+	if err != nil {
+		conn.WriteError(errNotInteger.Error())
+		return
+	}
+	count := -1
+	block := time.Duration(-1)
+	sh := r.shards[0]
+	for i := 2; i < len(cmd.Args); i += 2 {
+		if i+1 >= len(cmd.Args) {
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+		opt := strings.ToUpper(string(cmd.Args[i]))
+		if opt == "SHARD" {
+			j, err := r.parseShard(cmd.Args[i+1])
+			if err != nil {
+				conn.WriteError(err.Error())
+				return
+			}
+			sh = r.shards[j]
+			continue
+		}
+		n, err := strconv.Atoi(string(cmd.Args[i+1]))
+		if err != nil || n < 0 {
+			conn.WriteError(errNotInteger.Error())
+			return
+		}
+		switch opt {
+		case "COUNT":
+			count = n
+		case "BLOCK":
+			block = time.Duration(n) * time.Millisecond
+		default:
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+	}
+
+	// A transaction never blocks, as in Redis.
+	if _, ok := conn.(*txConn); ok {
+		block = -1
+	}
+
+	var timeout <-chan time.Time
+	if block > 0 {
+		t := time.NewTimer(block)
+		defer t.Stop()
+		timeout = t.C
+	}
+	for {
+		changes, added, err := sh.FSM.ReadChanges(after, count)
+		if err != nil {
+			if errors.Is(err, raft.ErrChangesTrimmed) {
+				conn.WriteError("ERR changes after index " + strconv.FormatUint(after, 10) + " are no longer kept, read the keys again")
+				return
+			}
+			conn.WriteError(err.Error())
+			return
+		}
+		if len(changes) > 0 {
+			writeChanges(conn, changes)
+			return
+		}
+		if block < 0 {
+			conn.WriteNull()
+			return
+		}
+		select {
+		case <-added:
+		case <-timeout:
+			conn.WriteNull()
+			return
+		case <-stateOf(conn).context().Done():
+			conn.WriteError(store.ErrAborted.Error())
+			return
+		}
+	}
+}
+
+// cdcCommit handles CDC.COMMIT name index [SHARD shard], which records the
+// index as the last change of the shard processed by the consumer named
+// name. The checkpoint is replicated through the log of the shard, so this
+// node must lead it.
+func (r *Redis) cdcCommit(conn redcon.Conn, cmd redcon.Command) {
+	if _, err := strconv.ParseUint(string(cmd.Args[2]), 10, 64); err != nil {
+		conn.WriteError(errNotInteger.Error())
+		return
+	}
+	sh, ok := r.cdcShard(conn, cmd.Args[3:])
+	if !ok {
+		return
+	}
+	if sh.Raft.State() != hraft.Leader {
+		conn.WriteError("ERR This node must lead shard " + strconv.Itoa(sh.index) + " to commit its changes")
+		return
+	}
+	kvCmd := &raft.KVCmd{Op: raft.Checkpoint, Key: cmd.Args[1], Val: cmd.Args[2]}
+	if _, err := r.applyTo(sh, kvCmd); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteString("OK")
+}
+
+// cdcCommitted handles CDC.COMMITTED name [SHARD shard], which replies with
+// the index last committed by the consumer named name, 0 when none was.
+func (r *Redis) cdcCommitted(conn redcon.Conn, cmd redcon.Command) {
+	sh, ok := r.cdcShard(conn, cmd.Args[2:])
+	if !ok {
+		return
+	}
+	conn.WriteInt64(int64(sh.FSM.Checkpoint(string(cmd.Args[1]))))
+}
+
+// cdcShard parses the optional SHARD shard of the CDC commands, the first
+// shard when it is missing.
+func (r *Redis) cdcShard(conn redcon.Conn, args [][]byte) (*Shard, bool) {
+	switch {
+	case len(args) == 0:
+		return r.shards[0], true
+	case len(args) != 2 || !strings.EqualFold(string(args[0]), "SHARD"):
+		conn.WriteError(errSyntax.Error())
+		return nil, false
+	}
+	i, err := r.parseShard(args[1])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return nil, false
+	}
+	return r.shards[i], true
+}
+
+func writeChanges(conn redcon.Conn, changes []raft.Change) {
+	conn.WriteArray(len(changes))
+	for _, c := range changes {
+		writeMap(conn, 8)
+		conn.WriteBulkString("index")
+		conn.WriteInt64(int64(c.Index))
+		conn.WriteBulkString("term")
+		conn.WriteInt64(int64(c.Term))
+		conn.WriteBulkString("time")
+		conn.WriteInt64(c.Time.UnixMilli())
+		conn.WriteBulkString("op")
+		conn.WriteBulkString(c.Op)
+		conn.WriteBulkString("db")
+		conn.WriteInt(c.DB)
+		conn.WriteBulkString("key")
+		if c.Key == nil {
+			conn.WriteNull()
+		} else {
+			conn.WriteBulk(c.Key)
+		}
+		conn.WriteBulkString("value")
+		if c.Value == nil {
+			conn.WriteNull()
+		} else {
+			conn.WriteBulk(c.Value)
+		}
+		conn.WriteBulkString("deleted")
+		if c.Deleted {
+			conn.WriteInt(1)
+		} else {
+			conn.WriteInt(0)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package transport
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/redcon"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var errClientName = errors.New("ERR Client names cannot contain spaces, newlines or special characters.")
+
+// validClientName reports whether name may be set with CLIENT SETNAME.
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] <= ' ' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// client handles CLIENT ID, CLIENT INFO, CLIENT LIST, CLIENT KILL, CLIENT
+// SETNAME, CLIENT GETNAME, CLIENT SETINFO and CLIENT CONSISTENCY [level |
+// DEFAULT].
+func (r *Redis) client(conn redcon.Conn, cmd redcon.Command) {
+	sub := strings.ToUpper(string(cmd.Args[1]))
+	st := stateOf(conn)
+
+	switch {
+	case sub == "ID" && len(cmd.Args) == 2:
+		conn.WriteInt64(st.id)
+
+	case sub == "INFO" && len(cmd.Args) == 2:
+		conn.WriteBulkString(st.describe(time.Now()))
+
+	case sub == "LIST":
+		r.clientList(conn, cmd)
+
+	case sub == "KILL" && len(cmd.Args) >= 3:
+		r.clientKill(conn, st, cmd)
+
+	case sub == "SETNAME" && len(cmd.Args) == 3:
+		name := string(cmd.Args[2])
+		if !validClientName(name) {
+			conn.WriteError(errClientName.Error())
+			return
+		}
+		st.setName(name)
+		conn.WriteString("OK")
+
+	case sub == "SETINFO" && len(cmd.Args) == 4:
+		if err := st.setInfo(string(cmd.Args[2]), string(cmd.Args[3])); err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		conn.WriteString("OK")
+
+	case sub == "GETNAME" && len(cmd.Args) == 2:
+		if name := st.getName(); name != "" {
+			conn.WriteBulkString(name)
+		} else {
+			conn.WriteNull()
+		}
+
+	case sub == "CONSISTENCY" && len(cmd.Args) == 2:
+		conn.WriteBulkString(st.consistency.String())
+
+	case sub == "CONSISTENCY" && len(cmd.Args) == 3:
+		c := consistencyDefault
+		if v := string(cmd.Args[2]); !strings.EqualFold(v, "default") {
+			var err error
+			if c, err = parseConsistency(v); err != nil {
+				conn.WriteError(err.Error())
+				return
+			}
+		}
+		st.consistency = c
+		conn.WriteString("OK")
+
+	case sub == "TRACKING" && len(cmd.Args) >= 3:
+		r.clientTracking(conn, st, cmd)
+
+	case sub == "CACHING" && len(cmd.Args) == 3:
+		r.clientCaching(conn, st, cmd)
+
+	case sub == "GETREDIR" && len(cmd.Args) == 2:
+		r.clientGetRedir(conn, st)
+
+	case sub == "TRACKINGINFO" && len(cmd.Args) == 2:
+		r.clientTrackingInfo(conn, st)
+
+	case sub == "ID" || sub == "INFO" || sub == "KILL" || sub == "SETNAME" || sub == "GETNAME" || sub == "SETINFO" || sub == "CONSISTENCY" ||
+		sub == "TRACKING" || sub == "CACHING" || sub == "GETREDIR" || sub == "TRACKINGINFO":
+		conn.WriteError("ERR wrong number of arguments for 'client|" + strings.ToLower(sub) + "' command")
+
+	default:
+		conn.WriteError("ERR unknown subcommand '" + string(cmd.Args[1]) + "'. Try CLIENT HELP.")
+	}
+}
+
+// setInfo handles CLIENT SETINFO LIB-NAME name | LIB-VER version |
+// TRACEPARENT traceparent | TRACESTATE tracestate. The W3C trace context
+// makes the spans of the following commands of the connection children of
+// the span of the client, until it is cleared with an empty value.
+func (st *connState) setInfo(attr, value string) error {
+	switch strings.ToUpper(attr) {
+	case "LIB-NAME":
+		if !validClientName(value) {
+			return errors.New("ERR lib-name cannot contain spaces, newlines or special characters.")
+		}
+		st.mu.Lock()
+		st.libName = value
+		st.mu.Unlock()
+	case "LIB-VER":
+		if !validClientName(value) {
+			return errors.New("ERR lib-ver cannot contain spaces, newlines or special characters.")
+		}
+		st.mu.Lock()
+		st.libVer = value
+		st.mu.Unlock()
+	case "TRACEPARENT":
+		if value != "" && !trace.SpanContextFromContext(extractTrace(context.Background(), value, "")).IsValid() {
+			return errors.New("ERR Invalid traceparent")
+		}
+		st.traceParent = value
+	case "TRACESTATE":
+		st.traceState = value
+	default:
+		return fmt.Errorf("ERR Unrecognized option '%s'", attr)
+	}
+	return nil
+}
+
+// describe formats the connection as a line of CLIENT LIST.
+func (st *connState) describe(now time.Time) string {
+	st.mu.Lock()
+	name, user, lastCmd, lastSeen := st.name, st.user, st.lastCmd, st.lastSeen
+	libName, libVer := st.libName, st.libVer
+	st.mu.Unlock()
+	resp := 2
+	if st.resp3.Load() {
+		resp = 3
+	}
+
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=N db=0 cmd=%s user=%s resp=%d lib-name=%s lib-ver=%s\n",
+		st.id, st.conn.RemoteAddr(), st.conn.NetConn().LocalAddr(), name,
+		int64(now.Sub(st.created).Seconds()), int64(now.Sub(lastSeen).Seconds()), lastCmd, user, resp, libName, libVer)
+}
+
+// clientsSorted returns the registered connections ordered by ID.
+func (r *Redis) clientsSorted() []*connState {
+	r.clientsMu.RLock()
+	list := make([]*connState, 0, len(r.clients))
+	for _, st := range r.clients {
+		list = append(list, st)
+	}
+	r.clientsMu.RUnlock()
+
+	slices.SortFunc(list, func(a, b *connState) int { return cmp.Compare(a.id, b.id) })
+	return list
+}
+
+// clientList handles CLIENT LIST [TYPE normal] [ID client-id ...].
+func (r *Redis) clientList(conn redcon.Conn, cmd redcon.Command) {
+	var ids map[int64]bool
+	args := cmd.Args[2:]
+	for len(args) > 0 {
+		switch opt := strings.ToUpper(string(args[0])); {
+		case opt == "TYPE" && len(args) >= 2:
+			// Only normal clients are listed; subscribers are handed over to
+			// the pub/sub hub.
+			if t := strings.ToLower(string(args[1])); t != "normal" {
+				conn.WriteBulkString("")
+				return
+			}
+			args = args[2:]
+		case opt == "ID" && len(args) >= 2:
+			ids = map[int64]bool{}
+			for _, a := range args[1:] {
+				id, err := strconv.ParseInt(string(a), 10, 64)
+				if err != nil || id <= 0 {
+					conn.WriteError("ERR Invalid client ID")
+					return
+				}
+				ids[id] = true
+			}
+			args = nil
+		default:
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+	}
+
+	now := time.Now()
+	b := &strings.Builder{}
+	for _, st := range r.clientsSorted() {
+		if ids != nil && !ids[st.id] {
+			continue
+		}
+		b.WriteString(st.describe(now))
+	}
+	conn.WriteBulkString(b.String())
+}
+
+// clientKill handles CLIENT KILL ip:port and CLIENT KILL <filter value> ...
+// with the ID, ADDR, LADDR and SKIPME filters. The connections are closed
+// underneath redcon, which then tears them down on their own goroutine, and
+// the commands they serve are abandoned.
+func (r *Redis) clientKill(conn redcon.Conn, self *connState, cmd redcon.Command) {
+	args := cmd.Args[2:]
+	if len(args) == 1 {
+		addr := string(args[0])
+		for _, st := range r.clientsSorted() {
+			if st.conn.RemoteAddr() == addr {
+				st.kill()
+				conn.WriteString("OK")
+				return
+			}
+		}
+		conn.WriteError("ERR No such client")
+		return
+	}
+	if len(args)%2 != 0 {
+		conn.WriteError(errSyntax.Error())
+		return
+	}
+
+	var id int64
+	var addr, laddr string
+	skipMe := true
+	for i := 0; i < len(args); i += 2 {
+		v := string(args[i+1])
+		switch strings.ToUpper(string(args[i])) {
+		case "ID":
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 {
+				conn.WriteError("ERR client-id should be greater than 0")
+				return
+			}
+			id = n
+		case "ADDR":
+			addr = v
+		case "LADDR":
+			laddr = v
+		case "SKIPME":
+			switch strings.ToLower(v) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				conn.WriteError(errSyntax.Error())
+				return
+			}
+		default:
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+	}
+
+	killed := 0
+	for _, st := range r.clientsSorted() {
+		switch {
+		case id != 0 && st.id != id,
+			addr != "" && st.conn.RemoteAddr() != addr,
+			laddr != "" && st.conn.NetConn().LocalAddr().String() != laddr,
+			skipMe && st == self:
+			continue
+		}
+		st.kill()
+		killed++
+	}
+	conn.WriteInt(killed)
+}
@@ -0,0 +1,547 @@
+package transport
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	hraft "github.com/hashicorp/raft"
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/cluster"
+	"raft-redis-cluster/raft"
+	"raft-redis-cluster/store"
+)
+
+var (
+	errClusterDown = errors.New("CLUSTERDOWN The cluster is down")
+	errInvalidSlot = errors.New("ERR Invalid or out of range slot")
+)
+
+// clusterNode is a server of the Raft configuration of a shard as seen by
+// Redis Cluster clients. The leader of the shard is the master of its slots
+// and the other servers are its replicas.
+type clusterNode struct {
+	id     string
+	server hraft.Server
+	host   string
+	port   int
+	master bool
+}
+
+// clusterShard is a shard with the ranges of slots it owns and its nodes,
+// the master first.
+type clusterShard struct {
+	slots [][2]int
+	nodes []clusterNode
+}
+
+// nodeID derives the 40 character node ID of Redis Cluster from a Raft
+// server ID.
+func nodeID(id hraft.ServerID) string {
+	sum := sha1.Sum([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// clusterShards describes every shard. The servers whose Redis address is
+// unknown are left out.
+func (r *Redis) clusterShards() ([]clusterShard, error) {
+	ranges := r.slotRanges()
+	shards := make([]clusterShard, len(r.shards))
+	for i, sh := range r.shards {
+		nodes, err := r.clusterNodes(sh)
+		if err != nil {
+			return nil, err
+		}
+		shards[i] = clusterShard{slots: ranges[i], nodes: nodes}
+	}
+	return shards, nil
+}
+
+// slotRanges returns the ranges of slots each shard owns, following the
+// migrations recorded in the slot table.
+func (r *Redis) slotRanges() [][][2]int {
+	ranges := make([][][2]int, len(r.shards))
+	for slot := 0; slot < cluster.Slots; slot++ {
+		i := r.shardIndex(slot)
+		if n := len(ranges[i]); n > 0 && ranges[i][n-1][1] == slot-1 {
+			ranges[i][n-1][1] = slot
+		} else {
+			ranges[i] = append(ranges[i], [2]int{slot, slot})
+		}
+	}
+	return ranges
+}
+
+// formatRange formats a range of slots the way CLUSTER NODES does.
+func formatRange(rg [2]int) string {
+	if rg[0] == rg[1] {
+		return strconv.Itoa(rg[0])
+	}
+	return fmt.Sprintf("%d-%d", rg[0], rg[1])
+}
+
+// clusterNodes returns the nodes of sh, the master first.
+func (r *Redis) clusterNodes(sh *Shard) ([]clusterNode, error) {
+	_, lid := sh.Raft.LeaderWithID()
+	if lid == "" {
+		return nil, errClusterDown
+	}
+	f := sh.Raft.GetConfiguration()
+	if err := f.Error(); err != nil {
+		return nil, err
+	}
+
+	var master []clusterNode
+	var replicas []clusterNode
+	for _, s := range f.Configuration().Servers {
+		addr, err := store.GetAdvertiseAddrByNodeID(r.stableStore, s.ID)
+		if err != nil || addr == "" {
+			continue
+		}
+		host, p, err := net.SplitHostPort(addr)
+		if err != nil {
+			continue
+		}
+		port, _ := strconv.Atoi(p)
+		n := clusterNode{id: nodeID(s.ID), server: s, host: host, port: port, master: s.ID == lid}
+		if n.master {
+			master = append(master, n)
+		} else {
+			replicas = append(replicas, n)
+		}
+	}
+	if len(master) == 0 {
+		return nil, errClusterDown
+	}
+	return append(master, replicas...), nil
+}
+
+// clusterSubcmdArgs is the number of arguments of the CLUSTER subcommands,
+// negative when it is a minimum.
+var clusterSubcmdArgs = map[string]int{
+	"INFO":            2,
+	"SLOTS":           2,
+	"SHARDS":          2,
+	"NODES":           2,
+	"SETSLOT":         -4,
+	"COUNTKEYSINSLOT": 3,
+	"GETKEYSINSLOT":   4,
+	"MIGRATEKEYS":     4,
+	"FAILOVER":        -2,
+	"MEET":            -4,
+}
+
+// clusterCmd handles the CLUSTER subcommands.
+func (r *Redis) clusterCmd(conn redcon.Conn, cmd redcon.Command) {
+	sub := strings.ToUpper(string(cmd.Args[1]))
+	n, ok := clusterSubcmdArgs[sub]
+	if !ok {
+		conn.WriteError("ERR unknown subcommand '" + string(cmd.Args[1]) + "'. Try CLUSTER HELP.")
+		return
+	}
+	if (n > 0 && len(cmd.Args) != n) || (n < 0 && len(cmd.Args) < -n) {
+		conn.WriteError("ERR wrong number of arguments for 'cluster|" + strings.ToLower(sub) + "' command")
+		return
+	}
+
+	ctx := context.Background()
+	var write func(redcon.Conn, []clusterShard)
+	switch sub {
+	case "INFO":
+		r.clusterInfo(conn)
+		return
+	case "SLOTS":
+		write = writeClusterSlots
+	case "SHARDS":
+		write = r.writeClusterShards
+	case "NODES":
+		write = r.writeClusterNodes
+	case "SETSLOT":
+		r.setSlot(conn, cmd)
+		return
+	case "COUNTKEYSINSLOT":
+		r.countKeysInSlot(ctx, conn, cmd)
+		return
+	case "GETKEYSINSLOT":
+		r.getKeysInSlot(ctx, conn, cmd)
+		return
+	case "MIGRATEKEYS":
+		r.migrateKeys(ctx, conn, cmd)
+		return
+	case "FAILOVER":
+		if len(cmd.Args) > 3 {
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+		r.failover(conn, cmd)
+		return
+	case "MEET":
+		r.meet(conn, cmd)
+		return
+	}
+
+	shards, err := r.clusterShards()
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	write(conn, shards)
+}
+
+// writeClusterSlots writes each range of slots with the master of its shard
+// followed by the replicas.
+func writeClusterSlots(conn redcon.Conn, shards []clusterShard) {
+	n := 0
+	for _, s := range shards {
+		n += len(s.slots)
+	}
+	conn.WriteArray(n)
+	for _, s := range shards {
+		for _, rg := range s.slots {
+			conn.WriteArray(2 + len(s.nodes))
+			conn.WriteInt(rg[0])
+			conn.WriteInt(rg[1])
+			for _, n := range s.nodes {
+				conn.WriteArray(3)
+				conn.WriteBulkString(n.host)
+				conn.WriteInt(n.port)
+				conn.WriteBulkString(n.id)
+			}
+		}
+	}
+}
+
+// writeClusterShards writes each shard with its slots and nodes.
+func (r *Redis) writeClusterShards(conn redcon.Conn, shards []clusterShard) {
+	conn.WriteArray(len(shards))
+	for i, s := range shards {
+		writeMap(conn, 2)
+		conn.WriteBulkString("slots")
+		conn.WriteArray(2 * len(s.slots))
+		for _, rg := range s.slots {
+			conn.WriteInt(rg[0])
+			conn.WriteInt(rg[1])
+		}
+		conn.WriteBulkString("nodes")
+		conn.WriteArray(len(s.nodes))
+		for _, n := range s.nodes {
+			role := "replica"
+			if n.master {
+				role = "master"
+			}
+			writeMap(conn, 7)
+			conn.WriteBulkString("id")
+			conn.WriteBulkString(n.id)
+			conn.WriteBulkString("port")
+			conn.WriteInt(n.port)
+			conn.WriteBulkString("ip")
+			conn.WriteBulkString(n.host)
+			conn.WriteBulkString("endpoint")
+			conn.WriteBulkString(n.host)
+			conn.WriteBulkString("role")
+			conn.WriteBulkString(role)
+			conn.WriteBulkString("replication-offset")
+			conn.WriteInt64(int64(r.offsetOf(r.shards[i], n)))
+			conn.WriteBulkString("health")
+			conn.WriteBulkString("online")
+		}
+	}
+}
+
+// offsetOf returns the applied index of sh on this node as its replication
+// offset. The offsets of the other nodes are not known here.
+func (r *Redis) offsetOf(sh *Shard, n clusterNode) uint64 {
+	if n.server.ID != r.id {
+		return 0
+	}
+	return sh.Raft.AppliedIndex()
+}
+
+// writeClusterNodes writes the nodes in the format of nodes.conf. A node is
+// a master when it leads at least one shard, and a replica of the master of
+// the first shard otherwise. The bus port is the port of the Raft transport
+// of the first shard. A migrating slot is listed by the masters of both its
+// source and its target shard.
+func (r *Redis) writeClusterNodes(conn redcon.Conn, shards []clusterShard) {
+	masterID := shards[0].nodes[0].id
+	epoch := r.raft.Stats()["term"]
+	migrating := r.fsm.Slots().MigratingSlots()
+
+	b := &strings.Builder{}
+	for _, n := range shards[0].nodes {
+		var slots []string
+		master := false
+		for _, s := range shards {
+			if s.nodes[0].id == n.id {
+				master = true
+				for _, rg := range s.slots {
+					slots = append(slots, formatRange(rg))
+				}
+			}
+		}
+		for slot, to := range migrating {
+			from := shards[r.shardIndex(slot)].nodes[0].id
+			switch target := shards[to].nodes[0].id; n.id {
+			case from:
+				slots = append(slots, fmt.Sprintf("[%d->-%s]", slot, target))
+			case target:
+				slots = append(slots, fmt.Sprintf("[%d-<-%s]", slot, from))
+			}
+		}
+
+		_, busPort, _ := net.SplitHostPort(string(n.server.Address))
+		flags, of := "slave", masterID
+		if master {
+			flags, of = "master", "-"
+		}
+		if n.server.ID == r.id {
+			flags = "myself," + flags
+		}
+		if n.server.Suffrage == hraft.Nonvoter {
+			// Non-voters can't be elected.
+			flags += ",nofailover"
+		}
+		fmt.Fprintf(b, "%s %s:%d@%s %s %s 0 0 %s connected",
+			n.id, n.host, n.port, busPort, flags, of, epoch)
+		for _, s := range slots {
+			b.WriteString(" " + s)
+		}
+		b.WriteString("\n")
+	}
+	conn.WriteBulkString(b.String())
+}
+
+// parseSlot parses a hash slot argument.
+func parseSlot(arg []byte) (int, error) {
+	slot, err := strconv.Atoi(string(arg))
+	if err != nil || slot < 0 || slot >= cluster.Slots {
+		return 0, errInvalidSlot
+	}
+	return slot, nil
+}
+
+// parseShard parses a shard index argument.
+func (r *Redis) parseShard(arg []byte) (int, error) {
+	i, err := strconv.Atoi(string(arg))
+	if err != nil || i < 0 || i >= len(r.shards) {
+		return 0, errors.New("ERR Unknown shard " + string(arg))
+	}
+	return i, nil
+}
+
+// setSlot handles CLUSTER SETSLOT slot MIGRATING|IMPORTING|NODE shard and
+// CLUSTER SETSLOT slot STABLE. Shards are named by their index rather than a
+// node ID. The slot table is shared by every shard, so a migration has a
+// single state: MIGRATING and IMPORTING both name the shard the slot moves
+// to. NODE ends the migration and assigns the slot to shard, and STABLE
+// cancels it.
+func (r *Redis) setSlot(conn redcon.Conn, cmd redcon.Command) {
+	slot, err := parseSlot(cmd.Args[2])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	kvCmd := &raft.KVCmd{Op: raft.SetSlot, Slot: slot}
+	table := r.fsm.Slots()
+	owner := r.shardIndex(slot)
+
+	switch state := strings.ToUpper(string(cmd.Args[3])); {
+	case state == "STABLE" && len(cmd.Args) == 4:
+		kvCmd.Val = []byte("stable")
+
+	case (state == "MIGRATING" || state == "IMPORTING" || state == "NODE") && len(cmd.Args) == 5:
+		i, err := r.parseShard(cmd.Args[4])
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		kvCmd.Shard = i
+		if state == "NODE" {
+			kvCmd.Val = []byte("node")
+			if i != owner {
+				keys, err := slotKeys(context.Background(), r.shards[owner], slot, 1)
+				if err != nil {
+					conn.WriteError(err.Error())
+					return
+				}
+				if len(keys) > 0 {
+					conn.WriteError(fmt.Sprintf("ERR Can't assign hashslot %d to a different shard while shard %d still holds keys for this hash slot.", slot, owner))
+					return
+				}
+			}
+			break
+		}
+
+		kvCmd.Val = []byte("migrating")
+		if i == owner {
+			conn.WriteError(fmt.Sprintf("ERR Hash slot %d is already owned by shard %d", slot, i))
+			return
+		}
+		if to, ok := table.Migrating(slot); ok && to != i {
+			conn.WriteError(fmt.Sprintf("ERR Hash slot %d is already migrating to shard %d", slot, to))
+			return
+		}
+
+	default:
+		conn.WriteError(errSyntax.Error())
+		return
+	}
+
+	if r.moved(conn, r.shards[0], slot) {
+		return
+	}
+	if _, err := r.applyTo(r.shards[0], kvCmd); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	r.logShard(r.shards[0]).Info("slot set", "slot", slot, "state", strings.ToLower(string(cmd.Args[3])), "to_shard", kvCmd.Shard)
+	conn.WriteString("OK")
+}
+
+// slotKeys returns up to count keys of slot held by the store of sh.
+func slotKeys(ctx context.Context, sh *Shard, slot, count int) ([][]byte, error) {
+	keys := [][]byte{}
+	var cursor uint64
+	for len(keys) < count {
+		page, next, err := sh.Store.Scan(ctx, cursor, keysPageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range page {
+			if len(keys) < count && keySlot(k) == slot {
+				keys = append(keys, k)
+			}
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	return keys, nil
+}
+
+// countKeysInSlot handles CLUSTER COUNTKEYSINSLOT slot. The keys are counted
+// in the shard that owns the slot.
+func (r *Redis) countKeysInSlot(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	slot, err := parseSlot(cmd.Args[2])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	keys, err := slotKeys(ctx, r.shardOf(slot), slot, math.MaxInt)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteInt(len(keys))
+}
+
+// getKeysInSlot handles CLUSTER GETKEYSINSLOT slot count.
+func (r *Redis) getKeysInSlot(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	slot, err := parseSlot(cmd.Args[2])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	count, err := strconv.Atoi(string(cmd.Args[3]))
+	if err != nil || count < 0 {
+		conn.WriteError("ERR Invalid number of keys")
+		return
+	}
+	keys, err := slotKeys(ctx, r.shardOf(slot), slot, count)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteArray(len(keys))
+	for _, k := range keys {
+		conn.WriteBulk(k)
+	}
+}
+
+// migrateKeys handles CLUSTER MIGRATEKEYS slot count, which moves up to
+// count keys of a migrating slot to its target shard and replies with the
+// number of keys moved. Once it replies 0, the slot can be assigned to the
+// target with CLUSTER SETSLOT slot NODE. The keys are moved through the
+// Raft logs of both shards, so this node must lead them.
+func (r *Redis) migrateKeys(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	slot, err := parseSlot(cmd.Args[2])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	count, err := strconv.Atoi(string(cmd.Args[3]))
+	if err != nil || count < 0 {
+		conn.WriteError("ERR Invalid number of keys")
+		return
+	}
+	i, ok := r.fsm.Slots().Migrating(slot)
+	if !ok {
+		conn.WriteError(fmt.Sprintf("ERR Hash slot %d is not migrating", slot))
+		return
+	}
+	from, to := r.shardOf(slot), r.shards[i]
+	if from.Raft.State() != hraft.Leader || to.Raft.State() != hraft.Leader {
+		conn.WriteError(fmt.Sprintf("ERR This node must lead shards %d and %d to migrate hash slot %d", from.index, to.index, slot))
+		return
+	}
+
+	keys, err := slotKeys(ctx, from, slot, count)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	n := 0
+	for _, k := range keys {
+		ok, err := r.migrateKey(ctx, from, to, k)
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		if ok {
+			n++
+		}
+	}
+	conn.WriteInt(n)
+}
+
+// migrateKey copies key from one shard to the other and deletes it from the
+// source. When the key is written in between, it is copied again. It
+// reports whether the key was moved.
+func (r *Redis) migrateKey(ctx context.Context, from, to *Shard, key []byte) (bool, error) {
+	for {
+		version, err := from.FSM.KeyVersion(ctx, key)
+		if err != nil {
+			return false, err
+		}
+		data, err := from.Store.Dump(ctx, key)
+		if errors.Is(err, store.ErrKeyNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if _, err := r.applyTo(to, &raft.KVCmd{Op: raft.RestoreKey, Key: key, Val: data}); err != nil {
+			return false, err
+		}
+
+		res, err := r.applyTo(from, &raft.KVCmd{
+			Op:    raft.Multi,
+			Watch: []raft.WatchedKey{{Key: key, Version: version}},
+			Cmds:  []raft.KVCmd{{Op: raft.Del, Key: key}},
+		})
+		if err != nil {
+			return false, err
+		}
+		if res != nil {
+			return true, nil
+		}
+	}
+}
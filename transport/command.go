@@ -0,0 +1,239 @@
+package transport
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/glob"
+)
+
+// movableKeyCmds are the commands whose keys are found by reading their
+// arguments rather than at fixed positions.
+var movableKeyCmds = map[string]bool{
+	"EVAL":    true,
+	"EVALSHA": true,
+	"XREAD":   true,
+}
+
+// command handles COMMAND, COMMAND COUNT, COMMAND LIST [FILTERBY ACLCAT
+// category | PATTERN pattern], COMMAND INFO [command ...], COMMAND DOCS
+// [command ...] and COMMAND GETKEYS command [arg ...]. The replies are built
+// from commands, cmdCategories and cmdKeyRange, the tables the commands are
+// validated and routed with, so they include the commands added with
+// RegisterCommand.
+func (r *Redis) command(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) == 1 {
+		names := commandNames()
+		conn.WriteArray(len(names))
+		for _, name := range names {
+			writeCommandInfo(conn, name)
+		}
+		return
+	}
+
+	sub := strings.ToUpper(string(cmd.Args[1]))
+	switch {
+	case sub == "COUNT" && len(cmd.Args) == 2:
+		conn.WriteInt(len(commands))
+
+	case sub == "LIST" && (len(cmd.Args) == 2 || len(cmd.Args) == 5):
+		names := commandNames()
+		if len(cmd.Args) == 5 {
+			if !strings.EqualFold(string(cmd.Args[2]), "FILTERBY") {
+				conn.WriteError(errSyntax.Error())
+				return
+			}
+			filter := strings.ToUpper(string(cmd.Args[3]))
+			if filter != "ACLCAT" && filter != "PATTERN" {
+				conn.WriteError(errSyntax.Error())
+				return
+			}
+			names = slices.DeleteFunc(names, func(name string) bool {
+				if filter == "ACLCAT" {
+					return !slices.Contains(cmdCategories[name], strings.ToLower(string(cmd.Args[4])))
+				}
+				return !glob.Match([]byte(strings.ToLower(string(cmd.Args[4]))), []byte(strings.ToLower(name)))
+			})
+		}
+		conn.WriteArray(len(names))
+		for _, name := range names {
+			conn.WriteBulkString(strings.ToLower(name))
+		}
+
+	case sub == "INFO":
+		names := commandNames()
+		if len(cmd.Args) > 2 {
+			names = names[:0]
+			for _, a := range cmd.Args[2:] {
+				names = append(names, strings.ToUpper(string(a)))
+			}
+		}
+		conn.WriteArray(len(names))
+		for _, name := range names {
+			if _, ok := commands[name]; !ok {
+				conn.WriteNull()
+				continue
+			}
+			writeCommandInfo(conn, name)
+		}
+
+	case sub == "DOCS":
+		names := commandNames()
+		if len(cmd.Args) > 2 {
+			names = names[:0]
+			for _, a := range cmd.Args[2:] {
+				name := strings.ToUpper(string(a))
+				if _, ok := commands[name]; ok {
+					names = append(names, name)
+				}
+			}
+		}
+		writeMap(conn, len(names))
+		for _, name := range names {
+			conn.WriteBulkString(strings.ToLower(name))
+			writeMap(conn, 1)
+			conn.WriteBulkString("group")
+			conn.WriteBulkString(commandGroup(name))
+		}
+
+	case sub == "GETKEYS" && len(cmd.Args) >= 3:
+		args := cmd.Args[2:]
+		name := strings.ToUpper(string(args[0]))
+		c, ok := commands[name]
+		switch {
+		case !ok:
+			conn.WriteError("ERR Invalid command specified")
+			return
+		case (c.arity >= 0 && len(args) != c.arity) || len(args) < -c.arity:
+			conn.WriteError("ERR Invalid number of arguments specified for command")
+			return
+		}
+		keys := cmdKeys(name, args)
+		if len(keys) == 0 {
+			conn.WriteError("ERR The command has no key arguments")
+			return
+		}
+		conn.WriteArray(len(keys))
+		for _, k := range keys {
+			conn.WriteBulk(k)
+		}
+
+	case sub == "COUNT" || sub == "LIST" || sub == "GETKEYS":
+		conn.WriteError("ERR wrong number of arguments for 'command|" + strings.ToLower(sub) + "' command")
+
+	default:
+		conn.WriteError("ERR unknown subcommand '" + string(cmd.Args[1]) + "'. Try COMMAND HELP.")
+	}
+}
+
+// commandNames returns the names of the commands, sorted.
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// writeCommandInfo writes the reply of COMMAND INFO for name: its name,
+// arity, flags, first key, last key, key step and ACL categories, then the
+// tips, key specifications and subcommands, which are left empty.
+func writeCommandInfo(conn redcon.Conn, name string) {
+	first, last, step := commandKeySpec(name)
+	flags := commandFlags(name)
+	cats := cmdCategories[name]
+
+	conn.WriteArray(10)
+	conn.WriteBulkString(strings.ToLower(name))
+	conn.WriteInt(commands[name].arity)
+	writeSet(conn, len(flags))
+	for _, f := range flags {
+		conn.WriteString(f)
+	}
+	conn.WriteInt(first)
+	conn.WriteInt(last)
+	conn.WriteInt(step)
+	writeSet(conn, len(cats))
+	for _, c := range cats {
+		conn.WriteString("@" + c)
+	}
+	conn.WriteArray(0)
+	conn.WriteArray(0)
+	conn.WriteArray(0)
+}
+
+// commandKeySpec returns the positions of the keys of name for COMMAND
+// INFO, worked out by cmdKeyRange on a command line of the least number of
+// arguments, a few more for a command of variable arity. A last key of -1
+// means the keys run to the end of the line.
+func commandKeySpec(name string) (first, last, step int) {
+	if movableKeyCmds[name] {
+		return 0, 0, 0
+	}
+	arity := commands[name].arity
+	n := arity
+	if n < 0 {
+		n = -n + 4
+	}
+	args := make([][]byte, n)
+	for i := range args {
+		args[i] = []byte{}
+	}
+	first, end, step := cmdKeyRange(name, args)
+	switch {
+	case step == 0 || end <= first:
+		return 0, 0, 0
+	case arity < 0 && end == len(args):
+		return first, -1, step
+	}
+	return first, end - 1, step
+}
+
+// commandFlags returns the flags of name reported by COMMAND INFO.
+func commandFlags(name string) []string {
+	cats := cmdCategories[name]
+	var flags []string
+	if slices.Contains(cats, "write") {
+		flags = append(flags, "write")
+	}
+	if slices.Contains(cats, "read") {
+		flags = append(flags, "readonly")
+	}
+	if slices.Contains(cats, "admin") {
+		flags = append(flags, "admin")
+	}
+	if slices.Contains(cats, "pubsub") {
+		flags = append(flags, "pubsub")
+	}
+	if noAuthCmds[name] {
+		flags = append(flags, "no_auth")
+	}
+	if isLocalCmd(name) {
+		flags = append(flags, "loading", "stale")
+	}
+	if movableKeyCmds[name] {
+		flags = append(flags, "movablekeys")
+	}
+	return flags
+}
+
+// commandGroup returns the group of name reported by COMMAND DOCS: the type
+// of the values it works on, or the part of the server it belongs to.
+func commandGroup(name string) string {
+	for _, c := range cmdCategories[name] {
+		switch c {
+		case "string", "hash", "set", "bitmap", "hyperloglog", "stream", "pubsub", "scripting", "connection":
+			return c
+		case "sortedset":
+			return "sorted-set"
+		case "transaction":
+			return "transactions"
+		case "keyspace":
+			return "generic"
+		}
+	}
+	return "server"
+}
@@ -0,0 +1,742 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	hraft "github.com/hashicorp/raft"
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/config"
+	"raft-redis-cluster/glob"
+	"raft-redis-cluster/raft"
+	"raft-redis-cluster/store"
+)
+
+// defaultApplyTimeout is how long a write waits to be committed by default.
+const defaultApplyTimeout = time.Second
+
+// defaultApplyBackoff is how long a failed write waits by default before it
+// is retried the first time, and maxApplyBackoff the longest.
+const (
+	defaultApplyBackoff = 100 * time.Millisecond
+	maxApplyBackoff     = 5 * time.Second
+)
+
+// defaultMaxmemorySamples is how many keys are sampled by default to pick
+// one to evict.
+const defaultMaxmemorySamples = 5
+
+var errOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'.")
+
+var errNoConfigFile = errors.New("ERR The server is running without a config file")
+
+// registerConfig registers the runtime parameters of the node. They are
+// local to the node, like the configuration of a Redis server.
+func (r *Redis) registerConfig() {
+	r.applyTimeout.Store(defaultApplyTimeout.Milliseconds())
+	r.applyBackoff.Store(defaultApplyBackoff.Milliseconds())
+	r.maxmemorySamples.Store(defaultMaxmemorySamples)
+	r.hz.Store(defaultHz)
+	r.writeBatchMax.Store(defaultWriteBatchMax)
+	r.protoMaxBulkLen.Store(defaultProtoMaxBulkLen)
+	r.raftChunkSize.Store(defaultRaftChunkSize)
+	r.maxClients.Store(defaultMaxClients)
+	r.consistency.Store(int32(leaderLocal))
+	r.readyGating.Store(true)
+	r.requirepass.Store("")
+	r.advertise.Store("")
+
+	r.config.Register(config.Param{
+		Name: "notify-keyspace-events",
+		Get:  r.fsm.NotifyKeyspaceEvents,
+		Set: func(v string) error {
+			for _, sh := range r.shards {
+				if err := sh.FSM.SetNotifyKeyspaceEvents(v); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "snapshot-compression",
+		Get:  r.fsm.SnapshotCompression,
+		Set: func(v string) error {
+			for _, sh := range r.shards {
+				if err := sh.FSM.SetSnapshotCompression(v); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "requirepass",
+		Get:  func() string { return r.requirepass.Load().(string) },
+		Set: func(v string) error {
+			r.requirepass.Store(v)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "timeout",
+		Get:  func() string { return strconv.FormatInt(r.idleTimeout.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseInt(v)
+			if err != nil {
+				return err
+			}
+			r.idleTimeout.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "advertise-addr",
+		Get:  r.advertiseAddr,
+		Set:  r.setAdvertiseAddr,
+	})
+	r.config.Register(config.Param{
+		Name: "write-timeout",
+		Get:  func() string { return strconv.FormatInt(r.writeTimeout.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseInt(v)
+			if err != nil {
+				return err
+			}
+			r.writeTimeout.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "maxclients",
+		Get:  func() string { return strconv.FormatInt(r.maxClients.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseInt(v)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return errors.New("argument must be greater than 0")
+			}
+			r.maxClients.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "maxmemory",
+		Get:  func() string { return strconv.FormatInt(r.maxmemory.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseMemory(v)
+			if err != nil {
+				return err
+			}
+			r.maxmemory.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "maxmemory-policy",
+		Get:  func() string { return store.EvictionPolicy(r.maxmemoryPolicy.Load()).String() },
+		Set: func(v string) error {
+			p, err := store.ParseEvictionPolicy(v)
+			if err != nil {
+				return err
+			}
+			r.maxmemoryPolicy.Store(int32(p))
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "hz",
+		Get:  func() string { return strconv.FormatInt(r.hz.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseInt(v)
+			if err != nil {
+				return err
+			}
+			if n > 500 {
+				return errors.New("argument must be between 0 and 500 inclusive")
+			}
+			r.hz.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "maxmemory-samples",
+		Get:  func() string { return strconv.FormatInt(r.maxmemorySamples.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseInt(v)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return errors.New("argument must be greater than 0")
+			}
+			r.maxmemorySamples.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "loglevel",
+		Get:  func() string { return formatLogLevel(r.logLevel.Level()) },
+		Set: func(v string) error {
+			level, err := parseLogLevel(v)
+			if err != nil {
+				return err
+			}
+			r.logLevel.Set(level)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "forward-to-leader",
+		Get:  func() string { return config.FormatBool(r.forwardToLeader.Load()) },
+		Set: func(v string) error {
+			b, err := config.ParseBool(v)
+			if err != nil {
+				return err
+			}
+			r.forwardToLeader.Store(b)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "follower-read-max-staleness",
+		Get:  func() string { return strconv.FormatInt(r.maxStaleness.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseInt(v)
+			if err != nil {
+				return err
+			}
+			r.maxStaleness.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "follower-read-max-lag",
+		Get:  func() string { return strconv.FormatInt(r.maxLag.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseInt(v)
+			if err != nil {
+				return err
+			}
+			r.maxLag.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "ready-gating",
+		Get:  func() string { return config.FormatBool(r.readyGating.Load()) },
+		Set: func(v string) error {
+			b, err := config.ParseBool(v)
+			if err != nil {
+				return err
+			}
+			r.readyGating.Store(b)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "ready-max-lag",
+		Get:  func() string { return strconv.FormatInt(r.readyMaxLag.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseInt(v)
+			if err != nil {
+				return err
+			}
+			r.readyMaxLag.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "read-consistency",
+		Get:  func() string { return consistency(r.consistency.Load()).String() },
+		Set: func(v string) error {
+			c, err := parseConsistency(v)
+			if err != nil {
+				return errors.New("argument(s) must be one of the following: leader-local, linearizable, stale")
+			}
+			r.consistency.Store(int32(c))
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "databases",
+		Get:  func() string { return strconv.Itoa(r.databases) },
+	})
+	r.config.Register(config.Param{
+		Name: "raft-apply-timeout",
+		Get:  func() string { return strconv.FormatInt(r.applyTimeout.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseInt(v)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return errors.New("argument must be greater than 0")
+			}
+			r.applyTimeout.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "raft-apply-retries",
+		Get:  func() string { return strconv.FormatInt(r.applyRetries.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseInt(v)
+			if err != nil {
+				return err
+			}
+			r.applyRetries.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "raft-apply-retry-backoff",
+		Get:  func() string { return strconv.FormatInt(r.applyBackoff.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseInt(v)
+			if err != nil {
+				return err
+			}
+			r.applyBackoff.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "command-timeout",
+		Get:  func() string { return strconv.FormatInt(r.commandTimeout.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseInt(v)
+			if err != nil {
+				return err
+			}
+			r.commandTimeout.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "command-read-timeout",
+		Get:  func() string { return strconv.FormatInt(r.cmdReadTimeout.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseInt(v)
+			if err != nil {
+				return err
+			}
+			r.cmdReadTimeout.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "command-write-timeout",
+		Get:  func() string { return strconv.FormatInt(r.cmdWriteTimeout.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseInt(v)
+			if err != nil {
+				return err
+			}
+			r.cmdWriteTimeout.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "proto-max-bulk-len",
+		Get:  func() string { return strconv.FormatInt(r.protoMaxBulkLen.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseMemory(v)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return errors.New("argument must be greater than 0")
+			}
+			r.protoMaxBulkLen.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "raft-chunk-size",
+		Get:  func() string { return strconv.FormatInt(r.raftChunkSize.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseMemory(v)
+			if err != nil {
+				return err
+			}
+			r.raftChunkSize.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "write-batch-window",
+		Get:  func() string { return strconv.FormatInt(r.writeBatchWindow.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseInt(v)
+			if err != nil {
+				return err
+			}
+			r.writeBatchWindow.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "write-batch-max",
+		Get:  func() string { return strconv.FormatInt(r.writeBatchMax.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseInt(v)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return errors.New("argument must be greater than 0")
+			}
+			r.writeBatchMax.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "client-max-cmds-per-sec",
+		Get:  func() string { return strconv.FormatInt(r.clientMaxCmds.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseInt(v)
+			if err != nil {
+				return err
+			}
+			r.clientMaxCmds.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "client-max-bytes-per-sec",
+		Get:  func() string { return strconv.FormatInt(r.clientMaxBytes.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseInt(v)
+			if err != nil {
+				return err
+			}
+			r.clientMaxBytes.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "ip-max-cmds-per-sec",
+		Get:  func() string { return strconv.FormatInt(r.ipMaxCmds.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseInt(v)
+			if err != nil {
+				return err
+			}
+			r.ipMaxCmds.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "ip-max-bytes-per-sec",
+		Get:  func() string { return strconv.FormatInt(r.ipMaxBytes.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseInt(v)
+			if err != nil {
+				return err
+			}
+			r.ipMaxBytes.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "max-pending-writes",
+		Get:  func() string { return strconv.FormatInt(r.maxPendingWrites.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseInt(v)
+			if err != nil {
+				return err
+			}
+			r.maxPendingWrites.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "max-apply-backlog",
+		Get:  func() string { return strconv.FormatInt(r.maxApplyBacklog.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseInt(v)
+			if err != nil {
+				return err
+			}
+			r.maxApplyBacklog.Store(n)
+			return nil
+		},
+	})
+	r.config.Register(config.Param{
+		Name: "busy-wait",
+		Get:  func() string { return strconv.FormatInt(r.busyWait.Load(), 10) },
+		Set: func(v string) error {
+			n, err := config.ParseInt(v)
+			if err != nil {
+				return err
+			}
+			r.busyWait.Store(n)
+			return nil
+		},
+	})
+
+	r.registerRaftConfig("raft-trailing-logs", 1,
+		func(c *hraft.ReloadableConfig) *uint64 { return &c.TrailingLogs }, nil)
+	r.registerRaftConfig("raft-snapshot-threshold", 1,
+		func(c *hraft.ReloadableConfig) *uint64 { return &c.SnapshotThreshold }, nil)
+	r.registerRaftConfig("raft-snapshot-interval", time.Second,
+		nil, func(c *hraft.ReloadableConfig) *time.Duration { return &c.SnapshotInterval })
+	r.registerRaftConfig("raft-heartbeat-timeout", time.Millisecond,
+		nil, func(c *hraft.ReloadableConfig) *time.Duration { return &c.HeartbeatTimeout })
+	r.registerRaftConfig("raft-election-timeout", time.Millisecond,
+		nil, func(c *hraft.ReloadableConfig) *time.Duration { return &c.ElectionTimeout })
+}
+
+// registerRaftConfig registers a field of the reloadable Raft configuration
+// of every shard, given either as a count or as a duration in unit.
+func (r *Redis) registerRaftConfig(name string, unit time.Duration,
+	count func(*hraft.ReloadableConfig) *uint64, dur func(*hraft.ReloadableConfig) *time.Duration) {
+	r.config.Register(config.Param{
+		Name: name,
+		Get: func() string {
+			c := r.raft.ReloadableConfig()
+			if count != nil {
+				return strconv.FormatUint(*count(&c), 10)
+			}
+			return strconv.FormatInt(int64(*dur(&c)/unit), 10)
+		},
+		Set: func(v string) error {
+			n, err := config.ParseInt(v)
+			if err != nil {
+				return err
+			}
+
+			r.reloadMu.Lock()
+			defer r.reloadMu.Unlock()
+
+			for _, sh := range r.shards {
+				c := sh.Raft.ReloadableConfig()
+				if count != nil {
+					*count(&c) = uint64(n)
+				} else {
+					*dur(&c) = time.Duration(n) * unit
+				}
+				if err := sh.Raft.ReloadConfig(c); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
+
+// configCmd handles CONFIG GET parameter [parameter ...], CONFIG SET
+// parameter value [parameter value ...], CONFIG RESETSTAT and CONFIG
+// REWRITE.
+func (r *Redis) configCmd(conn redcon.Conn, cmd redcon.Command) {
+	sub := strings.ToUpper(string(cmd.Args[1]))
+	switch {
+	case sub == "GET" && len(cmd.Args) >= 3:
+		out := r.configMatching(cmd.Args[2:])
+		writeMap(conn, len(out)/2)
+		for _, s := range out {
+			conn.WriteBulkString(s)
+		}
+
+	case sub == "SET" && len(cmd.Args) >= 4 && len(cmd.Args)%2 == 0:
+		for i := 2; i < len(cmd.Args); i += 2 {
+			name := string(cmd.Args[i])
+			if err := r.config.Set(name, string(cmd.Args[i+1])); err != nil {
+				conn.WriteError("ERR CONFIG SET failed (possibly related to argument '" + name + "') - " + err.Error())
+				return
+			}
+			r.connLog(stateOf(conn)).Info("config set", "param", name)
+			r.configMu.Lock()
+			r.configSet[strings.ToLower(name)] = true
+			r.configMu.Unlock()
+		}
+		conn.WriteString("OK")
+
+	case sub == "RESETSTAT" && len(cmd.Args) == 2:
+		conn.WriteString("OK")
+
+	case sub == "REWRITE" && len(cmd.Args) == 2:
+		if err := r.rewriteConfig(); err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		conn.WriteString("OK")
+
+	case sub == "GET" || sub == "SET" || sub == "RESETSTAT" || sub == "REWRITE":
+		conn.WriteError("ERR wrong number of arguments for 'config|" + strings.ToLower(sub) + "' command")
+
+	default:
+		conn.WriteError("ERR unknown subcommand '" + string(cmd.Args[1]) + "'. Try CONFIG HELP.")
+	}
+}
+
+// rewriteConfig writes the parameters changed with CONFIG SET to the params
+// section of the configuration file, along with the ones already there.
+func (r *Redis) rewriteConfig() error {
+	r.configMu.Lock()
+	defer r.configMu.Unlock()
+	if r.configFile == nil {
+		return errNoConfigFile
+	}
+	names := slices.Sorted(maps.Keys(r.configSet))
+	if err := r.configFile.Rewrite(r.config, names); err != nil {
+		r.log().Warn("config rewrite failed", "error", err)
+		return errors.New("ERR Rewriting config file: " + err.Error())
+	}
+	r.log().Info("config rewritten", "file", r.configFile.Path(), "params", len(names))
+	return nil
+}
+
+// SetConfigFile replaces the configuration file, once it was read again on
+// SIGHUP.
+func (r *Redis) SetConfigFile(f *config.File) {
+	r.configMu.Lock()
+	defer r.configMu.Unlock()
+	r.configFile = f
+}
+
+// configMatching returns the names and the values of the parameters
+// matching any of patterns, in pairs.
+func (r *Redis) configMatching(patterns [][]byte) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, pattern := range patterns {
+		pattern = bytes.ToLower(pattern)
+		for _, name := range r.config.Names() {
+			if seen[name] || !glob.Match(pattern, []byte(name)) {
+				continue
+			}
+			v, err := r.config.Get(name)
+			if err != nil {
+				continue
+			}
+			seen[name] = true
+			out = append(out, name, v)
+		}
+	}
+	return out
+}
+
+// advertiseAddr returns the address clients are sent to for this node, when
+// it differs from the one the nodes use between themselves.
+func (r *Redis) advertiseAddr() string {
+	return r.advertise.Load().(string)
+}
+
+// setAdvertiseAddr sets advertise-addr, which is recorded in the registry of
+// this node for its MOVED and ASK redirects and CLUSTER replies. The leader
+// of the first shard also replicates it to the other nodes; the others
+// learn it from CLUSTER MEET, or from RAFT.ADD with ADVERTISE.
+func (r *Redis) setAdvertiseAddr(v string) error {
+	if v != "" {
+		if _, _, err := net.SplitHostPort(v); err != nil {
+			return errors.New("argument must be a host:port address")
+		}
+	}
+	if err := store.SetAdvertiseAddrByNodeID(r.stableStore, r.id, v); err != nil {
+		return err
+	}
+	r.advertise.Store(v)
+
+	if r.raft.State() != hraft.Leader {
+		return nil
+	}
+	addr, err := store.GetRedisAddrByNodeID(r.stableStore, r.id)
+	if err != nil {
+		return err
+	}
+	_, err = r.applyTo(r.shards[0], &raft.KVCmd{Op: raft.SetNode, Key: []byte(r.id), Val: []byte(addr), Field: []byte(v)})
+	return err
+}
+
+// extendDeadline restarts the idle timeout of conn, and gives the reply of
+// the command just read write-timeout seconds to be written.
+func (r *Redis) extendDeadline(conn redcon.Conn) {
+	var deadline time.Time
+	if t := r.idleTimeout.Load(); t > 0 {
+		deadline = time.Now().Add(time.Duration(t) * time.Second)
+	}
+	conn.NetConn().SetReadDeadline(deadline)
+	conn.NetConn().SetWriteDeadline(r.writeDeadline())
+}
+
+// writeDeadline returns the deadline of a write to a client starting now.
+func (r *Redis) writeDeadline() time.Time {
+	if t := r.writeTimeout.Load(); t > 0 {
+		return time.Now().Add(time.Duration(t) * time.Second)
+	}
+	return time.Time{}
+}
+
+// denyOOM reports whether cmd may use more memory and is therefore refused
+// while the node is above maxmemory. A Batch is refused when any of its
+// commands is.
+func denyOOM(cmd *raft.KVCmd) bool {
+	switch cmd.Op {
+	case raft.Del, raft.Persist, raft.HDel, raft.SRem, raft.ZRem, raft.Publish, raft.ScriptFlush, raft.Flush, raft.Unlock:
+		return false
+	case raft.Batch:
+		return slices.ContainsFunc(cmd.Cmds, func(c raft.KVCmd) bool { return denyOOM(&c) })
+	}
+	return true
+}
+
+// overMaxmemory reports whether the keys of the node use more than
+// maxmemory.
+func (r *Redis) overMaxmemory() bool {
+	max := r.maxmemory.Load()
+	return max > 0 && r.store.UsedMemory() > max
+}
+
+// evict makes room for a write while the node is above maxmemory by deleting
+// keys chosen by maxmemory-policy. The keys are deleted through the Raft log
+// of their shard, so every replica evicts the same keys, and only from the
+// shards this node leads. It returns errOOM when the policy is noeviction or
+// no key can be evicted.
+func (r *Redis) evict() error {
+	if !r.overMaxmemory() {
+		return nil
+	}
+	policy := store.EvictionPolicy(r.maxmemoryPolicy.Load())
+	if policy == store.NoEviction {
+		return errOOM
+	}
+
+	r.evictMu.Lock()
+	defer r.evictMu.Unlock()
+
+	for r.overMaxmemory() {
+		evicted := false
+		for _, sh := range r.shards {
+			if sh.Raft.State() != hraft.Leader {
+				continue
+			}
+			key, err := sh.Store.EvictionCandidate(policy, int(r.maxmemorySamples.Load()))
+			if errors.Is(err, store.ErrKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if _, err := r.applyTo(sh, &raft.KVCmd{Op: raft.Del, Key: key, Evict: true}); err != nil {
+				return err
+			}
+			r.evictedKeys.Add(1)
+			evicted = true
+		}
+		if !evicted {
+			return errOOM
+		}
+	}
+	return nil
+}
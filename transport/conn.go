@@ -0,0 +1,346 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	hraft "github.com/hashicorp/raft"
+	"github.com/tidwall/redcon"
+	"raft-redis-cluster/acl"
+)
+
+// serverVersion is the Redis version reported to clients.
+const serverVersion = "7.0.0"
+
+// defaultMaxClients is the default of maxclients, as in Redis.
+const defaultMaxClients = 10000
+
+var errMaxClients = errors.New("ERR max number of clients reached")
+
+// connState is the state of a client connection, kept as its redcon
+// context.
+type connState struct {
+	id      int64
+	conn    redcon.Conn
+	created time.Time
+	// ctx is canceled once the connection is closed, and cancel cancels
+	// it. The commands of the connection run with a context derived from
+	// it.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// authenticated is set once the client passed AUTH, or from the start
+	// when no password is required.
+	authenticated atomic.Bool
+	// resp3 is set once the client switched to RESP3 with HELLO 3.
+	resp3 atomic.Bool
+
+	// sub holds the subscriptions of the connection. detached is set when
+	// the connection is handed over to serveSubscriber, which unregisters it
+	// when it is closed.
+	sub      *subscriber
+	detached bool
+	// tracking is set while CLIENT TRACKING is on. The rest of the tracking
+	// state is in Redis.tracking.
+	tracking bool
+	// db is the database selected with SELECT.
+	db int
+
+	// mu guards the fields that CLIENT LIST reads from other connections.
+	mu       sync.Mutex
+	name     string
+	user     string
+	lastCmd  string
+	lastSeen time.Time
+	libName  string
+	libVer   string
+	// busy is set while a command of the connection is served.
+	busy bool
+	// password and loggedIn keep the credentials of AUTH, to authenticate
+	// the connection forwarded to the leader.
+	password string
+	loggedIn bool
+
+	// tx is the transaction state from the first WATCH or MULTI until EXEC,
+	// DISCARD or UNWATCH.
+	tx *txState
+	// shard is the shard the current command is routed to, through whose
+	// Raft log apply replicates its writes.
+	shard *Shard
+	// asking is set by ASKING and lets the next command use a slot being
+	// migrated to a shard this node leads.
+	asking bool
+	// consistency is the consistency level of the reads of the connection,
+	// set with CLIENT CONSISTENCY, or to stale with READONLY.
+	consistency consistency
+	// limit and ipLimit are the rate limiters of the connection and of its
+	// IP address ip.
+	limit   limiter
+	ip      string
+	ipLimit *ipLimiter
+	// requestID is the request ID given with REQID to the command being
+	// served, which apply attaches to its first write.
+	requestID string
+	// readAfters holds the log index the reads of the connection wait for
+	// in each shard, by shard index: its last write, or the index given to
+	// RAFT.READAFTER.
+	readAfters map[int]uint64
+	// lastWrite is the shard of the last write of the connection, which
+	// WAIT waits for.
+	lastWrite *Shard
+	// args is the command line being served, which is forwarded to the
+	// leader in place of a redirect when forward-to-leader is enabled, on
+	// fwd.
+	args [][]byte
+	fwd  *forwarder
+	// flushed is set while redcon holds no reply of the connection yet to
+	// be written, for writeBulk to stream a large reply, and pipelined
+	// while the commands the client pipelined follow the one served.
+	flushed   bool
+	pipelined bool
+	// traceParent and traceState are the W3C trace context set with CLIENT
+	// SETINFO, which the spans of the commands of the connection continue.
+	// traceCtx holds the span of the command being served.
+	traceParent string
+	traceState  string
+	traceCtx    context.Context
+}
+
+func (st *connState) setName(name string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.name = name
+}
+
+func (st *connState) getName() string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.name
+}
+
+// getUser returns the ACL user the connection is authenticated as.
+func (st *connState) getUser() string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.user
+}
+
+// login authenticates the connection as user.
+func (st *connState) login(user, pass string) {
+	st.mu.Lock()
+	st.user, st.password, st.loggedIn = user, pass, true
+	st.mu.Unlock()
+	st.authenticated.Store(true)
+}
+
+// credentials returns the user and the password the connection
+// authenticated with, if it sent any.
+func (st *connState) credentials() (string, string, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.user, st.password, st.loggedIn
+}
+
+// closeForwarder closes the connection to the leader, if any.
+func (st *connState) closeForwarder() {
+	if st.fwd != nil {
+		st.fwd.conn.Close()
+		st.fwd = nil
+	}
+}
+
+// seen records that the connection sent cmd.
+func (st *connState) seen(cmd string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.lastCmd = strings.ToLower(cmd)
+	st.lastSeen = time.Now()
+}
+
+// stateOf returns the state of conn. Connections that did not go through
+// accept, such as the ones replaying a transaction, get an empty state.
+func stateOf(conn redcon.Conn) *connState {
+	if st, ok := conn.Context().(*connState); ok {
+		return st
+	}
+	st := &connState{}
+	conn.SetContext(st)
+	return st
+}
+
+// accept sets up the state of a new connection and registers it. Once
+// maxclients clients are connected, new connections get an error and are
+// closed.
+func (r *Redis) accept(conn redcon.Conn) bool {
+	r.clientsMu.RLock()
+	n := len(r.clients)
+	r.clientsMu.RUnlock()
+	if m := r.maxClients.Load(); m > 0 && int64(n) >= m {
+		r.rejectedConns.Add(1)
+		conn.NetConn().SetWriteDeadline(time.Now().Add(time.Second))
+		conn.WriteError(errMaxClients.Error())
+		return false
+	}
+
+	now := time.Now()
+	st := &connState{id: r.connID.Add(1), conn: conn, created: now, user: acl.DefaultUser, lastSeen: now}
+	st.ctx, st.cancel = context.WithCancel(context.Background())
+	st.authenticated.Store(!r.passwordRequired())
+	conn.SetContext(st)
+
+	r.clientsMu.Lock()
+	r.clients[st.id] = st
+	r.clientsMu.Unlock()
+	r.trackIP(st)
+
+	r.extendDeadline(conn)
+	return true
+}
+
+// closed unregisters a connection that was closed. A detached connection
+// is still served, by serveSubscriber.
+func (r *Redis) closed(conn redcon.Conn, err error) {
+	st, ok := conn.Context().(*connState)
+	if ok && st.detached {
+		return
+	}
+	if ok {
+		r.unregister(st)
+	}
+	if err != nil {
+		l := r.log().With("client", conn.RemoteAddr())
+		if ok {
+			l = r.connLog(st)
+		}
+		l.Warn("connection closed with an error", "error", err)
+	}
+}
+
+func (r *Redis) unregister(st *connState) {
+	if st.cancel != nil {
+		st.cancel()
+	}
+	r.clientsMu.Lock()
+	delete(r.clients, st.id)
+	r.clientsMu.Unlock()
+	r.untrackIP(st)
+	r.untrack(st)
+	st.closeForwarder()
+}
+
+// ping handles PING [message]. A subscribed RESP2 connection gets the reply
+// as an array, as its messages are. Like a Redis server loading its
+// dataset, the node replies LOADING until it is ready for every shard, so
+// the health checks that ping it keep clients away from a cold node.
+func (r *Redis) ping(conn redcon.Conn, cmd redcon.Command) {
+	if stateOf(conn).sub == nil && !r.allReady() {
+		conn.WriteError(errLoading.Error())
+		return
+	}
+	if stateOf(conn).sub != nil && !isRESP3(conn) {
+		conn.WriteArray(2)
+		conn.WriteBulkString("pong")
+		if len(cmd.Args) > 1 {
+			conn.WriteBulk(cmd.Args[1])
+		} else {
+			conn.WriteBulkString("")
+		}
+		return
+	}
+	if len(cmd.Args) > 1 {
+		conn.WriteBulk(cmd.Args[1])
+		return
+	}
+	conn.WriteString("PONG")
+}
+
+// quit handles QUIT. The connection is closed once the reply is flushed.
+func (r *Redis) quit(conn redcon.Conn) {
+	conn.WriteString("OK")
+	conn.Close()
+}
+
+// hello handles HELLO [protover [AUTH username password] [SETNAME name]].
+// protover 2 and 3 select RESP2 and RESP3.
+func (r *Redis) hello(conn redcon.Conn, cmd redcon.Command) {
+	args := cmd.Args[1:]
+	ver := 0
+	if len(args) > 0 {
+		var err error
+		ver, err = strconv.Atoi(string(args[0]))
+		if err != nil {
+			conn.WriteError("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if ver != 2 && ver != 3 {
+			conn.WriteError("NOPROTO unsupported protocol version")
+			return
+		}
+		args = args[1:]
+	}
+
+	st := stateOf(conn)
+	name, setName := "", false
+	for len(args) > 0 {
+		switch opt := strings.ToUpper(string(args[0])); {
+		case opt == "AUTH" && len(args) >= 3:
+			if !r.authenticate(string(args[1]), string(args[2])) {
+				conn.WriteError(errWrongPass.Error())
+				return
+			}
+			st.login(string(args[1]), string(args[2]))
+			args = args[3:]
+		case opt == "SETNAME" && len(args) >= 2:
+			name, setName = string(args[1]), true
+			args = args[2:]
+		default:
+			conn.WriteError("ERR Syntax error in HELLO option '" + string(args[0]) + "'")
+			return
+		}
+	}
+
+	if !st.authenticated.Load() {
+		conn.WriteError("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+		return
+	}
+	if setName {
+		if !validClientName(name) {
+			conn.WriteError(errClientName.Error())
+			return
+		}
+		st.setName(name)
+	}
+	if ver != 0 {
+		st.resp3.Store(ver == 3)
+	}
+	proto := 2
+	if st.resp3.Load() {
+		proto = 3
+	}
+
+	role := "replica"
+	if r.raft.State() == hraft.Leader {
+		role = "master"
+	}
+
+	writeMap(conn, 7)
+	conn.WriteBulkString("server")
+	conn.WriteBulkString("redis")
+	conn.WriteBulkString("version")
+	conn.WriteBulkString(serverVersion)
+	conn.WriteBulkString("proto")
+	conn.WriteInt(proto)
+	conn.WriteBulkString("id")
+	conn.WriteInt64(st.id)
+	conn.WriteBulkString("mode")
+	conn.WriteBulkString("standalone")
+	conn.WriteBulkString("role")
+	conn.WriteBulkString(role)
+	conn.WriteBulkString("modules")
+	conn.WriteArray(0)
+}
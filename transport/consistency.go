@@ -0,0 +1,205 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	hraft "github.com/hashicorp/raft"
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/store"
+)
+
+// consistency is the consistency level of a read.
+type consistency int32
+
+const (
+	// consistencyDefault leaves the level to the read-consistency of the
+	// node.
+	consistencyDefault consistency = iota
+	// leaderLocal reads are served by the leader from its store.
+	leaderLocal
+	// linearizable reads are served by the leader once a quorum confirmed
+	// that it still leads.
+	linearizable
+	// stale reads may be served by a follower, within the bounds of
+	// follower-read-max-staleness and follower-read-max-lag.
+	stale
+)
+
+var consistencyNames = []string{"default", "leader-local", "linearizable", "stale"}
+
+var errConsistency = errors.New("ERR consistency must be one of leader-local, linearizable, stale")
+
+func (c consistency) String() string {
+	return consistencyNames[c]
+}
+
+// parseConsistency parses a consistency level, case-insensitively.
+func parseConsistency(s string) (consistency, error) {
+	i := slices.Index(consistencyNames[1:], strings.ToLower(s))
+	if i < 0 {
+		return 0, errConsistency
+	}
+	return consistency(i + 1), nil
+}
+
+// getConsistency returns the level GET key [LEADER-LOCAL | LINEARIZABLE |
+// STALE] asks for.
+func getConsistency(args [][]byte) (consistency, error) {
+	switch len(args) {
+	case 2:
+		return consistencyDefault, nil
+	case 3:
+		c, err := parseConsistency(string(args[2]))
+		if err != nil {
+			return 0, errSyntax
+		}
+		return c, nil
+	}
+	return 0, errSyntax
+}
+
+// levelOf returns the consistency level a command line of st is read with:
+// the one given to GET, or else the one of the connection, set with CLIENT
+// CONSISTENCY or READONLY, or else read-consistency.
+func (r *Redis) levelOf(st *connState, name string, args [][]byte) (consistency, error) {
+	if !isReadCmd(name) {
+		return leaderLocal, nil
+	}
+	c := consistencyDefault
+	if name == "GET" {
+		var err error
+		if c, err = getConsistency(args); err != nil {
+			return 0, err
+		}
+	}
+	if c == consistencyDefault {
+		c = st.consistency
+	}
+	if c == consistencyDefault {
+		c = consistency(r.consistency.Load())
+	}
+	return c, nil
+}
+
+// isReadCmd reports whether name only reads keys, and can therefore be
+// served by a follower.
+func isReadCmd(name string) bool {
+	return slices.Contains(cmdCategories[name], "read") && !nodeCmds[name]
+}
+
+// fresh reports whether this node may serve the stale reads of sh. The leader always may. A follower may when it heard from the
+// leader within follower-read-max-staleness milliseconds and has applied
+// all but follower-read-max-lag of the entries it knows are committed. 0
+// disables either bound.
+func (r *Redis) fresh(sh *Shard) bool {
+	if sh.Raft.State() == hraft.Leader {
+		return true
+	}
+	if max := r.maxStaleness.Load(); max > 0 {
+		if time.Since(sh.Raft.LastContact()) > time.Duration(max)*time.Millisecond {
+			return false
+		}
+	}
+	if max := r.maxLag.Load(); max > 0 {
+		if commit, applied := sh.Raft.CommitIndex(), sh.Raft.AppliedIndex(); commit > applied && commit-applied > uint64(max) {
+			return false
+		}
+	}
+	return true
+}
+
+// readIndex makes the next read of sh linearizable. It notes the last index
+// of the log, confirms with a quorum that this node still leads the shard
+// and waits until the entries up to the index are applied, so that the read
+// sees every write completed before it started. With leader-local reads,
+// the leader serves reads from its store right away, and a deposed leader
+// may do so until its lease expires.
+func (r *Redis) readIndex(ctx context.Context, sh *Shard) error {
+	index := sh.Raft.LastIndex()
+	if err := await(ctx, sh.Raft.VerifyLeader()); err != nil {
+		return err
+	}
+	return r.waitApplied(ctx, sh, index)
+}
+
+// waitApplied waits until this node applied the entries of sh up to index,
+// for at most raft-apply-timeout, or until ctx is done.
+func (r *Redis) waitApplied(ctx context.Context, sh *Shard, index uint64) error {
+	if sh.Raft.AppliedIndex() >= index {
+		return nil
+	}
+	timeout := time.After(time.Duration(r.applyTimeout.Load()) * time.Millisecond)
+	for sh.Raft.AppliedIndex() < index {
+		select {
+		case <-timeout:
+			return hraft.ErrEnqueueTimeout
+		case <-ctx.Done():
+			return store.ContextErr(ctx)
+		case <-time.After(time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// readAfter makes the next reads of st in sh wait until index is applied.
+func (st *connState) readAfter(sh *Shard, index uint64) {
+	if st.readAfters == nil {
+		st.readAfters = map[int]uint64{}
+	}
+	st.readAfters[sh.index] = max(st.readAfters[sh.index], index)
+}
+
+// readIndexOf returns the index the reads of st in sh wait for.
+func (st *connState) readIndexOf(sh *Shard) uint64 {
+	return st.readAfters[sh.index]
+}
+
+// sessionShard returns the shard of the optional key argument of RAFT.INDEX
+// and RAFT.READAFTER, the first shard without one.
+func (r *Redis) sessionShard(args [][]byte) (*Shard, error) {
+	switch len(args) {
+	case 0:
+		return r.shards[0], nil
+	case 1:
+		return r.shardOfKey(args[0]), nil
+	}
+	return nil, errSyntax
+}
+
+// raftIndex handles RAFT.INDEX [key], which replies with the log index the
+// reads of the connection wait for in the shard of key: the index of its
+// last write, unless RAFT.READAFTER gave a later one. Passing it to
+// RAFT.READAFTER on another connection, to any node, lets that connection
+// read the write.
+func (r *Redis) raftIndex(conn redcon.Conn, cmd redcon.Command) {
+	sh, err := r.sessionShard(cmd.Args[1:])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteInt64(int64(stateOf(conn).readIndexOf(sh)))
+}
+
+// raftReadAfter handles RAFT.READAFTER index [key], which makes the next
+// reads of the connection in the shard of key wait until this node applied
+// the log up to index.
+func (r *Redis) raftReadAfter(conn redcon.Conn, cmd redcon.Command) {
+	index, err := strconv.ParseUint(string(cmd.Args[1]), 10, 64)
+	if err != nil {
+		conn.WriteError(errNotInteger.Error())
+		return
+	}
+	sh, err := r.sessionShard(cmd.Args[2:])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	stateOf(conn).readAfter(sh, index)
+	conn.WriteString("OK")
+}
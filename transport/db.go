@@ -0,0 +1,132 @@
+package transport
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/cluster"
+	"raft-redis-cluster/raft"
+	"raft-redis-cluster/store"
+)
+
+// defaultDatabases is the number of databases by default, as in Redis.
+const defaultDatabases = 16
+
+var (
+	errDBIndex = errors.New("ERR DB index is out of range")
+	errDBKey   = errors.New(`ERR keys starting with "\x00db" are reserved`)
+)
+
+// WithDatabases sets the number of databases clients can SELECT.
+func WithDatabases(n int) Option {
+	return func(r *Redis) { r.databases = n }
+}
+
+// parseDB parses the index of a database.
+func (r *Redis) parseDB(b []byte) (int, error) {
+	n, err := strconv.Atoi(string(b))
+	if err != nil {
+		return 0, errNotInteger
+	}
+	if n < 0 || n >= r.databases {
+		return 0, errDBIndex
+	}
+	return n, nil
+}
+
+// selectDB handles SELECT index. The keys of the databases other than 0
+// are kept in the same keyspace, under a prefix naming their database.
+func (r *Redis) selectDB(conn redcon.Conn, cmd redcon.Command) {
+	n, err := r.parseDB(cmd.Args[1])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	stateOf(conn).db = n
+	conn.WriteString("OK")
+}
+
+// selectedDB returns the database selected by the client of conn.
+func selectedDB(conn redcon.Conn) int {
+	if tc, ok := conn.(*txConn); ok {
+		if tc.Conn == nil {
+			return tc.db
+		}
+		conn = tc.Conn
+	}
+	return stateOf(conn).db
+}
+
+// useDB rewrites the keys of cmd into the keys of database db, once the
+// command passed its checks against the keys the client sent.
+func useDB(db int, cmd redcon.Command) {
+	if db == 0 {
+		return
+	}
+	first, end, step := cmdKeyRange(commandOf(cmd), cmd.Args)
+	if step == 0 {
+		return
+	}
+	for i := first; i < min(end, len(cmd.Args)); i += step {
+		cmd.Args[i] = store.DBKey(db, cmd.Args[i])
+	}
+}
+
+// userKey returns a key as the client of its database sees it.
+func userKey(key []byte) []byte {
+	_, k := store.SplitDBKey(key)
+	return k
+}
+
+// keySlot returns the hash slot of a key, which is that of the key in its
+// database, so that a key is in the same slot in every database.
+func keySlot(key []byte) int {
+	return cluster.KeySlot(userKey(key))
+}
+
+// swapDB handles SWAPDB index1 index2 [SHARD i]. Like FLUSHDB, it swaps the
+// keys of every shard, or of the shard i, one log entry per shard.
+func (r *Redis) swapDB(conn redcon.Conn, cmd redcon.Command) {
+	shards, args, err := r.parseMemberShard(cmd.Args)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	if len(args) != 3 {
+		conn.WriteError(errSyntax.Error())
+		return
+	}
+	a, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		conn.WriteError("ERR invalid first DB index")
+		return
+	}
+	b, err := strconv.Atoi(string(args[2]))
+	if err != nil {
+		conn.WriteError("ERR invalid second DB index")
+		return
+	}
+	if a < 0 || a >= r.databases || b < 0 || b >= r.databases {
+		conn.WriteError(errDBIndex.Error())
+		return
+	}
+	if a == b {
+		conn.WriteString("OK")
+		return
+	}
+
+	dbs := [][]byte{[]byte(strconv.Itoa(a)), []byte(strconv.Itoa(b))}
+	kvCmd := func() *raft.KVCmd { return &raft.KVCmd{Op: raft.SwapDB, Args: dbs} }
+	if _, ok := conn.(*txConn); ok {
+		_, err = r.apply(conn, kvCmd())
+	} else {
+		err = r.applyShards(conn, shards, kvCmd, append([][]byte{[]byte("SWAPDB")}, dbs...))
+	}
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteString("OK")
+}
@@ -0,0 +1,93 @@
+package transport
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	hraft "github.com/hashicorp/raft"
+
+	"raft-redis-cluster/store"
+)
+
+// blockingCmds are the commands that wait for as long as their own timeout
+// asks for, which the command timeouts don't cut short. They still stop
+// waiting once their connection is closed.
+var blockingCmds = map[string]bool{
+	"WAIT":     true,
+	"XREAD":    true,
+	"CDC.READ": true,
+}
+
+// context returns the context of the connection of st, canceled once it is
+// closed. A local connection has the context passed to Do instead.
+func (st *connState) context() context.Context {
+	if st.ctx == nil {
+		return context.Background()
+	}
+	return st.ctx
+}
+
+// kill closes the connection of st from another goroutine, abandoning the
+// command it serves. redcon only tears the connection down once the command
+// returned, so its context is canceled here rather than in unregister.
+func (st *connState) kill() {
+	if st.cancel != nil {
+		st.cancel()
+	}
+	st.conn.NetConn().Close()
+}
+
+// commandContext returns the context of the command name of st: that of its
+// connection, with the deadline of timeoutOf. The waits of the command for
+// its entries to be applied and for the leader to answer give up once it is
+// done, as do its reads of the store waiting for a write to finish. The
+// commands of a connection closed with CLIENT KILL or by the shutdown of the
+// node are abandoned at once, but a client hanging up is only noticed by
+// redcon once the command returned, so a command it left runs until its
+// deadline.
+func (r *Redis) commandContext(st *connState, name string) (context.Context, context.CancelFunc) {
+	ms := r.timeoutOf(name)
+	if ms == 0 {
+		return st.context(), func() {}
+	}
+	return context.WithTimeout(st.context(), time.Duration(ms)*time.Millisecond)
+}
+
+// timeoutOf returns how many milliseconds the command name may run, 0 for no
+// limit: command-read-timeout for the commands of the read category and
+// command-write-timeout for those of the write category when set, and
+// command-timeout otherwise.
+func (r *Redis) timeoutOf(name string) int64 {
+	if blockingCmds[name] {
+		return 0
+	}
+	var ms int64
+	switch cats := cmdCategories[name]; {
+	case slices.Contains(cats, "read"):
+		ms = r.cmdReadTimeout.Load()
+	case slices.Contains(cats, "write"):
+		ms = r.cmdWriteTimeout.Load()
+	}
+	if ms == 0 {
+		ms = r.commandTimeout.Load()
+	}
+	return ms
+}
+
+// await waits for f like f.Error, or until ctx is done. An entry handed to
+// Raft is not withdrawn when the wait is abandoned, and may still be
+// committed.
+func await(ctx context.Context, f hraft.Future) error {
+	if ctx.Done() == nil {
+		return f.Error()
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- f.Error() }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return store.ContextErr(ctx)
+	}
+}
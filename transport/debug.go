@@ -0,0 +1,110 @@
+package transport
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/redcon"
+)
+
+// debug handles DEBUG FAULT, the only DEBUG subcommand.
+func (r *Redis) debug(conn redcon.Conn, cmd redcon.Command) {
+	if !strings.EqualFold(string(cmd.Args[1]), "FAULT") {
+		conn.WriteError("ERR unknown subcommand '" + string(cmd.Args[1]) + "'. Try DEBUG HELP.")
+		return
+	}
+	if r.faults == nil {
+		conn.WriteError("ERR fault injection is disabled, start the node with --fault_injection")
+		return
+	}
+	if len(cmd.Args) < 3 {
+		conn.WriteError("ERR wrong number of arguments for 'debug|fault' command")
+		return
+	}
+	r.debugFault(conn, cmd)
+}
+
+// debugFault handles the failure points of this node:
+//
+//	DEBUG FAULT DROP probability
+//	DEBUG FAULT PARTITION node-id [node-id ...]
+//	DEBUG FAULT HEAL
+//	DEBUG FAULT APPLYDELAY milliseconds
+//	DEBUG FAULT STOREFAIL probability
+//	DEBUG FAULT SEED seed
+//	DEBUG FAULT RESET
+//	DEBUG FAULT STATUS
+//
+// A partition cuts this node off from the given nodes in both directions,
+// so both sides of a real partition are made by partitioning one of them.
+func (r *Redis) debugFault(conn redcon.Conn, cmd redcon.Command) {
+	f := r.faults
+	sub := strings.ToUpper(string(cmd.Args[2]))
+	args := cmd.Args[3:]
+	switch {
+	case (sub == "DROP" || sub == "STOREFAIL") && len(args) == 1:
+		p, err := strconv.ParseFloat(string(args[0]), 64)
+		if err != nil {
+			conn.WriteError(errNotFloat.Error())
+			return
+		}
+		set := f.SetDropRate
+		if sub == "STOREFAIL" {
+			set = f.SetStoreFailRate
+		}
+		if err := set(p); err != nil {
+			conn.WriteError("ERR probability must be between 0 and 1")
+			return
+		}
+		conn.WriteString("OK")
+
+	case sub == "PARTITION" && len(args) > 0:
+		ids := make([]string, len(args))
+		for i, a := range args {
+			ids[i] = string(a)
+		}
+		f.Partition(ids...)
+		conn.WriteString("OK")
+
+	case sub == "APPLYDELAY" && len(args) == 1:
+		ms, err := strconv.ParseInt(string(args[0]), 10, 64)
+		if err != nil || ms < 0 {
+			conn.WriteError(errNotInteger.Error())
+			return
+		}
+		f.SetApplyDelay(time.Duration(ms) * time.Millisecond)
+		conn.WriteString("OK")
+
+	case sub == "SEED" && len(args) == 1:
+		seed, err := strconv.ParseUint(string(args[0]), 10, 64)
+		if err != nil {
+			conn.WriteError(errNotInteger.Error())
+			return
+		}
+		f.Seed(seed)
+		conn.WriteString("OK")
+
+	case sub == "HEAL" && len(args) == 0:
+		f.Heal()
+		conn.WriteString("OK")
+
+	case sub == "RESET" && len(args) == 0:
+		f.Reset()
+		conn.WriteString("OK")
+
+	case sub == "STATUS" && len(args) == 0:
+		status := f.Status()
+		conn.WriteArray(len(status))
+		for _, s := range status {
+			conn.WriteBulkString(s)
+		}
+
+	case sub == "DROP" || sub == "STOREFAIL" || sub == "PARTITION" || sub == "APPLYDELAY" ||
+		sub == "SEED" || sub == "HEAL" || sub == "RESET" || sub == "STATUS":
+		conn.WriteError("ERR wrong number of arguments for 'debug|fault|" + strings.ToLower(sub) + "' command")
+
+	default:
+		conn.WriteError("ERR unknown subcommand '" + string(cmd.Args[2]) + "'. Try DEBUG HELP.")
+	}
+}
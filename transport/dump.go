@@ -0,0 +1,116 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/raft"
+	"raft-redis-cluster/rdb"
+	"raft-redis-cluster/store"
+)
+
+// dump handles DUMP key. The value is serialized in the format of Redis, so
+// it can be restored here or into a Redis server.
+func (r *Redis) dump(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	data, err := r.store.Dump(ctx, cmd.Args[keyName])
+	if errors.Is(err, store.ErrKeyNotFound) {
+		conn.WriteNull()
+		return
+	}
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	v, err := store.DecodeValue(data)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	b, err := rdb.Encode(v)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	r.writeBulk(conn, b)
+}
+
+// restore handles RESTORE key ttl payload [REPLACE] [ABSTTL] [IDLETIME s]
+// [FREQ f]. IDLETIME and FREQ are checked but not applied, as the access
+// time and frequency of a key are kept by each replica.
+func (r *Redis) restore(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	ttl, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+	if err != nil {
+		conn.WriteError(errNotInteger.Error())
+		return
+	}
+	if ttl < 0 {
+		conn.WriteError("ERR Invalid TTL value, must be >= 0")
+		return
+	}
+
+	replace, absTTL, idle, freq := false, false, false, false
+	for i := 4; i < len(cmd.Args); i++ {
+		switch opt := strings.ToUpper(string(cmd.Args[i])); {
+		case opt == "REPLACE":
+			replace = true
+		case opt == "ABSTTL":
+			absTTL = true
+		case (opt == "IDLETIME" || opt == "FREQ") && i+1 < len(cmd.Args) && !idle && !freq:
+			n, err := strconv.ParseInt(string(cmd.Args[i+1]), 10, 64)
+			if err != nil {
+				conn.WriteError(errNotInteger.Error())
+				return
+			}
+			if opt == "IDLETIME" && n < 0 {
+				conn.WriteError("ERR Invalid IDLETIME value, must be >= 0")
+				return
+			}
+			if opt == "FREQ" && (n < 0 || n > 255) {
+				conn.WriteError("ERR Invalid FREQ value, must be >= 0 and <= 255")
+				return
+			}
+			idle, freq = opt == "IDLETIME", opt == "FREQ"
+			i++
+		default:
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+	}
+
+	v, err := rdb.Decode(cmd.Args[3])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	switch {
+	case ttl > 0 && absTTL:
+		v.ExpireAt = ttl
+	case ttl > 0:
+		at, ok := unixMilli(store.Now(ctx), ttl, time.Millisecond, true)
+		if !ok {
+			conn.WriteError("ERR invalid expire time in 'restore' command")
+			return
+		}
+		v.ExpireAt = at
+	}
+	data, err := store.EncodeValue(v)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	kvCmd := &raft.KVCmd{Op: raft.RestoreKey, Key: cmd.Args[keyName], Val: data, Cond: raft.CondNX}
+	if replace {
+		kvCmd.Cond = raft.CondNone
+	}
+	if _, err := r.apply(conn, kvCmd); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteString("OK")
+}
@@ -0,0 +1,117 @@
+package transport
+
+import (
+	"fmt"
+	"strings"
+
+	hraft "github.com/hashicorp/raft"
+)
+
+// raftEventsPrefix is the prefix of the channels the Raft events are
+// published on, which the clients can't publish on.
+const raftEventsPrefix = "__raft__:"
+
+// raftEventsBacklog is how many events wait to be delivered. The events
+// beyond it are dropped, as pub/sub is fire-and-forget.
+const raftEventsBacklog = 64
+
+// RaftEvent is a change of the Raft group of a shard seen by this node. It
+// is published to the subscribers of this node on the channel __raft__:Kind,
+// and passed to the function set with WithRaftEvents.
+type RaftEvent struct {
+	// Kind is leader when the shard elected a leader or lost it; peer when
+	// the leader starts or stops replicating to a server, which it does on
+	// a change of the membership and for every server once elected; and
+	// snapshot or restore when this node saved a snapshot of the shard or
+	// was restored from one.
+	Kind  string
+	Shard int
+	// ID and Address are the leader of a leader event, empty while the
+	// shard has none, or the server of a peer event.
+	ID      string
+	Address string
+	// Removed is set on the peer event of a server no longer replicated to.
+	Removed bool
+	// Snapshot is the ID of the snapshot of a snapshot event.
+	Snapshot string
+}
+
+// Message returns the message of e published on its channel: its fields as
+// name=value pairs separated by commas, as in INFO.
+func (e RaftEvent) Message() string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "shard=%d", e.Shard)
+	switch e.Kind {
+	case "leader":
+		fmt.Fprintf(b, ",leader_id=%s,leader_address=%s", e.ID, e.Address)
+	case "peer":
+		fmt.Fprintf(b, ",id=%s,address=%s,removed=%d", e.ID, e.Address, boolToInt(e.Removed))
+	case "snapshot":
+		fmt.Fprintf(b, ",id=%s", e.Snapshot)
+	}
+	return b.String()
+}
+
+// WithRaftEvents sets f to be called with the Raft events of this node, one
+// at a time, while the server serves.
+func WithRaftEvents(f func(RaftEvent)) Option {
+	return func(r *Redis) { r.onRaftEvent = f }
+}
+
+// watchRaft observes the Raft group of sh for its events and the health of
+// its followers. The observer handles the observations in its filter, so it
+// has no channel to deliver them to.
+func (r *Redis) watchRaft(sh *Shard) {
+	sh.Raft.RegisterObserver(hraft.NewObserver(nil, false, func(o *hraft.Observation) bool {
+		sh.health.observe(o.Data)
+		switch d := o.Data.(type) {
+		case hraft.LeaderObservation:
+			r.raftEvent(RaftEvent{Kind: "leader", Shard: sh.index, ID: string(d.LeaderID), Address: string(d.LeaderAddr)})
+		case hraft.PeerObservation:
+			r.raftEvent(RaftEvent{Kind: "peer", Shard: sh.index, ID: string(d.Peer.ID), Address: string(d.Peer.Address), Removed: d.Removed})
+		}
+		return false
+	}))
+	sh.FSM.AddSnapshotObserver(shardSnapshots{r: r, shard: sh.index})
+}
+
+// shardSnapshots turns the snapshots of the state machine of a shard into
+// Raft events.
+type shardSnapshots struct {
+	r     *Redis
+	shard int
+}
+
+func (s shardSnapshots) SnapshotSaved(id string) {
+	s.r.raftEvent(RaftEvent{Kind: "snapshot", Shard: s.shard, Snapshot: id})
+}
+
+func (s shardSnapshots) SnapshotRestored() {
+	s.r.raftEvent(RaftEvent{Kind: "restore", Shard: s.shard})
+}
+
+// raftEvent queues e for deliverRaftEvents without blocking Raft, dropping
+// it when the backlog is full.
+func (r *Redis) raftEvent(e RaftEvent) {
+	select {
+	case r.raftEvents <- e:
+	default:
+	}
+}
+
+// deliverRaftEvents publishes the Raft events to the subscribers of this
+// node and passes them to the function of WithRaftEvents, until stop is
+// closed.
+func (r *Redis) deliverRaftEvents(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case e := <-r.raftEvents:
+			r.Publish(raftEventsPrefix+e.Kind, e.Message())
+			if r.onRaftEvent != nil {
+				r.onRaftEvent(e)
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package transport
+
+import (
+	"context"
+	"time"
+
+	hraft "github.com/hashicorp/raft"
+
+	"raft-redis-cluster/raft"
+)
+
+// defaultHz is how many times a second the expired keys are deleted by
+// default, as the hz of Redis. An hz of 0 stops deleting them, and reads
+// only hide them.
+const defaultHz = 10
+
+// activeExpireSamples is how many keys with an expiration are sampled at
+// once. Another sample is taken at once while more than a quarter of them
+// were expired, as in Redis.
+const activeExpireSamples = 20
+
+// activeExpire deletes the expired keys of the shards this node leads hz
+// times a second, until stop is closed: those found by reads since the last
+// time, then a sample of the keys with an expiration. Reads only hide the
+// keys once they expire, so without it they would stay in memory and in the
+// snapshots of every replica.
+func (r *Redis) activeExpire(stop <-chan struct{}) {
+	for {
+		hz := r.hz.Load()
+		wait := time.Second / defaultHz
+		if hz > 0 {
+			wait = time.Second / time.Duration(hz)
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-stop:
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		if r.hz.Load() == 0 {
+			continue
+		}
+		for _, sh := range r.shards {
+			if sh.Raft.State() == hraft.Leader {
+				// A cycle takes at most a quarter of the time between two.
+				r.expireShard(sh, time.Now().Add(wait/4))
+			}
+		}
+	}
+}
+
+// expireShard deletes the expired keys of sh through its Raft log, until
+// few of the keys sampled are expired or the deadline passes. Each entry
+// deletes a key only if it is still expired at the time of the entry, so
+// every replica deletes the same keys, even if one was written since.
+func (r *Redis) expireShard(sh *Shard, deadline time.Time) {
+	for time.Now().Before(deadline) {
+		keys, sampled, err := sh.Store.ExpiredKeys(context.Background(), activeExpireSamples)
+		if err != nil {
+			r.logShard(sh).Warn("sampling expired keys failed", "error", err)
+			return
+		}
+		if len(keys) == 0 {
+			return
+		}
+
+		batch := &raft.KVCmd{Op: raft.Batch}
+		for _, k := range keys {
+			batch.Cmds = append(batch.Cmds, raft.KVCmd{Op: raft.Del, Key: k, Expired: true})
+		}
+		res, err := r.applyTo(sh, batch)
+		if err != nil {
+			r.logShard(sh).Warn("deleting expired keys failed", "error", err)
+			return
+		}
+		results, _ := res.([]any)
+		for _, n := range results {
+			if n == 1 {
+				r.expiredKeys.Add(1)
+			}
+		}
+
+		if len(keys)*4 <= sampled {
+			return
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/store"
+)
+
+// forwardDialTimeout is how long connecting to the leader may take.
+const forwardDialTimeout = 5 * time.Second
+
+// forwarder is the connection on which the commands of a client are
+// forwarded to the leader of a shard, when forward-to-leader is enabled.
+// Each client has its own, authenticated as the client and speaking its
+// protocol version, so that the leader keeps the state of the client, such
+// as its watched keys, between commands.
+type forwarder struct {
+	addr  string
+	resp3 bool
+	conn  net.Conn
+	br    *bufio.Reader
+	// watching is set while the client watches keys on the leader.
+	watching bool
+}
+
+// SetForwardTLS sets the configuration used to dial the leader when
+// commands are forwarded to it. Without one, the leader is dialed without
+// TLS.
+func (r *Redis) SetForwardTLS(cfg *tls.Config) {
+	r.forwardTLS = cfg
+}
+
+// forwarding reports whether redirects are replaced by forwarding. The
+// commands of Do are always forwarded, as there is no client to redirect.
+func (r *Redis) forwarding(conn redcon.Conn) bool {
+	switch conn.(type) {
+	case *txConn:
+		return false
+	case *localConn:
+		return true
+	}
+	return r.forwardToLeader.Load()
+}
+
+// forward sends lines to the leader of sh on the forwarder of conn and
+// writes the reply of the last one to conn. The replies of the others are
+// discarded. Once the context of the command is done, the round trip is cut
+// short and the forwarder, left in the middle of it, is closed.
+func (r *Redis) forward(conn redcon.Conn, sh *Shard, lines ...[][]byte) {
+	_, lid := sh.Raft.LeaderWithID()
+	addr, err := store.GetRedisAddrByNodeID(r.stableStore, lid)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	st := stateOf(conn)
+	name := commandOf(redcon.Command{Args: lines[len(lines)-1]})
+	if f := st.fwd; f != nil && f.watching && f.addr != addr && name == "EXEC" {
+		// The keys were watched on a former leader, which can't tell
+		// whether they changed.
+		st.closeForwarder()
+		conn.WriteNull()
+		return
+	}
+
+	if st.requestID != "" {
+		last := append([][]byte{[]byte("REQID"), []byte(st.requestID)}, lines[len(lines)-1]...)
+		lines = append(lines[:len(lines)-1:len(lines)-1], last)
+	}
+
+	f, err := r.forwarderTo(st, addr)
+	if err == nil {
+		ctx := st.spanContext()
+		stop := context.AfterFunc(ctx, func() { f.conn.SetDeadline(time.Now()) })
+		var reply []byte
+		reply, err = f.roundTrip(lines)
+		if !stop() {
+			// The deadline set on the connection outlives the round trip.
+			st.closeForwarder()
+			if err != nil {
+				conn.WriteError(store.ContextErr(ctx).Error())
+				return
+			}
+		}
+		if err == nil {
+			switch name {
+			case "WATCH":
+				f.watching = true
+			case "EXEC":
+				f.watching = false
+			}
+			conn.WriteRaw(reply)
+			return
+		}
+	}
+	st.closeForwarder()
+	conn.WriteError("ERR failed to forward the command to the leader at " + addr + ": " + err.Error())
+}
+
+// unwatchForwarded forgets the keys the client watches on the leader.
+func (st *connState) unwatchForwarded() {
+	f := st.fwd
+	if f == nil || !f.watching {
+		return
+	}
+	if _, err := f.roundTrip([][][]byte{{[]byte("UNWATCH")}}); err != nil {
+		st.closeForwarder()
+		return
+	}
+	f.watching = false
+}
+
+// forwarderTo returns the forwarder of st to addr, connecting to it when st
+// has none or one to another node.
+func (r *Redis) forwarderTo(st *connState, addr string) (*forwarder, error) {
+	resp3 := st.resp3.Load()
+	if f := st.fwd; f != nil {
+		if f.addr == addr && f.resp3 == resp3 {
+			return f, nil
+		}
+		st.closeForwarder()
+	}
+	f, err := r.dialNode(st, addr, resp3)
+	if err != nil {
+		return nil, err
+	}
+	st.fwd = f
+	return f, nil
+}
+
+// dialNode connects to the node at addr, authenticated as the client of st.
+func (r *Redis) dialNode(st *connState, addr string, resp3 bool) (*forwarder, error) {
+	d := &net.Dialer{Timeout: forwardDialTimeout}
+	var c net.Conn
+	var err error
+	if r.forwardTLS != nil {
+		c, err = tls.DialWithDialer(d, "tcp", addr, r.forwardTLS)
+	} else {
+		c, err = d.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	f := &forwarder{addr: addr, resp3: resp3, conn: c, br: bufio.NewReader(c)}
+
+	user, pass, ok := st.credentials()
+	var hello [][]byte
+	switch {
+	case resp3:
+		hello = [][]byte{[]byte("HELLO"), []byte("3")}
+		if ok {
+			hello = append(hello, []byte("AUTH"), []byte(user), []byte(pass))
+		}
+	case ok:
+		hello = [][]byte{[]byte("AUTH"), []byte(user), []byte(pass)}
+	}
+	if hello != nil {
+		reply, err := f.roundTrip([][][]byte{hello})
+		if err == nil && len(reply) > 0 && reply[0] == '-' {
+			err = errors.New(string(reply[1 : len(reply)-2]))
+		}
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// roundTrip sends lines and returns the reply of the last one.
+func (f *forwarder) roundTrip(lines [][][]byte) ([]byte, error) {
+	var b []byte
+	for _, args := range lines {
+		b = redcon.AppendArray(b, len(args))
+		for _, arg := range args {
+			b = redcon.AppendBulk(b, arg)
+		}
+	}
+	if _, err := f.conn.Write(b); err != nil {
+		return nil, err
+	}
+
+	var reply []byte
+	for range lines {
+		reply = reply[:0]
+		var err error
+		if reply, err = readReply(f.br, reply); err != nil {
+			return nil, err
+		}
+	}
+	return reply, nil
+}
+
+// readReply appends the next RESP2 or RESP3 reply read from br to b, as is.
+func readReply(br *bufio.Reader, b []byte) ([]byte, error) {
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("protocol error: invalid reply line")
+	}
+	b = append(b, line...)
+
+	switch line[0] {
+	case '+', '-', ':', '_', ',', '#', '(':
+		return b, nil
+	}
+	n, err := strconv.Atoi(string(line[1 : len(line)-2]))
+	if err != nil {
+		return nil, errors.New("protocol error: invalid length")
+	}
+
+	switch line[0] {
+	case '$', '!', '=':
+		if n < 0 {
+			return b, nil
+		}
+		start := len(b)
+		b = append(b, make([]byte, n+2)...)
+		if _, err := io.ReadFull(br, b[start:]); err != nil {
+			return nil, err
+		}
+		return b, nil
+	case '*', '~', '>':
+	case '%', '|':
+		n *= 2
+	default:
+		return nil, errors.New("protocol error: unknown reply type " + strconv.Quote(string(line[:1])))
+	}
+	for range n {
+		if b, err = readReply(br, b); err != nil {
+			return nil, err
+		}
+	}
+	if line[0] == '|' {
+		// Attributes come before the reply they describe.
+		return readReply(br, b)
+	}
+	return b, nil
+}
@@ -0,0 +1,210 @@
+package transport
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+
+	hraft "github.com/hashicorp/raft"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"raft-redis-cluster/adminpb"
+)
+
+// grpcAdmin serves the gRPC management API, the counterpart of the HTTP one
+// for tooling built on the protobuf definitions of adminpb.
+type grpcAdmin struct {
+	adminpb.UnimplementedClusterAdminServer
+	r *Redis
+}
+
+// AdminServer returns the gRPC management API. Like the HTTP one, it
+// authenticates requests as an ACL user, with the basic credentials of the
+// "authorization" metadata, when clients must authenticate.
+func (r *Redis) AdminServer() adminpb.ClusterAdminServer {
+	return &grpcAdmin{r: r}
+}
+
+// login authenticates the request of ctx and checks that the user may run
+// the command args.
+func (g *grpcAdmin) login(ctx context.Context, args ...string) (*connState, error) {
+	user, pass, ok := "", "", false
+	if md, found := metadata.FromIncomingContext(ctx); found {
+		if auth := md.Get("authorization"); len(auth) > 0 {
+			user, pass, ok = parseBasicAuth(auth[0])
+			if !ok {
+				return nil, status.Error(codes.Unauthenticated, "invalid authorization metadata")
+			}
+		}
+	}
+	st, err := g.r.adminLogin(user, pass, ok, args...)
+	if err != nil {
+		code := codes.PermissionDenied
+		if errors.Is(err, errWrongPass) || errors.Is(err, errNoAuth) {
+			code = codes.Unauthenticated
+		}
+		return nil, status.Error(code, err.Error())
+	}
+	return st, nil
+}
+
+// parseBasicAuth parses the credentials of HTTP basic authentication.
+func parseBasicAuth(auth string) (string, string, bool) {
+	enc, ok := strings.CutPrefix(auth, "Basic ")
+	if !ok {
+		return "", "", false
+	}
+	b, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(b), ":")
+}
+
+// grpcError converts err to a gRPC status.
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, errBadRequest):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, errClusterDown):
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// grpcShards returns the shards of a request, every shard when it names
+// none.
+func (g *grpcAdmin) grpcShards(shards []int32) ([]int, error) {
+	if len(shards) == 0 {
+		return g.r.allShards(), nil
+	}
+	out := make([]int, len(shards))
+	for j, s := range shards {
+		i, err := g.r.parseShard([]byte(strconv.Itoa(int(s))))
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		out[j] = i
+	}
+	return out, nil
+}
+
+func (g *grpcAdmin) AddServer(ctx context.Context, req *adminpb.AddServerRequest) (*adminpb.Ack, error) {
+	st, err := g.login(ctx, "RAFT.ADD")
+	if err != nil {
+		return nil, err
+	}
+	if req.GetId() == "" || req.GetRaftAddress() == "" || req.GetRedisAddress() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id, raft_address and redis_address are required")
+	}
+	shards, err := g.grpcShards(req.GetShards())
+	if err != nil {
+		return nil, err
+	}
+	m := newServer{id: hraft.ServerID(req.GetId()), raftAddr: req.GetRaftAddress(), redisAddr: req.GetRedisAddress(), nonvoter: req.GetNonvoter()}
+	if err := g.r.addMember(st, m, shards); err != nil {
+		return nil, grpcError(err)
+	}
+	return &adminpb.Ack{}, nil
+}
+
+func (g *grpcAdmin) RemoveServer(ctx context.Context, req *adminpb.RemoveServerRequest) (*adminpb.Ack, error) {
+	st, err := g.login(ctx, "RAFT.REMOVE")
+	if err != nil {
+		return nil, err
+	}
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	shards, err := g.grpcShards(req.GetShards())
+	if err != nil {
+		return nil, err
+	}
+	if err := g.r.removeMember(st, hraft.ServerID(req.GetId()), shards); err != nil {
+		return nil, grpcError(err)
+	}
+	return &adminpb.Ack{}, nil
+}
+
+func (g *grpcAdmin) Snapshot(ctx context.Context, req *adminpb.SnapshotRequest) (*adminpb.Ack, error) {
+	if _, err := g.login(ctx, "RAFT.SNAPSHOT"); err != nil {
+		return nil, err
+	}
+	shards, err := g.grpcShards(req.GetShards())
+	if err != nil {
+		return nil, err
+	}
+	if err := g.r.snapshot(shards); err != nil {
+		return nil, grpcError(err)
+	}
+	return &adminpb.Ack{}, nil
+}
+
+func (g *grpcAdmin) Status(ctx context.Context, _ *adminpb.StatusRequest) (*adminpb.StatusResponse, error) {
+	if _, err := g.login(ctx, "INFO"); err != nil {
+		return nil, err
+	}
+	shards, err := g.r.shardStatus()
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	res := &adminpb.StatusResponse{Id: string(g.r.id)}
+	for _, sh := range shards {
+		s := &adminpb.ShardStatus{
+			Index:             int32(sh.Index),
+			Slots:             sh.Slots,
+			State:             sh.State,
+			Term:              sh.Term,
+			LeaderId:          sh.LeaderID,
+			LastLogIndex:      sh.LastLogIndex,
+			CommitIndex:       sh.CommitIndex,
+			AppliedIndex:      sh.AppliedIndex,
+			LastSnapshotIndex: sh.SnapshotIndex,
+		}
+		for _, srv := range sh.Servers {
+			s.Servers = append(s.Servers, &adminpb.Server{
+				Id:           srv.ID,
+				RaftAddress:  srv.RaftAddress,
+				RedisAddress: srv.RedisAddress,
+				Suffrage:     srv.Suffrage,
+			})
+		}
+		res.Shards = append(res.Shards, s)
+	}
+	return res, nil
+}
+
+func (g *grpcAdmin) GetConfig(ctx context.Context, req *adminpb.GetConfigRequest) (*adminpb.GetConfigResponse, error) {
+	if _, err := g.login(ctx, "CONFIG", "GET"); err != nil {
+		return nil, err
+	}
+	patterns := [][]byte{[]byte("*")}
+	if len(req.GetPatterns()) > 0 {
+		patterns = patterns[:0]
+		for _, p := range req.GetPatterns() {
+			patterns = append(patterns, []byte(p))
+		}
+	}
+	res := &adminpb.GetConfigResponse{}
+	out := g.r.configMatching(patterns)
+	for i := 0; i < len(out); i += 2 {
+		res.Params = append(res.Params, &adminpb.ConfigParam{Name: out[i], Value: out[i+1]})
+	}
+	return res, nil
+}
+
+func (g *grpcAdmin) SetConfig(ctx context.Context, req *adminpb.SetConfigRequest) (*adminpb.Ack, error) {
+	if _, err := g.login(ctx, "CONFIG", "SET"); err != nil {
+		return nil, err
+	}
+	if err := g.r.config.Set(req.GetName(), req.GetValue()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	g.r.log().Info("config set", "param", req.GetName())
+	return &adminpb.Ack{}, nil
+}
@@ -0,0 +1,172 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strconv"
+
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/raft"
+	"raft-redis-cluster/store"
+)
+
+const field = 2
+
+// hset handles HSET and HMSET key field value [field value ...].
+func (r *Redis) hset(conn redcon.Conn, plainCmd string, cmd redcon.Command) {
+	if len(cmd.Args)%2 != 0 {
+		conn.WriteError("ERR wrong number of arguments for '" + plainCmd + "' command")
+		return
+	}
+
+	kvCmd := &raft.KVCmd{
+		Op:  raft.HSet,
+		Key: cmd.Args[keyName],
+	}
+	for i := field; i < len(cmd.Args); i += 2 {
+		kvCmd.Pairs = append(kvCmd.Pairs, raft.KVPair{Key: cmd.Args[i], Val: cmd.Args[i+1]})
+	}
+	res, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	if plainCmd == "HMSET" {
+		conn.WriteString("OK")
+		return
+	}
+	n, _ := res.(int)
+	conn.WriteInt(n)
+}
+
+// hget handles HGET key field.
+func (r *Redis) hget(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	val, err := r.store.HGet(ctx, cmd.Args[keyName], cmd.Args[field])
+	if err != nil {
+		if errors.Is(err, store.ErrKeyNotFound) {
+			conn.WriteNull()
+		} else {
+			conn.WriteError(err.Error())
+		}
+		return
+	}
+	r.writeBulk(conn, val)
+}
+
+// hmget handles HMGET key field [field ...].
+func (r *Redis) hmget(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	m, err := r.store.HGetAll(ctx, cmd.Args[keyName])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	fields := cmd.Args[field:]
+	conn.WriteArray(len(fields))
+	for _, f := range fields {
+		val, ok := m[string(f)]
+		if !ok {
+			conn.WriteNull()
+			continue
+		}
+		conn.WriteBulk(val)
+	}
+}
+
+// hgetall handles HGETALL, HKEYS and HVALS.
+func (r *Redis) hgetall(ctx context.Context, conn redcon.Conn, plainCmd string, cmd redcon.Command) {
+	m, err := r.store.HGetAll(ctx, cmd.Args[keyName])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	// Fields are sorted so that scripts running on every replica see the
+	// same order.
+	fields := slices.Sorted(maps.Keys(m))
+
+	switch plainCmd {
+	case "HKEYS":
+		conn.WriteArray(len(fields))
+		for _, f := range fields {
+			conn.WriteBulkString(f)
+		}
+	case "HVALS":
+		conn.WriteArray(len(fields))
+		for _, f := range fields {
+			conn.WriteBulk(m[f])
+		}
+	default:
+		writeMap(conn, len(fields))
+		for _, f := range fields {
+			conn.WriteBulkString(f)
+			conn.WriteBulk(m[f])
+		}
+	}
+}
+
+// hdel handles HDEL key field [field ...].
+func (r *Redis) hdel(conn redcon.Conn, cmd redcon.Command) {
+	kvCmd := &raft.KVCmd{
+		Op:   raft.HDel,
+		Key:  cmd.Args[keyName],
+		Args: cmd.Args[field:],
+	}
+	res, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	n, _ := res.(int)
+	conn.WriteInt(n)
+}
+
+// hlen handles HLEN key.
+func (r *Redis) hlen(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	n, err := r.store.HLen(ctx, cmd.Args[keyName])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteInt(n)
+}
+
+// hexists handles HEXISTS key field.
+func (r *Redis) hexists(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	_, err := r.store.HGet(ctx, cmd.Args[keyName], cmd.Args[field])
+	if err != nil {
+		if errors.Is(err, store.ErrKeyNotFound) {
+			conn.WriteInt(0)
+		} else {
+			conn.WriteError(err.Error())
+		}
+		return
+	}
+	conn.WriteInt(1)
+}
+
+// hincrBy handles HINCRBY key field increment.
+func (r *Redis) hincrBy(conn redcon.Conn, cmd redcon.Command) {
+	if _, err := strconv.ParseInt(string(cmd.Args[3]), 10, 64); err != nil {
+		conn.WriteError(errNotInteger.Error())
+		return
+	}
+
+	kvCmd := &raft.KVCmd{
+		Op:    raft.HIncrBy,
+		Key:   cmd.Args[keyName],
+		Field: cmd.Args[field],
+		Val:   cmd.Args[3],
+	}
+	res, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	n, _ := res.(int64)
+	conn.WriteInt64(n)
+}
@@ -0,0 +1,67 @@
+package transport
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/hyperloglog"
+	"raft-redis-cluster/raft"
+	"raft-redis-cluster/store"
+)
+
+// pfadd handles PFADD key [element ...].
+func (r *Redis) pfadd(conn redcon.Conn, cmd redcon.Command) {
+	kvCmd := &raft.KVCmd{
+		Op:   raft.PFAdd,
+		Key:  cmd.Args[keyName],
+		Args: cmd.Args[2:],
+	}
+	res, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	n, _ := res.(int)
+	conn.WriteInt(n)
+}
+
+// pfcount handles PFCOUNT key [key ...]. With several keys it returns the
+// cardinality of their union without modifying them.
+func (r *Redis) pfcount(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	union := hyperloglog.New()
+	for _, k := range cmd.Args[keyName:] {
+		v, err := r.store.Get(ctx, k)
+		if errors.Is(err, store.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+
+		sk, err := hyperloglog.Decode(v)
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		union.Merge(sk)
+	}
+	conn.WriteInt64(int64(union.Count()))
+}
+
+// pfmerge handles PFMERGE destkey [sourcekey ...].
+func (r *Redis) pfmerge(conn redcon.Conn, cmd redcon.Command) {
+	kvCmd := &raft.KVCmd{
+		Op:   raft.PFMerge,
+		Key:  cmd.Args[keyName],
+		Args: cmd.Args[2:],
+	}
+	_, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteString("OK")
+}
@@ -0,0 +1,214 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	hraft "github.com/hashicorp/raft"
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/store"
+)
+
+// infoSection is a section of the INFO reply.
+type infoSection struct {
+	name  string
+	write func(b *strings.Builder)
+}
+
+func (r *Redis) infoSections() []infoSection {
+	return []infoSection{
+		{name: "server", write: r.infoServer},
+		{name: "clients", write: r.infoClients},
+		{name: "memory", write: r.infoMemory},
+		{name: "persistence", write: r.infoPersistence},
+		{name: "replication", write: r.infoReplication},
+		{name: "raft", write: r.infoRaft},
+		{name: "tenants", write: r.infoTenants},
+	}
+}
+
+// info handles INFO [section [section ...]].
+func (r *Redis) info(conn redcon.Conn, cmd redcon.Command) {
+	want := map[string]bool{}
+	for _, a := range cmd.Args[1:] {
+		want[strings.ToLower(string(a))] = true
+	}
+	all := want["all"] || want["everything"]
+	def := len(want) == 0 || want["default"]
+
+	b := &strings.Builder{}
+	for _, s := range r.infoSections() {
+		if !all && !def && !want[s.name] {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(b, "# %s\r\n", strings.ToUpper(s.name[:1])+s.name[1:])
+		s.write(b)
+	}
+	conn.WriteBulkString(b.String())
+}
+
+func infoField(b *strings.Builder, key string, val any) {
+	fmt.Fprintf(b, "%s:%v\r\n", key, val)
+}
+
+func (r *Redis) infoServer(b *strings.Builder) {
+	uptime := time.Since(r.started)
+	var port string
+	if addr := r.Addr(); addr != nil {
+		_, port, _ = net.SplitHostPort(addr.String())
+	}
+
+	infoField(b, "redis_version", serverVersion)
+	infoField(b, "redis_mode", "standalone")
+	infoField(b, "os", runtime.GOOS+" "+runtime.GOARCH)
+	infoField(b, "go_version", runtime.Version())
+	infoField(b, "process_id", os.Getpid())
+	infoField(b, "run_id", r.id)
+	infoField(b, "tcp_port", port)
+	if addr := r.advertiseAddr(); addr != "" {
+		infoField(b, "advertise_address", addr)
+	}
+	infoField(b, "uptime_in_seconds", int64(uptime.Seconds()))
+	infoField(b, "uptime_in_days", int64(uptime.Hours()/24))
+}
+
+func (r *Redis) infoClients(b *strings.Builder) {
+	r.clientsMu.RLock()
+	n := len(r.clients)
+	r.clientsMu.RUnlock()
+
+	infoField(b, "connected_clients", n)
+	infoField(b, "maxclients", r.maxClients.Load())
+	infoField(b, "rejected_connections", r.rejectedConns.Load())
+	infoField(b, "throttled_commands", r.throttledCmds.Load())
+	infoField(b, "command_panics", r.cmdPanics.Load())
+}
+
+func (r *Redis) infoMemory(b *strings.Builder) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	infoField(b, "used_memory", m.HeapAlloc)
+	infoField(b, "used_memory_human", humanBytes(m.HeapAlloc))
+	infoField(b, "used_memory_rss", m.Sys)
+	infoField(b, "used_memory_rss_human", humanBytes(m.Sys))
+	infoField(b, "used_memory_dataset", r.store.UsedMemory())
+	infoField(b, "maxmemory", r.maxmemory.Load())
+	infoField(b, "maxmemory_human", humanBytes(uint64(r.maxmemory.Load())))
+	infoField(b, "maxmemory_policy", store.EvictionPolicy(r.maxmemoryPolicy.Load()))
+	infoField(b, "evicted_keys", r.evictedKeys.Load())
+	infoField(b, "expired_keys", r.expiredKeys.Load())
+	pending, freed := store.LazyfreeStats()
+	infoField(b, "lazyfree_pending_objects", pending)
+	infoField(b, "lazyfreed_objects", freed)
+	infoField(b, "mem_allocator", "go")
+	infoField(b, "gc_cycles", m.NumGC)
+}
+
+// infoReplication reports the Raft leader as the master and the other
+// servers as its replicas.
+func (r *Redis) infoReplication(b *strings.Builder) {
+	if r.raft.State() != hraft.Leader {
+		infoField(b, "role", "slave")
+		_, lid := r.raft.LeaderWithID()
+		status := "down"
+		if lid != "" {
+			status = "up"
+			if addr, err := store.GetAdvertiseAddrByNodeID(r.stableStore, lid); err == nil {
+				host, port, _ := net.SplitHostPort(addr)
+				infoField(b, "master_host", host)
+				infoField(b, "master_port", port)
+			}
+		}
+		infoField(b, "master_link_status", status)
+		return
+	}
+
+	infoField(b, "role", "master")
+	servers := r.servers()
+	n := 0
+	for _, s := range servers {
+		if s.ID == r.id {
+			continue
+		}
+		host, port := "", ""
+		if addr, err := store.GetAdvertiseAddrByNodeID(r.stableStore, s.ID); err == nil {
+			host, port, _ = net.SplitHostPort(addr)
+		}
+		infoField(b, fmt.Sprintf("slave%d", n), fmt.Sprintf("ip=%s,port=%s,state=online", host, port))
+		n++
+	}
+	infoField(b, "connected_slaves", n)
+}
+
+func (r *Redis) infoRaft(b *strings.Builder) {
+	stats := r.raft.Stats()
+	lAddr, lid := r.raft.LeaderWithID()
+
+	infoField(b, "raft_node_id", r.id)
+	infoField(b, "raft_state", stats["state"])
+	infoField(b, "raft_term", stats["term"])
+	infoField(b, "raft_last_log_index", stats["last_log_index"])
+	infoField(b, "raft_last_log_term", stats["last_log_term"])
+	infoField(b, "raft_commit_index", stats["commit_index"])
+	infoField(b, "raft_applied_index", stats["applied_index"])
+	infoField(b, "raft_fsm_pending", stats["fsm_pending"])
+	infoField(b, "raft_last_snapshot_index", stats["last_snapshot_index"])
+	infoField(b, "raft_leader_id", lid)
+	infoField(b, "raft_leader_address", lAddr)
+
+	servers := r.servers()
+	infoField(b, "raft_num_peers", len(servers))
+	for i, s := range servers {
+		infoField(b, fmt.Sprintf("raft_peer%d", i), fmt.Sprintf("id=%s,address=%s,suffrage=%s", s.ID, s.Address, s.Suffrage))
+	}
+
+	infoField(b, "raft_num_shards", len(r.shards))
+	ranges := r.slotRanges()
+	for i, sh := range r.shards {
+		slots := make([]string, len(ranges[i]))
+		for j, rg := range ranges[i] {
+			slots[j] = formatRange(rg)
+		}
+		_, lid := sh.Raft.LeaderWithID()
+		infoField(b, fmt.Sprintf("raft_shard%d", i), fmt.Sprintf("slots=%s,state=%s,leader_id=%s,applied_index=%d,pending_writes=%d,apply_backlog=%d",
+			strings.Join(slots, " "), sh.Raft.State(), lid, sh.Raft.AppliedIndex(), sh.pendingWrites.Load(), applyBacklog(sh)))
+	}
+	infoField(b, "raft_max_pending_writes", r.maxPendingWrites.Load())
+	infoField(b, "raft_max_apply_backlog", r.maxApplyBacklog.Load())
+	infoField(b, "raft_rejected_writes", r.rejectedWrites.Load())
+	infoField(b, "raft_migrating_slots", len(r.fsm.Slots().MigratingSlots()))
+}
+
+// servers returns the servers of the latest Raft configuration.
+func (r *Redis) servers() []hraft.Server {
+	f := r.raft.GetConfiguration()
+	if err := f.Error(); err != nil {
+		return nil
+	}
+	return f.Configuration().Servers
+}
+
+// humanBytes formats n the way Redis does in its *_human fields.
+func humanBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	f := float64(n)
+	for _, suffix := range []string{"K", "M", "G", "T"} {
+		f /= unit
+		if f < unit || suffix == "T" {
+			return fmt.Sprintf("%.2f%s", f, suffix)
+		}
+	}
+	return ""
+}
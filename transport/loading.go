@@ -0,0 +1,86 @@
+package transport
+
+import (
+	"errors"
+
+	hraft "github.com/hashicorp/raft"
+)
+
+var errLoading = errors.New("LOADING Redis is loading the dataset in memory")
+
+// loading reports whether this node is loading the keys of sh: restoring a
+// snapshot, or applying the entries its log held when it started. Once it
+// applied them, only restoring a snapshot sent by the leader makes it load
+// again.
+func (sh *Shard) loading() bool {
+	if sh.FSM.Restoring() {
+		return true
+	}
+	if sh.loaded.Load() {
+		return false
+	}
+	if sh.Raft.AppliedIndex() < sh.startIndex {
+		return true
+	}
+	sh.loaded.Store(true)
+	return false
+}
+
+// available returns the error the commands on the keys of sh get while this
+// node can't serve them rather than serve keys that may be missing or
+// stale: LOADING while it loads them, and CLUSTERDOWN while the shard has no
+// leader, having lost its quorum.
+func (r *Redis) available(sh *Shard) error {
+	if sh.loading() {
+		return errLoading
+	}
+	if _, lid := sh.Raft.LeaderWithID(); lid == "" {
+		return errClusterDown
+	}
+	return nil
+}
+
+// anyLoading reports whether this node loads the keys of any shard.
+func (r *Redis) anyLoading() bool {
+	for _, sh := range r.shards {
+		if sh.loading() {
+			return true
+		}
+	}
+	return false
+}
+
+// ready reports whether this node caught up with the leader of sh since it
+// started, and so serves its reads: it leads the shard, or it heard from the
+// leader and applied all but ready-max-lag of the entries it knows are
+// committed. Once caught up, the node stays ready, although restoring a
+// snapshot makes it load again. Without ready-gating, the node is ready as
+// soon as it loaded its log.
+func (r *Redis) ready(sh *Shard) bool {
+	if sh.ready.Load() || !r.readyGating.Load() {
+		return true
+	}
+	if sh.loading() {
+		return false
+	}
+	if sh.Raft.State() != hraft.Leader {
+		if sh.Raft.LastContact().IsZero() {
+			return false
+		}
+		if commit, applied := sh.Raft.CommitIndex(), sh.Raft.AppliedIndex(); commit > applied && commit-applied > uint64(r.readyMaxLag.Load()) {
+			return false
+		}
+	}
+	sh.ready.Store(true)
+	return true
+}
+
+// allReady reports whether this node is ready for every shard.
+func (r *Redis) allReady() bool {
+	for _, sh := range r.shards {
+		if !r.ready(sh) || sh.loading() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/acl"
+)
+
+var errDoCmd = errors.New("ERR command not allowed with Do")
+
+// doDenied are the commands Do refuses: the ones that keep state on the
+// connection for the next commands, which a call of Do doesn't have, and
+// the ones that take the connection over.
+var doDenied = map[string]bool{
+	"SUBSCRIBE":    true,
+	"PSUBSCRIBE":   true,
+	"UNSUBSCRIBE":  true,
+	"PUNSUBSCRIBE": true,
+	"MULTI":        true,
+	"EXEC":         true,
+	"DISCARD":      true,
+	"WATCH":        true,
+	"UNWATCH":      true,
+	"QUIT":         true,
+	"SELECT":       true,
+	"HELLO":        true,
+	"AUTH":         true,
+	"CLIENT":       true,
+	"ASKING":       true,
+	"READONLY":     true,
+	"READWRITE":    true,
+}
+
+// localConn is the connection the commands of Do are served on. Its replies
+// are collected like those of a transaction, but its writes go through the
+// Raft log.
+type localConn struct {
+	txConn
+	ctx interface{}
+	// closed is set when a command panicked, and the connection is dropped
+	// rather than reused.
+	closed bool
+}
+
+func (c *localConn) Context() interface{}     { return c.ctx }
+func (c *localConn) SetContext(v interface{}) { c.ctx = v }
+func (c *localConn) RemoteAddr() string       { return "local" }
+func (c *localConn) Close() error             { c.closed = true; return nil }
+
+// localConns are the idle connections of Do, kept with their connection to
+// the leader for the next calls.
+type localConns struct {
+	mu   sync.Mutex
+	idle []*localConn
+}
+
+func (r *Redis) getLocalConn() *localConn {
+	r.local.mu.Lock()
+	defer r.local.mu.Unlock()
+	if n := len(r.local.idle); n > 0 {
+		c := r.local.idle[n-1]
+		r.local.idle = r.local.idle[:n-1]
+		return c
+	}
+
+	c := &localConn{}
+	st := &connState{id: r.connID.Add(1), user: acl.DefaultUser}
+	if pass := r.requirepass.Load().(string); pass != "" {
+		st.login(acl.DefaultUser, pass)
+	}
+	st.authenticated.Store(true)
+	c.ctx = st
+	return c
+}
+
+func (r *Redis) putLocalConn(c *localConn) {
+	if c.closed {
+		return
+	}
+	c.buf = nil
+	stateOf(c).ctx = nil
+	r.local.mu.Lock()
+	defer r.local.mu.Unlock()
+	r.local.idle = append(r.local.idle, c)
+}
+
+// Do runs the command line args the way the node runs the commands of its
+// clients, and returns the RESP2 reply. It lets an application embedding
+// the node run commands without a connection. The commands run as the
+// default user, and the ones this node can't serve are forwarded to the
+// leader, whatever forward-to-leader is. The commands that need a
+// connection of their own, such as MULTI or SUBSCRIBE, are refused. The
+// command runs with ctx as the context of its connection, and gives up
+// waiting for its entries to be applied once it is done.
+func (r *Redis) Do(ctx context.Context, args ...[]byte) []byte {
+	cmd := redcon.Command{Args: args}
+	switch {
+	case len(args) == 0:
+		return redcon.AppendError(nil, "ERR no command provided")
+	case doDenied[commandOf(cmd)]:
+		return redcon.AppendError(nil, errDoCmd.Error())
+	case ctx.Err() != nil:
+		return redcon.AppendError(nil, "ERR "+ctx.Err().Error())
+	}
+
+	c := r.getLocalConn()
+	defer r.putLocalConn(c)
+	st := stateOf(c)
+	if !r.begin(st) {
+		return redcon.AppendError(nil, errShutdown.Error())
+	}
+	defer r.end(st, c)
+	st.ctx = ctx
+	st.traceParent = traceParentOf(ctx)
+	func() {
+		defer r.recoverCmd(c, cmd)
+		r.serve(c, cmd)
+	}()
+	return c.buf
+}
@@ -0,0 +1,86 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/raft"
+	"raft-redis-cluster/store"
+)
+
+// lock handles LOCK key owner milliseconds, which takes the lock named key
+// for owner, a value unique to the client such as a random token, for the
+// given time. The reply is the fencing token of the lock, an integer that
+// grows each time a lock is taken, or null when another owner holds it.
+// The lock is a string key replicated through the log, so it survives the
+// failover of the leader and expires at the same time on every replica.
+//
+// The token is the index of the entry in the log of the shard holding the
+// key, so it only grows while the slot of the key stays on that shard. A
+// slot moved to another shard, whose log may be shorter, gets tokens lower
+// than those given before the move: a guarded resource must not compare
+// the tokens of locks taken before and after a slot migration. While the
+// slot migrates, LOCK is refused with TRYAGAIN, so that the two shards
+// never hand out the same lock at once, as are the transactions and the
+// scripts with keys in the slot.
+func (r *Redis) lock(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args[2]) == 0 {
+		conn.WriteError("ERR the owner of a lock can't be empty")
+		return
+	}
+	if _, ok := conn.(*txConn); !ok {
+		slot := keySlot(cmd.Args[keyName])
+		if _, ok := r.fsm.Slots().Migrating(slot); ok {
+			conn.WriteError(fmt.Sprintf("TRYAGAIN Hash slot %d is migrating, no lock can be taken in it until it ends", slot))
+			return
+		}
+	}
+	n, err := strconv.ParseInt(string(cmd.Args[3]), 10, 64)
+	if err != nil {
+		conn.WriteError(errNotInteger.Error())
+		return
+	}
+	at, ok := expireAt(store.Now(ctx), "PX", n)
+	if n <= 0 || !ok {
+		conn.WriteError("ERR invalid expire time in 'lock' command")
+		return
+	}
+
+	kvCmd := &raft.KVCmd{
+		Op:       raft.Lock,
+		Key:      cmd.Args[keyName],
+		Val:      cmd.Args[2],
+		ExpireAt: at,
+	}
+	res, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	token, _ := res.(int64)
+	if token == 0 {
+		conn.WriteNull()
+		return
+	}
+	conn.WriteInt64(token)
+}
+
+// unlock handles UNLOCK key owner, which releases the lock named key if
+// owner holds it. The reply is 1 if it did, 0 otherwise.
+func (r *Redis) unlock(conn redcon.Conn, cmd redcon.Command) {
+	kvCmd := &raft.KVCmd{
+		Op:  raft.Unlock,
+		Key: cmd.Args[keyName],
+		Val: cmd.Args[2],
+	}
+	res, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	n, _ := res.(int)
+	conn.WriteInt(n)
+}
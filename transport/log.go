@@ -0,0 +1,93 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/tidwall/redcon"
+)
+
+// logLevels are the values of the loglevel parameter, as in Redis, and the
+// levels of slog they stand for.
+var logLevels = []struct {
+	name  string
+	level slog.Level
+}{
+	{"debug", slog.LevelDebug},
+	{"verbose", slog.LevelDebug + 2},
+	{"notice", slog.LevelInfo},
+	{"warning", slog.LevelWarn},
+	{"nothing", slog.LevelError + 4},
+}
+
+// parseLogLevel returns the slog level of a value of loglevel.
+func parseLogLevel(v string) (slog.Level, error) {
+	for _, l := range logLevels {
+		if strings.EqualFold(v, l.name) {
+			return l.level, nil
+		}
+	}
+	return 0, errors.New("argument(s) must be one of the following: debug, verbose, notice, warning, nothing")
+}
+
+// formatLogLevel returns the value of loglevel for level.
+func formatLogLevel(level slog.Level) string {
+	name := logLevels[0].name
+	for _, l := range logLevels {
+		if level >= l.level {
+			name = l.name
+		}
+	}
+	return name
+}
+
+// newLogger returns the logger used until one is set with SetLogger, which
+// writes text to stderr at the level of loglevel.
+func (r *Redis) newLogger() *slog.Logger {
+	h := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: &r.logLevel})
+	return slog.New(h).With("server_id", string(r.id))
+}
+
+// SetLogger replaces the logger of the node. The level of l is its own,
+// unless its handler uses LogLevel.
+func (r *Redis) SetLogger(l *slog.Logger) {
+	r.logger.Store(l.With("server_id", string(r.id)))
+}
+
+// LogLevel returns the level set with the loglevel parameter, for the
+// handlers of the loggers given to SetLogger.
+func (r *Redis) LogLevel() *slog.LevelVar {
+	return &r.logLevel
+}
+
+// log returns the logger of the node.
+func (r *Redis) log() *slog.Logger {
+	return r.logger.Load()
+}
+
+// connLog returns the logger of the node with the fields of the client of
+// st.
+func (r *Redis) connLog(st *connState) *slog.Logger {
+	l := r.log().With("client_id", st.id)
+	if st.conn != nil {
+		l = l.With("client", st.conn.RemoteAddr())
+	}
+	return l
+}
+
+// logCmd logs the command a client sent, at the debug level.
+func (r *Redis) logCmd(st *connState, cmd redcon.Command) {
+	l := r.log()
+	if !l.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	r.connLog(st).Debug("command", "cmd", commandOf(cmd), "args", len(cmd.Args)-1)
+}
+
+// logShard returns the logger of the node with the fields of sh.
+func (r *Redis) logShard(sh *Shard) *slog.Logger {
+	return r.log().With("shard", sh.index, "raft_term", sh.Raft.CurrentTerm())
+}
@@ -0,0 +1,383 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	hraft "github.com/hashicorp/raft"
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/cluster"
+	"raft-redis-cluster/raft"
+)
+
+// promoteTimeout is how long RAFT.PROMOTE waits for a non-voter to catch up
+// with the log.
+const promoteTimeout = 10 * time.Second
+
+// newServer is a server being added to the shards of the cluster.
+type newServer struct {
+	id hraft.ServerID
+	// raftAddr is the Raft address of the first shard of the server; shard i
+	// listens on its port plus i.
+	raftAddr  string
+	redisAddr string
+	// advertiseAddr is the address clients are sent to instead of
+	// redisAddr, if any.
+	advertiseAddr string
+	nonvoter      bool
+}
+
+// parseMemberShard parses the SHARD i option ending the arguments of the
+// membership commands, and returns the shards to change with the remaining
+// arguments. Without the option, every shard is changed.
+func (r *Redis) parseMemberShard(args [][]byte) ([]int, [][]byte, error) {
+	n := len(args)
+	if n >= 2 && strings.EqualFold(string(args[n-2]), "SHARD") {
+		i, err := r.parseShard(args[n-1])
+		if err != nil {
+			return nil, nil, err
+		}
+		return []int{i}, args[:n-2], nil
+	}
+	return r.allShards(), args, nil
+}
+
+// allShards returns the indexes of every shard.
+func (r *Redis) allShards() []int {
+	shards := make([]int, len(r.shards))
+	for i := range shards {
+		shards[i] = i
+	}
+	return shards
+}
+
+// raftAdd handles RAFT.ADD server-id raft-addr redis-addr [ADVERTISE addr]
+// [NONVOTER] [SHARD i], which adds the server to the shard, or to every
+// shard, as a voter or a non-voter. With ADVERTISE, clients are redirected
+// to addr instead of redis-addr, which the nodes still use between
+// themselves. The shards this node doesn't lead are asked to their leader.
+func (r *Redis) raftAdd(conn redcon.Conn, cmd redcon.Command) {
+	shards, args, err := r.parseMemberShard(cmd.Args)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	m := newServer{id: hraft.ServerID(cmd.Args[1]), raftAddr: string(cmd.Args[2]), redisAddr: string(cmd.Args[3])}
+	for i := 4; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(string(args[i]), "NONVOTER") && !m.nonvoter:
+			m.nonvoter = true
+		case strings.EqualFold(string(args[i]), "ADVERTISE") && i+1 < len(args) && m.advertiseAddr == "":
+			i++
+			m.advertiseAddr = string(args[i])
+		default:
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+	}
+	if _, err := cluster.ShardAddr(m.raftAddr, 0); err != nil {
+		conn.WriteError("ERR Invalid Raft address " + m.raftAddr)
+		return
+	}
+	if _, _, err := net.SplitHostPort(m.redisAddr); err != nil {
+		conn.WriteError("ERR Invalid Redis address " + m.redisAddr)
+		return
+	}
+	if _, _, err := net.SplitHostPort(m.advertiseAddr); m.advertiseAddr != "" && err != nil {
+		conn.WriteError("ERR Invalid Redis address " + m.advertiseAddr)
+		return
+	}
+
+	if err := r.addMember(stateOf(conn), m, shards); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteString("OK")
+}
+
+// addMember adds m to shards, in order.
+func (r *Redis) addMember(st *connState, m newServer, shards []int) error {
+	for _, i := range shards {
+		sh := r.shards[i]
+		if sh.Raft.State() != hraft.Leader {
+			args := [][]byte{[]byte("RAFT.ADD"), []byte(m.id), []byte(m.raftAddr), []byte(m.redisAddr)}
+			if m.advertiseAddr != "" {
+				args = append(args, []byte("ADVERTISE"), []byte(m.advertiseAddr))
+			}
+			if m.nonvoter {
+				args = append(args, []byte("NONVOTER"))
+			}
+			args = append(args, []byte("SHARD"), []byte(strconv.Itoa(i)))
+			if err := r.askLeader(st, sh, args...); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if i == 0 {
+			// The first shard replicates the Redis addresses, so that
+			// every node can redirect clients to the new server.
+			kvCmd := &raft.KVCmd{Op: raft.SetNode, Key: []byte(m.id), Val: []byte(m.redisAddr), Field: []byte(m.advertiseAddr)}
+			if _, err := r.applyTo(sh, kvCmd); err != nil {
+				return err
+			}
+		}
+		addr, err := cluster.ShardAddr(m.raftAddr, i)
+		if err != nil {
+			return err
+		}
+		var f hraft.IndexFuture
+		if m.nonvoter {
+			f = sh.Raft.AddNonvoter(m.id, hraft.ServerAddress(addr), 0, 0)
+		} else {
+			f = sh.Raft.AddVoter(m.id, hraft.ServerAddress(addr), 0, 0)
+		}
+		if err := f.Error(); err != nil {
+			return errors.New("ERR " + err.Error())
+		}
+		r.logShard(sh).Info("server added", "id", m.id, "address", addr, "nonvoter", m.nonvoter)
+	}
+	return nil
+}
+
+// raftRemove handles RAFT.REMOVE server-id [SHARD i], which removes the
+// server from the shard, or from every shard.
+func (r *Redis) raftRemove(conn redcon.Conn, cmd redcon.Command) {
+	shards, args, err := r.parseMemberShard(cmd.Args)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	if len(args) != 2 {
+		conn.WriteError(errSyntax.Error())
+		return
+	}
+	if err := r.removeMember(stateOf(conn), hraft.ServerID(args[1]), shards); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteString("OK")
+}
+
+// removeMember removes the server id from shards.
+func (r *Redis) removeMember(st *connState, id hraft.ServerID, shards []int) error {
+	// The first shard goes last, as it keeps the address of the server
+	// other nodes redirect to while it still serves the others.
+	for j := len(shards) - 1; j >= 0; j-- {
+		i := shards[j]
+		sh := r.shards[i]
+		if sh.Raft.State() != hraft.Leader {
+			if err := r.askLeader(st, sh, []byte("RAFT.REMOVE"), []byte(id), []byte("SHARD"), []byte(strconv.Itoa(i))); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, err := serverOf(sh, id); err != nil {
+			return err
+		}
+		if i == 0 {
+			// Forgotten before the removal, which makes a leader removing
+			// itself step down.
+			if _, err := r.applyTo(sh, &raft.KVCmd{Op: raft.DelNode, Key: []byte(id)}); err != nil {
+				return err
+			}
+		}
+		if err := sh.Raft.RemoveServer(id, 0, 0).Error(); err != nil {
+			return errors.New("ERR " + err.Error())
+		}
+		r.logShard(sh).Info("server removed", "id", id)
+	}
+	return nil
+}
+
+// raftPromote handles RAFT.PROMOTE server-id [SHARD i], which makes the
+// non-voter a voter of the shard, or of every shard, once it has applied the
+// log as far as the leader has written it.
+func (r *Redis) raftPromote(conn redcon.Conn, cmd redcon.Command) {
+	shards, args, err := r.parseMemberShard(cmd.Args)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	if len(args) != 2 {
+		conn.WriteError(errSyntax.Error())
+		return
+	}
+	id := hraft.ServerID(args[1])
+
+	st := stateOf(conn)
+	for _, i := range shards {
+		sh := r.shards[i]
+		if sh.Raft.State() != hraft.Leader {
+			if err := r.askLeader(st, sh, []byte("RAFT.PROMOTE"), []byte(id), []byte("SHARD"), []byte(strconv.Itoa(i))); err != nil {
+				conn.WriteError(err.Error())
+				return
+			}
+			continue
+		}
+
+		s, err := serverOf(sh, id)
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		if s.Suffrage == hraft.Voter {
+			continue
+		}
+		if err := r.waitCaughtUp(st, sh, id); err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		if err := sh.Raft.AddVoter(s.ID, s.Address, 0, 0).Error(); err != nil {
+			conn.WriteError("ERR " + err.Error())
+			return
+		}
+		r.logShard(sh).Info("server promoted", "id", id)
+	}
+	conn.WriteString("OK")
+}
+
+// waitCaughtUp waits for the server id to apply the log of sh up to its
+// last entry, for up to promoteTimeout.
+func (r *Redis) waitCaughtUp(st *connState, sh *Shard, id hraft.ServerID) error {
+	index := sh.Raft.LastIndex()
+	timeout := time.After(promoteTimeout)
+	conns := map[hraft.ServerID]*forwarder{}
+	defer func() {
+		for _, c := range conns {
+			c.conn.Close()
+		}
+	}()
+	for {
+		if applied, err := r.peerApplied(st, conns, id, sh); err == nil && applied >= index {
+			return nil
+		}
+		select {
+		case <-timeout:
+			return errors.New("ERR Server " + string(id) + " has not caught up with the log of shard " + strconv.Itoa(sh.index))
+		case <-time.After(waitPollInterval):
+		}
+	}
+}
+
+// raftDemote handles RAFT.DEMOTE server-id [SHARD i], which makes the voter
+// a non-voter of the shard, or of every shard, so that it can be replaced
+// without changing the quorum while it keeps receiving the log.
+func (r *Redis) raftDemote(conn redcon.Conn, cmd redcon.Command) {
+	shards, args, err := r.parseMemberShard(cmd.Args)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	if len(args) != 2 {
+		conn.WriteError(errSyntax.Error())
+		return
+	}
+	id := hraft.ServerID(args[1])
+
+	st := stateOf(conn)
+	for _, i := range shards {
+		sh := r.shards[i]
+		if sh.Raft.State() != hraft.Leader {
+			if err := r.askLeader(st, sh, []byte("RAFT.DEMOTE"), []byte(id), []byte("SHARD"), []byte(strconv.Itoa(i))); err != nil {
+				conn.WriteError(err.Error())
+				return
+			}
+			continue
+		}
+
+		s, err := serverOf(sh, id)
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		if s.Suffrage == hraft.Nonvoter {
+			continue
+		}
+		if err := sh.Raft.DemoteVoter(id, 0, 0).Error(); err != nil {
+			conn.WriteError("ERR " + err.Error())
+			return
+		}
+		r.logShard(sh).Info("server demoted", "id", id)
+	}
+	conn.WriteString("OK")
+}
+
+// meet handles CLUSTER MEET ip port [cluster-bus-port], which adds the node
+// serving Redis on ip:port to every shard as a voter. Its Raft address is
+// ip:cluster-bus-port, the bus port defaulting to port plus 10000 as in
+// Redis Cluster. The server ID is asked to the node.
+func (r *Redis) meet(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) > 5 {
+		conn.WriteError(errSyntax.Error())
+		return
+	}
+	port, err := strconv.Atoi(string(cmd.Args[3]))
+	if err != nil || port < 0 || port > 65535 {
+		conn.WriteError("ERR Invalid node address specified: " + string(cmd.Args[2]) + ":" + string(cmd.Args[3]))
+		return
+	}
+	bus := port + 10000
+	if len(cmd.Args) == 5 {
+		bus, err = strconv.Atoi(string(cmd.Args[4]))
+		if err != nil || bus < 0 || bus > 65535 {
+			conn.WriteError("ERR Invalid cluster bus port specified: " + string(cmd.Args[4]))
+			return
+		}
+	}
+	host := string(cmd.Args[2])
+	m := newServer{
+		raftAddr:  net.JoinHostPort(host, strconv.Itoa(bus)),
+		redisAddr: net.JoinHostPort(host, strconv.Itoa(port)),
+	}
+
+	st := stateOf(conn)
+	id, advertised, err := r.askServerID(st, m.redisAddr)
+	if err != nil {
+		conn.WriteError("ERR Failed to meet " + m.redisAddr + ": " + err.Error())
+		return
+	}
+	m.id, m.advertiseAddr = id, advertised
+	if err := r.addMember(st, m, r.allShards()); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteString("OK")
+}
+
+// askServerID asks the node at addr its server ID and the address it
+// advertises to clients, if any, from INFO server raft.
+func (r *Redis) askServerID(st *connState, addr string) (hraft.ServerID, string, error) {
+	c, err := r.dialNode(st, addr, false)
+	if err != nil {
+		return "", "", err
+	}
+	defer c.conn.Close()
+
+	reply, err := c.roundTrip([][][]byte{{[]byte("INFO"), []byte("server"), []byte("raft")}})
+	if err != nil {
+		return "", "", err
+	}
+	if reply[0] == '-' {
+		return "", "", errors.New(string(reply[1 : len(reply)-2]))
+	}
+	var id, advertised string
+	for line := range bytes.Lines(reply) {
+		if v, ok := bytes.CutPrefix(line, []byte("raft_node_id:")); ok {
+			id = string(bytes.TrimSpace(v))
+		}
+		if v, ok := bytes.CutPrefix(line, []byte("advertise_address:")); ok {
+			advertised = string(bytes.TrimSpace(v))
+		}
+	}
+	if id == "" {
+		return "", "", errors.New("no server ID in INFO raft")
+	}
+	return hraft.ServerID(id), advertised, nil
+}
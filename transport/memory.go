@@ -0,0 +1,108 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/store"
+)
+
+// memory handles MEMORY USAGE key [SAMPLES count] and MEMORY STATS. The
+// sizes are the estimates the store keeps for maxmemory, read from the
+// replica of this node.
+func (r *Redis) memory(conn redcon.Conn, cmd redcon.Command) {
+	sub := strings.ToUpper(string(cmd.Args[1]))
+	switch {
+	case sub == "USAGE" && (len(cmd.Args) == 3 || len(cmd.Args) == 5):
+		r.memoryUsage(conn, cmd)
+
+	case sub == "STATS" && len(cmd.Args) == 2:
+		r.memoryStats(conn)
+
+	case sub == "USAGE" || sub == "STATS":
+		conn.WriteError("ERR wrong number of arguments for 'memory|" + strings.ToLower(sub) + "' command")
+
+	default:
+		conn.WriteError("ERR unknown subcommand '" + string(cmd.Args[1]) + "'. Try MEMORY HELP.")
+	}
+}
+
+// memoryUsage replies with the bytes used by a key and its value. The size
+// of every element is tracked, so SAMPLES is checked but not needed.
+func (r *Redis) memoryUsage(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) == 5 {
+		if !strings.EqualFold(string(cmd.Args[3]), "SAMPLES") {
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+		if n, err := strconv.Atoi(string(cmd.Args[4])); err != nil || n < 0 {
+			conn.WriteError(errNotInteger.Error())
+			return
+		}
+	}
+
+	n, err := r.store.MemoryUsage(context.Background(), cmd.Args[2])
+	switch {
+	case errors.Is(err, store.ErrKeyNotFound):
+		conn.WriteNull()
+	case err != nil:
+		conn.WriteError(err.Error())
+	default:
+		conn.WriteInt64(n)
+	}
+}
+
+// memoryStats replies with the memory used by the process and the keys of
+// every shard on this node.
+func (r *Redis) memoryStats(conn redcon.Conn) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	ctx := context.Background()
+	keys, err := r.store.Len(ctx)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	dataset := r.store.UsedMemory()
+	perKey, percentage := int64(0), 0.0
+	if keys > 0 {
+		perKey = dataset / int64(keys)
+	}
+	if m.HeapAlloc > 0 {
+		percentage = float64(dataset) * 100 / float64(m.HeapAlloc)
+	}
+
+	writeMap(conn, 8+len(r.shards))
+	conn.WriteBulkString("total.allocated")
+	conn.WriteInt64(int64(m.HeapAlloc))
+	conn.WriteBulkString("total.system")
+	conn.WriteInt64(int64(m.Sys))
+	conn.WriteBulkString("maxmemory")
+	conn.WriteInt64(r.maxmemory.Load())
+	conn.WriteBulkString("keys.count")
+	conn.WriteInt(keys)
+	conn.WriteBulkString("keys.bytes-per-key")
+	conn.WriteInt64(perKey)
+	conn.WriteBulkString("dataset.bytes")
+	conn.WriteInt64(dataset)
+	conn.WriteBulkString("dataset.percentage")
+	writeDouble(conn, percentage)
+	conn.WriteBulkString("evicted.keys")
+	conn.WriteInt64(r.evictedKeys.Load())
+
+	for _, sh := range r.shards {
+		n, _ := sh.Store.Len(ctx)
+		conn.WriteBulkString("shard." + strconv.Itoa(sh.index))
+		writeMap(conn, 2)
+		conn.WriteBulkString("keys.count")
+		conn.WriteInt(n)
+		conn.WriteBulkString("dataset.bytes")
+		conn.WriteInt64(sh.Store.UsedMemory())
+	}
+}
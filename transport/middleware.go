@@ -0,0 +1,44 @@
+package transport
+
+import "github.com/tidwall/redcon"
+
+// Middleware wraps the processing of the commands of the clients, for
+// concerns such as logging, metrics or rules refusing some commands, which
+// are kept out of the handlers. It returns a handler that does its work and
+// calls next to go on with the command, or writes a reply to Conn and
+// returns without calling it to answer the command itself.
+//
+// A command reaches the middleware once the client is authenticated and the
+// command passed the checks of its arity and of the ACL, with the keys of
+// the database the client selected. The commands queued by MULTI and those
+// called by scripts run inside EXEC and EVAL, which reach the middleware
+// themselves. A middleware may change the Context or the Args of the Call
+// before calling next; the new arguments are checked for their arity but
+// not against the ACL again.
+type Middleware func(next func(*Call)) func(*Call)
+
+// WithMiddleware adds mw to the middleware of the server. The first added
+// runs first, and sees the command before the others.
+func WithMiddleware(mw ...Middleware) Option {
+	return func(r *Redis) { r.middleware = append(r.middleware, mw...) }
+}
+
+// chain returns the handler of the commands that passed the checks of
+// serve: the middleware, then processCmd.
+func (r *Redis) chain() func(*Call) {
+	h := r.processCall
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		h = r.middleware[i](h)
+	}
+	return h
+}
+
+// processCall runs the command of c, at the end of the middleware chain.
+func (r *Redis) processCall(c *Call) {
+	cmd := redcon.Command{Raw: c.raw, Args: c.Args}
+	if err := checkArity(cmd); err != nil {
+		c.Conn.WriteError(err.Error())
+		return
+	}
+	r.processCmd(c.Context, c.Conn, cmd)
+}
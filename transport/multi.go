@@ -0,0 +1,277 @@
+package transport
+
+import (
+	"context"
+	"errors"
+
+	hraft "github.com/hashicorp/raft"
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/raft"
+)
+
+// txCmds are executed immediately even while a transaction is open.
+var txCmds = map[string]bool{
+	"MULTI":   true,
+	"EXEC":    true,
+	"DISCARD": true,
+	"WATCH":   true,
+	"UNWATCH": true,
+}
+
+// txLocalCmds are the local commands that may be queued in a transaction.
+var txLocalCmds = map[string]bool{
+	"PING": true,
+	"ECHO": true,
+}
+
+var errTxWrite = errors.New("ERR write command in a read-only context")
+
+// txState is the transaction state of a connection.
+type txState struct {
+	// multi is set between MULTI and EXEC or DISCARD.
+	multi  bool
+	queued []redcon.Command
+	// aborted is set when a command failed to queue, making EXEC fail.
+	aborted bool
+	// watched holds the keys watched with WATCH and their versions.
+	watched []raft.WatchedKey
+}
+
+// txConn collects the replies of a command executed as part of a
+// transaction. Writes are routed to applyFn instead of the Raft log.
+type txConn struct {
+	redcon.Conn
+	buf     []byte
+	applyFn func(cmd *raft.KVCmd) (any, error)
+	// shard is the shard whose FSM runs the command, when it is run for a
+	// transaction or a script.
+	shard *Shard
+	// resp3 selects the protocol of the replies when there is no client
+	// connection to take it from.
+	resp3 bool
+	// db is the database selected, likewise.
+	db int
+}
+
+func (c *txConn) apply(cmd *raft.KVCmd) (any, error) {
+	return c.applyFn(cmd)
+}
+
+func (c *txConn) WriteError(msg string)       { c.buf = redcon.AppendError(c.buf, msg) }
+func (c *txConn) WriteString(str string)      { c.buf = redcon.AppendString(c.buf, str) }
+func (c *txConn) WriteBulk(bulk []byte)       { c.buf = redcon.AppendBulk(c.buf, bulk) }
+func (c *txConn) WriteBulkString(bulk string) { c.buf = redcon.AppendBulkString(c.buf, bulk) }
+func (c *txConn) WriteInt(num int)            { c.buf = redcon.AppendInt(c.buf, int64(num)) }
+func (c *txConn) WriteInt64(num int64)        { c.buf = redcon.AppendInt(c.buf, num) }
+func (c *txConn) WriteUint64(num uint64)      { c.buf = redcon.AppendUint(c.buf, num) }
+func (c *txConn) WriteArray(count int)        { c.buf = redcon.AppendArray(c.buf, count) }
+func (c *txConn) WriteNull()                  { c.buf = redcon.AppendNull(c.buf) }
+func (c *txConn) WriteRaw(data []byte)        { c.buf = append(c.buf, data...) }
+func (c *txConn) WriteAny(v interface{})      { c.buf = redcon.AppendAny(c.buf, v) }
+
+// multi handles MULTI.
+func (r *Redis) multi(conn redcon.Conn) {
+	st := stateOf(conn)
+	if st.tx == nil {
+		st.tx = &txState{}
+	}
+	tx := st.tx
+	if tx.multi {
+		conn.WriteError("ERR MULTI calls can not be nested")
+		return
+	}
+	tx.multi = true
+	conn.WriteString("OK")
+}
+
+// discard handles DISCARD. Like EXEC, it also unwatches all keys.
+func (r *Redis) discard(conn redcon.Conn) {
+	st := stateOf(conn)
+	if st.tx == nil || !st.tx.multi {
+		conn.WriteError("ERR DISCARD without MULTI")
+		return
+	}
+	st.tx = nil
+	st.unwatchForwarded()
+	conn.WriteString("OK")
+}
+
+// queue adds cmd to the open transaction. A command that does not validate
+// or cannot run inside a transaction aborts it.
+func (r *Redis) queue(conn redcon.Conn, tx *txState, cmd redcon.Command, err error) {
+	if name := commandOf(cmd); err == nil && isLocalCmd(name) && !txLocalCmds[name] {
+		err = errors.New("ERR Command not allowed inside a transaction")
+	}
+	if err != nil {
+		tx.aborted = true
+		conn.WriteError(err.Error())
+		return
+	}
+
+	// redcon reuses the argument buffers once the handler returns.
+	args := make([][]byte, len(cmd.Args))
+	for i, a := range cmd.Args {
+		args[i] = append([]byte(nil), a...)
+	}
+	tx.queued = append(tx.queued, redcon.Command{Args: args})
+	conn.WriteString("QUEUED")
+}
+
+// watch handles WATCH key [key ...]. The version of each key is taken from
+// the state machine of its shard on the leader and checked again when the EXEC entry is
+// applied.
+func (r *Redis) watch(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	st := stateOf(conn)
+	if st.tx == nil {
+		st.tx = &txState{}
+	}
+	tx := st.tx
+	if tx.multi {
+		conn.WriteError("ERR WATCH inside MULTI is not allowed")
+		return
+	}
+
+	watched := make([]raft.WatchedKey, 0, len(cmd.Args)-keyName)
+	for _, k := range cmd.Args[keyName:] {
+		v, err := r.shardOfKey(k).FSM.KeyVersion(ctx, k)
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		watched = append(watched, raft.WatchedKey{Key: append([]byte(nil), k...), Version: v})
+	}
+	tx.watched = append(tx.watched, watched...)
+	conn.WriteString("OK")
+}
+
+// unwatch handles UNWATCH.
+func (r *Redis) unwatch(conn redcon.Conn) {
+	st := stateOf(conn)
+	if st.tx != nil && !st.tx.multi {
+		st.tx = nil
+	}
+	st.unwatchForwarded()
+	conn.WriteString("OK")
+}
+
+// exec handles EXEC. The queued commands are replicated as a single Multi
+// entry: each write contributes the KVCmd its handler would apply, and each
+// other command becomes a Read answered by the FSM at its position in the
+// transaction. The handlers then run again over the FSM responses to build
+// the replies.
+func (r *Redis) exec(conn redcon.Conn) {
+	st := stateOf(conn)
+	tx := st.tx
+	if tx == nil || !tx.multi {
+		conn.WriteError("ERR EXEC without MULTI")
+		return
+	}
+	st.tx = nil
+
+	if tx.aborted {
+		conn.WriteError("EXECABORT Transaction discarded because of previous errors.")
+		return
+	}
+	// The transaction is applied by a single shard, which must own every
+	// key it touches.
+	var keys [][]byte
+	for _, w := range tx.watched {
+		keys = append(keys, w.Key)
+	}
+	for _, cmd := range tx.queued {
+		keys = append(keys, cmdKeys(commandOf(cmd), cmd.Args)...)
+	}
+	sh, slot, err := r.keysShard(keys)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	// The keys of a migrating slot may be split between two shards.
+	if _, ok := r.fsm.Slots().Migrating(slot); ok && len(keys) > 0 {
+		conn.WriteError(errTryAgain.Error())
+		return
+	}
+	st.shard = sh
+	if sh.Raft.State() != hraft.Leader && r.forwarding(conn) {
+		lines := [][][]byte{{[]byte("MULTI")}}
+		for _, cmd := range tx.queued {
+			lines = append(lines, cmd.Args)
+		}
+		r.forward(conn, sh, append(lines, st.args)...)
+		return
+	}
+	if r.moved(conn, sh, slot) {
+		return
+	}
+
+	ctx := st.spanContext()
+	multi := &raft.KVCmd{Op: raft.Multi, Watch: tx.watched}
+	for _, cmd := range tx.queued {
+		var sub *raft.KVCmd
+		tc := &txConn{Conn: conn, shard: st.shard, applyFn: func(c *raft.KVCmd) (any, error) {
+			sub = c
+			return nil, errTxWrite
+		}}
+		r.dispatch(ctx, tc, commandOf(cmd), cmd)
+
+		if sub == nil {
+			sub = &raft.KVCmd{Op: raft.Read, Args: cmd.Args, RESP3: isRESP3(conn), DB: st.db}
+		}
+		multi.Cmds = append(multi.Cmds, *sub)
+	}
+
+	res, err := r.apply(conn, multi)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	if res == nil {
+		// A watched key changed.
+		conn.WriteNull()
+		return
+	}
+	results, _ := res.([]any)
+	if len(results) != len(tx.queued) {
+		conn.WriteError("ERR unexpected transaction response")
+		return
+	}
+
+	conn.WriteArray(len(tx.queued))
+	for i, cmd := range tx.queued {
+		if multi.Cmds[i].Op == raft.Read {
+			if b, ok := results[i].([]byte); ok {
+				conn.WriteRaw(b)
+			} else {
+				conn.WriteNull()
+			}
+			continue
+		}
+
+		result := results[i]
+		tc := &txConn{Conn: conn, applyFn: func(*raft.KVCmd) (any, error) {
+			if err, ok := result.(error); ok {
+				return nil, err
+			}
+			return result, nil
+		}}
+		r.dispatch(ctx, tc, commandOf(cmd), cmd)
+		conn.WriteRaw(tc.buf)
+	}
+}
+
+// TxRead answers a read-only command of a transaction from the FSM. Only the
+// leader of the shard has a client waiting for the reply, so followers skip
+// the work.
+func (h *shardHandler) TxRead(ctx context.Context, db int, args [][]byte, resp3 bool) any {
+	if h.sh.Raft.State() != hraft.Leader {
+		return nil
+	}
+
+	tc := &txConn{resp3: resp3, db: db, shard: h.sh, applyFn: func(*raft.KVCmd) (any, error) {
+		return nil, errTxWrite
+	}}
+	cmd := redcon.Command{Args: args}
+	h.r.dispatch(withShard(ctx, h.sh.index), tc, commandOf(cmd), cmd)
+	return tc.buf
+}
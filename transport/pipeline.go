@@ -0,0 +1,190 @@
+package transport
+
+import (
+	"slices"
+
+	hraft "github.com/hashicorp/raft"
+	"github.com/tidwall/redcon"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"raft-redis-cluster/raft"
+)
+
+// pipelineCmds are the commands whose KVCmd is batchable.
+var pipelineCmds = map[string]bool{"SET": true, "DEL": true}
+
+// servePipeline serves cmd together with the commands the client pipelined
+// after it, when cmd is a SET or DEL and none of them is a local command that
+// may take over the connection. Consecutive SETs and DELs to a shard this
+// node leads are replicated as one Batch entry; the other commands are
+// served one by one in between. The replies keep the order of the commands.
+// It reports false when it left the commands to redcon, which it always
+// does while rate limits are set, so each command is throttled on its own,
+// with middleware, which sees each command, and in the databases other than
+// 0, whose keys serve rewrites.
+func (r *Redis) servePipeline(conn redcon.Conn, cmd redcon.Command) bool {
+	if !pipelineCmds[commandOf(cmd)] || r.rateLimited() || len(r.middleware) > 0 || stateOf(conn).db != 0 {
+		return false
+	}
+	rest := conn.PeekPipeline()
+	if len(rest) == 0 {
+		return false
+	}
+	for _, c := range rest {
+		if name := commandOf(c); isLocalCmd(name) || txCmds[name] {
+			return false
+		}
+	}
+
+	// The replies are buffered until the last command is served.
+	stateOf(conn).flushed = false
+	cmds := append([]redcon.Command{cmd}, conn.ReadPipeline()...)
+	for i := 0; i < len(cmds); {
+		sh, n := r.batchRun(conn, cmds[i:])
+		if n < 2 {
+			r.serve(conn, cmds[i])
+			i++
+			continue
+		}
+		r.applyPipeline(conn, sh, cmds[i:i+n])
+		i += n
+	}
+	return true
+}
+
+// batchRun returns how many of the commands at the head of cmds can be
+// applied as one Batch, and the shard they go to. They must pass the checks
+// of serve and route to a shard this node leads and serves; any other
+// command is left to serve, which replies with the error.
+func (r *Redis) batchRun(conn redcon.Conn, cmds []redcon.Command) (*Shard, int) {
+	st := stateOf(conn)
+	if !st.authenticated.Load() || st.asking || (st.tx != nil && st.tx.multi) {
+		return nil, 0
+	}
+
+	var sh *Shard
+	n := 0
+	for _, cmd := range cmds {
+		if !pipelineCmds[commandOf(cmd)] || r.validateCmd(cmd) != nil || r.checkACL(st, cmd) != nil {
+			break
+		}
+		// A DEL of several keys is a Batch of its own, and a SET of a value
+		// written in chunks is applied on its own.
+		if commandOf(cmd) == "DEL" && len(cmd.Args) > 2 {
+			break
+		}
+		if commandOf(cmd) == "SET" && r.overChunk(len(cmd.Args[value])) {
+			break
+		}
+		s, slot, err := r.keysShard(cmdKeys(commandOf(cmd), cmd.Args))
+		if err != nil || (sh != nil && s != sh) || s.Raft.State() != hraft.Leader {
+			break
+		}
+		if r.available(s) != nil || (isReadCmd(commandOf(cmd)) && !r.ready(s)) {
+			break
+		}
+		if _, ok := r.fsm.Slots().Migrating(slot); ok {
+			break
+		}
+		sh = s
+		n++
+	}
+	return sh, n
+}
+
+// applyPipeline replicates the writes of cmds to sh as a single Batch entry.
+// As for EXEC, each handler runs once to build the KVCmd it would apply and
+// once more over the FSM response to build its reply.
+func (r *Redis) applyPipeline(conn redcon.Conn, sh *Shard, cmds []redcon.Command) {
+	st := stateOf(conn)
+	ctx, cancel := r.commandContext(st, commandOf(cmds[0]))
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "pipeline", trace.WithAttributes(
+		attribute.Int64("db.client.id", st.id),
+		attribute.Int("db.operation.batch.size", len(cmds)),
+		attribute.Int("raft.shard", sh.index)))
+	defer span.End()
+
+	// replies holds the reply of a command that failed before applying.
+	replies := make([][]byte, len(cmds))
+	batch := &raft.KVCmd{Op: raft.Batch}
+	if st.tracking {
+		batch.Origin = r.origin(st)
+	}
+	pos := make([]int, len(cmds))
+	subs := make([]*raft.KVCmd, len(cmds))
+	for i, cmd := range cmds {
+		st.seen(commandOf(cmd))
+		r.logCmd(st, cmd)
+		st.args = cmd.Args
+		st.shard = sh
+
+		var sub *raft.KVCmd
+		tc := &txConn{Conn: conn, shard: sh, applyFn: func(c *raft.KVCmd) (any, error) {
+			sub = c
+			return nil, errTxWrite
+		}}
+		r.dispatch(ctx, tc, commandOf(cmd), cmd)
+		if sub == nil {
+			replies[i] = tc.buf
+			continue
+		}
+		subs[i] = sub
+	}
+
+	// As in apply, only the writes that may use more memory are refused
+	// when no key can be evicted; the others are still applied.
+	var oom error
+	if slices.ContainsFunc(subs, func(c *raft.KVCmd) bool { return c != nil && denyOOM(c) }) {
+		oom = r.evict()
+	}
+	for i, sub := range subs {
+		switch {
+		case sub == nil:
+		case oom != nil && denyOOM(sub):
+			replies[i] = redcon.AppendError(nil, oom.Error())
+		default:
+			pos[i] = len(batch.Cmds)
+			batch.Cmds = append(batch.Cmds, *sub)
+		}
+	}
+
+	var results []any
+	var err error
+	if len(batch.Cmds) > 0 {
+		if err = r.admit(ctx, sh); err == nil {
+			var res any
+			var index uint64
+			res, index, err = r.applyAt(ctx, sh, batch)
+			sh.done()
+			recordError(span, err)
+			if index > 0 {
+				st.readAfter(sh, index)
+				st.lastWrite = sh
+			}
+			results, _ = res.([]any)
+		}
+	}
+
+	for i, cmd := range cmds {
+		switch {
+		case replies[i] != nil:
+			conn.WriteRaw(replies[i])
+		case err != nil:
+			conn.WriteError(err.Error())
+		case pos[i] >= len(results):
+			conn.WriteError(raft.ErrUnknownOp.Error())
+		default:
+			result := results[pos[i]]
+			tc := &txConn{Conn: conn, shard: sh, applyFn: func(*raft.KVCmd) (any, error) {
+				if err, ok := result.(error); ok {
+					return nil, err
+				}
+				return result, nil
+			}}
+			r.dispatch(ctx, tc, commandOf(cmd), cmd)
+			conn.WriteRaw(tc.buf)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package transport
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/glob"
+	"raft-redis-cluster/raft"
+)
+
+// subscriber is a connection with channel or pattern subscriptions. It is
+// detached from redcon on its first SUBSCRIBE or PSUBSCRIBE, and its later
+// commands are read by serveSubscriber so that messages can be written to
+// it at any time.
+type subscriber struct {
+	// id is the ID of the client.
+	id int64
+	// mu serializes the messages and the command replies written to conn.
+	mu   sync.Mutex
+	conn redcon.DetachedConn
+	// deadline returns the write-timeout deadline of a write starting now,
+	// so a subscriber that stopped reading is disconnected.
+	deadline func() time.Time
+
+	// channels and patterns are only used by the goroutine serving the
+	// connection.
+	channels map[string]bool
+	patterns map[string]bool
+}
+
+// write runs f on the connection and flushes what it wrote.
+func (s *subscriber) write(f func(conn redcon.Conn)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(s.conn)
+	s.conn.NetConn().SetWriteDeadline(s.deadline())
+	s.conn.Flush()
+}
+
+func (s *subscriber) count() int {
+	return len(s.channels) + len(s.patterns)
+}
+
+// pubsub holds the subscriptions of the clients connected to this node.
+type pubsub struct {
+	mu       sync.RWMutex
+	channels map[string]map[*subscriber]bool
+	patterns map[string]map[*subscriber]bool
+}
+
+func newPubSub() *pubsub {
+	return &pubsub{
+		channels: map[string]map[*subscriber]bool{},
+		patterns: map[string]map[*subscriber]bool{},
+	}
+}
+
+// subscriber returns the subscriber to channel of the client id, if any.
+func (ps *pubsub) subscriber(channel string, id int64) *subscriber {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	for s := range ps.channels[channel] {
+		if s.id == id {
+			return s
+		}
+	}
+	return nil
+}
+
+func (ps *pubsub) add(m map[string]map[*subscriber]bool, name string, s *subscriber) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if m[name] == nil {
+		m[name] = map[*subscriber]bool{}
+	}
+	m[name][s] = true
+}
+
+func (ps *pubsub) remove(m map[string]map[*subscriber]bool, name string, s *subscriber) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	delete(m[name], s)
+	if len(m[name]) == 0 {
+		delete(m, name)
+	}
+}
+
+// Publish delivers a message to the subscribers connected to this node. It
+// is called by the state machine for every replicated PUBLISH.
+func (r *Redis) Publish(channel, message string) int {
+	ps := r.pubsub
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	n := 0
+	for s := range ps.channels[channel] {
+		s.write(func(conn redcon.Conn) {
+			writePush(conn, 3)
+			conn.WriteBulkString("message")
+			conn.WriteBulkString(channel)
+			conn.WriteBulkString(message)
+		})
+		n++
+	}
+	for pattern, subs := range ps.patterns {
+		if !glob.Match([]byte(pattern), []byte(channel)) {
+			continue
+		}
+		for s := range subs {
+			s.write(func(conn redcon.Conn) {
+				writePush(conn, 4)
+				conn.WriteBulkString("pmessage")
+				conn.WriteBulkString(pattern)
+				conn.WriteBulkString(channel)
+				conn.WriteBulkString(message)
+			})
+			n++
+		}
+	}
+	return n
+}
+
+// subscribedCmds are the commands a connection may send once it has
+// subscribed.
+var subscribedCmds = map[string]bool{
+	"SUBSCRIBE":    true,
+	"PSUBSCRIBE":   true,
+	"UNSUBSCRIBE":  true,
+	"PUNSUBSCRIBE": true,
+	"PING":         true,
+	"QUIT":         true,
+}
+
+// detach detaches the connection from redcon, unless it already was, so
+// that messages can be written to it at any time. It reports whether it
+// did, when the caller must start serveSubscriber on it once it wrote its
+// reply with the write of the subscriber.
+func (r *Redis) detach(conn redcon.Conn, st *connState) (*subscriber, bool) {
+	if st.sub != nil {
+		return st.sub, false
+	}
+	// Subscribers are not subject to the idle timeout.
+	conn.NetConn().SetReadDeadline(time.Time{})
+	st.detached = true
+	st.sub = &subscriber{id: st.id, conn: conn.Detach(), deadline: r.writeDeadline, channels: map[string]bool{}, patterns: map[string]bool{}}
+	return st.sub, true
+}
+
+// subscribe handles SUBSCRIBE and PSUBSCRIBE. The first one detaches the
+// connection and starts serveSubscriber on it.
+func (r *Redis) subscribe(conn redcon.Conn, plainCmd string, cmd redcon.Command) {
+	st := stateOf(conn)
+	s, detached := r.detach(conn, st)
+	if detached {
+		defer func() { go r.serveSubscriber(st, s) }()
+	}
+
+	kind, subs, m := "subscribe", s.channels, r.pubsub.channels
+	if plainCmd == "PSUBSCRIBE" {
+		kind, subs, m = "psubscribe", s.patterns, r.pubsub.patterns
+	}
+	for _, ch := range cmd.Args[1:] {
+		name := string(ch)
+		if !subs[name] {
+			subs[name] = true
+			r.pubsub.add(m, name, s)
+		}
+		n := s.count()
+		s.write(func(conn redcon.Conn) {
+			writePush(conn, 3)
+			conn.WriteBulkString(kind)
+			conn.WriteBulkString(name)
+			conn.WriteInt(n)
+		})
+	}
+}
+
+// unsubscribe handles UNSUBSCRIBE and PUNSUBSCRIBE. Without arguments, every
+// channel or pattern of the connection is removed.
+func (r *Redis) unsubscribe(conn redcon.Conn, plainCmd string, cmd redcon.Command) {
+	kind := "unsubscribe"
+	if plainCmd == "PUNSUBSCRIBE" {
+		kind = "punsubscribe"
+	}
+
+	s := stateOf(conn).sub
+	if s == nil {
+		// The connection has no subscriptions.
+		chs := cmd.Args[1:]
+		if len(chs) == 0 {
+			writePush(conn, 3)
+			conn.WriteBulkString(kind)
+			conn.WriteNull()
+			conn.WriteInt(0)
+			return
+		}
+		for _, ch := range chs {
+			writePush(conn, 3)
+			conn.WriteBulkString(kind)
+			conn.WriteBulk(ch)
+			conn.WriteInt(0)
+		}
+		return
+	}
+
+	subs, m := s.channels, r.pubsub.channels
+	if plainCmd == "PUNSUBSCRIBE" {
+		subs, m = s.patterns, r.pubsub.patterns
+	}
+	var names []string
+	for _, ch := range cmd.Args[1:] {
+		names = append(names, string(ch))
+	}
+	if len(names) == 0 {
+		for name := range subs {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		s.write(func(conn redcon.Conn) {
+			writePush(conn, 3)
+			conn.WriteBulkString(kind)
+			conn.WriteNull()
+			conn.WriteInt(0)
+		})
+		return
+	}
+
+	for _, name := range names {
+		if subs[name] {
+			delete(subs, name)
+			r.pubsub.remove(m, name, s)
+		}
+		n := s.count()
+		s.write(func(conn redcon.Conn) {
+			writePush(conn, 3)
+			conn.WriteBulkString(kind)
+			conn.WriteBulkString(name)
+			conn.WriteInt(n)
+		})
+	}
+}
+
+// serveSubscriber reads the commands of a detached connection until it is
+// closed. Replies are collected first and written under the lock of the
+// subscriber, so that they do not interleave with messages. While it has
+// subscriptions, a RESP2 connection may only send the commands of
+// subscribedCmds.
+func (r *Redis) serveSubscriber(st *connState, s *subscriber) {
+	defer func() {
+		for name := range s.channels {
+			r.pubsub.remove(r.pubsub.channels, name, s)
+		}
+		for name := range s.patterns {
+			r.pubsub.remove(r.pubsub.patterns, name, s)
+		}
+		s.conn.Close()
+		r.unregister(st)
+	}()
+
+	for {
+		cmd, err := s.conn.ReadCommand()
+		if err != nil {
+			return
+		}
+		name := commandOf(cmd)
+		if name == "QUIT" {
+			s.write(func(conn redcon.Conn) { conn.WriteString("OK") })
+			return
+		}
+
+		tc := &txConn{Conn: s.conn}
+		switch {
+		case subscribedCmds[name]:
+			r.serve(tc, cmd)
+		case s.count() == 0 || st.resp3.Load():
+			r.serveDetached(st, s, cmd)
+			continue
+		default:
+			tc.WriteError("ERR Can't execute '" + strings.ToLower(name) + "': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context")
+		}
+		if len(tc.buf) > 0 {
+			s.write(func(conn redcon.Conn) { conn.WriteRaw(tc.buf) })
+		}
+	}
+}
+
+// serveDetached serves a command of a detached connection as redcon would,
+// collecting its reply to write it at once.
+func (r *Redis) serveDetached(st *connState, s *subscriber, cmd redcon.Command) {
+	dc := &detachedConn{Conn: s.conn}
+	if !r.begin(st) {
+		s.write(func(conn redcon.Conn) { conn.WriteError(errShutdown.Error()) })
+		s.conn.Close()
+		return
+	}
+	if r.throttle(st, cmd) {
+		dc.WriteError(errThrottled.Error())
+	} else {
+		func() {
+			defer r.recoverCmd(dc, cmd)
+			r.serve(dc, cmd)
+		}()
+	}
+	s.write(func(conn redcon.Conn) { conn.WriteRaw(dc.buf) })
+	r.end(st, dc)
+}
+
+// detachedConn collects the replies of a command of a detached connection.
+// Unlike txConn, the command runs as it would on the connection itself.
+type detachedConn struct {
+	redcon.Conn
+	buf []byte
+}
+
+func (c *detachedConn) WriteError(msg string)       { c.buf = redcon.AppendError(c.buf, msg) }
+func (c *detachedConn) WriteString(str string)      { c.buf = redcon.AppendString(c.buf, str) }
+func (c *detachedConn) WriteBulk(bulk []byte)       { c.buf = redcon.AppendBulk(c.buf, bulk) }
+func (c *detachedConn) WriteBulkString(bulk string) { c.buf = redcon.AppendBulkString(c.buf, bulk) }
+func (c *detachedConn) WriteInt(num int)            { c.buf = redcon.AppendInt(c.buf, int64(num)) }
+func (c *detachedConn) WriteInt64(num int64)        { c.buf = redcon.AppendInt(c.buf, num) }
+func (c *detachedConn) WriteUint64(num uint64)      { c.buf = redcon.AppendUint(c.buf, num) }
+func (c *detachedConn) WriteArray(count int)        { c.buf = redcon.AppendArray(c.buf, count) }
+func (c *detachedConn) WriteNull()                  { c.buf = redcon.AppendNull(c.buf) }
+func (c *detachedConn) WriteRaw(data []byte)        { c.buf = append(c.buf, data...) }
+func (c *detachedConn) WriteAny(v interface{})      { c.buf = redcon.AppendAny(c.buf, v) }
+
+// publish handles PUBLISH channel message. The message is replicated through
+// the Raft log so subscribers connected to any node receive it. The channels
+// of the Raft events are reserved to the nodes.
+func (r *Redis) publish(conn redcon.Conn, cmd redcon.Command) {
+	if strings.HasPrefix(string(cmd.Args[1]), raftEventsPrefix) {
+		conn.WriteError("ERR the " + raftEventsPrefix + "* channels are reserved for the Raft events")
+		return
+	}
+	kvCmd := &raft.KVCmd{
+		Op:  raft.Publish,
+		Key: cmd.Args[1],
+		Val: cmd.Args[2],
+	}
+	res, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	n, _ := res.(int)
+	conn.WriteInt(n)
+}
@@ -0,0 +1,245 @@
+package transport
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+
+	hraft "github.com/hashicorp/raft"
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/raft"
+	"raft-redis-cluster/store"
+)
+
+// serverOf returns the server id of the configuration of sh.
+func serverOf(sh *Shard, id hraft.ServerID) (hraft.Server, error) {
+	f := sh.Raft.GetConfiguration()
+	if err := f.Error(); err != nil {
+		return hraft.Server{}, err
+	}
+	for _, s := range f.Configuration().Servers {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return hraft.Server{}, errors.New("ERR Unknown server " + string(id))
+}
+
+// raftTransfer handles RAFT.TRANSFER [shard [server-id]], which hands the
+// leadership of the shard over to server-id, or to the most up to date
+// follower. It must be sent to the leader of the shard.
+func (r *Redis) raftTransfer(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) > 3 {
+		conn.WriteError(errSyntax.Error())
+		return
+	}
+	sh := r.shards[0]
+	if len(cmd.Args) > 1 {
+		i, err := r.parseShard(cmd.Args[1])
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		sh = r.shards[i]
+	}
+	if r.moved(conn, sh, 0) {
+		return
+	}
+
+	var f hraft.Future
+	if len(cmd.Args) == 3 {
+		s, err := serverOf(sh, hraft.ServerID(cmd.Args[2]))
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		f = sh.Raft.LeadershipTransferToServer(s.ID, s.Address)
+	} else {
+		f = sh.Raft.LeadershipTransfer()
+	}
+	if err := f.Error(); err != nil {
+		conn.WriteError("ERR " + err.Error())
+		return
+	}
+	_, lid := sh.Raft.LeaderWithID()
+	r.logShard(sh).Info("leadership transferred", "leader_id", lid)
+	conn.WriteString("OK")
+}
+
+// raftSnapshot handles RAFT.SNAPSHOT [shard], which makes this node take a
+// snapshot of the shard, or of every shard, and compact its log.
+func (r *Redis) raftSnapshot(conn redcon.Conn, cmd redcon.Command) {
+	shards := r.allShards()
+	switch len(cmd.Args) {
+	case 1:
+	case 2:
+		i, err := r.parseShard(cmd.Args[1])
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		shards = []int{i}
+	default:
+		conn.WriteError(errSyntax.Error())
+		return
+	}
+	if err := r.snapshot(shards); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteString("OK")
+}
+
+// raftDigest handles RAFT.DIGEST [shard] and RAFT.DIGEST AT index [shard].
+// The first, sent to the leader of the shard, appends a Digest entry to its
+// log, so that every replica computes the digest of its keyspace once it
+// applied the same entries, and replies with the index of the entry, the
+// leader's digest and its number of keys. The second replies with those of
+// this node at index, waiting for it to apply the entry, so that comparing
+// them across the nodes finds the replicas that silently diverged.
+func (r *Redis) raftDigest(conn redcon.Conn, cmd redcon.Command) {
+	args := cmd.Args[1:]
+	at := len(args) > 0 && strings.EqualFold(string(args[0]), "AT")
+	var index uint64
+	if at {
+		if len(args) < 2 {
+			conn.WriteError("ERR wrong number of arguments for 'raft.digest|at' command")
+			return
+		}
+		var err error
+		if index, err = strconv.ParseUint(string(args[1]), 10, 64); err != nil || index == 0 {
+			conn.WriteError(errNotInteger.Error())
+			return
+		}
+		args = args[2:]
+	}
+	sh := r.shards[0]
+	switch len(args) {
+	case 0:
+	case 1:
+		i, err := r.parseShard(args[0])
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		sh = r.shards[i]
+	default:
+		conn.WriteError(errSyntax.Error())
+		return
+	}
+
+	var d raft.DigestResult
+	if at {
+		if err := r.waitApplied(stateOf(conn).spanContext(), sh, index); err != nil {
+			conn.WriteError("ERR entry " + strconv.FormatUint(index, 10) + " not applied yet")
+			return
+		}
+		var ok bool
+		if d, ok = sh.FSM.DigestAt(index); !ok {
+			conn.WriteError("ERR no digest at index " + strconv.FormatUint(index, 10))
+			return
+		}
+	} else {
+		if r.moved(conn, sh, 0) {
+			return
+		}
+		res, err := r.applyTo(sh, &raft.KVCmd{Op: raft.Digest})
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		d = res.(raft.DigestResult)
+	}
+	conn.WriteArray(3)
+	conn.WriteInt64(int64(d.Index))
+	conn.WriteBulkString(hex.EncodeToString(d.Digest))
+	conn.WriteInt(d.Keys)
+}
+
+// snapshot takes a snapshot of shards.
+func (r *Redis) snapshot(shards []int) error {
+	for _, i := range shards {
+		err := r.shards[i].Raft.Snapshot().Error()
+		switch {
+		case errors.Is(err, hraft.ErrNothingNewToSnapshot):
+		case err != nil:
+			return errors.New("ERR " + err.Error())
+		default:
+			r.logShard(r.shards[i]).Info("snapshot taken")
+		}
+	}
+	return nil
+}
+
+// failover handles CLUSTER FAILOVER [FORCE | TAKEOVER]. The node asks the
+// leader of every shard it follows to hand the leadership over to it, so
+// that it becomes the master of all the slots. The options are accepted for
+// compatibility: the leadership is always transferred by Raft, which keeps
+// the log consistent.
+func (r *Redis) failover(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) == 3 {
+		if opt := strings.ToUpper(string(cmd.Args[2])); opt != "FORCE" && opt != "TAKEOVER" {
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+	}
+
+	st := stateOf(conn)
+	followed := 0
+	for _, sh := range r.shards {
+		if sh.Raft.State() == hraft.Leader {
+			continue
+		}
+		followed++
+		if err := r.askTransfer(st, sh); err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+	}
+	if followed == 0 {
+		conn.WriteError("ERR You should send CLUSTER FAILOVER to a replica")
+		return
+	}
+	conn.WriteString("OK")
+}
+
+// askTransfer asks the leader of sh to transfer the leadership to this node,
+// with RAFT.TRANSFER.
+func (r *Redis) askTransfer(st *connState, sh *Shard) error {
+	return r.askLeader(st, sh, []byte("RAFT.TRANSFER"), []byte(strconv.Itoa(sh.index)), []byte(r.id))
+}
+
+// askLeader sends args to the leader of sh, authenticated as the client of
+// st, and returns the error it replies with.
+func (r *Redis) askLeader(st *connState, sh *Shard, args ...[]byte) error {
+	return r.askLeaderLines(st, sh, args)
+}
+
+// askLeaderLines is askLeader sending several commands, such as a SELECT
+// before the command, and returning the error the last one replies with.
+func (r *Redis) askLeaderLines(st *connState, sh *Shard, lines ...[][]byte) error {
+	_, lid := sh.Raft.LeaderWithID()
+	if lid == "" {
+		return errClusterDown
+	}
+	addr, err := store.GetRedisAddrByNodeID(r.stableStore, lid)
+	if err != nil {
+		return err
+	}
+	c, err := r.dialNode(st, addr, false)
+	if err != nil {
+		return err
+	}
+	defer c.conn.Close()
+
+	reply, err := c.roundTrip(lines)
+	if err != nil {
+		return err
+	}
+	if reply[0] == '-' {
+		return errors.New(string(reply[1 : len(reply)-2]))
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package transport
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	hraft "github.com/hashicorp/raft"
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/cluster"
+)
+
+// peerHealth records the followers the leader of a shard fails to send
+// heartbeats to, with the time it last heard from them.
+type peerHealth struct {
+	mu     sync.Mutex
+	failed map[hraft.ServerID]time.Time
+}
+
+// observe updates the health of the followers from an observation of Raft.
+// A new leader starts over, as it has yet to hear from the followers.
+func (h *peerHealth) observe(data any) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch o := data.(type) {
+	case hraft.FailedHeartbeatObservation:
+		if h.failed == nil {
+			h.failed = map[hraft.ServerID]time.Time{}
+		}
+		h.failed[o.PeerID] = o.LastContact
+	case hraft.ResumedHeartbeatObservation:
+		delete(h.failed, o.PeerID)
+	case hraft.LeaderObservation:
+		clear(h.failed)
+	}
+}
+
+// failedSince returns the time the leader last heard from the follower id,
+// and whether it is failing to send it heartbeats.
+func (h *peerHealth) failedSince(id hraft.ServerID) (time.Time, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t, ok := h.failed[id]
+	return t, ok
+}
+
+// raftInfo handles RAFT.INFO [shard], which replies with the consensus state
+// of the shard on this node: its leader, term, log indexes, last snapshot,
+// and the servers of its configuration with their health. Only the leader
+// knows whether the followers are reachable; a follower reports itself and
+// the leader it hears from as online, and the others as unknown.
+func (r *Redis) raftInfo(conn redcon.Conn, cmd redcon.Command) {
+	sh := r.shards[0]
+	switch len(cmd.Args) {
+	case 1:
+	case 2:
+		i, err := r.parseShard(cmd.Args[1])
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		sh = r.shards[i]
+	default:
+		conn.WriteError(errSyntax.Error())
+		return
+	}
+
+	f := sh.Raft.GetConfiguration()
+	if err := f.Error(); err != nil {
+		conn.WriteError("ERR " + err.Error())
+		return
+	}
+	servers := f.Configuration().Servers
+	stats := sh.Raft.Stats()
+	stat := func(name string) int64 {
+		n, _ := strconv.ParseInt(stats[name], 10, 64)
+		return n
+	}
+	lAddr, lid := sh.Raft.LeaderWithID()
+	leader := sh.Raft.State() == hraft.Leader
+
+	writeMap(conn, 14)
+	conn.WriteBulkString("node_id")
+	conn.WriteBulkString(string(r.id))
+	conn.WriteBulkString("shard")
+	conn.WriteInt(sh.index)
+	conn.WriteBulkString("state")
+	conn.WriteBulkString(sh.Raft.State().String())
+	conn.WriteBulkString("leader_id")
+	conn.WriteBulkString(string(lid))
+	conn.WriteBulkString("leader_address")
+	conn.WriteBulkString(string(lAddr))
+	conn.WriteBulkString("term")
+	conn.WriteInt64(stat("term"))
+	conn.WriteBulkString("last_log_index")
+	conn.WriteInt64(stat("last_log_index"))
+	conn.WriteBulkString("commit_index")
+	conn.WriteInt64(stat("commit_index"))
+	conn.WriteBulkString("applied_index")
+	conn.WriteInt64(int64(sh.Raft.AppliedIndex()))
+	conn.WriteBulkString("fsm_pending")
+	conn.WriteInt64(stat("fsm_pending"))
+	conn.WriteBulkString("last_snapshot_index")
+	conn.WriteInt64(stat("last_snapshot_index"))
+	conn.WriteBulkString("last_snapshot_term")
+	conn.WriteInt64(stat("last_snapshot_term"))
+	conn.WriteBulkString("last_contact_ms")
+	conn.WriteInt64(lastContactMs(sh, leader))
+	conn.WriteBulkString("peers")
+	conn.WriteArray(len(servers))
+	for _, s := range servers {
+		health, since := "unknown", int64(-1)
+		switch {
+		case s.ID == r.id:
+			health, since = "online", 0
+		case leader:
+			health, since = "online", 0
+			if t, ok := sh.health.failedSince(s.ID); ok {
+				health, since = "failed", time.Since(t).Milliseconds()
+			}
+		case s.ID == lid:
+			health, since = "online", lastContactMs(sh, false)
+		}
+		writeMap(conn, 5)
+		conn.WriteBulkString("id")
+		conn.WriteBulkString(string(s.ID))
+		conn.WriteBulkString("address")
+		conn.WriteBulkString(string(s.Address))
+		conn.WriteBulkString("suffrage")
+		conn.WriteBulkString(s.Suffrage.String())
+		conn.WriteBulkString("health")
+		conn.WriteBulkString(health)
+		conn.WriteBulkString("last_contact_ms")
+		conn.WriteInt64(since)
+	}
+}
+
+// lastContactMs returns how many milliseconds ago this node last heard from
+// the leader of sh: 0 on the leader, -1 when it never did.
+func lastContactMs(sh *Shard, leader bool) int64 {
+	if leader {
+		return 0
+	}
+	t := sh.Raft.LastContact()
+	if t.IsZero() {
+		return -1
+	}
+	return time.Since(t).Milliseconds()
+}
+
+// clusterInfo handles CLUSTER INFO. The cluster is ok while every shard has
+// a leader; the slots of the shards without one are failing. The epochs are
+// the Raft terms: the current epoch is the highest term of the shards, and
+// the epoch of this node the term of the first shard.
+func (r *Redis) clusterInfo(conn redcon.Conn) {
+	ranges := r.slotRanges()
+	ok, fail := 0, 0
+	var epoch uint64
+	for i, sh := range r.shards {
+		n := 0
+		for _, rg := range ranges[i] {
+			n += rg[1] - rg[0] + 1
+		}
+		if _, lid := sh.Raft.LeaderWithID(); lid != "" {
+			ok += n
+		} else {
+			fail += n
+		}
+		term, _ := strconv.ParseUint(sh.Raft.Stats()["term"], 10, 64)
+		epoch = max(epoch, term)
+	}
+	state := "ok"
+	if fail > 0 {
+		state = "fail"
+	}
+
+	b := &strings.Builder{}
+	infoField(b, "cluster_enabled", 1)
+	infoField(b, "cluster_state", state)
+	infoField(b, "cluster_slots_assigned", cluster.Slots)
+	infoField(b, "cluster_slots_ok", ok)
+	infoField(b, "cluster_slots_pfail", 0)
+	infoField(b, "cluster_slots_fail", fail)
+	infoField(b, "cluster_known_nodes", len(r.servers()))
+	infoField(b, "cluster_size", len(r.shards))
+	infoField(b, "cluster_current_epoch", epoch)
+	infoField(b, "cluster_my_epoch", r.raft.Stats()["term"])
+	for i, sh := range r.shards {
+		_, lid := sh.Raft.LeaderWithID()
+		infoField(b, fmt.Sprintf("cluster_shard%d", i), fmt.Sprintf("state=%s,leader_id=%s,applied_index=%d",
+			sh.Raft.State(), lid, sh.Raft.AppliedIndex()))
+	}
+	conn.WriteBulkString(b.String())
+}
@@ -0,0 +1,122 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/tidwall/redcon"
+)
+
+var errThrottled = errors.New("THROTTLED rate limit exceeded, try again later")
+
+// bucket is a token bucket refilled at a rate per second and holding at most
+// one second of tokens. A request larger than that is let through when the
+// bucket is full and leaves it in debt.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) refill(now time.Time, rate float64) {
+	if b.last.IsZero() {
+		b.tokens = rate
+	} else {
+		b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*rate, rate)
+	}
+	b.last = now
+}
+
+func (b *bucket) allows(rate, n float64) bool {
+	return rate <= 0 || b.tokens >= min(n, rate)
+}
+
+// limiter limits the commands and the bytes of commands per second of a
+// connection or of the connections from an IP address.
+type limiter struct {
+	mu    sync.Mutex
+	cmds  bucket
+	bytes bucket
+}
+
+// allow reports whether a command of n bytes is within the rates, and takes
+// it from the buckets if so.
+func (l *limiter) allow(now time.Time, cmdRate, byteRate int64, n int) bool {
+	if cmdRate <= 0 && byteRate <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.cmds.refill(now, float64(cmdRate))
+	l.bytes.refill(now, float64(byteRate))
+	if !l.cmds.allows(float64(cmdRate), 1) || !l.bytes.allows(float64(byteRate), float64(n)) {
+		return false
+	}
+	l.cmds.tokens--
+	l.bytes.tokens -= float64(n)
+	return true
+}
+
+// ipLimiter is the limiter shared by the connections from an IP address.
+type ipLimiter struct {
+	limiter
+	conns int
+}
+
+// rateLimited reports whether any rate limit is set.
+func (r *Redis) rateLimited() bool {
+	return r.clientMaxCmds.Load() > 0 || r.clientMaxBytes.Load() > 0 ||
+		r.ipMaxCmds.Load() > 0 || r.ipMaxBytes.Load() > 0
+}
+
+// throttle reports whether cmd goes over the rate limits of its connection
+// or of its IP address, set with client-max-cmds-per-sec,
+// client-max-bytes-per-sec, ip-max-cmds-per-sec and ip-max-bytes-per-sec.
+// A command over a limit is refused and doesn't count against the others.
+func (r *Redis) throttle(st *connState, cmd redcon.Command) bool {
+	if !r.rateLimited() {
+		return false
+	}
+	now := time.Now()
+	n := len(cmd.Raw)
+	if !st.limit.allow(now, r.clientMaxCmds.Load(), r.clientMaxBytes.Load(), n) ||
+		(st.ipLimit != nil && !st.ipLimit.allow(now, r.ipMaxCmds.Load(), r.ipMaxBytes.Load(), n)) {
+		r.throttledCmds.Add(1)
+		return true
+	}
+	return false
+}
+
+// trackIP attaches to st the limiter of the IP address of its connection.
+func (r *Redis) trackIP(st *connState) {
+	ip := st.conn.RemoteAddr()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	r.ipLimitsMu.Lock()
+	defer r.ipLimitsMu.Unlock()
+	l, ok := r.ipLimits[ip]
+	if !ok {
+		l = &ipLimiter{}
+		r.ipLimits[ip] = l
+	}
+	l.conns++
+	st.ip, st.ipLimit = ip, l
+}
+
+// untrackIP releases the limiter of the IP address of st, which is dropped
+// with the last connection from the address.
+func (r *Redis) untrackIP(st *connState) {
+	if st.ipLimit == nil {
+		return
+	}
+	r.ipLimitsMu.Lock()
+	defer r.ipLimitsMu.Unlock()
+	if st.ipLimit.conns--; st.ipLimit.conns == 0 {
+		delete(r.ipLimits, st.ip)
+	}
+	st.ipLimit = nil
+}
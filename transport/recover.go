@@ -0,0 +1,23 @@
+package transport
+
+import (
+	"runtime/debug"
+
+	"github.com/tidwall/redcon"
+)
+
+// recoverCmd recovers from a panic of cmd, which would otherwise crash the
+// node and drop every client. It logs the panic with its stack, counts it,
+// and replies with an error before closing the connection, whose state the
+// command may have left half changed. It must be deferred by the handler
+// of the connection.
+func (r *Redis) recoverCmd(conn redcon.Conn, cmd redcon.Command) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	r.cmdPanics.Add(1)
+	r.connLog(stateOf(conn)).Error("command panicked", "cmd", commandOf(cmd), "panic", v, "stack", string(debug.Stack()))
+	conn.WriteError("ERR internal error")
+	conn.Close()
+}
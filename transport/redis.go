@@ -2,74 +2,528 @@ package transport
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
-	"log"
+	"log/slog"
+	"math"
 	"net"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	hraft "github.com/hashicorp/raft"
 	"github.com/tidwall/redcon"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	"raft-redis-cluster/config"
+	"raft-redis-cluster/fault"
 	"raft-redis-cluster/raft"
 	"raft-redis-cluster/store"
 )
 
 type Redis struct {
-	listen      net.Listener
+	listenMu    sync.Mutex
+	listeners   []net.Listener
 	store       store.Store
 	stableStore hraft.StableStore
 	id          hraft.ServerID
-	raft        *hraft.Raft
+	shards      []*Shard
+	// raft and fsm belong to the first shard, store reads every shard.
+	raft   *hraft.Raft
+	fsm    *raft.StateMachine
+	pubsub *pubsub
+	// tracking holds the clients with CLIENT TRACKING on.
+	tracking *tracking
+	connID   atomic.Int64
+	started  time.Time
+
+	// draining is set by Drain and Shutdown, closing by Shutdown, and
+	// inflight counts the commands being served.
+	draining atomic.Bool
+	closing  atomic.Bool
+	inflight atomic.Int64
+
+	clientsMu sync.RWMutex
+	clients   map[int64]*connState
+	local     localConns
+
+	ipLimitsMu     sync.Mutex
+	ipLimits       map[string]*ipLimiter
+	clientMaxCmds  atomic.Int64
+	clientMaxBytes atomic.Int64
+	ipMaxCmds      atomic.Int64
+	ipMaxBytes     atomic.Int64
+	throttledCmds  atomic.Int64
+	rejectedConns  atomic.Int64
+	// cmdPanics counts the commands that panicked.
+	cmdPanics atomic.Int64
+
+	config       *config.Registry
+	reloadMu     sync.Mutex
+	requirepass  atomic.Value // string
+	advertise    atomic.Value // string
+	idleTimeout  atomic.Int64 // seconds
+	writeTimeout atomic.Int64 // seconds
+	maxClients   atomic.Int64
+	applyTimeout atomic.Int64 // milliseconds
+	applyRetries atomic.Int64
+	applyBackoff atomic.Int64 // milliseconds
+	maxmemory    atomic.Int64 // bytes
+	// commandTimeout is the deadline of the commands, 0 for none, and
+	// cmdReadTimeout and cmdWriteTimeout those of the reads and the writes
+	// when set.
+	commandTimeout  atomic.Int64 // milliseconds
+	cmdReadTimeout  atomic.Int64 // milliseconds
+	cmdWriteTimeout atomic.Int64 // milliseconds
+
+	// protoMaxBulkLen is the longest argument of a command, and
+	// raftChunkSize the largest value written in a single log entry, 0 for
+	// no limit. uploadSeq numbers the values written in chunks.
+	protoMaxBulkLen atomic.Int64 // bytes
+	raftChunkSize   atomic.Int64 // bytes
+	uploadSeq       atomic.Int64
+
+	// configFile is where CONFIG REWRITE writes configSet, the parameters
+	// changed with CONFIG SET.
+	configMu   sync.Mutex
+	configFile *config.File
+	configSet  map[string]bool
+
+	writeBatchWindow atomic.Int64 // microseconds
+	writeBatchMax    atomic.Int64
+
+	maxPendingWrites atomic.Int64
+	maxApplyBacklog  atomic.Int64 // log entries
+	busyWait         atomic.Int64 // milliseconds
+	rejectedWrites   atomic.Int64
+
+	maxmemoryPolicy  atomic.Int32
+	maxmemorySamples atomic.Int64
+	evictMu          sync.Mutex
+	evictedKeys      atomic.Int64
+
+	hz          atomic.Int64
+	expiredKeys atomic.Int64
+
+	databases int
+
+	saving     atomic.Bool
+	saveFailed atomic.Bool
+
+	forwardToLeader atomic.Bool
+	forwardTLS      *tls.Config
+
+	maxStaleness atomic.Int64 // milliseconds
+	maxLag       atomic.Int64 // log entries
+	consistency  atomic.Int32
+
+	readyGating atomic.Bool
+	readyMaxLag atomic.Int64 // log entries
+
+	logger   atomic.Pointer[slog.Logger]
+	logLevel slog.LevelVar
+
+	// faults is set when failures can be injected with DEBUG FAULT.
+	faults *fault.Injector
+
+	// handler runs the commands through the middleware added with
+	// WithMiddleware.
+	middleware []Middleware
+	handler    func(*Call)
+
+	// raftEvents are the Raft events waiting for deliverRaftEvents.
+	raftEvents  chan RaftEvent
+	onRaftEvent func(RaftEvent)
+}
+
+// Option sets the initial value of a runtime parameter in NewRedis.
+type Option func(*Redis)
+
+// WithMaxClients sets maxclients, the number of clients connected at once
+// beyond which new connections are refused.
+func WithMaxClients(n int64) Option {
+	return func(r *Redis) { r.maxClients.Store(n) }
+}
+
+// WithIdleTimeout sets timeout, after which a client that sent no command
+// is disconnected. Zero keeps idle clients connected.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(r *Redis) { r.idleTimeout.Store(int64(d / time.Second)) }
 }
 
-// NewRedis creates a new Redis transport.
-func NewRedis(id hraft.ServerID, raft *hraft.Raft, store store.Store, stableStore hraft.StableStore) *Redis {
-	return &Redis{
-		store:       store,
-		raft:        raft,
+// WithWriteTimeout sets write-timeout, after which a client that doesn't
+// read its replies is disconnected. Zero waits for it forever.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(r *Redis) { r.writeTimeout.Store(int64(d / time.Second)) }
+}
+
+// WithConfigFile sets the configuration file the node was started with,
+// where CONFIG REWRITE writes the parameters changed at runtime.
+func WithConfigFile(f *config.File) Option {
+	return func(r *Redis) { r.configFile = f }
+}
+
+// WithFaults enables DEBUG FAULT, which sets the failure points of f.
+func WithFaults(f *fault.Injector) Option {
+	return func(r *Redis) { r.faults = f }
+}
+
+// NewRedis creates a new Redis transport serving the slots of shards. The
+// Redis addresses of the nodes are read from stableStore.
+func NewRedis(id hraft.ServerID, shards []*Shard, stableStore hraft.StableStore, opts ...Option) *Redis {
+	stores := make([]store.Store, len(shards))
+	for i, sh := range shards {
+		sh.index = i
+		sh.startIndex = sh.Raft.LastIndex()
+		stores[i] = sh.Store
+	}
+	r := &Redis{
+		shards:      shards,
+		raft:        shards[0].Raft,
+		fsm:         shards[0].FSM,
 		id:          id,
 		stableStore: stableStore,
+		started:     time.Now(),
+		pubsub:      newPubSub(),
+		tracking:    newTracking(),
+		databases:   defaultDatabases,
+		clients:     map[int64]*connState{},
+		ipLimits:    map[string]*ipLimiter{},
+		config:      config.New(),
+		configSet:   map[string]bool{},
+		raftEvents:  make(chan RaftEvent, raftEventsBacklog),
+	}
+	r.store = store.NewShardedStore(stores, r.routeKey)
+	for _, sh := range shards {
+		r.watchRaft(sh)
+	}
+	r.logger.Store(r.newLogger())
+	r.registerConfig()
+	for _, opt := range opts {
+		opt(r)
 	}
+	r.handler = r.chain()
+	return r
+}
+
+// Config returns the runtime configuration of the node.
+func (r *Redis) Config() *config.Registry {
+	return r.config
+}
+
+// Serve listens on every address of addrs, such as an IPv4 and an IPv6
+// address or the addresses of several interfaces, and serves the clients of
+// all of them until one fails or Shutdown is called.
+func (r *Redis) Serve(addrs ...string) error {
+	return r.serveAll(addrs, nil)
+}
+
+// ServeTLS is like Serve, but clients connect over TLS with tlsConfig.
+func (r *Redis) ServeTLS(tlsConfig *tls.Config, addrs ...string) error {
+	return r.serveAll(addrs, tlsConfig)
 }
 
-func (r *Redis) Serve(addr string) error {
-	var err error
-	r.listen, err = net.Listen("tcp", addr)
+func (r *Redis) serveAll(addrs []string, tlsConfig *tls.Config) error {
+	lns, err := Listen(tlsConfig, addrs...)
 	if err != nil {
 		return err
 	}
-	return r.handle()
+	return r.ServeListeners(lns...)
+}
+
+// Listen listens on every address of addrs, over TLS with tlsConfig unless
+// it is nil, for ServeListeners.
+func Listen(tlsConfig *tls.Config, addrs ...string) ([]net.Listener, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("no address to listen on")
+	}
+	lns := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range lns {
+				l.Close()
+			}
+			return nil, err
+		}
+		if tlsConfig != nil {
+			ln = tls.NewListener(ln, tlsConfig)
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
 }
 
-func (r *Redis) handle() error {
-	return redcon.Serve(r.listen,
+// ServeListeners is like Serve, but serves the clients of listeners opened
+// by the caller, such as with Listen to know they are open before serving
+// them.
+func (r *Redis) ServeListeners(lns ...net.Listener) error {
+	if len(lns) == 0 {
+		return errors.New("no address to listen on")
+	}
+	r.listenMu.Lock()
+	r.listeners = lns
+	r.listenMu.Unlock()
+	if r.closing.Load() {
+		r.Close()
+		return ErrServerClosed
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go r.activeExpire(stop)
+	go r.deliverRaftEvents(stop)
+
+	errs := make(chan error, len(lns))
+	for _, ln := range lns {
+		go func() { errs <- r.handle(ln) }()
+	}
+	err := <-errs
+	r.Close()
+	for range lns[1:] {
+		<-errs
+	}
+	if r.closing.Load() {
+		return ErrServerClosed
+	}
+	return err
+}
+
+func (r *Redis) handle(ln net.Listener) error {
+	return redcon.Serve(ln,
 		func(conn redcon.Conn, cmd redcon.Command) {
-			err := r.validateCmd(cmd)
-			if err != nil {
-				conn.WriteError(err.Error())
+			st := stateOf(conn)
+			st.flushed = !st.pipelined
+			st.pipelined = len(conn.PeekPipeline()) > 0
+			if !r.begin(st) {
+				conn.WriteError(errShutdown.Error())
+				conn.Close()
 				return
 			}
-			r.processCmd(conn, cmd)
-		},
-		func(conn redcon.Conn) bool {
-			return true
-		},
-		func(conn redcon.Conn, err error) {
-			if err != nil {
-				log.Default().Println("error:", err)
+			defer r.end(st, conn)
+			defer r.recoverCmd(conn, cmd)
+			r.extendDeadline(conn)
+			if r.throttle(st, cmd) {
+				conn.WriteError(errThrottled.Error())
+				return
 			}
+			if r.servePipeline(conn, cmd) {
+				return
+			}
+			r.serve(conn, cmd)
 		},
+		r.accept,
+		r.closed,
 	)
 }
 
-var argsLen = map[string]int{
-	"GET": 2,
-	"SET": 3,
-	"DEL": 2,
+// serve checks a command line against the state of the connection and runs
+// it.
+func (r *Redis) serve(conn redcon.Conn, cmd redcon.Command) {
+	st := stateOf(conn)
+	st.seen(commandOf(cmd))
+	r.logCmd(st, cmd)
+	ctx, cancel := r.commandContext(st, commandOf(cmd))
+	defer cancel()
+	span := startCommand(ctx, st, commandOf(cmd))
+	defer endCommand(st, span)
+	if !st.authenticated.Load() && !noAuthCmds[commandOf(cmd)] {
+		conn.WriteError(errNoAuth.Error())
+		return
+	}
+	_, vspan := tracer.Start(st.traceCtx, "validate")
+	err := r.validateCmd(cmd)
+	if err == nil {
+		err = r.checkACL(st, cmd)
+	}
+	endSpan(vspan, err)
+	if err == nil {
+		useDB(st.db, cmd)
+	}
+	if tx := st.tx; tx != nil && tx.multi && !txCmds[commandOf(cmd)] {
+		r.queue(conn, tx, cmd, err)
+		return
+	}
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	if st.tracking {
+		r.trackRead(st, cmd)
+	}
+	r.handler(&Call{Context: st.spanContext(), Conn: conn, Args: cmd.Args, r: r, raw: cmd.Raw})
+}
+
+// commands is the command table: the arity of each command, including the
+// command name, whether any node serves it, and its handler. A negative
+// arity -N means the command takes at least N arguments. It is filled in
+// init, since the handlers refer back to it, and extended by
+// RegisterCommand.
+var commands map[string]*cmdSpec
+
+func init() {
+	commands = map[string]*cmdSpec{
+		"GET":     {arity: -2, run: (*Redis).get},
+		"SET":     {arity: -3, run: (*Redis).set},
+		"DEL":     {arity: -2, run: noCtx((*Redis).del)},
+		"UNLINK":  {arity: -2, run: noCtx((*Redis).del)},
+		"EXPIRE":  {arity: 3, run: named((*Redis).expire)},
+		"PEXPIRE": {arity: 3, run: named((*Redis).expire)},
+		"TTL":     {arity: 2, run: named((*Redis).ttl)},
+		"PTTL":    {arity: 2, run: named((*Redis).ttl)},
+		"PERSIST": {arity: 2, run: noCtx((*Redis).persist)},
+
+		"INCR":        {arity: 2, run: namedNoCtx((*Redis).incrBy)},
+		"DECR":        {arity: 2, run: namedNoCtx((*Redis).incrBy)},
+		"INCRBY":      {arity: 3, run: namedNoCtx((*Redis).incrBy)},
+		"DECRBY":      {arity: 3, run: namedNoCtx((*Redis).incrBy)},
+		"INCRBYFLOAT": {arity: 3, run: noCtx((*Redis).incrByFloat)},
+
+		"MGET": {arity: -2, run: (*Redis).mget},
+		"MSET": {arity: -3, run: noCtx((*Redis).mset)},
+
+		"APPEND":   {arity: 3, run: noCtx((*Redis).appendCmd)},
+		"STRLEN":   {arity: 2, run: (*Redis).strlen},
+		"GETRANGE": {arity: 4, run: (*Redis).getrange},
+		"SUBSTR":   {arity: 4, run: (*Redis).getrange},
+		"SETRANGE": {arity: 4, run: noCtx((*Redis).setrange)},
+
+		"EXISTS": {arity: -2, run: (*Redis).exists},
+		"TOUCH":  {arity: -2, run: (*Redis).exists},
+		"TYPE":   {arity: 2, run: (*Redis).typeCmd},
+
+		"VERSION": {arity: 2, run: (*Redis).version},
+
+		"DUMP":    {arity: 2, run: (*Redis).dump},
+		"RESTORE": {arity: -4, run: (*Redis).restore},
+
+		"RENAME":   {arity: 3, run: namedNoCtx((*Redis).rename)},
+		"RENAMENX": {arity: 3, run: namedNoCtx((*Redis).rename)},
+		"COPY":     {arity: -3, run: noCtx((*Redis).copyCmd)},
+
+		"LOCK":   {arity: 4, run: (*Redis).lock},
+		"UNLOCK": {arity: 3, run: noCtx((*Redis).unlock)},
+
+		"KEYS": {arity: 2, run: (*Redis).keys},
+		"SCAN": {arity: -2, run: (*Redis).scan},
+
+		"DBSIZE":   {arity: 1, run: (*Redis).dbsizeCmd},
+		"FLUSHDB":  {arity: -1, run: noCtx((*Redis).flush)},
+		"FLUSHALL": {arity: -1, run: noCtx((*Redis).flush)},
+		"SWAPDB":   {arity: -3, run: noCtx((*Redis).swapDB)},
+
+		"HSET":    {arity: -4, run: namedNoCtx((*Redis).hset)},
+		"HMSET":   {arity: -4, run: namedNoCtx((*Redis).hset)},
+		"HGET":    {arity: 3, run: (*Redis).hget},
+		"HMGET":   {arity: -3, run: (*Redis).hmget},
+		"HDEL":    {arity: -3, run: noCtx((*Redis).hdel)},
+		"HGETALL": {arity: 2, run: named((*Redis).hgetall)},
+		"HKEYS":   {arity: 2, run: named((*Redis).hgetall)},
+		"HVALS":   {arity: 2, run: named((*Redis).hgetall)},
+		"HLEN":    {arity: 2, run: (*Redis).hlen},
+		"HEXISTS": {arity: 3, run: (*Redis).hexists},
+		"HINCRBY": {arity: 4, run: noCtx((*Redis).hincrBy)},
+
+		"SADD":      {arity: -3, run: namedNoCtx((*Redis).sadd)},
+		"SREM":      {arity: -3, run: namedNoCtx((*Redis).sadd)},
+		"SMEMBERS":  {arity: 2, run: (*Redis).smembers},
+		"SISMEMBER": {arity: 3, run: (*Redis).sismember},
+		"SCARD":     {arity: 2, run: (*Redis).scard},
+
+		"ZADD":          {arity: -4, run: noCtx((*Redis).zadd)},
+		"ZREM":          {arity: -3, run: noCtx((*Redis).zrem)},
+		"ZSCORE":        {arity: 3, run: (*Redis).zscore},
+		"ZCARD":         {arity: 2, run: (*Redis).zcard},
+		"ZRANGE":        {arity: -4, run: (*Redis).zrange},
+		"ZRANGEBYSCORE": {arity: -4, run: (*Redis).zrangeByScore},
+
+		"SETBIT":   {arity: 4, run: noCtx((*Redis).setbit)},
+		"GETBIT":   {arity: 3, run: (*Redis).getbit},
+		"BITCOUNT": {arity: -2, run: (*Redis).bitcount},
+		"BITOP":    {arity: -4, run: noCtx((*Redis).bitop)},
+
+		"PFADD":   {arity: -2, run: noCtx((*Redis).pfadd)},
+		"PFCOUNT": {arity: -2, run: (*Redis).pfcount},
+		"PFMERGE": {arity: -2, run: noCtx((*Redis).pfmerge)},
+
+		"XADD":   {arity: -5, run: noCtx((*Redis).xadd)},
+		"XLEN":   {arity: 2, run: (*Redis).xlen},
+		"XRANGE": {arity: -4, run: (*Redis).xrange},
+		"XREAD":  {arity: -4, run: (*Redis).xread},
+
+		"SUBSCRIBE":    {arity: -2, local: true, run: namedNoCtx((*Redis).subscribe)},
+		"PSUBSCRIBE":   {arity: -2, local: true, run: namedNoCtx((*Redis).subscribe)},
+		"UNSUBSCRIBE":  {arity: -1, local: true, run: namedNoCtx((*Redis).unsubscribe)},
+		"PUNSUBSCRIBE": {arity: -1, local: true, run: namedNoCtx((*Redis).unsubscribe)},
+		"PUBLISH":      {arity: 3, run: noCtx((*Redis).publish)},
+
+		"MULTI":   {arity: 1, local: true, run: connOnly((*Redis).multi)},
+		"EXEC":    {arity: 1, local: true, run: connOnly((*Redis).exec)},
+		"DISCARD": {arity: 1, local: true, run: connOnly((*Redis).discard)},
+		"WATCH":   {arity: -2, run: (*Redis).watch},
+		"UNWATCH": {arity: 1, local: true, run: connOnly((*Redis).unwatch)},
+
+		"EVAL":    {arity: -3, run: namedNoCtx((*Redis).eval)},
+		"EVALSHA": {arity: -3, run: namedNoCtx((*Redis).eval)},
+		"SCRIPT":  {arity: -2, run: noCtx((*Redis).script)},
+
+		"PING":   {arity: -1, local: true, run: noCtx((*Redis).ping)},
+		"ECHO":   {arity: 2, local: true, run: noCtx((*Redis).echo)},
+		"QUIT":   {arity: 1, local: true, run: connOnly((*Redis).quit)},
+		"SELECT": {arity: 2, local: true, run: noCtx((*Redis).selectDB)},
+		"HELLO":  {arity: -1, local: true, run: noCtx((*Redis).hello)},
+
+		"INFO":    {arity: -1, local: true, run: noCtx((*Redis).info)},
+		"COMMAND": {arity: -1, local: true, run: noCtx((*Redis).command)},
+		"CONFIG":  {arity: -2, local: true, run: noCtx((*Redis).configCmd)},
+		"CLIENT":  {arity: -2, local: true, run: noCtx((*Redis).client)},
+		"MEMORY":  {arity: -2, local: true, run: noCtx((*Redis).memory)},
+		"DEBUG":   {arity: -2, local: true, run: noCtx((*Redis).debug)},
+		"AUTH":    {arity: -2, local: true, run: noCtx((*Redis).auth)},
+		"ACL":     {arity: -2, local: true, run: noCtx((*Redis).aclCmd)},
+		"TENANT":  {arity: -2, local: true, run: noCtx((*Redis).tenant)},
+
+		"ROLE":      {arity: 1, local: true, run: noCtx((*Redis).role)},
+		"REPLICAOF": {arity: 3, local: true, run: noCtx((*Redis).replicaOf)},
+		"SLAVEOF":   {arity: 3, local: true, run: noCtx((*Redis).replicaOf)},
+
+		"CLUSTER":   {arity: -2, local: true, run: noCtx((*Redis).clusterCmd)},
+		"ASKING":    {arity: 1, local: true, run: connOnly((*Redis).asking)},
+		"READONLY":  {arity: 1, local: true, run: connOnly((*Redis).readOnly)},
+		"READWRITE": {arity: 1, local: true, run: connOnly((*Redis).readWrite)},
+		"REQID":     {arity: -3, local: true, run: noCtx((*Redis).reqID)},
+
+		"RAFT.INFO":      {arity: -1, local: true, run: noCtx((*Redis).raftInfo)},
+		"RAFT.INDEX":     {arity: -1, local: true, run: noCtx((*Redis).raftIndex)},
+		"RAFT.READAFTER": {arity: -2, local: true, run: noCtx((*Redis).raftReadAfter)},
+		"RAFT.APPLIED":   {arity: -1, local: true, run: noCtx((*Redis).raftApplied)},
+		"RAFT.TRANSFER":  {arity: -1, local: true, run: noCtx((*Redis).raftTransfer)},
+		"RAFT.ADD":       {arity: -4, local: true, run: noCtx((*Redis).raftAdd)},
+		"RAFT.REMOVE":    {arity: -2, local: true, run: noCtx((*Redis).raftRemove)},
+		"RAFT.PROMOTE":   {arity: -2, local: true, run: noCtx((*Redis).raftPromote)},
+		"RAFT.DEMOTE":    {arity: -2, local: true, run: noCtx((*Redis).raftDemote)},
+		"RAFT.SNAPSHOT":  {arity: -1, local: true, run: noCtx((*Redis).raftSnapshot)},
+		"RAFT.DIGEST":    {arity: -1, local: true, run: noCtx((*Redis).raftDigest)},
+		"CDC.READ":       {arity: -2, local: true, run: noCtx((*Redis).cdcRead)},
+		"CDC.COMMIT":     {arity: -3, local: true, run: noCtx((*Redis).cdcCommit)},
+		"CDC.COMMITTED":  {arity: -2, local: true, run: noCtx((*Redis).cdcCommitted)},
+		"WAIT":           {arity: 3, local: true, run: noCtx((*Redis).wait)},
+
+		"BGSAVE":   {arity: -1, local: true, run: noCtx((*Redis).bgsave)},
+		"LASTSAVE": {arity: 1, local: true, run: connOnly((*Redis).lastSaveCmd)},
+	}
 }
 
+var (
+	errSyntax     = errors.New("ERR syntax error")
+	errNotInteger = errors.New("ERR value is not an integer or out of range")
+)
+
 const (
 	commandName = 0
 	keyName     = 1
@@ -77,100 +531,794 @@ const (
 )
 
 func (r *Redis) validateCmd(cmd redcon.Command) error {
+	if err := checkArity(cmd); err != nil {
+		return err
+	}
+	if err := r.checkBulkLen(cmd); err != nil {
+		return err
+	}
+
+	// The keys of the other databases are out of reach of the clients.
+	for _, k := range cmdKeys(commandOf(cmd), cmd.Args) {
+		if store.IsDBKey(k) {
+			return errDBKey
+		}
+	}
+
+	return nil
+}
+
+// checkArity checks that cmd is a known command with the number of arguments
+// it takes.
+func checkArity(cmd redcon.Command) error {
 	if len(cmd.Args) == 0 {
 		return errors.New("ERR no command provided")
 	}
-
-	plainCmd := strings.ToUpper(string(cmd.Args[commandName]))
-	expectedLen, ok := argsLen[plainCmd]
+	plainCmd := commandOf(cmd)
+	c, ok := commands[plainCmd]
 	if !ok {
 		return errors.New("ERR unknown command '" + plainCmd + "'")
 	}
-
-	if len(cmd.Args) != expectedLen {
+	if (c.arity >= 0 && len(cmd.Args) != c.arity) || len(cmd.Args) < -c.arity {
 		return errors.New("ERR wrong number of arguments for '" + plainCmd + "' command")
 	}
-
 	return nil
 }
 
-func (r *Redis) processCmd(conn redcon.Conn, cmd redcon.Command) {
-	ctx := context.Background()
+// commandOf returns the upper-cased command name of cmd.
+func commandOf(cmd redcon.Command) string {
+	if len(cmd.Args) == 0 {
+		return ""
+	}
+	return strings.ToUpper(string(cmd.Args[commandName]))
+}
+
+// processCmd routes a command to the shard of its keys and runs it with ctx.
+func (r *Redis) processCmd(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	plainCmd := commandOf(cmd)
+	st := stateOf(conn)
+	st.args = cmd.Args
+	asking := st.asking
+	st.asking = false
+
+	keys := cmdKeys(plainCmd, cmd.Args)
+	sh, slot, err := r.keysShard(keys)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	st.shard = sh
+	level, err := r.levelOf(st, plainCmd, cmd.Args)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	if isLocalCmd(plainCmd) {
+		r.dispatch(ctx, conn, plainCmd, cmd)
+		return
+	}
+	if err := r.available(sh); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	if isReadCmd(plainCmd) && !r.ready(sh) {
+		conn.WriteError(errLoading.Error())
+		return
+	}
+
+	ctx, ok := r.routeCmd(ctx, conn, plainCmd, level, keys, slot, asking)
+	if !ok {
+		return
+	}
+	r.dispatch(ctx, conn, plainCmd, cmd)
+}
 
-	if r.raft.State() != hraft.Leader {
-		_, lid := r.raft.LeaderWithID()
-		add, err := store.GetRedisAddrByNodeID(r.stableStore, lid)
+// routeCmd routes a command with route and makes reads wait for the writes
+// they must see.
+func (r *Redis) routeCmd(ctx context.Context, conn redcon.Conn, plainCmd string, level consistency, keys [][]byte, slot int, asking bool) (context.Context, bool) {
+	_, span := tracer.Start(ctx, "route")
+	defer span.End()
+
+	st := stateOf(conn)
+	if !(nodeCmds[plainCmd] && r.leads()) {
+		var ok bool
+		if ctx, ok = r.route(ctx, conn, level, keys, slot, asking); !ok {
+			return nil, false
+		}
+	}
+	if sh := st.shard; isReadCmd(plainCmd) {
+		if err := r.waitApplied(ctx, sh, st.readIndexOf(sh)); err != nil {
+			recordError(span, err)
+			conn.WriteError(err.Error())
+			return nil, false
+		}
+		if level == linearizable && sh.Raft.State() == hraft.Leader {
+			if err := r.readIndex(ctx, sh); err != nil {
+				recordError(span, err)
+				conn.WriteError(err.Error())
+				return nil, false
+			}
+		}
+	}
+	return ctx, true
+}
+
+// route redirects the client when this node does not serve the keys of a
+// command, and otherwise returns the context the command runs with. While
+// a slot is migrating, the source shard serves the keys it still holds and
+// sends the client to the target shard with an ASK error for the others.
+// The target shard serves the keys of the slot after ASKING. Followers serve
+// stale reads.
+func (r *Redis) route(ctx context.Context, conn redcon.Conn, level consistency, keys [][]byte, slot int, asking bool) (context.Context, bool) {
+	st := stateOf(conn)
+	from := st.shard
+	i, ok := r.fsm.Slots().Migrating(slot)
+	if len(keys) == 0 || !ok {
+		if level == stale && len(keys) > 0 && r.fresh(from) {
+			return ctx, true
+		}
+		return ctx, !r.moved(conn, from, slot)
+	}
+
+	to := r.shards[i]
+	if asking && to.Raft.State() == hraft.Leader {
+		st.shard = to
+		return withShard(ctx, to.index), true
+	}
+	if r.moved(conn, from, slot) {
+		return nil, false
+	}
+	n := 0
+	for _, k := range keys {
+		ok, err := from.Store.Exists(ctx, k)
 		if err != nil {
 			conn.WriteError(err.Error())
-			return
+			return nil, false
+		}
+		if ok {
+			n++
 		}
-		conn.WriteError("MOVED -1 " + add)
+	}
+	switch n {
+	case len(keys):
+		return ctx, true
+	case 0:
+		r.redirect(conn, "ASK", slot, to)
+	default:
+		conn.WriteError(errTryAgain.Error())
+	}
+	return nil, false
+}
+
+// moved redirects the client to the leader of sh with a MOVED error for
+// slot and reports whether it did so.
+func (r *Redis) moved(conn redcon.Conn, sh *Shard, slot int) bool {
+	if sh.Raft.State() == hraft.Leader {
+		return false
+	}
+	r.redirect(conn, "MOVED", slot, sh)
+	return true
+}
+
+// redirect sends the client to the leader of sh with a MOVED or an ASK
+// error for slot. When forward-to-leader is enabled, the command is
+// forwarded to the leader instead.
+func (r *Redis) redirect(conn redcon.Conn, kind string, slot int, sh *Shard) {
+	if r.forwarding(conn) {
+		args := stateOf(conn).args
+		if kind == "ASK" {
+			r.forward(conn, sh, [][]byte{[]byte("ASKING")}, args)
+		} else {
+			r.forward(conn, sh, args)
+		}
+		return
+	}
+
+	_, lid := sh.Raft.LeaderWithID()
+	if lid == "" {
+		conn.WriteError(errClusterDown.Error())
 		return
 	}
+	add, err := store.GetAdvertiseAddrByNodeID(r.stableStore, lid)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteError(kind + " " + strconv.Itoa(slot) + " " + add)
+}
 
-	plainCmd := strings.ToUpper(string(cmd.Args[commandName]))
-	switch plainCmd {
-	case "GET":
-		val, err := r.store.Get(ctx, cmd.Args[keyName])
+// dispatch runs a validated command with its handler, on the leader for
+// the commands that are not local.
+func (r *Redis) dispatch(ctx context.Context, conn redcon.Conn, plainCmd string, cmd redcon.Command) {
+	c, ok := commands[plainCmd]
+	if !ok {
+		conn.WriteError("ERR unknown command '" + plainCmd + "'")
+		return
+	}
+	c.run(r, ctx, conn, cmd)
+}
+
+// get handles GET key [consistency].
+func (r *Redis) get(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	if _, err := getConsistency(cmd.Args); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	val, err := r.store.Get(ctx, cmd.Args[keyName])
+	if err != nil {
+		if errors.Is(err, store.ErrKeyNotFound) {
+			conn.WriteNull()
+		} else {
+			conn.WriteError(err.Error())
+		}
+		return
+	}
+	r.writeBulk(conn, val)
+}
+
+// persist handles PERSIST key.
+func (r *Redis) persist(conn redcon.Conn, cmd redcon.Command) {
+	kvCmd := &raft.KVCmd{
+		Op:  raft.Persist,
+		Key: cmd.Args[keyName],
+	}
+	res, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteInt(boolToInt(res))
+}
+
+// incrByFloat handles INCRBYFLOAT key increment.
+func (r *Redis) incrByFloat(conn redcon.Conn, cmd redcon.Command) {
+	kvCmd := &raft.KVCmd{
+		Op:  raft.IncrByFloat,
+		Key: cmd.Args[keyName],
+		Val: cmd.Args[value],
+	}
+	res, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	b, _ := res.([]byte)
+	conn.WriteBulk(b)
+}
+
+// mget handles MGET key [key ...].
+func (r *Redis) mget(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	keys := cmd.Args[keyName:]
+	conn.WriteArray(len(keys))
+	for _, k := range keys {
+		val, err := r.store.Get(ctx, k)
 		if err != nil {
-			if errors.Is(err, store.ErrKeyNotFound) {
-				conn.WriteNull()
-			} else {
-				conn.WriteError(err.Error())
-			}
-			return
+			conn.WriteNull()
+			continue
 		}
 		conn.WriteBulk(val)
+	}
+}
 
-	case "SET":
-		kvCmd := &raft.KVCmd{
-			Op:  raft.Put,
-			Key: cmd.Args[keyName],
-			Val: cmd.Args[value],
-		}
-		b, err := json.Marshal(kvCmd)
+// mset handles MSET key value [key value ...].
+func (r *Redis) mset(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args)%2 != 1 {
+		conn.WriteError("ERR wrong number of arguments for '" + commandOf(cmd) + "' command")
+		return
+	}
+	kvCmd := &raft.KVCmd{Op: raft.MSet}
+	for i := keyName; i < len(cmd.Args); i += 2 {
+		kvCmd.Pairs = append(kvCmd.Pairs, raft.KVPair{Key: cmd.Args[i], Val: cmd.Args[i+1]})
+	}
+	_, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteString("OK")
+}
+
+// exists handles EXISTS key [key ...] and TOUCH key [key ...].
+func (r *Redis) exists(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	n := 0
+	for _, k := range cmd.Args[keyName:] {
+		ok, err := r.store.Exists(ctx, k)
 		if err != nil {
 			conn.WriteError(err.Error())
 			return
 		}
-		f := r.raft.Apply(b, time.Second*1)
-		if f.Error() != nil {
-			conn.WriteError(f.Error().Error())
+		if ok {
+			n++
+		}
+	}
+	conn.WriteInt(n)
+}
+
+// typeCmd handles TYPE key.
+func (r *Redis) typeCmd(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	kind, err := r.store.Type(ctx, cmd.Args[keyName])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteString(kind.String())
+}
+
+// version handles VERSION key. The version is read before the value, so a
+// SET IFVERSION made from a value read after it can only fail, never
+// overwrite a newer write.
+func (r *Redis) version(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	key := cmd.Args[keyName]
+	v, err := r.shards[r.routeKey(ctx, key)].FSM.Version(ctx, key)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteInt64(int64(v))
+}
+
+// dbsizeCmd handles DBSIZE.
+func (r *Redis) dbsizeCmd(ctx context.Context, conn redcon.Conn, _ redcon.Command) {
+	n, err := r.dbsize(ctx, conn)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteInt(n)
+}
+
+// echo handles ECHO message.
+func (r *Redis) echo(conn redcon.Conn, cmd redcon.Command) {
+	conn.WriteBulk(cmd.Args[1])
+}
+
+// asking handles ASKING, which lets the next command of the connection use
+// the keys of a slot migrating to a shard this node leads.
+func (r *Redis) asking(conn redcon.Conn) {
+	stateOf(conn).asking = true
+	conn.WriteString("OK")
+}
+
+// readOnly handles READONLY, which lets the connection read stale keys from
+// a follower.
+func (r *Redis) readOnly(conn redcon.Conn) {
+	stateOf(conn).consistency = stale
+	conn.WriteString("OK")
+}
+
+// readWrite handles READWRITE, which undoes READONLY.
+func (r *Redis) readWrite(conn redcon.Conn) {
+	stateOf(conn).consistency = consistencyDefault
+	conn.WriteString("OK")
+}
+
+// lastSaveCmd handles LASTSAVE.
+func (r *Redis) lastSaveCmd(conn redcon.Conn) {
+	conn.WriteInt64(r.lastSave().Unix())
+}
+
+// apply replicates cmd through the Raft log of the shard the command of conn
+// is routed to and returns the FSM response. Inside EXEC, the command is
+// handed to the transaction instead.
+func (r *Redis) apply(conn redcon.Conn, cmd *raft.KVCmd) (any, error) {
+	if tc, ok := conn.(*txConn); ok {
+		return tc.apply(cmd)
+	}
+	if denyOOM(cmd) {
+		if err := r.evict(); err != nil {
+			return nil, err
+		}
+	}
+	st := stateOf(conn)
+	sh := st.shard
+	if sh == nil {
+		sh = r.shards[0]
+	}
+	if err := r.admit(st.spanContext(), sh); err != nil {
+		return nil, err
+	}
+	defer sh.done()
+	ctx, span := tracer.Start(st.spanContext(), "raft.apply", trace.WithAttributes(attribute.Int("raft.shard", sh.index)))
+	cmd.Trace = traceParentOf(ctx)
+	cmd.RequestID, st.requestID = st.requestID, ""
+	if st.tracking {
+		cmd.Origin = r.origin(st)
+	}
+	res, index, err := r.applyBatched(ctx, sh, cmd)
+	span.SetAttributes(attribute.Int64("raft.index", int64(index)))
+	endSpan(span, err)
+	if index > 0 {
+		st.readAfter(sh, index)
+		st.lastWrite = sh
+	}
+	return res, err
+}
+
+// applyTo replicates cmd through the Raft log of sh, on behalf of the node
+// rather than of a client.
+func (r *Redis) applyTo(sh *Shard, cmd *raft.KVCmd) (any, error) {
+	res, _, err := r.applyAt(context.Background(), sh, cmd)
+	return res, err
+}
+
+// applyAt replicates cmd through the Raft log of sh and also returns the
+// index of its entry, or 0 when it was not applied. Up to raft-apply-retries
+// failed attempts are retried, waiting raft-apply-retry-backoff milliseconds
+// doubled on each one, when retryable allows it. Once ctx is done, cmd is
+// no longer appended nor waited for. A value larger than raft-chunk-size is
+// written in chunks.
+func (r *Redis) applyAt(ctx context.Context, sh *Shard, cmd *raft.KVCmd) (any, uint64, error) {
+	if r.chunked(cmd) {
+		return r.applyChunked(ctx, sh, cmd)
+	}
+	b, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, 0, err
+	}
+	var f hraft.ApplyFuture
+	for attempt := int64(0); ; attempt++ {
+		if ctx.Err() != nil {
+			return nil, 0, store.ContextErr(ctx)
+		}
+		f = sh.Raft.Apply(b, time.Duration(r.applyTimeout.Load())*time.Millisecond)
+		err := await(ctx, f)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			r.logShard(sh).Debug("raft apply abandoned", "op", cmd.Op, "error", err)
+			return nil, 0, err
+		}
+		if attempt >= r.applyRetries.Load() || !retryable(cmd, err) {
+			r.logShard(sh).Warn("raft apply failed", "op", cmd.Op, "attempts", attempt+1, "error", err)
+			return nil, 0, err
+		}
+		r.logShard(sh).Info("retrying raft apply", "op", cmd.Op, "attempt", attempt+1, "error", err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(min(time.Duration(r.applyBackoff.Load()<<attempt)*time.Millisecond, maxApplyBackoff)):
+		}
+	}
+	res := f.Response()
+	if err, ok := res.(error); ok {
+		return nil, f.Index(), err
+	}
+	return res, f.Index(), nil
+}
+
+// retryable reports whether cmd may be applied again after err. The entry
+// was not appended when the queue was full or this node wasn't the leader,
+// so any command is retried. When leadership was lost while it was waiting,
+// it may still be committed by the next leader, so only the commands whose
+// effect and reply are the same when applied twice, or that carry a request
+// ID, are retried. A value written in chunks is not, as the entry that
+// completes it consumes its chunks.
+func retryable(cmd *raft.KVCmd, err error) bool {
+	switch {
+	case errors.Is(err, hraft.ErrEnqueueTimeout), errors.Is(err, hraft.ErrNotLeader):
+		return true
+	case errors.Is(err, hraft.ErrLeadershipLost):
+		if cmd.RequestID != "" {
+			return true
+		}
+		if cmd.Upload != "" {
+			return false
+		}
+		switch cmd.Op {
+		case raft.Put:
+			return cmd.Cond == raft.CondNone && !cmd.Get
+		case raft.MSet, raft.Expire:
+			return true
+		}
+	}
+	return false
+}
+
+// set handles SET key value [NX | XX | IFVERSION version] [GET] [EX seconds |
+// PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds |
+// KEEPTTL]. IFVERSION writes the value only if the key is still at the
+// version read with VERSION, 0 for a key that must not exist, which the FSM
+// checks when it applies the entry.
+func (r *Redis) set(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	kvCmd := &raft.KVCmd{
+		Op:  raft.Put,
+		Key: cmd.Args[keyName],
+		Val: cmd.Args[value],
+	}
+
+	hasExpire := false
+	for i := value + 1; i < len(cmd.Args); i++ {
+		opt := strings.ToUpper(string(cmd.Args[i]))
+		switch opt {
+		case "NX", "XX":
+			if kvCmd.Cond != raft.CondNone {
+				conn.WriteError(errSyntax.Error())
+				return
+			}
+			kvCmd.Cond = raft.CondNX
+			if opt == "XX" {
+				kvCmd.Cond = raft.CondXX
+			}
+		case "IFVERSION":
+			if kvCmd.Cond != raft.CondNone || i+1 >= len(cmd.Args) {
+				conn.WriteError(errSyntax.Error())
+				return
+			}
+			i++
+			n, err := strconv.ParseUint(string(cmd.Args[i]), 10, 64)
+			if err != nil {
+				conn.WriteError(errNotInteger.Error())
+				return
+			}
+			kvCmd.Cond, kvCmd.Version = raft.CondVersion, n
+		case "GET":
+			kvCmd.Get = true
+		case "KEEPTTL":
+			if hasExpire {
+				conn.WriteError(errSyntax.Error())
+				return
+			}
+			kvCmd.KeepTTL = true
+			hasExpire = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if hasExpire || i+1 >= len(cmd.Args) {
+				conn.WriteError(errSyntax.Error())
+				return
+			}
+			i++
+			n, err := strconv.ParseInt(string(cmd.Args[i]), 10, 64)
+			if err != nil {
+				conn.WriteError(errNotInteger.Error())
+				return
+			}
+			at, ok := expireAt(store.Now(ctx), opt, n)
+			if n <= 0 || !ok {
+				conn.WriteError("ERR invalid expire time in 'set' command")
+				return
+			}
+			kvCmd.ExpireAt = at
+			hasExpire = true
+		default:
+			conn.WriteError(errSyntax.Error())
 			return
 		}
+	}
+
+	res, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	pr, _ := res.(raft.PutResult)
+
+	switch {
+	case kvCmd.Get && pr.PrevFound:
+		conn.WriteBulk(pr.Prev)
+	case kvCmd.Get, !pr.Applied:
+		conn.WriteNull()
+	default:
 		conn.WriteString("OK")
+	}
+}
 
-	case "DEL":
-		kvCmd := &raft.KVCmd{
-			Op:  raft.Del,
-			Key: cmd.Args[keyName],
+// flush handles FLUSHDB [ASYNC | SYNC] [SHARD i] and FLUSHALL [ASYNC | SYNC]
+// [SHARD i]. They wipe the keys of the selected database, or of every
+// database, in every shard, or in the shard i, one log entry per shard.
+func (r *Redis) flush(conn redcon.Conn, cmd redcon.Command) {
+	shards, args, err := r.parseMemberShard(cmd.Args)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	if len(args) > 2 {
+		conn.WriteError(errSyntax.Error())
+		return
+	}
+	if len(args) == 2 {
+		if mode := strings.ToUpper(string(args[1])); mode != "ASYNC" && mode != "SYNC" {
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+	}
+
+	name := commandOf(cmd)
+	kvCmd := func() *raft.KVCmd {
+		if name == "FLUSHDB" {
+			return &raft.KVCmd{Op: raft.FlushDB, Val: []byte(strconv.Itoa(selectedDB(conn)))}
 		}
-		b, err := json.Marshal(kvCmd)
+		return &raft.KVCmd{Op: raft.Flush}
+	}
+	if _, ok := conn.(*txConn); ok {
+		_, err = r.apply(conn, kvCmd())
+	} else if name == "FLUSHDB" {
+		selectDB := [][]byte{[]byte("SELECT"), []byte(strconv.Itoa(selectedDB(conn)))}
+		err = r.applyShards(conn, shards, kvCmd, selectDB, [][]byte{[]byte(name)})
+	} else {
+		err = r.applyShards(conn, shards, kvCmd, [][]byte{[]byte(name)})
+	}
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteString("OK")
+}
+
+// applyShards applies the command kvCmd makes to each of shards: this node
+// applies it to the shards it leads, and the leader of each other shard is
+// sent lines, with SHARD i added to the last one, so that the command
+// replies OK only once every shard applied it.
+func (r *Redis) applyShards(conn redcon.Conn, shards []int, kvCmd func() *raft.KVCmd, lines ...[][]byte) error {
+	for _, i := range shards {
+		sh := r.shards[i]
+		if sh.Raft.State() == hraft.Leader {
+			if _, err := r.applyTo(sh, kvCmd()); err != nil {
+				return err
+			}
+			continue
+		}
+		last := append(slices.Clip(lines[len(lines)-1]), []byte("SHARD"), []byte(strconv.Itoa(i)))
+		sent := append(slices.Clip(lines[:len(lines)-1]), last)
+		if err := r.askLeaderLines(stateOf(conn), sh, sent...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expireAt converts a SET expiration option into an absolute time in Unix
+// milliseconds, relative to now. It reports false when the time overflows.
+func expireAt(now time.Time, opt string, n int64) (int64, bool) {
+	switch opt {
+	case "EX":
+		return unixMilli(now, n, time.Second, true)
+	case "PX":
+		return unixMilli(now, n, time.Millisecond, true)
+	case "EXAT":
+		return unixMilli(now, n, time.Second, false)
+	default:
+		return n, true
+	}
+}
+
+// incrBy handles INCR, DECR, INCRBY and DECRBY. The read-modify-write is done
+// by the FSM so concurrent increments are serialized by the Raft log.
+func (r *Redis) incrBy(conn redcon.Conn, plainCmd string, cmd redcon.Command) {
+	var delta int64 = 1
+	if plainCmd == "INCRBY" || plainCmd == "DECRBY" {
+		n, err := strconv.ParseInt(string(cmd.Args[value]), 10, 64)
 		if err != nil {
-			conn.WriteError(err.Error())
+			conn.WriteError(errNotInteger.Error())
 			return
 		}
-		f := r.raft.Apply(b, time.Second*1)
-		if f.Error() != nil {
-			conn.WriteError(f.Error().Error())
+		delta = n
+	}
+	if plainCmd == "DECR" || plainCmd == "DECRBY" {
+		if delta == math.MinInt64 {
+			conn.WriteError("ERR decrement would overflow")
 			return
 		}
-		res := f.Response()
-		if err, ok := res.(error); ok {
+		delta = -delta
+	}
+
+	kvCmd := &raft.KVCmd{
+		Op:  raft.IncrBy,
+		Key: cmd.Args[keyName],
+		Val: []byte(strconv.FormatInt(delta, 10)),
+	}
+	res, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	n, _ := res.(int64)
+	conn.WriteInt64(n)
+}
+
+// expire handles EXPIRE and PEXPIRE. The deadline is computed here on the
+// leader and replicated as an absolute time.
+func (r *Redis) expire(ctx context.Context, conn redcon.Conn, plainCmd string, cmd redcon.Command) {
+	n, err := strconv.ParseInt(string(cmd.Args[value]), 10, 64)
+	if err != nil {
+		conn.WriteError(errNotInteger.Error())
+		return
+	}
+
+	unit := time.Second
+	if plainCmd == "PEXPIRE" {
+		unit = time.Millisecond
+	}
+	at, ok := unixMilli(store.Now(ctx), n, unit, true)
+	if !ok {
+		conn.WriteError("ERR invalid expire time in '" + strings.ToLower(plainCmd) + "' command")
+		return
+	}
+
+	kvCmd := &raft.KVCmd{
+		Op:       raft.Expire,
+		Key:      cmd.Args[keyName],
+		ExpireAt: at,
+	}
+	res, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteInt(boolToInt(res))
+}
+
+// unixMilli converts n units of time into Unix milliseconds, after now when
+// relative. It reports false when the result doesn't fit in an int64.
+func unixMilli(now time.Time, n int64, unit time.Duration, relative bool) (int64, bool) {
+	if unit == time.Second {
+		if n > math.MaxInt64/1000 || n < math.MinInt64/1000 {
+			return 0, false
+		}
+		n *= 1000
+	}
+	if relative {
+		base := now.UnixMilli()
+		if (n > 0 && n > math.MaxInt64-base) || (n < 0 && n < math.MinInt64-base) {
+			return 0, false
+		}
+		n += base
+	}
+	return n, true
+}
+
+// ttl handles TTL and PTTL. It replies -2 if the key does not exist and -1 if
+// the key has no expiration.
+func (r *Redis) ttl(ctx context.Context, conn redcon.Conn, plainCmd string, cmd redcon.Command) {
+	at, err := r.store.TTL(ctx, cmd.Args[keyName])
+	if err != nil {
+		if errors.Is(err, store.ErrKeyNotFound) {
+			conn.WriteInt(-2)
+		} else {
 			conn.WriteError(err.Error())
-			return
 		}
-		conn.WriteInt(1)
+		return
+	}
+	if at.IsZero() {
+		conn.WriteInt(-1)
+		return
+	}
 
-	default:
-		conn.WriteError("ERR unknown command '" + plainCmd + "'")
+	// In milliseconds, as a time.Duration overflows past 292 years. The time
+	// is that of the entry in scripts, which the FSM runs on every replica.
+	remain := at.UnixMilli() - store.Now(ctx).UnixMilli()
+	if plainCmd == "PTTL" {
+		conn.WriteInt64(remain)
+		return
 	}
+	conn.WriteInt64((remain + 500) / 1000)
 }
 
+func boolToInt(v any) int {
+	if b, ok := v.(bool); ok && b {
+		return 1
+	}
+	return 0
+}
+
+// Close closes the listeners.
 func (r *Redis) Close() error {
-	return r.listen.Close()
+	r.listenMu.Lock()
+	defer r.listenMu.Unlock()
+	var errs []error
+	for _, ln := range r.listeners {
+		if err := ln.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
 
+// Addr returns the address of the first listener.
 func (r *Redis) Addr() net.Addr {
-	return r.listen.Addr()
+	r.listenMu.Lock()
+	defer r.listenMu.Unlock()
+	if len(r.listeners) == 0 {
+		return nil
+	}
+	return r.listeners[0].Addr()
 }
\ No newline at end of file
@@ -0,0 +1,196 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/acl"
+	"raft-redis-cluster/raft"
+	"raft-redis-cluster/store"
+)
+
+// cmdHandler runs a validated command.
+type cmdHandler func(r *Redis, ctx context.Context, conn redcon.Conn, cmd redcon.Command)
+
+// cmdSpec is an entry of the command table.
+type cmdSpec struct {
+	arity int
+	// local commands are served by any node without redirecting to the
+	// leader.
+	local bool
+	run   cmdHandler
+	// keys are the positions of the keys of a command added with
+	// RegisterCommand. The keys of the built-in commands are found by
+	// cmdKeyRange.
+	keys *keyRange
+}
+
+// keyRange is every step-th argument from first to last, a negative last
+// counting from the end of the command line.
+type keyRange struct {
+	first, last, step int
+}
+
+// isLocalCmd reports whether name is a local command.
+func isLocalCmd(name string) bool {
+	c, ok := commands[name]
+	return ok && c.local
+}
+
+// The adapters below turn the handlers that don't take every argument of a
+// cmdHandler into one.
+
+func noCtx(f func(*Redis, redcon.Conn, redcon.Command)) cmdHandler {
+	return func(r *Redis, _ context.Context, conn redcon.Conn, cmd redcon.Command) {
+		f(r, conn, cmd)
+	}
+}
+
+func connOnly(f func(*Redis, redcon.Conn)) cmdHandler {
+	return func(r *Redis, _ context.Context, conn redcon.Conn, _ redcon.Command) {
+		f(r, conn)
+	}
+}
+
+// named passes the upper-cased command name to the handlers shared by
+// several commands.
+func named(f func(*Redis, context.Context, redcon.Conn, string, redcon.Command)) cmdHandler {
+	return func(r *Redis, ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+		f(r, ctx, conn, commandOf(cmd), cmd)
+	}
+}
+
+func namedNoCtx(f func(*Redis, redcon.Conn, string, redcon.Command)) cmdHandler {
+	return func(r *Redis, _ context.Context, conn redcon.Conn, cmd redcon.Command) {
+		f(r, conn, commandOf(cmd), cmd)
+	}
+}
+
+// CommandFlags describe how a command added with RegisterCommand is served.
+type CommandFlags int
+
+const (
+	// CommandWrite marks a command that writes keys. It is served by the
+	// leader of the shard of its keys and belongs to the write ACL
+	// category.
+	CommandWrite CommandFlags = 1 << iota
+	// CommandReadOnly marks a command that only reads keys. It is served
+	// at the read consistency of the client and belongs to the read ACL
+	// category.
+	CommandReadOnly
+	// CommandLocal marks a command served by any node, without redirecting
+	// the client to the leader.
+	CommandLocal
+)
+
+// Command is a command added to the server by the application embedding
+// it.
+type Command struct {
+	// Name is the name clients send, matched regardless of case.
+	Name string
+	// Arity is the number of arguments, including the name. A negative
+	// arity -N means the command takes at least N arguments.
+	Arity int
+	Flags CommandFlags
+	// FirstKey, LastKey and KeyStep are the positions of the keys of the
+	// command, which route it to the shard of its keys and are checked
+	// against the key patterns of the ACL: every KeyStep-th argument from
+	// FirstKey to LastKey, -1 for the last argument. A KeyStep of 0 means
+	// the command has no keys.
+	FirstKey int
+	LastKey  int
+	KeyStep  int
+	// Categories are ACL categories of the command besides the read or
+	// write category of its flags.
+	Categories []string
+	// Handler runs the command and writes its reply.
+	Handler func(*Call)
+}
+
+// Call is a command run by the Handler of a Command.
+type Call struct {
+	// Context carries the trace of the command and, inside a transaction or
+	// a script, the shard it runs on.
+	Context context.Context
+	// Conn is the client connection the reply is written to.
+	Conn redcon.Conn
+	// Args is the command line, the name first. The keys are those of the
+	// database the client selected.
+	Args [][]byte
+
+	r   *Redis
+	raw []byte
+}
+
+// Name returns the upper-cased name of the command.
+func (c *Call) Name() string {
+	return commandOf(redcon.Command{Args: c.Args})
+}
+
+// Store returns the keys of every shard of the node for reads. The writes
+// must go through Apply.
+func (c *Call) Store() store.Store {
+	return c.r.store
+}
+
+// Apply replicates cmd through the Raft log of the shard of the keys of the
+// command and returns the response of the state machine. Inside a
+// transaction, cmd becomes a part of its entry.
+func (c *Call) Apply(cmd *raft.KVCmd) (any, error) {
+	return c.r.apply(c.Conn, cmd)
+}
+
+// RegisterCommand adds c to the commands of every server of the process. It
+// must be called before the servers start, such as from an init function,
+// and fails when a command of the same name exists.
+func RegisterCommand(c Command) error {
+	name := strings.ToUpper(c.Name)
+	switch {
+	case name == "" || strings.ContainsAny(name, " |"):
+		return errors.New("transport: invalid command name " + c.Name)
+	case c.Arity == 0:
+		return errors.New("transport: the arity of command " + c.Name + " can't be 0")
+	case c.Handler == nil:
+		return errors.New("transport: command " + c.Name + " has no handler")
+	case c.Flags&CommandWrite != 0 && c.Flags&CommandReadOnly != 0:
+		return errors.New("transport: command " + c.Name + " can't both write and be read-only")
+	case c.KeyStep < 0 || (c.KeyStep > 0 && (c.FirstKey < 1 || (c.LastKey >= 0 && c.LastKey < c.FirstKey))):
+		return errors.New("transport: invalid key positions for command " + c.Name)
+	}
+	if _, ok := commands[name]; ok {
+		return errors.New("transport: command " + c.Name + " already exists")
+	}
+
+	var cats []string
+	switch {
+	case c.Flags&CommandWrite != 0:
+		cats = append(cats, "write")
+	case c.Flags&CommandReadOnly != 0:
+		cats = append(cats, "read")
+	}
+	for _, cat := range c.Categories {
+		cat = strings.ToLower(strings.TrimPrefix(cat, "@"))
+		if !slices.Contains(acl.Categories, cat) {
+			return errors.New("transport: unknown ACL category " + cat)
+		}
+		if !slices.Contains(cats, cat) {
+			cats = append(cats, cat)
+		}
+	}
+
+	handler := c.Handler
+	commands[name] = &cmdSpec{
+		arity: c.Arity,
+		local: c.Flags&CommandLocal != 0,
+		run: func(r *Redis, ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+			handler(&Call{Context: ctx, Conn: conn, Args: cmd.Args, r: r})
+		},
+		keys: &keyRange{first: c.FirstKey, last: c.LastKey, step: c.KeyStep},
+	}
+	cmdCategories[name] = cats
+	return nil
+}
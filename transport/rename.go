@@ -0,0 +1,75 @@
+package transport
+
+import (
+	"strings"
+
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/raft"
+	"raft-redis-cluster/store"
+)
+
+// rename handles RENAME key newkey and RENAMENX key newkey. Both keys must
+// be in the same slot, so the move is a single entry of the log of their
+// shard.
+func (r *Redis) rename(conn redcon.Conn, plainCmd string, cmd redcon.Command) {
+	kvCmd := &raft.KVCmd{
+		Op:  raft.Rename,
+		Key: cmd.Args[keyName],
+		Val: cmd.Args[2],
+	}
+	if plainCmd == "RENAMENX" {
+		kvCmd.Cond = raft.CondNX
+	}
+	res, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	if plainCmd == "RENAMENX" {
+		n, _ := res.(int)
+		conn.WriteInt(n)
+		return
+	}
+	conn.WriteString("OK")
+}
+
+// copyCmd handles COPY source destination [DB destination-db] [REPLACE].
+// The destination is in the database selected unless DB names another.
+func (r *Redis) copyCmd(conn redcon.Conn, cmd redcon.Command) {
+	kvCmd := &raft.KVCmd{
+		Op:   raft.Copy,
+		Key:  cmd.Args[keyName],
+		Val:  cmd.Args[2],
+		Cond: raft.CondNX,
+	}
+	for i := 3; i < len(cmd.Args); i++ {
+		switch strings.ToUpper(string(cmd.Args[i])) {
+		case "REPLACE":
+			kvCmd.Cond = raft.CondNone
+		case "DB":
+			if i+1 >= len(cmd.Args) {
+				conn.WriteError(errSyntax.Error())
+				return
+			}
+			i++
+			db, err := r.parseDB(cmd.Args[i])
+			if err != nil {
+				conn.WriteError(err.Error())
+				return
+			}
+			kvCmd.Val = store.DBKey(db, userKey(cmd.Args[2]))
+		default:
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+	}
+
+	res, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	n, _ := res.(int)
+	conn.WriteInt(n)
+}
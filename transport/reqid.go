@@ -0,0 +1,31 @@
+package transport
+
+import (
+	"errors"
+
+	"github.com/tidwall/redcon"
+)
+
+var errReqIDCmd = errors.New("ERR REQID can't wrap this command")
+
+// reqID handles REQID id command [arg ...], which serves command with its
+// write tagged with id. The state machine applies a write with a given id
+// once and answers its retries with the first result, so a client may safely
+// send the command again after a timeout or a lost connection left it unsure
+// whether the write was applied. Results are remembered for ten minutes.
+func (r *Redis) reqID(conn redcon.Conn, cmd redcon.Command) {
+	inner := redcon.Command{Args: cmd.Args[2:]}
+	if name := commandOf(inner); isLocalCmd(name) && name != "EXEC" {
+		conn.WriteError(errReqIDCmd.Error())
+		return
+	}
+	if len(cmd.Args[1]) == 0 {
+		conn.WriteError("ERR invalid request ID")
+		return
+	}
+
+	st := stateOf(conn)
+	st.requestID = string(cmd.Args[1])
+	r.serve(conn, inner)
+	st.requestID = ""
+}
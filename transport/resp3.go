@@ -0,0 +1,66 @@
+package transport
+
+import (
+	"strconv"
+
+	"github.com/tidwall/redcon"
+)
+
+// isRESP3 reports whether the client of conn switched to RESP3 with HELLO.
+// Replies built for scripts, which have no client, are always RESP2.
+func isRESP3(conn redcon.Conn) bool {
+	if tc, ok := conn.(*txConn); ok {
+		if tc.Conn == nil {
+			return tc.resp3
+		}
+		conn = tc.Conn
+	}
+	st, ok := conn.Context().(*connState)
+	return ok && st.resp3.Load()
+}
+
+func appendHeader(b []byte, prefix byte, n int) []byte {
+	b = append(b, prefix)
+	b = strconv.AppendInt(b, int64(n), 10)
+	return append(b, '\r', '\n')
+}
+
+// writeMap writes the header of a reply of n key-value pairs: a map in
+// RESP3, or an array of 2n elements in RESP2.
+func writeMap(conn redcon.Conn, n int) {
+	if isRESP3(conn) {
+		conn.WriteRaw(appendHeader(nil, '%', n))
+		return
+	}
+	conn.WriteArray(2 * n)
+}
+
+// writeSet writes the header of a reply of n unordered members: a set in
+// RESP3, or an array in RESP2.
+func writeSet(conn redcon.Conn, n int) {
+	if isRESP3(conn) {
+		conn.WriteRaw(appendHeader(nil, '~', n))
+		return
+	}
+	conn.WriteArray(n)
+}
+
+// writePush writes the header of an out-of-band message of n elements: a
+// push in RESP3, or an array in RESP2.
+func writePush(conn redcon.Conn, n int) {
+	if isRESP3(conn) {
+		conn.WriteRaw(appendHeader(nil, '>', n))
+		return
+	}
+	conn.WriteArray(n)
+}
+
+// writeDouble writes a score as a double in RESP3, or as a bulk string in
+// RESP2.
+func writeDouble(conn redcon.Conn, f float64) {
+	if isRESP3(conn) {
+		conn.WriteRaw([]byte("," + formatScore(f) + "\r\n"))
+		return
+	}
+	conn.WriteBulkString(formatScore(f))
+}
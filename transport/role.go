@@ -0,0 +1,77 @@
+package transport
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	hraft "github.com/hashicorp/raft"
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/store"
+)
+
+// role handles ROLE. As in INFO replication, the leader of the first shard
+// is the master and the other servers are its replicas. The replication
+// offset is the applied index of this node; those of the other nodes are not
+// known here and are reported as 0.
+func (r *Redis) role(conn redcon.Conn, _ redcon.Command) {
+	offset := int64(r.raft.AppliedIndex())
+	if r.raft.State() == hraft.Leader {
+		var replicas []hraft.Server
+		for _, s := range r.servers() {
+			if s.ID != r.id {
+				replicas = append(replicas, s)
+			}
+		}
+		conn.WriteArray(3)
+		conn.WriteBulkString("master")
+		conn.WriteInt64(offset)
+		conn.WriteArray(len(replicas))
+		for _, s := range replicas {
+			host, port := "", ""
+			if addr, err := store.GetAdvertiseAddrByNodeID(r.stableStore, s.ID); err == nil {
+				host, port, _ = net.SplitHostPort(addr)
+			}
+			conn.WriteArray(3)
+			conn.WriteBulkString(host)
+			conn.WriteBulkString(port)
+			conn.WriteBulkString("0")
+		}
+		return
+	}
+
+	host, port, state := "", 0, "connect"
+	if _, lid := r.raft.LeaderWithID(); lid != "" {
+		state = "connected"
+		if addr, err := store.GetAdvertiseAddrByNodeID(r.stableStore, lid); err == nil {
+			var p string
+			host, p, _ = net.SplitHostPort(addr)
+			port, _ = strconv.Atoi(p)
+		}
+	}
+	conn.WriteArray(5)
+	conn.WriteBulkString("slave")
+	conn.WriteBulkString(host)
+	conn.WriteInt(port)
+	conn.WriteBulkString(state)
+	conn.WriteInt64(offset)
+}
+
+// replicaOf handles REPLICAOF NO ONE and SLAVEOF NO ONE, which ask for this
+// node to become the master. The leader of the first shard already is, and
+// replies OK; a follower has the leader hand the leadership of the first
+// shard over to it, with RAFT.TRANSFER forwarded on behalf of the client.
+// The replicas follow the Raft leader, so REPLICAOF host port is refused.
+func (r *Redis) replicaOf(conn redcon.Conn, cmd redcon.Command) {
+	if !strings.EqualFold(string(cmd.Args[1]), "NO") || !strings.EqualFold(string(cmd.Args[2]), "ONE") {
+		conn.WriteError("ERR " + commandOf(cmd) + " host port is not supported, the replicas follow the Raft leader")
+		return
+	}
+	sh := r.shards[0]
+	if sh.Raft.State() == hraft.Leader {
+		conn.WriteString("OK")
+		return
+	}
+	r.forward(conn, sh, [][]byte{[]byte("RAFT.TRANSFER"), []byte("0"), []byte(r.id)})
+}
@@ -0,0 +1,60 @@
+package transport
+
+import (
+	"strings"
+	"time"
+
+	"github.com/tidwall/redcon"
+)
+
+// bgsave handles BGSAVE [SCHEDULE]. It snapshots every shard in the
+// background, the way Redis forks to write an RDB file.
+func (r *Redis) bgsave(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) == 2 && !strings.EqualFold(string(cmd.Args[1]), "SCHEDULE") {
+		conn.WriteError(errSyntax.Error())
+		return
+	}
+	if !r.saving.CompareAndSwap(false, true) {
+		conn.WriteError("ERR Background save already in progress")
+		return
+	}
+	go func() {
+		defer r.saving.Store(false)
+		err := r.snapshot(r.allShards())
+		r.saveFailed.Store(err != nil)
+		if err != nil {
+			r.log().Warn("background save failed", "error", err)
+		}
+	}()
+	conn.WriteString("Background saving started")
+}
+
+// lastSave returns when every shard was last snapshotted, or when the node
+// started if a shard has no snapshot since.
+func (r *Redis) lastSave() time.Time {
+	last := time.Now()
+	for _, sh := range r.shards {
+		t := sh.FSM.LastSnapshot()
+		if t.IsZero() {
+			return r.started
+		}
+		if t.Before(last) {
+			last = t
+		}
+	}
+	return last
+}
+
+func (r *Redis) infoPersistence(b *strings.Builder) {
+	inProgress, status := 0, "ok"
+	if r.saving.Load() {
+		inProgress = 1
+	}
+	if r.saveFailed.Load() {
+		status = "err"
+	}
+	infoField(b, "loading", boolToInt(r.anyLoading()))
+	infoField(b, "rdb_bgsave_in_progress", inProgress)
+	infoField(b, "rdb_last_save_time", r.lastSave().Unix())
+	infoField(b, "rdb_last_bgsave_status", status)
+}
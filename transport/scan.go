@@ -0,0 +1,144 @@
+package transport
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/redcon"
+	"raft-redis-cluster/glob"
+	"raft-redis-cluster/store"
+)
+
+const (
+	defaultScanCount = 10
+	keysPageSize     = 1000
+)
+
+// scanOptions are the optional MATCH, COUNT and TYPE arguments of SCAN.
+type scanOptions struct {
+	match []byte
+	count int
+	typ   string
+}
+
+func parseScanOptions(args [][]byte) (scanOptions, error) {
+	opts := scanOptions{count: defaultScanCount}
+	for i := 0; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return opts, errSyntax
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			opts.match = args[i+1]
+		case "COUNT":
+			n, err := strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return opts, errNotInteger
+			}
+			if n < 1 {
+				return opts, errSyntax
+			}
+			opts.count = n
+		case "TYPE":
+			opts.typ = strings.ToLower(string(args[i+1]))
+		default:
+			return opts, errSyntax
+		}
+	}
+	return opts, nil
+}
+
+// filter reports whether key should be returned to the client, which sees
+// the keys of shards in database db.
+func (o scanOptions) filter(ctx context.Context, r *Redis, shards []*Shard, db int, key []byte) (bool, error) {
+	kdb, k := store.SplitDBKey(key)
+	if kdb != db || !r.inScope(shards, key) {
+		return false, nil
+	}
+	if o.match != nil && !glob.Match(o.match, k) {
+		return false, nil
+	}
+	if o.typ == "" {
+		return true, nil
+	}
+	kind, err := r.store.Type(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	return kind.String() == o.typ, nil
+}
+
+// scan handles SCAN cursor [MATCH pattern] [COUNT count] [TYPE type].
+func (r *Redis) scan(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	cursor, err := strconv.ParseUint(string(cmd.Args[1]), 10, 64)
+	if err != nil {
+		conn.WriteError("ERR invalid cursor")
+		return
+	}
+	opts, err := parseScanOptions(cmd.Args[2:])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	keys, next, err := r.keyspace(conn).Scan(ctx, cursor, opts.count)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	shards := r.scope(conn)
+	db := selectedDB(conn)
+	matched := keys[:0]
+	for _, k := range keys {
+		ok, err := opts.filter(ctx, r, shards, db, k)
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		if ok {
+			matched = append(matched, userKey(k))
+		}
+	}
+
+	conn.WriteArray(2)
+	conn.WriteBulkString(strconv.FormatUint(next, 10))
+	conn.WriteArray(len(matched))
+	for _, k := range matched {
+		conn.WriteBulk(k)
+	}
+}
+
+// keys handles KEYS pattern. It walks the whole keyspace, so it is only
+// suitable for small datasets.
+func (r *Redis) keys(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	pattern := cmd.Args[keyName]
+
+	shards := r.scope(conn)
+	db := selectedDB(conn)
+	var matched [][]byte
+	var cursor uint64
+	for {
+		keys, next, err := r.keyspace(conn).Scan(ctx, cursor, keysPageSize)
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		for _, k := range keys {
+			kdb, uk := store.SplitDBKey(k)
+			if kdb == db && r.inScope(shards, k) && glob.Match(pattern, uk) {
+				matched = append(matched, uk)
+			}
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	conn.WriteArray(len(matched))
+	for _, k := range matched {
+		conn.WriteBulk(k)
+	}
+}
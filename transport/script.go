@@ -0,0 +1,194 @@
+package transport
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/raft"
+	"raft-redis-cluster/script"
+)
+
+// scriptDenied are the commands a script may not call, besides the ones
+// that only make sense on a client connection.
+var scriptDenied = map[string]bool{
+	"EVAL":    true,
+	"EVALSHA": true,
+	"SCRIPT":  true,
+	"WATCH":   true,
+}
+
+// eval handles EVAL script numkeys [key ...] [arg ...] and EVALSHA sha1
+// numkeys [key ...] [arg ...]. The script is replicated and run by the FSM
+// on every replica; EVALSHA is resolved to the script body here, from the
+// cache of the shard of the keys, where EVAL cached it.
+func (r *Redis) eval(conn redcon.Conn, plainCmd string, cmd redcon.Command) {
+	body := string(cmd.Args[1])
+	if plainCmd == "EVALSHA" {
+		var ok bool
+		body, ok = r.scriptFSM(conn).Script(body)
+		if !ok {
+			conn.WriteError("NOSCRIPT No matching script. Please use EVAL.")
+			return
+		}
+	}
+
+	numKeys, err := strconv.Atoi(string(cmd.Args[2]))
+	if err != nil {
+		conn.WriteError(errNotInteger.Error())
+		return
+	}
+	if numKeys < 0 {
+		conn.WriteError("ERR Number of keys can't be negative")
+		return
+	}
+	if numKeys > len(cmd.Args)-3 {
+		conn.WriteError(raft.ErrNumKeys.Error())
+		return
+	}
+	// As in a transaction, the keys of a migrating slot may be split
+	// between two shards, and a LOCK called by the script would be taken
+	// on either.
+	if _, ok := conn.(*txConn); !ok && numKeys > 0 {
+		if _, ok := r.fsm.Slots().Migrating(keySlot(cmd.Args[3])); ok {
+			conn.WriteError(errTryAgain.Error())
+			return
+		}
+	}
+
+	// The script sees its keys as the client sent them, and the commands
+	// it calls are run in the database of the client.
+	args := slices.Clone(cmd.Args[3:])
+	for i := range numKeys {
+		args[i] = userKey(args[i])
+	}
+	kvCmd := &raft.KVCmd{
+		Op:      raft.Eval,
+		Val:     []byte(body),
+		Args:    args,
+		NumKeys: numKeys,
+		DB:      selectedDB(conn),
+	}
+	res, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	b, _ := res.([]byte)
+	conn.WriteRaw(b)
+}
+
+// scriptFSM returns the FSM whose script cache EVALSHA reads on conn: that
+// of the shard running the transaction, or else of the shard the command
+// was routed to by its keys.
+func (r *Redis) scriptFSM(conn redcon.Conn) *raft.StateMachine {
+	if tc, ok := conn.(*txConn); ok && tc.shard != nil {
+		return tc.shard.FSM
+	}
+	if sh := stateOf(conn).shard; sh != nil {
+		return sh.FSM
+	}
+	return r.fsm
+}
+
+// script handles SCRIPT LOAD script [SHARD i], SCRIPT EXISTS sha1
+// [sha1 ...] and SCRIPT FLUSH [ASYNC | SYNC] [SHARD i]. Every shard has its
+// own script cache, so LOAD and FLUSH change the cache of every shard, or of
+// the shard i, one log entry per shard, and EXISTS finds the scripts cached
+// in any shard.
+func (r *Redis) script(conn redcon.Conn, cmd redcon.Command) {
+	sub := strings.ToUpper(string(cmd.Args[1]))
+	shards, args, err := r.parseMemberShard(cmd.Args)
+	if sub == "EXISTS" {
+		args, err = cmd.Args, nil
+	}
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	switch {
+	case sub == "LOAD" && len(args) == 3:
+		kvCmd := func() *raft.KVCmd {
+			return &raft.KVCmd{Op: raft.ScriptLoad, Val: args[2]}
+		}
+		if _, ok := conn.(*txConn); ok {
+			_, err = r.apply(conn, kvCmd())
+		} else {
+			err = r.applyShards(conn, shards, kvCmd, [][]byte{[]byte("SCRIPT"), []byte("LOAD"), args[2]})
+		}
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		// Loading a script that compiles always succeeds, so its digest
+		// is the reply.
+		conn.WriteBulkString(script.SHA1Hex(string(args[2])))
+
+	case sub == "EXISTS" && len(args) >= 3:
+		conn.WriteArray(len(args) - 2)
+		for _, sha := range args[2:] {
+			found := false
+			for _, sh := range r.shards {
+				if _, ok := sh.FSM.Script(string(sha)); ok {
+					found = true
+					break
+				}
+			}
+			conn.WriteInt(boolToInt(found))
+		}
+
+	case sub == "FLUSH" && len(args) <= 3:
+		kvCmd := func() *raft.KVCmd { return &raft.KVCmd{Op: raft.ScriptFlush} }
+		if _, ok := conn.(*txConn); ok {
+			_, err = r.apply(conn, kvCmd())
+		} else {
+			err = r.applyShards(conn, shards, kvCmd, [][]byte{[]byte("SCRIPT"), []byte("FLUSH")})
+		}
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		conn.WriteString("OK")
+
+	case sub == "LOAD" || sub == "EXISTS" || sub == "FLUSH":
+		conn.WriteError("ERR wrong number of arguments for 'script|" + strings.ToLower(sub) + "' command")
+
+	default:
+		conn.WriteError("ERR unknown subcommand '" + string(cmd.Args[1]) + "'. Try SCRIPT HELP.")
+	}
+}
+
+// RunCommand executes a command issued by a script. It is called by the FSM
+// on every replica while the script's entry is applied, and applies writes
+// through apply instead of the Raft log. Every replica must see the same
+// replies, so the commands read the time of the entry rather than the clock,
+// and only the keys of the shard; PUBLISH replies 0, as the subscribers
+// receiving a message are those of each replica.
+func (h *shardHandler) RunCommand(ctx context.Context, db int, args [][]byte, apply func(raft.KVCmd) any) []byte {
+	cmd := redcon.Command{Args: args}
+	if err := h.r.validateCmd(cmd); err != nil {
+		return redcon.AppendError(nil, err.Error())
+	}
+
+	plainCmd := commandOf(cmd)
+	if isLocalCmd(plainCmd) || txCmds[plainCmd] || scriptDenied[plainCmd] {
+		return redcon.AppendError(nil, "ERR This Redis command is not allowed from script")
+	}
+
+	useDB(db, cmd)
+	tc := &txConn{shard: h.sh, db: db, applyFn: func(c *raft.KVCmd) (any, error) {
+		res := apply(*c)
+		if err, ok := res.(error); ok {
+			return nil, err
+		}
+		if c.Op == raft.Publish {
+			return 0, nil
+		}
+		return res, nil
+	}}
+	h.r.dispatch(withShard(ctx, h.sh.index), tc, plainCmd, cmd)
+	return tc.buf
+}
@@ -0,0 +1,71 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"slices"
+
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/raft"
+)
+
+const member = 2
+
+// sadd handles SADD and SREM key member [member ...].
+func (r *Redis) sadd(conn redcon.Conn, plainCmd string, cmd redcon.Command) {
+	op := raft.SAdd
+	if plainCmd == "SREM" {
+		op = raft.SRem
+	}
+
+	kvCmd := &raft.KVCmd{
+		Op:   op,
+		Key:  cmd.Args[keyName],
+		Args: cmd.Args[member:],
+	}
+	res, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	n, _ := res.(int)
+	conn.WriteInt(n)
+}
+
+// smembers handles SMEMBERS key.
+func (r *Redis) smembers(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	members, err := r.store.SMembers(ctx, cmd.Args[keyName])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	// Members are sorted so that scripts running on every replica see the
+	// same order.
+	slices.SortFunc(members, bytes.Compare)
+
+	writeSet(conn, len(members))
+	for _, m := range members {
+		conn.WriteBulk(m)
+	}
+}
+
+// sismember handles SISMEMBER key member.
+func (r *Redis) sismember(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	ok, err := r.store.SIsMember(ctx, cmd.Args[keyName], cmd.Args[member])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteInt(boolToInt(ok))
+}
+
+// scard handles SCARD key.
+func (r *Redis) scard(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	n, err := r.store.SCard(ctx, cmd.Args[keyName])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteInt(n)
+}
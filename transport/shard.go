@@ -0,0 +1,183 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync/atomic"
+
+	hraft "github.com/hashicorp/raft"
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/cluster"
+	"raft-redis-cluster/raft"
+	"raft-redis-cluster/store"
+)
+
+var (
+	errCrossSlot = errors.New("CROSSSLOT Keys in request don't hash to the same slot")
+	errTryAgain  = errors.New("TRYAGAIN Multiple keys request during rehashing of slot")
+)
+
+// Shard is one of the Raft groups of the node. The hash slots are split
+// evenly between the shards, and each shard has its own state machine and
+// store. The first shard also holds the state that is not tied to a key:
+// the ACL, the script cache, PUBLISH and the slot table, which records the
+// slots migrated to another shard.
+type Shard struct {
+	Raft  *hraft.Raft
+	FSM   *raft.StateMachine
+	Store store.Store
+
+	index int
+	batch batcher
+	// pendingWrites is the number of client writes waiting to commit.
+	pendingWrites atomic.Int64
+	// health is the health of the followers while this node leads.
+	health peerHealth
+	// startIndex is the last index of the log when the node started, and
+	// loaded is set once it applied it.
+	startIndex uint64
+	loaded     atomic.Bool
+	// ready is set once the node caught up with the leader.
+	ready atomic.Bool
+}
+
+// shardIndex returns the index of the shard that owns slot.
+func (r *Redis) shardIndex(slot int) int {
+	if i, ok := r.fsm.Slots().Owner(slot); ok {
+		return i
+	}
+	return cluster.ShardOf(slot, len(r.shards))
+}
+
+func (r *Redis) shardOf(slot int) *Shard {
+	return r.shards[r.shardIndex(slot)]
+}
+
+func (r *Redis) shardOfKey(key []byte) *Shard {
+	return r.shardOf(keySlot(key))
+}
+
+// keysShard returns the hash slot keys belong to and the shard that owns
+// it. Every key must be in the same slot, as in Redis Cluster, which hash
+// tags allow for related keys. Commands without keys go to the first shard.
+func (r *Redis) keysShard(keys [][]byte) (*Shard, int, error) {
+	if len(keys) == 0 {
+		return r.shards[0], 0, nil
+	}
+	slot := keySlot(keys[0])
+	for _, k := range keys[1:] {
+		if keySlot(k) != slot {
+			return nil, 0, errCrossSlot
+		}
+	}
+	return r.shardOf(slot), slot, nil
+}
+
+type shardKey struct{}
+
+// withShard returns a context whose store reads go to the i-th shard,
+// whichever shard owns the keys.
+func withShard(ctx context.Context, i int) context.Context {
+	return context.WithValue(ctx, shardKey{}, i)
+}
+
+// routeKey returns the index of the shard the store reads key from.
+func (r *Redis) routeKey(ctx context.Context, key []byte) int {
+	if i, ok := ctx.Value(shardKey{}).(int); ok {
+		return i
+	}
+	return r.shardIndex(keySlot(key))
+}
+
+// leads reports whether this node is the leader of at least one shard.
+func (r *Redis) leads() bool {
+	for _, sh := range r.shards {
+		if sh.Raft.State() == hraft.Leader {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeCmds work on the keys of the shards this node leads, the way a Redis
+// Cluster node runs them on its own slots. Cluster clients send them to
+// every master. FLUSHDB, FLUSHALL, SWAPDB and SCRIPT also reach the other
+// shards, through their leader.
+var nodeCmds = map[string]bool{
+	"KEYS":     true,
+	"SCAN":     true,
+	"DBSIZE":   true,
+	"FLUSHDB":  true,
+	"FLUSHALL": true,
+	"SWAPDB":   true,
+	"SCRIPT":   true,
+}
+
+// scope returns the shards KEYS, SCAN and DBSIZE see on conn: the shard
+// whose FSM runs a transaction or a script, so that every replica sees the
+// same keys, and otherwise the shards this node leads.
+func (r *Redis) scope(conn redcon.Conn) []*Shard {
+	if len(r.shards) == 1 {
+		return r.shards
+	}
+	if tc, ok := conn.(*txConn); ok && tc.shard != nil {
+		return []*Shard{tc.shard}
+	}
+	var led []*Shard
+	for _, sh := range r.shards {
+		if sh.Raft.State() == hraft.Leader {
+			led = append(led, sh)
+		}
+	}
+	return led
+}
+
+func (r *Redis) inScope(shards []*Shard, key []byte) bool {
+	return len(r.shards) == 1 || slices.Contains(shards, r.shardOfKey(key))
+}
+
+// keyspace returns the store SCAN and KEYS walk: that of the shard running a
+// transaction or a script, whose pages then don't depend on how far the
+// replica applied the other shards, or else every shard.
+func (r *Redis) keyspace(conn redcon.Conn) store.Store {
+	if tc, ok := conn.(*txConn); ok && tc.shard != nil {
+		return tc.shard.Store
+	}
+	return r.store
+}
+
+// dbsize returns the number of keys of the selected database in the shards
+// of scope.
+func (r *Redis) dbsize(ctx context.Context, conn redcon.Conn) (int, error) {
+	total := 0
+	db := selectedDB(conn)
+	for _, sh := range r.scope(conn) {
+		n, err := sh.Store.DBSize(ctx, db)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// shardHandler runs the commands the FSM of a shard issues for the reads
+// of transactions and for scripts.
+type shardHandler struct {
+	r  *Redis
+	sh *Shard
+}
+
+// TxReader returns the TxReader of the i-th shard. The commands it runs read
+// the store of the shard.
+func (r *Redis) TxReader(i int) raft.TxReader {
+	return &shardHandler{r: r, sh: r.shards[i]}
+}
+
+// CommandRunner returns the CommandRunner of the i-th shard. The commands it
+// runs read the store of the shard.
+func (r *Redis) CommandRunner(i int) raft.CommandRunner {
+	return &shardHandler{r: r, sh: r.shards[i]}
+}
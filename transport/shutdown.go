@@ -0,0 +1,179 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	hraft "github.com/hashicorp/raft"
+	"github.com/tidwall/redcon"
+)
+
+// ErrServerClosed is returned by Serve and ServeTLS after Shutdown.
+var ErrServerClosed = errors.New("redis: server closed")
+
+var errShutdown = errors.New("ERR server is shutting down")
+
+// shutdownPoll is how often Shutdown checks whether the commands in flight
+// finished.
+const shutdownPoll = 10 * time.Millisecond
+
+// begin marks a command of st as in flight. It reports false once Shutdown
+// started, when the command must not be served.
+func (r *Redis) begin(st *connState) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if r.closing.Load() {
+		return false
+	}
+	st.busy = true
+	r.inflight.Add(1)
+	return true
+}
+
+// end marks the command of st as done. Once Shutdown started, the
+// connection is closed after its reply.
+func (r *Redis) end(st *connState, conn redcon.Conn) {
+	st.mu.Lock()
+	st.busy = false
+	st.mu.Unlock()
+	r.inflight.Add(-1)
+	if r.closing.Load() {
+		conn.Close()
+	}
+}
+
+// Drain makes the health endpoint of the admin API fail, so load balancers
+// stop sending new clients to the node ahead of Shutdown. The node keeps
+// serving its clients meanwhile.
+func (r *Redis) Drain() {
+	if !r.draining.Swap(true) {
+		r.log().Info("draining")
+	}
+}
+
+// Shutdown stops the server gracefully. It closes the listener, tells the
+// idle clients the server is shutting down and disconnects them, and lets
+// the commands in flight finish, closing their connections after the reply.
+// Then it transfers the leadership of every shard this node leads to
+// another voter, so the cluster elects no new leader by timeout. If ctx ends
+// first, the remaining connections are closed at once and its error is
+// returned.
+func (r *Redis) Shutdown(ctx context.Context) error {
+	if !r.closing.CompareAndSwap(false, true) {
+		return ErrServerClosed
+	}
+	r.draining.Store(true)
+	r.log().Info("shutting down", "clients", r.clientCount())
+	r.Close()
+	r.disconnectIdle()
+
+	t := time.NewTicker(shutdownPoll)
+	defer t.Stop()
+	for r.inflight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			r.disconnectAll()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return r.handOff()
+}
+
+// Kill stops the server at once: it closes the listener and every client
+// connection, without waiting for the commands in flight or handing the
+// leadership over.
+func (r *Redis) Kill() error {
+	if !r.closing.CompareAndSwap(false, true) {
+		return ErrServerClosed
+	}
+	r.draining.Store(true)
+	r.log().Info("killed", "clients", r.clientCount())
+	r.Close()
+	r.disconnectAll()
+	return nil
+}
+
+func (r *Redis) clientCount() int {
+	r.clientsMu.RLock()
+	defer r.clientsMu.RUnlock()
+	return len(r.clients)
+}
+
+// disconnectIdle notifies and closes the connections with no command in
+// flight. The error is written straight to the network connection, as the
+// handler of the connection may be blocked reading it.
+func (r *Redis) disconnectIdle() {
+	msg := redcon.AppendError(nil, errShutdown.Error())
+
+	r.clientsMu.RLock()
+	defer r.clientsMu.RUnlock()
+	for _, st := range r.clients {
+		if s := st.sub; st.detached && s != nil {
+			// A detached connection serving a command is closed by end.
+			st.mu.Lock()
+			busy := st.busy
+			st.mu.Unlock()
+			if !busy {
+				s.write(func(conn redcon.Conn) { conn.WriteError(errShutdown.Error()) })
+				s.conn.NetConn().Close()
+			}
+			continue
+		}
+		st.mu.Lock()
+		if !st.busy && st.conn != nil {
+			nc := st.conn.NetConn()
+			nc.SetWriteDeadline(time.Now().Add(time.Second))
+			nc.Write(msg)
+			nc.Close()
+		}
+		st.mu.Unlock()
+	}
+}
+
+// disconnectAll closes every client connection, abandoning the commands
+// they serve.
+func (r *Redis) disconnectAll() {
+	r.clientsMu.RLock()
+	defer r.clientsMu.RUnlock()
+	for _, st := range r.clients {
+		if st.conn != nil {
+			st.kill()
+		}
+	}
+}
+
+// handOff transfers the leadership of the shards this node leads.
+func (r *Redis) handOff() error {
+	var errs []error
+	for _, sh := range r.shards {
+		if sh.Raft.State() != hraft.Leader || !hasOtherVoter(sh) {
+			continue
+		}
+		if err := sh.Raft.LeadershipTransfer().Error(); err != nil {
+			r.logShard(sh).Warn("leadership transfer failed", "error", err)
+			errs = append(errs, err)
+			continue
+		}
+		_, lid := sh.Raft.LeaderWithID()
+		r.logShard(sh).Info("leadership transferred", "leader_id", lid)
+	}
+	return errors.Join(errs...)
+}
+
+// hasOtherVoter reports whether sh has a voter other than this node to take
+// over its leadership.
+func hasOtherVoter(sh *Shard) bool {
+	f := sh.Raft.GetConfiguration()
+	if f.Error() != nil {
+		return false
+	}
+	_, id := sh.Raft.LeaderWithID()
+	for _, s := range f.Configuration().Servers {
+		if s.Suffrage == hraft.Voter && s.ID != id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,284 @@
+package transport
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/raft"
+	"raft-redis-cluster/store"
+)
+
+// xreadPollInterval is how often a blocking XREAD checks for new entries.
+const xreadPollInterval = 10 * time.Millisecond
+
+// xadd handles XADD key <* | id> field value [field value ...].
+func (r *Redis) xadd(conn redcon.Conn, cmd redcon.Command) {
+	fields := cmd.Args[3:]
+	if len(fields)%2 != 0 {
+		conn.WriteError("ERR wrong number of arguments for 'XADD' command")
+		return
+	}
+
+	spec := string(cmd.Args[2])
+	if spec != "*" {
+		if _, err := store.ParseStreamID(strings.TrimSuffix(spec, "-*"), 0); err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+	}
+
+	kvCmd := &raft.KVCmd{
+		Op:   raft.XAdd,
+		Key:  cmd.Args[keyName],
+		Val:  cmd.Args[2],
+		Args: fields,
+	}
+	res, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	id, _ := res.(store.StreamID)
+	conn.WriteBulkString(id.String())
+}
+
+// xlen handles XLEN key.
+func (r *Redis) xlen(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	n, err := r.store.XLen(ctx, cmd.Args[keyName])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteInt(n)
+}
+
+// xrange handles XRANGE key start end [COUNT count].
+func (r *Redis) xrange(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	start, err := parseRangeID(string(cmd.Args[2]), false)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	end, err := parseRangeID(string(cmd.Args[3]), true)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	count := -1
+	switch len(cmd.Args) {
+	case 4:
+	case 6:
+		if strings.ToUpper(string(cmd.Args[4])) != "COUNT" {
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+		count, err = strconv.Atoi(string(cmd.Args[5]))
+		if err != nil {
+			conn.WriteError(errNotInteger.Error())
+			return
+		}
+	default:
+		conn.WriteError(errSyntax.Error())
+		return
+	}
+
+	entries, err := r.store.XRange(ctx, cmd.Args[keyName], start, end, count)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	writeStreamEntries(conn, entries)
+}
+
+// parseRangeID parses an XRANGE endpoint: "-", "+", an exclusive "(id" or an
+// id whose omitted sequence defaults to the low or high end.
+func parseRangeID(s string, end bool) (store.StreamID, error) {
+	switch s {
+	case "-":
+		return store.StreamID{}, nil
+	case "+":
+		return store.MaxStreamID, nil
+	}
+
+	exclusive := strings.HasPrefix(s, "(")
+	s = strings.TrimPrefix(s, "(")
+
+	var defaultSeq uint64
+	if end {
+		defaultSeq = store.MaxStreamID.Seq
+	}
+	id, err := store.ParseStreamID(s, defaultSeq)
+	if err != nil || !exclusive {
+		return id, err
+	}
+
+	if end {
+		return prevStreamID(id)
+	}
+	return nextStreamID(id)
+}
+
+func nextStreamID(id store.StreamID) (store.StreamID, error) {
+	switch {
+	case id.Seq < store.MaxStreamID.Seq:
+		id.Seq++
+	case id.Ms < store.MaxStreamID.Ms:
+		id.Ms, id.Seq = id.Ms+1, 0
+	default:
+		return id, store.ErrInvalidStreamID
+	}
+	return id, nil
+}
+
+func prevStreamID(id store.StreamID) (store.StreamID, error) {
+	switch {
+	case id.Seq > 0:
+		id.Seq--
+	case id.Ms > 0:
+		id.Ms, id.Seq = id.Ms-1, store.MaxStreamID.Seq
+	default:
+		return id, store.ErrInvalidStreamID
+	}
+	return id, nil
+}
+
+// xread handles XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...]
+// id [id ...].
+func (r *Redis) xread(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	count := -1
+	block := time.Duration(-1)
+
+	i := 1
+	for ; i < len(cmd.Args); i++ {
+		opt := strings.ToUpper(string(cmd.Args[i]))
+		if opt == "STREAMS" {
+			i++
+			break
+		}
+		if i+1 >= len(cmd.Args) {
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+		n, err := strconv.Atoi(string(cmd.Args[i+1]))
+		if err != nil || n < 0 {
+			conn.WriteError(errNotInteger.Error())
+			return
+		}
+		switch opt {
+		case "COUNT":
+			count = n
+		case "BLOCK":
+			block = time.Duration(n) * time.Millisecond
+		default:
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+		i++
+	}
+
+	rest := cmd.Args[min(i, len(cmd.Args)):]
+	if len(rest) == 0 || len(rest)%2 != 0 {
+		conn.WriteError("ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")
+		return
+	}
+	keys, ids := rest[:len(rest)/2], rest[len(rest)/2:]
+
+	after := make([]store.StreamID, len(keys))
+	for j, k := range keys {
+		var err error
+		if string(ids[j]) == "$" {
+			after[j], err = r.store.XLastID(ctx, k)
+		} else {
+			after[j], err = store.ParseStreamID(string(ids[j]), 0)
+		}
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+	}
+
+	// A transaction never blocks, as in Redis.
+	if _, ok := conn.(*txConn); ok {
+		block = -1
+	}
+
+	var deadline time.Time
+	if block > 0 {
+		deadline = time.Now().Add(block)
+	}
+	for {
+		found, err := r.readStreams(ctx, keys, after, count)
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		if len(found) > 0 {
+			// RESP3 clients get a map from stream key to entries.
+			resp3 := isRESP3(conn)
+			if resp3 {
+				writeMap(conn, len(found))
+			} else {
+				conn.WriteArray(len(found))
+			}
+			for _, s := range found {
+				if !resp3 {
+					conn.WriteArray(2)
+				}
+				conn.WriteBulk(userKey(s.key))
+				writeStreamEntries(conn, s.entries)
+			}
+			return
+		}
+		if block < 0 || (block > 0 && time.Now().After(deadline)) {
+			conn.WriteNull()
+			return
+		}
+		select {
+		case <-ctx.Done():
+			conn.WriteError(store.ContextErr(ctx).Error())
+			return
+		case <-time.After(xreadPollInterval):
+		}
+	}
+}
+
+type streamResult struct {
+	key     []byte
+	entries []store.StreamEntry
+}
+
+// readStreams returns the entries newer than after for each key that has any.
+func (r *Redis) readStreams(ctx context.Context, keys [][]byte, after []store.StreamID, count int) ([]streamResult, error) {
+	var found []streamResult
+	for j, k := range keys {
+		start, err := nextStreamID(after[j])
+		if err != nil {
+			continue
+		}
+		entries, err := r.store.XRange(ctx, k, start, store.MaxStreamID, count)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) > 0 {
+			found = append(found, streamResult{key: k, entries: entries})
+		}
+	}
+	return found, nil
+}
+
+func writeStreamEntries(conn redcon.Conn, entries []store.StreamEntry) {
+	conn.WriteArray(len(entries))
+	for _, e := range entries {
+		conn.WriteArray(2)
+		conn.WriteBulkString(e.ID.String())
+		conn.WriteArray(len(e.Fields))
+		for _, f := range e.Fields {
+			conn.WriteBulk(f)
+		}
+	}
+}
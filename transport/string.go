@@ -0,0 +1,106 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/raft"
+	"raft-redis-cluster/store"
+)
+
+// getrange handles GETRANGE key start end and its older name SUBSTR. Negative
+// offsets count from the end of the string.
+func (r *Redis) getrange(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	start, err1 := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+	end, err2 := strconv.ParseInt(string(cmd.Args[3]), 10, 64)
+	if err1 != nil || err2 != nil {
+		conn.WriteError(errNotInteger.Error())
+		return
+	}
+
+	val, err := r.store.Get(ctx, cmd.Args[keyName])
+	if err != nil && !errors.Is(err, store.ErrKeyNotFound) {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	n := int64(len(val))
+	if start < 0 && end < 0 && start > end {
+		conn.WriteBulkString("")
+		return
+	}
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if end < 0 {
+		end = max(n+end, 0)
+	}
+	end = min(end, n-1)
+	if start > end || n == 0 {
+		conn.WriteBulkString("")
+		return
+	}
+	r.writeBulk(conn, val[start:end+1])
+}
+
+// strlen handles STRLEN key.
+func (r *Redis) strlen(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	val, err := r.store.Get(ctx, cmd.Args[keyName])
+	if err != nil && !errors.Is(err, store.ErrKeyNotFound) {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteInt(len(val))
+}
+
+// setrange handles SETRANGE key offset value. The write is applied through
+// the log as an offset and the bytes written rather than as the new value,
+// so that it is computed from the value every replica has.
+func (r *Redis) setrange(conn redcon.Conn, cmd redcon.Command) {
+	offset, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+	if err != nil {
+		conn.WriteError(errNotInteger.Error())
+		return
+	}
+	if offset < 0 {
+		conn.WriteError("ERR offset is out of range")
+		return
+	}
+	if offset+int64(len(cmd.Args[3])) > raft.MaxStringLen {
+		conn.WriteError(raft.ErrStringTooLong.Error())
+		return
+	}
+
+	kvCmd := &raft.KVCmd{
+		Op:     raft.SetRange,
+		Key:    cmd.Args[keyName],
+		Offset: offset,
+		Val:    cmd.Args[3],
+	}
+	res, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	n, _ := res.(int)
+	conn.WriteInt(n)
+}
+
+// appendCmd handles APPEND key value.
+func (r *Redis) appendCmd(conn redcon.Conn, cmd redcon.Command) {
+	kvCmd := &raft.KVCmd{
+		Op:  raft.Append,
+		Key: cmd.Args[keyName],
+		Val: cmd.Args[value],
+	}
+	res, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	n, _ := res.(int)
+	conn.WriteInt(n)
+}
@@ -0,0 +1,149 @@
+package transport
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	hraft "github.com/hashicorp/raft"
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/raft"
+)
+
+// tenant handles TENANT SET, DEL, LIST and INFO. The tenants are part of the
+// replicated state of every shard, since their keys are spread over the
+// shards, so SET and DEL are applied to each shard and this node must lead
+// them all. Each shard enforces the quotas on its own keys.
+func (r *Redis) tenant(conn redcon.Conn, cmd redcon.Command) {
+	sub := strings.ToUpper(string(cmd.Args[1]))
+	switch {
+	case sub == "SET" && len(cmd.Args) >= 4:
+		r.tenantSet(conn, cmd)
+
+	case sub == "DEL" && len(cmd.Args) >= 3:
+		kvCmd := func() *raft.KVCmd { return &raft.KVCmd{Op: raft.DelTenant, Args: cmd.Args[2:]} }
+		res, ok := r.applyTenant(conn, kvCmd)
+		if !ok {
+			return
+		}
+		n, _ := res.(int)
+		conn.WriteInt(n)
+
+	case sub == "LIST" && len(cmd.Args) == 2:
+		ts := r.fsm.Tenants()
+		conn.WriteArray(len(ts))
+		for _, t := range ts {
+			conn.WriteBulkString(t.Name)
+		}
+
+	case sub == "INFO" && len(cmd.Args) == 3:
+		t, ok := r.fsm.Tenant(string(cmd.Args[2]))
+		if !ok {
+			conn.WriteNull()
+			return
+		}
+		u := r.store.PrefixUsage(t.Prefix)
+		writeMap(conn, 6)
+		conn.WriteBulkString("name")
+		conn.WriteBulkString(t.Name)
+		conn.WriteBulkString("prefix")
+		conn.WriteBulkString(t.Prefix)
+		conn.WriteBulkString("keys")
+		conn.WriteInt64(u.Keys)
+		conn.WriteBulkString("used_memory")
+		conn.WriteInt64(u.Bytes)
+		conn.WriteBulkString("maxkeys")
+		conn.WriteInt64(t.MaxKeys)
+		conn.WriteBulkString("maxmemory")
+		conn.WriteInt64(t.MaxMemory)
+
+	case sub == "SET" || sub == "DEL" || sub == "LIST" || sub == "INFO":
+		conn.WriteError("ERR wrong number of arguments for 'tenant|" + strings.ToLower(sub) + "' command")
+
+	default:
+		conn.WriteError("ERR unknown subcommand '" + string(cmd.Args[1]) + "'. Try TENANT HELP.")
+	}
+}
+
+// tenantSet handles TENANT SET name prefix [MAXKEYS count] [MAXMEMORY bytes],
+// which creates the tenant of the keys starting with prefix in every
+// database, or updates it. A missing quota, or one of 0, is no limit.
+func (r *Redis) tenantSet(conn redcon.Conn, cmd redcon.Command) {
+	var maxKeys, maxMemory int64
+	for i := 4; i < len(cmd.Args); i += 2 {
+		if i+1 >= len(cmd.Args) {
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+		n, err := strconv.ParseInt(string(cmd.Args[i+1]), 10, 64)
+		if err != nil || n < 0 {
+			conn.WriteError(errNotInteger.Error())
+			return
+		}
+		switch strings.ToUpper(string(cmd.Args[i])) {
+		case "MAXKEYS":
+			maxKeys = n
+		case "MAXMEMORY":
+			maxMemory = n
+		default:
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+	}
+
+	kvCmd := func() *raft.KVCmd {
+		return &raft.KVCmd{
+			Op:   raft.SetTenant,
+			Key:  cmd.Args[2],
+			Val:  cmd.Args[3],
+			Args: [][]byte{[]byte(strconv.FormatInt(maxKeys, 10)), []byte(strconv.FormatInt(maxMemory, 10))},
+		}
+	}
+	if _, ok := r.applyTenant(conn, kvCmd); ok {
+		conn.WriteString("OK")
+	}
+}
+
+// applyTenant applies the command made by kvCmd to every shard, one log
+// entry per shard, and returns the response of the first. It writes the
+// error and reports false when this node doesn't lead every shard or an
+// entry fails.
+func (r *Redis) applyTenant(conn redcon.Conn, kvCmd func() *raft.KVCmd) (any, bool) {
+	if _, ok := conn.(*txConn); ok {
+		conn.WriteError("ERR TENANT is not allowed in transactions")
+		return nil, false
+	}
+	if r.moved(conn, r.shards[0], 0) {
+		return nil, false
+	}
+	for _, sh := range r.shards[1:] {
+		if sh.Raft.State() != hraft.Leader {
+			conn.WriteError("ERR This node must lead every shard to change tenants")
+			return nil, false
+		}
+	}
+
+	var first any
+	for _, sh := range r.shards {
+		res, err := r.applyTo(sh, kvCmd())
+		if err != nil {
+			conn.WriteError(err.Error())
+			return nil, false
+		}
+		if sh.index == 0 {
+			first = res
+		}
+	}
+	return first, true
+}
+
+// infoTenants reports the usage and quotas of each tenant, summed over the
+// shards of this node.
+func (r *Redis) infoTenants(b *strings.Builder) {
+	for _, t := range r.fsm.Tenants() {
+		u := r.store.PrefixUsage(t.Prefix)
+		infoField(b, "tenant_"+t.Name, fmt.Sprintf("prefix=%s,keys=%d,used_memory=%d,maxkeys=%d,maxmemory=%d",
+			t.Prefix, u.Keys, u.Bytes, t.MaxKeys, t.MaxMemory))
+	}
+}
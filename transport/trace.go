@@ -0,0 +1,81 @@
+package transport
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer traces the commands once a tracer provider is set with
+// otel.SetTracerProvider. A command has a span from the moment redcon hands
+// it over until its reply is written, with children for its validation, its
+// routing and the replication of its writes through the Raft log. The
+// replication is continued by the FSM of every server applying the entry.
+var tracer = otel.Tracer("raft-redis-cluster/transport")
+
+// startCommand starts the span of the command name in ctx, as a child of
+// the trace context the client set with CLIENT SETINFO TRACEPARENT, if any.
+func startCommand(ctx context.Context, st *connState, name string) trace.Span {
+	if st.traceParent != "" {
+		ctx = extractTrace(ctx, st.traceParent, st.traceState)
+	}
+	ctx, span := tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("db.system", "redis"),
+			attribute.String("db.operation.name", name),
+			attribute.Int64("db.client.id", st.id),
+		))
+	st.traceCtx = ctx
+	return span
+}
+
+// endCommand ends the span of the command being served.
+func endCommand(st *connState, span trace.Span) {
+	st.traceCtx = nil
+	span.End()
+}
+
+// spanContext returns the context of the command being served, with its
+// span.
+func (st *connState) spanContext() context.Context {
+	if st.traceCtx == nil {
+		return context.Background()
+	}
+	return st.traceCtx
+}
+
+// extractTrace returns ctx with the remote span context of the W3C trace
+// context headers.
+func extractTrace(ctx context.Context, parent, state string) context.Context {
+	return propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{"traceparent": parent, "tracestate": state})
+}
+
+// traceParentOf returns the W3C traceparent of the span of ctx, or "" when
+// it is not traced.
+func traceParentOf(ctx context.Context) string {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ""
+	}
+	c := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, c)
+	return c.Get("traceparent")
+}
+
+// recordError records err, if any, as the status of span.
+func recordError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// endSpan ends span, recording err as its status.
+func endSpan(span trace.Span, err error) {
+	recordError(span, err)
+	span.End()
+}
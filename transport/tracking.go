@@ -0,0 +1,449 @@
+package transport
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tidwall/redcon"
+)
+
+// invalidateChannel is the channel RESP2 clients subscribe to, to receive
+// the invalidations redirected to them with CLIENT TRACKING ON REDIRECT.
+const invalidateChannel = "__redis__:invalidate"
+
+// tracker is the CLIENT TRACKING state of a connection.
+type tracker struct {
+	st *connState
+	// sub is the detached connection the invalidations are pushed to when
+	// they are not redirected.
+	sub      *subscriber
+	redirect int64
+	bcast    bool
+	prefixes []string
+	optin    bool
+	optout   bool
+	noloop   bool
+	// caching is the answer of CLIENT CACHING for the next command, when
+	// cachingSet: yes to track its keys in OPTIN mode, no not to in OPTOUT
+	// mode.
+	caching    bool
+	cachingSet bool
+	// keys are the keys the client read and was not told of since.
+	keys map[string]bool
+}
+
+// tracking holds the clients of this node with CLIENT TRACKING on, and the
+// keys they read. As every node applies every write, each node tells its
+// own clients of the keys that changed.
+type tracking struct {
+	mu      sync.Mutex
+	clients map[int64]*tracker
+	// keys maps each key read by the clients not in BCAST mode to them.
+	keys map[string]map[*tracker]bool
+}
+
+func newTracking() *tracking {
+	return &tracking{clients: map[int64]*tracker{}, keys: map[string]map[*tracker]bool{}}
+}
+
+// forget removes the keys of tr from the table. t.mu must be held.
+func (t *tracking) forget(tr *tracker) {
+	for k := range tr.keys {
+		delete(t.keys[k], tr)
+		if len(t.keys[k]) == 0 {
+			delete(t.keys, k)
+		}
+	}
+	tr.keys = map[string]bool{}
+}
+
+// origin identifies the connection st in the commands it writes, so that
+// it can skip the invalidations of its own writes.
+func (r *Redis) origin(st *connState) string {
+	return string(r.id) + "/" + strconv.FormatInt(st.id, 10)
+}
+
+// clientTracking handles CLIENT TRACKING ON|OFF [REDIRECT client-id]
+// [PREFIX prefix [PREFIX prefix ...]] [BCAST] [OPTIN] [OPTOUT] [NOLOOP].
+// Unless they are redirected, the invalidations are pushed to the
+// connection itself, which is detached as SUBSCRIBE does so that they can
+// be written at any time; a RESP2 connection only gets them redirected to
+// one subscribed to __redis__:invalidate.
+func (r *Redis) clientTracking(conn redcon.Conn, st *connState, cmd redcon.Command) {
+	on := false
+	switch strings.ToUpper(string(cmd.Args[2])) {
+	case "ON":
+		on = true
+	case "OFF":
+	default:
+		conn.WriteError(errSyntax.Error())
+		return
+	}
+
+	opts := tracker{st: st}
+	for i := 3; i < len(cmd.Args); i++ {
+		switch strings.ToUpper(string(cmd.Args[i])) {
+		case "REDIRECT":
+			if i+1 >= len(cmd.Args) {
+				conn.WriteError(errSyntax.Error())
+				return
+			}
+			i++
+			id, err := strconv.ParseInt(string(cmd.Args[i]), 10, 64)
+			if err != nil {
+				conn.WriteError(errNotInteger.Error())
+				return
+			}
+			opts.redirect = id
+		case "PREFIX":
+			if i+1 >= len(cmd.Args) {
+				conn.WriteError(errSyntax.Error())
+				return
+			}
+			i++
+			opts.prefixes = append(opts.prefixes, string(cmd.Args[i]))
+		case "BCAST":
+			opts.bcast = true
+		case "OPTIN":
+			opts.optin = true
+		case "OPTOUT":
+			opts.optout = true
+		case "NOLOOP":
+			opts.noloop = true
+		default:
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+	}
+
+	if !on {
+		r.untrack(st)
+		conn.WriteString("OK")
+		return
+	}
+	switch {
+	case len(opts.prefixes) > 0 && !opts.bcast:
+		conn.WriteError("ERR PREFIX option requires BCAST mode to be enabled")
+		return
+	case opts.optin && opts.optout:
+		conn.WriteError("ERR You can't use both OPTIN and OPTOUT")
+		return
+	case opts.bcast && (opts.optin || opts.optout):
+		conn.WriteError("ERR OPTIN and OPTOUT are not compatible with BCAST")
+		return
+	}
+	if opts.redirect != 0 && opts.redirect != st.id {
+		r.clientsMu.RLock()
+		_, ok := r.clients[opts.redirect]
+		r.clientsMu.RUnlock()
+		if !ok {
+			conn.WriteError("ERR The client ID you want redirect to does not exist")
+			return
+		}
+	}
+	if _, ok := conn.(*txConn); ok && opts.redirect == 0 && st.sub == nil {
+		conn.WriteError("ERR CLIENT TRACKING without REDIRECT can't be enabled in a transaction")
+		return
+	}
+
+	t := r.tracking
+	t.mu.Lock()
+	tr := t.clients[st.id]
+	if tr != nil {
+		if tr.bcast != opts.bcast {
+			t.mu.Unlock()
+			conn.WriteError("ERR You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode.")
+			return
+		}
+		for _, p := range opts.prefixes {
+			if q, ok := overlappingPrefix(p, tr.prefixes); ok {
+				t.mu.Unlock()
+				conn.WriteError("ERR Prefix '" + p + "' overlaps with an existing prefix '" + q + "'. Prefixes for a single client must not overlap.")
+				return
+			}
+		}
+		opts.prefixes = append(tr.prefixes, opts.prefixes...)
+		opts.sub, opts.keys = tr.sub, tr.keys
+	} else {
+		for i, p := range opts.prefixes {
+			if q, ok := overlappingPrefix(p, opts.prefixes[:i]); ok {
+				t.mu.Unlock()
+				conn.WriteError("ERR Prefix '" + p + "' overlaps with an existing prefix '" + q + "'. Prefixes for a single client must not overlap.")
+				return
+			}
+		}
+		opts.keys = map[string]bool{}
+		tr = &tracker{}
+		t.clients[st.id] = tr
+	}
+	*tr = opts
+	t.mu.Unlock()
+	st.tracking = true
+
+	if opts.redirect != 0 || st.sub != nil {
+		if tr.sub == nil && st.sub != nil {
+			t.mu.Lock()
+			tr.sub = st.sub
+			t.mu.Unlock()
+		}
+		conn.WriteString("OK")
+		return
+	}
+	s, _ := r.detach(conn, st)
+	t.mu.Lock()
+	tr.sub = s
+	t.mu.Unlock()
+	s.write(func(conn redcon.Conn) { conn.WriteString("OK") })
+	go r.serveSubscriber(st, s)
+}
+
+// overlappingPrefix returns a prefix of prefixes that is a prefix of p, or
+// that p is a prefix of.
+func overlappingPrefix(p string, prefixes []string) (string, bool) {
+	for _, q := range prefixes {
+		if strings.HasPrefix(p, q) || strings.HasPrefix(q, p) {
+			return q, true
+		}
+	}
+	return "", false
+}
+
+// untrack turns CLIENT TRACKING off for st.
+func (r *Redis) untrack(st *connState) {
+	t := r.tracking
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tr := t.clients[st.id]; tr != nil {
+		t.forget(tr)
+		delete(t.clients, st.id)
+	}
+	st.tracking = false
+}
+
+// clientCaching handles CLIENT CACHING YES|NO, which decides whether the
+// keys read by the next command are tracked in OPTIN or OPTOUT mode.
+func (r *Redis) clientCaching(conn redcon.Conn, st *connState, cmd redcon.Command) {
+	t := r.tracking
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tr := t.clients[st.id]
+	if tr == nil || (!tr.optin && !tr.optout) {
+		conn.WriteError("ERR CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled")
+		return
+	}
+	switch strings.ToUpper(string(cmd.Args[2])) {
+	case "YES":
+		if !tr.optin {
+			conn.WriteError("ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.")
+			return
+		}
+		tr.caching, tr.cachingSet = true, true
+	case "NO":
+		if !tr.optout {
+			conn.WriteError("ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+			return
+		}
+		tr.caching, tr.cachingSet = false, true
+	default:
+		conn.WriteError(errSyntax.Error())
+		return
+	}
+	conn.WriteString("OK")
+}
+
+// clientGetRedir handles CLIENT GETREDIR: the ID of the client the
+// invalidations are redirected to, 0 when they are not, and -1 when
+// tracking is off.
+func (r *Redis) clientGetRedir(conn redcon.Conn, st *connState) {
+	t := r.tracking
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tr := t.clients[st.id]
+	if tr == nil {
+		conn.WriteInt(-1)
+		return
+	}
+	conn.WriteInt64(tr.redirect)
+}
+
+// clientTrackingInfo handles CLIENT TRACKINGINFO.
+func (r *Redis) clientTrackingInfo(conn redcon.Conn, st *connState) {
+	t := r.tracking
+	t.mu.Lock()
+	var flags, prefixes []string
+	redirect := int64(-1)
+	if tr := t.clients[st.id]; tr == nil {
+		flags = []string{"off"}
+	} else {
+		flags = []string{"on"}
+		for _, f := range []struct {
+			set  bool
+			name string
+		}{
+			{tr.bcast, "bcast"},
+			{tr.optin, "optin"},
+			{tr.optout, "optout"},
+			{tr.cachingSet && tr.caching, "caching-yes"},
+			{tr.cachingSet && !tr.caching, "caching-no"},
+			{tr.noloop, "noloop"},
+		} {
+			if f.set {
+				flags = append(flags, f.name)
+			}
+		}
+		redirect, prefixes = tr.redirect, slices.Clone(tr.prefixes)
+	}
+	t.mu.Unlock()
+	if redirect > 0 {
+		r.clientsMu.RLock()
+		_, ok := r.clients[redirect]
+		r.clientsMu.RUnlock()
+		if !ok {
+			flags = append(flags, "broken_redirect")
+		}
+	}
+
+	writeMap(conn, 3)
+	conn.WriteBulkString("flags")
+	writeSet(conn, len(flags))
+	for _, f := range flags {
+		conn.WriteBulkString(f)
+	}
+	conn.WriteBulkString("redirect")
+	conn.WriteInt64(redirect)
+	conn.WriteBulkString("prefixes")
+	conn.WriteArray(len(prefixes))
+	for _, p := range prefixes {
+		conn.WriteBulkString(p)
+	}
+}
+
+// trackRead tracks the keys cmd reads, if it is a read-only command, for
+// the connection st with CLIENT TRACKING on. It runs before the command,
+// so that a write applied while it runs is not missed, and consumes the
+// CLIENT CACHING answer.
+func (r *Redis) trackRead(st *connState, cmd redcon.Command) {
+	name := commandOf(cmd)
+	if name == "CLIENT" && len(cmd.Args) > 1 && strings.EqualFold(string(cmd.Args[1]), "CACHING") {
+		return
+	}
+	t := r.tracking
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tr := t.clients[st.id]
+	if tr == nil {
+		return
+	}
+	caching, set := tr.caching, tr.cachingSet
+	tr.cachingSet = false
+
+	c := cmdCategories[name]
+	switch {
+	case tr.bcast || !slices.Contains(c, "read") || slices.Contains(c, "write"):
+		return
+	case tr.optin && !(set && caching):
+		return
+	case tr.optout && set && !caching:
+		return
+	}
+	for _, k := range cmdKeys(name, cmd.Args) {
+		key := string(k)
+		if t.keys[key] == nil {
+			t.keys[key] = map[*tracker]bool{}
+		}
+		t.keys[key][tr] = true
+		tr.keys[key] = true
+	}
+}
+
+// Invalidate tells the clients tracking the keys that they changed. It is
+// the raft.Invalidator of every shard.
+func (r *Redis) Invalidate(keys [][]byte, origin string) {
+	t := r.tracking
+	t.mu.Lock()
+	targets := map[*tracker][][]byte{}
+	skip := func(tr *tracker) bool {
+		return tr.noloop && origin != "" && origin == r.origin(tr.st)
+	}
+	if keys == nil {
+		for _, tr := range t.clients {
+			t.forget(tr)
+			if !skip(tr) {
+				targets[tr] = nil
+			}
+		}
+	}
+	for _, k := range keys {
+		key := string(k)
+		for tr := range t.keys[key] {
+			delete(tr.keys, key)
+			if !skip(tr) {
+				targets[tr] = append(targets[tr], k)
+			}
+		}
+		delete(t.keys, key)
+		// As in Redis, the prefixes match the keys of every database.
+		uk := string(userKey(k))
+		for _, tr := range t.clients {
+			if !tr.bcast || skip(tr) {
+				continue
+			}
+			if len(tr.prefixes) == 0 || slices.ContainsFunc(tr.prefixes, func(p string) bool { return strings.HasPrefix(uk, p) }) {
+				targets[tr] = append(targets[tr], k)
+			}
+		}
+	}
+	type send struct {
+		tr   tracker
+		keys [][]byte
+	}
+	sends := make([]send, 0, len(targets))
+	for tr, ks := range targets {
+		sends = append(sends, send{*tr, ks})
+	}
+	t.mu.Unlock()
+
+	for _, s := range sends {
+		r.sendInvalidation(&s.tr, s.keys)
+	}
+}
+
+// sendInvalidation pushes the invalidation of keys, or of every key when
+// nil, to the connection of tr or to the one it redirects to.
+func (r *Redis) sendInvalidation(tr *tracker, keys [][]byte) {
+	writeKeys := func(conn redcon.Conn) {
+		if keys == nil {
+			conn.WriteNull()
+			return
+		}
+		conn.WriteArray(len(keys))
+		for _, k := range keys {
+			conn.WriteBulk(userKey(k))
+		}
+	}
+
+	if tr.redirect != 0 {
+		s := r.pubsub.subscriber(invalidateChannel, tr.redirect)
+		if s == nil {
+			return
+		}
+		s.write(func(conn redcon.Conn) {
+			writePush(conn, 3)
+			conn.WriteBulkString("message")
+			conn.WriteBulkString(invalidateChannel)
+			writeKeys(conn)
+		})
+		return
+	}
+	// A RESP2 connection can't tell an invalidation from a reply.
+	if tr.sub == nil || !tr.st.resp3.Load() {
+		return
+	}
+	tr.sub.write(func(conn redcon.Conn) {
+		writePush(conn, 2)
+		conn.WriteBulkString("invalidate")
+		writeKeys(conn)
+	})
+}
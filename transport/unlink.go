@@ -0,0 +1,47 @@
+package transport
+
+import (
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/raft"
+)
+
+// del handles DEL key [key ...] and UNLINK key [key ...], which delete the
+// keys in a single entry of the log of their shard and reply with how many
+// existed. The keys are gone once the entry is applied, and the elements of
+// the large values are released in the background. A single key is a Del,
+// which a pipeline can batch with other writes.
+func (r *Redis) del(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) == 2 {
+		res, err := r.apply(conn, &raft.KVCmd{Op: raft.Del, Key: cmd.Args[keyName]})
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		n, _ := res.(int)
+		conn.WriteInt(n)
+		return
+	}
+
+	batch := &raft.KVCmd{Op: raft.Batch}
+	for _, k := range cmd.Args[keyName:] {
+		batch.Cmds = append(batch.Cmds, raft.KVCmd{Op: raft.Del, Key: k})
+	}
+	res, err := r.apply(conn, batch)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	results, _ := res.([]any)
+	n := 0
+	for _, res := range results {
+		if err, ok := res.(error); ok {
+			conn.WriteError(err.Error())
+			return
+		}
+		if res == 1 {
+			n++
+		}
+	}
+	conn.WriteInt(n)
+}
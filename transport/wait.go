@@ -0,0 +1,149 @@
+package transport
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	hraft "github.com/hashicorp/raft"
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/store"
+)
+
+// waitPollInterval is how often WAIT asks the other servers how far they
+// applied the log.
+const waitPollInterval = 10 * time.Millisecond
+
+// raftApplied handles RAFT.APPLIED [shard], which replies with the index of
+// the last entry of the log of the shard this node applied. WAIT asks the
+// other servers with it.
+func (r *Redis) raftApplied(conn redcon.Conn, cmd redcon.Command) {
+	sh := r.shards[0]
+	switch len(cmd.Args) {
+	case 1:
+	case 2:
+		i, err := r.parseShard(cmd.Args[1])
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		sh = r.shards[i]
+	default:
+		conn.WriteError(errSyntax.Error())
+		return
+	}
+	conn.WriteInt64(int64(sh.Raft.AppliedIndex()))
+}
+
+// wait handles WAIT numreplicas timeout. It blocks until numreplicas other
+// servers of the shard of the last write of the connection applied it, or
+// for timeout milliseconds, 0 meaning forever, and replies with the number
+// of servers that did. The write itself is committed, and so stored by a
+// quorum, once it was acknowledged. It stops waiting once the connection is
+// closed.
+func (r *Redis) wait(conn redcon.Conn, cmd redcon.Command) {
+	want, err := strconv.Atoi(string(cmd.Args[1]))
+	if err != nil || want < 0 {
+		conn.WriteError(errNotInteger.Error())
+		return
+	}
+	ms, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+	if err != nil {
+		conn.WriteError(errNotInteger.Error())
+		return
+	}
+	if ms < 0 {
+		conn.WriteError("ERR timeout is negative")
+		return
+	}
+
+	st := stateOf(conn)
+	sh := st.lastWrite
+	if sh == nil {
+		sh = r.shards[0]
+	}
+	if sh.Raft.State() != hraft.Leader {
+		conn.WriteError("ERR WAIT cannot be used with replica instances")
+		return
+	}
+	f := sh.Raft.GetConfiguration()
+	if err := f.Error(); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	var peers []hraft.Server
+	for _, s := range f.Configuration().Servers {
+		if s.ID != r.id {
+			peers = append(peers, s)
+		}
+	}
+	index := st.readIndexOf(sh)
+	if index == 0 {
+		conn.WriteInt(len(peers))
+		return
+	}
+
+	var timeout <-chan time.Time
+	if ms > 0 {
+		timeout = time.After(time.Duration(ms) * time.Millisecond)
+	}
+	conns := map[hraft.ServerID]*forwarder{}
+	defer func() {
+		for _, c := range conns {
+			c.conn.Close()
+		}
+	}()
+	acked := map[hraft.ServerID]bool{}
+	for {
+		for _, s := range peers {
+			if acked[s.ID] {
+				continue
+			}
+			applied, err := r.peerApplied(st, conns, s.ID, sh)
+			if err == nil && applied >= index {
+				acked[s.ID] = true
+			}
+		}
+		if len(acked) >= want || len(acked) == len(peers) {
+			break
+		}
+		select {
+		case <-timeout:
+			conn.WriteInt(len(acked))
+			return
+		case <-st.context().Done():
+			conn.WriteError(store.ErrAborted.Error())
+			return
+		case <-time.After(waitPollInterval):
+		}
+	}
+	conn.WriteInt(len(acked))
+}
+
+// peerApplied asks the server id how far it applied the log of sh, on a
+// connection kept in conns.
+func (r *Redis) peerApplied(st *connState, conns map[hraft.ServerID]*forwarder, id hraft.ServerID, sh *Shard) (uint64, error) {
+	c := conns[id]
+	if c == nil {
+		addr, err := store.GetRedisAddrByNodeID(r.stableStore, id)
+		if err != nil {
+			return 0, err
+		}
+		if c, err = r.dialNode(st, addr, false); err != nil {
+			return 0, err
+		}
+		conns[id] = c
+	}
+
+	reply, err := c.roundTrip([][][]byte{{[]byte("RAFT.APPLIED"), []byte(strconv.Itoa(sh.index))}})
+	if err != nil {
+		c.conn.Close()
+		delete(conns, id)
+		return 0, err
+	}
+	if len(reply) < 3 || reply[0] != ':' {
+		return 0, errors.New("unexpected reply to RAFT.APPLIED: " + strconv.Quote(string(reply)))
+	}
+	return strconv.ParseUint(string(reply[1:len(reply)-2]), 10, 64)
+}
@@ -0,0 +1,246 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/redcon"
+
+	"raft-redis-cluster/raft"
+	"raft-redis-cluster/store"
+)
+
+var errNotFloat = errors.New("ERR value is not a valid float")
+
+// zadd handles ZADD key [NX | XX] [GT | LT] [CH] score member [score member ...].
+func (r *Redis) zadd(conn redcon.Conn, cmd redcon.Command) {
+	kvCmd := &raft.KVCmd{
+		Op:  raft.ZAdd,
+		Key: cmd.Args[keyName],
+	}
+
+	ch := false
+	i := keyName + 1
+loop:
+	for ; i < len(cmd.Args); i++ {
+		switch strings.ToUpper(string(cmd.Args[i])) {
+		case "NX":
+			kvCmd.Cond = raft.CondNX
+		case "XX":
+			kvCmd.Cond = raft.CondXX
+		case "GT":
+			kvCmd.ScoreCond = raft.ScoreGT
+		case "LT":
+			kvCmd.ScoreCond = raft.ScoreLT
+		case "CH":
+			ch = true
+		default:
+			break loop
+		}
+	}
+
+	pairs := cmd.Args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		conn.WriteError(errSyntax.Error())
+		return
+	}
+	if kvCmd.Cond == raft.CondNX && kvCmd.ScoreCond != raft.ScoreAny {
+		conn.WriteError("ERR GT, LT, and/or NX options at the same time are not compatible")
+		return
+	}
+	for j := 0; j < len(pairs); j += 2 {
+		if _, err := parseScore(pairs[j]); err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		kvCmd.Pairs = append(kvCmd.Pairs, raft.KVPair{Key: pairs[j+1], Val: pairs[j]})
+	}
+
+	res, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	zr, _ := res.(raft.ZAddResult)
+	if ch {
+		conn.WriteInt(zr.Changed)
+		return
+	}
+	conn.WriteInt(zr.Added)
+}
+
+// zrem handles ZREM key member [member ...].
+func (r *Redis) zrem(conn redcon.Conn, cmd redcon.Command) {
+	kvCmd := &raft.KVCmd{
+		Op:   raft.ZRem,
+		Key:  cmd.Args[keyName],
+		Args: cmd.Args[member:],
+	}
+	res, err := r.apply(conn, kvCmd)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	n, _ := res.(int)
+	conn.WriteInt(n)
+}
+
+// zscore handles ZSCORE key member.
+func (r *Redis) zscore(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	score, err := r.store.ZScore(ctx, cmd.Args[keyName], cmd.Args[member])
+	if err != nil {
+		if errors.Is(err, store.ErrKeyNotFound) {
+			conn.WriteNull()
+		} else {
+			conn.WriteError(err.Error())
+		}
+		return
+	}
+	writeDouble(conn, score)
+}
+
+// zcard handles ZCARD key.
+func (r *Redis) zcard(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	n, err := r.store.ZCard(ctx, cmd.Args[keyName])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteInt(n)
+}
+
+// zrange handles ZRANGE key start stop [WITHSCORES].
+func (r *Redis) zrange(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	start, err1 := strconv.Atoi(string(cmd.Args[2]))
+	stop, err2 := strconv.Atoi(string(cmd.Args[3]))
+	if err1 != nil || err2 != nil {
+		conn.WriteError(errNotInteger.Error())
+		return
+	}
+
+	withScores := false
+	for _, opt := range cmd.Args[4:] {
+		if strings.ToUpper(string(opt)) != "WITHSCORES" {
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+		withScores = true
+	}
+
+	members, err := r.store.ZRange(ctx, cmd.Args[keyName], start, stop)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	writeZMembers(conn, members, withScores)
+}
+
+// zrangeByScore handles ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count].
+func (r *Redis) zrangeByScore(ctx context.Context, conn redcon.Conn, cmd redcon.Command) {
+	lo, err := parseScoreBound(cmd.Args[2])
+	if err != nil {
+		conn.WriteError("ERR min or max is not a float")
+		return
+	}
+	hi, err := parseScoreBound(cmd.Args[3])
+	if err != nil {
+		conn.WriteError("ERR min or max is not a float")
+		return
+	}
+
+	withScores := false
+	offset, count := 0, -1
+	for i := 4; i < len(cmd.Args); i++ {
+		switch strings.ToUpper(string(cmd.Args[i])) {
+		case "WITHSCORES":
+			withScores = true
+		case "LIMIT":
+			if i+2 >= len(cmd.Args) {
+				conn.WriteError(errSyntax.Error())
+				return
+			}
+			var err1, err2 error
+			offset, err1 = strconv.Atoi(string(cmd.Args[i+1]))
+			count, err2 = strconv.Atoi(string(cmd.Args[i+2]))
+			if err1 != nil || err2 != nil {
+				conn.WriteError(errNotInteger.Error())
+				return
+			}
+			if offset < 0 {
+				conn.WriteArray(0)
+				return
+			}
+			i += 2
+		default:
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+	}
+
+	members, err := r.store.ZRangeByScore(ctx, cmd.Args[keyName], lo, hi, offset, count)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	writeZMembers(conn, members, withScores)
+}
+
+// writeZMembers writes the members of a range. With scores, RESP3 clients
+// get a [member, score] pair per member instead of a flat array.
+func writeZMembers(conn redcon.Conn, members []store.ZMember, withScores bool) {
+	pairs := withScores && isRESP3(conn)
+	switch {
+	case withScores && !pairs:
+		conn.WriteArray(len(members) * 2)
+	default:
+		conn.WriteArray(len(members))
+	}
+	for _, m := range members {
+		if pairs {
+			conn.WriteArray(2)
+		}
+		conn.WriteBulk(m.Member)
+		if withScores {
+			writeDouble(conn, m.Score)
+		}
+	}
+}
+
+// parseScore parses a score, accepting "inf", "+inf" and "-inf" but not NaN.
+func parseScore(b []byte) (float64, error) {
+	f, err := strconv.ParseFloat(string(b), 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, errNotFloat
+	}
+	return f, nil
+}
+
+// parseScoreBound parses a range endpoint such as "1.5", "(1.5" or "-inf".
+func parseScoreBound(b []byte) (store.ScoreBound, error) {
+	bound := store.ScoreBound{}
+	if len(b) > 0 && b[0] == '(' {
+		bound.Exclusive = true
+		b = b[1:]
+	}
+	f, err := parseScore(b)
+	if err != nil {
+		return bound, err
+	}
+	bound.Value = f
+	return bound, nil
+}
+
+// formatScore formats a score the way Redis replies with it.
+func formatScore(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}